@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/align"
+	"github.com/spf13/cobra"
+)
+
+var (
+	alignSkewThreshold time.Duration
+	alignFormat        string
+)
+
+var alignCmd = &cobra.Command{
+	Use:   "align [gfs files...]",
+	Short: "Report how the time ranges of cluster members' archives overlap",
+	Long: `Read archives from the members of a cluster and report the range each one
+covers, the range they all cover, and an estimate of each member's clock
+skew. Skew is estimated from instances created while the members were
+running, such as regions, which every member records at about the same
+moment: each archive's skew is the median of how much earlier or later it
+recorded them than the other archives. Archives skewed by more than
+--skew-threshold are flagged, as queries comparing them with other members
+over short windows cannot be trusted.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if alignFormat != "table" && alignFormat != "json" {
+			return fmt.Errorf("unknown format %q (expected table or json)", alignFormat)
+		}
+
+		files, err := expandPatterns(args)
+		if err != nil {
+			return err
+		}
+
+		var archives []*align.Archive
+		for _, file := range files {
+			archive, err := align.Read(file, file, timeZoneMode)
+			if err != nil {
+				return err
+			}
+			archives = append(archives, archive)
+		}
+
+		report := align.Analyze(archives, alignSkewThreshold)
+		if alignFormat == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(report)
+		}
+		return printAlignReport(report)
+	},
+}
+
+// printAlignReport writes an alignment report as a table followed by the
+// common range
+func printAlignReport(report *align.Report) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tSTART\tEND\tDURATION\tSHARED EVENTS\tSKEW\t")
+	for _, a := range report.Archives {
+		start, end, duration := "-", "-", "-"
+		if !a.Start.IsZero() {
+			start = a.Start.UTC().Format(time.RFC3339)
+			end = a.End.UTC().Format(time.RFC3339)
+			duration = a.End.Sub(a.Start).String()
+		}
+		skew := "unknown"
+		if a.SkewKnown {
+			skew = a.Skew.String()
+			if a.Skew > 0 {
+				skew = "+" + skew
+			}
+		}
+		flag := ""
+		if a.Skewed {
+			flag = "SKEWED"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", a.Node, start, end, duration, a.SharedEvents, skew, flag)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if report.Overlap {
+		fmt.Printf("\nCommon range: %s - %s (%s)\n", report.CommonStart.UTC().Format(time.RFC3339),
+			report.CommonEnd.UTC().Format(time.RFC3339), report.CommonEnd.Sub(report.CommonStart))
+	} else {
+		fmt.Println("\nNo common range: the archives do not all overlap")
+	}
+	if report.Skewed > 0 {
+		fmt.Printf("%d of %d archives appear skewed by more than %s; cross-node queries over short windows may be misleading\n",
+			report.Skewed, len(report.Archives), report.Threshold)
+	}
+	return nil
+}
+
+func init() {
+	alignCmd.Flags().DurationVar(&alignSkewThreshold, "skew-threshold", 2*time.Second, "Flag archives whose estimated clock skew exceeds this")
+	alignCmd.Flags().StringVar(&alignFormat, "format", "table", "Output format: table or json")
+	addTimeZoneFlag(alignCmd)
+	rootCmd.AddCommand(alignCmd)
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/torture"
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkFormat   string
+	checkTorture  bool
+	checkVariants int
+)
+
+// archiveCheck is what was read from one archive
+type archiveCheck struct {
+	File      string `json:"file"`
+	Version   int    `json:"version"`
+	Product   string `json:"product"`
+	Types     int    `json:"types"`
+	Instances int    `json:"instances"`
+	Samples   int    `json:"samples"`
+	Error     string `json:"error,omitempty"`
+	// Archive is the header, if it could be read
+	Archive *gfs.ArchiveInfo `json:"archive,omitempty"`
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check [gfs files...]",
+	Short: "Read GFS files without converting them and report what was found",
+	Long: `Parse each GFS file and report its version, product and how many resource
+types, instances and samples could be read, or why it could not be read.
+Nothing is written to the TSDB.
+
+With --torture, damaged variants of each file are generated (truncated at
+record boundaries, header bits flipped, oversized lengths, duplicated type
+ids and out of range stat offsets) and read in turn, reporting any that
+crash the reader, allocate far more memory than the intact file or read
+inconsistently. Reader logs are discarded unless --verbose is set.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if checkFormat != "table" && checkFormat != "json" {
+			return fmt.Errorf("unknown format %q (expected table or json)", checkFormat)
+		}
+
+		files, err := expandPatterns(args)
+		if err != nil {
+			return err
+		}
+
+		if checkTorture {
+			return runTorture(files)
+		}
+
+		var checks []archiveCheck
+		failed := 0
+		for _, file := range files {
+			check := checkArchive(file)
+			if check.Error != "" {
+				failed++
+			}
+			checks = append(checks, check)
+		}
+
+		if checkFormat == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(checks); err != nil {
+				return err
+			}
+		} else {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "FILE\tVERSION\tPRODUCT\tTYPES\tINSTANCES\tSAMPLES\tSTATUS")
+			for _, c := range checks {
+				status := "ok"
+				if c.Error != "" {
+					status = c.Error
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%d\t%d\t%s\n",
+					c.File, c.Version, c.Product, c.Types, c.Instances, c.Samples, status)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d files could not be read cleanly", failed, len(checks))
+		}
+		return nil
+	},
+}
+
+// checkArchive reads one archive and summarizes it
+func checkArchive(file string) archiveCheck {
+	check := archiveCheck{File: file}
+
+	reader, err := gfs.NewStatArchiveReader(file)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	defer reader.Close()
+
+	if err := reader.ReadArchive(); err != nil {
+		check.Error = err.Error()
+	}
+
+	info := reader.GetArchiveInfo()
+	check.Version = info.Version
+	check.Product = info.ProductDescription
+	if info.Version != 0 {
+		check.Archive = &info
+	}
+	check.Types = len(reader.GetResourceTypes())
+	check.Instances = len(reader.GetInstances())
+	for _, instance := range reader.GetInstances() {
+		for _, values := range instance.Stats {
+			check.Samples += len(values)
+		}
+	}
+	return check
+}
+
+// runTorture reads damaged variants of every file and reports the ones
+// that exposed reader bugs
+func runTorture(files []string) error {
+	var reports []*torture.Report
+	failed := 0
+	for _, file := range files {
+		report, err := torture.Run(file, checkVariants)
+		if err != nil {
+			return fmt.Errorf("failed to torture %s: %w", file, err)
+		}
+		if report.Failed() {
+			failed++
+		}
+		reports = append(reports, report)
+	}
+
+	if checkFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			return err
+		}
+	} else {
+		for _, report := range reports {
+			fmt.Printf("%s (%d types, %d instances, %d samples; allocation limit %d MiB)\n", report.File,
+				report.Baseline.Types, report.Baseline.Instances, report.Baseline.Samples, report.AllocLimit>>20)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "DAMAGE\tVARIANTS\tERRORS\tPANICS\tEXCESSIVE MEMORY\tUNEXPECTED")
+			for _, k := range report.Kinds {
+				fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\n", k.Kind, k.Variants, k.Errors, k.Panics, k.Excessive, k.Unexpected)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			for _, f := range report.Failures {
+				fmt.Printf("  FAIL %s, %s: %s\n", f.Kind, f.Name, failureReason(f))
+			}
+			fmt.Println()
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files have damaged variants the reader did not handle", failed, len(reports))
+	}
+	return nil
+}
+
+// failureReason is the one-line cause of a torture failure
+func failureReason(r torture.Result) string {
+	switch {
+	case r.Panic != "":
+		return "panic: " + strings.SplitN(r.Panic, "\n", 2)[0]
+	case r.Excessive:
+		return fmt.Sprintf("allocated %d MiB", r.Allocated>>20)
+	default:
+		return r.Unexpected
+	}
+}
+
+func init() {
+	checkCmd.Flags().StringVar(&checkFormat, "format", "table", "Output format: table or json")
+	checkCmd.Flags().BoolVar(&checkTorture, "torture", false, "Read damaged variants of each file and report the ones the reader mishandles")
+	checkCmd.Flags().IntVar(&checkVariants, "torture-variants", 50, "Maximum number of damaged variants of each kind per file")
+	rootCmd.AddCommand(checkCmd)
+}
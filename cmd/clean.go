@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/clean"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cleanDryRun      bool
+	cleanTruncateWAL bool
+	cleanYes         bool
+	cleanFormat      string
+	cleanBeforeRun   bool
+)
+
+var cleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Remove artifacts left in the TSDB by crashed runs",
+	Long: `Find what crashed or interrupted runs left in the TSDB directory and remove
+what is certainly orphaned: temporary block, checkpoint and snapshot
+directories, the lock of a watch daemon that is no longer running, and
+unfinished ingest queue and relabel files. Anything of uncertain origin,
+or modified in the last few minutes, is reported and kept. Nothing is
+removed while a watch daemon or another process is using the TSDB.
+
+Every WAL segment is read to check for corruption. A corrupt WAL is only
+truncated with --truncate-wal, after confirmation, and loses every record
+from the corruption onwards.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cleanFormat != "table" && cleanFormat != "json" {
+			return fmt.Errorf("unknown format %q (expected table or json)", cleanFormat)
+		}
+
+		report, err := clean.Run(tsdbPath, clean.Options{DryRun: cleanDryRun, CheckWAL: true})
+		if err != nil {
+			return fmt.Errorf("failed to clean %s: %w", tsdbPath, err)
+		}
+
+		if cleanTruncateWAL && !cleanDryRun {
+			for i, check := range report.WAL {
+				if !check.Corrupt {
+					continue
+				}
+				if !cleanYes && !confirm(fmt.Sprintf("Truncate WAL %s at segment %d offset %d, discarding every later record?", check.Dir, check.Segment, check.Offset)) {
+					continue
+				}
+				if err := report.TruncateWAL(i); err != nil {
+					return err
+				}
+			}
+		}
+
+		if cleanFormat == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(report)
+		}
+		return printCleanReport(report)
+	},
+}
+
+// printCleanReport writes the clean report as tables
+func printCleanReport(report *clean.Report) error {
+	if report.InUse != "" {
+		fmt.Printf("%s is in use (%s); nothing was removed\n\n", report.Path, report.InUse)
+	}
+
+	if len(report.Items) == 0 {
+		fmt.Println("No orphaned artifacts found")
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ACTION\tKIND\tSIZE\tPATH\tREASON")
+		for _, item := range report.Items {
+			action := item.Action
+			if action == clean.ActionRemove && report.DryRun {
+				action = "would remove"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", action, item.Kind, formatSize(item.Bytes), item.Path, item.Reason)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	for _, check := range report.WAL {
+		switch {
+		case check.Truncated:
+			fmt.Printf("WAL %s: truncated at segment %d offset %d, discarded %s\n",
+				check.Dir, check.Segment, check.Offset, formatSize(check.Discarded))
+		case check.Corrupt:
+			fmt.Printf("WAL %s: corrupt at segment %d offset %d (%s); run with --truncate-wal to discard it from there\n",
+				check.Dir, check.Segment, check.Offset, check.Error)
+		default:
+			fmt.Printf("WAL %s: %d segments, %s, OK\n", check.Dir, check.Segments, formatSize(check.Bytes))
+		}
+	}
+
+	if report.DryRun {
+		fmt.Printf("\nWould reclaim %s\n", formatSize(report.Reclaimed))
+	} else {
+		fmt.Printf("\nReclaimed %s\n", formatSize(report.Reclaimed))
+	}
+	return nil
+}
+
+// cleanBeforeConvert removes orphaned artifacts before a conversion opens
+// the TSDB. The WAL is left for the TSDB to check when it opens.
+func cleanBeforeConvert() error {
+	if _, err := os.Stat(tsdbPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	report, err := clean.Run(tsdbPath, clean.Options{})
+	if err != nil {
+		return fmt.Errorf("failed to clean %s: %w", tsdbPath, err)
+	}
+
+	removed := 0
+	for _, item := range report.Items {
+		if item.Action == clean.ActionRemove {
+			removed++
+			logging.Default().Infof("Removed %s (%s)", item.Path, item.Reason)
+		} else {
+			logging.Default().Infof("Kept %s: %s", item.Path, item.Reason)
+		}
+	}
+	if removed > 0 {
+		fmt.Printf("Removed %d orphaned artifacts from %s, reclaiming %s\n", removed, tsdbPath, formatSize(report.Reclaimed))
+	}
+	return nil
+}
+
+// confirm asks a yes/no question on stderr, defaulting to no
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// formatSize formats a byte count for display
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	cleanCmd.Flags().BoolVar(&cleanDryRun, "dry-run", false, "Only report what would be removed")
+	cleanCmd.Flags().BoolVar(&cleanTruncateWAL, "truncate-wal", false, "Truncate a corrupt WAL at the corruption, after confirmation")
+	cleanCmd.Flags().BoolVar(&cleanYes, "yes", false, "Truncate without asking for confirmation")
+	cleanCmd.Flags().StringVar(&cleanFormat, "format", "table", "Output format: table or json")
+	rootCmd.AddCommand(cleanCmd)
+}
@@ -1,21 +1,26 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"path/filepath"
+	"time"
 
+	"github.com/4n3w/gfs-to-prometheus/internal/align"
 	"github.com/4n3w/gfs-to-prometheus/internal/cluster"
 	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/ingest"
 	"github.com/spf13/cobra"
 )
 
 var (
-	clusterName    string
-	nodePatterns   []string
+	clusterName     string
+	nodePatterns    []string
 	excludePatterns []string
-	recursive      bool
-	concurrency    int
+	recursive       bool
+	concurrency     int
+	alignReport     bool
 )
 
 var clusterCmd = &cobra.Command{
@@ -23,39 +28,147 @@ var clusterCmd = &cobra.Command{
 	Short: "Process GFS files from entire GemFire cluster",
 	Long: `Process GFS statistics files from multiple nodes in a GemFire cluster.
 Supports flexible file discovery for various deployment patterns including
-Docker Compose, Kubernetes, and traditional deployments.`,
+Docker Compose, Kubernetes, and traditional deployments.
+
+With --dry-run, the files are converted without writing to the TSDB or
+the import history, and the series and samples each metric name would get
+are reported as by convert --dry-run, with --format json as JSON.
+
+The files of each node are converted one after the other, in the order
+their archives start, while nodes are converted concurrently. A file that
+starts before the last sample of the node's previous file is warned about
+as overlapping.
+
+With --start and --end, or --last, only the samples in that time window
+are written, as by convert.
+
+Once converted, the series, samples and skipped samples of every file are
+listed with their time range and node, then totalled for the cluster and
+for each node. With --summary-json they are also written to a file as
+JSON, keyed by node under "nodes".
+
+With --align-report, the archives are read again once converted to report
+how the nodes' time ranges overlap and which nodes' clocks appear skewed,
+as by the align command.`,
 	Args: cobra.MinimumNArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		conv, err := converter.New(tsdbPath, configFile)
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if err := checkDryRunFlags(); err != nil {
+			return err
+		}
+		if err := parseTimeWindow(); err != nil {
+			return err
+		}
+		if alignReport && dryRunJSON() {
+			return fmt.Errorf("--align-report cannot be used with --dry-run --format json")
+		}
+		if pid, running := ingest.DaemonPID(tsdbPath); running && !dryRun {
+			if err := rejectHandOff(cmd, pid, "node-pattern", "exclude", "recursive"); err != nil {
+				return err
+			}
+			return enqueueClusterFiles(pid, args)
+		}
+
+		// The processor sums the progress of the files it converts
+		var processor *cluster.Processor
+		opts := converterOptions()
+		opts.DryRun = dryRun
+		opts.Window = window
+		opts.ReadProgress = func(filename string, bytesRead, totalBytes int64, samples int) {
+			processor.FileProgress(filename, bytesRead, totalBytes, samples)
+		}
+		conv, err := converter.New(tsdbPath, configFile, opts)
 		if err != nil {
 			return fmt.Errorf("failed to initialize converter: %w", err)
 		}
-		defer conv.Close()
+		defer closeConverter(conv, &err)
+		printTSDBOptions(conv)
 
-		processor, err := cluster.NewProcessor(cluster.Config{
+		progress := newProgressLine()
+		out := dryRunOutput()
+		processor, err = cluster.NewProcessor(cluster.Config{
 			ClusterName:     clusterName,
 			NodePatterns:    nodePatterns,
 			ExcludePatterns: excludePatterns,
 			Recursive:       recursive,
 			Concurrency:     concurrency,
 			Converter:       conv,
+			Progress: func(p cluster.Progress) {
+				if !dryRunJSON() {
+					progress.update(p.Fraction(), fmt.Sprintf("%d/%d files", p.Done, p.Files), "")
+				}
+			},
 		})
 		if err != nil {
 			return fmt.Errorf("failed to create cluster processor: %w", err)
 		}
 
+		defer conv.EmitRunCompleted()
+		defer saveSummary(conv, processor.NodeName, &err)
+		defer interruptOnSignal(conv)()
+
+		// A directory with failed files leaves the run failing, but the
+		// others are still converted unless the error policy is abort
+		var failed []error
 		for _, dir := range args {
-			fmt.Printf("Processing cluster directory: %s\n", dir)
-			if err := processor.ProcessDirectory(dir); err != nil {
-				return fmt.Errorf("failed to process directory %s: %w", dir, err)
+			fmt.Fprintf(out, "Processing cluster directory: %s\n", dir)
+			err := processor.ProcessDirectory(dir)
+			progress.clear()
+			if err != nil {
+				failed = append(failed, fmt.Errorf("failed to process directory %s: %w", dir, err))
+				if conv.Aborts(err) {
+					break
+				}
 			}
 		}
 
-		fmt.Println("Cluster processing complete!")
+		if dryRun {
+			if len(failed) == 0 {
+				fmt.Fprintln(out, "Cluster processing complete!")
+			}
+			if err := printDryRun(conv); err != nil {
+				return err
+			}
+		} else {
+			if err := printSummary(out, conv.Report(processor.NodeName)); err != nil {
+				return err
+			}
+			if len(failed) == 0 {
+				fmt.Fprintln(out, "Cluster processing complete!")
+			}
+		}
+		if len(failed) > 0 {
+			return errors.Join(failed...)
+		}
+
+		if alignReport {
+			return printClusterAlignment(processor, args)
+		}
 		return nil
 	},
 }
 
+// printClusterAlignment prints the alignment report of the archives
+// discovered in dirs, labeled by node
+func printClusterAlignment(processor *cluster.Processor, dirs []string) error {
+	var archives []*align.Archive
+	for _, dir := range dirs {
+		nodes, err := processor.DiscoverFiles(dir)
+		if err != nil {
+			return fmt.Errorf("failed to discover files in %s: %w", dir, err)
+		}
+		for _, node := range nodes {
+			archive, err := align.Read(node.FilePath, node.Name, timeZoneMode)
+			if err != nil {
+				return err
+			}
+			archives = append(archives, archive)
+		}
+	}
+
+	fmt.Println("\nArchive alignment:")
+	return printAlignReport(align.Analyze(archives, alignSkewThreshold))
+}
+
 var clusterWatchCmd = &cobra.Command{
 	Use:   "cluster-watch [directories...]",
 	Short: "Watch directories for new GFS files from cluster nodes",
@@ -63,11 +176,26 @@ var clusterWatchCmd = &cobra.Command{
 multiple cluster nodes. Supports the same flexible patterns as cluster command.`,
 	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		conv, err := converter.New(tsdbPath, configFile)
+		if err := rejectBackfill(cmd); err != nil {
+			return err
+		}
+		absTsdbPath, err := filepath.Abs(tsdbPath)
+		if err != nil {
+			return fmt.Errorf("invalid TSDB path %s: %w", tsdbPath, err)
+		}
+
+		lock, err := ingest.AcquireLock(absTsdbPath)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+
+		conv, err := converter.New(tsdbPath, configFile, converterOptions())
 		if err != nil {
 			return fmt.Errorf("failed to initialize converter: %w", err)
 		}
 		defer conv.Close()
+		printTSDBOptions(conv)
 
 		processor, err := cluster.NewProcessor(cluster.Config{
 			ClusterName:     clusterName,
@@ -87,6 +215,10 @@ multiple cluster nodes. Supports the same flexible patterns as cluster command.`
 		}
 		defer watcher.Close()
 
+		if err := watcher.WatchIngestQueue(absTsdbPath); err != nil {
+			return fmt.Errorf("failed to watch ingest queue: %w", err)
+		}
+
 		for _, dir := range args {
 			absDir, err := filepath.Abs(dir)
 			if err != nil {
@@ -103,36 +235,75 @@ multiple cluster nodes. Supports the same flexible patterns as cluster command.`
 	},
 }
 
+// enqueueClusterFiles discovers the cluster's files and hands them to the
+// running watch daemon, which derives node labels from the paths itself
+func enqueueClusterFiles(pid int, dirs []string) error {
+	processor, err := cluster.NewProcessor(cluster.Config{
+		ClusterName:     clusterName,
+		NodePatterns:    nodePatterns,
+		ExcludePatterns: excludePatterns,
+		Recursive:       recursive,
+		Concurrency:     concurrency,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create cluster processor: %w", err)
+	}
+
+	var files []string
+	for _, dir := range dirs {
+		nodes, err := processor.DiscoverFiles(dir)
+		if err != nil {
+			return fmt.Errorf("failed to discover files in %s: %w", dir, err)
+		}
+		for _, node := range nodes {
+			files = append(files, node.FilePath)
+		}
+	}
+
+	if err := ingest.Enqueue(tsdbPath, files); err != nil {
+		return fmt.Errorf("failed to queue files for watch daemon: %w", err)
+	}
+	fmt.Printf("Watch daemon (pid %d) owns %s; queued %d files for it to convert\n", pid, tsdbPath, len(files))
+	return nil
+}
+
 func init() {
 	// Common flags for both cluster commands
 	for _, cmd := range []*cobra.Command{clusterCmd, clusterWatchCmd} {
+		addConverterFlags(cmd)
 		cmd.Flags().StringVar(&clusterName, "cluster-name", "gemfire", "Name of the cluster for labeling")
 		cmd.Flags().StringSliceVar(&nodePatterns, "node-pattern", []string{
 			// Docker Compose patterns
-			"*/stats/*-stats.gfs",           // compose/server-1/stats/server-1-stats.gfs
-			"*/*/*-stats.gfs",               // volumes/server-1/data/server-1-stats.gfs
-			"*/data/*-stats.gfs",            // server-1/data/server-1-stats.gfs
-			
-			// Traditional patterns  
-			"*/stats/*.gfs",                 // server-1/stats/statistics.gfs
-			"*/*-stats.gfs",                 // server-1/server-1-stats.gfs
-			
+			"*/stats/*-stats.gfs", // compose/server-1/stats/server-1-stats.gfs
+			"*/*/*-stats.gfs",     // volumes/server-1/data/server-1-stats.gfs
+			"*/data/*-stats.gfs",  // server-1/data/server-1-stats.gfs
+
+			// Traditional patterns
+			"*/stats/*.gfs", // server-1/stats/statistics.gfs
+			"*/*-stats.gfs", // server-1/server-1-stats.gfs
+
 			// Kubernetes patterns
 			"*/persistent-data/*-stats.gfs", // server-1/persistent-data/server-1-stats.gfs
 			"*/logs/*-stats.gfs",            // server-1/logs/server-1-stats.gfs
 		}, "Patterns for finding node stats files (supports glob)")
-		
+
 		cmd.Flags().StringSliceVar(&excludePatterns, "exclude", []string{
 			"*/tmp/*",
-			"*/temp/*", 
+			"*/temp/*",
 			"*/.git/*",
 			"*/node_modules/*",
 		}, "Patterns to exclude from search")
-		
+
 		cmd.Flags().BoolVar(&recursive, "recursive", true, "Search directories recursively")
-		cmd.Flags().IntVar(&concurrency, "concurrency", 4, "Number of files to process concurrently")
+		cmd.Flags().IntVar(&concurrency, "concurrency", 4, "Number of nodes whose files are processed concurrently")
 	}
 
+	addDryRunFlags(clusterCmd)
+	addWindowFlags(clusterCmd)
+	addSummaryFlags(clusterCmd)
+	clusterCmd.Flags().BoolVar(&alignReport, "align-report", false, "Report how the nodes' archives overlap and which clocks appear skewed")
+	clusterCmd.Flags().DurationVar(&alignSkewThreshold, "skew-threshold", 2*time.Second, "Flag nodes whose estimated clock skew exceeds this, with --align-report")
+
 	rootCmd.AddCommand(clusterCmd)
 	rootCmd.AddCommand(clusterWatchCmd)
-}
\ No newline at end of file
+}
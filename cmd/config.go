@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/config"
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	requireMatches    bool
+	configTestCluster string
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with converter config files",
+}
+
+var configTestCmd = &cobra.Command{
+	Use:   "test [gfs files...]",
+	Short: "Report what each config rule matches in sample archives",
+	Long: `Parse the given archives and run every resource type, instance and stat
+through the filters, value corrections and metric mappings of --config, layered
+over --profile and with the types of --preset included if given, without
+writing to the TSDB. For each rule, report how many resource types, stats and instances it
+matched and flag the rules that matched nothing, then the metric prefix each file
+is given and the prefix rule that chose it. Prefix rules on the cluster match
+the name given with --cluster.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if configFile == "" && profile == "" && len(presets) == 0 {
+			return fmt.Errorf("--config, --profile or --preset is required")
+		}
+
+		cfg, err := config.LoadLayered(profile, configFile)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		if err := cfg.ApplyPresets(presets); err != nil {
+			return err
+		}
+		if err := cfg.AddInstanceFilters(includeInstances, excludeInstances); err != nil {
+			return err
+		}
+
+		files, err := expandPatterns(args)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no files match %v", args)
+		}
+
+		matcher := config.NewMatcher(cfg)
+		for _, file := range files {
+			if err := matchArchive(cfg, matcher, file); err != nil {
+				return err
+			}
+		}
+
+		results := matcher.Results()
+		unmatched := 0
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tRULE\tTYPES\tSTATS\tINSTANCES\t")
+		for _, result := range results {
+			flag := ""
+			if result.Types == 0 {
+				flag = "NO MATCHES"
+				unmatched++
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n",
+				result.Kind, result.Rule, result.Types, result.Stats, result.Instances, flag)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FILE\tPREFIX\tPREFIX RULE")
+		for _, result := range matcher.PrefixResults() {
+			rule := result.Rule
+			if rule == "" {
+				rule = "(metric_prefix)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", result.File, result.Prefix, rule)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Printf("\n%d rules, %d with no matches in %d files\n", len(results), unmatched, len(files))
+		if requireMatches && unmatched > 0 {
+			return fmt.Errorf("%d config rules matched nothing", unmatched)
+		}
+		return nil
+	},
+}
+
+// matchArchive parses a GFS file and runs every instance and stat with data
+// through the matcher, under the metric names cfg gives the stats
+func matchArchive(cfg *config.Config, matcher *config.Matcher, file string) error {
+	reader, err := gfs.NewStatArchiveReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", file, err)
+	}
+	defer reader.Close()
+
+	if err := reader.ReadArchive(); err != nil {
+		if gfs.IsUnreadable(err) {
+			return fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %s parsed with errors: %v\n", file, err)
+	}
+
+	product := reader.GetArchiveInfo().ProductDescription
+	prefix := matcher.Prefix(file, configTestCluster, product)
+
+	types := reader.GetResourceTypes()
+	for _, instance := range gfs.SortedInstances(reader.GetInstances()) {
+		resType, ok := types[instance.TypeID]
+		if !ok {
+			continue
+		}
+		if !matcher.IncludeType(resType.Name, instance.Name) || !matcher.IncludeInstance(resType.Name, instance.Name) {
+			continue
+		}
+
+		for _, statID := range gfs.SortedStatIDs(instance.Stats) {
+			if int(statID) >= len(resType.Stats) || len(instance.Stats[statID]) == 0 {
+				continue
+			}
+			stat := &resType.Stats[statID]
+			statName := stat.Name
+			if !matcher.IncludeStat(resType.Name, instance.Name, statName) {
+				continue
+			}
+			metricName, err := converter.StatMetricName(cfg, prefix, resType.Name, stat)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			matcher.Mapping(resType.Name, instance.Name, statName, metricName)
+			matcher.Correction(resType.Name, instance.Name, statName, metricName, product)
+		}
+	}
+	return nil
+}
+
+func init() {
+	configTestCmd.Flags().BoolVar(&requireMatches, "require-matches", false, "Exit non-zero if any rule matched nothing")
+	configTestCmd.Flags().StringVar(&configTestCluster, "cluster", "", "Cluster name the files are matched as by prefix rules")
+	addConfigFlags(configTestCmd)
+	configCmd.AddCommand(configTestCmd)
+	rootCmd.AddCommand(configCmd)
+}
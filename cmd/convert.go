@@ -1,44 +1,420 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"path/filepath"
+	"io"
+	"os"
 
 	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/glob"
+	"github.com/4n3w/gfs-to-prometheus/internal/ingest"
 	"github.com/spf13/cobra"
 )
 
+var (
+	allowEmpty    bool
+	convertStrict bool
+	convertResume bool
+	convertVerify bool
+	metadataOut   string
+)
+
 var convertCmd = &cobra.Command{
 	Use:   "convert [gfs files...]",
 	Short: "Convert GFS files to Prometheus TSDB",
-	Long:  `Process one or more GFS files and write their metrics to Prometheus TSDB.`,
-	Args:  cobra.MinimumNArgs(1),
+	Long: `Process one or more GFS files and write their metrics to Prometheus TSDB.
+
+If a watch daemon is already running against the same TSDB path, the files
+are handed to the daemon's ingest queue instead and converted by it, with
+its own flags and config; other flags than --tsdb-path and the logging flags
+are then refused.
+
+Quote patterns to have them expanded here rather than by the shell; a "**"
+segment matches any number of directories, as in 'node-*/**/*.gfs'. The
+number of files each pattern matched is printed before processing begins.
+A pattern that matches nothing is an error unless --allow-empty is given.
+The files are converted in the order their archives start, read from
+their headers, so rolled archives are written oldest first whatever their
+names; a file that starts before the last sample of the one before it is
+warned about as overlapping.
+
+While a file is read its progress is shown, as a bar when stdout is a
+terminal. After each file a parse report shows how much of it was read:
+records, samples and bytes, where a truncated archive ends and how many
+corrupt records were skipped. Partly read archives are still converted;
+with --strict the command then exits non-zero.
+
+With --verify, each archive's consistency is also checked while it is
+read, as by the validate command, and the verdict is printed after its
+parse report. Archives that fail are still converted, but the command
+then exits non-zero.
+
+Progress is checkpointed in the TSDB after every commit. If a run is
+interrupted, run it again with --resume and the same files and config:
+files it completed are skipped and the file it was converting continues
+after the samples it had committed, so nothing is written twice. Ctrl+C
+stops a run cleanly, committing the samples of the file being converted
+that were decoded so far; press it twice to exit at once.
+
+Give "-" as the only argument to convert a single archive, gzipped or not,
+read from stdin, as in 'kubectl exec server-1 -- cat stats.gfs | convert -'.
+
+With --metadata-out, the HELP and TYPE of every metric written are saved
+to a file once the files are converted, from the descriptions and counter
+flags of the stats: as the JSON the Prometheus metadata API returns, or as
+"# HELP" and "# TYPE" lines if the file name ends with .txt or .prom.
+
+With --start and --end, only the samples from the start to the end, both
+included, are written; the others are counted as outside the window in
+the file's summary. Each is an RFC3339 time or a duration before the last
+sample of each archive, such as -2h. --last 6h writes the last six hours
+of each archive, as --start -6h does. A window that leaves a file with
+samples but none to write fails it. The summary lists the window each
+file was limited to, resolved against its last sample.
+
+Once the files are converted, a summary lists for each the series and
+samples written, the samples skipped as filtered out by the config, as
+duplicates, as invalid or as outside the time window, its parse warnings
+and the time range written, then the totals. With --summary-json the
+summary is also written to a file as JSON, even if the run fails, for
+automation to check.
+
+With --dry-run, the files are read and converted as usual but nothing is
+written to the TSDB or the import history. The series and samples each
+metric name would get are listed instead, with the totals, the time range
+and an estimate of the size on disk, checked against --max-series if
+set; --format json prints them as JSON and nothing else on stdout.
+
+With --clean-before-run, artifacts left in the TSDB by crashed runs are
+removed first, as by the clean command; the WAL is not truncated.
+
+With --mimir-url and --tenant, the TSDB's blocks are then uploaded to
+Grafana Mimir as by the upload command, backfilling the tenant without
+remote write. The accepted block IDs are printed.`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		conv, err := converter.New(tsdbPath, configFile)
+		if err := checkDryRunFlags(); err != nil {
+			return err
+		}
+		if err := parseTimeWindow(); err != nil {
+			return err
+		}
+		if dryRun {
+			return dryRunConvert(args)
+		}
+
+		uploader, err := mimirUploader()
+		if err != nil {
+			return err
+		}
+		if uploader != nil {
+			if pid, running := ingest.DaemonPID(tsdbPath); running {
+				return fmt.Errorf("watch daemon (pid %d) owns %s; stop it before uploading blocks to Mimir", pid, tsdbPath)
+			}
+		}
+
+		if err := convertArgs(cmd, args); err != nil {
+			return err
+		}
+		if uploader == nil {
+			return nil
+		}
+		return uploadBlocks(uploader)
+	},
+}
+
+// convertArgs converts the files or stdin archive given on the command line
+func convertArgs(cmd *cobra.Command, args []string) (err error) {
+	if len(args) == 1 && args[0] == "-" {
+		if convertResume {
+			return fmt.Errorf("--resume cannot be used with an archive read from stdin")
+		}
+		return convertStdin()
+	}
+	for _, arg := range args {
+		if arg == "-" {
+			return fmt.Errorf("- reads an archive from stdin and cannot be combined with other files")
+		}
+	}
+
+	files, err := resolveConvertPatterns(args, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	if pid, running := ingest.DaemonPID(tsdbPath); running {
+		if err := rejectHandOff(cmd, pid); err != nil {
+			return err
+		}
+		if err := ingest.Enqueue(tsdbPath, files); err != nil {
+			return fmt.Errorf("failed to queue files for watch daemon: %w", err)
+		}
+		fmt.Printf("Watch daemon (pid %d) owns %s; queued %d files for it to convert\n", pid, tsdbPath, len(files))
+		return nil
+	}
+
+	if cleanBeforeRun {
+		if err := cleanBeforeConvert(); err != nil {
+			return err
+		}
+	}
+
+	progress := newProgressLine()
+	conv, err := converter.New(tsdbPath, configFile, convertOptions(progress))
+	if err != nil {
+		return fmt.Errorf("failed to initialize converter: %w", err)
+	}
+	defer closeConverter(conv, &err)
+	printTSDBOptions(conv)
+
+	defer conv.EmitRunCompleted()
+	defer saveSummary(conv, nil, &err)
+	defer interruptOnSignal(conv)()
+
+	batch, err := conv.StartBatch(tsdbPath, convertResume)
+	if err != nil {
+		return err
+	}
+
+	files = conv.OrderByStart(files)
+	unclean, unverified, skipped := 0, 0, 0
+	var aborted error
+	for i, file := range files {
+		switch state, committed := batch.State(file); state {
+		case converter.FileCompleted:
+			fmt.Printf("Skipping %s: converted before the interruption\n", file)
+		case converter.FilePartial:
+			fmt.Printf("Resuming %s after %d committed samples...\n", file, committed)
+		default:
+			fmt.Printf("Processing %s...\n", file)
+		}
+		if i > 0 {
+			conv.WarnOverlap(files[i-1], file)
+		}
+		report, _, err := batch.ConvertFile(file)
+		progress.clear()
+		if errors.Is(err, converter.ErrInterrupted) {
+			return fmt.Errorf("failed to convert %s: %w", file, err)
+		}
 		if err != nil {
-			return fmt.Errorf("failed to initialize converter: %w", err)
+			skipped++
+			if conv.Aborts(err) {
+				aborted = fmt.Errorf("failed to convert %s: %w", file, err)
+				break
+			}
+			fmt.Printf("Skipping %s: %v\n", file, err)
+			continue
+		}
+		if report != nil {
+			printParseReport(report)
+			if !report.Clean() {
+				unclean++
+			}
+			if v := report.Verification; v != nil && !v.OK {
+				unverified++
+			}
+		}
+	}
+	// The checkpoint of a run that skipped files is kept, so --resume
+	// converts only them
+	if skipped == 0 {
+		if err := batch.Finish(); err != nil {
+			return err
+		}
+	}
+	if metadataOut != "" {
+		if err := conv.WriteMetadata(metadataOut); err != nil {
+			return err
+		}
+	}
+	if err := printSummary(os.Stdout, conv.Report(nil)); err != nil {
+		return err
+	}
+	if aborted != nil {
+		return aborted
+	}
+
+	fmt.Println("Conversion complete!")
+	if convertResume {
+		fmt.Printf("Resumed run: %d files skipped as already converted, %d resumed, %d converted from the start\n",
+			batch.Skipped, batch.Resumed, batch.Fresh)
+	}
+	if skipped > 0 {
+		return fmt.Errorf("%d of %d files were skipped", skipped, len(files))
+	}
+	if convertStrict && unclean > 0 {
+		return fmt.Errorf("%d of %d files were not read cleanly", unclean, len(files))
+	}
+	if unverified > 0 {
+		return fmt.Errorf("%d of %d files failed verification", unverified, len(files))
+	}
+	return nil
+}
+
+// convertOptions are the converter options of the convert command, which
+// shows the progress of reading each file on progress
+func convertOptions(progress *progressLine) converter.Options {
+	opts := converterOptions()
+	opts.Verify = convertVerify
+	opts.Window = window
+	opts.ReadProgress = progress.readProgress
+	return opts
+}
+
+// convertStdin converts the single archive read from stdin by "convert -"
+func convertStdin() (err error) {
+	if pid, running := ingest.DaemonPID(tsdbPath); running {
+		return fmt.Errorf("watch daemon (pid %d) owns %s and cannot be handed an archive read from stdin", pid, tsdbPath)
+	}
+
+	if cleanBeforeRun {
+		if err := cleanBeforeConvert(); err != nil {
+			return err
+		}
+	}
+
+	progress := newProgressLine()
+	conv, err := converter.New(tsdbPath, configFile, convertOptions(progress))
+	if err != nil {
+		return fmt.Errorf("failed to initialize converter: %w", err)
+	}
+	defer closeConverter(conv, &err)
+	printTSDBOptions(conv)
+
+	defer conv.EmitRunCompleted()
+	defer saveSummary(conv, nil, &err)
+	defer interruptOnSignal(conv)()
+
+	fmt.Println("Processing stdin...")
+	report, err := conv.ConvertReader(converter.StdinName, os.Stdin)
+	progress.clear()
+	if err != nil {
+		return fmt.Errorf("failed to convert stdin: %w", err)
+	}
+	printParseReport(report)
+	if metadataOut != "" {
+		if err := conv.WriteMetadata(metadataOut); err != nil {
+			return err
 		}
-		defer conv.Close()
+	}
+	if err := printSummary(os.Stdout, conv.Report(nil)); err != nil {
+		return err
+	}
+
+	fmt.Println("Conversion complete!")
+	if convertStrict && !report.Clean() {
+		return fmt.Errorf("the archive was not read cleanly")
+	}
+	if v := report.Verification; v != nil && !v.OK {
+		return fmt.Errorf("the archive failed verification")
+	}
+	return nil
+}
 
-		for _, pattern := range args {
-			files, err := filepath.Glob(pattern)
-			if err != nil {
-				return fmt.Errorf("invalid file pattern %s: %w", pattern, err)
+// printParseReport prints the parse report of a converted file, with the
+// warnings it kept
+func printParseReport(report *gfs.ParseReport) {
+	fmt.Printf("  %s\n", report)
+	for _, w := range report.Warnings {
+		fmt.Printf("    %s\n", w)
+	}
+	if more := report.WarningCount - len(report.Warnings); more > 0 {
+		fmt.Printf("    ... and %d more\n", more)
+	}
+	if v := report.Verification; v != nil {
+		fmt.Printf("  %s\n", v)
+		for _, check := range v.Failed() {
+			if check.Name == gfs.CheckCleanRead {
+				// Already listed as warnings
+				continue
 			}
+			for _, example := range check.Examples {
+				fmt.Printf("    %s: %s\n", check.Name, example)
+			}
+		}
+	}
+}
+
+// patternMatches holds the files one command line pattern matched
+type patternMatches struct {
+	pattern string
+	files   []string
+}
+
+// matchPatterns expands each glob pattern given on the command line,
+// failing on the first invalid one
+func matchPatterns(patterns []string) ([]patternMatches, error) {
+	results := make([]patternMatches, 0, len(patterns))
+	for _, pattern := range patterns {
+		matches, err := glob.Expand(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file pattern %s: %w", pattern, err)
+		}
+		results = append(results, patternMatches{pattern: pattern, files: matches})
+	}
+	return results, nil
+}
 
-			for _, file := range files {
-				fmt.Printf("Processing %s...\n", file)
-				if err := conv.ConvertFile(file); err != nil {
-					return fmt.Errorf("failed to convert %s: %w", file, err)
-				}
+// expandPatterns resolves the glob patterns given on the command line into
+// a list of files, each listed once even if several patterns match it
+func expandPatterns(patterns []string) ([]string, error) {
+	results, err := matchPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return flattenMatches(results), nil
+}
+
+func flattenMatches(results []patternMatches) []string {
+	var files []string
+	seen := make(map[string]bool)
+	for _, r := range results {
+		for _, file := range r.files {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
 			}
 		}
+	}
+	return files
+}
 
-		fmt.Println("Conversion complete!")
-		return nil
-	},
+// resolveConvertPatterns expands the convert patterns, printing how many
+// files each one matched to out. Patterns that matched nothing are warned
+// about and fail the run unless --allow-empty is set.
+func resolveConvertPatterns(patterns []string, out io.Writer) ([]string, error) {
+	results, err := matchPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	empty := 0
+	for _, r := range results {
+		fmt.Fprintf(out, "%s: %d files\n", r.pattern, len(r.files))
+		if len(r.files) == 0 {
+			empty++
+			fmt.Fprintf(os.Stderr, "Warning: pattern %s matched no files\n", r.pattern)
+		}
+	}
+	if empty > 0 && !allowEmpty {
+		return nil, fmt.Errorf("%d of %d patterns matched no files (use --allow-empty to continue anyway)", empty, len(patterns))
+	}
+	return flattenMatches(results), nil
 }
 
 func init() {
+	convertCmd.Flags().BoolVar(&convertStrict, "strict", false, "Exit non-zero if any archive was truncated or had corrupt records skipped")
+	convertCmd.Flags().BoolVar(&convertVerify, "verify", false, "Check each archive's consistency while converting it and exit non-zero if any fails")
+	convertCmd.Flags().BoolVar(&convertResume, "resume", false, "Continue an interrupted conversion of the same files from its checkpoint")
+	convertCmd.Flags().BoolVar(&allowEmpty, "allow-empty", false, "Continue when a file pattern matches no files")
+	convertCmd.Flags().StringVar(&metadataOut, "metadata-out", "", "Write the HELP and TYPE of every metric written to this file, as JSON or as # HELP and # TYPE lines for a .txt or .prom file")
+	convertCmd.Flags().BoolVar(&cleanBeforeRun, "clean-before-run", false, "Remove artifacts left in the TSDB by crashed runs before converting")
+	addMimirFlags(convertCmd)
+	addConverterFlags(convertCmd)
+	addDryRunFlags(convertCmd)
+	addWindowFlags(convertCmd)
+	addSummaryFlags(convertCmd)
 	rootCmd.AddCommand(convertCmd)
-}
\ No newline at end of file
+}
@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/config"
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/tsdb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	coverageFormat   string
+	coverageShowGaps bool
+)
+
+// metricCoverage is how much of one metric of an archive is in the TSDB
+type metricCoverage struct {
+	Metric         string        `json:"metric"`
+	SeriesExpected int           `json:"series_expected"`
+	SeriesFound    int           `json:"series_found"`
+	SamplesExpect  int           `json:"samples_expected"`
+	SamplesFound   int           `json:"samples_found"`
+	Coverage       float64       `json:"coverage_percent"`
+	Gaps           []coverageGap `json:"gaps,omitempty"`
+}
+
+// coverageGap is a run of consecutive archive samples missing from a
+// series in the TSDB
+type coverageGap struct {
+	Instance string    `json:"instance"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Samples  int       `json:"samples"`
+}
+
+var coverageCmd = &cobra.Command{
+	Use:   "coverage [gfs file]",
+	Short: "Report how completely a GFS file is already in the TSDB",
+	Long: `Parse a GFS file, list the series a conversion would write and look them
+up in the TSDB without modifying it. For each metric, report how many of the
+archive's samples are present and where the missing runs are.
+
+Series are matched as written by the convert command: by the labels that
+identify a stat's instance (resource_type and instance, or job, statType and
+statName with --legacy-labels, or the instance label and extra labels of its
+metric mapping), renamed by the label mappings of the config.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := args[0]
+
+		cfg, err := config.LoadLayered(profile, configFile)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		if err := cfg.ApplyPresets(presets); err != nil {
+			return err
+		}
+		if err := cfg.AddInstanceFilters(includeInstances, excludeInstances); err != nil {
+			return err
+		}
+
+		reader, err := gfs.NewStatArchiveReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", file, err)
+		}
+		defer reader.Close()
+		reader.SetTimeZoneMode(timeZoneMode)
+		if err := reader.ReadArchive(); err != nil {
+			if gfs.IsUnreadable(err) {
+				return fmt.Errorf("failed to parse %s: %w", file, err)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %s parsed with errors: %v\n", file, err)
+		}
+
+		expected, err := converter.ListSeries(reader, file, cfg, legacyLabels)
+		if err != nil {
+			return err
+		}
+		if len(expected) == 0 {
+			return fmt.Errorf("no series found in %s", file)
+		}
+
+		start, end := seriesTimeRange(expected)
+		db, err := tsdb.OpenReader(tsdbPath, start, end)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		results, err := measureCoverage(db, expected)
+		if err != nil {
+			return err
+		}
+
+		return printCoverage(file, start, end, results)
+	},
+}
+
+// hasLabels reports whether labels include every label of want
+func hasLabels(labels, want map[string]string) bool {
+	for name, value := range want {
+		if labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// seriesTimeRange returns the earliest and latest sample time of series
+func seriesTimeRange(series []converter.ArchiveSeries) (time.Time, time.Time) {
+	var start, end time.Time
+	for _, s := range series {
+		for _, ts := range s.Timestamps {
+			if start.IsZero() || ts.Before(start) {
+				start = ts
+			}
+			if ts.After(end) {
+				end = ts
+			}
+		}
+	}
+	return start, end
+}
+
+// measureCoverage looks up every expected series in the TSDB, one query per
+// metric, and compares sample timestamps
+func measureCoverage(db *tsdb.Reader, expected []converter.ArchiveSeries) ([]*metricCoverage, error) {
+	byMetric := make(map[string][]converter.ArchiveSeries)
+	var metrics []string
+	for _, s := range expected {
+		if _, ok := byMetric[s.Metric]; !ok {
+			metrics = append(metrics, s.Metric)
+		}
+		byMetric[s.Metric] = append(byMetric[s.Metric], s)
+	}
+	sort.Strings(metrics)
+
+	var results []*metricCoverage
+	for _, metric := range metrics {
+		stored, err := db.Select(map[string]string{"__name__": metric})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s: %w", metric, err)
+		}
+
+		result := &metricCoverage{Metric: metric}
+		for _, want := range byMetric[metric] {
+			result.SeriesExpected++
+			result.SamplesExpect += len(want.Timestamps)
+
+			have := make(map[int64]bool)
+			found := false
+			for _, s := range stored {
+				if !hasLabels(s.Labels, want.Labels) {
+					continue
+				}
+				found = true
+				for _, ts := range s.Timestamps {
+					have[ts.UnixMilli()] = true
+				}
+			}
+			if found {
+				result.SeriesFound++
+			}
+
+			var gap *coverageGap
+			for _, ts := range want.Timestamps {
+				if have[ts.UnixMilli()] {
+					result.SamplesFound++
+					gap = nil
+					continue
+				}
+				if gap == nil {
+					result.Gaps = append(result.Gaps, coverageGap{Instance: want.Instance, Start: ts})
+					gap = &result.Gaps[len(result.Gaps)-1]
+				}
+				gap.End = ts
+				gap.Samples++
+			}
+		}
+
+		if result.SamplesExpect > 0 {
+			result.Coverage = 100 * float64(result.SamplesFound) / float64(result.SamplesExpect)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func printCoverage(file string, start, end time.Time, results []*metricCoverage) error {
+	if coverageFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	if coverageFormat != "table" {
+		return fmt.Errorf("unknown format %q (expected table or json)", coverageFormat)
+	}
+
+	fmt.Printf("%s: %s - %s\n\n", file, start.Format(time.RFC3339), end.Format(time.RFC3339))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METRIC\tSERIES\tSAMPLES\tCOVERAGE\tGAPS")
+	expected, found := 0, 0
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%d/%d\t%d/%d\t%.1f%%\t%d\n",
+			r.Metric, r.SeriesFound, r.SeriesExpected, r.SamplesFound, r.SamplesExpect, r.Coverage, len(r.Gaps))
+		expected += r.SamplesExpect
+		found += r.SamplesFound
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if coverageShowGaps {
+		for _, r := range results {
+			for _, gap := range r.Gaps {
+				fmt.Printf("  %s (instance %s): %s - %s (%d samples)\n", r.Metric, gap.Instance,
+					gap.Start.Format(time.RFC3339), gap.End.Format(time.RFC3339), gap.Samples)
+			}
+		}
+	}
+
+	total := 0.0
+	if expected > 0 {
+		total = 100 * float64(found) / float64(expected)
+	}
+	fmt.Printf("\nOverall: %d/%d samples (%.1f%%) present\n", found, expected, total)
+	return nil
+}
+
+func init() {
+	coverageCmd.Flags().StringVar(&coverageFormat, "format", "table", "Output format: table or json")
+	coverageCmd.Flags().BoolVar(&coverageShowGaps, "show-gaps", false, "List every missing run of samples")
+	addConfigFlags(coverageCmd)
+	addTimeZoneFlag(coverageCmd)
+	addLegacyLabelsFlag(coverageCmd)
+	rootCmd.AddCommand(coverageCmd)
+}
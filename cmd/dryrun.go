@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/tsdb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dryRun       bool
+	dryRunFormat string
+)
+
+// dryRunReport is what a dry run would have written, with the size it
+// would take in the TSDB estimated as by the estimate command, the
+// instances each instance filter left out and, with --max-series, whether
+// the series would go over the limit
+type dryRunReport struct {
+	*tsdb.Counts
+	Bytes              int64                           `json:"bytes"`
+	InstanceFilters    []converter.InstanceFilterCount `json:"instance_filters,omitempty"`
+	SeriesLimit        int                             `json:"series_limit,omitempty"`
+	ExceedsSeriesLimit bool                            `json:"exceeds_series_limit,omitempty"`
+}
+
+func addDryRunFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Convert without writing to the TSDB and report the series, samples and size that would be written")
+	cmd.Flags().StringVar(&dryRunFormat, "format", "table", "Format of the --dry-run report: table or json")
+}
+
+// checkDryRunFlags rejects an unknown report format, and the flags that
+// only make sense once the TSDB has been written
+func checkDryRunFlags() error {
+	if dryRunFormat != "table" && dryRunFormat != "json" {
+		return fmt.Errorf("unknown format %q (expected table or json)", dryRunFormat)
+	}
+	if !dryRun {
+		if dryRunFormat != "table" {
+			return fmt.Errorf("--format only applies to the --dry-run report")
+		}
+		return nil
+	}
+	if mimirURL != "" {
+		return fmt.Errorf("--mimir-url cannot be used with --dry-run")
+	}
+	if convertResume {
+		return fmt.Errorf("--resume cannot be used with --dry-run")
+	}
+	return nil
+}
+
+// dryRunJSON reports whether the dry run report is printed as JSON, in
+// which case nothing else is printed on stdout
+func dryRunJSON() bool {
+	return dryRun && dryRunFormat == "json"
+}
+
+// dryRunOutput is where convert and cluster print what they are doing:
+// stdout, unless a dry run report is printed there as JSON
+func dryRunOutput() io.Writer {
+	if dryRunJSON() {
+		return io.Discard
+	}
+	return os.Stdout
+}
+
+// dryRunConvert converts the files or stdin archive given to convert
+// --dry-run and prints what would have been written
+func dryRunConvert(args []string) (err error) {
+	out := dryRunOutput()
+	var files []string
+	if len(args) != 1 || args[0] != "-" {
+		if files, err = resolveConvertPatterns(args, out); err != nil {
+			return err
+		}
+	}
+
+	progress := newProgressLine()
+	opts := convertOptions(progress)
+	opts.DryRun = true
+	if dryRunJSON() {
+		opts.ReadProgress = nil
+	}
+	conv, err := converter.New(tsdbPath, configFile, opts)
+	if err != nil {
+		return fmt.Errorf("failed to initialize converter: %w", err)
+	}
+	defer conv.Close()
+
+	defer conv.EmitRunCompleted()
+	defer saveSummary(conv, nil, &err)
+
+	if files == nil {
+		fmt.Fprintln(out, "Processing stdin...")
+		_, err := conv.ConvertReader(converter.StdinName, os.Stdin)
+		progress.clear()
+		if err != nil {
+			return fmt.Errorf("failed to convert stdin: %w", err)
+		}
+	}
+	files = conv.OrderByStart(files)
+	for i, file := range files {
+		fmt.Fprintf(out, "Processing %s...\n", file)
+		if i > 0 {
+			conv.WarnOverlap(files[i-1], file)
+		}
+		_, err := conv.ConvertFile(file)
+		progress.clear()
+		if err != nil {
+			return fmt.Errorf("failed to convert %s: %w", file, err)
+		}
+	}
+	return printDryRun(conv)
+}
+
+// printDryRun prints what a dry run of conv would have written, as a table
+// of the series and samples of each metric name and the totals, checked
+// against the series limit, followed by the instances each instance
+// filter left out, or as JSON
+func printDryRun(conv *converter.Converter) error {
+	counts := conv.GetWriter().Counts()
+	report := dryRunReport{
+		Counts:          counts,
+		Bytes:           estimateBytes(counts.Series, counts.Samples),
+		InstanceFilters: conv.InstanceFilterCounts(),
+		SeriesLimit:     maxSeries,
+	}
+	report.ExceedsSeriesLimit = maxSeries > 0 && counts.Series > maxSeries
+	if dryRunJSON() {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	fmt.Println("\nDry run, nothing was written:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METRIC\tSERIES\tSAMPLES")
+	for _, m := range counts.Metrics {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", m.Name, m.Series, m.Samples)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nTotal: %d series, %d samples, about %s on disk\n", counts.Series, counts.Samples, formatSize(report.Bytes))
+	if counts.Samples > 0 {
+		fmt.Printf("Time range: %s to %s\n", counts.MinTime.UTC().Format(time.RFC3339), counts.MaxTime.UTC().Format(time.RFC3339))
+	}
+	if report.ExceedsSeriesLimit {
+		fmt.Printf("Series limit: %d series would exceed --max-series %d by %d\n", counts.Series, maxSeries, counts.Series-maxSeries)
+	} else if maxSeries > 0 {
+		fmt.Printf("Series limit: %d series within --max-series %d\n", counts.Series, maxSeries)
+	}
+	if len(report.InstanceFilters) == 0 {
+		return nil
+	}
+
+	fmt.Println("\nInstances left out by the instance filters:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILTER\tINSTANCES")
+	for _, f := range report.InstanceFilters {
+		fmt.Fprintf(w, "%s\t%d\n", f.Filter, f.Instances)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/config"
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/spf13/cobra"
+)
+
+// Typical TSDB costs the estimate is based on: compressed samples of
+// gauges and counters that change every sample take a little over a byte,
+// and each series adds index entries, postings and chunk headers
+const (
+	estimateBytesPerSample = 1.5
+	estimateBytesPerSeries = 1024
+)
+
+var (
+	estimateFormat   string
+	estimateFraction float64
+	estimateTop      int
+)
+
+// fileEstimate is what was extrapolated for one archive
+type fileEstimate struct {
+	File string `json:"file"`
+	// Decoded is the percentage of the sample section that was decoded
+	Decoded float64 `json:"decoded_percent"`
+	Series  int     `json:"series"`
+	Samples int64   `json:"samples"`
+	Bytes   int64   `json:"bytes"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// typeEstimate is what one resource type contributes across all archives
+type typeEstimate struct {
+	Type    string  `json:"type"`
+	Series  int     `json:"series"`
+	Samples int64   `json:"samples"`
+	Share   float64 `json:"share_percent"`
+}
+
+// importEstimate is the output of the estimate command
+type importEstimate struct {
+	Files   []fileEstimate `json:"files"`
+	Series  int            `json:"series"`
+	Samples int64          `json:"samples"`
+	Bytes   int64          `json:"bytes"`
+	Types   []typeEstimate `json:"top_types"`
+}
+
+var estimateCmd = &cobra.Command{
+	Use:   "estimate [gfs files...]",
+	Short: "Estimate the series, samples and TSDB size an import would add",
+	Long: `Estimate what converting GFS files would write without converting them. Every
+file's metadata is read, but only about --fraction of its sample records are
+decoded, in small windows spread evenly over the file; the counts seen are
+extrapolated to the whole file. Files small enough to be decoded in a few
+windows are read whole.
+
+The series and samples are those convert would write with the same config:
+filtered types and dropped mappings are left out. Series are counted once
+across files, as convert writes a stat of the same instance to the same
+series. The TSDB is not consulted, so series it already holds are counted
+too. The size on disk assumes typical compression and is a rough guide.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if estimateFormat != "table" && estimateFormat != "json" {
+			return fmt.Errorf("unknown format %q (expected table or json)", estimateFormat)
+		}
+		if estimateFraction <= 0 || estimateFraction > 1 {
+			return fmt.Errorf("--fraction must be more than 0 and at most 1, got %g", estimateFraction)
+		}
+
+		cfg, err := config.LoadLayered(profile, configFile)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		if err := cfg.ApplyPresets(presets); err != nil {
+			return err
+		}
+		if err := cfg.AddInstanceFilters(includeInstances, excludeInstances); err != nil {
+			return err
+		}
+
+		files, err := expandPatterns(args)
+		if err != nil {
+			return err
+		}
+
+		result := importEstimate{}
+		seen := make(map[string]bool)
+		types := make(map[string]*typeEstimate)
+		failed := 0
+		for _, file := range files {
+			estimate, series, err := estimateFile(file, cfg)
+			if err != nil {
+				failed++
+				result.Files = append(result.Files, fileEstimate{File: file, Error: err.Error()})
+				continue
+			}
+
+			fe := fileEstimate{File: file, Decoded: 100 * estimate.Fraction(), Series: len(series)}
+			for _, s := range series {
+				samples := int64(s.Samples + 0.5)
+				fe.Samples += samples
+				t := types[s.Type]
+				if t == nil {
+					t = &typeEstimate{Type: s.Type}
+					types[s.Type] = t
+				}
+				t.Samples += samples
+
+				key := s.Metric + "\x00" + s.Instance
+				if !seen[key] {
+					seen[key] = true
+					t.Series++
+					result.Series++
+				}
+			}
+			fe.Bytes = estimateBytes(fe.Series, fe.Samples)
+			result.Samples += fe.Samples
+			result.Files = append(result.Files, fe)
+		}
+		result.Bytes = estimateBytes(result.Series, result.Samples)
+		result.Types = topTypes(types, result.Samples, estimateTop)
+
+		if err := printEstimate(&result); err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d files could not be estimated", failed, len(files))
+		}
+		return nil
+	},
+}
+
+// estimateFile reads part of an archive and returns the series it would
+// be converted to
+func estimateFile(file string, cfg *config.Config) (*gfs.Estimate, []converter.SeriesEstimate, error) {
+	reader, err := gfs.NewStatArchiveReader(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+	reader.SetTimeZoneMode(timeZoneMode)
+
+	estimate, err := reader.EstimateArchive(estimateFraction)
+	if err != nil {
+		if estimate == nil || gfs.IsUnreadable(err) {
+			return nil, nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %s parsed with errors: %v\n", file, err)
+	}
+	series, err := converter.EstimateSeries(reader, estimate, file, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return estimate, series, nil
+}
+
+// estimateBytes returns the TSDB bytes that series holding samples take
+func estimateBytes(series int, samples int64) int64 {
+	return int64(float64(samples)*estimateBytesPerSample) + int64(series)*estimateBytesPerSeries
+}
+
+// topTypes returns the n types with the most samples, most first
+func topTypes(types map[string]*typeEstimate, total int64, n int) []typeEstimate {
+	var result []typeEstimate
+	for _, t := range types {
+		if total > 0 {
+			t.Share = 100 * float64(t.Samples) / float64(total)
+		}
+		result = append(result, *t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Samples != result[j].Samples {
+			return result[i].Samples > result[j].Samples
+		}
+		return result[i].Type < result[j].Type
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+func printEstimate(result *importEstimate) error {
+	if estimateFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tDECODED\tSERIES\tSAMPLES\tSIZE\tSTATUS")
+	for _, f := range result.Files {
+		if f.Error != "" {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t%s\n", f.File, f.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%.1f%%\t%d\t%d\t%s\tok\n", f.File, f.Decoded, f.Series, f.Samples, formatSize(f.Bytes))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nTotal: %d series, %d samples, about %s on disk\n", result.Series, result.Samples, formatSize(result.Bytes))
+	if len(result.Types) == 0 {
+		return nil
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tSERIES\tSAMPLES\tSHARE")
+	for _, t := range result.Types {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f%%\n", t.Type, t.Series, t.Samples, t.Share)
+	}
+	return w.Flush()
+}
+
+func init() {
+	estimateCmd.Flags().StringVar(&estimateFormat, "format", "table", "Output format: table or json")
+	estimateCmd.Flags().Float64Var(&estimateFraction, "fraction", 0.01, "Fraction of each file's sample records to decode")
+	estimateCmd.Flags().IntVar(&estimateTop, "top", 10, "Number of resource types to list by contribution, 0 for all")
+	addConfigFlags(estimateCmd)
+	addTimeZoneFlag(estimateCmd)
+	rootCmd.AddCommand(estimateCmd)
+}
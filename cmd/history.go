@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/provenance"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyFormat string
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history [file...]",
+	Short: "Show the import history of a TSDB",
+	Long: `List when each GFS file was imported into the TSDB, with its hash, the
+tool version and config used, and the number of samples written. Pass file
+paths to only show their imports.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		records, err := provenance.ReadHistory(tsdbPath)
+		if err != nil {
+			return fmt.Errorf("failed to read import history: %w", err)
+		}
+
+		if len(args) > 0 {
+			wanted := make(map[string]bool)
+			for _, arg := range args {
+				absFile, err := filepath.Abs(arg)
+				if err != nil {
+					return fmt.Errorf("invalid file %s: %w", arg, err)
+				}
+				wanted[absFile] = true
+			}
+
+			var filtered []provenance.Record
+			for _, record := range records {
+				if wanted[record.File] {
+					filtered = append(filtered, record)
+				}
+			}
+			records = filtered
+		}
+
+		switch historyFormat {
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if records == nil {
+				records = []provenance.Record{}
+			}
+			return enc.Encode(records)
+		case "table":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "IMPORTED\tFILE\tSAMPLES\tVERSION\tCONFIG\tSHA256")
+			for _, record := range records {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n",
+					record.ImportedAt.Format(time.RFC3339), record.File, record.SamplesWritten,
+					record.ToolVersion, shortHash(record.ConfigHash), shortHash(record.SHA256))
+			}
+			return w.Flush()
+		default:
+			return fmt.Errorf("unknown format %q (expected table or json)", historyFormat)
+		}
+	},
+}
+
+// shortHash abbreviates a hex digest for table output
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+func init() {
+	historyCmd.Flags().StringVar(&historyFormat, "format", "table", "Output format: table or json")
+	rootCmd.AddCommand(historyCmd)
+}
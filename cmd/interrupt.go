@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+)
+
+// interruptOnSignal stops conv cleanly on the first Ctrl+C or SIGTERM: the
+// file being converted stops once the samples decoded so far are written
+// and committed, so --resume continues after them, and no other file is
+// started. A second signal kills the process as usual. The returned
+// function stops listening.
+func interruptOnSignal(conv *converter.Converter) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			signal.Stop(signals)
+			fmt.Fprintln(os.Stderr, "\nInterrupted: committing what was written, press Ctrl+C again to exit at once")
+			conv.Interrupt()
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	listPresets  bool
+	listProfiles bool
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the built-in presets and profiles",
+	Long: `List what can be selected with --preset, with the resource types each one
+includes, or with --profile.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !listPresets && !listProfiles {
+			return fmt.Errorf("--presets or --profiles is required")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		if listPresets {
+			fmt.Fprintln(w, "PRESET\tDESCRIPTION\tRESOURCE TYPES")
+			for _, p := range config.Presets() {
+				types := "(all)"
+				if p.ResourceTypes != nil {
+					types = strings.Join(p.ResourceTypes, ", ")
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", p.Name, p.Description, types)
+			}
+		}
+		if listProfiles {
+			if listPresets {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintln(w, "PROFILE")
+			for _, name := range config.Profiles() {
+				fmt.Fprintln(w, name)
+			}
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	listCmd.Flags().BoolVar(&listPresets, "presets", false, "List the presets and the resource types they include")
+	listCmd.Flags().BoolVar(&listProfiles, "profiles", false, "List the config profiles")
+	rootCmd.AddCommand(listCmd)
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/plot"
+	"github.com/spf13/cobra"
+)
+
+var (
+	plotType     string
+	plotStats    []string
+	plotInstance string
+	plotStart    string
+	plotEnd      string
+	plotWidth    int
+	plotHeight   int
+)
+
+var plotCmd = &cobra.Command{
+	Use:   "plot [gfs file]",
+	Short: "Draw a chart of statistics from a GFS file in the terminal",
+	Long: `Read a GFS file and draw the values of one or more statistics of a resource
+type as a text chart, with the value range, the time axis and the minimum,
+maximum and average of each series. Nothing is written to the TSDB.
+
+Repeat --stat to overlay several statistics; each series is drawn with its
+own glyph. Without --instance, every instance of the type is plotted.
+--start and --end (RFC3339) limit the chart to part of the archive.`,
+	Example: `  gfs-to-prometheus plot stats.gfs --type StatSampler --stat delayDuration --instance statSampler`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := args[0]
+
+		start, err := parsePlotTime("start", plotStart)
+		if err != nil {
+			return err
+		}
+		end, err := parsePlotTime("end", plotEnd)
+		if err != nil {
+			return err
+		}
+
+		reader, err := gfs.NewStatArchiveReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", file, err)
+		}
+		defer reader.Close()
+		reader.SetTimeZoneMode(timeZoneMode)
+		if err := reader.ReadArchive(); err != nil {
+			if gfs.IsUnreadable(err) {
+				return fmt.Errorf("failed to parse %s: %w", file, err)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %s parsed with errors: %v\n", file, err)
+		}
+
+		series, err := selectPlotSeries(reader, start, end)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s: %s\n\n", file, plotType)
+		return plot.Render(os.Stdout, series, plotWidth, plotHeight)
+	},
+}
+
+// selectPlotSeries returns the samples of the selected stats of every
+// selected instance between start and end. A zero start or end leaves that
+// side open.
+func selectPlotSeries(reader *gfs.StatArchiveReader, start, end time.Time) ([]plot.Series, error) {
+	var resType *gfs.ResourceType
+	for _, t := range reader.GetResourceTypes() {
+		if t.Name == plotType {
+			resType = t
+			break
+		}
+	}
+	if resType == nil {
+		return nil, fmt.Errorf("resource type %q not found", plotType)
+	}
+
+	statIndexes := make([]int32, len(plotStats))
+	for i, name := range plotStats {
+		statIndexes[i] = -1
+		for j, stat := range resType.Stats {
+			if stat.Name == name {
+				statIndexes[i] = int32(j)
+				break
+			}
+		}
+		if statIndexes[i] < 0 {
+			return nil, fmt.Errorf("resource type %s has no stat %q", plotType, name)
+		}
+	}
+
+	var series []plot.Series
+	for _, instance := range gfs.SortedInstances(reader.GetInstances()) {
+		if instance.TypeID != resType.ID || (plotInstance != "" && instance.Name != plotInstance) {
+			continue
+		}
+		for i, index := range statIndexes {
+			s := plot.Series{Name: plotStats[i]}
+			if plotInstance == "" {
+				s.Name = instance.Name + " " + plotStats[i]
+			}
+			for _, sample := range instance.Stats[index] {
+				t := sample.Time()
+				if (!start.IsZero() && t.Before(start)) || (!end.IsZero() && t.After(end)) {
+					continue
+				}
+				s.Times = append(s.Times, t)
+				s.Values = append(s.Values, sample.Value)
+			}
+			if len(s.Times) > 0 {
+				series = append(series, s)
+			}
+		}
+	}
+
+	if len(series) == 0 {
+		if plotInstance != "" {
+			return nil, fmt.Errorf("no samples for instance %q of %s in the selected range", plotInstance, plotType)
+		}
+		return nil, fmt.Errorf("no samples for %s in the selected range", plotType)
+	}
+	if len(series) > len(plot.Glyphs) {
+		return nil, fmt.Errorf("selection has %d series, at most %d can be plotted; use --instance to narrow it", len(series), len(plot.Glyphs))
+	}
+	return series, nil
+}
+
+// parsePlotTime parses the value of a --start or --end flag
+func parsePlotTime(flag, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --%s %q (expected RFC3339, e.g. 2024-01-02T15:04:05Z): %w", flag, value, err)
+	}
+	return t, nil
+}
+
+func init() {
+	plotCmd.Flags().StringVar(&plotType, "type", "", "Resource type to plot (required)")
+	plotCmd.Flags().StringArrayVar(&plotStats, "stat", nil, "Stat to plot; repeat to overlay several (required)")
+	plotCmd.Flags().StringVar(&plotInstance, "instance", "", "Instance to plot (default: every instance of the type)")
+	plotCmd.Flags().StringVar(&plotStart, "start", "", "Plot only samples at or after this time (RFC3339)")
+	plotCmd.Flags().StringVar(&plotEnd, "end", "", "Plot only samples at or before this time (RFC3339)")
+	plotCmd.Flags().IntVar(&plotWidth, "width", 72, "Chart width in columns")
+	plotCmd.Flags().IntVar(&plotHeight, "height", 15, "Chart height in rows")
+	plotCmd.MarkFlagRequired("type")
+	plotCmd.MarkFlagRequired("stat")
+	addTimeZoneFlag(plotCmd)
+	rootCmd.AddCommand(plotCmd)
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// progressBarWidth is the number of cells of a progress bar
+const progressBarWidth = 30
+
+// progressLine shows the progress of a conversion on stdout: a bar redrawn
+// in place when stdout is a terminal, otherwise a plain line every tenth
+// of the way so logs of batch runs stay short
+type progressLine struct {
+	mu    sync.Mutex
+	tty   bool
+	shown bool   // A bar is drawn on the current line
+	step  int    // The last tenth printed as a plain line
+	stage string // The stage printed with it
+}
+
+func newProgressLine() *progressLine {
+	return &progressLine{tty: isTerminal(os.Stdout), step: -1}
+}
+
+// update shows the stage reached, such as the number of files done, the
+// details of the current one and the fraction done, or no fraction if it
+// is negative because the total is not known. A plain line is printed
+// when the stage changes too.
+func (p *progressLine) update(fraction float64, stage, detail string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	text := detail
+	if stage != "" && detail != "" {
+		text = stage + ", " + detail
+	} else if stage != "" {
+		text = stage
+	}
+	percent := int(fraction * 100)
+
+	if !p.tty {
+		step := percent / 10
+		if fraction < 0 || (step == p.step && stage == p.stage) {
+			return
+		}
+		p.step, p.stage = step, stage
+		fmt.Printf("  %s, %d%%\n", text, percent)
+		return
+	}
+	if fraction < 0 {
+		fmt.Printf("\r\033[K  %s", text)
+	} else {
+		filled := int(fraction * progressBarWidth)
+		bar := strings.Repeat("#", filled) + strings.Repeat(".", progressBarWidth-filled)
+		fmt.Printf("\r\033[K  [%s] %s, %d%%", bar, text, percent)
+	}
+	p.shown = true
+}
+
+// clear removes the bar before other output and starts over for the next
+// file
+func (p *progressLine) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.shown {
+		fmt.Print("\r\033[K")
+		p.shown = false
+	}
+	p.step, p.stage = -1, ""
+}
+
+// readProgress shows the progress of reading an archive
+func (p *progressLine) readProgress(filename string, bytesRead, totalBytes int64, samples int) {
+	if totalBytes <= 0 {
+		p.update(-1, "", fmt.Sprintf("%s read, %d samples", formatSize(bytesRead), samples))
+		return
+	}
+	fraction := min(float64(bytesRead)/float64(totalBytes), 1)
+	p.update(fraction, "", fmt.Sprintf("%s of %s read, %d samples", formatSize(bytesRead), formatSize(totalBytes), samples))
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
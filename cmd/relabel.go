@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/ingest"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+	"github.com/4n3w/gfs-to-prometheus/internal/relabel"
+	"github.com/4n3w/gfs-to-prometheus/internal/tsdb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	relabelRules  string
+	relabelDryRun bool
+)
+
+var relabelCmd = &cobra.Command{
+	Use:   "relabel",
+	Short: "Rename and relabel series already in the TSDB",
+	Long: `Rewrite every block of the TSDB, applying Prometheus relabel_configs from
+--rules to each series. Data still in the head/WAL is first persisted as
+blocks so it is relabeled too.
+
+Each block is replaced by a relabeled copy that is moved into place in one
+step, and progress is checkpointed after every block: an interrupted run
+is resumed by running the same command again. Use --dry-run to count the
+affected series without changing anything.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if relabelRules == "" {
+			return fmt.Errorf("--rules is required")
+		}
+		if pid, running := ingest.DaemonPID(tsdbPath); running {
+			return fmt.Errorf("watch daemon (pid %d) is using %s; stop it before relabeling", pid, tsdbPath)
+		}
+
+		rules, err := relabel.LoadRules(relabelRules)
+		if err != nil {
+			return fmt.Errorf("failed to load rules: %w", err)
+		}
+
+		relabeler := relabel.New(tsdbPath, rules)
+
+		var result *relabel.Result
+		if relabelDryRun {
+			result, err = relabeler.DryRun()
+		} else {
+			result, err = relabelBlocks(relabeler)
+		}
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FROM\tTO\tSERIES")
+		for _, change := range result.Changes {
+			to := change.To
+			if to == "" {
+				to = "(dropped)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\n", change.From, to, change.Series)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if relabelDryRun {
+			fmt.Printf("\nWould relabel %d of %d series (%d dropped)\n",
+				result.SeriesRelabeled, result.SeriesTotal, result.SeriesDropped)
+			return nil
+		}
+
+		fmt.Printf("\nRelabeled %d of %d series (%d dropped) in %d blocks",
+			result.SeriesRelabeled, result.SeriesTotal, result.SeriesDropped, result.Blocks)
+		if result.BlocksSkipped > 0 {
+			fmt.Printf(", %d blocks already done", result.BlocksSkipped)
+		}
+		fmt.Println()
+		if result.SamplesMerged > 0 {
+			fmt.Printf("%d samples dropped where relabeled series collided at the same timestamp\n", result.SamplesMerged)
+		}
+		return nil
+	},
+}
+
+// relabelBlocks persists head data as blocks so it is included, then
+// rewrites every block
+func relabelBlocks(relabeler *relabel.Relabeler) (*relabel.Result, error) {
+	logging.Default().Infof("Persisting head data in %s as blocks", tsdbPath)
+	options, err := tsdbOptions()
+	if err != nil {
+		return nil, err
+	}
+	writer, err := tsdb.NewWriter(tsdbPath, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := writer.FlushHead(); err != nil {
+		writer.Close()
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return relabeler.Run(func(block string, done, total int) {
+		logging.Default().Infof("Relabeling block %s (%d/%d)", block, done+1, total)
+	})
+}
+
+func init() {
+	relabelCmd.Flags().StringVar(&relabelRules, "rules", "", "YAML file with Prometheus relabel_configs")
+	relabelCmd.Flags().BoolVar(&relabelDryRun, "dry-run", false, "Only count the series that would change")
+	addProfileFlag(relabelCmd)
+	addTSDBFlags(relabelCmd)
+	rootCmd.AddCommand(relabelCmd)
+}
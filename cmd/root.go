@@ -1,28 +1,403 @@
 package cmd
 
 import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/config"
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+	"github.com/4n3w/gfs-to-prometheus/internal/tsdb"
+	"github.com/4n3w/gfs-to-prometheus/pkg/events"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	tsdbPath           string
+	configFile         string
+	verbose            bool
+	enrichmentFile     string
+	gapThreshold       time.Duration
+	emitGapMetrics     bool
+	emitInstanceCounts bool
+	emitImportInfo     bool
+	logFile            string
+	logMaxSize         int
+	logMaxFiles        int
+	maxWriteRate       float64
+	maxIORate          float64
+	eventsOut          string
+	descriptorPolicy   string
+	timeZoneMode       string
+	sampleErrors       string
+	timeJumps          string
+	onError            string
+	maxSeries          int
+	onCardinality      string
+	maxFuture          time.Duration
+	futureSamples      string
+	remapToNow         bool
+	remapEnd           string
+	adjustResets       bool
+	dedupMode          string
+	downsample         time.Duration
+	rateWindow         time.Duration
+	alignInterval      time.Duration
+	alignMaxGap        time.Duration
+	profile            string
+	presets            []string
+	includeInstances   []string
+	excludeInstances   []string
+	streamThreshold    int64
+	pipelineBuffer     int
+	lowMemory          bool
+	legacyParser       bool
+	parser             string
+	legacyLabels       bool
+	paddingThreshold   int
+	commitBatch        int
+	tsdbRetention      time.Duration
+	tsdbOOOWindow      time.Duration
+	tsdbMinBlock       time.Duration
+	tsdbMaxBlock       time.Duration
+	backfill           bool
 )
 
 var (
-	tsdbPath   string
-	configFile string
-	verbose    bool
+	logOutput   *logging.RotatingFile
+	eventStream *events.Writer
+	// remapEndTime is parsed from --remap-end by parseRemapEnd
+	remapEndTime time.Time
 )
 
+// version is set at build time with -ldflags "-X .../cmd.version=..."
+var version = "dev"
+
 var rootCmd = &cobra.Command{
 	Use:   "gfs-to-prometheus",
 	Short: "Convert GemFire statistics files to Prometheus TSDB",
 	Long: `A tool to parse GemFire/Geode statistics files (.gfs) and write
 the metrics directly to a Prometheus TSDB for historical analysis.`,
+	Version: version,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := setupLogging(); err != nil {
+			return err
+		}
+		if err := parseRemapEnd(); err != nil {
+			return err
+		}
+		return setupEvents()
+	},
 }
 
 func Execute() error {
-	return rootCmd.Execute()
+	err := rootCmd.Execute()
+	eventStream.Close()
+	if logOutput != nil {
+		logOutput.Close()
+	}
+	return err
+}
+
+// setupLogging sets the default log level, warnings only unless --verbose
+// is set, and redirects the standard logger to a size-rotated file when
+// --log-file is set; otherwise logs go to stderr
+func setupLogging() error {
+	level := logging.LevelWarn
+	if verbose {
+		level = logging.LevelDebug
+	}
+	logging.SetDefault(logging.New(level))
+
+	if logFile == "" {
+		return nil
+	}
+
+	out, err := logging.OpenRotatingFile(logFile, logMaxSize, logMaxFiles)
+	if err != nil {
+		return err
+	}
+	logOutput = out
+	log.SetOutput(out)
+	return nil
+}
+
+// setupEvents opens the JSON event stream when --events-out is set
+func setupEvents() error {
+	if eventsOut == "" {
+		return nil
+	}
+
+	stream, err := events.Open(eventsOut)
+	if err != nil {
+		return err
+	}
+	eventStream = stream
+	return nil
+}
+
+// parseRemapEnd parses --remap-end into remapEndTime
+func parseRemapEnd() error {
+	if remapEnd == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, remapEnd)
+	if err != nil {
+		return fmt.Errorf("invalid --remap-end %q (expected RFC3339, e.g. 2024-01-02T15:04:05Z)", remapEnd)
+	}
+	remapEndTime = t
+	return nil
+}
+
+// tsdbFlagOptions collects the --tsdb-* flags; those not given are zero
+func tsdbFlagOptions() tsdb.Options {
+	return tsdb.Options{
+		Retention:        tsdbRetention,
+		OutOfOrderWindow: tsdbOOOWindow,
+		MinBlockDuration: tsdbMinBlock,
+		MaxBlockDuration: tsdbMaxBlock,
+	}
+}
+
+// tsdbOptions returns the options of the --tsdb-* flags, those not given
+// taken from the config's tsdb section, for the commands that open the
+// TSDB without a converter
+func tsdbOptions() (tsdb.Options, error) {
+	cfg, err := config.LoadLayered(profile, configFile)
+	if err != nil {
+		return tsdb.Options{}, fmt.Errorf("failed to load config: %w", err)
+	}
+	return tsdbFlagOptions().Or(tsdb.Options(cfg.TSDB)), nil
+}
+
+// printTSDBOptions prints the options the converter opened the TSDB with,
+// unless it opened none for a dry run
+func printTSDBOptions(conv *converter.Converter) {
+	if options, ok := conv.TSDBOptions(); ok {
+		fmt.Printf("TSDB %s: %s\n", tsdbPath, options)
+	}
+}
+
+// closeConverter closes the converter, returning its error through err
+// unless something failed before, and prints the blocks a backfill wrote
+// and what the TSDB holds then
+func closeConverter(conv *converter.Converter, err *error) {
+	// The head can only be queried before the TSDB is closed, and the
+	// blocks of a backfill only once they are written
+	var stats *tsdb.Stats
+	var statsErr error
+	if !backfill {
+		stats, statsErr = conv.TSDBStats()
+	}
+	closeErr := conv.Close()
+	if *err == nil && closeErr != nil {
+		*err = fmt.Errorf("failed to close converter: %w", closeErr)
+	}
+	for _, id := range conv.BackfillBlocks() {
+		fmt.Printf("Wrote block %s\n", id)
+	}
+	if backfill && closeErr == nil {
+		stats, statsErr = conv.TSDBStats()
+	}
+	if statsErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to read TSDB stats: %v\n", statsErr)
+	} else if stats != nil {
+		fmt.Printf("\nTSDB %s now holds:\n", tsdbPath)
+		if printErr := printTSDBStats(os.Stdout, stats); *err == nil {
+			*err = printErr
+		}
+	}
+}
+
+// rejectBackfill fails a command that keeps running, which a backfill
+// would never write the blocks of
+func rejectBackfill(cmd *cobra.Command) error {
+	if backfill {
+		return fmt.Errorf("--backfill writes its blocks once the run ends, so it cannot be used with %s", cmd.Name())
+	}
+	return nil
+}
+
+// rejectHandOff fails a command about to hand its files to the watch daemon
+// with pid if flags other than those naming the TSDB and the log were set
+// on it, except honoured ones it uses itself to find the files: the daemon
+// converts them with its own flags and config, so the others would be
+// dropped
+func rejectHandOff(cmd *cobra.Command, pid int, honoured ...string) error {
+	honoured = append(honoured, "tsdb-path", "verbose", "log-file", "log-max-size", "log-max-files")
+	var dropped []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if !slices.Contains(honoured, f.Name) {
+			dropped = append(dropped, "--"+f.Name)
+		}
+	})
+	if len(dropped) > 0 {
+		return fmt.Errorf("watch daemon (pid %d) owns %s and converts the files handed to it with its own flags and config, so %s cannot be used; stop it to convert with them",
+			pid, tsdbPath, strings.Join(dropped, ", "))
+	}
+	return nil
+}
+
+// paddingThresholdOption maps --padding-threshold to the converter
+// option, where zero selects the default
+func paddingThresholdOption() int {
+	if paddingThreshold == 0 {
+		return -1
+	}
+	return paddingThreshold
+}
+
+// commitBatchOption maps --commit-batch to the converter option, where
+// zero selects the default
+func commitBatchOption() int {
+	if commitBatch == 0 {
+		return -1
+	}
+	return commitBatch
+}
+
+// converterOptions collects the flags shared by every converting command
+func converterOptions() converter.Options {
+	return converter.Options{
+		EnrichmentFile:     enrichmentFile,
+		GapThreshold:       gapThreshold,
+		EmitGapMetrics:     emitGapMetrics,
+		EmitInstanceCounts: emitInstanceCounts,
+		ToolVersion:        version,
+		EmitImportInfo:     emitImportInfo,
+		MaxWriteRate:       maxWriteRate,
+		MaxIORate:          maxIORate,
+		Events:             eventStream,
+		Logger:             logging.Default(),
+
+		DescriptorConflicts: descriptorPolicy,
+		TimeZoneMode:        timeZoneMode,
+		SampleErrors:        sampleErrors,
+		TimeJumps:           timeJumps,
+		OnError:             onError,
+		AdjustCounterResets: adjustResets,
+		Dedup:               dedupMode,
+		Downsample:          downsample,
+		RateWindow:          rateWindow,
+		Align:               alignInterval,
+		AlignMaxGap:         alignMaxGap,
+		Profile:             profile,
+		Presets:             presets,
+		IncludeInstances:    includeInstances,
+		ExcludeInstances:    excludeInstances,
+		StreamThreshold:     streamThreshold * 1024 * 1024,
+		PipelineBuffer:      pipelineBuffer,
+		LowMemory:           lowMemory,
+		LegacyParser:        legacyParser,
+		Parser:              parser,
+		LegacyLabels:        legacyLabels,
+		PaddingThreshold:    paddingThresholdOption(),
+
+		MaxSeries:             maxSeries,
+		OnCardinalityExceeded: onCardinality,
+		MaxFuture:             maxFuture,
+		FutureSamples:         futureSamples,
+		RemapToNow:            remapToNow,
+		RemapEnd:              remapEndTime,
+		CommitBatch:           commitBatchOption(),
+		TSDB:                  tsdbFlagOptions(),
+		Backfill:              backfill,
+	}
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&tsdbPath, "tsdb-path", "./data", "Path to Prometheus TSDB directory")
 	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file for metric mappings (optional)")
-	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose logging")
-}
\ No newline at end of file
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Log every file, record and recoverable parse problem instead of warnings only")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Write logs to this file instead of stderr, rotating it by size")
+	rootCmd.PersistentFlags().IntVar(&logMaxSize, "log-max-size", 100, "Rotate the log file once it reaches this many megabytes")
+	rootCmd.PersistentFlags().StringVar(&eventsOut, "events-out", "", "Write newline-delimited JSON events to a file, fd:N or - for stdout")
+	rootCmd.PersistentFlags().IntVar(&logMaxFiles, "log-max-files", 5, "Number of log files to keep, including the active one")
+}
+
+// addConverterFlags adds the flags of the options converterOptions passes
+// to the converter, for the commands that convert
+func addConverterFlags(cmd *cobra.Command) {
+	addConfigFlags(cmd)
+	addTimeZoneFlag(cmd)
+	addSampleErrorsFlag(cmd)
+	addLegacyLabelsFlag(cmd)
+	addTSDBFlags(cmd)
+	cmd.Flags().DurationVar(&gapThreshold, "gap-threshold", time.Minute, "Report intervals between samples longer than this as sampling gaps (0 disables)")
+	cmd.Flags().BoolVar(&emitInstanceCounts, "emit-instance-counts", false, "Write the number of live instances of each resource type as a <prefix>_instance_count series, derived from instance create and delete records")
+	cmd.Flags().BoolVar(&emitGapMetrics, "emit-gap-metrics", false, "Write a <prefix>_sampling_gap_seconds sample at the start of each sampling gap and a <prefix>_sampling_disabled_seconds sample for each interval with sampling disabled")
+	cmd.Flags().BoolVar(&emitImportInfo, "emit-import-info", false, "Write a <prefix>_import_info series recording the provenance of each converted file and a <prefix>_archive_timezone_offset_seconds sample with its timezone")
+	cmd.Flags().Float64Var(&maxWriteRate, "max-write-rate", 0, "Maximum samples written to the TSDB per second (0 = unlimited)")
+	cmd.Flags().Float64Var(&maxIORate, "max-io-rate", 0, "Maximum megabytes read from GFS files per second (0 = unlimited)")
+	cmd.Flags().StringVar(&descriptorPolicy, "descriptor-conflicts", converter.ConflictSuffix, "How to handle stats whose unit or counter flag changes between files: suffix, normalize or fail")
+	cmd.Flags().StringVar(&timeJumps, "time-jumps", gfs.TimeJumpsDrop, "How to handle samples recorded after a member's clock went back: drop them, clamp them to just after the latest sample or keep them out of order")
+	cmd.Flags().IntVar(&maxSeries, "max-series", 0, "Write at most this many distinct series in the run (0 for no limit); a dry run reports whether the limit would be exceeded")
+	cmd.Flags().StringVar(&onCardinality, "on-cardinality-exceeded", tsdb.SeriesLimitAbort, "What a series beyond --max-series does: abort (end the run) or drop (skip its samples, counting them)")
+	cmd.Flags().DurationVar(&maxFuture, "max-future", 0, "Handle samples dated more than this past the wall clock, such as 1h, with --future-samples (0 writes them as they are)")
+	cmd.Flags().StringVar(&futureSamples, "future-samples", converter.FutureDrop, "What happens to samples past --max-future: drop them, clamp the first of each series to now or keep them, counting them either way")
+	cmd.Flags().BoolVar(&remapToNow, "remap-to-now", false, "Move every sample of each archive by the same offset so its last sample lands now, or at --remap-end, labeling its series remapped=\"true\"; watch keeps the offset of a file as it grows")
+	cmd.Flags().StringVar(&remapEnd, "remap-end", "", "With --remap-to-now, the RFC3339 time the last sample of each archive lands at instead of now")
+	cmd.Flags().StringVar(&onError, "on-error", converter.OnErrorContinue, "What a failed sample write or file does: continue (quarantine the sample into failed-samples.jsonl, or skip the file), skip-file (end the file at its first failed write) or abort (end the run); the exit code is non-zero if a file was skipped")
+	cmd.Flags().DurationVar(&downsample, "downsample", 0, "Keep one sample per interval of this length in every series: the last of a gauge, the largest of a counter, plus the first and last samples (0 keeps every sample)")
+	cmd.Flags().DurationVar(&alignInterval, "align", 0, "Resample every series onto a grid of this interval: the latest value of a gauge and the interpolated value of a counter at each grid point between its first and last samples (0 writes the samples as they are)")
+	cmd.Flags().DurationVar(&alignMaxGap, "align-max-gap", time.Minute, "With --align, leave the grid points in a gap between two samples longer than this empty (0 fills every gap)")
+	cmd.Flags().DurationVar(&rateWindow, "rate-window", 0, "Also write a <metric>:rate<window> series of every counter's per-second rate over a trailing window of this length, approximated from the archive's samples (0 disables)")
+	cmd.Flags().StringVar(&dedupMode, "dedup", tsdb.DedupRun, "Which samples to skip as already written: run (those another file of the run wrote), tsdb (also those the TSDB already held) or off")
+	cmd.Flags().BoolVar(&adjustResets, "adjust-counter-resets", false, "Keep counter series monotonic by carrying their value over when a counter goes back within an archive")
+	cmd.Flags().BoolVar(&legacyParser, "legacy-parser", false, "Convert with the old GeodeParser, which reads no stat descriptors, instead of the archive reader (deprecated)")
+	cmd.Flags().StringVar(&parser, "parser", converter.ParserGo, "What reads GFS files: go, java (the Java extractor, which needs java) or auto (go, then java for files in which go found no samples)")
+	cmd.Flags().BoolVar(&backfill, "backfill", false, "Write new TSDB blocks directly, one per --tsdb-min-block range, instead of appending through the head and WAL; far less disk for historical imports, but samples are held in memory until the run ends and it cannot be resumed")
+	cmd.Flags().IntVar(&commitBatch, "commit-batch", tsdb.DefaultCommitBatch, "Commit the samples appended to the TSDB every this many samples, keeping those already committed if a file fails (0 commits once per file)")
+	cmd.Flags().IntVar(&paddingThreshold, "padding-threshold", gfs.DefaultPaddingThreshold, "Ignore a run of at least this many zero bytes ending a GFS file as padding added when it was copied (0 disables)")
+	cmd.Flags().Int64Var(&streamThreshold, "stream-threshold", 256, "Convert GFS files larger than this many megabytes while reading them, keeping only their metadata in memory (0 disables)")
+	cmd.Flags().IntVar(&pipelineBuffer, "pipeline-buffer", 65536, "Write the samples of streamed and --low-memory files to the TSDB on a goroutine of their own, buffering up to this many decoded samples (0 writes each as it is decoded)")
+	cmd.Flags().BoolVar(&lowMemory, "low-memory", false, "Decode the samples of every GFS file into a temporary file and write them from there, keeping only its metadata in memory")
+	cmd.Flags().StringVar(&enrichmentFile, "enrichment-file", "", "YAML file of join rules that add labels to matching instances (optional)")
+}
+
+// addProfileFlag adds --profile, the built-in config layered under --config
+func addProfileFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&profile, "profile", "", "Built-in config layered under --config ("+strings.Join(config.Profiles(), ", ")+")")
+}
+
+// addConfigFlags adds --profile and the flags that select what of an
+// archive is converted on top of the config
+func addConfigFlags(cmd *cobra.Command) {
+	addProfileFlag(cmd)
+	cmd.Flags().StringSliceVar(&presets, "preset", nil, "Only convert the resource types of these presets ("+strings.Join(config.PresetNames(), ", ")+"); see list --presets")
+	cmd.Flags().StringSliceVar(&includeInstances, "include-instance", nil, "Only convert the instances whose text id matches one of these glob patterns, added to the config's include_instances")
+	cmd.Flags().StringSliceVar(&excludeInstances, "exclude-instance", nil, "Skip the instances whose text id matches one of these glob patterns, added to the config's exclude_instances")
+}
+
+// addTimeZoneFlag adds --timezone-mode, for the commands that read the
+// timestamps of archives
+func addTimeZoneFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&timeZoneMode, "timezone-mode", gfs.TimeZoneRaw, "How to adjust timestamps for the archive's timezone: raw (trust the epoch millis), apply (add the offset) or strip (subtract it)")
+}
+
+// addSampleErrorsFlag adds --sample-errors, for the commands that decode
+// the samples of archives
+func addSampleErrorsFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&sampleErrors, "sample-errors", gfs.SampleErrorsLenient, "How to handle damaged sample data: lenient (skip it and keep reading) or strict (stop the file at the first damaged record)")
+}
+
+// addLegacyLabelsFlag adds --legacy-labels, for the commands that name the
+// series of archives
+func addLegacyLabelsFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&legacyLabels, "legacy-labels", false, "Label the series of convert with job, statType and statName instead of resource_type and instance (deprecated, removed in the next release)")
+}
+
+// addTSDBFlags adds the --tsdb-* flags, for the commands that open the
+// TSDB
+func addTSDBFlags(cmd *cobra.Command) {
+	cmd.Flags().DurationVar(&tsdbRetention, "tsdb-retention", 0, "Keep TSDB blocks for this long back from the newest sample (default 8760h, or the config's tsdb.retention)")
+	cmd.Flags().DurationVar(&tsdbOOOWindow, "tsdb-ooo-window", 0, "Accept samples up to this far behind the newest sample in the TSDB; raise it to import archives older than that (default 720h, or the config's tsdb.out_of_order_window)")
+	cmd.Flags().DurationVar(&tsdbMinBlock, "tsdb-min-block", 0, "Shortest time range of a TSDB block (default 2h, or the config's tsdb.min_block_duration)")
+	cmd.Flags().DurationVar(&tsdbMaxBlock, "tsdb-max-block", 0, "Longest time range of a TSDB block (default 24h, or the config's tsdb.max_block_duration)")
+}
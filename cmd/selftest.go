@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/selftest"
+	"github.com/spf13/cobra"
+)
+
+var (
+	selftestTypes     int
+	selftestInstances int
+	selftestSamples   int
+	selftestKeep      bool
+	selftestFormat    string
+)
+
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Validate the installation by converting a synthetic archive",
+	Long: `Write a synthetic statistics archive covering every encoding the converter
+reads, read it back and compare every value with what was written, convert
+it into a scratch TSDB and query every series back, in memory and through a
+--low-memory spill file. When java is available, the archive is converted
+with the Java extractor as well and both parsers must write the same
+series. Each step is reported as PASS or FAIL, and the command fails if any
+step did. What each feature makes of an archive is covered by go test.
+
+Everything is written to a temporary directory, which is removed afterwards
+unless --keep is given. The TSDB given with --tsdb-path is not touched.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if selftestFormat != "table" && selftestFormat != "json" {
+			return fmt.Errorf("unknown format %q (expected table or json)", selftestFormat)
+		}
+
+		dir, err := os.MkdirTemp("", "gfs-selftest-")
+		if err != nil {
+			return err
+		}
+		if !selftestKeep {
+			defer os.RemoveAll(dir)
+		}
+
+		report, err := selftest.Run(dir, selftest.Options{
+			Types:     selftestTypes,
+			Instances: selftestInstances,
+			Samples:   selftestSamples,
+		})
+		if err != nil {
+			return err
+		}
+
+		if selftestFormat == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+		} else if err := printSelftestReport(report); err != nil {
+			return err
+		}
+
+		if report.Failed() {
+			return fmt.Errorf("self test failed")
+		}
+		return nil
+	},
+}
+
+// printSelftestReport writes the outcome of each self test step as a table
+func printSelftestReport(report *selftest.Report) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tRESULT\tDETAIL")
+	for _, step := range report.Steps {
+		result := "PASS"
+		if !step.OK {
+			result = "FAIL"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", step.Name, result, step.Detail)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if selftestKeep {
+		fmt.Printf("\nKept the archive %s and the TSDB %s\n", report.Archive, report.TSDB)
+	}
+	return nil
+}
+
+func init() {
+	selftestCmd.Flags().IntVar(&selftestTypes, "types", 3, "Number of synthetic resource types")
+	selftestCmd.Flags().IntVar(&selftestInstances, "instances", 4, "Number of instances of each type")
+	selftestCmd.Flags().IntVar(&selftestSamples, "samples", 120, "Number of samples, one second apart")
+	selftestCmd.Flags().BoolVar(&selftestKeep, "keep", false, "Keep the synthetic archive and TSDB")
+	selftestCmd.Flags().StringVar(&selftestFormat, "format", "table", "Output format: table or json")
+	rootCmd.AddCommand(selftestCmd)
+}
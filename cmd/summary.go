@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/tsdb"
+	"github.com/spf13/cobra"
+)
+
+var summaryJSON string
+
+func addSummaryFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&summaryJSON, "summary-json", "", "Write the summary of every file converted and the totals to this file as JSON")
+}
+
+// saveSummary writes the summary of the run to --summary-json, if set,
+// even if the run failed. An error writing it is returned in err unless
+// the run already failed. node, if set, returns the node a file is from.
+func saveSummary(conv *converter.Converter, node func(filename string) string, err *error) {
+	if summaryJSON == "" {
+		return
+	}
+	data, jsonErr := json.MarshalIndent(conv.Report(node), "", "  ")
+	if jsonErr == nil {
+		jsonErr = os.WriteFile(summaryJSON, append(data, '\n'), 0644)
+	}
+	if jsonErr != nil && *err == nil {
+		*err = fmt.Errorf("failed to write summary: %w", jsonErr)
+	}
+}
+
+// printSummary prints the series, samples and skipped samples of every
+// file of a run with their time range, then the totals with the samples
+// skipped as over the series limit, those dated past the future limit and
+// those quarantined, the time window resolved for each
+// file, the files skipped with the reason why and, for a cluster, the
+// totals of each node
+func printSummary(out io.Writer, report *converter.RunReport) error {
+	cluster := report.Nodes != nil
+	fmt.Fprintln(out, "\nSummary:")
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	header := "FILE\tSERIES\tSAMPLES\tFILTERED\tDUPLICATE\tINVALID\tOUTSIDE WINDOW\tWARNINGS\tFIRST SAMPLE\tLAST SAMPLE"
+	if cluster {
+		header = "NODE\t" + header
+	}
+	fmt.Fprintln(w, header)
+	for _, r := range report.Files {
+		if cluster {
+			fmt.Fprintf(w, "%s\t", r.Node)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n", r.File, r.SeriesWritten, r.SamplesWritten,
+			r.SkippedFiltered, r.SkippedDuplicates, r.SkippedInvalid, r.SamplesOutsideWindow, r.ParseWarnings,
+			summaryTime(r.FirstSample), summaryTime(r.LastSample))
+	}
+	if cluster {
+		fmt.Fprint(w, "\t")
+	}
+	printSummaryTotals(w, "TOTAL", &report.Totals)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if skipped := report.Totals.SkippedSeriesLimit; skipped > 0 {
+		fmt.Fprintf(out, "%d samples skipped in series over --max-series\n", skipped)
+	}
+	if future := report.Totals.FutureSamples; future > 0 {
+		fmt.Fprintf(out, "%d samples dated past --max-future, handled with --future-samples %s\n", future, futureSamples)
+	}
+	if quarantined := report.Totals.QuarantinedSamples; quarantined > 0 {
+		fmt.Fprintf(out, "%d samples rejected by the TSDB, quarantined in %s\n", quarantined, tsdb.QuarantinePath(tsdbPath))
+	}
+	if err := printWindows(out, report); err != nil {
+		return err
+	}
+	if err := printSkippedFiles(out, report); err != nil {
+		return err
+	}
+	if !cluster {
+		return nil
+	}
+
+	nodes := make([]string, 0, len(report.Nodes))
+	for node := range report.Nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	fmt.Fprintln(out, "\nBy node:")
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tSERIES\tSAMPLES\tFILTERED\tDUPLICATE\tINVALID\tOUTSIDE WINDOW\tWARNINGS\tFIRST SAMPLE\tLAST SAMPLE")
+	for _, node := range nodes {
+		printSummaryTotals(w, node, report.Nodes[node])
+	}
+	return w.Flush()
+}
+
+// printWindows lists the time window the samples of each file were limited
+// to, if the run had one, as resolved against the end of the file for a
+// window relative to it
+func printWindows(out io.Writer, report *converter.RunReport) error {
+	windowed := false
+	for _, r := range report.Files {
+		windowed = windowed || r.WindowStart != nil || r.WindowEnd != nil
+	}
+	if !windowed {
+		return nil
+	}
+	fmt.Fprintln(out, "\nTime window:")
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tSTART\tEND")
+	for _, r := range report.Files {
+		if r.WindowStart != nil || r.WindowEnd != nil {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", r.File, summaryTime(r.WindowStart), summaryTime(r.WindowEnd))
+		}
+	}
+	return w.Flush()
+}
+
+// printSkippedFiles lists the files of a run that failed, with the error
+// that ended them, if any did
+func printSkippedFiles(out io.Writer, report *converter.RunReport) error {
+	if report.Totals.FailedFiles == 0 {
+		return nil
+	}
+	fmt.Fprintln(out, "\nSkipped files:")
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tREASON")
+	for _, r := range report.Files {
+		if r.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\n", r.File, r.Error)
+		}
+	}
+	return w.Flush()
+}
+
+// printSummaryTotals prints a row of totals named name
+func printSummaryTotals(w io.Writer, name string, t *converter.SummaryTotals) {
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n", name, t.SeriesWritten, t.SamplesWritten,
+		t.SkippedFiltered, t.SkippedDuplicates, t.SkippedInvalid, t.SamplesOutsideWindow, t.ParseWarnings,
+		summaryTime(t.FirstSample), summaryTime(t.LastSample))
+}
+
+// summaryTime formats the time of a sample in the summary, "-" if there
+// was none
+func summaryTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
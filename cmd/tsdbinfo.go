@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/tsdb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tsdbInfoFormat string
+)
+
+var tsdbInfoCmd = &cobra.Command{
+	Use:   "tsdb-info",
+	Short: "Show what a TSDB holds",
+	Long: `Report the series and samples the TSDB at --tsdb-path holds, in its
+head and its blocks, the time range of the samples, the number of blocks
+and the size of the directory on disk. This is what convert and cluster
+print once they are done.
+
+The TSDB is opened read-only and its WAL replayed, without taking its
+lock, so it can be inspected while a watch daemon writes to it.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if tsdbInfoFormat != "table" && tsdbInfoFormat != "json" {
+			return fmt.Errorf("unknown format %q (expected table or json)", tsdbInfoFormat)
+		}
+		if _, err := os.Stat(tsdbPath); err != nil {
+			return fmt.Errorf("failed to open TSDB: %w", err)
+		}
+		stats, err := tsdb.ReadStats(tsdbPath)
+		if err != nil {
+			return err
+		}
+
+		if tsdbInfoFormat == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(stats)
+		}
+		fmt.Printf("TSDB %s:\n", tsdbPath)
+		return printTSDBStats(os.Stdout, stats)
+	},
+}
+
+// printTSDBStats prints what a TSDB holds as a table
+func printTSDBStats(out io.Writer, stats *tsdb.Stats) error {
+	first, last := "-", "-"
+	if stats.Samples > 0 {
+		first = stats.MinTime.UTC().Format(time.RFC3339)
+		last = stats.MaxTime.UTC().Format(time.RFC3339)
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIES\tSAMPLES\tFIRST SAMPLE\tLAST SAMPLE\tBLOCKS\tSIZE")
+	fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%d\t%s\n", stats.Series, stats.Samples, first, last, stats.Blocks, formatSize(stats.Bytes))
+	return w.Flush()
+}
+
+func init() {
+	tsdbInfoCmd.Flags().StringVar(&tsdbInfoFormat, "format", "table", "Output format: table or json")
+	rootCmd.AddCommand(tsdbInfoCmd)
+}
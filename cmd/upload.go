@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/ingest"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+	"github.com/4n3w/gfs-to-prometheus/internal/mimir"
+	"github.com/4n3w/gfs-to-prometheus/internal/tsdb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mimirURL               string
+	mimirTenant            string
+	mimirUsername          string
+	mimirPasswordFile      string
+	mimirBearerTokenFile   string
+	mimirRetries           int
+	mimirValidationTimeout time.Duration
+)
+
+var uploadCmd = &cobra.Command{
+	Use:   "upload",
+	Short: "Upload the TSDB's blocks to Grafana Mimir",
+	Long: `Persist the data held in the TSDB head as blocks and upload every block the
+tenant has not accepted yet through Mimir's block upload API, as convert
+does when given --mimir-url. Use it to finish an interrupted upload without
+converting the archives again.
+
+Each file of a block is uploaded separately and recorded in the TSDB once
+Mimir has it, so an interrupted upload of a large block resumes after the
+files already sent. Failed requests are retried with exponential backoff.
+The tenant must have block upload enabled in Mimir.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		uploader, err := mimirUploader()
+		if err != nil {
+			return err
+		}
+		if uploader == nil {
+			return fmt.Errorf("--mimir-url is required")
+		}
+		return uploadBlocks(uploader)
+	},
+}
+
+// addMimirFlags adds the flags selecting where and how blocks are
+// uploaded to Mimir
+func addMimirFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&mimirURL, "mimir-url", "", "Upload the TSDB's blocks to the Mimir at this URL")
+	cmd.Flags().StringVar(&mimirTenant, "tenant", "", "Mimir tenant to upload blocks to")
+	cmd.Flags().StringVar(&mimirUsername, "mimir-username", "", "Username for basic auth to Mimir")
+	cmd.Flags().StringVar(&mimirPasswordFile, "mimir-password-file", "", "File holding the password for basic auth to Mimir")
+	cmd.Flags().StringVar(&mimirBearerTokenFile, "mimir-bearer-token-file", "", "File holding a bearer token for Mimir")
+	cmd.Flags().IntVar(&mimirRetries, "mimir-retries", 5, "Retries of a failed upload request")
+	cmd.Flags().DurationVar(&mimirValidationTimeout, "mimir-validation-timeout", 10*time.Minute, "How long to wait for Mimir to validate an uploaded block")
+}
+
+// mimirUploader returns an uploader for the Mimir flags, nil if no Mimir
+// URL was given
+func mimirUploader() (*mimir.Uploader, error) {
+	if mimirURL == "" {
+		return nil, nil
+	}
+
+	opts := mimir.Options{
+		URL:               mimirURL,
+		Tenant:            mimirTenant,
+		Username:          mimirUsername,
+		Retries:           mimirRetries,
+		ValidationTimeout: mimirValidationTimeout,
+		Logger:            logging.Default(),
+	}
+	if mimirPasswordFile != "" {
+		password, err := readSecret(mimirPasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		opts.Password = password
+	}
+	if mimirBearerTokenFile != "" {
+		token, err := readSecret(mimirBearerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		opts.BearerToken = token
+	}
+	return mimir.NewUploader(opts)
+}
+
+func readSecret(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// uploadBlocks persists head data as blocks so it is included, then
+// uploads every block the tenant has not accepted
+func uploadBlocks(uploader *mimir.Uploader) error {
+	if pid, running := ingest.DaemonPID(tsdbPath); running {
+		return fmt.Errorf("watch daemon (pid %d) owns %s; stop it before uploading blocks to Mimir", pid, tsdbPath)
+	}
+
+	logging.Default().Infof("Persisting head data in %s as blocks", tsdbPath)
+	options, err := tsdbOptions()
+	if err != nil {
+		return err
+	}
+	writer, err := tsdb.NewWriter(tsdbPath, options)
+	if err != nil {
+		return err
+	}
+	if err := writer.FlushHead(); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	result, err := uploader.Upload(context.Background(), tsdbPath)
+	if result != nil {
+		for _, id := range result.Accepted {
+			fmt.Printf("Mimir accepted block %s\n", id)
+		}
+		if len(result.Skipped) > 0 {
+			fmt.Printf("%d blocks already uploaded\n", len(result.Skipped))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%w (run upload with the same flags to resume)", err)
+	}
+	fmt.Printf("Uploaded %d blocks to Mimir tenant %s\n", len(result.Accepted), mimirTenant)
+	return nil
+}
+
+func init() {
+	addMimirFlags(uploadCmd)
+	addProfileFlag(uploadCmd)
+	addTSDBFlags(uploadCmd)
+	rootCmd.AddCommand(uploadCmd)
+}
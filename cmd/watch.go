@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/ingest"
 	"github.com/4n3w/gfs-to-prometheus/internal/watcher"
 	"github.com/spf13/cobra"
 )
@@ -19,11 +20,26 @@ var watchCmd = &cobra.Command{
 	Short: "Watch directories for new GFS files",
 	Long:  `Continuously monitor directories for new or modified GFS files and convert them.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		conv, err := converter.New(tsdbPath, configFile)
+		if err := rejectBackfill(cmd); err != nil {
+			return err
+		}
+		absTsdbPath, err := filepath.Abs(tsdbPath)
+		if err != nil {
+			return fmt.Errorf("invalid TSDB path %s: %w", tsdbPath, err)
+		}
+
+		lock, err := ingest.AcquireLock(absTsdbPath)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+
+		conv, err := converter.New(tsdbPath, configFile, converterOptions())
 		if err != nil {
 			return fmt.Errorf("failed to initialize converter: %w", err)
 		}
 		defer conv.Close()
+		printTSDBOptions(conv)
 
 		w, err := watcher.New(conv)
 		if err != nil {
@@ -31,6 +47,10 @@ var watchCmd = &cobra.Command{
 		}
 		defer w.Close()
 
+		if err := w.WatchIngestQueue(absTsdbPath); err != nil {
+			return fmt.Errorf("failed to watch ingest queue: %w", err)
+		}
+
 		for _, dir := range watchDirs {
 			absDir, err := filepath.Abs(dir)
 			if err != nil {
@@ -49,5 +69,6 @@ var watchCmd = &cobra.Command{
 
 func init() {
 	watchCmd.Flags().StringSliceVar(&watchDirs, "dir", []string{"."}, "Directories to watch for GFS files")
+	addConverterFlags(watchCmd)
 	rootCmd.AddCommand(watchCmd)
-}
\ No newline at end of file
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/spf13/cobra"
+)
+
+var (
+	windowStart string
+	windowEnd   string
+	windowLast  time.Duration
+	// window is parsed from them by parseTimeWindow
+	window converter.TimeWindow
+)
+
+func addWindowFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&windowStart, "start", "", "Only write samples at or after this time: RFC3339, or a duration before the end of each archive such as -2h")
+	cmd.Flags().StringVar(&windowEnd, "end", "", "Only write samples at or before this time: RFC3339, or a duration before the end of each archive such as -1h")
+	cmd.Flags().DurationVar(&windowLast, "last", 0, "Only write the samples of this last stretch of each archive, such as 6h for --start -6h")
+}
+
+// parseTimeWindow parses the --start, --end and --last flags into window
+func parseTimeWindow() error {
+	start, err := converter.ParseWindowBound(windowStart)
+	if err != nil {
+		return fmt.Errorf("invalid --start: %w", err)
+	}
+	switch {
+	case windowLast < 0:
+		return fmt.Errorf("invalid --last: %s is negative", windowLast)
+	case windowLast > 0 && windowStart != "":
+		return fmt.Errorf("--last and --start cannot be combined")
+	case windowLast > 0:
+		start = converter.WindowBound{Offset: windowLast, Relative: true}
+	}
+	end, err := converter.ParseWindowBound(windowEnd)
+	if err != nil {
+		return fmt.Errorf("invalid --end: %w", err)
+	}
+	window = converter.TimeWindow{Start: start, End: end}
+	return window.Validate()
+}
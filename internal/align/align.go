@@ -0,0 +1,182 @@
+// Package align compares the time ranges of archives from the members of a
+// cluster and estimates how far their clocks disagree
+package align
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+)
+
+// Archive is the time coverage of one archive and when its instances were
+// created
+type Archive struct {
+	Node  string
+	File  string
+	Start time.Time // First sample
+	End   time.Time // Last sample
+	// Created maps "type/instance" to the creation time of each instance
+	// created after the first sample. Instances such as regions are
+	// created on every member at about the same moment, so their creation
+	// times can be compared across archives.
+	Created map[string]time.Time
+}
+
+// Read reads the sample time range and instance creation times of an
+// archive without keeping its samples. An archive that was only partly read
+// is still returned.
+func Read(file, node, timeZoneMode string) (*Archive, error) {
+	reader, err := gfs.NewStatArchiveReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	reader.SetTimeZoneMode(timeZoneMode)
+
+	a := &Archive{Node: node, File: file, Created: make(map[string]time.Time)}
+	err = reader.ReadArchiveStream(func(_ *gfs.ResourceInstance, _ *gfs.StatDescriptor, timestamp time.Time, _ float64) error {
+		if a.Start.IsZero() || timestamp.Before(a.Start) {
+			a.Start = timestamp
+		}
+		if timestamp.After(a.End) {
+			a.End = timestamp
+		}
+		return nil
+	})
+	if err != nil && gfs.IsUnreadable(err) {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	types := reader.GetResourceTypes()
+	for _, instance := range reader.GetInstances() {
+		if a.Start.IsZero() || !instance.CreationTime.After(a.Start) {
+			continue
+		}
+		typeName := fmt.Sprintf("type-%d", instance.TypeID)
+		if t, ok := types[instance.TypeID]; ok {
+			typeName = t.Name
+		}
+		a.Created[typeName+"/"+instance.Name] = instance.CreationTime
+	}
+	return a, nil
+}
+
+// Report is the alignment of a set of archives
+type Report struct {
+	Archives []ArchiveAlignment `json:"archives"`
+	// CommonStart and CommonEnd bound the range every archive covers;
+	// Overlap is false if there is none
+	CommonStart time.Time `json:"common_start,omitempty"`
+	CommonEnd   time.Time `json:"common_end,omitempty"`
+	Overlap     bool      `json:"overlap"`
+	// Threshold is the skew above which an archive is flagged
+	Threshold time.Duration `json:"-"`
+	Skewed    int           `json:"skewed"`
+}
+
+// ArchiveAlignment is one archive's range and estimated clock skew
+type ArchiveAlignment struct {
+	Node  string    `json:"node"`
+	File  string    `json:"file"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// SharedEvents counts the instance creations also found in another
+	// archive. Skew, the median of how much earlier or later than the other
+	// archives this one recorded them, is only known when there are some.
+	SharedEvents int           `json:"shared_events"`
+	Skew         time.Duration `json:"-"`
+	SkewSeconds  float64       `json:"skew_seconds"`
+	SkewKnown    bool          `json:"skew_known"`
+	Skewed       bool          `json:"skewed"`
+}
+
+// Analyze computes the common range of the archives and flags those whose
+// clock appears to be off by more than threshold. Archives without samples
+// are listed but do not narrow the common range.
+func Analyze(archives []*Archive, threshold time.Duration) *Report {
+	report := &Report{Threshold: threshold}
+
+	// Offsets of each archive's events from the median time the archives
+	// that recorded the event give for it
+	offsets := make([][]time.Duration, len(archives))
+	events := make(map[string][]int)
+	for i, a := range archives {
+		for key := range a.Created {
+			events[key] = append(events[key], i)
+		}
+	}
+	for key, recorded := range events {
+		if len(recorded) < 2 {
+			continue
+		}
+		times := make([]time.Time, len(recorded))
+		for j, i := range recorded {
+			times[j] = archives[i].Created[key]
+		}
+		reference := medianTime(times)
+		for _, i := range recorded {
+			offsets[i] = append(offsets[i], archives[i].Created[key].Sub(reference))
+		}
+	}
+
+	first := true
+	for i, a := range archives {
+		alignment := ArchiveAlignment{
+			Node:         a.Node,
+			File:         a.File,
+			Start:        a.Start,
+			End:          a.End,
+			SharedEvents: len(offsets[i]),
+		}
+		if len(offsets[i]) > 0 {
+			alignment.Skew = medianDuration(offsets[i])
+			alignment.SkewSeconds = alignment.Skew.Seconds()
+			alignment.SkewKnown = true
+			alignment.Skewed = threshold > 0 && (alignment.Skew > threshold || alignment.Skew < -threshold)
+		}
+		if alignment.Skewed {
+			report.Skewed++
+		}
+		report.Archives = append(report.Archives, alignment)
+
+		if a.Start.IsZero() {
+			continue
+		}
+		if first || a.Start.After(report.CommonStart) {
+			report.CommonStart = a.Start
+		}
+		if first || a.End.Before(report.CommonEnd) {
+			report.CommonEnd = a.End
+		}
+		first = false
+	}
+	report.Overlap = !first && !report.CommonEnd.Before(report.CommonStart)
+
+	sort.SliceStable(report.Archives, func(i, j int) bool {
+		if report.Archives[i].Node != report.Archives[j].Node {
+			return report.Archives[i].Node < report.Archives[j].Node
+		}
+		return report.Archives[i].File < report.Archives[j].File
+	})
+	return report
+}
+
+func medianTime(times []time.Time) time.Time {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	mid := len(times) / 2
+	if len(times)%2 == 1 {
+		return times[mid]
+	}
+	return times[mid-1].Add(times[mid].Sub(times[mid-1]) / 2)
+}
+
+func medianDuration(durations []time.Duration) time.Duration {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	mid := len(durations) / 2
+	if len(durations)%2 == 1 {
+		return durations[mid]
+	}
+	return (durations[mid-1] + durations[mid]) / 2
+}
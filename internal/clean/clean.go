@@ -0,0 +1,437 @@
+// Package clean finds what crashed or interrupted runs leave behind in a
+// TSDB directory and removes it. Deletion is conservative: only artifacts
+// whose origin is certain and that nothing can still be writing are
+// removed, everything else is reported and left in place.
+package clean
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/ingest"
+	"github.com/4n3w/gfs-to-prometheus/internal/mimir"
+	"github.com/4n3w/gfs-to-prometheus/internal/relabel"
+	kitlog "github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// Kinds of artifact
+const (
+	KindTmpDir          = "tmp-dir"
+	KindStaleLock       = "stale-lock"
+	KindQueueTmp        = "queue-tmp"
+	KindRelabelTmp      = "relabel-tmp"
+	KindRelabelStaging  = "relabel-staging"
+	KindConvertTmp      = "convert-tmp"
+	KindMimirTmp        = "mimir-tmp"
+	KindIncompleteBlock = "incomplete-block"
+	KindUnknown         = "unknown"
+)
+
+// Actions taken on an artifact
+const (
+	ActionRemove = "remove"
+	ActionKeep   = "keep"
+)
+
+// Temporary directory suffixes used by the Prometheus TSDB for blocks,
+// WAL checkpoints and chunk snapshots being created or deleted
+var tmpSuffixes = []string{".tmp-for-creation", ".tmp-for-deletion", ".tmp"}
+
+// minAge is how long an artifact must be left untouched before it counts
+// as orphaned rather than still being written
+const minAge = 5 * time.Minute
+
+// WAL directories checked for corruption
+var walDirs = []string{"wal", "wbl"}
+
+// Item is one artifact found in the TSDB directory
+type Item struct {
+	Path   string `json:"path"`
+	Kind   string `json:"kind"`
+	Bytes  int64  `json:"bytes"`
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+}
+
+// WALCheck is the result of reading one write-ahead log end to end
+type WALCheck struct {
+	Dir      string `json:"dir"`
+	Segments int    `json:"segments"`
+	Bytes    int64  `json:"bytes"`
+	Corrupt  bool   `json:"corrupt"`
+	Segment  int    `json:"segment,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Truncated is set once the WAL has been truncated at the corruption
+	Truncated bool  `json:"truncated"`
+	Discarded int64 `json:"discarded_bytes,omitempty"`
+
+	corruption *wlog.CorruptionErr
+}
+
+// Report describes what a run found and did
+type Report struct {
+	Path   string `json:"path"`
+	DryRun bool   `json:"dry_run"`
+	// InUse is why nothing was removed, if the TSDB is in use
+	InUse string     `json:"in_use,omitempty"`
+	Items []Item     `json:"items"`
+	WAL   []WALCheck `json:"wal,omitempty"`
+	// Reclaimed is the size of the removed items, or of the items that
+	// would be removed on a dry run
+	Reclaimed int64 `json:"reclaimed_bytes"`
+}
+
+// Options control a run
+type Options struct {
+	// DryRun reports what would be removed without removing it
+	DryRun bool
+	// CheckWAL reads every WAL segment to find corruption, unless the TSDB
+	// is in use
+	CheckWAL bool
+}
+
+// Run scans tsdbPath and removes its orphaned artifacts. Nothing is
+// removed while a watch daemon owns the directory or another process has
+// the TSDB open.
+func Run(tsdbPath string, opts Options) (*Report, error) {
+	info, err := os.Stat(tsdbPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", tsdbPath)
+	}
+
+	report := &Report{Path: tsdbPath, DryRun: opts.DryRun, Items: []Item{}}
+	release, inUse := lockTSDB(tsdbPath)
+	defer release()
+	report.InUse = inUse
+
+	if err := report.scan(tsdbPath); err != nil {
+		return nil, err
+	}
+	// A WAL that is being written may end in a partial record
+	if opts.CheckWAL && report.InUse == "" {
+		for _, name := range walDirs {
+			dir := filepath.Join(tsdbPath, name)
+			if _, err := os.Stat(dir); err != nil {
+				continue
+			}
+			check, err := checkWAL(dir)
+			if err != nil {
+				return nil, err
+			}
+			report.WAL = append(report.WAL, *check)
+		}
+	}
+
+	for i := range report.Items {
+		item := &report.Items[i]
+		if item.Action != ActionRemove {
+			continue
+		}
+		if report.InUse != "" {
+			item.Action = ActionKeep
+			item.Reason = report.InUse
+			continue
+		}
+		if !opts.DryRun {
+			if err := os.RemoveAll(item.Path); err != nil {
+				item.Action = ActionKeep
+				item.Reason = fmt.Sprintf("failed to remove: %v", err)
+				continue
+			}
+		}
+		report.Reclaimed += item.Bytes
+	}
+	return report, nil
+}
+
+// lockTSDB takes the TSDB's lock so nothing opens it while artifacts are
+// removed. It returns why the directory is in use if it cannot.
+func lockTSDB(tsdbPath string) (func(), string) {
+	if pid, running := ingest.DaemonPID(tsdbPath); running {
+		return func() {}, fmt.Sprintf("watch daemon (pid %d) owns the TSDB", pid)
+	}
+
+	// Without a lock file the TSDB has never been opened with locking;
+	// taking the lock would create one
+	lockPath := filepath.Join(tsdbPath, "lock")
+	if _, err := os.Stat(lockPath); err != nil {
+		return func() {}, ""
+	}
+	lock, _, err := fileutil.Flock(lockPath)
+	if err != nil {
+		return func() {}, "TSDB is open in another process"
+	}
+	return func() { lock.Release() }, ""
+}
+
+// scan lists the artifacts in tsdbPath, marking those that are safe to
+// remove
+func (r *Report) scan(tsdbPath string) error {
+	entries, err := os.ReadDir(tsdbPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(tsdbPath, entry.Name())
+		switch {
+		case path == ingest.LockPath(tsdbPath):
+			r.checkDaemonLock(path)
+		case path == relabel.CheckpointPath(tsdbPath)+".tmp":
+			r.add(path, KindRelabelTmp, ActionRemove, "checkpoint left by an interrupted relabel write")
+		case path == converter.CheckpointPath(tsdbPath)+".tmp":
+			r.add(path, KindConvertTmp, ActionRemove, "checkpoint left by an interrupted conversion write")
+		case path == mimir.StatePath(tsdbPath)+".tmp":
+			r.add(path, KindMimirTmp, ActionRemove, "upload state left by an interrupted Mimir upload write")
+		case path == relabel.StagingDir(tsdbPath):
+			if _, err := os.Stat(relabel.CheckpointPath(tsdbPath)); err == nil {
+				r.add(path, KindRelabelStaging, ActionKeep, "relabel was interrupted; run it again to resume")
+			} else {
+				r.add(path, KindRelabelStaging, ActionRemove, "staging left by a relabel that no longer has a checkpoint")
+			}
+		case path == ingest.QueueDir(tsdbPath):
+			r.scanDir(path, func(child fs.DirEntry) {
+				if !child.IsDir() && strings.HasSuffix(child.Name(), ".tmp") {
+					r.add(filepath.Join(path, child.Name()), KindQueueTmp, ActionRemove, "ingest request that was never completed")
+				}
+			})
+		case entry.Name() == "wal":
+			r.scanDir(path, func(child fs.DirEntry) {
+				if isTmpDir(child) {
+					r.add(filepath.Join(path, child.Name()), KindTmpDir, ActionRemove, "WAL checkpoint left by an interrupted run")
+				}
+			})
+		case isTmpDir(entry):
+			r.add(path, KindTmpDir, ActionRemove, "block or snapshot left by an interrupted compaction or deletion")
+		case entry.IsDir() && hasTmpSuffix(entry.Name()):
+			r.add(path, KindUnknown, ActionKeep, "temporary directory of unknown origin")
+		case entry.IsDir() && isULID(entry.Name()):
+			if _, err := os.Stat(filepath.Join(path, "meta.json")); err != nil {
+				r.add(path, KindIncompleteBlock, ActionKeep, "block directory without meta.json")
+			}
+		}
+	}
+	return nil
+}
+
+// scanDir calls fn for every entry of dir, ignoring a directory that
+// cannot be read
+func (r *Report) scanDir(dir string, fn func(fs.DirEntry)) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		fn(entry)
+	}
+}
+
+// checkDaemonLock reports a daemon lock whose process has exited. A lock
+// that cannot be read as a pid is kept.
+func (r *Report) checkDaemonLock(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		r.add(path, KindStaleLock, ActionKeep, fmt.Sprintf("cannot read lock: %v", err))
+		return
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		r.add(path, KindStaleLock, ActionKeep, "lock does not contain a pid")
+		return
+	}
+	if _, running := ingest.DaemonPID(filepath.Dir(path)); running {
+		return
+	}
+	r.add(path, KindStaleLock, ActionRemove, fmt.Sprintf("watch daemon (pid %d) is no longer running", pid))
+}
+
+// add records an artifact. One modified too recently to be sure it is
+// orphaned is kept whatever the action asked for.
+func (r *Report) add(path, kind, action, reason string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if action == ActionRemove {
+		if age := time.Since(latestModTime(path, info)); age < minAge {
+			action = ActionKeep
+			reason = fmt.Sprintf("modified %s ago; may still be in use", age.Round(time.Second))
+		}
+	}
+	r.Items = append(r.Items, Item{
+		Path:   path,
+		Kind:   kind,
+		Bytes:  diskUsage(path),
+		Action: action,
+		Reason: reason,
+	})
+}
+
+// checkWAL reads every record of the WAL in dir, returning where it is
+// first corrupt, if it is
+func checkWAL(dir string) (*WALCheck, error) {
+	check := &WALCheck{Dir: dir, Bytes: segmentBytes(dir)}
+	first, last, err := wlog.Segments(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list WAL segments in %s: %w", dir, err)
+	}
+	if last < 0 {
+		return check, nil
+	}
+	check.Segments = last - first + 1
+
+	segments, err := wlog.NewSegmentsReader(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL in %s: %w", dir, err)
+	}
+	defer segments.Close()
+
+	reader := wlog.NewReader(segments)
+	for reader.Next() {
+	}
+	if err := reader.Err(); err != nil {
+		var corruption *wlog.CorruptionErr
+		if !errors.As(err, &corruption) {
+			return nil, err
+		}
+		check.Corrupt = true
+		check.Segment = corruption.Segment
+		check.Offset = corruption.Offset
+		check.Error = corruption.Err.Error()
+		check.corruption = corruption
+	}
+	return check, nil
+}
+
+// TruncateWAL discards the i-th checked WAL from its corruption onwards,
+// as Prometheus does when it finds one on startup: the corrupt segment
+// keeps the records before the corruption and every later segment is
+// deleted. The discarded bytes count as reclaimed.
+func (r *Report) TruncateWAL(i int) error {
+	check := &r.WAL[i]
+	if check.corruption == nil || check.Segment < 0 {
+		return fmt.Errorf("WAL in %s has no corruption to truncate", check.Dir)
+	}
+	release, inUse := lockTSDB(r.Path)
+	defer release()
+	if inUse != "" {
+		return fmt.Errorf("cannot truncate WAL: %s", inUse)
+	}
+
+	w, err := wlog.New(kitlog.NewNopLogger(), nil, check.Dir, wlog.CompressionNone)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL in %s: %w", check.Dir, err)
+	}
+	if err := w.Repair(check.corruption); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to truncate WAL in %s: %w", check.Dir, err)
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	check.Truncated = true
+	check.Discarded = check.Bytes - segmentBytes(check.Dir)
+	if check.Discarded < 0 {
+		check.Discarded = 0
+	}
+	r.Reclaimed += check.Discarded
+	return nil
+}
+
+// isTmpDir matches the temporary directories the Prometheus TSDB deletes
+// when it opens
+func isTmpDir(entry fs.DirEntry) bool {
+	if !entry.IsDir() {
+		return false
+	}
+	name := entry.Name()
+	if !hasTmpSuffix(name) {
+		return false
+	}
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	return strings.HasPrefix(name, "checkpoint.") ||
+		strings.HasPrefix(name, "chunk_snapshot.") ||
+		isULID(base)
+}
+
+func hasTmpSuffix(name string) bool {
+	ext := filepath.Ext(name)
+	for _, suffix := range tmpSuffixes {
+		if ext == suffix {
+			return true
+		}
+	}
+	return false
+}
+
+func isULID(name string) bool {
+	_, err := ulid.ParseStrict(name)
+	return err == nil
+}
+
+// diskUsage returns the size of a file or of everything under a directory
+func diskUsage(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// latestModTime returns the most recent modification time of a file or of
+// anything under a directory
+func latestModTime(path string, info fs.FileInfo) time.Time {
+	latest := info.ModTime()
+	if !info.IsDir() {
+		return latest
+	}
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return latest
+}
+
+// segmentBytes returns the size of the WAL segment files in dir
+func segmentBytes(dir string) int64 {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	var size int64
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil || entry.IsDir() {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
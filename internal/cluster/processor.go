@@ -2,13 +2,16 @@ package cluster
 
 import (
 	"fmt"
-	"log"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
 )
 
 type Config struct {
@@ -18,6 +21,12 @@ type Config struct {
 	Recursive       bool
 	Concurrency     int
 	Converter       *converter.Converter
+	// Progress, if set, is called with the overall progress of
+	// ProcessDirectory whenever FileProgress is called and whenever a
+	// file is done, concurrently when files are converted concurrently
+	Progress func(Progress)
+	// Logger receives the processor's logs; nil uses logging.Default()
+	Logger logging.Logger
 }
 
 type NodeInfo struct {
@@ -27,9 +36,13 @@ type NodeInfo struct {
 }
 
 type Processor struct {
-	config           Config
-	excludeRegexes   []*regexp.Regexp
-	nodeExtractors   []*NodeExtractor
+	config         Config
+	excludeRegexes []*regexp.Regexp
+	nodeExtractors []*NodeExtractor
+	logger         logging.Logger
+
+	// progress tracks the files of the running ProcessDirectory
+	progress atomic.Pointer[progressTracker]
 }
 
 type NodeExtractor struct {
@@ -41,6 +54,10 @@ type NodeExtractor struct {
 func NewProcessor(config Config) (*Processor, error) {
 	p := &Processor{
 		config: config,
+		logger: config.Logger,
+	}
+	if p.logger == nil {
+		p.logger = logging.Default()
 	}
 
 	// Compile exclude patterns
@@ -62,7 +79,7 @@ func NewProcessor(config Config) (*Processor, error) {
 		},
 		{
 			Pattern: regexp.MustCompile(`.*?([a-zA-Z]+-\d+)[^/]*-stats\.gfs`),
-			Name:    "$1",     // Extract node-1, server-2, etc.
+			Name:    "$1", // Extract node-1, server-2, etc.
 			Type:    "server",
 		},
 		// Traditional patterns
@@ -82,83 +99,210 @@ func NewProcessor(config Config) (*Processor, error) {
 }
 
 func (p *Processor) ProcessDirectory(rootDir string) error {
-	files, err := p.discoverFiles(rootDir)
+	files, err := p.DiscoverFiles(rootDir)
 	if err != nil {
 		return fmt.Errorf("failed to discover files: %w", err)
 	}
 
 	if len(files) == 0 {
-		log.Printf("No GFS files found in %s", rootDir)
+		p.logger.Warnf("No GFS files found in %s", rootDir)
 		return nil
 	}
 
-	log.Printf("Found %d GFS files to process", len(files))
+	p.logger.Infof("Found %d GFS files to process", len(files))
+	progress := newProgressTracker(files)
+	p.progress.Store(progress)
+	defer p.progress.Store(nil)
 
 	// Process files with concurrency control
 	semaphore := make(chan struct{}, p.config.Concurrency)
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	var errors []error
-
-	for _, nodeInfo := range files {
+	// unreadable counts the files skipped as not archives this tool reads
+	unreadable := 0
+	// aborted stops every node at its next file once a file failed with
+	// converter.OnErrorAbort or went over the series limit; abortErr is
+	// the error of that file
+	var aborted atomic.Bool
+	var abortErr error
+	abort := p.config.Converter.OnError() == converter.OnErrorAbort
+	var unclean []nodeReport
+	var incomplete []NodeInfo
+
+	// The files of a node are converted one after the other, oldest
+	// first, while the nodes are converted concurrently
+	for _, nodeFiles := range p.orderByNode(files) {
 		wg.Add(1)
-		go func(node NodeInfo) {
+		go func(nodeFiles []NodeInfo) {
 			defer wg.Done()
-			semaphore <- struct{}{} // Acquire semaphore
+			semaphore <- struct{}{}        // Acquire semaphore
 			defer func() { <-semaphore }() // Release semaphore
 
-			if err := p.processFile(node); err != nil {
+			for i, node := range nodeFiles {
+				if aborted.Load() {
+					return
+				}
+				if i > 0 {
+					p.config.Converter.WarnOverlap(nodeFiles[i-1].FilePath, node.FilePath)
+				}
+				report, err := p.processFile(node)
+				p.reportProgress(progress.done(node.FilePath))
 				mu.Lock()
-				errors = append(errors, fmt.Errorf("failed to process %s: %w", node.FilePath, err))
+				switch {
+				case gfs.IsPermanent(err):
+					// Not an archive this tool reads, which only ends the
+					// run early with converter.OnErrorAbort
+					p.logger.Warnf("Skipping %s, which cannot be read: %v", node.FilePath, err)
+					unreadable++
+					if abort {
+						errors = append(errors, fmt.Errorf("failed to process %s: %w", node.FilePath, err))
+						if !aborted.Swap(true) {
+							abortErr = errors[len(errors)-1]
+						}
+					}
+				case gfs.IsIncomplete(err):
+					incomplete = append(incomplete, node)
+				case err != nil:
+					errors = append(errors, fmt.Errorf("failed to process %s: %w", node.FilePath, err))
+					if p.config.Converter.Aborts(err) && !aborted.Swap(true) {
+						abortErr = errors[len(errors)-1]
+					}
+				}
+				if report != nil && !report.Clean() {
+					unclean = append(unclean, nodeReport{node: node, report: report})
+				}
 				mu.Unlock()
 			}
-		}(nodeInfo)
+		}(nodeFiles)
 	}
 
 	wg.Wait()
 
+	// Archives too short to hold a header are usually still being created,
+	// so they are tried again once everything else is done
+	for _, node := range incomplete {
+		if aborted.Load() {
+			break
+		}
+		if _, err := p.processFile(node); err != nil {
+			errors = append(errors, fmt.Errorf("failed to process %s: %w", node.FilePath, err))
+			if p.config.Converter.Aborts(err) {
+				abortErr = errors[len(errors)-1]
+				aborted.Store(true)
+			}
+		}
+	}
+
+	// Archives that were only partly read are listed together, per node
+	sort.Slice(unclean, func(i, j int) bool {
+		if unclean[i].node.Name != unclean[j].node.Name {
+			return unclean[i].node.Name < unclean[j].node.Name
+		}
+		return unclean[i].node.FilePath < unclean[j].node.FilePath
+	})
+	for _, r := range unclean {
+		p.logger.Warnf("%s archive %s: %s", r.node.Name, r.node.FilePath, r.report)
+	}
+
+	if aborted.Load() {
+		return fmt.Errorf("processing aborted: %w", abortErr)
+	}
 	if len(errors) > 0 {
-		log.Printf("Encountered %d errors during processing:", len(errors))
 		for _, err := range errors {
-			log.Printf("  %v", err)
+			p.logger.Warnf("%v", err)
 		}
 		return fmt.Errorf("processing completed with %d errors", len(errors))
 	}
+	if unreadable > 0 {
+		return fmt.Errorf("%d of %d files were skipped", unreadable, len(files))
+	}
 
 	return nil
 }
 
-func (p *Processor) discoverFiles(rootDir string) ([]NodeInfo, error) {
+// orderByNode groups files by node, in the order the nodes were
+// discovered, with the files of each node ordered by the start time in
+// their headers
+func (p *Processor) orderByNode(files []NodeInfo) [][]NodeInfo {
+	var names []string
+	byNode := make(map[string][]NodeInfo)
+	for _, file := range files {
+		if _, ok := byNode[file.Name]; !ok {
+			names = append(names, file.Name)
+		}
+		byNode[file.Name] = append(byNode[file.Name], file)
+	}
+
+	groups := make([][]NodeInfo, 0, len(names))
+	for _, name := range names {
+		nodeFiles := byNode[name]
+		paths := make([]string, len(nodeFiles))
+		byPath := make(map[string]NodeInfo, len(nodeFiles))
+		for i, file := range nodeFiles {
+			paths[i] = file.FilePath
+			byPath[file.FilePath] = file
+		}
+		for i, path := range p.config.Converter.OrderByStart(paths) {
+			nodeFiles[i] = byPath[path]
+		}
+		groups = append(groups, nodeFiles)
+	}
+	return groups
+}
+
+// DiscoverFiles finds the stats files under rootDir matching the node
+// patterns, with node information extracted from each path
+func (p *Processor) DiscoverFiles(rootDir string) ([]NodeInfo, error) {
 	var files []NodeInfo
-	
+	seen := make(map[string]bool)
+
 	for _, pattern := range p.config.NodePatterns {
 		// Convert pattern to absolute path
 		searchPattern := filepath.Join(rootDir, pattern)
-		
+
 		matches, err := filepath.Glob(searchPattern)
 		if err != nil {
-			log.Printf("Warning: invalid pattern %s: %v", pattern, err)
+			p.logger.Warnf("Invalid pattern %s: %v", pattern, err)
 			continue
 		}
 
+		// Rolled archives are usually compressed next to the live one
+		if strings.HasSuffix(searchPattern, ".gfs") {
+			compressed, _ := filepath.Glob(searchPattern + ".gz")
+			matches = append(matches, compressed...)
+		}
+
 		for _, match := range matches {
-			// Check if file should be excluded
-			if p.shouldExclude(match) {
+			// Check if file should be excluded or was matched by an earlier pattern
+			if p.shouldExclude(match) || seen[match] {
 				continue
 			}
+			seen[match] = true
 
 			// Extract node information
 			nodeInfo := p.extractNodeInfo(match)
 			if nodeInfo.Name != "" {
 				files = append(files, nodeInfo)
-				log.Printf("Discovered: %s (node=%s, type=%s)", match, nodeInfo.Name, nodeInfo.Type)
+				p.logger.Debugf("Discovered: %s (node=%s, type=%s)", match, nodeInfo.Name, nodeInfo.Type)
 			}
 		}
 	}
 
+	// Process in path order so repeated runs behave identically
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].FilePath < files[j].FilePath
+	})
+
 	return files, nil
 }
 
+// NodeName returns the name of the node the stats file at filePath is
+// from, as extracted when it is discovered
+func (p *Processor) NodeName(filePath string) string {
+	return p.extractNodeInfo(filePath).Name
+}
+
 func (p *Processor) shouldExclude(path string) bool {
 	for _, regex := range p.excludeRegexes {
 		if regex.MatchString(path) {
@@ -181,13 +325,13 @@ func (p *Processor) extractNodeInfo(filePath string) NodeInfo {
 			// Replace placeholders in name and type
 			name := extractor.Name
 			nodeType := extractor.Type
-			
+
 			for i, match := range matches {
 				placeholder := fmt.Sprintf("$%d", i)
 				name = strings.ReplaceAll(name, placeholder, match)
 				nodeType = strings.ReplaceAll(nodeType, placeholder, match)
 			}
-			
+
 			nodeInfo.Name = name
 			nodeInfo.Type = p.inferNodeType(name, filePath)
 			break
@@ -200,7 +344,7 @@ func (p *Processor) extractNodeInfo(filePath string) NodeInfo {
 func (p *Processor) inferNodeType(nodeName, filePath string) string {
 	nameLower := strings.ToLower(nodeName)
 	pathLower := strings.ToLower(filePath)
-	
+
 	// Check for common node type indicators
 	if strings.Contains(nameLower, "locator") || strings.Contains(pathLower, "locator") {
 		return "locator"
@@ -211,13 +355,33 @@ func (p *Processor) inferNodeType(nodeName, filePath string) string {
 	if strings.Contains(nameLower, "server") || strings.Contains(pathLower, "server") {
 		return "server"
 	}
-	
+
 	// Default to server
 	return "server"
 }
 
-func (p *Processor) processFile(nodeInfo NodeInfo) error {
-	log.Printf("Processing %s (cluster=%s, node=%s, type=%s)", 
+// FileProgress records that bytesRead of a file being converted have been
+// read, for the converter's ReadProgress option
+func (p *Processor) FileProgress(filename string, bytesRead, totalBytes int64, samples int) {
+	if progress := p.progress.Load(); progress != nil {
+		p.reportProgress(progress.update(filename, bytesRead))
+	}
+}
+
+func (p *Processor) reportProgress(progress Progress) {
+	if p.config.Progress != nil {
+		p.config.Progress(progress)
+	}
+}
+
+// nodeReport pairs a node's archive with the report of reading it
+type nodeReport struct {
+	node   NodeInfo
+	report *gfs.ParseReport
+}
+
+func (p *Processor) processFile(nodeInfo NodeInfo) (*gfs.ParseReport, error) {
+	p.logger.Infof("Processing %s (cluster=%s, node=%s, type=%s)",
 		nodeInfo.FilePath, p.config.ClusterName, nodeInfo.Name, nodeInfo.Type)
 
 	// Set cluster labels for this file
@@ -237,11 +401,11 @@ func (p *Processor) processFile(nodeInfo NodeInfo) error {
 func globToRegex(glob string) string {
 	// Escape regex special characters except * and ?
 	regex := regexp.QuoteMeta(glob)
-	
+
 	// Convert glob wildcards to regex
 	regex = strings.ReplaceAll(regex, `\*`, `.*`)
 	regex = strings.ReplaceAll(regex, `\?`, `.`)
-	
+
 	// Anchor the pattern
 	return "^" + regex + "$"
-}
\ No newline at end of file
+}
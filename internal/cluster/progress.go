@@ -0,0 +1,73 @@
+package cluster
+
+import (
+	"os"
+	"sync"
+)
+
+// Progress is how far ProcessDirectory has got through the files it found
+type Progress struct {
+	Files int
+	// Done counts the files that have been converted or given up on
+	Done int
+	// BytesRead counts the bytes read of all files, and TotalBytes their
+	// sizes on disk
+	BytesRead  int64
+	TotalBytes int64
+}
+
+// Fraction returns the part of the bytes of all files read so far
+func (p Progress) Fraction() float64 {
+	if p.TotalBytes <= 0 {
+		return 0
+	}
+	return min(float64(p.BytesRead)/float64(p.TotalBytes), 1)
+}
+
+// progressTracker sums the progress of the files converted concurrently
+type progressTracker struct {
+	mu       sync.Mutex
+	progress Progress
+	sizes    map[string]int64
+	read     map[string]int64
+}
+
+func newProgressTracker(files []NodeInfo) *progressTracker {
+	t := &progressTracker{
+		sizes: make(map[string]int64, len(files)),
+		read:  make(map[string]int64, len(files)),
+	}
+	t.progress.Files = len(files)
+	for _, node := range files {
+		if info, err := os.Stat(node.FilePath); err == nil {
+			t.sizes[node.FilePath] = info.Size()
+			t.progress.TotalBytes += info.Size()
+		}
+	}
+	return t
+}
+
+// update records that bytesRead of a file have been read and returns the
+// overall progress
+func (t *progressTracker) update(filename string, bytesRead int64) Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.setRead(filename, bytesRead)
+	return t.progress
+}
+
+// done records that a file has been dealt with and returns the overall
+// progress
+func (t *progressTracker) done(filename string) Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.setRead(filename, t.sizes[filename])
+	t.progress.Done++
+	return t.progress
+}
+
+func (t *progressTracker) setRead(filename string, bytesRead int64) {
+	bytesRead = min(bytesRead, t.sizes[filename])
+	t.progress.BytesRead += bytesRead - t.read[filename]
+	t.read[filename] = bytesRead
+}
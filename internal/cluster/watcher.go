@@ -1,20 +1,24 @@
 package cluster
 
 import (
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/ingest"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
 	"github.com/fsnotify/fsnotify"
 )
 
 type Watcher struct {
-	processor     *Processor
-	fsWatcher     *fsnotify.Watcher
+	processor      *Processor
+	fsWatcher      *fsnotify.Watcher
 	processedFiles sync.Map
-	done          chan bool
+	done           chan bool
+	tsdbPath       string
+	logger         logging.Logger
 }
 
 func NewWatcher(processor *Processor) (*Watcher, error) {
@@ -27,6 +31,7 @@ func NewWatcher(processor *Processor) (*Watcher, error) {
 		processor: processor,
 		fsWatcher: fsWatcher,
 		done:      make(chan bool),
+		logger:    processor.logger,
 	}, nil
 }
 
@@ -47,10 +52,10 @@ func (w *Watcher) AddDirectory(dir string) error {
 				if w.processor.shouldExclude(path) {
 					return filepath.SkipDir
 				}
-				
+
 				// Add directory to watcher
 				if err := w.fsWatcher.Add(path); err != nil {
-					log.Printf("Warning: Could not watch directory %s: %v", path, err)
+					w.logger.Warnf("Could not watch directory %s: %v", path, err)
 				}
 			}
 			return nil
@@ -60,6 +65,25 @@ func (w *Watcher) AddDirectory(dir string) error {
 	return nil
 }
 
+// WatchIngestQueue makes the watcher also convert files that batch commands
+// hand over through the ingest queue of tsdbPath, starting with any requests
+// queued while no daemon was running
+func (w *Watcher) WatchIngestQueue(tsdbPath string) error {
+	w.tsdbPath = tsdbPath
+	if err := w.fsWatcher.Add(ingest.QueueDir(tsdbPath)); err != nil {
+		return err
+	}
+
+	pending, err := ingest.PendingRequests(tsdbPath)
+	if err != nil {
+		return err
+	}
+	for _, request := range pending {
+		go w.processRequest(request)
+	}
+	return nil
+}
+
 func (w *Watcher) Start() error {
 	go w.watch()
 	<-w.done
@@ -79,9 +103,16 @@ func (w *Watcher) watch() {
 				return
 			}
 
+			if w.tsdbPath != "" && ingest.IsRequest(w.tsdbPath, event.Name) {
+				if event.Op&fsnotify.Create == fsnotify.Create {
+					go w.processRequest(event.Name)
+				}
+				continue
+			}
+
 			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
 				if w.isGFSFile(event.Name) && w.matchesPatterns(event.Name) {
-					log.Printf("Detected GFS file: %s", event.Name)
+					w.logger.Debugf("Detected GFS file: %s", event.Name)
 					go w.processFile(event.Name)
 				}
 			}
@@ -90,7 +121,7 @@ func (w *Watcher) watch() {
 			if !ok {
 				return
 			}
-			log.Printf("Watcher error: %v", err)
+			w.logger.Warnf("Watcher error: %v", err)
 
 		case <-w.done:
 			return
@@ -99,8 +130,7 @@ func (w *Watcher) watch() {
 }
 
 func (w *Watcher) isGFSFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	return ext == ".gfs"
+	return gfs.HasArchiveExtension(filename)
 }
 
 func (w *Watcher) matchesPatterns(filePath string) bool {
@@ -111,7 +141,7 @@ func (w *Watcher) matchesPatterns(filePath string) bool {
 		if matched, _ := filepath.Match(pattern, filePath); matched {
 			return true
 		}
-		
+
 		// Also check if the file path contains pattern elements
 		if strings.Contains(filePath, "stats") && strings.Contains(filePath, ".gfs") {
 			return true
@@ -128,12 +158,54 @@ func (w *Watcher) processFile(filename string) {
 
 	// Extract node info
 	nodeInfo := w.processor.extractNodeInfo(filename)
-	
-	log.Printf("Processing new cluster GFS file: %s (node=%s, type=%s)", 
+
+	w.logger.Infof("Processing new cluster GFS file: %s (node=%s, type=%s)",
 		filename, nodeInfo.Name, nodeInfo.Type)
-	
-	if err := w.processor.processFile(nodeInfo); err != nil {
-		log.Printf("Error processing %s: %v", filename, err)
+
+	report, err := w.processor.processFile(nodeInfo)
+	switch {
+	case gfs.IsPermanent(err):
+		// Stays marked as processed, so later writes do not log it again
+		w.logger.Warnf("Skipping %s, which cannot be read: %v", filename, err)
+		return
+	case gfs.IsIncomplete(err), report != nil && report.Truncated:
+		// Half written: converted again once it has grown
+		w.logger.Infof("%s ends inside a record, it will be converted again when it changes", filename)
+		w.processedFiles.Delete(filename)
+		return
+	case err != nil:
+		w.logger.Warnf("Error processing %s: %v", filename, err)
 		w.processedFiles.Delete(filename)
 	}
-}
\ No newline at end of file
+	w.warnUnclean(nodeInfo, report)
+}
+
+// processRequest converts a file queued by a batch command, deriving the
+// node labels from its path like any discovered file
+func (w *Watcher) processRequest(path string) {
+	request, err := ingest.TakeRequest(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			w.logger.Warnf("Error reading ingest request %s: %v", path, err)
+		}
+		return
+	}
+
+	nodeInfo := w.processor.extractNodeInfo(request.File)
+
+	w.logger.Infof("Processing queued cluster GFS file: %s (node=%s, type=%s)",
+		request.File, nodeInfo.Name, nodeInfo.Type)
+
+	report, err := w.processor.processFile(nodeInfo)
+	if err != nil {
+		w.logger.Warnf("Error processing %s: %v", request.File, err)
+	}
+	w.warnUnclean(nodeInfo, report)
+}
+
+// warnUnclean logs the parse report of an archive that was only partly read
+func (w *Watcher) warnUnclean(nodeInfo NodeInfo, report *gfs.ParseReport) {
+	if report != nil && !report.Clean() {
+		w.logger.Warnf("%s archive %s: %s", nodeInfo.Name, nodeInfo.FilePath, report)
+	}
+}
@@ -1,31 +1,331 @@
 package config
 
 import (
-	"os"
-
-	"gopkg.in/yaml.v3"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
 )
 
+// labelNamePattern matches valid Prometheus label names
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 type Config struct {
-	MetricPrefix   string                       `yaml:"metric_prefix"`
-	MetricMappings map[string]MetricMapping     `yaml:"metric_mappings"`
-	LabelMappings  map[string]string            `yaml:"label_mappings"`
-	Filters        Filters                      `yaml:"filters"`
+	MetricPrefix   string                   `yaml:"metric_prefix"`
+	MetricMappings map[string]MetricMapping `yaml:"metric_mappings"`
+	// LabelMappings rename labels: every label written under a key, such
+	// as resource_type or instance, is written under its value instead
+	LabelMappings map[string]string `yaml:"label_mappings"`
+	Filters       Filters           `yaml:"filters"`
+
+	ValueCorrections []ValueCorrection `yaml:"value_corrections"`
+	// Prefixes replace MetricPrefix for the files they match, the first
+	// matching rule applying
+	Prefixes []PrefixRule `yaml:"prefixes"`
+
+	// NumericIDLabel, if set, is the label that carries the numeric id of
+	// every instance, such as the PID of a process or a connection id, so
+	// that instances sharing a name get series of their own.
+	// NumericIDTypes limits it to those resource types.
+	NumericIDLabel string   `yaml:"numeric_id_label"`
+	NumericIDTypes []string `yaml:"numeric_id_types"`
+
+	// SourceLabels label the series of every instance with the archive
+	// they come from. ArchiveInfo writes a <prefix>_archive_info series
+	// identifying every archive converted.
+	SourceLabels SourceLabels `yaml:"source_labels"`
+	ArchiveInfo  bool         `yaml:"archive_info"`
+
+	// MetricNameTemplate, if set, is a Go template that names every stat
+	// in place of <prefix>_<resource type>_<stat>, executed with
+	// MetricNameData. Counters still get the _total suffix.
+	MetricNameTemplate string `yaml:"metric_name_template"`
+
+	// DerivedMetrics are computed from the stats of every instance of
+	// their resource type and written alongside them
+	DerivedMetrics []DerivedMetric `yaml:"derived_metrics"`
+
+	// TSDB tunes the TSDB the converters write to, where the --tsdb-*
+	// flags are not given
+	TSDB TSDB `yaml:"tsdb"`
+
+	// mappingPatterns are the keys of MetricMappings that are glob
+	// patterns, sorted, and profileMappings those that come from the
+	// profile rather than the config file
+	mappingPatterns []string
+	profileMappings map[string]bool
+	// nameTemplate is MetricNameTemplate parsed
+	nameTemplate *template.Template
 }
 
+// SourceLabels selects the labels that tell which archive a sample comes
+// from when several archives of a node are converted. Each adds a series
+// per archive, so they are off unless set.
+type SourceLabels struct {
+	// File adds a source_file label with the file name of the archive
+	File bool `yaml:"file"`
+	// SystemID adds a system_id label with the system id of the archive
+	// header, which changes when the member restarts
+	SystemID bool `yaml:"system_id"`
+	// Host adds a host label with the host name of the archive header's
+	// machine info, and OS an os label with its OS info; archives that
+	// record none get no label
+	Host bool `yaml:"host"`
+	OS   bool `yaml:"os"`
+}
+
+// TSDB holds the settings of the TSDB written to, each a duration such as
+// 720h; a setting left out keeps its default
+type TSDB struct {
+	Retention        time.Duration `yaml:"retention"`
+	OutOfOrderWindow time.Duration `yaml:"out_of_order_window"`
+	MinBlockDuration time.Duration `yaml:"min_block_duration"`
+	MaxBlockDuration time.Duration `yaml:"max_block_duration"`
+}
+
+// MetricMapping renames, relabels or drops the stats it is keyed by in
+// MetricMappings: "ResourceType.stat", the stat's default metric name, or
+// a glob pattern matching either
 type MetricMapping struct {
 	Name   string            `yaml:"name"`
 	Labels map[string]string `yaml:"labels"`
 	Drop   bool              `yaml:"drop"`
+	// InstanceLabel, if set, is the label that carries the instance name;
+	// the labels that identify the instance, resource_type and instance
+	// or the legacy statType and statName, are then left out
+	InstanceLabel string `yaml:"instance_label"`
+	// Min and Max, if set, bound the values of the stat that are valid;
+	// samples outside them are skipped as invalid
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+	// AsStateMetric writes the stat as a state metric: a sample of 1 in a
+	// series labeled with its value, written only where the value changes,
+	// for flags and enumerations
+	AsStateMetric bool `yaml:"as_state_metric"`
 }
 
+// Valid reports whether a value read for the stat is within the mapping's
+// Min and Max
+func (m MetricMapping) Valid(value float64) bool {
+	return (m.Min == nil || value >= *m.Min) && (m.Max == nil || value <= *m.Max)
+}
+
+// MappingFor returns the metric mapping of a stat whose default metric
+// name is metricName, with its key: the one keyed "ResourceType.stat" or
+// by the metric name, otherwise the first pattern in sorted order that
+// matches either
+func (c *Config) MappingFor(resourceType, stat, metricName string) (MetricMapping, string, bool) {
+	qualified := resourceType + "." + stat
+	for _, key := range []string{qualified, metricName} {
+		if mapping, ok := c.MetricMappings[key]; ok {
+			return mapping, key, true
+		}
+	}
+	for _, key := range c.mappingPatterns {
+		if matchName(key, qualified) || matchName(key, metricName) {
+			return c.MetricMappings[key], key, true
+		}
+	}
+	return MetricMapping{}, "", false
+}
+
+// ProfileMapping reports whether the metric mapping keyed key comes from
+// the profile, which is expected to map stats a given archive may not have
+func (c *Config) ProfileMapping(key string) bool {
+	return c.profileMappings[key]
+}
+
+// prepareMappings checks the keys of the metric mappings that are glob
+// patterns and sorts them for MappingFor, and checks that no mapping's Min
+// is above its Max
+func (c *Config) prepareMappings() error {
+	c.mappingPatterns = nil
+	for key, mapping := range c.MetricMappings {
+		if mapping.Min != nil && mapping.Max != nil && *mapping.Min > *mapping.Max {
+			return fmt.Errorf("metric mapping %q has min %g above max %g", key, *mapping.Min, *mapping.Max)
+		}
+		if !strings.ContainsAny(key, `*?[\`) {
+			continue
+		}
+		if _, err := path.Match(key, ""); err != nil {
+			return fmt.Errorf("invalid metric mapping pattern %q: %w", key, err)
+		}
+		c.mappingPatterns = append(c.mappingPatterns, key)
+	}
+	sort.Strings(c.mappingPatterns)
+	return nil
+}
+
+// Filters select the resource types, instances and stats that are
+// converted. Every entry is a pattern in path.Match syntax, so
+// "StatSampler*" matches every type whose name starts with StatSampler;
+// stat patterns match either the bare stat name or "ResourceType.stat",
+// and instance patterns the instance's text id.
 type Filters struct {
 	IncludeResourceTypes []string `yaml:"include_resource_types"`
 	ExcludeResourceTypes []string `yaml:"exclude_resource_types"`
+	IncludeInstances     []string `yaml:"include_instances"`
+	ExcludeInstances     []string `yaml:"exclude_instances"`
 	IncludeStats         []string `yaml:"include_stats"`
 	ExcludeStats         []string `yaml:"exclude_stats"`
 }
 
+// IncludesType reports whether a resource type passes the type filters:
+// it matches the include list, or the list is empty, and not the exclude
+// list
+func (f Filters) IncludesType(resourceType string) bool {
+	included := len(f.IncludeResourceTypes) == 0 || matchesAny(f.IncludeResourceTypes, resourceType)
+	return included && !matchesAny(f.ExcludeResourceTypes, resourceType)
+}
+
+// InstanceFilter returns the instance filter that leaves an instance out,
+// "" if the instance passes them: the first exclude_instances pattern that
+// matches it, or include_instances if none of those patterns matches it
+func (f Filters) InstanceFilter(instance string) string {
+	if len(f.IncludeInstances) > 0 && !matchesAny(f.IncludeInstances, instance) {
+		return "include_instances"
+	}
+	for _, pattern := range f.ExcludeInstances {
+		if matchName(pattern, instance) {
+			return "exclude_instances: " + pattern
+		}
+	}
+	return ""
+}
+
+// IncludesStat reports whether a stat of a resource type passes the stat
+// filters, the same way IncludesType does for types
+func (f Filters) IncludesStat(resourceType, stat string) bool {
+	qualified := resourceType + "." + stat
+	included := len(f.IncludeStats) == 0 || matchesAny(f.IncludeStats, stat) || matchesAny(f.IncludeStats, qualified)
+	return included && !matchesAny(f.ExcludeStats, stat) && !matchesAny(f.ExcludeStats, qualified)
+}
+
+// validateFilters checks that every filter is a valid pattern
+func (c *Config) validateFilters() error {
+	lists := []struct {
+		name     string
+		patterns []string
+	}{
+		{"include_resource_types", c.Filters.IncludeResourceTypes},
+		{"exclude_resource_types", c.Filters.ExcludeResourceTypes},
+		{"include_instances", c.Filters.IncludeInstances},
+		{"exclude_instances", c.Filters.ExcludeInstances},
+		{"include_stats", c.Filters.IncludeStats},
+		{"exclude_stats", c.Filters.ExcludeStats},
+	}
+	for _, list := range lists {
+		for _, pattern := range list.patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid %s pattern %q: %w", list.name, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// AddInstanceFilters adds instance patterns given on the command line to
+// the config's include and exclude lists
+func (c *Config) AddInstanceFilters(include, exclude []string) error {
+	c.Filters.IncludeInstances = append(c.Filters.IncludeInstances, include...)
+	c.Filters.ExcludeInstances = append(c.Filters.ExcludeInstances, exclude...)
+	return c.validateFilters()
+}
+
+// matchName reports whether a filter pattern matches name
+func matchName(pattern, name string) bool {
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchName(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// NumericIDLabelFor returns the label that carries the numeric id of the
+// instances of a resource type, empty if they are not labeled with it
+func (c *Config) NumericIDLabelFor(resourceType string) string {
+	if len(c.NumericIDTypes) > 0 && !containsString(c.NumericIDTypes, resourceType) {
+		return ""
+	}
+	return c.NumericIDLabel
+}
+
+// validateNumericIDLabel checks that the numeric id label is a label name
+// that does not replace one of the labels of every instance
+func (c *Config) validateNumericIDLabel() error {
+	switch {
+	case c.NumericIDLabel == "":
+		if len(c.NumericIDTypes) > 0 {
+			return fmt.Errorf("numeric_id_types is set without numeric_id_label")
+		}
+		return nil
+	case !labelNamePattern.MatchString(c.NumericIDLabel) || strings.HasPrefix(c.NumericIDLabel, "__"):
+		return fmt.Errorf("numeric_id_label %q is not a valid label name", c.NumericIDLabel)
+	case containsString(instanceLabels, c.NumericIDLabel):
+		return fmt.Errorf("numeric_id_label %q would replace the instance's %s label", c.NumericIDLabel, c.NumericIDLabel)
+	}
+	return nil
+}
+
+// instanceLabels are the labels that identify the series of an instance,
+// as the converters write them and as they wrote them before
+var instanceLabels = []string{"resource_type", "instance", "job", "statType", "statName", "source_file", "system_id", "host", "os", "remapped"}
+
+// RenameLabels returns labels with the labels that have a label mapping
+// renamed, copying them first if any is
+func (c *Config) RenameLabels(labels map[string]string) map[string]string {
+	renamed := false
+	for name := range labels {
+		if _, ok := c.LabelMappings[name]; ok {
+			renamed = true
+			break
+		}
+	}
+	if !renamed {
+		return labels
+	}
+	result := make(map[string]string, len(labels))
+	for name, value := range labels {
+		if to, ok := c.LabelMappings[name]; ok {
+			name = to
+		}
+		result[name] = value
+	}
+	return result
+}
+
+// validateLabelMappings checks that every label mapping renames a label to
+// a valid label name that no other one renames to
+func (c *Config) validateLabelMappings() error {
+	names := make([]string, 0, len(c.LabelMappings))
+	for name := range c.LabelMappings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	renamedFrom := make(map[string]string, len(names))
+	for _, name := range names {
+		to := c.LabelMappings[name]
+		if !labelNamePattern.MatchString(to) || strings.HasPrefix(to, "__") {
+			return fmt.Errorf("label_mappings: %s is renamed to %q, which is not a valid label name", name, to)
+		}
+		if other, ok := renamedFrom[to]; ok {
+			return fmt.Errorf("label_mappings: %s and %s are both renamed to %s", other, name, to)
+		}
+		renamedFrom[to] = name
+	}
+	return nil
+}
+
 func Default() *Config {
 	return &Config{
 		MetricPrefix:   "gemfire",
@@ -36,15 +336,5 @@ func Default() *Config {
 }
 
 func Load(filename string) (*Config, error) {
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
-
-	cfg := Default()
-	if err := yaml.Unmarshal(data, cfg); err != nil {
-		return nil, err
-	}
-
-	return cfg, nil
-}
\ No newline at end of file
+	return LoadLayered("", filename)
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ValueCorrection rescales every sample of a stat as value*Multiply + Add.
+// Metric is either "ResourceType.stat" or the Prometheus metric name.
+// When Versions is set, the correction only applies to archives whose
+// product description matches one of the glob patterns.
+type ValueCorrection struct {
+	Metric   string   `yaml:"metric"`
+	Multiply *float64 `yaml:"multiply"`
+	Add      float64  `yaml:"add"`
+	Versions []string `yaml:"versions"`
+}
+
+// Name identifies the correction in reports
+func (vc *ValueCorrection) Name() string {
+	if len(vc.Versions) == 0 {
+		return vc.Metric
+	}
+	return fmt.Sprintf("%s [%s]", vc.Metric, strings.Join(vc.Versions, ", "))
+}
+
+// Describe formats the correction's arithmetic, e.g. "x0.001 +0"
+func (vc *ValueCorrection) Describe() string {
+	return fmt.Sprintf("x%g %+g", vc.factor(), vc.Add)
+}
+
+// Apply returns the corrected value
+func (vc *ValueCorrection) Apply(value float64) float64 {
+	return value*vc.factor() + vc.Add
+}
+
+func (vc *ValueCorrection) factor() float64 {
+	if vc.Multiply == nil {
+		return 1
+	}
+	return *vc.Multiply
+}
+
+// MatchesStat reports whether the correction targets the stat
+func (vc *ValueCorrection) MatchesStat(resourceType, stat, metricName string) bool {
+	return vc.Metric == resourceType+"."+stat || vc.Metric == metricName
+}
+
+// MatchesVersion reports whether the correction applies to an archive
+// written by product. Corrections without versions apply to every archive.
+func (vc *ValueCorrection) MatchesVersion(product string) bool {
+	if len(vc.Versions) == 0 {
+		return true
+	}
+	for _, pattern := range vc.Versions {
+		if matchGlob(pattern, product) {
+			return true
+		}
+	}
+	return false
+}
+
+// CorrectionFor returns the first correction for the stat that applies to
+// archives written by product
+func (c *Config) CorrectionFor(resourceType, stat, metricName, product string) (*ValueCorrection, bool) {
+	for i := range c.ValueCorrections {
+		vc := &c.ValueCorrections[i]
+		if vc.MatchesStat(resourceType, stat, metricName) && vc.MatchesVersion(product) {
+			return vc, true
+		}
+	}
+	return nil, false
+}
+
+func (c *Config) validateCorrections() error {
+	for i, vc := range c.ValueCorrections {
+		if vc.Metric == "" {
+			return fmt.Errorf("value_corrections[%d]: metric is required", i)
+		}
+	}
+	return nil
+}
+
+// matchGlob matches s against a pattern where * matches any run of
+// characters, including '/', which product descriptions may contain
+func matchGlob(pattern, s string) bool {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+	matched, _ := regexp.MatchString("^"+quoted+"$", s)
+	return matched
+}
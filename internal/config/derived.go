@@ -0,0 +1,290 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DerivedMetric is a metric computed from other stats of the same
+// instance. Expr is an arithmetic expression of numbers, such as 1e9, and
+// operands "ResourceType.stat", with + - * / and parentheses; every operand
+// must name the same resource type. Names are matched without regard to
+// case.
+type DerivedMetric struct {
+	Name string `yaml:"name"`
+	Expr string `yaml:"expr"`
+
+	// resourceType and operands are the resource type and the distinct
+	// stats the expression reads, and expr the expression parsed, reading
+	// operand i as values[i]
+	resourceType string
+	operands     []string
+	expr         expression
+}
+
+// ResourceType returns the resource type whose stats the metric is
+// computed from
+func (d *DerivedMetric) ResourceType() string {
+	return d.resourceType
+}
+
+// Operands returns the names of the stats the metric is computed from, in
+// the order Eval reads their values
+func (d *DerivedMetric) Operands() []string {
+	return d.operands
+}
+
+// Eval computes the metric from the values of its operands, in the order
+// of Operands, and returns false if it divides by zero or the result is
+// not a number
+func (d *DerivedMetric) Eval(values []float64) (float64, bool) {
+	v, ok := d.expr.eval(values)
+	if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v, true
+}
+
+// DerivedFor returns the derived metrics computed from stats of a
+// resource type
+func (c *Config) DerivedFor(resourceType string) []*DerivedMetric {
+	var metrics []*DerivedMetric
+	for i := range c.DerivedMetrics {
+		if strings.EqualFold(c.DerivedMetrics[i].resourceType, resourceType) {
+			metrics = append(metrics, &c.DerivedMetrics[i])
+		}
+	}
+	return metrics
+}
+
+// validateDerivedMetrics checks the names of the derived metrics and
+// parses their expressions
+func (c *Config) validateDerivedMetrics() error {
+	names := make(map[string]bool, len(c.DerivedMetrics))
+	for i := range c.DerivedMetrics {
+		d := &c.DerivedMetrics[i]
+		if !metricPrefixPattern.MatchString(d.Name) {
+			return fmt.Errorf("derived_metrics[%d]: %q is not a valid metric name", i, d.Name)
+		}
+		if names[d.Name] {
+			return fmt.Errorf("derived_metrics[%d]: metric %s is derived twice", i, d.Name)
+		}
+		names[d.Name] = true
+		if err := d.parse(); err != nil {
+			return fmt.Errorf("derived metric %s: %w", d.Name, err)
+		}
+	}
+	return nil
+}
+
+// expression is a parsed arithmetic expression
+type expression interface {
+	eval(values []float64) (float64, bool)
+}
+
+type constant float64
+
+func (e constant) eval([]float64) (float64, bool) {
+	return float64(e), true
+}
+
+// operand is the value of the stat Operands gives at its index
+type operand int
+
+func (e operand) eval(values []float64) (float64, bool) {
+	return values[e], true
+}
+
+type negation struct {
+	x expression
+}
+
+func (e negation) eval(values []float64) (float64, bool) {
+	x, ok := e.x.eval(values)
+	return -x, ok
+}
+
+type binary struct {
+	op   byte
+	x, y expression
+}
+
+func (e binary) eval(values []float64) (float64, bool) {
+	x, ok := e.x.eval(values)
+	if !ok {
+		return 0, false
+	}
+	y, ok := e.y.eval(values)
+	if !ok {
+		return 0, false
+	}
+	switch e.op {
+	case '+':
+		return x + y, true
+	case '-':
+		return x - y, true
+	case '*':
+		return x * y, true
+	default:
+		if y == 0 {
+			return 0, false
+		}
+		return x / y, true
+	}
+}
+
+// exprParser parses an expression by recursive descent:
+//
+//	sum     = product { ("+" | "-") product }
+//	product = unary { ("*" | "/") unary }
+//	unary   = "-" unary | "(" sum ")" | number | ResourceType.stat
+type exprParser struct {
+	d   *DerivedMetric
+	src string
+	pos int
+}
+
+func (d *DerivedMetric) parse() error {
+	d.resourceType, d.operands = "", nil
+	p := &exprParser{d: d, src: d.Expr}
+	expr, err := p.sum()
+	if err != nil {
+		return err
+	}
+	if p.skipSpace(); p.pos < len(p.src) {
+		return fmt.Errorf("unexpected %q at offset %d of %q", p.src[p.pos:], p.pos, p.src)
+	}
+	if len(d.operands) == 0 {
+		return fmt.Errorf("%q reads no stat", p.src)
+	}
+	d.expr = expr
+	return nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// next returns the next character that is not a space, or 0 at the end
+func (p *exprParser) next() byte {
+	p.skipSpace()
+	if p.pos == len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *exprParser) sum() (expression, error) {
+	x, err := p.product()
+	for err == nil {
+		op := p.next()
+		if op != '+' && op != '-' {
+			return x, nil
+		}
+		p.pos++
+		var y expression
+		if y, err = p.product(); err == nil {
+			x = binary{op: op, x: x, y: y}
+		}
+	}
+	return nil, err
+}
+
+func (p *exprParser) product() (expression, error) {
+	x, err := p.unary()
+	for err == nil {
+		op := p.next()
+		if op != '*' && op != '/' {
+			return x, nil
+		}
+		p.pos++
+		var y expression
+		if y, err = p.unary(); err == nil {
+			x = binary{op: op, x: x, y: y}
+		}
+	}
+	return nil, err
+}
+
+func (p *exprParser) unary() (expression, error) {
+	switch c := p.next(); {
+	case c == 0:
+		return nil, fmt.Errorf("%q ends early", p.src)
+	case c == '-':
+		p.pos++
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return negation{x}, nil
+	case c == '(':
+		p.pos++
+		x, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ')' {
+			return nil, fmt.Errorf("missing ) at offset %d of %q", p.pos, p.src)
+		}
+		p.pos++
+		return x, nil
+	case c == '.' || c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == '.' || p.src[p.pos] >= '0' && p.src[p.pos] <= '9') {
+			p.pos++
+		}
+		if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+			p.pos++
+			if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+				p.pos++
+			}
+			for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+				p.pos++
+			}
+		}
+		v, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in %q", p.src[start:p.pos], p.src)
+		}
+		return constant(v), nil
+	default:
+		return p.operand()
+	}
+}
+
+// operand parses ResourceType.stat, recording the stat as an operand
+func (p *exprParser) operand() (expression, error) {
+	start := p.pos
+	for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+	name := p.src[start:p.pos]
+	resourceType, stat, ok := strings.Cut(name, ".")
+	if !ok || resourceType == "" || stat == "" || strings.Contains(stat, ".") {
+		if name == "" {
+			return nil, fmt.Errorf("unexpected %q at offset %d of %q", p.src[start:], start, p.src)
+		}
+		return nil, fmt.Errorf("operand %q is not ResourceType.stat", name)
+	}
+	d := p.d
+	if d.resourceType == "" {
+		d.resourceType = resourceType
+	} else if !strings.EqualFold(d.resourceType, resourceType) {
+		return nil, fmt.Errorf("operands %s.* and %s.* are of different resource types", d.resourceType, resourceType)
+	}
+	for i, o := range d.operands {
+		if strings.EqualFold(o, stat) {
+			return operand(i), nil
+		}
+	}
+	d.operands = append(d.operands, stat)
+	return operand(len(d.operands) - 1), nil
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
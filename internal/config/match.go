@@ -0,0 +1,259 @@
+package config
+
+import (
+	"sort"
+	"sync"
+)
+
+// RuleResult reports how many distinct resource types, stats and instances
+// a single config rule matched
+type RuleResult struct {
+	Kind      string
+	Rule      string
+	Types     int
+	Stats     int
+	Instances int
+}
+
+type ruleCounter struct {
+	kind      string
+	rule      string
+	types     map[string]bool
+	stats     map[string]bool
+	instances map[string]bool
+}
+
+func (r *ruleCounter) record(resourceType, instance, stat string) {
+	r.types[resourceType] = true
+	r.instances[resourceType+"/"+instance] = true
+	if stat != "" {
+		r.stats[resourceType+"."+stat] = true
+	}
+}
+
+// PrefixResult reports the metric prefix a file was given and the prefix
+// rule that chose it, empty for the configured metric prefix
+type PrefixResult struct {
+	File   string
+	Prefix string
+	Rule   string
+}
+
+// Matcher applies a config's filters and metric mappings and counts what
+// each rule matched, so rules that never match real data can be found
+type Matcher struct {
+	cfg *Config
+
+	mu    sync.Mutex
+	rules []*ruleCounter
+	index map[string]*ruleCounter
+
+	prefixes []PrefixResult
+	// prefixRule is the prefix rule of the file being matched, if any
+	prefixRule string
+}
+
+func NewMatcher(cfg *Config) *Matcher {
+	m := &Matcher{cfg: cfg, index: make(map[string]*ruleCounter)}
+
+	for _, name := range cfg.Filters.IncludeResourceTypes {
+		m.addRule("include_resource_types", name)
+	}
+	for _, name := range cfg.Filters.ExcludeResourceTypes {
+		m.addRule("exclude_resource_types", name)
+	}
+	for _, name := range cfg.Filters.IncludeInstances {
+		m.addRule("include_instances", name)
+	}
+	for _, name := range cfg.Filters.ExcludeInstances {
+		m.addRule("exclude_instances", name)
+	}
+	for _, name := range cfg.Filters.IncludeStats {
+		m.addRule("include_stats", name)
+	}
+	for _, name := range cfg.Filters.ExcludeStats {
+		m.addRule("exclude_stats", name)
+	}
+
+	for i := range cfg.ValueCorrections {
+		m.addRule("value_corrections", cfg.ValueCorrections[i].Name())
+	}
+	for i := range cfg.Prefixes {
+		m.addRule("prefixes", cfg.Prefixes[i].Name())
+	}
+
+	keys := make([]string, 0, len(cfg.MetricMappings))
+	for key := range cfg.MetricMappings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		m.addRule("metric_mappings", key)
+	}
+
+	return m
+}
+
+func (m *Matcher) addRule(kind, rule string) {
+	key := kind + ":" + rule
+	if _, ok := m.index[key]; ok {
+		return
+	}
+	counter := &ruleCounter{
+		kind:      kind,
+		rule:      rule,
+		types:     make(map[string]bool),
+		stats:     make(map[string]bool),
+		instances: make(map[string]bool),
+	}
+	m.rules = append(m.rules, counter)
+	m.index[key] = counter
+}
+
+func (m *Matcher) match(kind, rule, resourceType, instance, stat string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if counter, ok := m.index[kind+":"+rule]; ok {
+		counter.record(resourceType, instance, stat)
+	}
+}
+
+// Prefix returns the metric prefix of a file, as Config.PrefixFor does, and
+// records it for PrefixResults. The types matched until the next file
+// count towards the prefix rule that chose it.
+func (m *Matcher) Prefix(path, cluster, product string) string {
+	prefix, rule := m.cfg.PrefixFor(path, cluster, product)
+	result := PrefixResult{File: path, Prefix: prefix}
+	if rule != nil {
+		result.Rule = rule.Name()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prefixes = append(m.prefixes, result)
+	m.prefixRule = result.Rule
+	return prefix
+}
+
+// IncludeType reports whether the resource type passes the type filters
+func (m *Matcher) IncludeType(resourceType, instance string) bool {
+	filters := m.cfg.Filters
+
+	included := len(filters.IncludeResourceTypes) == 0
+	for _, name := range filters.IncludeResourceTypes {
+		if matchName(name, resourceType) {
+			m.match("include_resource_types", name, resourceType, instance, "")
+			included = true
+		}
+	}
+
+	for _, name := range filters.ExcludeResourceTypes {
+		if matchName(name, resourceType) {
+			m.match("exclude_resource_types", name, resourceType, instance, "")
+			included = false
+		}
+	}
+
+	if included {
+		m.mu.Lock()
+		rule := m.prefixRule
+		m.mu.Unlock()
+		if rule != "" {
+			m.match("prefixes", rule, resourceType, instance, "")
+		}
+	}
+	return included
+}
+
+// IncludeInstance reports whether the instance passes the instance
+// filters
+func (m *Matcher) IncludeInstance(resourceType, instance string) bool {
+	filters := m.cfg.Filters
+
+	included := len(filters.IncludeInstances) == 0
+	for _, name := range filters.IncludeInstances {
+		if matchName(name, instance) {
+			m.match("include_instances", name, resourceType, instance, "")
+			included = true
+		}
+	}
+
+	for _, name := range filters.ExcludeInstances {
+		if matchName(name, instance) {
+			m.match("exclude_instances", name, resourceType, instance, "")
+			included = false
+		}
+	}
+	return included
+}
+
+// IncludeStat reports whether the stat passes the stat filters. Stat
+// filter patterns match either the bare stat name or "ResourceType.stat".
+func (m *Matcher) IncludeStat(resourceType, instance, stat string) bool {
+	filters := m.cfg.Filters
+	qualified := resourceType + "." + stat
+
+	included := len(filters.IncludeStats) == 0
+	for _, name := range filters.IncludeStats {
+		if matchName(name, stat) || matchName(name, qualified) {
+			m.match("include_stats", name, resourceType, instance, stat)
+			included = true
+		}
+	}
+
+	for _, name := range filters.ExcludeStats {
+		if matchName(name, stat) || matchName(name, qualified) {
+			m.match("exclude_stats", name, resourceType, instance, stat)
+			included = false
+		}
+	}
+
+	return included
+}
+
+// Mapping returns the metric mapping of a stat whose default metric name
+// is metricName, as Config.MappingFor does, if any
+func (m *Matcher) Mapping(resourceType, instance, stat, metricName string) (MetricMapping, bool) {
+	mapping, key, ok := m.cfg.MappingFor(resourceType, stat, metricName)
+	if ok {
+		m.match("metric_mappings", key, resourceType, instance, stat)
+	}
+	return mapping, ok
+}
+
+// Correction returns the value correction for the stat in archives written
+// by product, if any
+func (m *Matcher) Correction(resourceType, instance, stat, metricName, product string) (*ValueCorrection, bool) {
+	vc, ok := m.cfg.CorrectionFor(resourceType, stat, metricName, product)
+	if ok {
+		m.match("value_corrections", vc.Name(), resourceType, instance, stat)
+	}
+	return vc, ok
+}
+
+// PrefixResults returns the prefix of every file passed to Prefix, in order
+func (m *Matcher) PrefixResults() []PrefixResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]PrefixResult(nil), m.prefixes...)
+}
+
+// Results returns the match counts of every rule: filters, value
+// corrections and prefix rules in config order, then metric mappings
+// sorted by key
+func (m *Matcher) Results() []RuleResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := make([]RuleResult, 0, len(m.rules))
+	for _, r := range m.rules {
+		results = append(results, RuleResult{
+			Kind:      r.kind,
+			Rule:      r.rule,
+			Types:     len(r.types),
+			Stats:     len(r.stats),
+			Instances: len(r.instances),
+		})
+	}
+	return results
+}
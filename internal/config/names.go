@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// MetricNameData is what metric_name_template is executed with for every
+// stat: the metric prefix of its file and the names of its resource type
+// and of the stat as the archive gives them
+type MetricNameData struct {
+	Prefix       string
+	ResourceType string
+	Stat         string
+}
+
+// metricNameFuncs are the functions metric_name_template can call
+var metricNameFuncs = template.FuncMap{
+	"snake": Snake,
+	"lower": strings.ToLower,
+}
+
+// Snake lowercases a name and replaces its spaces and hyphens with
+// underscores, as the default metric names do with stat names
+func Snake(name string) string {
+	name = strings.ToLower(strings.ReplaceAll(name, " ", "_"))
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+// MetricName returns the name metric_name_template gives a stat of a file
+// with the metric prefix prefix, and false if the template is not set. A
+// name that is not a valid Prometheus metric name is an error naming the
+// stat.
+func (c *Config) MetricName(prefix, resourceType, stat string) (string, bool, error) {
+	if c.nameTemplate == nil {
+		return "", false, nil
+	}
+	var name strings.Builder
+	data := MetricNameData{Prefix: prefix, ResourceType: resourceType, Stat: stat}
+	if err := c.nameTemplate.Execute(&name, data); err != nil {
+		return "", true, fmt.Errorf("metric_name_template failed for %s.%s: %w", resourceType, stat, err)
+	}
+	if !metricPrefixPattern.MatchString(name.String()) {
+		return "", true, fmt.Errorf("metric_name_template names %s.%s %q, which is not a valid metric name", resourceType, stat, name.String())
+	}
+	return name.String(), true, nil
+}
+
+// validateMetricNameTemplate parses the metric name template and checks
+// that it names a plain stat validly, so that a template that cannot
+// fails before any file is read
+func (c *Config) validateMetricNameTemplate() error {
+	c.nameTemplate = nil
+	if c.MetricNameTemplate == "" {
+		return nil
+	}
+	tmpl, err := template.New("metric_name_template").Funcs(metricNameFuncs).Parse(c.MetricNameTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid metric_name_template: %w", err)
+	}
+	c.nameTemplate = tmpl.Option("missingkey=error")
+
+	prefix, _ := c.PrefixFor("", "", "")
+	_, _, err = c.MetricName(prefix, "CachePerfStats", "gets")
+	return err
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/glob"
+)
+
+// Dialects of the products that write archives, as matched by prefix rules
+const (
+	DialectGemFire = "gemfire"
+	DialectGeode   = "geode"
+)
+
+// PrefixRule gives the files it matches their own metric prefix. Every
+// condition that is set must hold: Dialect is the product that wrote the
+// archive, Cluster the name of the cluster it is imported as and Path a
+// glob, where "**" matches any number of directories, over the file path.
+type PrefixRule struct {
+	Prefix  string `yaml:"prefix"`
+	Dialect string `yaml:"dialect"`
+	Cluster string `yaml:"cluster"`
+	Path    string `yaml:"path"`
+}
+
+// Name identifies the rule in reports, e.g. "geode: dialect=geode"
+func (p *PrefixRule) Name() string {
+	var conditions []string
+	if p.Dialect != "" {
+		conditions = append(conditions, "dialect="+p.Dialect)
+	}
+	if p.Cluster != "" {
+		conditions = append(conditions, "cluster="+p.Cluster)
+	}
+	if p.Path != "" {
+		conditions = append(conditions, "path="+p.Path)
+	}
+	return p.Prefix + ": " + strings.Join(conditions, " ")
+}
+
+// Matches reports whether the rule applies to the file at path, imported
+// as part of cluster and written by product. Either may be empty when it
+// is not known, which no condition on it matches.
+func (p *PrefixRule) Matches(path, cluster, product string) bool {
+	if p.Dialect != "" && p.Dialect != Dialect(product) {
+		return false
+	}
+	if p.Cluster != "" && p.Cluster != cluster {
+		return false
+	}
+	if p.Path != "" {
+		matched, _ := glob.Match(p.Path, filepath.Clean(path))
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// covers reports whether the rule matches every file that other matches,
+// because each of its conditions is unset or the same as other's
+func (p *PrefixRule) covers(other *PrefixRule) bool {
+	return (p.Dialect == "" || p.Dialect == other.Dialect) &&
+		(p.Cluster == "" || p.Cluster == other.Cluster) &&
+		(p.Path == "" || p.Path == other.Path)
+}
+
+// Dialect returns the dialect of an archive from its product description,
+// "" if it names neither product. GemFire releases built on Geode mention
+// both, so GemFire is looked for first.
+func Dialect(product string) string {
+	lower := strings.ToLower(product)
+	switch {
+	case strings.Contains(lower, "gemfire"):
+		return DialectGemFire
+	case strings.Contains(lower, "geode"):
+		return DialectGeode
+	}
+	return ""
+}
+
+// PrefixFor returns the metric prefix of a file and the first prefix rule
+// that matches it, or the configured metric prefix and nil if none does
+func (c *Config) PrefixFor(path, cluster, product string) (string, *PrefixRule) {
+	for i := range c.Prefixes {
+		if rule := &c.Prefixes[i]; rule.Matches(path, cluster, product) {
+			return rule.Prefix, rule
+		}
+	}
+	if c.MetricPrefix == "" {
+		return "gemfire", nil
+	}
+	return c.MetricPrefix, nil
+}
+
+// metricPrefixPattern is what a prefix must look like to start a valid
+// Prometheus metric name
+var metricPrefixPattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+func (c *Config) validatePrefixes() error {
+	for i := range c.Prefixes {
+		rule := &c.Prefixes[i]
+		if !metricPrefixPattern.MatchString(rule.Prefix) {
+			return fmt.Errorf("prefixes[%d]: invalid prefix %q", i, rule.Prefix)
+		}
+		if rule.Dialect == "" && rule.Cluster == "" && rule.Path == "" {
+			return fmt.Errorf("prefixes[%d]: at least one of dialect, cluster or path is required", i)
+		}
+		if rule.Dialect != "" && rule.Dialect != DialectGemFire && rule.Dialect != DialectGeode {
+			return fmt.Errorf("prefixes[%d]: unknown dialect %q (expected %s or %s)", i, rule.Dialect, DialectGemFire, DialectGeode)
+		}
+		if rule.Path != "" {
+			if err := glob.Validate(rule.Path); err != nil {
+				return fmt.Errorf("prefixes[%d]: invalid path pattern %q: %w", i, rule.Path, err)
+			}
+		}
+
+		// A rule that an earlier one covers never applies, which is only a
+		// mistake worth failing for when it asks for a different prefix
+		for j := 0; j < i; j++ {
+			earlier := &c.Prefixes[j]
+			if earlier.covers(rule) && earlier.Prefix != rule.Prefix {
+				return fmt.Errorf("prefixes[%d] (%s) conflicts with prefixes[%d] (%s), which matches every file it does", i, rule.Name(), j, earlier.Name())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Preset is a curated set of resource types for a common use case,
+// selected with --preset
+type Preset struct {
+	Name        string
+	Description string
+	// ResourceTypes are the types the preset includes; nil includes every
+	// type
+	ResourceTypes []string
+}
+
+var presets = []Preset{
+	{
+		Name:        "capacity",
+		Description: "Heap, CPU, region sizes and disk usage",
+		ResourceTypes: []string{
+			"VMStats",
+			"VMMemoryUsageStats",
+			"StatSampler",
+			"PartitionedRegionStats",
+			"DiskStoreStatistics",
+			"DiskDirStatistics",
+			"LinuxSystemStats",
+		},
+	},
+	{
+		Name:        "clients",
+		Description: "Client connections, queues and subscriptions",
+		ResourceTypes: []string{
+			"CacheClientProxyStatistics",
+			"CacheServerStats",
+			"CacheClientNotifierStatistics",
+			"ClientSubscriptionStats",
+			"ClientHealthStats",
+			"PoolStats",
+		},
+	},
+	{
+		Name:        "gc",
+		Description: "Garbage collection and heap pressure",
+		ResourceTypes: []string{
+			"VMGCStats",
+			"VMMemoryPoolStats",
+			"VMMemoryUsageStats",
+			"VMStats",
+			"StatSampler",
+			"ResourceManagerStats",
+		},
+	},
+	{
+		Name:        "all",
+		Description: "Every resource type",
+	},
+}
+
+// Presets returns the built-in presets
+func Presets() []Preset {
+	return presets
+}
+
+// PresetNames returns the names of the built-in presets
+func PresetNames() []string {
+	names := make([]string, len(presets))
+	for i, p := range presets {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// ApplyPresets adds the resource types of the named presets to the include
+// filter, so several presets and the config's own includes combine. The
+// all preset removes the include filter instead. Exclude filters still
+// apply either way.
+func (c *Config) ApplyPresets(names []string) error {
+	var types []string
+	all := false
+	for _, name := range names {
+		p, ok := lookupPreset(name)
+		if !ok {
+			return fmt.Errorf("unknown preset %q (available: %s)", name, strings.Join(PresetNames(), ", "))
+		}
+		if p.ResourceTypes == nil {
+			all = true
+		}
+		types = append(types, p.ResourceTypes...)
+	}
+
+	if all {
+		c.Filters.IncludeResourceTypes = nil
+		return nil
+	}
+	for _, t := range types {
+		if !containsString(c.Filters.IncludeResourceTypes, t) {
+			c.Filters.IncludeResourceTypes = append(c.Filters.IncludeResourceTypes, t)
+		}
+	}
+	return nil
+}
+
+func lookupPreset(name string) (Preset, bool) {
+	for _, p := range presets {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Preset{}, false
+}
+
+func containsString(values []string, v string) bool {
+	for _, existing := range values {
+		if existing == v {
+			return true
+		}
+	}
+	return false
+}
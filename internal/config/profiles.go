@@ -0,0 +1,95 @@
+package config
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profileFiles holds the built-in configs selected with --profile
+//
+//go:embed profiles/*.yaml
+var profileFiles embed.FS
+
+// Profiles returns the names of the built-in profiles
+func Profiles() []string {
+	entries, _ := fs.ReadDir(profileFiles, "profiles")
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".yaml"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadLayered returns the defaults, overlaid with the built-in profile and
+// then with the config file. Either may be empty. Entries of the file
+// replace the profile's: mappings by key, other settings as a whole.
+func LoadLayered(profile, filename string) (*Config, error) {
+	cfg := Default()
+
+	if profile != "" {
+		data, err := profileFiles.ReadFile(path.Join("profiles", profile+".yaml"))
+		if err != nil {
+			return nil, fmt.Errorf("unknown profile %q (available: %s)", profile, strings.Join(Profiles(), ", "))
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("invalid profile %s: %w", profile, err)
+		}
+		cfg.profileMappings = make(map[string]bool, len(cfg.MetricMappings))
+		for key := range cfg.MetricMappings {
+			cfg.profileMappings[key] = true
+		}
+	}
+
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, err
+		}
+		var file struct {
+			MetricMappings map[string]yaml.Node `yaml:"metric_mappings"`
+		}
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+		for key := range file.MetricMappings {
+			delete(cfg.profileMappings, key)
+		}
+	}
+
+	if err := cfg.validateCorrections(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validatePrefixes(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateFilters(); err != nil {
+		return nil, err
+	}
+	if err := cfg.prepareMappings(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateNumericIDLabel(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateLabelMappings(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateMetricNameTemplate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateDerivedMetrics(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
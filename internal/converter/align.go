@@ -0,0 +1,88 @@
+package converter
+
+import "time"
+
+// aligner resamples one series onto a grid of a fixed interval, aligned to
+// the Unix epoch, writing a sample at every grid point between its first
+// and its last sample: the latest value at or before the point for a
+// gauge, or for a counter the value interpolated linearly between the
+// samples around it. A counter that went back between them is taken to
+// have been reset at the later one, keeping the value before until then.
+// Grid points inside a gap between two samples longer than maxGap, if
+// set, are left empty.
+type aligner struct {
+	interval int64 // In milliseconds
+	maxGap   time.Duration
+	counter  bool
+	write    func(t time.Time, value float64) error
+
+	// prev is the latest sample, if any, and next the grid point to write
+	// next, in milliseconds
+	prev    time.Time
+	value   float64
+	hasPrev bool
+	next    int64
+	// samples counts the samples added
+	samples int
+}
+
+// newAligner returns an aligner for a series of a counter stat or of a
+// gauge that writes its grid points with write
+func newAligner(interval, maxGap time.Duration, counter bool, write func(t time.Time, value float64) error) *aligner {
+	return &aligner{interval: interval.Milliseconds(), maxGap: maxGap, counter: counter, write: write}
+}
+
+// add adds the next sample of the series, writing the grid points up to
+// it. A sample that is not after the one before it, kept out of order by
+// TimeJumpsKeep, is left out.
+func (a *aligner) add(t time.Time, value float64) error {
+	if a.hasPrev && !t.After(a.prev) {
+		return nil
+	}
+	a.samples++
+	at := t.UnixMilli()
+	if !a.hasPrev || (a.maxGap > 0 && t.Sub(a.prev) > a.maxGap) {
+		// Nothing is written before the first sample or inside a gap
+		a.next = ceilMultiple(at, a.interval)
+	} else {
+		for ; a.next < at; a.next += a.interval {
+			if err := a.write(time.UnixMilli(a.next), a.interpolate(a.next, t, value)); err != nil {
+				return err
+			}
+		}
+	}
+	a.prev, a.value, a.hasPrev = t, value, true
+	if a.next == at {
+		a.next += a.interval
+		return a.write(t, value)
+	}
+	return nil
+}
+
+// interpolate returns the value of the grid point at, in milliseconds,
+// between the latest sample and the next, at t with value
+func (a *aligner) interpolate(at int64, t time.Time, value float64) float64 {
+	if !a.counter || value < a.value {
+		return a.value
+	}
+	prev := a.prev.UnixMilli()
+	return a.value + (value-a.value)*float64(at-prev)/float64(t.UnixMilli()-prev)
+}
+
+// flush does nothing, as no grid point is written after the last sample
+func (a *aligner) flush() error {
+	return nil
+}
+
+func (a *aligner) added() int {
+	return a.samples
+}
+
+// ceilMultiple returns the smallest multiple of interval at or after ms
+func ceilMultiple(ms, interval int64) int64 {
+	q := ms / interval * interval
+	if q < ms {
+		q += interval
+	}
+	return q
+}
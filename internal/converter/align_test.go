@@ -0,0 +1,36 @@
+package converter_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// TestAlign checks what aligning a gauge and a counter sampled at
+// irregular intervals to a grid writes: a sample at every grid point from
+// the first after the first sample to the last before the last sample,
+// none in a gap longer than the largest one bridged, the gauge's latest
+// value, the counter's interpolated between the samples around the point
+// and held across a reset. The counter is reset before the fourth sample,
+// which is followed by the gap, and the sixth sample falls on a grid
+// point.
+func TestAlign(t *testing.T) {
+	const interval, maxGap = 5 * time.Second, 8 * time.Second
+	base := testStart.Truncate(interval)
+	seconds := []int{1, 4, 7, 11, 22, 25, 26}
+	archive := writeInstance(t, t.TempDir(), gfstest.Instance{
+		Start:   base,
+		Stats:   counterAndGauge,
+		Seconds: seconds,
+		Values: [][]float64{
+			{10, 40, 70, 20, 50, 70, 90},
+			{3, 5, 2, 8, 1, 4, 6},
+		},
+	})
+	checkTransformed(t, archive, base, seconds[len(seconds)-1]+5, converter.Options{Align: interval, AlignMaxGap: maxGap}, map[string][]timedValue{
+		"gemfire_selfteststats0_entries":          {{5, 5}, {10, 2}, {25, 4}},
+		"gemfire_selfteststats0_operations_total": {{5, 50}, {10, 70}, {25, 70}},
+	})
+}
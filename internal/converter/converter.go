@@ -1,59 +1,790 @@
 package converter
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/4n3w/gfs-to-prometheus/internal/config"
+	"github.com/4n3w/gfs-to-prometheus/internal/enrich"
 	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+	"github.com/4n3w/gfs-to-prometheus/internal/provenance"
+	"github.com/4n3w/gfs-to-prometheus/internal/throttle"
 	"github.com/4n3w/gfs-to-prometheus/internal/tsdb"
+	"github.com/4n3w/gfs-to-prometheus/pkg/events"
 )
 
 type Converter struct {
-	writer *tsdb.Writer
-	config *config.Config
+	writer     *tsdb.Writer
+	config     *config.Config
+	enricher   *enrich.Enricher
+	opts       Options
+	history    *provenance.History
+	configHash string
+
+	writeLimiter *throttle.Bucket
+	readLimiter  *throttle.Bucket
+
+	totalsMu sync.Mutex
+	totals   runTotals
+
+	chronology chronology
+
+	// interrupted is set by Interrupt
+	interrupted atomic.Bool
+
+	// futures tracks the samples dated after the future limit of the
+	// files being converted
+	futures futures
+
+	// remaps holds the offsets of the files moved to the present
+	remaps remaps
+
+	descriptorsMu       sync.Mutex
+	descriptors         map[string][]descriptorVariant
+	descriptorConflicts []DescriptorConflict
+
+	// Keys of the metric mappings that matched a stat, and whether any
+	// stat was looked up
+	mappingsMu      sync.Mutex
+	mappingsUsed    map[string]bool
+	mappingsChecked bool
+
+	// Instances left out by each instance filter, by the filter's name
+	instancesMu       sync.Mutex
+	instancesFiltered map[string]int
+
+	// HELP and TYPE of the stat metrics written, by metric name
+	metadataMu sync.Mutex
+	metadata   map[string][]MetricMetadata
+
+	logger logging.Logger
+}
+
+// Options holds optional behaviour selected on the command line
+type Options struct {
+	// EnrichmentFile is a YAML file of join rules used to add labels
+	EnrichmentFile string
+
+	// GapThreshold is the longest interval between samples that is not
+	// reported as a sampling gap. Zero disables gap detection.
+	GapThreshold time.Duration
+	// EmitGapMetrics writes a <prefix>_sampling_gap_seconds sample at the
+	// start of every detected gap
+	EmitGapMetrics bool
+	// EmitInstanceCounts writes the number of live instances of each
+	// resource type as a <prefix>_instance_count series
+	EmitInstanceCounts bool
+
+	// ToolVersion is recorded in the import history of every file
+	ToolVersion string
+	// EmitImportInfo writes a <prefix>_import_info series for every
+	// converted file
+	EmitImportInfo bool
+
+	// MaxWriteRate caps the samples written per second (0 = unlimited)
+	MaxWriteRate float64
+	// MaxIORate caps the megabytes read from archives per second
+	// (0 = unlimited)
+	MaxIORate float64
+
+	// Events receives the JSON event stream; nil disables it
+	Events *events.Writer
+
+	// DescriptorConflicts is the policy for stats whose unit or counter
+	// flag changes between files: ConflictSuffix (default),
+	// ConflictNormalize or ConflictFail
+	DescriptorConflicts string
+
+	// Profile is a built-in config layered under the config file, e.g.
+	// geode-mixin
+	Profile string
+	// Presets are built-in resource type selections added to the include
+	// filter, e.g. capacity
+	Presets []string
+	// IncludeInstances and ExcludeInstances are instance patterns added
+	// to the config's instance filters
+	IncludeInstances []string
+	ExcludeInstances []string
+
+	// StreamThreshold is the archive size in bytes above which files are
+	// converted while they are read, keeping only their metadata in
+	// memory. Zero disables streaming.
+	StreamThreshold int64
+
+	// PipelineBuffer, if positive, appends the samples of streamed and
+	// spilled archives on a goroutine of their own, with up to this many
+	// samples buffered between it and the one decoding them
+	PipelineBuffer int
+
+	// LowMemory decodes the samples of every archive into a temporary
+	// file and writes them from there, keeping only the archive's
+	// metadata in memory whatever its size
+	LowMemory bool
+
+	// Verify checks every archive's consistency while reading it, see
+	// gfs.StatArchiveReader.Verify; the verdict is in its parse report
+	Verify bool
+
+	// LegacyParser converts with the old GeodeParser instead of
+	// StatArchiveReader, as an escape hatch while the latter settles
+	LegacyParser bool
+
+	// Parser picks what reads archives: ParserGo (default), ParserJava or
+	// ParserAuto. It cannot be combined with LegacyParser.
+	Parser string
+
+	// LegacyLabels labels the series of convert with job, statType and
+	// statName, as before resource_type and instance were written by both
+	// convert and cluster
+	LegacyLabels bool
+
+	// TimeZoneMode selects how archive timestamps are adjusted for the
+	// archive's timezone: gfs.TimeZoneRaw (default), gfs.TimeZoneApply or
+	// gfs.TimeZoneStrip
+	TimeZoneMode string
+
+	// SampleErrors is the policy for damaged sample data:
+	// gfs.SampleErrorsLenient (default) salvages what it can and
+	// gfs.SampleErrorsStrict ends the archive at the first damaged record
+	SampleErrors string
+
+	// TimeJumps is the policy for samples recorded after a member's clock
+	// went back, until it passes the latest sample before: gfs.TimeJumpsDrop
+	// (default) drops them, gfs.TimeJumpsClamp moves them a millisecond
+	// after the latest sample and gfs.TimeJumpsKeep writes them as they are
+	// into the TSDB's out-of-order window
+	TimeJumps string
+
+	// OnError is the policy for samples that cannot be written and files
+	// that cannot be converted: OnErrorContinue (default), OnErrorSkipFile
+	// or OnErrorAbort. What was appended before a file ends is committed.
+	OnError string
+
+	// MaxSeries, if set, caps the distinct series the run writes, and
+	// OnCardinalityExceeded picks what happens to the series beyond:
+	// tsdb.SeriesLimitAbort (default) ends the run, tsdb.SeriesLimitDrop
+	// skips their samples. A dry run does not enforce the limit.
+	MaxSeries             int
+	OnCardinalityExceeded string
+
+	// MaxFuture, if set, is how far past the wall clock a sample may be
+	// dated, and FutureSamples what happens to those dated later:
+	// FutureDrop (default), FutureClamp or FutureKeep. Samples from hosts
+	// with broken clocks would otherwise make the TSDB head refuse the
+	// older samples appended after them.
+	MaxFuture     time.Duration
+	FutureSamples string
+
+	// RemapToNow moves every sample of an archive by the same offset, so
+	// that its last sample lands at RemapEnd, or the time the archive is
+	// first converted if RemapEnd is zero, and labels its series with
+	// LabelRemapped. It cannot be combined with LegacyParser, which does
+	// not know when an archive ends.
+	RemapToNow bool
+	RemapEnd   time.Time
+
+	// AdjustCounterResets keeps the series of counter stats monotonic by
+	// adding what a counter had reached before each decrease within an
+	// archive to the values after it, so rate() does not see the reset
+	AdjustCounterResets bool
+
+	// Downsample, if positive, reduces the samples of every series to one
+	// per interval of this length: the last value of a gauge or the
+	// largest of a counter, keeping the first and last samples as they are
+	Downsample time.Duration
+
+	// Align, if positive, resamples every series onto a grid of this
+	// interval instead: a sample per grid point between its first and last
+	// samples, carrying a gauge's latest value forward and interpolating a
+	// counter's. Grid points in a gap between samples longer than
+	// AlignMaxGap, if positive, are left empty.
+	Align       time.Duration
+	AlignMaxGap time.Duration
+
+	// RateWindow, if positive, also writes a <metric>:rate<window> series
+	// for every counter stat: its per-second rate over a trailing window of
+	// this length, computed from the archive's samples
+	RateWindow time.Duration
+
+	// Window limits the samples written from each archive to a time
+	// window; the zero value writes them all
+	Window TimeWindow
+
+	// DryRun converts without opening the TSDB or recording imports,
+	// only counting what would be written, as returned by the writer's
+	// Counts
+	DryRun bool
+
+	// Dedup selects which samples are skipped as duplicates of samples
+	// already written: tsdb.DedupRun (default) skips those in the time
+	// range of a series another file of the run wrote, tsdb.DedupTSDB also
+	// those in the range of a series the TSDB already held and
+	// tsdb.DedupOff writes every sample
+	Dedup string
+
+	// Backfill writes the samples into new blocks of the TSDB, one for
+	// every range of TSDB.MinBlockDuration with samples, instead of
+	// appending them through its head and WAL. The blocks are written when
+	// the converter is closed, the samples being held in memory until
+	// then, so a backfill cannot be resumed or dedup against the TSDB.
+	Backfill bool
+
+	// TSDB tunes the TSDB written to; its zero fields take their values
+	// from the config's tsdb section, or else tsdb.DefaultOptions
+	TSDB tsdb.Options
+
+	// CommitBatch is the number of samples appended to the TSDB between
+	// commits, bounding what the appender holds in memory and what a
+	// failed file loses. Zero uses tsdb.DefaultCommitBatch and a negative
+	// value commits only once a file is converted.
+	CommitBatch int
+
+	// PaddingThreshold is the shortest run of zero bytes ending an archive
+	// that is ignored as padding. Zero uses gfs.DefaultPaddingThreshold and
+	// a negative value disables it.
+	PaddingThreshold int
+
+	// ReadProgress is called while each archive is read, as by
+	// gfs.StatArchiveReader.SetProgressFunc. Files converted concurrently
+	// call it concurrently.
+	ReadProgress func(filename string, bytesRead, totalBytes int64, samples int)
+
+	// ReaderLimits bounds what corrupt lengths and counts in an archive
+	// can make the reader allocate; zero fields use the gfs defaults
+	ReaderLimits gfs.ReaderLimits
+
+	// Logger receives the converter's and the reader's logs; nil uses
+	// logging.Default()
+	Logger logging.Logger
 }
 
-func New(tsdbPath string, configFile string) (*Converter, error) {
-	writer, err := tsdb.NewWriter(tsdbPath)
+func New(tsdbPath string, configFile string, opts Options) (*Converter, error) {
+	switch opts.DescriptorConflicts {
+	case "", ConflictSuffix, ConflictNormalize, ConflictFail:
+	default:
+		return nil, fmt.Errorf("unknown descriptor conflict policy %q", opts.DescriptorConflicts)
+	}
+	if !gfs.ValidTimeZoneMode(opts.TimeZoneMode) {
+		return nil, fmt.Errorf("unknown timezone mode %q (expected raw, apply or strip)", opts.TimeZoneMode)
+	}
+	if !gfs.ValidSampleErrorPolicy(opts.SampleErrors) {
+		return nil, fmt.Errorf("unknown sample error policy %q (expected lenient or strict)", opts.SampleErrors)
+	}
+	if !gfs.ValidTimeJumpPolicy(opts.TimeJumps) {
+		return nil, fmt.Errorf("unknown time jump policy %q (expected drop, clamp or keep)", opts.TimeJumps)
+	}
+	if !ValidOnError(opts.OnError) {
+		return nil, fmt.Errorf("unknown error policy %q (expected continue, skip-file or abort)", opts.OnError)
+	}
+	if !ValidParser(opts.Parser) {
+		return nil, fmt.Errorf("unknown parser %q (expected go, java or auto)", opts.Parser)
+	}
+	if opts.LegacyParser && opts.Parser != "" && opts.Parser != ParserGo {
+		return nil, fmt.Errorf("the legacy parser cannot be combined with parser %s", opts.Parser)
+	}
+	if opts.MaxSeries < 0 {
+		return nil, fmt.Errorf("series limit %d is negative", opts.MaxSeries)
+	}
+	if !tsdb.ValidSeriesLimitPolicy(opts.OnCardinalityExceeded) {
+		return nil, fmt.Errorf("unknown cardinality policy %q (expected abort or drop)", opts.OnCardinalityExceeded)
+	}
+	if opts.MaxFuture < 0 {
+		return nil, fmt.Errorf("future limit %s is negative", opts.MaxFuture)
+	}
+	if !ValidFuturePolicy(opts.FutureSamples) {
+		return nil, fmt.Errorf("unknown future sample policy %q (expected drop, clamp or keep)", opts.FutureSamples)
+	}
+	if !opts.RemapEnd.IsZero() && !opts.RemapToNow {
+		return nil, fmt.Errorf("a remap end needs remapping to now")
+	}
+	if opts.RemapToNow && opts.LegacyParser {
+		return nil, fmt.Errorf("the legacy parser cannot be combined with remapping to now")
+	}
+	if opts.Backfill && opts.Dedup == tsdb.DedupTSDB {
+		return nil, fmt.Errorf("a backfill cannot dedup against the samples the TSDB holds")
+	}
+	if !tsdb.ValidDedupMode(opts.Dedup) {
+		return nil, fmt.Errorf("unknown dedup mode %q (expected run, tsdb or off)", opts.Dedup)
+	}
+	if opts.Downsample < 0 || (opts.Downsample > 0 && opts.Downsample < time.Millisecond) {
+		return nil, fmt.Errorf("downsample interval %s is below 1ms", opts.Downsample)
+	}
+	if opts.Align < 0 || (opts.Align > 0 && opts.Align < time.Millisecond) {
+		return nil, fmt.Errorf("align interval %s is below 1ms", opts.Align)
+	}
+	if opts.Align > 0 && opts.Downsample > 0 {
+		return nil, fmt.Errorf("downsampling and aligning to a grid cannot be combined")
+	}
+	if opts.RateWindow < 0 || (opts.RateWindow > 0 && opts.RateWindow < time.Millisecond) {
+		return nil, fmt.Errorf("rate window %s is below 1ms", opts.RateWindow)
+	}
+	if opts.PipelineBuffer < 0 {
+		return nil, fmt.Errorf("pipeline buffer %d is negative", opts.PipelineBuffer)
+	}
+	if err := opts.Window.Validate(); err != nil {
+		return nil, err
+	}
+
+	var enricher *enrich.Enricher
+	if opts.EnrichmentFile != "" {
+		var err error
+		enricher, err = enrich.Load(opts.EnrichmentFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load enrichment file: %w", err)
+		}
+	}
+
+	cfg, err := config.LoadLayered(opts.Profile, configFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create TSDB writer: %w", err)
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := cfg.ApplyPresets(opts.Presets); err != nil {
+		return nil, err
+	}
+	if err := cfg.AddInstanceFilters(opts.IncludeInstances, opts.ExcludeInstances); err != nil {
+		return nil, err
 	}
 
-	// For now, use minimal config to avoid filtering out metrics
-	cfg := config.Default()
-	// Skip config file loading for debug - we want to see all metrics
-	// if configFile != "" {
-	// 	cfg, err = config.Load(configFile)
-	// 	if err != nil {
-	// 		writer.Close()
-	// 		return nil, fmt.Errorf("failed to load config: %w", err)
-	// 	}
-	// }
+	var writer *tsdb.Writer
+	if opts.DryRun {
+		writer = tsdb.NewCountingWriter()
+	} else {
+		var err error
+		options := opts.TSDB.Or(tsdb.Options(cfg.TSDB))
+		if opts.Backfill {
+			writer, err = tsdb.NewBackfillWriter(tsdbPath, options)
+		} else {
+			writer, err = tsdb.NewWriter(tsdbPath, options)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create TSDB writer: %w", err)
+		}
+		writer.SetSeriesLimit(opts.MaxSeries, opts.OnCardinalityExceeded)
+		if opts.CommitBatch != 0 {
+			writer.SetCommitBatch(opts.CommitBatch)
+		}
+		// A sample that cannot be written is skipped only when the error
+		// policy continues; it is kept in the quarantine file rather than
+		// lost
+		if opts.OnError == "" || opts.OnError == OnErrorContinue {
+			writer.SetQuarantine(tsdb.QuarantinePath(tsdbPath))
+		}
+	}
+	if err := writer.SetDedup(opts.Dedup, opts.GapThreshold); err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	configHash := "default"
+	if configFile != "" {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			writer.Close()
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		configHash = provenance.HashBytes(data)
+	}
+	if opts.Profile != "" {
+		configHash = provenance.HashBytes([]byte("profile " + opts.Profile + "\n" + configHash))
+	}
+
+	if len(opts.Presets) > 0 {
+		configHash = provenance.HashBytes([]byte("presets " + strings.Join(opts.Presets, ",") + "\n" + configHash))
+	}
+	if len(opts.IncludeInstances) > 0 || len(opts.ExcludeInstances) > 0 {
+		configHash = provenance.HashBytes([]byte("instances " + strings.Join(opts.IncludeInstances, ",") +
+			" -" + strings.Join(opts.ExcludeInstances, ",") + "\n" + configHash))
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = logging.Default()
+	}
+
+	writeLimiter := throttle.NewBucket(opts.MaxWriteRate)
+	writer.SetRateLimit(writeLimiter)
 
 	return &Converter{
-		writer: writer,
-		config: cfg,
+		writer:     writer,
+		config:     cfg,
+		enricher:   enricher,
+		opts:       opts,
+		history:    provenance.OpenHistory(tsdbPath),
+		configHash: configHash,
+
+		writeLimiter: writeLimiter,
+		readLimiter:  throttle.NewBucket(opts.MaxIORate * 1024 * 1024),
+
+		totals:       runTotals{start: time.Now()},
+		descriptors:  make(map[string][]descriptorVariant),
+		mappingsUsed: make(map[string]bool),
+		metadata:     make(map[string][]MetricMetadata),
+		logger:       logger,
 	}, nil
 }
 
+// TSDBOptions returns the options the TSDB was opened with, and false for
+// a dry run, which opens none
+func (c *Converter) TSDBOptions() (tsdb.Options, bool) {
+	if c.opts.DryRun {
+		return tsdb.Options{}, false
+	}
+	return c.writer.Options(), true
+}
+
+// TSDBStats returns what the TSDB holds, nil for a dry run. It queries the
+// open TSDB, so it is called before Close, except for a backfill, whose
+// blocks are only written by Close.
+func (c *Converter) TSDBStats() (*tsdb.Stats, error) {
+	return c.writer.Stats()
+}
+
+// BackfillBlocks returns the ULIDs of the blocks a backfill wrote once the
+// converter is closed
+func (c *Converter) BackfillBlocks() []string {
+	return c.writer.Blocks()
+}
+
+// ErrInterrupted is the error of a file whose conversion Interrupt stopped
+var ErrInterrupted = errors.New("conversion interrupted")
+
+// Interrupt stops the conversion in progress, committing the samples
+// written so far so that a resumed run continues after them, and fails
+// every later file with ErrInterrupted. It may be called from any
+// goroutine, such as a signal handler.
+func (c *Converter) Interrupt() {
+	c.interrupted.Store(true)
+}
+
 func (c *Converter) Close() error {
+	if c.enricher != nil {
+		for _, stats := range c.enricher.Stats() {
+			c.logger.Infof("Enrichment %s: %d instances joined, %d without a matching entry",
+				stats.Name, stats.Joined, stats.Missing)
+		}
+	}
+	for _, conflict := range c.DescriptorConflicts() {
+		c.logger.Warnf("Stat descriptor conflict between files: %s", conflict)
+	}
+	c.warnUnmatchedMappings()
+	if dropped := c.writer.DroppedSeries(); dropped > 0 {
+		c.logger.Warnf("Dropped %d series over the limit of %d series", dropped, c.opts.MaxSeries)
+	}
 	return c.writer.Close()
 }
 
+// EnrichLabels adds labels from the enrichment file, if one was given
+func (c *Converter) EnrichLabels(resourceType, instanceName, nodeName string, labels map[string]string) {
+	if c.enricher == nil {
+		return
+	}
+	c.enricher.Apply(resourceType, instanceName, nodeName, labels)
+}
+
+// ReadLimiter returns the bucket that caps archive read rates, nil if
+// reads are unlimited
+func (c *Converter) ReadLimiter() *throttle.Bucket {
+	return c.readLimiter
+}
+
+// LogRates logs the average throttled write and read rates, if any limit
+// is set
+func (c *Converter) LogRates() {
+	if c.writeLimiter != nil {
+		c.logger.Infof("Write rate: %.0f samples/s (limit %.0f)", c.writeLimiter.Rate(), c.opts.MaxWriteRate)
+	}
+	if c.readLimiter != nil {
+		c.logger.Infof("Read rate: %.2f MB/s (limit %.2f)", c.readLimiter.Rate()/(1024*1024), c.opts.MaxIORate)
+	}
+}
+
+// Logger returns the logger the converter writes to
+func (c *Converter) Logger() logging.Logger {
+	return c.logger
+}
+
 func (c *Converter) GetWriter() *tsdb.Writer {
 	return c.writer
 }
 
-func (c *Converter) ConvertFile(filename string) error {
-	// Use Go parser directly for now (Java extractor has compilation issues)
+// InstanceLabeler returns the labels of an instance's series, before any
+// metric mapping is applied
+type InstanceLabeler func(resourceType, instanceName string) map[string]string
+
+// ConvertFile converts a file and returns the report of how much of it was
+// read, nil if it could not be opened or was read by the legacy parser or
+// the Java extractor
+func (c *Converter) ConvertFile(filename string) (*gfs.ParseReport, error) {
+	return c.ConvertFileWithLabels(filename, "", c.instanceLabels)
+}
+
+// ConvertFileWithLabels converts a file like ConvertFile, but labels the
+// series of each instance with labeler. cluster, if set, is the cluster the
+// file is imported as, which prefix rules may match on.
+func (c *Converter) ConvertFileWithLabels(filename, cluster string, labeler InstanceLabeler) (*gfs.ParseReport, error) {
+	var report *gfs.ParseReport
+	err := c.TrackFile(filename, func() (events.FileSummary, error) {
+		summary, r, err := c.convertFile(filename, cluster, labeler)
+		report = r
+		return summary, err
+	})
+	return report, err
+}
+
+// instanceLabels returns the default labels of an instance's series
+func (c *Converter) instanceLabels(resourceType, instanceName string) map[string]string {
+	labels := InstanceLabels(resourceType, instanceName, c.opts.LegacyLabels)
+	c.EnrichLabels(resourceType, instanceName, "", labels)
+	return labels
+}
+
+func (c *Converter) convertFile(filename, cluster string, labeler InstanceLabeler) (events.FileSummary, *gfs.ParseReport, error) {
+	if c.opts.LegacyParser {
+		summary, err := c.convertLegacy(filename, cluster, labeler)
+		return summary, nil, err
+	}
+
+	if c.opts.Parser == ParserJava {
+		summary, err := c.convertJava(filename, cluster, labeler)
+		return summary, nil, err
+	}
+
 	reader, err := gfs.NewStatArchiveReader(filename)
 	if err != nil {
-		return fmt.Errorf("failed to create StatArchive reader: %w", err)
+		return events.FileSummary{}, nil, fmt.Errorf("failed to create StatArchive reader: %w", err)
 	}
 	defer reader.Close()
-	return c.convertWithReader(reader, filename)
+
+	summary, report, err := c.convertArchive(reader, filename, cluster, c.streams(filename), labeler)
+	if c.fallBackToJava(filename, report) {
+		summary, err := c.convertJava(filename, cluster, labeler)
+		return summary, nil, err
+	}
+	if err != nil {
+		return summary, report, err
+	}
+	return summary, report, c.RecordImport(filename, summary.MetricPrefix, summary.SamplesWritten)
+}
+
+// StdinName is the name an archive read by ConvertReader is reported and
+// recorded under when it has no other
+const StdinName = "<stdin>"
+
+// ConvertReader converts an archive read from src, such as stdin, which
+// may be gzipped. name stands for the file in logs, events and the import
+// history. Archives larger than the stream threshold cannot be recognised
+// before reading, so any archive is streamed when a threshold is set. An
+// archive that cannot be read again is not handed to the Java extractor by
+// ParserAuto.
+func (c *Converter) ConvertReader(name string, src io.Reader) (*gfs.ParseReport, error) {
+	if c.opts.LegacyParser {
+		return nil, fmt.Errorf("the legacy parser cannot read %s: it needs a file", name)
+	}
+	if c.opts.Parser == ParserJava {
+		return nil, fmt.Errorf("the Java extractor cannot read %s: it needs a file", name)
+	}
+
+	var report *gfs.ParseReport
+	err := c.TrackFile(name, func() (events.FileSummary, error) {
+		// The archive is hashed as it is read, as it cannot be read again
+		hash := sha256.New()
+		input := io.TeeReader(src, hash)
+		reader := gfs.NewStatArchiveReaderFromReader(input, 0)
+
+		summary, r, err := c.convertArchive(reader, name, "", c.opts.StreamThreshold > 0, c.instanceLabels)
+		report = r
+		if err != nil {
+			return summary, err
+		}
+		// Anything after the last record read still belongs to the archive
+		if _, err := io.Copy(io.Discard, input); err != nil {
+			return summary, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		return summary, c.recordImport(name, name, hex.EncodeToString(hash.Sum(nil)), summary.MetricPrefix, summary.SamplesWritten)
+	})
+	return report, err
+}
+
+// convertArchive converts an archive from a reader that has not been read
+// yet, spilling its samples to disk with LowMemory and otherwise streaming
+// them if stream is set
+func (c *Converter) convertArchive(reader *gfs.StatArchiveReader, filename, cluster string, stream bool, labeler InstanceLabeler) (events.FileSummary, *gfs.ParseReport, error) {
+	reader.SetGapThreshold(c.opts.GapThreshold)
+	reader.SetReadLimit(c.readLimiter)
+	reader.SetTimeZoneMode(c.opts.TimeZoneMode)
+	reader.SetSampleErrorPolicy(c.opts.SampleErrors)
+	reader.SetTimeJumpPolicy(c.opts.TimeJumps)
+	reader.SetLogger(c.logger)
+	if c.opts.PaddingThreshold != 0 {
+		reader.SetPaddingThreshold(max(c.opts.PaddingThreshold, 0))
+	}
+	reader.SetLimits(c.opts.ReaderLimits)
+	if progress := c.opts.ReadProgress; progress != nil {
+		reader.SetProgressFunc(func(bytesRead, totalBytes int64, samples int) {
+			progress(filename, bytesRead, totalBytes, samples)
+		})
+	}
+	if c.opts.Verify {
+		reader.Verify()
+	}
+
+	// A window relative to the end of the archive, like the offset of a
+	// remapped archive, is resolved once it has been read, so an archive
+	// that would be streamed is spilled instead
+	if c.opts.LowMemory || (stream && (c.opts.Window.Relative() || c.opts.RemapToNow)) {
+		summary, err := c.convertSpilled(reader, filename, cluster, labeler)
+		return summary, reader.GetParseReport(), err
+	}
+	if stream {
+		summary, err := c.convertStream(reader, filename, cluster, labeler)
+		return summary, reader.GetParseReport(), err
+	}
+
+	c.logger.Debugf("Parsing GFS file: %s", filename)
+	if err := reader.ReadArchive(); err != nil {
+		if gfs.IsUnreadable(err) {
+			return events.FileSummary{}, reader.GetParseReport(), fmt.Errorf("failed to parse %s: %w", filename, err)
+		}
+		c.Warn(events.WarningParse, filename, "Archive parsing completed with errors: %v", err)
+	}
+
+	product := reader.GetArchiveInfo().ProductDescription
+	prefix, rule := c.filePrefix(filename, cluster, product)
+	summary, err := c.convertRead(reader, filename, prefix, c.NewValueCorrector(product), labeler)
+	summary.MetricPrefix, summary.PrefixRule = prefix, rule
+	report := reader.GetParseReport()
+	c.reportParse(report, filename, &summary)
+	return summary, report, err
+}
+
+// reportParse logs the parse report of an archive and adds its counts to
+// the file summary. Callers surface reports that are not clean.
+func (c *Converter) reportParse(report *gfs.ParseReport, filename string, summary *events.FileSummary) {
+	summary.ParseWarnings = report.WarningCount
+	summary.SkippedRecords = report.SkippedRecords
+	summary.Truncated = report.Truncated
+	summary.PaddingBytes = report.PaddingBytes
+	summary.LimitsExceeded = report.LimitsExceeded
+	c.logger.Infof("Read %s: %s", filename, report)
+	if report.LimitsExceeded > 0 {
+		c.Warn(events.WarningLimitExceeded, filename, "Skipped %d records of %s with lengths or counts above the reader limits", report.LimitsExceeded, filename)
+	}
+	if jumps := report.TimeJumps; len(jumps) > 0 {
+		policy := c.opts.TimeJumps
+		if policy == "" {
+			policy = gfs.TimeJumpsDrop
+		}
+		c.Warn(events.WarningTimeJump, filename, "Time goes back at %d sample records of %s, the first at %s; %d regressed sample records handled with policy %s",
+			len(jumps), filename, jumps[0], report.RegressedSamples, policy)
+	}
+	if v := report.Verification; v != nil && !v.OK {
+		c.Warn(events.WarningParse, filename, "%s %s", filename, v)
+	}
+}
+
+// convertLegacy converts a file with the legacy parser selected by
+// --legacy-parser
+func (c *Converter) convertLegacy(filename, cluster string, labeler InstanceLabeler) (events.FileSummary, error) {
+	parser, err := gfs.NewGeodeParser(filename)
+	if err != nil {
+		return events.FileSummary{}, fmt.Errorf("failed to create parser: %w", err)
+	}
+	defer parser.Close()
+	parser.SetReadLimit(c.readLimiter)
+
+	c.logger.Debugf("Parsing GFS file with the legacy parser: %s", filename)
+	if err := parser.ReadArchive(); err != nil {
+		return events.FileSummary{}, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	// The legacy parser does not read the product description, so only
+	// corrections without a version restriction and prefix rules without a
+	// dialect apply
+	prefix, rule := c.filePrefix(filename, cluster, "")
+	summary, err := c.convertRead(parser, filename, prefix, c.NewValueCorrector(""), labeler)
+	summary.MetricPrefix, summary.PrefixRule = prefix, rule
+	if err != nil {
+		return summary, err
+	}
+	return summary, c.RecordImport(filename, prefix, summary.SamplesWritten)
+}
+
+// convertRead writes the samples of an archive that has already been read
+// under the metric prefix of the file
+func (c *Converter) convertRead(reader StatReader, filename, prefix string, corrector *ValueCorrector, labeler InstanceLabeler) (events.FileSummary, error) {
+	var summary events.FileSummary
+	samples, err := c.convertWithReader(reader, filename, prefix, corrector, labeler, &summary)
+	summary.SamplesWritten = samples
+	summary.ResourceTypes = len(reader.GetResourceTypes())
+	summary.Instances = len(reader.GetInstances())
+	summary.SamplingGaps = len(reader.GetSamplingGaps())
+	summary.SamplingDisabled = len(reader.GetSamplingDisabled())
+	summary.CorrectionsApplied = corrector.Applied()
+	return summary, err
+}
+
+// RecordImport appends a provenance record for a converted file to the
+// import history and, if enabled, writes it as an import info series under
+// the file's metric prefix
+func (c *Converter) RecordImport(filename, prefix string, samples int) error {
+	hash, err := provenance.HashFile(filename)
+	if err != nil {
+		c.Warn(events.WarningProvenance, filename, "Failed to hash %s for import history: %v", filename, err)
+	}
+
+	absFile, err := filepath.Abs(filename)
+	if err != nil {
+		absFile = filename
+	}
+	return c.recordImport(filename, absFile, hash, prefix, samples)
+}
+
+// recordImport records the import of filename, recorded as file, whose
+// contents hash to hash
+func (c *Converter) recordImport(filename, file, hash, prefix string, samples int) error {
+	record := provenance.Record{
+		File:           file,
+		SHA256:         hash,
+		ImportedAt:     time.Now(),
+		ToolVersion:    c.opts.ToolVersion,
+		ConfigHash:     c.configHash,
+		SamplesWritten: samples,
+	}
+
+	if c.opts.EmitImportInfo {
+		labels := map[string]string{
+			"job":          "gfs-to-prometheus",
+			"file":         record.File,
+			"sha256":       record.SHA256,
+			"tool_version": record.ToolVersion,
+			"config_hash":  record.ConfigHash,
+		}
+		labels = c.config.RenameLabels(labels)
+		if err := c.writer.WriteMetric(prefix+"_import_info", labels, 1, record.ImportedAt); err != nil {
+			c.Warn(events.WarningWrite, filename, "Failed to write import info for %s: %v", filename, err)
+		} else if err := c.writer.CommitSource(""); err != nil {
+			return fmt.Errorf("failed to commit import info: %w", err)
+		}
+	}
+
+	if c.opts.DryRun {
+		return nil
+	}
+	if err := c.history.Append(record); err != nil {
+		c.Warn(events.WarningProvenance, filename, "Failed to record import of %s: %v", filename, err)
+	}
+	return nil
 }
 
 // Define interface for both readers
@@ -61,95 +792,518 @@ type StatReader interface {
 	ReadArchive() error
 	GetResourceTypes() map[int32]*gfs.ResourceType
 	GetInstances() map[int32]*gfs.ResourceInstance
-	GetArchiveInfo() map[string]interface{}
+	GetArchiveInfo() gfs.ArchiveInfo
+	GetSamplingGaps() []gfs.SamplingGap
+	GetSamplingDisabled() []gfs.SamplingGap
+	GetInstanceEvents() []gfs.InstanceEvent
+	GetLastSampleTime() time.Time
+	TimeZone() (string, time.Duration)
 	Close() error
 }
 
-func (c *Converter) convertWithReader(reader StatReader, filename string) error {
-	log.Printf("Parsing GFS file: %s", filename)
-	if err := reader.ReadArchive(); err != nil {
-		log.Printf("Warning: Archive parsing completed with errors: %v", err)
-	}
-
+// convertWithReader writes the samples of an archive that has already been
+// read, counting those it skips in summary
+func (c *Converter) convertWithReader(reader StatReader, filename, prefix string, corrector *ValueCorrector, labeler InstanceLabeler, summary *events.FileSummary) (int, error) {
 	types := reader.GetResourceTypes()
 	instances := reader.GetInstances()
+	info := reader.GetArchiveInfo()
+
+	if err := c.checkMetricNames(prefix, types); err != nil {
+		return 0, err
+	}
+	metricName := func(resourceType string, stat *gfs.StatDescriptor) string {
+		return c.formatMetricName(resourceType, stat, c.statMetricName(prefix, resourceType, stat))
+	}
+	resolutions, err := c.ResolveDescriptors(filename, corrector.Product(), types, instances, metricName)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.setWindow(filename, reader.GetLastSampleTime()); err != nil {
+		return 0, err
+	}
+	c.setRemap(filename, reader.GetLastSampleTime())
+
+	totalMetrics, counterResets := 0, 0
+	// samples counts the samples of the stats and kept those written
+	samples, kept := 0, 0
+	var firstSample time.Time
+	writeSample := func(metricName string, labels map[string]string, timestamp time.Time, value float64) error {
+		timestamp, ok := c.futureTime(filename, metricName, labels, timestamp, false)
+		if !ok {
+			return nil
+		}
+		if err := c.writer.WriteSourceMetric(filename, metricName, labels, value, timestamp); err != nil {
+			return c.writeFailed(filename, err, "Failed to write metric %s sample at %s: %v", metricName, timestamp.Format(time.RFC3339Nano), err)
+		}
+		if firstSample.IsZero() || timestamp.Before(firstSample) {
+			firstSample = timestamp
+		}
+		totalMetrics++
+		kept++
+		return nil
+	}
+	markStale := func(metricName string, labels map[string]string, timestamp time.Time) error {
+		return c.writeStaleMarker(filename, metricName, labels, timestamp)
+	}
+	// stop ends the file early on err, committing what was written
+	stop := func(err error) (int, error) {
+		if err := c.writer.CommitSource(filename); err != nil {
+			return 0, fmt.Errorf("failed to commit metrics: %w", err)
+		}
+		return totalMetrics - c.writer.Skipped(filename), err
+	}
+	derived := make(map[*gfs.ResourceType][]derivedMetric)
+	progress := c.NewProgressReporter(filename, len(instances))
+	for done, instance := range gfs.SortedInstances(instances) {
+		progress.Update(done, totalMetrics)
+		if c.interrupted.Load() {
+			return stop(ErrInterrupted)
+		}
 
-	totalMetrics := 0
-	for _, instance := range instances {
 		resType, ok := types[instance.TypeID]
 		if !ok {
-			log.Printf("Warning: Unknown resource type %d for instance %s", instance.TypeID, instance.Name)
+			c.Warn(events.WarningUnknownType, filename, "Unknown resource type %d for instance %s", instance.TypeID, instance.Name)
+			summary.SkippedInvalid += instanceSamples(instance)
 			continue
 		}
 
 		// Skip corrupted types/instances
-		if !c.isValidResourceType(resType) || !c.isValidInstance(instance) {
+		if !isValidResourceType(resType) || !isValidInstance(instance) {
+			summary.SkippedInvalid += instanceSamples(instance)
+			continue
+		}
+		if !c.config.Filters.IncludesType(resType.Name) || !c.includesInstance(instance.Name) {
+			summary.SkippedFiltered += instanceSamples(instance)
 			continue
 		}
 
+		labels := numericIDLabels(c.config, labeler(resType.Name, instance.Name), resType.Name, instance)
+		labels = sourceLabels(c.config, labels, filename, info)
+
 		// Iterate through all stats for this resource type
 		for i, stat := range resType.Stats {
 			statID := int32(i)
-			
+
 			// Check if we have data for this stat
 			values, hasData := instance.Stats[statID]
 			if !hasData || len(values) == 0 {
 				continue
 			}
+			if !c.config.Filters.IncludesStat(resType.Name, stat.Name) {
+				summary.SkippedFiltered += len(values)
+				continue
+			}
+
+			mapping, mapped := c.mappingFor(resType.Name, stat.Name, c.statMetricName(prefix, resType.Name, &stat))
+			if mapped && mapping.Drop {
+				summary.SkippedFiltered += len(values)
+				continue
+			}
+			statLabels := labels
+			if mapped {
+				statLabels = mappedLabels(labels, instance.Name, mapping)
+			}
+			statLabels = c.config.RenameLabels(statLabels)
+
+			metricName := metricName(resType.Name, &stat)
+			correction := corrector.Lookup(resType.Name, stat.Name, metricName)
+			metricName, scale := resolutions.Resolve(resType.Name, stat.Name, metricName)
+			var state *stateSeries
+			if mapped && mapping.AsStateMetric {
+				state = newStateSeries(metricName, statLabels, writeSample, markStale)
+				c.recordStateMetadata(metricName, &stat)
+			} else {
+				c.recordMetadata(metricName, &stat)
+			}
+			var reset *counterReset
+			if stat.IsCounter && c.opts.AdjustCounterResets {
+				reset = &counterReset{}
+			}
 
-			metricName := c.formatMetricName(resType.Name, stat.Name)
-			
-			// Use proper Prometheus labels as requested
-			labels := map[string]string{
-				"job":      "gfs-to-prometheus",
-				"statType": resType.Name,
-				"statName": instance.Name,
+			write := func(timestamp time.Time, value float64) error {
+				return writeSample(metricName, statLabels, timestamp, value)
+			}
+			var ds resampler
+			if state == nil {
+				ds = c.newResampler(stat.IsCounter, write)
 			}
-			
+			var rate *rateSeries
+			if stat.IsCounter && c.opts.RateWindow > 0 && state == nil {
+				rate = c.newRateSeries(metricName, statLabels, writeSample)
+			}
+
 			// Write ALL values for this stat, preserving original timestamps
-			for i, sample := range values {
-				value := c.convertToFloat64(sample.Value)
-				
-				// Use the original timestamp from the GFS file
-				timestamp := sample.Timestamp
-				
-				if err := c.writer.WriteMetric(metricName, labels, value, timestamp); err != nil {
-					log.Printf("Warning: Failed to write metric %s sample %d: %v", metricName, i, err)
+			var last time.Time
+			for _, sample := range values {
+				if mapped && !mapping.Valid(sample.Value) {
+					summary.SkippedInvalid++
 					continue
 				}
-				totalMetrics++
+				raw := sample.Value
+				if reset != nil {
+					raw = reset.adjust(raw)
+				}
+				value := corrector.Apply(correction, raw) * scale
+
+				// Use the original timestamp from the GFS file
+				timestamp := sample.Time()
+				if timestamp.After(last) {
+					last = timestamp
+				}
+
+				var err error
+				if state != nil {
+					err = state.add(timestamp, value)
+				} else if ds != nil {
+					err = ds.add(timestamp, value)
+				} else {
+					err = write(timestamp, value)
+				}
+				if err != nil {
+					return stop(err)
+				}
+				samples++
+				if rate != nil {
+					if err := rate.add(timestamp, value); err != nil {
+						return stop(err)
+					}
+				}
+			}
+			if ds != nil {
+				if err := ds.flush(); err != nil {
+					return stop(err)
+				}
+			}
+			if rate != nil {
+				if err := rate.flush(); err != nil {
+					return stop(err)
+				}
+				samples += rate.downsampled()
+			}
+			if state != nil {
+				var err error
+				if !instance.DeletionTime.IsZero() {
+					err = state.markStale(instance.DeletionTime)
+				} else {
+					err = state.flush()
+				}
+				if err != nil {
+					return stop(err)
+				}
+			} else if !instance.DeletionTime.IsZero() {
+				if err := markStale(metricName, statLabels, staleTime(instance.DeletionTime, last)); err != nil {
+					return stop(err)
+				}
+				if rate != nil && rate.written {
+					if err := markStale(rate.metricName, statLabels, staleTime(instance.DeletionTime, rate.last)); err != nil {
+						return stop(err)
+					}
+				}
+			}
+			if reset != nil {
+				counterResets += reset.resets
+			}
+		}
+
+		metrics, ok := derived[resType]
+		if !ok {
+			metrics = c.derivedMetrics(resType)
+			derived[resType] = metrics
+		}
+		if len(metrics) > 0 {
+			d := c.newDerivedInstance(metrics, c.config.RenameLabels(labels), writeSample)
+			if err := d.addAll(resType, instance); err != nil {
+				return stop(err)
+			}
+			samples += d.downsampled()
+			if !instance.DeletionTime.IsZero() {
+				if err := d.markStale(instance.DeletionTime, markStale); err != nil {
+					return stop(err)
+				}
 			}
 		}
 	}
 
-	if err := c.writer.Commit(); err != nil {
-		return fmt.Errorf("failed to commit metrics: %w", err)
+	totalMetrics += c.reportSamplingGaps(reader.GetSamplingGaps(), filename, prefix)
+	totalMetrics += c.reportSamplingDisabled(reader.GetSamplingDisabled(), filename, prefix)
+	totalMetrics += c.reportInstanceCounts(reader, filename, prefix)
+	totalMetrics += c.reportTimeZone(reader, filename, prefix, firstSample)
+	totalMetrics += c.reportArchiveInfo(reader, filename, prefix, firstSample)
+
+	// Samples quarantined by the commit count as skipped
+	if err := c.writer.CommitSource(filename); err != nil {
+		return 0, fmt.Errorf("failed to commit metrics: %w", err)
 	}
+	totalMetrics -= c.writer.Skipped(filename)
 
-	log.Printf("Converted %d metrics from %s", totalMetrics, filename)
-	return nil
+	c.logger.Infof("Converted %d metrics from %s", totalMetrics, filename)
+	c.logCounterResets(filename, counterResets)
+	c.logDownsampled(filename, samples, kept)
+	corrector.LogApplied(filename)
+	c.LogRates()
+	return totalMetrics, c.checkWindow(filename, totalMetrics)
+}
+
+// reportSamplingGaps logs the gaps found in an archive and, if enabled,
+// writes them as gap metrics. It returns the number of samples written.
+func (c *Converter) reportSamplingGaps(gaps []gfs.SamplingGap, filename, prefix string) int {
+	if len(gaps) == 0 {
+		return 0
+	}
+
+	c.logger.Infof("Found %d sampling gaps longer than %s in %s:", len(gaps), c.opts.GapThreshold, filename)
+	for _, gap := range gaps {
+		c.logger.Infof("  gap: %s - %s (%s)", gap.Start.Format(time.RFC3339), gap.End.Format(time.RFC3339), gap.Duration())
+	}
+
+	if !c.opts.EmitGapMetrics {
+		return 0
+	}
+
+	written := 0
+	metricName := prefix + "_sampling_gap_seconds"
+	labels := c.config.RenameLabels(map[string]string{
+		"job":  "gfs-to-prometheus",
+		"file": filepath.Base(filename),
+	})
+	for _, gap := range gaps {
+		at, ok := c.futureTime(filename, metricName, labels, gap.Start, false)
+		if !ok {
+			continue
+		}
+		if err := c.writer.WriteSourceMetric(filename, metricName, labels, gap.Duration().Seconds(), at); err != nil {
+			c.Warn(events.WarningWrite, filename, "Failed to write sampling gap at %s: %v", gap.Start, err)
+			continue
+		}
+		written++
+	}
+	return written
+}
+
+// reportSamplingDisabled logs the intervals during which statistic sampling
+// was disabled and, if gap metrics are enabled, writes them as annotation
+// metrics. It returns the number of samples written.
+func (c *Converter) reportSamplingDisabled(intervals []gfs.SamplingGap, filename, prefix string) int {
+	if len(intervals) == 0 {
+		return 0
+	}
+
+	c.logger.Infof("Statistic sampling was disabled %d times in %s:", len(intervals), filename)
+	for _, interval := range intervals {
+		c.logger.Infof("  disabled: %s - %s (%s)", interval.Start.Format(time.RFC3339), interval.End.Format(time.RFC3339), interval.Duration())
+	}
+
+	if !c.opts.EmitGapMetrics {
+		return 0
+	}
+
+	written := 0
+	metricName := prefix + "_sampling_disabled_seconds"
+	labels := c.config.RenameLabels(map[string]string{
+		"job":  "gfs-to-prometheus",
+		"file": filepath.Base(filename),
+	})
+	for _, interval := range intervals {
+		at, ok := c.futureTime(filename, metricName, labels, interval.Start, false)
+		if !ok {
+			continue
+		}
+		if err := c.writer.WriteSourceMetric(filename, metricName, labels, interval.Duration().Seconds(), at); err != nil {
+			c.Warn(events.WarningWrite, filename, "Failed to write sampling disabled interval at %s: %v", interval.Start, err)
+			continue
+		}
+		written++
+	}
+	return written
 }
 
-func (c *Converter) isValidResourceType(resType *gfs.ResourceType) bool {
+// instanceCountInterval is how often an unchanged instance count is written
+// again, well within Prometheus' five minute lookback, so the count holds
+// between creations and deletions
+const instanceCountInterval = time.Minute
+
+// reportInstanceCounts writes, if enabled, the number of live instances of
+// each resource type as a <prefix>_instance_count step series derived from
+// the archive's create and delete records, from the first record of a type
+// to the last sample. Types whose stats are filtered out are still counted.
+// It returns the number of samples written.
+func (c *Converter) reportInstanceCounts(reader StatReader, filename, prefix string) int {
+	if !c.opts.EmitInstanceCounts {
+		return 0
+	}
+
+	// Events are grouped by type name, as each archive appended to the
+	// file defines its types again
+	types := reader.GetResourceTypes()
+	byType := make(map[string][]gfs.InstanceEvent)
+	var typeNames []string
+	for _, event := range reader.GetInstanceEvents() {
+		resType, ok := types[event.TypeID]
+		if !ok || !isValidResourceType(resType) {
+			continue
+		}
+		if _, seen := byType[resType.Name]; !seen {
+			typeNames = append(typeNames, resType.Name)
+		}
+		byType[resType.Name] = append(byType[resType.Name], event)
+	}
+	end := reader.GetLastSampleTime()
+
+	written := 0
+	metricName := prefix + "_instance_count"
+	for _, typeName := range typeNames {
+		labels := c.config.RenameLabels(map[string]string{
+			"job":           "gfs-to-prometheus",
+			"file":          filepath.Base(filename),
+			"resource_type": typeName,
+		})
+
+		count := 0
+		var last time.Time
+		write := func(timestamp time.Time) {
+			last = timestamp
+			at, ok := c.futureTime(filename, metricName, labels, timestamp, false)
+			if !ok {
+				return
+			}
+			if err := c.writer.WriteSourceMetric(filename, metricName, labels, float64(count), at); err != nil {
+				c.Warn(events.WarningWrite, filename, "Failed to write instance count of %s at %s: %v", typeName, timestamp, err)
+				return
+			}
+			written++
+		}
+		// hold writes the current count every interval up to before
+		hold := func(before time.Time) {
+			if last.IsZero() {
+				return
+			}
+			for t := last.Add(instanceCountInterval); t.Before(before); t = t.Add(instanceCountInterval) {
+				write(t)
+			}
+		}
+
+		typeEvents := byType[typeName]
+		for i, event := range typeEvents {
+			hold(event.Time)
+			if event.Created {
+				count++
+			} else {
+				count--
+			}
+			// Records at the same time make a single sample
+			if i+1 < len(typeEvents) && typeEvents[i+1].Time.Equal(event.Time) {
+				continue
+			}
+			write(event.Time)
+		}
+		if end.After(last) {
+			hold(end)
+			write(end)
+		}
+	}
+	return written
+}
+
+// reportTimeZone logs the archive's timezone and, if import info is enabled,
+// writes it as a <prefix>_archive_timezone_offset_seconds sample at the
+// first sample of the archive, labelled with the product and machine from
+// the header when it has them
+func (c *Converter) reportTimeZone(reader StatReader, filename, prefix string, firstSample time.Time) int {
+	info := reader.GetArchiveInfo()
+	name, offset := reader.TimeZone()
+	mode := c.opts.TimeZoneMode
+	if mode == "" {
+		mode = gfs.TimeZoneRaw
+	}
+	c.logger.Infof("Archive timezone of %s: %s (%s), timestamps converted with mode %s", filename, name, gfs.FormatOffset(offset), mode)
+
+	if !c.opts.EmitImportInfo || firstSample.IsZero() {
+		return 0
+	}
+
+	labels := map[string]string{
+		"job":      "gfs-to-prometheus",
+		"file":     filepath.Base(filename),
+		"timezone": name,
+		"offset":   gfs.FormatOffset(offset),
+		"mode":     mode,
+	}
+	if info.ProductDescription != "" {
+		labels["product"] = info.ProductDescription
+	}
+	if info.MachineInfo != "" {
+		labels["machine"] = info.MachineInfo
+	}
+	labels = c.config.RenameLabels(labels)
+	if err := c.writer.WriteSourceMetric(filename, prefix+"_archive_timezone_offset_seconds", labels, offset.Seconds(), firstSample); err != nil {
+		c.Warn(events.WarningWrite, filename, "Failed to write archive timezone: %v", err)
+		return 0
+	}
+	return 1
+}
+
+// reportArchiveInfo writes, if the config asks for it, a
+// <prefix>_archive_info sample of 1 at the start and the end of the
+// archive, labelled with what identifies it and the member that wrote it
+func (c *Converter) reportArchiveInfo(reader StatReader, filename, prefix string, firstSample time.Time) int {
+	if !c.config.ArchiveInfo || firstSample.IsZero() {
+		return 0
+	}
+	info := reader.GetArchiveInfo()
+	labels := map[string]string{
+		"file":      filepath.Base(filename),
+		"system_id": strconv.FormatInt(info.SystemID, 10),
+	}
+	for name, value := range map[string]string{"product": info.ProductDescription, "os": info.OSInfo, "machine": info.MachineInfo, "host": info.Hostname()} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	labels = c.config.RenameLabels(labels)
+
+	start, end := info.StartTime, reader.GetLastSampleTime()
+	if start.IsZero() || start.After(firstSample) {
+		start = firstSample
+	}
+	timestamps := []time.Time{start}
+	if end.After(start) {
+		timestamps = append(timestamps, end)
+	}
+	written := 0
+	for _, timestamp := range timestamps {
+		at, ok := c.futureTime(filename, prefix+"_archive_info", labels, timestamp, false)
+		if !ok {
+			continue
+		}
+		if err := c.writer.WriteSourceMetric(filename, prefix+"_archive_info", labels, 1, at); err != nil {
+			c.Warn(events.WarningWrite, filename, "Failed to write archive info at %s: %v", timestamp, err)
+			continue
+		}
+		written++
+	}
+	return written
+}
+
+func isValidResourceType(resType *gfs.ResourceType) bool {
 	if len(resType.Name) == 0 || len(resType.Name) > 100 {
 		return false
 	}
-	
+
 	// Check for reasonable characters
 	for _, r := range resType.Name {
 		if r < 32 || r > 126 {
 			return false
 		}
 	}
-	
+
 	return true
 }
 
-func (c *Converter) isValidInstance(instance *gfs.ResourceInstance) bool {
+func isValidInstance(instance *gfs.ResourceInstance) bool {
 	if len(instance.Name) == 0 || len(instance.Name) > 200 {
 		return false
 	}
-	
+
 	// Check for reasonable characters (allow more flexibility for instance names)
 	validChars := 0
 	for _, r := range instance.Name {
@@ -157,17 +1311,80 @@ func (c *Converter) isValidInstance(instance *gfs.ResourceInstance) bool {
 			validChars++
 		}
 	}
-	
+
 	// At least 80% of characters should be printable
 	return float64(validChars)/float64(len(instance.Name)) >= 0.8
 }
 
-func (c *Converter) formatMetricName(resourceType, statName string) string {
-	prefix := c.config.MetricPrefix
-	if prefix == "" {
-		prefix = "gemfire"
+// instanceSamples returns the number of samples of all the stats of an
+// instance
+func instanceSamples(instance *gfs.ResourceInstance) int {
+	samples := 0
+	for _, values := range instance.Stats {
+		samples += len(values)
+	}
+	return samples
+}
+
+// filePrefix returns the metric prefix of a file and the name of the prefix
+// rule that chose it, empty when it is the configured metric prefix
+func (c *Converter) filePrefix(filename, cluster, product string) (string, string) {
+	prefix, rule := c.config.PrefixFor(filename, cluster, product)
+	if rule == nil {
+		return prefix, ""
+	}
+	c.logger.Infof("Metric prefix of %s: %s (prefix rule %s)", filename, prefix, rule.Name())
+	return prefix, rule.Name()
+}
+
+// formatMetricName returns the name a stat is written under: the name of
+// its metric mapping, if it has one, or metricName, its name before
+// mappings
+func (c *Converter) formatMetricName(resourceType string, stat *gfs.StatDescriptor, metricName string) string {
+	if mapping, ok := c.mappingFor(resourceType, stat.Name, metricName); ok && mapping.Name != "" {
+		return mapping.Name
+	}
+	return metricName
+}
+
+// mappedLabels returns a copy of the labels of an instance with a metric
+// mapping's extra labels added and, if the mapping names an instance label,
+// the instance name moved to it
+func mappedLabels(labels map[string]string, instance string, mapping config.MetricMapping) map[string]string {
+	result := make(map[string]string, len(labels)+len(mapping.Labels))
+	for name, value := range labels {
+		result[name] = value
+	}
+	if mapping.InstanceLabel != "" {
+		for _, name := range identityLabels {
+			delete(result, name)
+		}
+		result[mapping.InstanceLabel] = instance
+	}
+	for name, value := range mapping.Labels {
+		result[name] = value
 	}
+	return result
+}
 
+// numericIDLabels returns the labels of an instance with its numeric id
+// added, copying them first, if cfg labels the instances of its type with
+// it
+func numericIDLabels(cfg *config.Config, labels map[string]string, resourceType string, instance *gfs.ResourceInstance) map[string]string {
+	name := cfg.NumericIDLabelFor(resourceType)
+	if name == "" {
+		return labels
+	}
+	result := make(map[string]string, len(labels)+1)
+	for label, value := range labels {
+		result[label] = value
+	}
+	result[name] = strconv.FormatInt(instance.NumericID, 10)
+	return result
+}
+
+// FormatMetricName builds the default Prometheus name of a stat
+func FormatMetricName(prefix, resourceType, statName string) string {
 	resourceType = strings.ToLower(strings.ReplaceAll(resourceType, " ", "_"))
 	statName = strings.ToLower(strings.ReplaceAll(statName, " ", "_"))
 	statName = strings.ReplaceAll(statName, "-", "_")
@@ -175,15 +1392,52 @@ func (c *Converter) formatMetricName(resourceType, statName string) string {
 	return fmt.Sprintf("%s_%s_%s", prefix, resourceType, statName)
 }
 
-func (c *Converter) convertToFloat64(value interface{}) float64 {
-	switch v := value.(type) {
-	case int32:
-		return float64(v)
-	case int64:
-		return float64(v)
-	case float64:
-		return v
-	default:
-		return 0
+// StatMetricName builds the Prometheus name of a stat from its descriptor
+// before metric mappings: the name cfg's metric name template gives it, or
+// the default name. The _total suffix Prometheus gives counters is added
+// to the name of a counter stat unless it already ends with it.
+func StatMetricName(cfg *config.Config, prefix, resourceType string, stat *gfs.StatDescriptor) (string, error) {
+	name, templated, err := cfg.MetricName(prefix, resourceType, stat.Name)
+	if err != nil {
+		return "", err
+	}
+	if !templated {
+		name = FormatMetricName(prefix, resourceType, stat.Name)
+	}
+	if stat.IsCounter && !strings.HasSuffix(name, "_total") {
+		name += "_total"
+	}
+	return name, nil
+}
+
+// checkMetricNames names every stat of types that passes the filters, in
+// type ID and stat offset order, failing on the first the metric name
+// template names invalidly, so that a file fails before anything of it is
+// written, with the same error on every run
+func (c *Converter) checkMetricNames(prefix string, types map[int32]*gfs.ResourceType) error {
+	if c.config.MetricNameTemplate == "" {
+		return nil
 	}
-}
\ No newline at end of file
+	for _, resType := range gfs.SortedResourceTypes(types) {
+		if !c.config.Filters.IncludesType(resType.Name) {
+			continue
+		}
+		for i := range resType.Stats {
+			stat := &resType.Stats[i]
+			if !c.config.Filters.IncludesStat(resType.Name, stat.Name) {
+				continue
+			}
+			if _, err := StatMetricName(c.config, prefix, resType.Name, stat); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// statMetricName returns StatMetricName of a stat that checkMetricNames
+// has checked
+func (c *Converter) statMetricName(prefix, resourceType string, stat *gfs.StatDescriptor) string {
+	name, _ := StatMetricName(c.config, prefix, resourceType, stat)
+	return name
+}
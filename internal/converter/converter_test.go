@@ -0,0 +1,532 @@
+package converter_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+	"github.com/4n3w/gfs-to-prometheus/internal/tsdb"
+	"github.com/4n3w/gfs-to-prometheus/pkg/events"
+)
+
+// testOptions sizes the synthetic archive the tests convert
+var testOptions = gfstest.Options{Types: 2, Instances: 3, Samples: 20}
+
+// testStart is the start of the archives the tests write, recent enough
+// for the default out-of-order window
+var testStart = time.Now().Add(-time.Hour).Truncate(time.Minute)
+
+// testEnd is the end of the synthetic archive starting at start, one
+// interval after its last sample
+func testEnd(start time.Time) time.Time {
+	return start.Add(time.Duration(testOptions.Samples+1) * gfstest.SampleInterval)
+}
+
+// synthetic writes the synthetic archive starting at start to dir
+func synthetic(t *testing.T, dir string, start time.Time) string {
+	t.Helper()
+	path := filepath.Join(dir, fmt.Sprintf("synthetic-%d.gfs", start.Unix()))
+	if err := gfstest.WriteFile(path, start, testOptions); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeInstance writes the archive of a single instance to dir
+func writeInstance(t *testing.T, dir string, archive gfstest.Instance) string {
+	t.Helper()
+	path := filepath.Join(dir, "instance.gfs")
+	if err := archive.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeConfig writes a config file to dir
+func writeConfig(t *testing.T, dir, config string) string {
+	t.Helper()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// convertFile converts the archive into the TSDB at tsdbPath
+func convertFile(archive, tsdbPath, configFile string, options converter.Options) (*converter.Converter, error) {
+	options.Logger = logging.Discard
+	options.ToolVersion = "test"
+	conv, err := converter.New(tsdbPath, configFile, options)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conv.ConvertFile(archive)
+	if closeErr := conv.Close(); err == nil {
+		err = closeErr
+	}
+	return conv, err
+}
+
+// mustConvert converts the archive into the TSDB at tsdbPath and returns
+// the closed converter
+func mustConvert(t *testing.T, archive, tsdbPath, configFile string, options converter.Options) *converter.Converter {
+	t.Helper()
+	conv, err := convertFile(archive, tsdbPath, configFile, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conv
+}
+
+// selectSeries returns the series matching labelPairs between start and
+// end in the TSDB at tsdbPath
+func selectSeries(t *testing.T, tsdbPath string, start, end time.Time, labelPairs map[string]string) []tsdb.Series {
+	t.Helper()
+	reader, err := tsdb.OpenReader(tsdbPath, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	series, err := reader.Select(labelPairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return series
+}
+
+// instanceSeries returns the series of the first synthetic instance in
+// the TSDB at tsdbPath, up to the given number of seconds after start
+func instanceSeries(t *testing.T, tsdbPath string, start time.Time, seconds int) []tsdb.Series {
+	t.Helper()
+	end := start.Add(time.Duration(seconds) * time.Second)
+	return selectSeries(t, tsdbPath, start, end, map[string]string{
+		converter.LabelResourceType: gfstest.TypeName(0),
+		converter.LabelInstance:     gfstest.InstanceName(0, 0),
+	})
+}
+
+// timedValue is a sample at a number of seconds after a start
+type timedValue struct {
+	second int
+	value  float64
+}
+
+// timedValues returns the samples of s in seconds after start
+func timedValues(s tsdb.Series, start time.Time) []timedValue {
+	var samples []timedValue
+	for i, t := range s.Timestamps {
+		samples = append(samples, timedValue{int(t.Sub(start) / time.Second), s.Values[i]})
+	}
+	return samples
+}
+
+// checkSynthetic checks that every stat of every instance of the
+// synthetic archive starting at start became a series holding all of its
+// samples in the TSDB at tsdbPath
+func checkSynthetic(t *testing.T, tsdbPath string, start time.Time) {
+	t.Helper()
+	reader, err := tsdb.OpenReader(tsdbPath, start, testEnd(start))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	for ty := 0; ty < testOptions.Types; ty++ {
+		for i := 0; i < testOptions.Instances; i++ {
+			name := gfstest.InstanceName(ty, i)
+			series, err := reader.Select(map[string]string{converter.LabelResourceType: gfstest.TypeName(ty), converter.LabelInstance: name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(series) != len(gfstest.StatTypes) {
+				t.Fatalf("%s has %d series, wrote %d stats", name, len(series), len(gfstest.StatTypes))
+			}
+			for _, s := range series {
+				if len(s.Timestamps) != testOptions.Samples {
+					t.Fatalf("series %s of %s has %d samples, wrote %d", s.Labels["__name__"], name, len(s.Timestamps), testOptions.Samples)
+				}
+			}
+		}
+	}
+}
+
+// readEvents returns the events of an event log
+func readEvents(t *testing.T, log string) []events.Event {
+	t.Helper()
+	var result []events.Event
+	for _, line := range strings.Split(strings.TrimSpace(log), "\n") {
+		var event events.Event
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, event)
+	}
+	return result
+}
+
+func TestConvert(t *testing.T) {
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	tests := []struct {
+		name    string
+		options converter.Options
+	}{
+		{"in memory", converter.Options{}},
+		{"streamed", converter.Options{StreamThreshold: 1, PipelineBuffer: 4096}},
+		{"low memory", converter.Options{LowMemory: true, PipelineBuffer: 4096}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tsdbPath := filepath.Join(t.TempDir(), "tsdb")
+			mustConvert(t, archive, tsdbPath, "", tt.options)
+			checkSynthetic(t, tsdbPath, testStart)
+		})
+	}
+}
+
+// TestLargeLongs checks that a long gauge holding byte counts beyond an
+// int, as vmStats.maxMemory does, is written to the TSDB as read, in
+// memory and with low memory
+func TestLargeLongs(t *testing.T) {
+	maxMemory := []float64{1 << 31, 8 << 30, 1 << 40, 3<<40 + 12345, 1<<53 - 1}
+	dir := t.TempDir()
+	archive := writeInstance(t, dir, gfstest.Instance{
+		Start:  testStart,
+		Stats:  []gfs.StatDescriptor{{Name: "maxMemory", Type: gfs.StatTypeLong, Unit: "bytes"}},
+		Values: [][]float64{maxMemory},
+	})
+	for _, lowMemory := range []bool{false, true} {
+		t.Run(fmt.Sprintf("low memory %t", lowMemory), func(t *testing.T) {
+			tsdbPath := filepath.Join(t.TempDir(), "tsdb")
+			mustConvert(t, archive, tsdbPath, "", converter.Options{LowMemory: lowMemory})
+			series := instanceSeries(t, tsdbPath, testStart, len(maxMemory)+1)
+			if len(series) != 1 {
+				t.Fatalf("%d series, want 1", len(series))
+			}
+			if fmt.Sprint(series[0].Values) != fmt.Sprint(maxMemory) {
+				t.Errorf("values %v, want %v", series[0].Values, maxMemory)
+			}
+		})
+	}
+}
+
+// TestSkipJunk checks that every sample on either side of junk injected
+// between two samples is converted
+func TestSkipJunk(t *testing.T) {
+	dir := t.TempDir()
+	var archive bytes.Buffer
+	if err := gfstest.Write(&archive, testStart, testOptions, gfs.ArchiveHeader{}); err != nil {
+		t.Fatal(err)
+	}
+	data := archive.Bytes()
+	reader := gfs.NewStatArchiveReaderFromReader(bytes.NewReader(data), int64(len(data)))
+	reader.SetLogger(logging.Discard)
+	reader.TraceLayout()
+	if err := reader.ReadArchive(); err != nil {
+		t.Fatal(err)
+	}
+	var samples []int64
+	for _, record := range reader.GetLayout().Records {
+		if record.Token == gfs.SAMPLE_TOKEN {
+			samples = append(samples, record.Offset)
+		}
+	}
+	at := samples[len(samples)/2]
+	junk := append([]byte{5}, bytes.Repeat([]byte{gfs.INT_RESOURCE_INST_ID_TOKEN}, 15)...)
+	damaged := append(append(append([]byte(nil), data[:at]...), junk...), data[at:]...)
+	path := filepath.Join(dir, "junk.gfs")
+	if err := os.WriteFile(path, damaged, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tsdbPath := filepath.Join(dir, "tsdb")
+	mustConvert(t, path, tsdbPath, "", converter.Options{})
+	checkSynthetic(t, tsdbPath, testStart)
+}
+
+// TestSkipDuplicates converts the archive twice in one run, and again in a
+// second run reading what the TSDB holds, and checks that the conversions
+// after the first write no sample and count all of them as duplicates
+func TestSkipDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	tsdbPath := filepath.Join(dir, "tsdb")
+
+	var eventLog strings.Builder
+	options := converter.Options{Logger: logging.Discard, ToolVersion: "test", Events: events.NewWriter(&eventLog)}
+	conv, err := converter.New(tsdbPath, "", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conv.ConvertFile(archive)
+	if err == nil {
+		_, err = conv.ConvertFile(archive)
+	}
+	if closeErr := conv.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.Dedup = tsdb.DedupTSDB
+	mustConvert(t, archive, tsdbPath, "", options)
+
+	var summaries []*events.FileSummary
+	for _, event := range readEvents(t, eventLog.String()) {
+		if event.Type == events.FileCompleted {
+			summaries = append(summaries, event.Summary)
+		}
+	}
+	if len(summaries) != 3 {
+		t.Fatalf("want 3 converted files, got %d", len(summaries))
+	}
+	written := summaries[0].SamplesWritten
+	for i, summary := range summaries[1:] {
+		if summary.SamplesWritten != 0 || summary.SkippedDuplicates != written {
+			t.Errorf("conversion %d wrote %d samples and skipped %d, want 0 written and %d skipped",
+				i+2, summary.SamplesWritten, summary.SkippedDuplicates, written)
+		}
+	}
+	checkSynthetic(t, tsdbPath, testStart)
+}
+
+// TestSeriesLimit checks that a series limit of half the series of the
+// archive fails the conversion with tsdb.SeriesLimitAbort and leaves out
+// the series beyond it with tsdb.SeriesLimitDrop
+func TestSeriesLimit(t *testing.T) {
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	limit := testOptions.Types * testOptions.Instances * len(gfstest.StatTypes) / 2
+
+	_, err := convertFile(archive, filepath.Join(dir, "tsdb-abort"), "", converter.Options{MaxSeries: limit})
+	if !errors.Is(err, tsdb.ErrSeriesLimit) {
+		t.Fatalf("converting with a limit of %d series failed with %v, want the series limit error", limit, err)
+	}
+
+	conv := mustConvert(t, archive, filepath.Join(dir, "tsdb-drop"), "", converter.Options{MaxSeries: limit, OnCardinalityExceeded: tsdb.SeriesLimitDrop})
+	summary := conv.Report(nil).Files[0]
+	if summary.SeriesWritten != limit || summary.SkippedSeriesLimit == 0 {
+		t.Errorf("dropping series over a limit of %d wrote %d series and skipped %d samples", limit, summary.SeriesWritten, summary.SkippedSeriesLimit)
+	}
+}
+
+// TestReaderLimits converts the archive with an instance limit below what
+// it holds and checks that the instances under the limit are converted,
+// and that the records over it are counted in the summary and warned about
+func TestReaderLimits(t *testing.T) {
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	const maxInstances = 4
+	var eventLog strings.Builder
+	conv, err := convertFile(archive, filepath.Join(dir, "tsdb"), "", converter.Options{
+		ReaderLimits: gfs.ReaderLimits{MaxInstances: maxInstances},
+		Events:       events.NewWriter(&eventLog),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary := conv.Report(nil).Files[0]
+	if summary.Instances != maxInstances || summary.LimitsExceeded == 0 {
+		t.Errorf("converted %d instances with %d records over the limits, want %d instances and some", summary.Instances, summary.LimitsExceeded, maxInstances)
+	}
+	warned := 0
+	for _, event := range readEvents(t, eventLog.String()) {
+		if event.Warning != nil && event.Warning.Class == events.WarningLimitExceeded {
+			warned++
+		}
+	}
+	if warned != 1 {
+		t.Errorf("want 1 warning about the reader limits, got %d", warned)
+	}
+}
+
+func TestCommitInBatches(t *testing.T) {
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	tsdbPath := filepath.Join(dir, "tsdb")
+	mustConvert(t, archive, tsdbPath, "", converter.Options{CommitBatch: 7})
+	checkSynthetic(t, tsdbPath, testStart)
+}
+
+// TestImportOldArchive converts the archive, then one written 90 days
+// before it into the same TSDB, and checks that the old one is rejected
+// with the default out-of-order window but written whole once the window
+// covers it
+func TestImportOldArchive(t *testing.T) {
+	const age = 90 * 24 * time.Hour
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	oldStart := testStart.Add(-age)
+	old := synthetic(t, dir, oldStart)
+	tsdbPath := filepath.Join(dir, "tsdb")
+
+	mustConvert(t, archive, tsdbPath, "", converter.Options{})
+	options := converter.Options{OnError: converter.OnErrorSkipFile}
+	if _, err := convertFile(old, tsdbPath, "", options); err == nil {
+		t.Fatalf("an archive %s older than the TSDB was written with the default out-of-order window of %s", age, tsdb.DefaultOptions().OutOfOrderWindow)
+	}
+
+	options.TSDB.OutOfOrderWindow = age + 24*time.Hour
+	mustConvert(t, old, tsdbPath, "", options)
+	checkSynthetic(t, tsdbPath, oldStart)
+}
+
+// TestBackfillBlocks checks that converting with Backfill writes blocks
+// that open as a TSDB listing the ones the backfill reported, holding
+// every sample
+func TestBackfillBlocks(t *testing.T) {
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	tsdbPath := filepath.Join(dir, "tsdb")
+	conv := mustConvert(t, archive, tsdbPath, "", converter.Options{Backfill: true})
+
+	written := conv.BackfillBlocks()
+	blocks, err := tsdb.ListBlocks(tsdbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) == 0 || strings.Join(blocks, ",") != strings.Join(written, ",") {
+		t.Fatalf("the backfill wrote blocks %v, the TSDB lists %v", written, blocks)
+	}
+	checkSynthetic(t, tsdbPath, testStart)
+}
+
+// TestConvertConcurrently converts the archive and one written before it
+// into the same TSDB at once, as cluster does with the files of two nodes,
+// and checks that both are written whole
+func TestConvertConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	earlierStart := testStart.Add(-2 * time.Duration(testOptions.Samples) * gfstest.SampleInterval)
+	files := []string{synthetic(t, dir, testStart), synthetic(t, dir, earlierStart)}
+	tsdbPath := filepath.Join(dir, "tsdb")
+	conv, err := converter.New(tsdbPath, "", converter.Options{Logger: logging.Discard, ToolVersion: "test", CommitBatch: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make([]error, len(files))
+	var wg sync.WaitGroup
+	for n, file := range files {
+		wg.Add(1)
+		go func(n int, file string) {
+			defer wg.Done()
+			_, errs[n] = conv.ConvertFile(file)
+		}(n, file)
+	}
+	wg.Wait()
+	if closeErr := conv.Close(); closeErr != nil {
+		errs = append(errs, closeErr)
+	}
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+	checkSynthetic(t, tsdbPath, testStart)
+	checkSynthetic(t, tsdbPath, earlierStart)
+}
+
+// TestQuarantineRejected converts the archive, then one written before it
+// into the same TSDB with an out-of-order window too small to take it, and
+// checks that the file still converts, with every sample the TSDB rejected
+// counted and kept in the quarantine file with its labels and timestamp
+func TestQuarantineRejected(t *testing.T) {
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	earlierStart := testStart.Add(-2 * time.Duration(testOptions.Samples) * gfstest.SampleInterval)
+	earlier := synthetic(t, dir, earlierStart)
+	tsdbPath := filepath.Join(dir, "tsdb")
+
+	options := converter.Options{Logger: logging.Discard, ToolVersion: "test"}
+	options.TSDB.OutOfOrderWindow = time.Millisecond
+	conv, err := converter.New(tsdbPath, "", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conv.ConvertFile(archive)
+	if err == nil {
+		_, err = conv.ConvertFile(earlier)
+	}
+	if closeErr := conv.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var summary events.FileSummary
+	for _, result := range conv.Report(nil).Files {
+		if result.File == earlier {
+			summary = result.FileSummary
+		}
+	}
+	if summary.QuarantinedSamples == 0 || summary.SamplesWritten != 0 {
+		t.Fatalf("the earlier archive wrote %d samples and quarantined %d, want none written", summary.SamplesWritten, summary.QuarantinedSamples)
+	}
+
+	data, err := os.ReadFile(tsdb.QuarantinePath(tsdbPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != summary.QuarantinedSamples {
+		t.Fatalf("the quarantine file holds %d samples, %d were counted", len(lines), summary.QuarantinedSamples)
+	}
+	for _, line := range lines {
+		var sample tsdb.QuarantinedSample
+		if err := json.Unmarshal([]byte(line), &sample); err != nil {
+			t.Fatalf("bad quarantine line %q: %v", line, err)
+		}
+		if sample.Source != earlier || sample.Labels["__name__"] == "" || sample.Error == "" || sample.Timestamp.Before(earlierStart) || !sample.Timestamp.Before(testStart) {
+			t.Fatalf("quarantined sample %s is not one of the earlier archive", line)
+		}
+	}
+	checkSynthetic(t, tsdbPath, testStart)
+}
+
+// TestTSDBStats checks that what the open TSDB reports holding covers
+// every stat sample of the archive from the first, one interval after its
+// start, and that reading the closed TSDB reports the same
+func TestTSDBStats(t *testing.T) {
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	tsdbPath := filepath.Join(dir, "tsdb")
+	conv, err := converter.New(tsdbPath, "", converter.Options{Logger: logging.Discard, ToolVersion: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conv.ConvertFile(archive)
+	var open *tsdb.Stats
+	if err == nil {
+		open, err = conv.TSDBStats()
+	}
+	if closeErr := conv.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := tsdb.ReadStats(tsdbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	series := testOptions.Types * testOptions.Instances * len(gfstest.StatTypes)
+	first := testStart.Add(gfstest.SampleInterval)
+	if open.Series < series || open.Samples < int64(series*testOptions.Samples) || !open.MinTime.Equal(first) {
+		t.Errorf("the TSDB reports %d series with %d samples from %s, wrote %d series with %d samples from %s",
+			open.Series, open.Samples, open.MinTime.Format(time.RFC3339), series, series*testOptions.Samples, first.Format(time.RFC3339))
+	}
+	if read.Series != open.Series || read.Samples != open.Samples || !read.MinTime.Equal(open.MinTime) || !read.MaxTime.Equal(open.MaxTime) || read.Blocks != open.Blocks {
+		t.Errorf("the closed TSDB reports %+v, the open one %+v", *read, *open)
+	}
+}
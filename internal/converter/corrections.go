@@ -0,0 +1,77 @@
+package converter
+
+import (
+	"sort"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/config"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+)
+
+// ValueCorrector applies the configured value corrections to one archive
+// and counts the samples each correction changed
+type ValueCorrector struct {
+	config  *config.Config
+	product string
+	applied map[*config.ValueCorrection]int
+	logger  logging.Logger
+}
+
+// NewValueCorrector returns a corrector for an archive written by product
+func (c *Converter) NewValueCorrector(product string) *ValueCorrector {
+	return &ValueCorrector{
+		config:  c.config,
+		product: product,
+		applied: make(map[*config.ValueCorrection]int),
+		logger:  c.logger,
+	}
+}
+
+// Product returns the product description the corrector was created for
+func (v *ValueCorrector) Product() string {
+	return v.product
+}
+
+// Lookup returns the correction for a stat, or nil if it has none
+func (v *ValueCorrector) Lookup(resourceType, stat, metricName string) *config.ValueCorrection {
+	vc, ok := v.config.CorrectionFor(resourceType, stat, metricName, v.product)
+	if !ok {
+		return nil
+	}
+	return vc
+}
+
+// Apply corrects a single sample with vc, which may be nil
+func (v *ValueCorrector) Apply(vc *config.ValueCorrection, value float64) float64 {
+	if vc == nil {
+		return value
+	}
+	v.applied[vc]++
+	return vc.Apply(value)
+}
+
+// Applied returns the number of corrected samples per correction name
+func (v *ValueCorrector) Applied() map[string]int {
+	if len(v.applied) == 0 {
+		return nil
+	}
+	applied := make(map[string]int, len(v.applied))
+	for vc, count := range v.applied {
+		applied[vc.Name()] += count
+	}
+	return applied
+}
+
+// LogApplied lists the corrections applied to filename
+func (v *ValueCorrector) LogApplied(filename string) {
+	names := make([]*config.ValueCorrection, 0, len(v.applied))
+	for vc := range v.applied {
+		names = append(names, vc)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i].Name() < names[j].Name()
+	})
+
+	for _, vc := range names {
+		v.logger.Infof("Applied value correction %s (%s) to %d samples in %s", vc.Name(), vc.Describe(), v.applied[vc], filename)
+	}
+}
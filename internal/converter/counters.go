@@ -0,0 +1,30 @@
+package converter
+
+// counterReset keeps the series of a counter stat monotonic across the
+// resets of the counter within an archive, as when its member restarts
+// without starting a new archive, by adding the value each reset lost to
+// the values after it
+type counterReset struct {
+	seen   bool
+	last   float64 // The last value read, before adjusting
+	offset float64 // The sum of the values the resets so far lost
+	resets int
+}
+
+// adjust returns value with what the resets before it lost added, counting
+// a reset if it is below the value before it
+func (r *counterReset) adjust(value float64) float64 {
+	if r.seen && value < r.last {
+		r.offset += r.last
+		r.resets++
+	}
+	r.seen, r.last = true, value
+	return value + r.offset
+}
+
+// logCounterResets logs how many counter resets were adjusted in a file
+func (c *Converter) logCounterResets(filename string, resets int) {
+	if resets > 0 {
+		c.logger.Infof("Adjusted %d counter resets in %s", resets, filename)
+	}
+}
@@ -0,0 +1,65 @@
+package converter_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// counterAndGauge are the stats of the archives the tests of counter
+// handling write: a counter, written as operations_total, and a gauge
+var counterAndGauge = []gfs.StatDescriptor{
+	{Name: "operations", Type: gfs.StatTypeLong, IsCounter: true, LargerBetter: true},
+	{Name: "entries", Type: gfs.StatTypeLong},
+}
+
+// counterResetValues are the values of a counter that is reset after the
+// third sample, and counterAdjustedValues what adjusting the reset makes
+// of them
+var (
+	counterResetValues    = []float64{10, 20, 30, 5, 15}
+	counterAdjustedValues = []float64{10, 20, 30, 35, 45}
+)
+
+// TestAdjustCounterResets checks that a counter reset mid-archive is
+// written as it is read or, with reset adjustment, in memory and
+// streamed, kept monotonic, and that a gauge with the same values is
+// never adjusted
+func TestAdjustCounterResets(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeInstance(t, dir, gfstest.Instance{
+		Start:  testStart,
+		Stats:  counterAndGauge,
+		Values: [][]float64{counterResetValues, counterResetValues},
+	})
+	const counter = "gemfire_selfteststats0_operations_total"
+	tests := []struct {
+		name    string
+		options converter.Options
+		want    []float64
+	}{
+		{"raw", converter.Options{}, counterResetValues},
+		{"adjusted", converter.Options{AdjustCounterResets: true}, counterAdjustedValues},
+		{"adjusted low memory", converter.Options{AdjustCounterResets: true, LowMemory: true}, counterAdjustedValues},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tsdbPath := filepath.Join(t.TempDir(), "tsdb")
+			mustConvert(t, archive, tsdbPath, "", tt.options)
+			values := make(map[string][]float64)
+			for _, s := range instanceSeries(t, tsdbPath, testStart, len(counterResetValues)+1) {
+				values[s.Labels["__name__"]] = s.Values
+			}
+			if got := values[counter]; fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%s is %v, want %v", counter, got, tt.want)
+			}
+			if got := values["gemfire_selfteststats0_entries"]; fmt.Sprint(got) != fmt.Sprint(counterResetValues) {
+				t.Errorf("gauge entries is %v, want %v", got, counterResetValues)
+			}
+		})
+	}
+}
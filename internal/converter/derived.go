@@ -0,0 +1,227 @@
+package converter
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/config"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+)
+
+// derivedMetric is a derived metric of the config resolved against a
+// resource type of an archive: stats holds the stat read as each of its
+// operands
+type derivedMetric struct {
+	metric *config.DerivedMetric
+	stats  []*gfs.StatDescriptor
+}
+
+// derivedMetrics returns the derived metrics computed from stats of a
+// resource type, leaving out those reading a stat it does not have
+func (c *Converter) derivedMetrics(resType *gfs.ResourceType) []derivedMetric {
+	var metrics []derivedMetric
+metrics:
+	for _, metric := range c.config.DerivedFor(resType.Name) {
+		d := derivedMetric{metric: metric}
+		for _, operand := range metric.Operands() {
+			stat := findStat(resType, operand)
+			if stat == nil {
+				c.logger.Debugf("Not deriving %s: %s has no stat %s", metric.Name, resType.Name, operand)
+				continue metrics
+			}
+			d.stats = append(d.stats, stat)
+		}
+		c.addMetadata(metric.Name, MetricMetadata{Type: MetricGauge, Help: metric.Expr})
+		metrics = append(metrics, d)
+	}
+	return metrics
+}
+
+// findStat returns the stat of a resource type with a name, matched
+// without regard to case, or nil
+func findStat(resType *gfs.ResourceType, name string) *gfs.StatDescriptor {
+	for i := range resType.Stats {
+		if strings.EqualFold(resType.Stats[i].Name, name) {
+			return &resType.Stats[i]
+		}
+	}
+	return nil
+}
+
+// derivedInstance computes the derived metrics of one instance from its
+// samples as they are read. Every operand keeps its latest value, as a
+// stat the archive does not sample at a tick has not changed, so a metric
+// is written at every timestamp at which one of its operands was sampled,
+// once each of them has been. A timestamp at which it divides by zero is
+// left out.
+type derivedInstance struct {
+	// labels are those of the instance's derived series
+	labels map[string]string
+	series []*derivedSeries
+	// operands lists, for each stat, the series reading it and as which
+	// of their operands
+	operands map[*gfs.StatDescriptor][]derivedOperand
+}
+
+type derivedOperand struct {
+	series  *derivedSeries
+	operand int
+}
+
+// derivedSeries is the series of one derived metric of an instance
+type derivedSeries struct {
+	metric *config.DerivedMetric
+	values []float64
+	known  []bool
+	// unknown counts the operands not sampled yet
+	unknown int
+	// pending is the timestamp of the latest operand sample, to be
+	// evaluated once every operand sampled at it has been added
+	pending    time.Time
+	hasPending bool
+	// last is the timestamp of the latest sample written, if any
+	last    time.Time
+	written bool
+
+	write func(t time.Time, value float64) error
+	ds    resampler
+}
+
+// newDerivedInstance starts the derived metrics of an instance whose
+// series have labels, writing their samples with write, resampled if
+// Downsample or Align is set
+func (c *Converter) newDerivedInstance(metrics []derivedMetric, labels map[string]string, write func(metricName string, labels map[string]string, t time.Time, value float64) error) *derivedInstance {
+	d := &derivedInstance{labels: labels, operands: make(map[*gfs.StatDescriptor][]derivedOperand)}
+	for _, m := range metrics {
+		name := m.metric.Name
+		series := &derivedSeries{
+			metric:  m.metric,
+			values:  make([]float64, len(m.stats)),
+			known:   make([]bool, len(m.stats)),
+			unknown: len(m.stats),
+		}
+		series.write = func(t time.Time, value float64) error {
+			return write(name, labels, t, value)
+		}
+		series.ds = c.newResampler(false, series.write)
+		for i, stat := range m.stats {
+			d.operands[stat] = append(d.operands[stat], derivedOperand{series: series, operand: i})
+		}
+		d.series = append(d.series, series)
+	}
+	return d
+}
+
+// add adds a sample of a stat, writing the samples of the metrics reading
+// it that were pending at an earlier timestamp
+func (d *derivedInstance) add(stat *gfs.StatDescriptor, t time.Time, value float64) error {
+	for _, o := range d.operands[stat] {
+		s := o.series
+		if s.hasPending && !t.Equal(s.pending) {
+			if err := s.evaluate(); err != nil {
+				return err
+			}
+		}
+		if !s.known[o.operand] {
+			s.known[o.operand] = true
+			s.unknown--
+		}
+		s.values[o.operand] = value
+		s.pending, s.hasPending = t, true
+	}
+	return nil
+}
+
+// flush writes the samples still pending once every sample of the
+// instance has been added
+func (d *derivedInstance) flush() error {
+	for _, s := range d.series {
+		if s.hasPending {
+			if err := s.evaluate(); err != nil {
+				return err
+			}
+		}
+		if s.ds != nil {
+			if err := s.ds.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// downsampled returns the number of samples handed to the resamplers
+func (d *derivedInstance) downsampled() int {
+	samples := 0
+	for _, s := range d.series {
+		if s.ds != nil {
+			samples += s.ds.added()
+		}
+	}
+	return samples
+}
+
+// evaluate writes the sample pending, if every operand has a value and
+// the expression does not divide by zero
+func (s *derivedSeries) evaluate() error {
+	s.hasPending = false
+	if s.unknown > 0 {
+		return nil
+	}
+	value, ok := s.metric.Eval(s.values)
+	if !ok {
+		return nil
+	}
+	s.last, s.written = s.pending, true
+	if s.ds != nil {
+		return s.ds.add(s.pending, value)
+	}
+	return s.write(s.pending, value)
+}
+
+// derivedSample is a sample of an operand of a derived metric, ordered by
+// time to compute the metrics of an instance read in memory
+type derivedSample struct {
+	stat  *gfs.StatDescriptor
+	time  time.Time
+	value float64
+}
+
+// addAll adds every sample of the operands of the instance's derived
+// metrics, read in memory, in the order of their timestamps
+func (d *derivedInstance) addAll(resType *gfs.ResourceType, instance *gfs.ResourceInstance) error {
+	var samples []derivedSample
+	for i := range resType.Stats {
+		stat := &resType.Stats[i]
+		if _, ok := d.operands[stat]; !ok {
+			continue
+		}
+		for _, sample := range instance.Stats[int32(i)] {
+			samples = append(samples, derivedSample{stat: stat, time: sample.Time(), value: sample.Value})
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].time.Before(samples[j].time)
+	})
+	for _, sample := range samples {
+		if err := d.add(sample.stat, sample.time, sample.value); err != nil {
+			return err
+		}
+	}
+	return d.flush()
+}
+
+// markStale ends every derived series of the instance deleted at deleted
+// that was written with a staleness marker, written with mark
+func (d *derivedInstance) markStale(deleted time.Time, mark func(metricName string, labels map[string]string, t time.Time) error) error {
+	for _, s := range d.series {
+		if !s.written {
+			continue
+		}
+		if err := mark(s.metric.Name, d.labels, staleTime(deleted, s.last)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package converter_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// derivedConfig derives the hit ratio of the hits and misses stats
+const derivedConfig = `derived_metrics:
+  - name: selftest_hit_ratio
+    expr: selfteststats0.hits / (SelfTestStats0.hits + SelfTestStats0.misses)
+`
+
+// TestDerivedMetrics checks that converting two stats sampled at the same
+// ticks in memory and streamed with derivedConfig writes their ratio at
+// every tick it is defined, labeled as the instance's stats are. The tick
+// where both are 0 is left out.
+func TestDerivedMetrics(t *testing.T) {
+	dir := t.TempDir()
+	hits := []float64{1, 3, 0, 6, 8}
+	misses := []float64{1, 1, 0, 2, 0}
+	archive := writeInstance(t, dir, gfstest.Instance{
+		Start: testStart,
+		Stats: []gfs.StatDescriptor{
+			{Name: "hits", Type: gfs.StatTypeLong},
+			{Name: "misses", Type: gfs.StatTypeLong},
+		},
+		Values: [][]float64{hits, misses},
+	})
+	configFile := writeConfig(t, dir, derivedConfig)
+
+	var want []timedValue
+	for k := range hits {
+		if total := hits[k] + misses[k]; total != 0 {
+			want = append(want, timedValue{k + 1, hits[k] / total})
+		}
+	}
+	end := testStart.Add(time.Duration(len(hits)+1) * time.Second)
+	for _, lowMemory := range []bool{false, true} {
+		tsdbPath := filepath.Join(t.TempDir(), "tsdb")
+		mustConvert(t, archive, tsdbPath, configFile, converter.Options{LowMemory: lowMemory})
+		series := selectSeries(t, tsdbPath, testStart, end, map[string]string{"__name__": "selftest_hit_ratio"})
+		if len(series) != 1 {
+			t.Fatalf("low memory %t: %d derived series, want 1", lowMemory, len(series))
+		}
+		labels := series[0].Labels
+		if labels[converter.LabelResourceType] != gfstest.TypeName(0) || labels[converter.LabelInstance] != gfstest.InstanceName(0, 0) {
+			t.Errorf("low memory %t: derived series has labels %v, want those of %s", lowMemory, labels, gfstest.InstanceName(0, 0))
+		}
+		if got := timedValues(series[0], testStart); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("low memory %t: derived series has samples %v, want %v", lowMemory, got, want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/pkg/events"
+)
+
+// Descriptor conflict policies, selected with Options.DescriptorConflicts
+const (
+	// ConflictSuffix writes each changed variant of a stat to its own
+	// series, metric_v2, metric_v3, ...
+	ConflictSuffix = "suffix"
+	// ConflictNormalize rescales values whose unit changed to the unit seen
+	// first, falling back to suffixing when the units cannot be converted
+	ConflictNormalize = "normalize"
+	// ConflictFail refuses to convert a file that conflicts with an earlier
+	// one
+	ConflictFail = "fail"
+)
+
+// descriptorSignature is the part of a stat descriptor that changes the
+// meaning of its values
+type descriptorSignature struct {
+	Unit      string
+	IsCounter bool
+}
+
+func (s descriptorSignature) String() string {
+	kind := "gauge"
+	if s.IsCounter {
+		kind = "counter"
+	}
+	return fmt.Sprintf("unit=%q %s", s.Unit, kind)
+}
+
+// descriptorVariant is one signature seen for a metric and where it was
+// first seen
+type descriptorVariant struct {
+	signature descriptorSignature
+	file      string
+	product   string
+}
+
+// DescriptorConflict records a stat whose descriptor differs between files
+type DescriptorConflict struct {
+	Metric     string
+	First      descriptorVariant
+	Changed    descriptorVariant
+	Resolution string
+}
+
+func (dc DescriptorConflict) String() string {
+	return fmt.Sprintf("%s: %s (%s) has %s, %s (%s) has %s; %s",
+		dc.Metric,
+		dc.First.file, dc.First.product, dc.First.signature,
+		dc.Changed.file, dc.Changed.product, dc.Changed.signature,
+		dc.Resolution)
+}
+
+// statResolution is how the samples of one stat of a file are written
+type statResolution struct {
+	metricName string
+	scale      float64
+}
+
+// DescriptorResolutions maps the stats of one file to the series they are
+// written to
+type DescriptorResolutions struct {
+	stats map[string]statResolution
+}
+
+// Resolve returns the metric name to write a stat to and the factor to
+// scale its values by
+func (r *DescriptorResolutions) Resolve(resourceType, stat, metricName string) (string, float64) {
+	if res, ok := r.stats[resourceType+"."+stat]; ok {
+		return res.metricName, res.scale
+	}
+	return metricName, 1
+}
+
+// ResolveDescriptors compares the descriptors of every stat with data in a
+// file against those seen earlier in the run and decides, according to
+// the conflict policy, where each stat is written. With the fail policy
+// nothing is recorded and an error listing the conflicts is returned.
+func (c *Converter) ResolveDescriptors(filename, product string, types map[int32]*gfs.ResourceType,
+	instances map[int32]*gfs.ResourceInstance, metricName func(resourceType string, stat *gfs.StatDescriptor) string) (*DescriptorResolutions, error) {
+
+	c.descriptorsMu.Lock()
+	defer c.descriptorsMu.Unlock()
+
+	resolutions := &DescriptorResolutions{stats: make(map[string]statResolution)}
+	pending := make(map[string]descriptorVariant)
+	var conflicts []DescriptorConflict
+
+	for _, instance := range gfs.SortedInstances(instances) {
+		resType, ok := types[instance.TypeID]
+		if !ok {
+			continue
+		}
+		for _, statID := range gfs.SortedStatIDs(instance.Stats) {
+			if int(statID) >= len(resType.Stats) || len(instance.Stats[statID]) == 0 {
+				continue
+			}
+			stat := resType.Stats[statID]
+			key := resType.Name + "." + stat.Name
+			if _, done := resolutions.stats[key]; done {
+				continue
+			}
+
+			name := metricName(resType.Name, &stat)
+			variant := descriptorVariant{
+				signature: descriptorSignature{Unit: stat.Unit, IsCounter: stat.IsCounter},
+				file:      filename,
+				product:   product,
+			}
+
+			res, conflict := c.resolveVariant(name, variant, pending)
+			resolutions.stats[key] = res
+			if conflict != nil {
+				conflicts = append(conflicts, *conflict)
+			}
+		}
+	}
+
+	if len(conflicts) > 0 && c.conflictPolicy() == ConflictFail {
+		lines := make([]string, len(conflicts))
+		for i, conflict := range conflicts {
+			conflict.Resolution = "file rejected"
+			lines[i] = "  " + conflict.String()
+		}
+		return nil, fmt.Errorf("stat descriptors in %s conflict with earlier files:\n%s",
+			filename, strings.Join(lines, "\n"))
+	}
+
+	for name, variant := range pending {
+		c.descriptors[name] = append(c.descriptors[name], variant)
+	}
+	for _, conflict := range conflicts {
+		c.descriptorConflicts = append(c.descriptorConflicts, conflict)
+		c.Warn(events.WarningDescriptorConflict, filename, "Descriptor conflict for %s", conflict)
+	}
+
+	return resolutions, nil
+}
+
+// ResolveStat decides where one stat of a file that is being streamed is
+// written. Samples are written as they are read, so the descriptor is
+// registered at once rather than after the whole file has been checked;
+// with the fail policy a conflict is returned as an error.
+func (c *Converter) ResolveStat(filename, product string, stat *gfs.StatDescriptor, metricName string) (string, float64, error) {
+	c.descriptorsMu.Lock()
+	defer c.descriptorsMu.Unlock()
+
+	variant := descriptorVariant{
+		signature: descriptorSignature{Unit: stat.Unit, IsCounter: stat.IsCounter},
+		file:      filename,
+		product:   product,
+	}
+	pending := make(map[string]descriptorVariant)
+	res, conflict := c.resolveVariant(metricName, variant, pending)
+	if conflict != nil && c.conflictPolicy() == ConflictFail {
+		conflict.Resolution = "file rejected"
+		return "", 0, fmt.Errorf("stat descriptors in %s conflict with earlier files:\n  %s", filename, conflict)
+	}
+
+	for name, variant := range pending {
+		c.descriptors[name] = append(c.descriptors[name], variant)
+	}
+	if conflict != nil {
+		c.descriptorConflicts = append(c.descriptorConflicts, *conflict)
+		c.Warn(events.WarningDescriptorConflict, filename, "Descriptor conflict for %s", conflict)
+	}
+	return res.metricName, res.scale, nil
+}
+
+// resolveVariant decides where a stat with the given descriptor is
+// written. New variants are added to pending rather than registered, so
+// nothing is recorded for a file that is rejected.
+func (c *Converter) resolveVariant(name string, variant descriptorVariant, pending map[string]descriptorVariant) (statResolution, *DescriptorConflict) {
+	known := c.descriptors[name]
+	if len(known) == 0 {
+		pending[name] = variant
+		return statResolution{metricName: name, scale: 1}, nil
+	}
+
+	for i, existing := range known {
+		if existing.signature == variant.signature {
+			return statResolution{metricName: variantName(name, i), scale: 1}, nil
+		}
+	}
+
+	conflict := &DescriptorConflict{Metric: name, First: known[0], Changed: variant}
+
+	if c.conflictPolicy() == ConflictNormalize {
+		if scale, ok := unitScale(variant.signature, known[0].signature); ok {
+			conflict.Resolution = fmt.Sprintf("normalized to %q (x%g)", known[0].signature.Unit, scale)
+			return statResolution{metricName: name, scale: scale}, conflict
+		}
+	}
+
+	suffixed := variantName(name, len(known))
+	pending[name] = variant
+	conflict.Resolution = fmt.Sprintf("written as %s", suffixed)
+	return statResolution{metricName: suffixed, scale: 1}, conflict
+}
+
+// variantName returns the series name of the i-th descriptor variant of a
+// metric: the metric itself, then metric_v2, metric_v3, ...
+func variantName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s_v%d", name, i+1)
+}
+
+// DescriptorConflicts returns the conflicts found so far in the run
+func (c *Converter) DescriptorConflicts() []DescriptorConflict {
+	c.descriptorsMu.Lock()
+	defer c.descriptorsMu.Unlock()
+	return append([]DescriptorConflict(nil), c.descriptorConflicts...)
+}
+
+func (c *Converter) conflictPolicy() string {
+	if c.opts.DescriptorConflicts == "" {
+		return ConflictSuffix
+	}
+	return c.opts.DescriptorConflicts
+}
+
+// unitFactors gives the size of common GemFire stat units in a base unit of
+// their dimension, so values can be converted between them
+var unitFactors = map[string]struct {
+	dimension string
+	factor    float64
+}{
+	"nanoseconds":  {"time", 1e-9},
+	"nanos":        {"time", 1e-9},
+	"ns":           {"time", 1e-9},
+	"microseconds": {"time", 1e-6},
+	"micros":       {"time", 1e-6},
+	"us":           {"time", 1e-6},
+	"milliseconds": {"time", 1e-3},
+	"millis":       {"time", 1e-3},
+	"ms":           {"time", 1e-3},
+	"seconds":      {"time", 1},
+	"sec":          {"time", 1},
+	"s":            {"time", 1},
+	"bytes":        {"bytes", 1},
+	"kilobytes":    {"bytes", 1 << 10},
+	"kb":           {"bytes", 1 << 10},
+	"megabytes":    {"bytes", 1 << 20},
+	"mb":           {"bytes", 1 << 20},
+}
+
+// unitScale returns the factor that converts values with signature from
+// into the unit of signature to. Counter-ness must match.
+func unitScale(from, to descriptorSignature) (float64, bool) {
+	if from.IsCounter != to.IsCounter {
+		return 0, false
+	}
+	f, ok := unitFactors[strings.ToLower(strings.TrimSpace(from.Unit))]
+	if !ok {
+		return 0, false
+	}
+	t, ok := unitFactors[strings.ToLower(strings.TrimSpace(to.Unit))]
+	if !ok || f.dimension != t.dimension {
+		return 0, false
+	}
+	return f.factor / t.factor, true
+}
@@ -0,0 +1,128 @@
+package converter
+
+import "time"
+
+// downsampler reduces the samples of one series to one per bucket of a
+// fixed interval, aligned to the Unix epoch: the last value of a gauge or
+// the largest of a counter, at the time of the last sample of the bucket.
+// The first and last samples of the series are written as they are, so
+// rate() ranges over the series are not clipped.
+type downsampler struct {
+	interval int64 // In milliseconds
+	counter  bool
+	write    func(t time.Time, value float64) error
+
+	// The bucket being aggregated: the time of its latest sample, the
+	// value it is written with and the latest value, written instead if it
+	// is the last bucket
+	pending bool
+	bucket  int64
+	time    time.Time
+	value   float64
+	last    float64
+
+	// written is the time of the sample written last, if any
+	written    time.Time
+	hasWritten bool
+	// samples counts the samples added
+	samples int
+}
+
+// resampler reduces the samples of a series before they are written, to
+// one per bucket with Downsample or to the points of a grid with Align
+type resampler interface {
+	// add adds the next sample of the series
+	add(t time.Time, value float64) error
+	// flush writes what is still pending once every sample has been added
+	flush() error
+	// added returns the number of samples added
+	added() int
+}
+
+// newResampler returns the resampler Downsample or Align call for, for a
+// series of a counter stat or of a gauge, writing the samples it keeps
+// with write, or nil if neither is set
+func (c *Converter) newResampler(counter bool, write func(t time.Time, value float64) error) resampler {
+	switch {
+	case c.opts.Downsample > 0:
+		return newDownsampler(c.opts.Downsample, counter, write)
+	case c.opts.Align > 0:
+		return newAligner(c.opts.Align, c.opts.AlignMaxGap, counter, write)
+	}
+	return nil
+}
+
+// resamples reports whether the samples of every series are resampled
+func (c *Converter) resamples() bool {
+	return c.opts.Downsample > 0 || c.opts.Align > 0
+}
+
+// newDownsampler returns a downsampler for a series of a counter stat or
+// of a gauge that writes the samples it keeps with write
+func newDownsampler(interval time.Duration, counter bool, write func(t time.Time, value float64) error) *downsampler {
+	return &downsampler{interval: interval.Milliseconds(), counter: counter, write: write}
+}
+
+// add adds the next sample of the series, writing it if it is the first
+// and the bucket before it if it starts a new one
+func (d *downsampler) add(t time.Time, value float64) error {
+	d.samples++
+	if d.samples == 1 {
+		if err := d.emit(t, value); err != nil {
+			return err
+		}
+	}
+
+	bucket := t.UnixMilli() / d.interval
+	if d.pending && bucket != d.bucket {
+		if err := d.emit(d.time, d.value); err != nil {
+			return err
+		}
+		d.pending = false
+	}
+	if !d.pending {
+		d.pending, d.bucket, d.value = true, bucket, value
+	} else if !d.counter || value > d.value {
+		d.value = value
+	}
+	d.time, d.last = t, value
+	return nil
+}
+
+func (d *downsampler) added() int {
+	return d.samples
+}
+
+// flush writes the last sample of the series once every sample has been
+// added
+func (d *downsampler) flush() error {
+	if !d.pending {
+		return nil
+	}
+	d.pending = false
+	return d.emit(d.time, d.last)
+}
+
+// emit writes a sample unless one was just written at the same time, as
+// when the first sample is alone in its bucket
+func (d *downsampler) emit(t time.Time, value float64) error {
+	if d.hasWritten && t.Equal(d.written) {
+		return nil
+	}
+	d.written, d.hasWritten = t, true
+	return d.write(t, value)
+}
+
+// logDownsampled logs how many samples of a file were resampled to how
+// many
+func (c *Converter) logDownsampled(filename string, samples, kept int) {
+	if samples == 0 {
+		return
+	}
+	switch {
+	case c.opts.Downsample > 0:
+		c.logger.Infof("Downsampled %d samples of %s to %d, one per %s", samples, filename, kept, c.opts.Downsample)
+	case c.opts.Align > 0:
+		c.logger.Infof("Aligned %d samples of %s to %d grid points, one per %s", samples, filename, kept, c.opts.Align)
+	}
+}
@@ -0,0 +1,56 @@
+package converter_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// checkTransformed converts the archive of counterAndGauge starting at
+// base in memory and streamed with options, and checks that the first
+// synthetic instance's series hold the samples of want, by name, up to
+// the given number of seconds after base
+func checkTransformed(t *testing.T, archive string, base time.Time, seconds int, options converter.Options, want map[string][]timedValue) {
+	t.Helper()
+	for _, lowMemory := range []bool{false, true} {
+		options.LowMemory = lowMemory
+		tsdbPath := filepath.Join(t.TempDir(), "tsdb")
+		mustConvert(t, archive, tsdbPath, "", options)
+		series := instanceSeries(t, tsdbPath, base, seconds)
+		if len(series) != len(want) {
+			t.Fatalf("low memory %t: %d series, want %d", lowMemory, len(series), len(want))
+		}
+		for _, s := range series {
+			name := s.Labels["__name__"]
+			if got := timedValues(s, base); fmt.Sprint(got) != fmt.Sprint(want[name]) {
+				t.Errorf("low memory %t: %s has samples %v, want %v", lowMemory, name, got, want[name])
+			}
+		}
+	}
+}
+
+// TestDownsample checks what downsampling a gauge and a counter, sampled a
+// second apart from a second after a bucket boundary, keeps: the first and
+// last samples, and the last value of the gauge and the largest of the
+// counter in each bucket, at the last sample of the bucket, the sample on
+// a boundary starting the next bucket. The counter is reset in the second
+// bucket.
+func TestDownsample(t *testing.T) {
+	const interval = 5 * time.Second
+	base := testStart.Truncate(interval)
+	gauge := []float64{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5, 8}
+	counter := []float64{10, 20, 30, 40, 50, 60, 5, 15, 25, 35, 45, 55}
+	archive := writeInstance(t, t.TempDir(), gfstest.Instance{
+		Start:  base,
+		Stats:  counterAndGauge,
+		Values: [][]float64{counter, gauge},
+	})
+	checkTransformed(t, archive, base, len(gauge)+1, converter.Options{Downsample: interval}, map[string][]timedValue{
+		"gemfire_selfteststats0_entries":          {{1, 3}, {4, 1}, {9, 5}, {12, 8}},
+		"gemfire_selfteststats0_operations_total": {{1, 10}, {4, 40}, {9, 60}, {12, 55}},
+	})
+}
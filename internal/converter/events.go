@@ -0,0 +1,157 @@
+package converter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/pkg/events"
+)
+
+// progressInterval is the minimum time between progress events for a file
+const progressInterval = time.Second
+
+// runTotals accumulates the run_completed summary across files
+type runTotals struct {
+	start   time.Time
+	files   int
+	failed  int
+	samples int
+	// duplicates, outside, overLimit, future, quarantine, filtered and
+	// invalid count the samples skipped or set aside as in RunSummary
+	duplicates int
+	outside    int
+	overLimit  int
+	future     int
+	quarantine int
+	filtered   int
+	invalid    int
+	// results holds the summary of every file, in the order they ended
+	results []FileResult
+}
+
+// Warn logs a warning and writes it to the event stream with its class
+func (c *Converter) Warn(class, filename, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	c.logger.Warnf("%s", message)
+	c.opts.Events.Emit(events.Event{
+		Type:    events.Warning,
+		File:    filename,
+		Warning: &events.WarningInfo{Class: class, Message: message},
+	})
+}
+
+// TrackFile wraps the conversion of one file with file_started and
+// file_completed events and adds its outcome to the run totals
+func (c *Converter) TrackFile(filename string, convert func() (events.FileSummary, error)) error {
+	if c.interrupted.Load() {
+		return ErrInterrupted
+	}
+	c.opts.Events.Emit(events.Event{Type: events.FileStarted, File: filename})
+
+	start := time.Now()
+	c.writer.BeginSource(filename)
+	c.beginFuture(filename)
+	summary, err := convert()
+	summary.FutureSamples = c.endFuture(filename)
+	summary.SkippedDuplicates = c.writer.Duplicates(filename)
+	summary.SamplesOutsideWindow = c.writer.Excluded(filename)
+	summary.SkippedSeriesLimit = c.writer.OverSeriesLimit(filename)
+	summary.QuarantinedSamples = c.writer.Quarantined(filename)
+	c.summarizeSource(filename, &summary)
+	c.writer.EndSource(filename)
+	c.fileEnded(filename, summary.LastSample)
+	if summary.SkippedDuplicates > 0 {
+		c.logger.Infof("Skipped %d samples of %s already written", summary.SkippedDuplicates, filename)
+	}
+	if summary.SamplesOutsideWindow > 0 {
+		c.logger.Infof("Skipped %d samples of %s outside the time window %s", summary.SamplesOutsideWindow, filename, c.opts.Window)
+	}
+	if summary.FutureSamples > 0 {
+		c.logger.Warnf("%d samples of %s are dated more than %s in the future, handled with policy %s", summary.FutureSamples, filename, c.opts.MaxFuture, c.futurePolicy())
+	}
+	if summary.QuarantinedSamples > 0 {
+		c.logger.Warnf("%d samples of %s were rejected by the TSDB and quarantined in %s", summary.QuarantinedSamples, filename, c.writer.QuarantinePath())
+	}
+	if summary.SkippedSeriesLimit > 0 {
+		c.logger.Infof("Skipped %d samples of %s in series over the limit of %d series", summary.SkippedSeriesLimit, filename, c.opts.MaxSeries)
+	}
+	summary.DurationSeconds = time.Since(start).Seconds()
+	if err != nil {
+		summary.Error = err.Error()
+	}
+
+	c.totalsMu.Lock()
+	c.totals.files++
+	c.totals.samples += summary.SamplesWritten
+	c.totals.duplicates += summary.SkippedDuplicates
+	c.totals.outside += summary.SamplesOutsideWindow
+	c.totals.overLimit += summary.SkippedSeriesLimit
+	c.totals.future += summary.FutureSamples
+	c.totals.quarantine += summary.QuarantinedSamples
+	c.totals.filtered += summary.SkippedFiltered
+	c.totals.invalid += summary.SkippedInvalid
+	if err != nil {
+		c.totals.failed++
+	}
+	c.totals.results = append(c.totals.results, FileResult{File: filename, FileSummary: summary})
+	c.totalsMu.Unlock()
+
+	c.opts.Events.Emit(events.Event{Type: events.FileCompleted, File: filename, Summary: &summary})
+	return err
+}
+
+// EmitRunCompleted writes the run_completed event for a batch run
+func (c *Converter) EmitRunCompleted() {
+	c.totalsMu.Lock()
+	run := events.RunSummary{
+		Files:           c.totals.files,
+		FailedFiles:     c.totals.failed,
+		SamplesWritten:  c.totals.samples,
+		DurationSeconds: time.Since(c.totals.start).Seconds(),
+
+		SkippedDuplicates:    c.totals.duplicates,
+		SamplesOutsideWindow: c.totals.outside,
+		SkippedSeriesLimit:   c.totals.overLimit,
+		DroppedSeries:        c.writer.DroppedSeries(),
+		FutureSamples:        c.totals.future,
+		QuarantinedSamples:   c.totals.quarantine,
+		SkippedFiltered:      c.totals.filtered,
+		SkippedInvalid:       c.totals.invalid,
+	}
+	c.totalsMu.Unlock()
+
+	c.opts.Events.Emit(events.Event{Type: events.RunCompleted, Run: &run})
+}
+
+// ProgressReporter emits progress events for one file, at most once per
+// progressInterval
+type ProgressReporter struct {
+	events   *events.Writer
+	filename string
+	total    int
+	last     time.Time
+}
+
+// NewProgressReporter returns a reporter for a file with the given number
+// of instances
+func (c *Converter) NewProgressReporter(filename string, instances int) *ProgressReporter {
+	return &ProgressReporter{events: c.opts.Events, filename: filename, total: instances, last: time.Now()}
+}
+
+// Update reports that done instances and samples have been converted
+func (p *ProgressReporter) Update(done, samples int) {
+	if p.events == nil || time.Since(p.last) < progressInterval {
+		return
+	}
+	p.last = time.Now()
+
+	p.events.Emit(events.Event{
+		Type: events.Progress,
+		File: p.filename,
+		Progress: &events.ProgressInfo{
+			InstancesDone:  done,
+			InstancesTotal: p.total,
+			SamplesWritten: samples,
+		},
+	})
+}
@@ -0,0 +1,46 @@
+package converter
+
+// InstanceFilterCount is the number of instances an instance filter left
+// out of the files converted so far
+type InstanceFilterCount struct {
+	Filter    string `json:"filter"`
+	Instances int    `json:"instances"`
+}
+
+// includesInstance reports whether an instance passes the instance
+// filters, counting it against the filter that left it out if not
+func (c *Converter) includesInstance(instance string) bool {
+	filter := c.config.Filters.InstanceFilter(instance)
+	if filter == "" {
+		return true
+	}
+	c.instancesMu.Lock()
+	if c.instancesFiltered == nil {
+		c.instancesFiltered = make(map[string]int)
+	}
+	c.instancesFiltered[filter]++
+	c.instancesMu.Unlock()
+	return false
+}
+
+// InstanceFilterCounts returns the number of instances each instance
+// filter left out, include_instances first and then every exclude_instances
+// pattern in order, including those that left out none
+func (c *Converter) InstanceFilterCounts() []InstanceFilterCount {
+	filters := c.config.Filters
+	var names []string
+	if len(filters.IncludeInstances) > 0 {
+		names = append(names, "include_instances")
+	}
+	for _, pattern := range filters.ExcludeInstances {
+		names = append(names, "exclude_instances: "+pattern)
+	}
+
+	c.instancesMu.Lock()
+	defer c.instancesMu.Unlock()
+	counts := make([]InstanceFilterCount, 0, len(names))
+	for _, name := range names {
+		counts = append(counts, InstanceFilterCount{Filter: name, Instances: c.instancesFiltered[name]})
+	}
+	return counts
+}
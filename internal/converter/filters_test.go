@@ -0,0 +1,41 @@
+package converter_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// filterConfig excludes the first synthetic type and two stats of every
+// type, one by its bare name and one by its qualified name
+const filterConfig = `filters:
+  exclude_resource_types: ["*Stats0"]
+  exclude_stats: ["*Average", "SelfTestStats*.online"]
+`
+
+// TestFilters checks that converting with filterConfig, in memory and
+// through a spill file, writes only the series of the types and stats it
+// keeps
+func TestFilters(t *testing.T) {
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	configFile := writeConfig(t, dir, filterConfig)
+	for _, lowMemory := range []bool{false, true} {
+		tsdbPath := filepath.Join(t.TempDir(), "tsdb")
+		mustConvert(t, archive, tsdbPath, configFile, converter.Options{LowMemory: lowMemory})
+		for ty := 0; ty < testOptions.Types; ty++ {
+			want := len(gfstest.StatTypes) - 2
+			if ty == 0 {
+				want = 0
+			}
+			for i := 0; i < testOptions.Instances; i++ {
+				series := selectSeries(t, tsdbPath, testStart, testEnd(testStart), map[string]string{converter.LabelResourceType: gfstest.TypeName(ty), converter.LabelInstance: gfstest.InstanceName(ty, i)})
+				if len(series) != want {
+					t.Errorf("low memory %t: %s has %d series with the filters, want %d", lowMemory, gfstest.InstanceName(ty, i), len(series), want)
+				}
+			}
+		}
+	}
+}
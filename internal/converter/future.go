@@ -0,0 +1,136 @@
+package converter
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Future sample policies, selected with Options.FutureSamples
+const (
+	// FutureDrop skips the samples dated after the future limit
+	FutureDrop = "drop"
+	// FutureClamp writes the first sample of a series dated after the
+	// future limit at the time of the conversion and skips the rest of
+	// them, which would share its timestamp
+	FutureClamp = "clamp"
+	// FutureKeep writes them as they are, only counting them
+	FutureKeep = "keep"
+)
+
+// ValidFuturePolicy reports whether policy is a future sample policy;
+// empty is FutureDrop
+func ValidFuturePolicy(policy string) bool {
+	switch policy {
+	case "", FutureDrop, FutureClamp, FutureKeep:
+		return true
+	}
+	return false
+}
+
+// futurePolicy returns the future sample policy of the converter
+func (c *Converter) futurePolicy() string {
+	if c.opts.FutureSamples == "" {
+		return FutureDrop
+	}
+	return c.opts.FutureSamples
+}
+
+// futureFile tracks the samples of one file dated after the future limit
+type futureFile struct {
+	// now is the wall clock when the file's conversion began, and limit
+	// now plus MaxFuture
+	now, limit time.Time
+	// samples counts the samples after the limit, and clamped holds the
+	// series that had one clamped, by seriesID
+	samples int
+	clamped map[string]bool
+}
+
+// futures holds the files being converted while MaxFuture is set, by
+// name. Files converted concurrently check their samples concurrently.
+type futures struct {
+	mu    sync.Mutex
+	files map[string]*futureFile
+}
+
+// beginFuture starts checking the samples of filename against the wall
+// clock, if MaxFuture is set
+func (c *Converter) beginFuture(filename string) {
+	if c.opts.MaxFuture <= 0 {
+		return
+	}
+	now := time.Now()
+	c.futures.mu.Lock()
+	defer c.futures.mu.Unlock()
+	if c.futures.files == nil {
+		c.futures.files = make(map[string]*futureFile)
+	}
+	c.futures.files[filename] = &futureFile{now: now, limit: now.Add(c.opts.MaxFuture), clamped: make(map[string]bool)}
+}
+
+// endFuture stops checking the samples of filename, returning how many
+// were dated after the future limit
+func (c *Converter) endFuture(filename string) int {
+	c.futures.mu.Lock()
+	defer c.futures.mu.Unlock()
+	file := c.futures.files[filename]
+	if file == nil {
+		return 0
+	}
+	delete(c.futures.files, filename)
+	return file.samples
+}
+
+// futureTime checks a sample of filename at t of the series of metricName
+// with labels against the future limit, returning the time to write it at
+// and false if it is skipped as the FutureSamples policy says. A staleness
+// marker, if marker is set, is not counted and is only kept by FutureKeep.
+// A sample of a remapped file is checked at the time it is moved to.
+func (c *Converter) futureTime(filename, metricName string, labels map[string]string, t time.Time, marker bool) (time.Time, bool) {
+	if c.opts.MaxFuture <= 0 {
+		return t, true
+	}
+	c.futures.mu.Lock()
+	defer c.futures.mu.Unlock()
+	file := c.futures.files[filename]
+	shift := c.writer.TimeShift(filename)
+	if file == nil || !t.Add(shift).After(file.limit) {
+		return t, true
+	}
+	if marker {
+		return t, c.futurePolicy() == FutureKeep
+	}
+	file.samples++
+	switch c.futurePolicy() {
+	case FutureKeep:
+		return t, true
+	case FutureClamp:
+		key := seriesID(metricName, labels)
+		if file.clamped[key] {
+			return t, false
+		}
+		file.clamped[key] = true
+		return file.now.Add(-shift), true
+	}
+	return t, false
+}
+
+// seriesID identifies the series of metricName with labels
+func seriesID(metricName string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(metricName)
+	for _, name := range names {
+		b.WriteByte(0xff)
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(labels[name])
+	}
+	return b.String()
+}
@@ -0,0 +1,67 @@
+package converter
+
+import (
+	"path/filepath"
+	"strconv"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/config"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+)
+
+// The labels that identify the series of an instance. Both convert and
+// cluster write them; cluster adds the cluster, node and node_type labels.
+const (
+	LabelResourceType = "resource_type"
+	LabelInstance     = "instance"
+)
+
+// LabelState is the label that carries the value of a stat written as a
+// state metric
+const LabelState = "state"
+
+// InstanceLabels returns the labels that identify the series of an
+// instance, or with legacy the job, statType and statName labels convert
+// wrote before, statName holding the instance name
+func InstanceLabels(resourceType, instanceName string, legacy bool) map[string]string {
+	if legacy {
+		return map[string]string{
+			"job":      "gfs-to-prometheus",
+			"statType": resourceType,
+			"statName": instanceName,
+		}
+	}
+	return map[string]string{
+		LabelResourceType: resourceType,
+		LabelInstance:     instanceName,
+	}
+}
+
+// identityLabels are the labels of InstanceLabels that a metric mapping's
+// instance label replaces, canonical and legacy
+var identityLabels = []string{LabelResourceType, LabelInstance, "statType", "statName"}
+
+// sourceLabels returns the labels of an instance with the source labels
+// cfg selects added, copying them first if it selects any
+func sourceLabels(cfg *config.Config, labels map[string]string, filename string, info gfs.ArchiveInfo) map[string]string {
+	selected := cfg.SourceLabels
+	if !selected.File && !selected.SystemID && !selected.Host && !selected.OS {
+		return labels
+	}
+	result := make(map[string]string, len(labels)+4)
+	for name, value := range labels {
+		result[name] = value
+	}
+	if selected.File {
+		result["source_file"] = filepath.Base(filename)
+	}
+	if selected.SystemID {
+		result["system_id"] = strconv.FormatInt(info.SystemID, 10)
+	}
+	if host := info.Hostname(); selected.Host && host != "" {
+		result["host"] = host
+	}
+	if selected.OS && info.OSInfo != "" {
+		result["os"] = info.OSInfo
+	}
+	return result
+}
@@ -0,0 +1,46 @@
+package converter_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// labelConfig renames the labels of every instance to the legacy ones
+const labelConfig = `label_mappings:
+  resource_type: statType
+  instance: statName
+`
+
+// TestRenameLabels checks that legacy labels and labelConfig both write
+// every series of an instance under statType and statName instead of
+// resource_type and instance
+func TestRenameLabels(t *testing.T) {
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	tests := []struct {
+		name       string
+		configFile string
+		options    converter.Options
+	}{
+		{"legacy", "", converter.Options{LegacyLabels: true}},
+		{"mapped", writeConfig(t, dir, labelConfig), converter.Options{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tsdbPath := filepath.Join(t.TempDir(), "tsdb")
+			mustConvert(t, archive, tsdbPath, tt.configFile, tt.options)
+			series := selectSeries(t, tsdbPath, testStart, testEnd(testStart), map[string]string{"statType": gfstest.TypeName(0), "statName": gfstest.InstanceName(0, 0)})
+			if len(series) != len(gfstest.StatTypes) {
+				t.Fatalf("%s has %d series labeled statType and statName, wrote %d stats", gfstest.InstanceName(0, 0), len(series), len(gfstest.StatTypes))
+			}
+			for _, s := range series {
+				if _, ok := s.Labels[converter.LabelResourceType]; ok {
+					t.Errorf("series %s still has a %s label", s.Labels["__name__"], converter.LabelResourceType)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package converter
+
+import (
+	"sort"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/config"
+	"github.com/4n3w/gfs-to-prometheus/pkg/events"
+)
+
+// mappingFor returns the metric mapping of a stat whose default metric
+// name is metricName, as config.Config.MappingFor does, and records that
+// its key matched
+func (c *Converter) mappingFor(resourceType, stat, metricName string) (config.MetricMapping, bool) {
+	mapping, key, ok := c.config.MappingFor(resourceType, stat, metricName)
+	c.mappingsMu.Lock()
+	c.mappingsChecked = true
+	if ok {
+		c.mappingsUsed[key] = true
+	}
+	c.mappingsMu.Unlock()
+	return mapping, ok
+}
+
+// UnmatchedMappings returns the keys of the metric mappings of the config
+// file, not the profile, that have not matched a stat of any file
+// converted so far, sorted
+func (c *Converter) UnmatchedMappings() []string {
+	c.mappingsMu.Lock()
+	defer c.mappingsMu.Unlock()
+	var keys []string
+	for key := range c.config.MetricMappings {
+		if !c.mappingsUsed[key] && !c.config.ProfileMapping(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// warnUnmatchedMappings warns about every metric mapping that matched no
+// stat in the run, which usually means its key is misspelled, unless no
+// stat was converted at all
+func (c *Converter) warnUnmatchedMappings() {
+	c.mappingsMu.Lock()
+	checked := c.mappingsChecked
+	c.mappingsMu.Unlock()
+	if !checked {
+		return
+	}
+	for _, key := range c.UnmatchedMappings() {
+		c.Warn(events.WarningUnknownMapping, "", "Metric mapping %q matched no stat", key)
+	}
+}
@@ -0,0 +1,198 @@
+package converter_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/config"
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+	"github.com/4n3w/gfs-to-prometheus/pkg/events"
+)
+
+// mappingConfig renames a stat by its qualified name, relabels one by its
+// default metric name, drops one of every type by a pattern and has a
+// mapping for a stat no type has
+const mappingConfig = `metric_mappings:
+  "SelfTestStats0.operations":
+    name: selftest_operations_total
+  "gemfire_selfteststats0_entries":
+    labels:
+      tier: gold
+  "SelfTestStats*.ratio":
+    drop: true
+  "SelfTestStats0.missing":
+    name: selftest_missing
+`
+
+// TestMappings checks the series of the stats of the first type
+// mappingConfig maps, and that the mapping that matches nothing is warned
+// about once
+func TestMappings(t *testing.T) {
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	tsdbPath := filepath.Join(dir, "tsdb")
+	var eventLog strings.Builder
+	mustConvert(t, archive, tsdbPath, writeConfig(t, dir, mappingConfig), converter.Options{Events: events.NewWriter(&eventLog)})
+
+	unknown := 0
+	for _, event := range readEvents(t, eventLog.String()) {
+		if event.Warning != nil && event.Warning.Class == events.WarningUnknownMapping {
+			if !strings.Contains(event.Warning.Message, "SelfTestStats0.missing") {
+				t.Errorf("unexpected warning %q", event.Warning.Message)
+			}
+			unknown++
+		}
+	}
+	if unknown != 1 {
+		t.Errorf("want 1 warning about the unknown mapping, got %d", unknown)
+	}
+
+	series := instanceSeries(t, tsdbPath, testStart, testOptions.Samples+1)
+	names := make(map[string]map[string]string, len(series))
+	for _, s := range series {
+		names[s.Labels["__name__"]] = s.Labels
+	}
+	if len(series) != len(gfstest.StatTypes)-1 {
+		t.Errorf("%s has %d series, want %d without the dropped stat", gfstest.InstanceName(0, 0), len(series), len(gfstest.StatTypes)-1)
+	}
+	if _, ok := names["gemfire_selfteststats0_ratio"]; ok {
+		t.Error("dropped stat ratio was written")
+	}
+	if _, ok := names["selftest_operations_total"]; !ok {
+		t.Error("renamed stat operations not written as selftest_operations_total")
+	}
+	if labels := names["gemfire_selfteststats0_entries"]; labels["tier"] != "gold" {
+		t.Errorf("relabeled stat entries has labels %v, want tier=gold", labels)
+	}
+}
+
+// negativeGauge is the values of a gauge that goes negative, as delta
+// gauges do, and rangeConfig bounds them to those that are not negative
+var negativeGauge = []float64{3, -2, 0, -70000, 12, -1}
+
+const rangeConfig = `metric_mappings:
+  SelfTestStats0.delta:
+    min: 0
+`
+
+// mappingReport lists the series the conversion of archive with the config
+// at configFile writes, and the mappings of the config that match nothing
+func mappingReport(t *testing.T, archive, configFile string, conv *converter.Converter) []byte {
+	t.Helper()
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := gfs.NewStatArchiveReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	reader.SetLogger(logging.Discard)
+	if err := reader.ReadArchive(); err != nil {
+		t.Fatal(err)
+	}
+	series, err := converter.ListSeries(reader, archive, cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) == 0 {
+		t.Fatal("no series listed")
+	}
+	report, err := json.Marshal(map[string]interface{}{"series": series, "unmatched": conv.UnmatchedMappings()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// TestStableOutput converts the synthetic archive twice with mappingConfig
+// and checks that both runs give the same mapping report and summary, and
+// write the same series
+func TestStableOutput(t *testing.T) {
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	configFile := writeConfig(t, dir, mappingConfig)
+
+	var mappings, summaries [2][]byte
+	var tsdbPaths [2]string
+	for run := range tsdbPaths {
+		tsdbPaths[run] = filepath.Join(dir, fmt.Sprintf("tsdb-%d", run))
+		conv := mustConvert(t, archive, tsdbPaths[run], configFile, converter.Options{})
+		mappings[run] = mappingReport(t, archive, configFile, conv)
+
+		report := conv.Report(nil)
+		for i := range report.Files {
+			// The only thing that may differ between runs
+			report.Files[i].DurationSeconds = 0
+		}
+		summary, err := json.Marshal(report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		summaries[run] = summary
+	}
+	if !bytes.Equal(mappings[0], mappings[1]) {
+		t.Errorf("mapping reports differ:\n%s\n%s", mappings[0], mappings[1])
+	}
+	if !bytes.Equal(summaries[0], summaries[1]) {
+		t.Errorf("summaries differ:\n%s\n%s", summaries[0], summaries[1])
+	}
+
+	for typ := 0; typ < testOptions.Types; typ++ {
+		labels := map[string]string{converter.LabelResourceType: gfstest.TypeName(typ)}
+		first := selectSeries(t, tsdbPaths[0], testStart, testEnd(testStart), labels)
+		second := selectSeries(t, tsdbPaths[1], testStart, testEnd(testStart), labels)
+		if fmt.Sprint(first) != fmt.Sprint(second) {
+			t.Errorf("the runs wrote different series of %s:\n%v\n%v", gfstest.TypeName(typ), first, second)
+		}
+	}
+}
+
+// TestNegativeValues checks that converting a gauge that goes negative
+// in memory and streamed writes every value, and only those that are not
+// negative with a metric mapping whose min is 0
+func TestNegativeValues(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeInstance(t, dir, gfstest.Instance{
+		Start:  testStart,
+		Stats:  []gfs.StatDescriptor{{Name: "delta", Type: gfs.StatTypeInt}},
+		Values: [][]float64{negativeGauge},
+	})
+	var bounded []float64
+	for _, v := range negativeGauge {
+		if v >= 0 {
+			bounded = append(bounded, v)
+		}
+	}
+	tests := []struct {
+		name       string
+		configFile string
+		want       []float64
+	}{
+		{"unbounded", "", negativeGauge},
+		{"min 0", writeConfig(t, dir, rangeConfig), bounded},
+	}
+	for _, tt := range tests {
+		for _, lowMemory := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/low memory %t", tt.name, lowMemory), func(t *testing.T) {
+				tsdbPath := filepath.Join(t.TempDir(), "tsdb")
+				mustConvert(t, archive, tsdbPath, tt.configFile, converter.Options{LowMemory: lowMemory})
+				series := instanceSeries(t, tsdbPath, testStart, len(negativeGauge)+1)
+				if len(series) != 1 {
+					t.Fatalf("%d series, want 1", len(series))
+				}
+				if fmt.Sprint(series[0].Values) != fmt.Sprint(tt.want) {
+					t.Errorf("values %v, want %v", series[0].Values, tt.want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+)
+
+// Metric types of MetricMetadata
+const (
+	MetricCounter = "counter"
+	MetricGauge   = "gauge"
+	// MetricUnknown is the type of the metrics of --legacy-parser, which
+	// reads no counter flags
+	MetricUnknown = "unknown"
+)
+
+// MetricMetadata is the HELP and TYPE of a metric as the Prometheus
+// metadata API returns them, with the unit of its stat in the archive
+type MetricMetadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// recordMetadata records the metadata of the stat written as metricName,
+// once for every distinct description, type and unit
+func (c *Converter) recordMetadata(metricName string, stat *gfs.StatDescriptor) {
+	meta := MetricMetadata{Type: MetricGauge, Help: stat.Description, Unit: stat.Unit}
+	if c.opts.LegacyParser {
+		meta.Type = MetricUnknown
+	} else if stat.IsCounter {
+		meta.Type = MetricCounter
+	}
+	c.addMetadata(metricName, meta)
+}
+
+// addMetadata records meta for metricName unless it already has been
+func (c *Converter) addMetadata(metricName string, meta MetricMetadata) {
+	c.metadataMu.Lock()
+	defer c.metadataMu.Unlock()
+	for _, seen := range c.metadata[metricName] {
+		if seen == meta {
+			return
+		}
+	}
+	c.metadata[metricName] = append(c.metadata[metricName], meta)
+}
+
+// Metadata returns the metadata of every stat metric written so far, by
+// metric name. A metric has several entries if the files disagree on its
+// description, type or unit.
+func (c *Converter) Metadata() map[string][]MetricMetadata {
+	c.metadataMu.Lock()
+	defer c.metadataMu.Unlock()
+	result := make(map[string][]MetricMetadata, len(c.metadata))
+	for name, metas := range c.metadata {
+		result[name] = append([]MetricMetadata(nil), metas...)
+	}
+	return result
+}
+
+// WriteMetadata writes the metadata of every stat metric written so far to
+// path: as "# HELP" and "# TYPE" lines if it ends with .txt or .prom, else
+// as the JSON object of metric names to entries the Prometheus metadata API
+// returns
+func (c *Converter) WriteMetadata(path string) error {
+	metadata := c.Metadata()
+	var data []byte
+	switch filepath.Ext(path) {
+	case ".txt", ".prom":
+		data = []byte(openMetricsMetadata(metadata))
+	default:
+		var err error
+		data, err = json.MarshalIndent(metadata, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	c.logger.Infof("Wrote the metadata of %d metrics to %s", len(metadata), path)
+	return nil
+}
+
+// openMetricsMetadata returns the "# HELP" and "# TYPE" lines of every
+// metric, sorted by name, taking the first entry of metrics with several
+func openMetricsMetadata(metadata map[string][]MetricMetadata) string {
+	names := make([]string, 0, len(metadata))
+	for name := range metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	escaper := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	var b strings.Builder
+	for _, name := range names {
+		meta := metadata[name][0]
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, escaper.Replace(meta.Help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, meta.Type)
+	}
+	return b.String()
+}
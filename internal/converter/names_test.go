@@ -0,0 +1,29 @@
+package converter_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+)
+
+// badNameConfig names one stat of each synthetic type invalidly, the
+// later stat of the earlier type
+const badNameConfig = `metric_name_template: '{{if or (and (eq .ResourceType "SelfTestStats0") (eq .Stat "threads")) (and (eq .ResourceType "SelfTestStats1") (eq .Stat "entries"))}}{{.Stat}}-invalid{{else}}{{.Prefix}}_{{snake .ResourceType}}_{{snake .Stat}}{{end}}'
+`
+
+// TestInvalidMetricName checks that a file whose stats the metric name
+// template names invalidly fails naming the first of them, by type and
+// then stat, on every run
+func TestInvalidMetricName(t *testing.T) {
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	configFile := writeConfig(t, dir, badNameConfig)
+	for run := 0; run < 5; run++ {
+		_, err := convertFile(archive, filepath.Join(t.TempDir(), "tsdb"), configFile, converter.Options{})
+		if err == nil || !strings.Contains(err.Error(), "SelfTestStats0.threads") {
+			t.Fatalf("run %d: want SelfTestStats0.threads named invalidly, got %v", run, err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/tsdb"
+	"github.com/4n3w/gfs-to-prometheus/pkg/events"
+)
+
+// Error policies, selected with Options.OnError
+const (
+	// OnErrorContinue quarantines a sample the TSDB rejects, skips a file
+	// that cannot be converted and goes on with the rest
+	OnErrorContinue = "continue"
+	// OnErrorSkipFile ends a file at its first sample that cannot be
+	// written, going on with the next file
+	OnErrorSkipFile = "skip-file"
+	// OnErrorAbort ends the run at the first sample that cannot be written
+	// or file that cannot be converted
+	OnErrorAbort = "abort"
+)
+
+// ValidOnError reports whether policy is an error policy; empty is
+// OnErrorContinue
+func ValidOnError(policy string) bool {
+	switch policy {
+	case "", OnErrorContinue, OnErrorSkipFile, OnErrorAbort:
+		return true
+	}
+	return false
+}
+
+// Aborts reports whether a file that failed with err ends the run: with
+// OnErrorAbort, or when it went over the series limit
+func (c *Converter) Aborts(err error) bool {
+	return c.OnError() == OnErrorAbort || errors.Is(err, tsdb.ErrSeriesLimit)
+}
+
+// OnError returns the error policy of the converter: what the commands
+// converting several files do when one fails
+func (c *Converter) OnError() string {
+	if c.opts.OnError == "" {
+		return OnErrorContinue
+	}
+	return c.opts.OnError
+}
+
+// writeFailed warns that a sample of filename could not be written with
+// err and returns the error that ends the file, or nil with
+// OnErrorContinue. Going over the series limit ends the file whatever the
+// policy.
+func (c *Converter) writeFailed(filename string, err error, format string, args ...any) error {
+	if errors.Is(err, tsdb.ErrSeriesLimit) {
+		return err
+	}
+	c.Warn(events.WarningWrite, filename, format, args...)
+	if c.OnError() == OnErrorContinue {
+		return nil
+	}
+	return fmt.Errorf(format, args...)
+}
@@ -0,0 +1,110 @@
+package converter
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/pkg/events"
+)
+
+// chronology holds the start time in the header of every file ordered by
+// OrderByStart and the time of the last sample every file wrote, to warn
+// about files that overlap
+type chronology struct {
+	mu     sync.Mutex
+	starts map[string]time.Time
+	ends   map[string]time.Time
+}
+
+// OrderByStart returns files sorted by the start time in their archive
+// headers, reading nothing else of them, so rolled archives are converted
+// oldest first rather than in the order they were named. Files whose
+// header cannot be read keep their order after the others, to fail when
+// they are converted. The legacy parser's files are left in order.
+func (c *Converter) OrderByStart(files []string) []string {
+	if c.opts.LegacyParser || len(files) < 2 {
+		return files
+	}
+
+	type archiveStart struct {
+		file  string
+		start time.Time
+		ok    bool
+	}
+	starts := make([]archiveStart, len(files))
+	for i, file := range files {
+		starts[i].file = file
+		start, err := c.readStartTime(file)
+		if err != nil {
+			c.logger.Debugf("Cannot order %s by start time: %v", file, err)
+			continue
+		}
+		starts[i].start, starts[i].ok = start, true
+	}
+	sort.SliceStable(starts, func(i, j int) bool {
+		a, b := starts[i], starts[j]
+		if a.ok != b.ok {
+			return a.ok
+		}
+		return a.ok && a.start.Before(b.start)
+	})
+
+	c.chronology.mu.Lock()
+	defer c.chronology.mu.Unlock()
+	if c.chronology.starts == nil {
+		c.chronology.starts = make(map[string]time.Time)
+	}
+	ordered := make([]string, len(starts))
+	for i, s := range starts {
+		ordered[i] = s.file
+		if s.ok {
+			c.chronology.starts[s.file] = s.start
+		}
+	}
+	return ordered
+}
+
+// readStartTime reads the start time in the header of the archive file,
+// adjusted for the timezone mode
+func (c *Converter) readStartTime(filename string) (time.Time, error) {
+	reader, err := gfs.NewStatArchiveReader(filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer reader.Close()
+	reader.SetTimeZoneMode(c.opts.TimeZoneMode)
+	reader.SetLogger(c.logger)
+	if err := reader.ReadHeaderOnly(); err != nil {
+		return time.Time{}, err
+	}
+	return reader.GetStartTime(), nil
+}
+
+// fileEnded records the time of the last sample a file wrote, if any
+func (c *Converter) fileEnded(filename string, last *time.Time) {
+	if last == nil {
+		return
+	}
+	c.chronology.mu.Lock()
+	defer c.chronology.mu.Unlock()
+	if c.chronology.ends == nil {
+		c.chronology.ends = make(map[string]time.Time)
+	}
+	c.chronology.ends[filename] = *last
+}
+
+// WarnOverlap warns if the file next, ordered by OrderByStart, starts
+// before the last sample of the file previous converted before it
+func (c *Converter) WarnOverlap(previous, next string) {
+	c.chronology.mu.Lock()
+	end, ended := c.chronology.ends[previous]
+	start, started := c.chronology.starts[next]
+	c.chronology.mu.Unlock()
+	if !ended || !started || !start.Before(end) {
+		return
+	}
+	c.Warn(events.WarningOverlap, next, "%s starts at %s, before the last sample of %s at %s; their samples overlap",
+		next, start.UTC().Format(time.RFC3339), previous, end.UTC().Format(time.RFC3339))
+}
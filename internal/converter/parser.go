@@ -0,0 +1,77 @@
+package converter
+
+import (
+	"fmt"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/pkg/events"
+)
+
+// Archive parsers, selected with Options.Parser
+const (
+	// ParserGo reads archives with gfs.StatArchiveReader
+	ParserGo = "go"
+	// ParserJava reads archives with the Java extractor, through
+	// gfs.JavaStatArchiveReader, which needs java and the extractor's
+	// libraries
+	ParserJava = "java"
+	// ParserAuto reads archives with gfs.StatArchiveReader, and again with
+	// the Java extractor if it found no samples in them
+	ParserAuto = "auto"
+)
+
+// ValidParser reports whether parser is an archive parser; empty is
+// ParserGo
+func ValidParser(parser string) bool {
+	switch parser {
+	case "", ParserGo, ParserJava, ParserAuto:
+		return true
+	}
+	return false
+}
+
+// fallBackToJava reports whether an archive the Go reader read with report
+// is converted again with the Java extractor, as ParserAuto does for an
+// archive in which it found no samples
+func (c *Converter) fallBackToJava(filename string, report *gfs.ParseReport) bool {
+	if c.opts.Parser != ParserAuto || report == nil || report.Samples > 0 {
+		return false
+	}
+	if !gfs.JavaAvailable() {
+		c.logger.Debugf("No samples read from %s, and java is not available to extract them", filename)
+		return false
+	}
+	c.logger.Infof("No samples read from %s, converting it with the Java extractor", filename)
+	return true
+}
+
+// convertJava converts a file with the Java extractor selected by
+// ParserJava, with the same filters, mappings and labels as the Go reader
+func (c *Converter) convertJava(filename, cluster string, labeler InstanceLabeler) (events.FileSummary, error) {
+	reader, err := gfs.NewJavaStatArchiveReader(filename)
+	if err != nil {
+		return events.FileSummary{}, fmt.Errorf("failed to create Java reader: %w", err)
+	}
+	defer reader.Close()
+
+	c.logger.Debugf("Parsing GFS file with the Java extractor: %s", filename)
+	if err := reader.ReadArchive(); err != nil {
+		return events.FileSummary{}, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+	if skipped := reader.SkippedValues(); skipped > 0 {
+		c.Warn(events.WarningParse, filename, "Skipped %d samples of %s the Java extractor gave no number for", skipped, filename)
+	}
+
+	// The extractor does not report the product description, so only
+	// corrections without a version restriction and prefix rules without a
+	// dialect apply
+	prefix, rule := c.filePrefix(filename, cluster, "")
+	summary, err := c.convertRead(reader, filename, prefix, c.NewValueCorrector(""), labeler)
+	summary.MetricPrefix, summary.PrefixRule = prefix, rule
+	// Samples the extractor gave no number for are invalid ones
+	summary.SkippedInvalid += reader.SkippedValues()
+	if err != nil {
+		return summary, err
+	}
+	return summary, c.RecordImport(filename, prefix, summary.SamplesWritten)
+}
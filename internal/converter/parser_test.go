@@ -0,0 +1,44 @@
+package converter_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// TestParsersAgree converts the synthetic archive with the Go reader and
+// with the Java extractor, each into a TSDB of its own, and checks that
+// both write the same number of series and samples of every type
+func TestParsersAgree(t *testing.T) {
+	if !gfs.JavaAvailable() {
+		t.Skip("java not found")
+	}
+	archive := synthetic(t, t.TempDir(), testStart)
+
+	type counts struct{ series, samples int }
+	read := make(map[string][]counts, 2)
+	for _, parser := range []string{converter.ParserGo, converter.ParserJava} {
+		tsdbPath := filepath.Join(t.TempDir(), "tsdb")
+		if _, err := convertFile(archive, tsdbPath, "", converter.Options{Parser: parser}); err != nil {
+			t.Fatalf("%s parser: %v", parser, err)
+		}
+		for ty := 0; ty < testOptions.Types; ty++ {
+			var c counts
+			for _, s := range selectSeries(t, tsdbPath, testStart, testEnd(testStart), map[string]string{converter.LabelResourceType: gfstest.TypeName(ty)}) {
+				c.series++
+				c.samples += len(s.Timestamps)
+			}
+			read[parser] = append(read[parser], c)
+		}
+	}
+	for ty := 0; ty < testOptions.Types; ty++ {
+		goRead, javaRead := read[converter.ParserGo][ty], read[converter.ParserJava][ty]
+		if goRead.series == 0 || goRead != javaRead {
+			t.Errorf("%s: the Go reader wrote %d series of %d samples, the Java extractor %d of %d", gfstest.TypeName(ty),
+				goRead.series, goRead.samples, javaRead.series, javaRead.samples)
+		}
+	}
+}
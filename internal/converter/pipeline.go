@@ -0,0 +1,117 @@
+package converter
+
+import (
+	"time"
+)
+
+// pipelineBatch is the number of samples the reader hands the writer
+// goroutine at a time, which keeps channel operations off the path of
+// every sample
+const pipelineBatch = 1024
+
+// pipelineSample is a sample of a streamed archive ready to be appended,
+// or a staleness marker if stale is set
+type pipelineSample struct {
+	metricName string
+	labels     map[string]string
+	value      float64
+	timestamp  time.Time
+	stale      bool
+}
+
+// pipeline hands the samples of a streamed archive from the goroutine
+// reading it to a goroutine appending them to the TSDB, so decoding the
+// archive overlaps with writing it. Everything but the appends, labels
+// included, stays on the reading goroutine.
+type pipeline struct {
+	batches chan []pipelineSample
+	// free holds the batches the writer goroutine is done with
+	free  chan []pipelineSample
+	batch []pipelineSample
+
+	// stopped is closed when the writer goroutine stops early on err;
+	// done is closed when it returns
+	stopped chan struct{}
+	done    chan struct{}
+	err     error
+}
+
+// startPipeline appends the samples of the stream on a goroutine of its
+// own, buffering up to buffer samples between it and the reader
+func (s *sampleStream) startPipeline(buffer int) {
+	batches := max(buffer/pipelineBatch, 1)
+	p := &pipeline{
+		batches: make(chan []pipelineSample, batches),
+		free:    make(chan []pipelineSample, batches+1),
+		batch:   make([]pipelineSample, 0, pipelineBatch),
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(p.done)
+		for batch := range p.batches {
+			for _, sample := range batch {
+				var err error
+				if sample.stale {
+					err = s.c.writeStaleMarker(s.filename, sample.metricName, sample.labels, sample.timestamp)
+				} else {
+					err = s.appendSample(sample.metricName, sample.labels, sample.value, sample.timestamp)
+				}
+				if err != nil {
+					p.err = err
+					close(p.stopped)
+					// Let the reader finish with what it sends
+					for range p.batches {
+					}
+					return
+				}
+			}
+			select {
+			case p.free <- batch[:0]:
+			default:
+			}
+		}
+	}()
+	s.pipeline = p
+}
+
+// send hands a sample to the writer goroutine, failing with its error if
+// it stopped
+func (p *pipeline) send(sample pipelineSample) error {
+	p.batch = append(p.batch, sample)
+	if len(p.batch) < pipelineBatch {
+		return nil
+	}
+	return p.flush()
+}
+
+// flush hands the samples sent since the last flush to the writer
+// goroutine
+func (p *pipeline) flush() error {
+	if len(p.batch) == 0 {
+		return nil
+	}
+	select {
+	case p.batches <- p.batch:
+	case <-p.stopped:
+		return p.err
+	}
+	select {
+	case p.batch = <-p.free:
+	default:
+		p.batch = make([]pipelineSample, 0, pipelineBatch)
+	}
+	return nil
+}
+
+// close waits for the writer goroutine to append every sample sent,
+// returning the error it stopped on, if any
+func (p *pipeline) close() error {
+	err := p.flush()
+	close(p.batches)
+	<-p.done
+	if p.err != nil {
+		return p.err
+	}
+	return err
+}
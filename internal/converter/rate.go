@@ -0,0 +1,118 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// rateSample is a sample of a counter with the values it lost to resets
+// added back
+type rateSample struct {
+	time  time.Time
+	value float64
+}
+
+// rateSeries computes the per-second rate of a counter series over a
+// trailing window, as rate(counter[window]) would at the time of each of
+// its samples: the increase since the oldest sample at most window
+// earlier, a counter going back counting as a reset to zero, over the
+// time between the two. A sample with no earlier one in the window, as
+// after a sampling gap longer than it, has no rate. Being computed at the
+// samples rather than extrapolated to the edges of the window, the rates
+// only approximate those Prometheus would compute.
+type rateSeries struct {
+	metricName string
+	window     time.Duration
+	// samples holds the samples of the window, oldest first
+	samples []rateSample
+	// prev is the latest value as read, and offset what the resets before
+	// it add to the values after them
+	prev   float64
+	offset float64
+	// last is the timestamp of the latest rate written, if any
+	last    time.Time
+	written bool
+
+	write func(t time.Time, value float64) error
+	ds    resampler
+}
+
+// newRateSeries starts the rate series of the counter written as
+// metricName with labels, writing its samples with write, resampled if
+// Downsample or Align is set
+func (c *Converter) newRateSeries(metricName string, labels map[string]string, write func(metricName string, labels map[string]string, t time.Time, value float64) error) *rateSeries {
+	r := &rateSeries{metricName: c.rateName(metricName), window: c.opts.RateWindow}
+	c.addMetadata(r.metricName, MetricMetadata{
+		Type: MetricGauge,
+		Help: fmt.Sprintf("Per-second rate of %s over %s, approximated from the archive's samples", metricName, r.window),
+	})
+	r.write = func(t time.Time, value float64) error {
+		return write(r.metricName, labels, t, value)
+	}
+	r.ds = c.newResampler(false, r.write)
+	return r
+}
+
+// rateName returns the name of the rate series of the counter written as
+// metricName, as a recording rule would name it: 1m gives
+// <metricName>:rate1m
+func (c *Converter) rateName(metricName string) string {
+	window := c.opts.RateWindow.String()
+	if strings.HasSuffix(window, "m0s") {
+		window = strings.TrimSuffix(window, "0s")
+	}
+	if strings.HasSuffix(window, "h0m") {
+		window = strings.TrimSuffix(window, "0m")
+	}
+	return metricName + ":rate" + window
+}
+
+// add adds the next sample of the counter, writing its rate. A sample that
+// is not after the one before it, kept out of order by TimeJumpsKeep, is
+// left out.
+func (r *rateSeries) add(t time.Time, value float64) error {
+	n := len(r.samples)
+	if n > 0 && !t.After(r.samples[n-1].time) {
+		return nil
+	}
+	if n > 0 && value < r.prev {
+		r.offset += r.prev
+	}
+	r.prev = value
+	sample := rateSample{time: t, value: value + r.offset}
+
+	start := t.Add(-r.window)
+	i := 0
+	for i < n && r.samples[i].time.Before(start) {
+		i++
+	}
+	r.samples = append(r.samples[i:], sample)
+	if len(r.samples) == 1 {
+		return nil
+	}
+
+	first := r.samples[0]
+	rate := (sample.value - first.value) / t.Sub(first.time).Seconds()
+	r.last, r.written = t, true
+	if r.ds != nil {
+		return r.ds.add(t, rate)
+	}
+	return r.write(t, rate)
+}
+
+// flush writes the rates still held by the resampler, if any
+func (r *rateSeries) flush() error {
+	if r.ds == nil {
+		return nil
+	}
+	return r.ds.flush()
+}
+
+// downsampled returns the number of rates handed to the resampler
+func (r *rateSeries) downsampled() int {
+	if r.ds == nil {
+		return 0
+	}
+	return r.ds.added()
+}
@@ -0,0 +1,41 @@
+package converter_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// TestRates checks the rate series converting a counter in memory and
+// streamed with a rate window writes: the increase over the oldest sample
+// in the window, counting a reset as one from zero, per second between
+// the two, and no rate for the first sample after a gap longer than the
+// window. The counter is sampled at irregular intervals and reset before
+// the fourth sample.
+func TestRates(t *testing.T) {
+	seconds := []int{1, 3, 5, 9, 13, 30, 32}
+	archive := writeInstance(t, t.TempDir(), gfstest.Instance{
+		Start:   testStart,
+		Stats:   counterAndGauge[:1],
+		Seconds: seconds,
+		Values:  [][]float64{{0, 20, 40, 8, 20, 30, 50}},
+	})
+	const rate = "gemfire_selfteststats0_operations_total:rate10s"
+	want := []timedValue{{3, 10}, {5, 10}, {9, 6}, {13, 4}, {32, 10}}
+	for _, lowMemory := range []bool{false, true} {
+		tsdbPath := filepath.Join(t.TempDir(), "tsdb")
+		mustConvert(t, archive, tsdbPath, "", converter.Options{RateWindow: 10 * time.Second, LowMemory: lowMemory})
+		end := testStart.Add(time.Duration(seconds[len(seconds)-1]+1) * time.Second)
+		series := selectSeries(t, tsdbPath, testStart, end, map[string]string{"__name__": rate})
+		if len(series) != 1 {
+			t.Fatalf("low memory %t: %d series named %s, want 1", lowMemory, len(series), rate)
+		}
+		if got := timedValues(series[0], testStart); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("low memory %t: %s has samples %v, want %v", lowMemory, rate, got, want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package converter
+
+import (
+	"sync"
+	"time"
+)
+
+// LabelRemapped is set to "true" on every series of an archive converted
+// with RemapToNow, so that queries can tell its samples, and keep them
+// apart from those of the same archive converted at their own time
+const LabelRemapped = "remapped"
+
+// remaps holds the offset every archive converted with RemapToNow is
+// moved by, by name. An archive converted again, as watch does once it
+// has grown, keeps the offset of its first conversion, so that its new
+// samples land after those written before.
+type remaps struct {
+	mu      sync.Mutex
+	offsets map[string]time.Duration
+}
+
+// setRemap moves the samples of filename, whose last sample is at end, to
+// the present if RemapToNow is set. An archive without samples has nothing
+// to move.
+func (c *Converter) setRemap(filename string, end time.Time) {
+	if !c.opts.RemapToNow || end.IsZero() {
+		return
+	}
+	c.remaps.mu.Lock()
+	offset, ok := c.remaps.offsets[filename]
+	if !ok {
+		target := c.opts.RemapEnd
+		if target.IsZero() {
+			target = time.Now()
+		}
+		offset = target.Sub(end)
+		if c.remaps.offsets == nil {
+			c.remaps.offsets = make(map[string]time.Duration)
+		}
+		c.remaps.offsets[filename] = offset
+		c.logger.Infof("Remapping %s by %s, its last sample at %s landing at %s",
+			filename, offset, end.Format(time.RFC3339), target.Format(time.RFC3339))
+	}
+	c.remaps.mu.Unlock()
+	c.writer.SetTimeShift(filename, offset, LabelRemapped, "true")
+}
@@ -0,0 +1,212 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/provenance"
+)
+
+const checkpointFileName = "convert-checkpoint.json"
+
+// CheckpointPath returns the path of the checkpoint a batch conversion
+// keeps in tsdbPath until it finishes
+func CheckpointPath(tsdbPath string) string {
+	return filepath.Join(tsdbPath, checkpointFileName)
+}
+
+// batchCheckpoint records how far a batch conversion got, so an
+// interrupted run can resume without writing any sample twice
+type batchCheckpoint struct {
+	ConfigHash string    `json:"config_hash"`
+	Started    time.Time `json:"started"`
+	Completed  []string  `json:"completed"`
+	// Current is the file being converted, SHA256 its hash and Committed
+	// the number of its samples that were committed
+	Current   string `json:"current,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+	Committed int    `json:"committed,omitempty"`
+}
+
+// FileState says how a file of a batch is converted
+type FileState int
+
+const (
+	// FileFresh is converted from the start
+	FileFresh FileState = iota
+	// FilePartial was interrupted after some of its samples were
+	// committed; conversion resumes after them
+	FilePartial
+	// FileCompleted was fully converted by the interrupted run and is
+	// skipped
+	FileCompleted
+)
+
+// Batch converts a list of files, checkpointing after every commit. A
+// batch started with resume continues from the checkpoint of an
+// interrupted one.
+type Batch struct {
+	c          *Converter
+	path       string
+	checkpoint *batchCheckpoint
+	resumed    *batchCheckpoint // The interrupted run's checkpoint, nil if none
+
+	// base is the writer's write count when the current file started
+	base int
+
+	Skipped int // Files completed by the interrupted run
+	Resumed int // Files resumed after their committed samples
+	Fresh   int // Files converted from the start
+}
+
+// StartBatch starts checkpointing a batch conversion into the TSDB. With
+// resume, the checkpoint of an interrupted run is continued; it must have
+// been made with the same config. Without it, any such checkpoint is
+// discarded. A backfill keeps no checkpoint.
+func (c *Converter) StartBatch(tsdbPath string, resume bool) (*Batch, error) {
+	if resume && c.opts.Backfill {
+		return nil, fmt.Errorf("a backfill cannot be resumed: nothing is written before its blocks, once every file is converted")
+	}
+	b := &Batch{
+		c:          c,
+		path:       CheckpointPath(tsdbPath),
+		checkpoint: &batchCheckpoint{ConfigHash: c.configHash, Started: time.Now()},
+	}
+
+	data, err := os.ReadFile(b.path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	case !resume:
+		c.logger.Warnf("Discarding the checkpoint of an interrupted conversion; use --resume to continue it instead")
+	default:
+		var cp batchCheckpoint
+		if err := json.Unmarshal(data, &cp); err != nil {
+			return nil, fmt.Errorf("invalid conversion checkpoint: %w", err)
+		}
+		if cp.ConfigHash != c.configHash {
+			return nil, fmt.Errorf("the interrupted conversion (started %s) used a different config; resume it with the same config or run without --resume",
+				cp.Started.Format(time.RFC3339))
+		}
+		b.resumed = &cp
+		b.checkpoint.Started = cp.Started
+		b.checkpoint.Completed = append([]string(nil), cp.Completed...)
+	}
+	if resume && b.resumed == nil {
+		c.logger.Infof("No interrupted conversion to resume in %s; converting every file", tsdbPath)
+	}
+	return b, b.save()
+}
+
+// State returns how file will be converted and, for a partial file, how
+// many of its samples are skipped
+func (b *Batch) State(file string) (FileState, int) {
+	if b.resumed == nil {
+		return FileFresh, 0
+	}
+	abs := absPath(file)
+	for _, done := range b.resumed.Completed {
+		if done == abs {
+			return FileCompleted, 0
+		}
+	}
+	if b.resumed.Current == abs && b.resumed.Committed > 0 {
+		return FilePartial, b.resumed.Committed
+	}
+	return FileFresh, 0
+}
+
+// ConvertFile converts a file of the batch like Converter.ConvertFile,
+// skipping it if the interrupted run completed it and skipping the samples
+// it committed if it was interrupted inside it. The returned state says
+// which happened; the report is nil for a skipped file.
+func (b *Batch) ConvertFile(file string) (*gfs.ParseReport, FileState, error) {
+	state, committed := b.State(file)
+	if state == FileCompleted {
+		b.Skipped++
+		return nil, state, nil
+	}
+
+	abs := absPath(file)
+	hash, err := provenance.HashFile(file)
+	if err != nil {
+		return nil, state, fmt.Errorf("failed to hash %s: %w", file, err)
+	}
+	if state == FilePartial && hash != b.resumed.SHA256 {
+		b.c.logger.Warnf("%s changed since the interrupted conversion; converting it from the start", file)
+		state, committed = FileFresh, 0
+	}
+	if state == FilePartial {
+		b.Resumed++
+	} else {
+		b.Fresh++
+	}
+
+	b.checkpoint.Current = abs
+	b.checkpoint.SHA256 = hash
+	b.checkpoint.Committed = committed
+	if err := b.save(); err != nil {
+		return nil, state, err
+	}
+
+	// The writes of a file are made in the same order on every run, so
+	// skipping as many as were committed resumes exactly after them
+	writer := b.c.writer
+	b.base = writer.Written(file)
+	writer.Skip(file, committed)
+	writer.OnCommit(file, func(total int) error {
+		b.checkpoint.Committed = total - b.base
+		return b.save()
+	})
+	report, err := b.c.ConvertFile(file)
+	if err != nil {
+		// Samples committed when the converter is closed still count
+		return report, state, err
+	}
+	writer.OnCommit(file, nil)
+	writer.Skip(file, 0)
+
+	b.checkpoint.Completed = append(b.checkpoint.Completed, abs)
+	b.checkpoint.Current = ""
+	b.checkpoint.SHA256 = ""
+	b.checkpoint.Committed = 0
+	return report, state, b.save()
+}
+
+// Finish removes the checkpoint once every file has been converted
+func (b *Batch) Finish() error {
+	if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// save writes the checkpoint, except for a backfill, which has committed
+// nothing to disk before its blocks are written
+func (b *Batch) save() error {
+	if b.c.opts.Backfill {
+		return nil
+	}
+	data, err := json.Marshal(b.checkpoint)
+	if err != nil {
+		return err
+	}
+	tmpPath := b.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, b.path)
+}
+
+func absPath(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+	return abs
+}
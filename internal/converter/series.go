@@ -0,0 +1,155 @@
+package converter
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/config"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+)
+
+// ArchiveSeries is a series that ConvertFile writes for an archive, with
+// the labels that identify it and the timestamps of its samples
+type ArchiveSeries struct {
+	Metric     string
+	Instance   string
+	Labels     map[string]string
+	Timestamps []time.Time
+}
+
+// ListSeries returns the series ConvertFile writes for an archive that has
+// already been read from filename with cfg, with legacy labels if
+// legacyLabels is set, without writing anything. Labels added by
+// enrichment, the state label of state metrics, the suffixes of
+// conflicting descriptors, derived metrics and the rate series of
+// RateWindow are not included.
+// It fails on a stat the metric name template names invalidly.
+func ListSeries(reader StatReader, filename string, cfg *config.Config, legacyLabels bool) ([]ArchiveSeries, error) {
+	types := reader.GetResourceTypes()
+	prefix, _ := cfg.PrefixFor(filename, "", reader.GetArchiveInfo().ProductDescription)
+
+	var series []ArchiveSeries
+	for _, instance := range gfs.SortedInstances(reader.GetInstances()) {
+		resType, ok := types[instance.TypeID]
+		if !ok || !isValidResourceType(resType) || !isValidInstance(instance) {
+			continue
+		}
+		if !cfg.Filters.IncludesType(resType.Name) || cfg.Filters.InstanceFilter(instance.Name) != "" {
+			continue
+		}
+
+		for i, stat := range resType.Stats {
+			values := instance.Stats[int32(i)]
+			if len(values) == 0 || !cfg.Filters.IncludesStat(resType.Name, stat.Name) {
+				continue
+			}
+			metric, err := StatMetricName(cfg, prefix, resType.Name, &stat)
+			if err != nil {
+				return nil, err
+			}
+			mapping, _, mapped := cfg.MappingFor(resType.Name, stat.Name, metric)
+			if mapped && mapping.Drop {
+				continue
+			}
+
+			timestamps := make([]time.Time, len(values))
+			for j, sample := range values {
+				timestamps[j] = sample.Time()
+			}
+
+			s := ArchiveSeries{
+				Metric:   metric,
+				Instance: instance.Name,
+				Labels: sourceLabels(cfg, numericIDLabels(cfg, InstanceLabels(resType.Name, instance.Name, legacyLabels),
+					resType.Name, instance), filename, reader.GetArchiveInfo()),
+				Timestamps: timestamps,
+			}
+			if mapped {
+				if mapping.Name != "" {
+					s.Metric = mapping.Name
+				}
+				s.Labels = mappedLabels(s.Labels, instance.Name, mapping)
+			}
+			s.Labels = cfg.RenameLabels(s.Labels)
+			series = append(series, s)
+		}
+	}
+	return series, nil
+}
+
+// SeriesEstimate is a series ConvertFile writes for an archive, with the
+// number of samples an estimate extrapolates for it
+type SeriesEstimate struct {
+	Metric   string
+	Type     string
+	Instance string
+	Samples  float64
+}
+
+// EstimateSeries returns the series ConvertFile writes for an archive that
+// has been estimated from filename with cfg, like ListSeries, with their
+// sample counts scaled up from the part of the archive that was decoded.
+// Instances that share a name share their series, as they do when written,
+// unless the config labels them with their numeric ids.
+func EstimateSeries(reader StatReader, estimate *gfs.Estimate, filename string, cfg *config.Config) ([]SeriesEstimate, error) {
+	types := reader.GetResourceTypes()
+	prefix, _ := cfg.PrefixFor(filename, "", reader.GetArchiveInfo().ProductDescription)
+	scale := estimate.Scale()
+
+	index := make(map[string]int)
+	var series []SeriesEstimate
+	for instance, values := range estimate.Values {
+		resType, ok := types[instance.TypeID]
+		if !ok || !isValidResourceType(resType) || !isValidInstance(instance) {
+			continue
+		}
+		if !cfg.Filters.IncludesType(resType.Name) || cfg.Filters.InstanceFilter(instance.Name) != "" {
+			continue
+		}
+
+		for statName, count := range values {
+			if !cfg.Filters.IncludesStat(resType.Name, statName) {
+				continue
+			}
+			stat := &gfs.StatDescriptor{Name: statName}
+			for i := range resType.Stats {
+				if resType.Stats[i].Name == statName {
+					stat = &resType.Stats[i]
+					break
+				}
+			}
+			metric, err := StatMetricName(cfg, prefix, resType.Name, stat)
+			if err != nil {
+				return nil, err
+			}
+			mapping, _, mapped := cfg.MappingFor(resType.Name, statName, metric)
+			if mapped && mapping.Drop {
+				continue
+			}
+			if mapped && mapping.Name != "" {
+				metric = mapping.Name
+			}
+
+			key := metric + "\x00" + instance.Name
+			if cfg.NumericIDLabelFor(resType.Name) != "" {
+				key += "\x00" + strconv.FormatInt(instance.NumericID, 10)
+			}
+			i, seen := index[key]
+			if !seen {
+				i = len(series)
+				index[key] = i
+				series = append(series, SeriesEstimate{Metric: metric, Type: resType.Name, Instance: instance.Name})
+			}
+			series[i].Samples += float64(count) * scale
+		}
+	}
+
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].Metric != series[j].Metric {
+			return series[i].Metric < series[j].Metric
+		}
+		return series[i].Instance < series[j].Instance
+	})
+	return series, nil
+}
@@ -0,0 +1,212 @@
+package converter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/pkg/events"
+)
+
+// spillRecordSize is the size of a spilled sample: the indexes of its
+// instance and stat, its timestamp in milliseconds and its value
+const spillRecordSize = 4 + 4 + 8 + 8
+
+// spillBuffer is the buffer size for writing and reading a spill file
+const spillBuffer = 1 << 20
+
+// spillDeleted stands for the stat of a record that marks its instance
+// deleted rather than holding a sample
+const spillDeleted = math.MaxUint32
+
+// spilledStat is a stat of the samples in a spill file, with its type
+type spilledStat struct {
+	resType *gfs.ResourceType
+	stat    *gfs.StatDescriptor
+}
+
+// sampleSpill is a temporary file holding the decoded samples of an
+// archive and the deletions of their instances in the order they were
+// read. Instances and stats are numbered in
+// the order they were first seen, so ids reused by later instances and
+// appended archives stay apart.
+type sampleSpill struct {
+	file *os.File
+	w    *bufio.Writer
+
+	instances     []*gfs.ResourceInstance
+	instanceIndex map[*gfs.ResourceInstance]uint32
+	stats         []spilledStat
+	statIndex     map[*gfs.StatDescriptor]uint32
+
+	samples   int64
+	deletions int64
+}
+
+// newSampleSpill creates an empty spill file in the temporary directory
+func newSampleSpill() (*sampleSpill, error) {
+	file, err := os.CreateTemp("", "gfs-spill-*.bin")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill file: %w", err)
+	}
+	return &sampleSpill{
+		file:          file,
+		w:             bufio.NewWriterSize(file, spillBuffer),
+		instanceIndex: make(map[*gfs.ResourceInstance]uint32),
+		statIndex:     make(map[*gfs.StatDescriptor]uint32),
+	}, nil
+}
+
+// append adds a sample of a stat of instance, whose type is resType
+func (s *sampleSpill) append(resType *gfs.ResourceType, instance *gfs.ResourceInstance, stat *gfs.StatDescriptor, timestamp time.Time, value float64) error {
+	i, ok := s.instanceIndex[instance]
+	if !ok {
+		i = uint32(len(s.instances))
+		s.instances = append(s.instances, instance)
+		s.instanceIndex[instance] = i
+	}
+	j, ok := s.statIndex[stat]
+	if !ok {
+		j = uint32(len(s.stats))
+		s.stats = append(s.stats, spilledStat{resType: resType, stat: stat})
+		s.statIndex[stat] = j
+	}
+
+	var record [spillRecordSize]byte
+	binary.LittleEndian.PutUint32(record[0:], i)
+	binary.LittleEndian.PutUint32(record[4:], j)
+	binary.LittleEndian.PutUint64(record[8:], uint64(timestamp.UnixMilli()))
+	binary.LittleEndian.PutUint64(record[16:], math.Float64bits(value))
+	if _, err := s.w.Write(record[:]); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	s.samples++
+	return nil
+}
+
+// appendDeletion adds the deletion of an instance, if it has samples
+func (s *sampleSpill) appendDeletion(instance *gfs.ResourceInstance) error {
+	i, ok := s.instanceIndex[instance]
+	if !ok {
+		return nil
+	}
+	var record [spillRecordSize]byte
+	binary.LittleEndian.PutUint32(record[0:], i)
+	binary.LittleEndian.PutUint32(record[4:], spillDeleted)
+	if _, err := s.w.Write(record[:]); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	s.deletions++
+	return nil
+}
+
+// replay calls fn with every spilled sample and deleted with every
+// instance deleted, in the order they were added
+func (s *sampleSpill) replay(fn func(instance *gfs.ResourceInstance, stat *gfs.StatDescriptor, timestamp time.Time, value float64) error,
+	deleted func(instance *gfs.ResourceInstance) error) error {
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind spill file: %w", err)
+	}
+
+	r := bufio.NewReaderSize(s.file, spillBuffer)
+	var record [spillRecordSize]byte
+	for n := int64(0); n < s.samples+s.deletions; n++ {
+		if _, err := io.ReadFull(r, record[:]); err != nil {
+			return fmt.Errorf("failed to read spill file: %w", err)
+		}
+		instance := s.instances[binary.LittleEndian.Uint32(record[0:])]
+		j := binary.LittleEndian.Uint32(record[4:])
+		if j == spillDeleted {
+			if err := deleted(instance); err != nil {
+				return err
+			}
+			continue
+		}
+		stat := s.stats[j].stat
+		timestamp := time.UnixMilli(int64(binary.LittleEndian.Uint64(record[8:])))
+		value := math.Float64frombits(binary.LittleEndian.Uint64(record[16:]))
+		if err := fn(instance, stat, timestamp, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close removes the spill file
+func (s *sampleSpill) Close() error {
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+// convertSpilled converts an archive with --low-memory: its samples are
+// decoded into a spill file, keeping only its metadata in memory, and
+// written from there once the whole archive has been read. Every stat is
+// resolved before the first sample is written, so a descriptor conflict
+// stops the file before it writes anything.
+func (c *Converter) convertSpilled(reader *gfs.StatArchiveReader, filename, cluster string, labeler InstanceLabeler) (events.FileSummary, error) {
+	spill, err := newSampleSpill()
+	if err != nil {
+		return events.FileSummary{}, err
+	}
+	defer spill.Close()
+
+	c.logger.Debugf("Spilling samples of %s to %s", filename, spill.file.Name())
+	s := c.newSampleStream(reader, filename, cluster, labeler)
+	reader.SetDeleteFunc(func(instance *gfs.ResourceInstance) error {
+		if err := spill.appendDeletion(instance); err != nil {
+			s.stopped = err
+			return err
+		}
+		return nil
+	})
+	readErr := reader.ReadArchiveStream(func(instance *gfs.ResourceInstance, stat *gfs.StatDescriptor, timestamp time.Time, value float64) error {
+		if c.interrupted.Load() {
+			s.stopped = ErrInterrupted
+			return ErrInterrupted
+		}
+		resType := reader.GetResourceTypes()[instance.TypeID]
+		if err := spill.append(resType, instance, stat, timestamp, value); err != nil {
+			s.stopped = err
+			return err
+		}
+		return nil
+	})
+	if readErr == nil || (s.stopped == nil && !gfs.IsUnreadable(readErr)) {
+		c.logger.Debugf("Spilled %d samples of %s", spill.samples, filename)
+		err := c.setWindow(filename, reader.GetLastSampleTime())
+		if err == nil {
+			c.setRemap(filename, reader.GetLastSampleTime())
+			if c.opts.PipelineBuffer > 0 {
+				s.startPipeline(c.opts.PipelineBuffer)
+			}
+			err = s.replay(spill)
+		}
+		if err != nil {
+			s.stopped = err
+			readErr = err
+		}
+	}
+	return s.finish(readErr)
+}
+
+// replay writes the samples of a spill file, resolving the stats of all of
+// its valid types first
+func (s *sampleStream) replay(spill *sampleSpill) error {
+	for _, st := range spill.stats {
+		if !isValidResourceType(st.resType) {
+			continue
+		}
+		if _, err := s.resolve(st.resType, st.stat); err != nil {
+			return err
+		}
+	}
+	return spill.replay(s.write, s.deleted)
+}
@@ -0,0 +1,28 @@
+package converter
+
+import (
+	"time"
+)
+
+// staleTime returns when the series of an instance deleted at deleted,
+// whose latest sample is at last, are marked stale: when it was deleted,
+// or right after that sample if it is no earlier
+func staleTime(deleted, last time.Time) time.Time {
+	if deleted.After(last) {
+		return deleted
+	}
+	return last.Add(time.Millisecond)
+}
+
+// writeStaleMarker ends a series of filename at t with a staleness marker,
+// failing as writeFailed does if it cannot be written. A marker after the
+// future limit is left out unless FutureKeep keeps the samples there.
+func (c *Converter) writeStaleMarker(filename, metricName string, labels map[string]string, t time.Time) error {
+	if _, ok := c.futureTime(filename, metricName, labels, t, true); !ok {
+		return nil
+	}
+	if err := c.writer.WriteStaleMarker(filename, metricName, labels, t); err != nil {
+		return c.writeFailed(filename, err, "Failed to write staleness marker of metric %s at %s: %v", metricName, t.Format(time.RFC3339Nano), err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package converter_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// writeInstances writes an archive of the first synthetic type to dir,
+// calling create before sample k to create or delete instances, and
+// sampling every instance of ids at every tick
+func writeInstances(t *testing.T, dir string, samples int, create func(w *gfs.ArchiveWriter, k int) error, ids ...int32) string {
+	t.Helper()
+	path := filepath.Join(dir, "instances.gfs")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w, err := gfs.NewArchiveWriter(file, gfs.ArchiveHeader{StartTime: testStart, SystemStartTime: testStart, TimeZoneName: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteResourceType(gfstest.SyntheticType(0)); err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < samples; k++ {
+		if err := create(w, k); err != nil {
+			t.Fatal(err)
+		}
+		var instances []gfs.InstanceSample
+		for _, id := range ids {
+			values := make(map[int]float64, len(gfstest.StatTypes))
+			for stat := range gfstest.StatTypes {
+				values[stat] = gfstest.Value(int32(stat), id, k)
+			}
+			instances = append(instances, gfs.InstanceSample{InstanceID: id, Values: values})
+		}
+		if err := w.WriteSample(testStart.Add(time.Duration(k+1)*gfstest.SampleInterval), instances); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// reusedNames are the instances of the archive writeReusedID writes
+var reusedNames = []string{"selftest-reused-first", "selftest-reused-second"}
+
+// writeReusedID writes an archive in which the first of reusedNames is
+// deleted after testOptions.Samples samples and its id reused by the
+// second, as Geode does
+func writeReusedID(t *testing.T, dir string) string {
+	t.Helper()
+	return writeInstances(t, dir, 2*testOptions.Samples, func(w *gfs.ArchiveWriter, k int) error {
+		switch k {
+		case 0:
+			return w.CreateInstance(0, reusedNames[0], 0, 0)
+		case testOptions.Samples:
+			if err := w.DeleteInstance(0); err != nil {
+				return err
+			}
+			return w.CreateInstance(0, reusedNames[1], 0, 0)
+		}
+		return nil
+	}, 0)
+}
+
+// TestReuseInstanceID checks that instances sharing an id, one after the
+// other, each become their own series holding all of their samples
+func TestReuseInstanceID(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeReusedID(t, dir)
+	tsdbPath := filepath.Join(dir, "tsdb")
+	mustConvert(t, archive, tsdbPath, "", converter.Options{})
+
+	end := testStart.Add(time.Duration(2*testOptions.Samples+1) * gfstest.SampleInterval)
+	for _, name := range reusedNames {
+		series := selectSeries(t, tsdbPath, testStart, end, map[string]string{converter.LabelResourceType: gfstest.TypeName(0), converter.LabelInstance: name})
+		if len(series) != len(gfstest.StatTypes) {
+			t.Fatalf("%s has %d series, wrote %d stats", name, len(series), len(gfstest.StatTypes))
+		}
+		for _, s := range series {
+			if len(s.Timestamps) != testOptions.Samples {
+				t.Errorf("series %s of %s has %d samples, wrote %d", s.Labels["__name__"], name, len(s.Timestamps), testOptions.Samples)
+			}
+		}
+	}
+}
+
+// TestDeletedInstanceStale checks that every series of a deleted instance
+// ends with a staleness marker after its last sample, in memory, streamed
+// and with low memory, while those of the live instance do not
+func TestDeletedInstanceStale(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeReusedID(t, dir)
+	tests := []struct {
+		name    string
+		options converter.Options
+	}{
+		{"in memory", converter.Options{}},
+		{"streamed", converter.Options{StreamThreshold: 1, PipelineBuffer: 4096}},
+		{"low memory", converter.Options{LowMemory: true}},
+	}
+	end := testStart.Add(time.Duration(2*testOptions.Samples+2) * gfstest.SampleInterval)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tsdbPath := filepath.Join(t.TempDir(), "tsdb")
+			mustConvert(t, archive, tsdbPath, "", tt.options)
+			for n, name := range reusedNames {
+				series := selectSeries(t, tsdbPath, testStart, end, map[string]string{converter.LabelResourceType: gfstest.TypeName(0), converter.LabelInstance: name})
+				if len(series) != len(gfstest.StatTypes) {
+					t.Fatalf("%s has %d series, wrote %d stats", name, len(series), len(gfstest.StatTypes))
+				}
+				for _, s := range series {
+					metric := s.Labels["__name__"]
+					switch {
+					case n > 0 && len(s.Stale) > 0:
+						t.Errorf("series %s of live %s is marked stale", metric, name)
+					case n == 0 && len(s.Stale) != 1:
+						t.Errorf("series %s of deleted %s has %d staleness markers, want 1", metric, name, len(s.Stale))
+					case n == 0 && !s.Stale[0].After(s.Timestamps[len(s.Timestamps)-1]):
+						t.Errorf("series %s of deleted %s is marked stale at %s, before its last sample", metric, name, s.Stale[0].Format(time.RFC3339Nano))
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestNumericIDLabel checks that two live instances sharing a name, as
+// threads of the same kind do, each become their own series holding all
+// of their samples when labeled with their numeric ids
+func TestNumericIDLabel(t *testing.T) {
+	const name = "selftest-shared"
+	numericIDs := []int64{1001, 1002}
+	dir := t.TempDir()
+	archive := writeInstances(t, dir, testOptions.Samples, func(w *gfs.ArchiveWriter, k int) error {
+		for id, numericID := range numericIDs {
+			if k == 0 {
+				if err := w.CreateInstance(int32(id), name, numericID, 0); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}, 0, 1)
+	tsdbPath := filepath.Join(dir, "tsdb")
+	mustConvert(t, archive, tsdbPath, writeConfig(t, dir, "numeric_id_label: pid\n"), converter.Options{})
+
+	for _, numericID := range numericIDs {
+		pid := strconv.FormatInt(numericID, 10)
+		series := selectSeries(t, tsdbPath, testStart, testEnd(testStart), map[string]string{converter.LabelResourceType: gfstest.TypeName(0), converter.LabelInstance: name, "pid": pid})
+		if len(series) != len(gfstest.StatTypes) {
+			t.Fatalf("%s with pid %s has %d series, wrote %d stats", name, pid, len(series), len(gfstest.StatTypes))
+		}
+		for _, s := range series {
+			if len(s.Timestamps) != testOptions.Samples {
+				t.Errorf("series %s of %s with pid %s has %d samples, wrote %d", s.Labels["__name__"], name, pid, len(s.Timestamps), testOptions.Samples)
+			}
+		}
+	}
+}
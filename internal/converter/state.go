@@ -0,0 +1,91 @@
+package converter
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+)
+
+// stateSeries writes a stat mapped with as_state_metric. Each run of
+// samples with the same value is written as a sample of 1 at its first and
+// at its last sample, in the series labeled with the value, and a change
+// of value ends the series of the value before with a staleness marker,
+// so a flag that never changes takes two samples however long the archive.
+type stateSeries struct {
+	metricName string
+	labels     map[string]string
+
+	// state holds the labels of the series of the current value, nil
+	// before the first sample; first and last are the times of the first
+	// and the latest sample of its run
+	state       map[string]string
+	value       float64
+	first, last time.Time
+
+	write func(metricName string, labels map[string]string, t time.Time, value float64) error
+	mark  func(metricName string, labels map[string]string, t time.Time) error
+}
+
+// newStateSeries starts the state metric of a stat written as metricName
+// with labels, writing its samples with write and its staleness markers
+// with mark
+func newStateSeries(metricName string, labels map[string]string, write func(metricName string, labels map[string]string, t time.Time, value float64) error, mark func(metricName string, labels map[string]string, t time.Time) error) *stateSeries {
+	return &stateSeries{metricName: metricName, labels: labels, write: write, mark: mark}
+}
+
+// add adds the next sample of the stat, writing the run before it if its
+// value changed. A sample that is not after the one before it, kept out of
+// order by TimeJumpsKeep, is left out.
+func (s *stateSeries) add(t time.Time, value float64) error {
+	if s.state != nil {
+		if !t.After(s.last) {
+			return nil
+		}
+		if value == s.value {
+			s.last = t
+			return nil
+		}
+		if err := s.flush(); err != nil {
+			return err
+		}
+		if err := s.mark(s.metricName, s.state, t); err != nil {
+			return err
+		}
+	}
+
+	s.state = make(map[string]string, len(s.labels)+1)
+	for name, v := range s.labels {
+		s.state[name] = v
+	}
+	s.state[LabelState] = strconv.FormatFloat(value, 'g', -1, 64)
+	s.value, s.first, s.last = value, t, t
+	return s.write(s.metricName, s.state, t, 1)
+}
+
+// flush writes the last sample of the current run, if it has more than one
+func (s *stateSeries) flush() error {
+	if s.state == nil || !s.last.After(s.first) {
+		return nil
+	}
+	s.first = s.last
+	return s.write(s.metricName, s.state, s.last, 1)
+}
+
+// markStale ends the series of the current value, once its run has been
+// written, for an instance deleted at deleted
+func (s *stateSeries) markStale(deleted time.Time) error {
+	if s.state == nil {
+		return nil
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.mark(s.metricName, s.state, staleTime(deleted, s.last))
+}
+
+// recordStateMetadata records the metadata of a stat written as the state
+// metric metricName, a gauge whatever the stat is
+func (c *Converter) recordStateMetadata(metricName string, stat *gfs.StatDescriptor) {
+	c.addMetadata(metricName, MetricMetadata{Type: MetricGauge, Help: stat.Description, Unit: stat.Unit})
+}
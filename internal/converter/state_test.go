@@ -0,0 +1,67 @@
+package converter_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// stateConfig writes the primary flag as a state metric
+const stateConfig = `metric_mappings:
+  SelfTestStats0.primary:
+    name: selftest_is_primary
+    as_state_metric: true
+`
+
+// TestStateMetrics checks that converting a flag that flips twice in
+// memory and streamed with stateConfig writes a series per value holding
+// the first and last sample of each of its runs, ended by a staleness
+// marker where the flag changes
+func TestStateMetrics(t *testing.T) {
+	dir := t.TempDir()
+	flag := []float64{1, 1, 1, 0, 0, 0, 1, 1}
+	archive := writeInstance(t, dir, gfstest.Instance{
+		Start:  testStart,
+		Stats:  []gfs.StatDescriptor{{Name: "primary", Type: gfs.StatTypeBoolean}},
+		Values: [][]float64{flag},
+	})
+	configFile := writeConfig(t, dir, stateConfig)
+
+	// The samples and staleness markers of each state, in seconds
+	type run struct {
+		samples []int
+		stale   []int
+	}
+	want := map[string]run{
+		"1": {samples: []int{1, 3, 7, 8}, stale: []int{4}},
+		"0": {samples: []int{4, 6}, stale: []int{7}},
+	}
+	seconds := func(times []time.Time) []int {
+		var s []int
+		for _, at := range times {
+			s = append(s, int(at.Sub(testStart)/time.Second))
+		}
+		return s
+	}
+	end := testStart.Add(time.Duration(len(flag)+1) * time.Second)
+	for _, lowMemory := range []bool{false, true} {
+		tsdbPath := filepath.Join(t.TempDir(), "tsdb")
+		mustConvert(t, archive, tsdbPath, configFile, converter.Options{LowMemory: lowMemory})
+		series := selectSeries(t, tsdbPath, testStart, end, map[string]string{"__name__": "selftest_is_primary"})
+		if len(series) != len(want) {
+			t.Fatalf("low memory %t: %d state series, want %d", lowMemory, len(series), len(want))
+		}
+		for _, s := range series {
+			state := s.Labels[converter.LabelState]
+			got := run{samples: seconds(s.Timestamps), stale: seconds(s.Stale)}
+			if fmt.Sprint(got) != fmt.Sprint(want[state]) {
+				t.Errorf("low memory %t: state %q has samples and markers %v, want %v", lowMemory, state, got, want[state])
+			}
+		}
+	}
+}
@@ -0,0 +1,556 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/config"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/pkg/events"
+)
+
+// streams reports whether a file is large enough to be converted while it
+// is read rather than after
+func (c *Converter) streams(filename string) bool {
+	if c.opts.StreamThreshold <= 0 {
+		return false
+	}
+	info, err := os.Stat(filename)
+	return err == nil && info.Size() > c.opts.StreamThreshold
+}
+
+// streamStat is how the samples of one stat are written, decided at its
+// first sample
+type streamStat struct {
+	drop       bool
+	metricName string
+	scale      float64
+	correction *config.ValueCorrection
+	mapping    *config.MetricMapping
+}
+
+// seriesKey identifies the series of one stat of one instance
+type seriesKey struct {
+	instance *gfs.ResourceInstance
+	stat     *gfs.StatDescriptor
+}
+
+// sampleStream writes the samples of an archive as the reader decodes them
+type sampleStream struct {
+	c         *Converter
+	reader    *gfs.StatArchiveReader
+	filename  string
+	cluster   string
+	labeler   InstanceLabeler
+	corrector *ValueCorrector
+	progress  *ProgressReporter
+	// prefix is the metric prefix of the file, resolved with the corrector
+	// once the header has been read
+	prefix string
+	rule   string
+
+	instances map[*gfs.ResourceInstance]*streamInstance
+	stats     map[*gfs.StatDescriptor]*streamStat
+	// series holds the labels of the series that a metric mapping or a
+	// label mapping gives labels other than their instance's
+	series map[seriesKey]map[string]string
+	// resets follows the counters whose resets are adjusted
+	resets map[seriesKey]*counterReset
+	// downsamplers holds the resampler of every series with Downsample or
+	// Align
+	downsamplers map[seriesKey]resampler
+	// resampled counts the samples added to the resamplers of deleted
+	// instances, which are dropped
+	resampled int
+	// rates holds the rate series of every counter with RateWindow
+	rates map[seriesKey]*rateSeries
+	// states holds the series of every stat written as a state metric
+	states map[seriesKey]*stateSeries
+	// derived holds the derived metrics of every resource type seen
+	derived map[*gfs.ResourceType][]derivedMetric
+
+	// seen counts the instances, for progress reported by the writer
+	// goroutine of a pipeline
+	seen atomic.Int64
+
+	// pipeline, if set, appends the samples on a goroutine of its own;
+	// written and firstSample are then only read once it is closed
+	pipeline    *pipeline
+	written     int
+	firstSample time.Time
+	// skippedFiltered and skippedInvalid count the samples skipped as in
+	// FileSummary
+	skippedFiltered int
+	skippedInvalid  int
+	// stopped is the error write ended the read with, if any
+	stopped error
+}
+
+// streamInstance is what a stream keeps of an instance it has seen
+type streamInstance struct {
+	// labels are nil for instances that are skipped; filtered tells those
+	// skipped by the filters from those skipped as corrupt
+	labels   map[string]string
+	filtered bool
+	// written holds the stats that had a value written and last the time
+	// of the latest, to end their series when the instance is deleted
+	written map[*gfs.StatDescriptor]struct{}
+	last    time.Time
+	// derived computes the instance's derived metrics, if it has any
+	derived *derivedInstance
+}
+
+// convertStream converts an archive while reading it, keeping only its
+// resource types and instances in memory
+func (c *Converter) convertStream(reader *gfs.StatArchiveReader, filename, cluster string, labeler InstanceLabeler) (events.FileSummary, error) {
+	c.logger.Debugf("Streaming GFS file: %s", filename)
+	// The window is not relative to the end of the archive, or it would
+	// have been spilled
+	if err := c.setWindow(filename, time.Time{}); err != nil {
+		return events.FileSummary{}, err
+	}
+	s := c.newSampleStream(reader, filename, cluster, labeler)
+	if c.opts.PipelineBuffer > 0 {
+		s.startPipeline(c.opts.PipelineBuffer)
+	}
+	reader.SetDeleteFunc(func(instance *gfs.ResourceInstance) error {
+		if err := s.deleted(instance); err != nil {
+			s.stopped = err
+			return err
+		}
+		return nil
+	})
+	readErr := reader.ReadArchiveStream(func(instance *gfs.ResourceInstance, stat *gfs.StatDescriptor, timestamp time.Time, value float64) error {
+		if err := s.write(instance, stat, timestamp, value); err != nil {
+			s.stopped = err
+			return err
+		}
+		return nil
+	})
+	return s.finish(readErr)
+}
+
+// newSampleStream returns a stream that writes the samples of the archive
+// reader reads
+func (c *Converter) newSampleStream(reader *gfs.StatArchiveReader, filename, cluster string, labeler InstanceLabeler) *sampleStream {
+	return &sampleStream{
+		c:         c,
+		reader:    reader,
+		filename:  filename,
+		cluster:   cluster,
+		labeler:   labeler,
+		progress:  c.NewProgressReporter(filename, 0),
+		instances: make(map[*gfs.ResourceInstance]*streamInstance),
+		stats:     make(map[*gfs.StatDescriptor]*streamStat),
+		series:    make(map[seriesKey]map[string]string),
+		resets:    make(map[seriesKey]*counterReset),
+
+		downsamplers: make(map[seriesKey]resampler),
+		rates:        make(map[seriesKey]*rateSeries),
+		states:       make(map[seriesKey]*stateSeries),
+		derived:      make(map[*gfs.ResourceType][]derivedMetric),
+	}
+}
+
+// finish writes what is derived from the whole archive once it has been
+// read, ending with readErr, and commits
+func (s *sampleStream) finish(readErr error) (events.FileSummary, error) {
+	c, reader, filename := s.c, s.reader, s.filename
+	if s.prefix == "" {
+		// No sample was read
+		s.prefix, s.rule = c.filePrefix(filename, s.cluster, reader.GetArchiveInfo().ProductDescription)
+	}
+	summary := events.FileSummary{
+		ResourceTypes:    len(reader.GetResourceTypes()),
+		Instances:        len(reader.GetInstances()),
+		SamplingGaps:     len(reader.GetSamplingGaps()),
+		SamplingDisabled: len(reader.GetSamplingDisabled()),
+		MetricPrefix:     s.prefix,
+		PrefixRule:       s.rule,
+		SkippedFiltered:  s.skippedFiltered,
+		SkippedInvalid:   s.skippedInvalid,
+	}
+	if s.corrector != nil {
+		summary.CorrectionsApplied = s.corrector.Applied()
+	}
+	if readErr != nil {
+		if gfs.IsUnreadable(readErr) {
+			s.closePipeline()
+			return summary, fmt.Errorf("failed to parse %s: %w", filename, readErr)
+		}
+		if s.stopped != nil {
+			return s.stop(summary, readErr)
+		}
+		c.Warn(events.WarningParse, filename, "Archive parsing completed with errors: %v", readErr)
+	}
+	c.reportParse(reader.GetParseReport(), filename, &summary)
+
+	// The last samples of derived metrics and of every downsampled series
+	// are still pending
+	samples := s.resampled
+	for _, inst := range s.instances {
+		if inst.derived == nil {
+			continue
+		}
+		if err := inst.derived.flush(); err != nil {
+			return s.stop(summary, err)
+		}
+		samples += inst.derived.downsampled()
+	}
+	for _, ds := range s.downsamplers {
+		if err := ds.flush(); err != nil {
+			return s.stop(summary, err)
+		}
+		samples += ds.added()
+	}
+	for _, rate := range s.rates {
+		if err := rate.flush(); err != nil {
+			return s.stop(summary, err)
+		}
+		samples += rate.downsampled()
+	}
+	for _, state := range s.states {
+		if err := state.flush(); err != nil {
+			return s.stop(summary, err)
+		}
+	}
+	if err := s.closePipeline(); err != nil {
+		return s.stop(summary, err)
+	}
+	kept := s.written
+
+	s.written += c.reportSamplingGaps(reader.GetSamplingGaps(), filename, s.prefix)
+	s.written += c.reportSamplingDisabled(reader.GetSamplingDisabled(), filename, s.prefix)
+	s.written += c.reportInstanceCounts(reader, filename, s.prefix)
+	s.written += c.reportTimeZone(reader, filename, s.prefix, s.firstSample)
+	s.written += c.reportArchiveInfo(reader, filename, s.prefix, s.firstSample)
+
+	// Samples quarantined by the commit count as skipped
+	if err := c.writer.CommitSource(filename); err != nil {
+		return summary, fmt.Errorf("failed to commit metrics: %w", err)
+	}
+	s.written -= c.writer.Skipped(filename)
+	summary.SamplesWritten = s.written
+
+	c.logger.Infof("Converted %d metrics from %s", s.written, filename)
+	resets := 0
+	for _, reset := range s.resets {
+		resets += reset.resets
+	}
+	c.logCounterResets(filename, resets)
+	c.logDownsampled(filename, samples, kept)
+	if s.corrector != nil {
+		s.corrector.LogApplied(filename)
+	}
+	c.LogRates()
+	return summary, c.checkWindow(filename, s.written)
+}
+
+// stop ends the file early on err once the pipeline has appended what it
+// was handed, committing the samples written before the failure
+func (s *sampleStream) stop(summary events.FileSummary, err error) (events.FileSummary, error) {
+	if pipelineErr := s.closePipeline(); pipelineErr != nil && !errors.Is(err, pipelineErr) {
+		err = pipelineErr
+	}
+	if commitErr := s.c.writer.CommitSource(s.filename); commitErr != nil {
+		return summary, fmt.Errorf("failed to commit metrics: %w", commitErr)
+	}
+	summary.SamplesWritten = s.written - s.c.writer.Skipped(s.filename)
+	return summary, err
+}
+
+// write writes one decoded value
+func (s *sampleStream) write(instance *gfs.ResourceInstance, stat *gfs.StatDescriptor, timestamp time.Time, value float64) error {
+	if s.c.interrupted.Load() {
+		return ErrInterrupted
+	}
+	resType := s.reader.GetResourceTypes()[instance.TypeID]
+
+	inst, seen := s.instances[instance]
+	if !seen {
+		s.seen.Add(1)
+		inst = &streamInstance{}
+		switch {
+		case !isValidResourceType(resType) || !isValidInstance(instance):
+		case !s.c.config.Filters.IncludesType(resType.Name) || !s.c.includesInstance(instance.Name):
+			inst.filtered = true
+		default:
+			inst.labels = numericIDLabels(s.c.config, s.labeler(resType.Name, instance.Name), resType.Name, instance)
+			inst.labels = sourceLabels(s.c.config, inst.labels, s.filename, s.reader.GetArchiveInfo())
+			inst.derived = s.derivedInstance(resType, inst.labels)
+		}
+		s.instances[instance] = inst
+	}
+	if inst.labels == nil {
+		if inst.filtered {
+			s.skippedFiltered++
+		} else {
+			s.skippedInvalid++
+		}
+		return nil
+	}
+	if inst.derived != nil {
+		if err := inst.derived.add(stat, timestamp, value); err != nil {
+			return err
+		}
+	}
+
+	st, err := s.resolve(resType, stat)
+	if err != nil {
+		return err
+	}
+	if st.drop {
+		s.skippedFiltered++
+		return nil
+	}
+	if st.mapping != nil && !st.mapping.Valid(value) {
+		s.skippedInvalid++
+		return nil
+	}
+	if inst.written == nil {
+		inst.written = make(map[*gfs.StatDescriptor]struct{})
+	}
+	if _, ok := inst.written[stat]; !ok {
+		inst.written[stat] = struct{}{}
+	}
+	if timestamp.After(inst.last) {
+		inst.last = timestamp
+	}
+
+	labels := s.seriesLabels(instance, inst, stat, st)
+
+	if stat.IsCounter && s.c.opts.AdjustCounterResets {
+		key := seriesKey{instance: instance, stat: stat}
+		reset, ok := s.resets[key]
+		if !ok {
+			reset = &counterReset{}
+			s.resets[key] = reset
+		}
+		value = reset.adjust(value)
+	}
+
+	value = s.corrector.Apply(st.correction, value) * st.scale
+	if st.mapping != nil && st.mapping.AsStateMetric {
+		key := seriesKey{instance: instance, stat: stat}
+		state, ok := s.states[key]
+		if !ok {
+			state = newStateSeries(st.metricName, labels, func(metricName string, labels map[string]string, t time.Time, value float64) error {
+				return s.append(metricName, labels, value, t)
+			}, s.appendMarker)
+			s.states[key] = state
+		}
+		return state.add(timestamp, value)
+	}
+	if stat.IsCounter && s.c.opts.RateWindow > 0 {
+		key := seriesKey{instance: instance, stat: stat}
+		rate, ok := s.rates[key]
+		if !ok {
+			rate = s.c.newRateSeries(st.metricName, labels, func(metricName string, labels map[string]string, t time.Time, value float64) error {
+				return s.append(metricName, labels, value, t)
+			})
+			s.rates[key] = rate
+		}
+		if err := rate.add(timestamp, value); err != nil {
+			return err
+		}
+	}
+	if s.c.resamples() {
+		key := seriesKey{instance: instance, stat: stat}
+		ds, ok := s.downsamplers[key]
+		if !ok {
+			ds = s.c.newResampler(stat.IsCounter, func(t time.Time, value float64) error {
+				return s.append(st.metricName, labels, value, t)
+			})
+			s.downsamplers[key] = ds
+		}
+		return ds.add(timestamp, value)
+	}
+	return s.append(st.metricName, labels, value, timestamp)
+}
+
+// derivedInstance starts the derived metrics of an instance of a resource
+// type with labels, or returns nil if the type has none
+func (s *sampleStream) derivedInstance(resType *gfs.ResourceType, labels map[string]string) *derivedInstance {
+	metrics, ok := s.derived[resType]
+	if !ok {
+		metrics = s.c.derivedMetrics(resType)
+		s.derived[resType] = metrics
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+	return s.c.newDerivedInstance(metrics, s.c.config.RenameLabels(labels), func(metricName string, labels map[string]string, t time.Time, value float64) error {
+		return s.append(metricName, labels, value, t)
+	})
+}
+
+// seriesLabels returns the labels of the series of a stat of an instance
+func (s *sampleStream) seriesLabels(instance *gfs.ResourceInstance, inst *streamInstance, stat *gfs.StatDescriptor, st *streamStat) map[string]string {
+	if st.mapping == nil && len(s.c.config.LabelMappings) == 0 {
+		return inst.labels
+	}
+	key := seriesKey{instance: instance, stat: stat}
+	series, ok := s.series[key]
+	if !ok {
+		series = inst.labels
+		if st.mapping != nil {
+			series = mappedLabels(inst.labels, instance.Name, *st.mapping)
+		}
+		series = s.c.config.RenameLabels(series)
+		s.series[key] = series
+	}
+	return series
+}
+
+// deleted ends the series of an instance that was deleted with staleness
+// markers, once the samples of their downsamplers have been written
+func (s *sampleStream) deleted(instance *gfs.ResourceInstance) error {
+	inst := s.instances[instance]
+	if inst == nil {
+		return nil
+	}
+	if inst.derived != nil {
+		if err := inst.derived.flush(); err != nil {
+			return err
+		}
+		if err := inst.derived.markStale(instance.DeletionTime, s.appendMarker); err != nil {
+			return err
+		}
+		s.resampled += inst.derived.downsampled()
+		inst.derived = nil
+	}
+	if len(inst.written) == 0 {
+		return nil
+	}
+	resType := s.reader.GetResourceTypes()[instance.TypeID]
+	at := staleTime(instance.DeletionTime, inst.last)
+	for i := range resType.Stats {
+		stat := &resType.Stats[i]
+		if _, ok := inst.written[stat]; !ok {
+			continue
+		}
+		key := seriesKey{instance: instance, stat: stat}
+		if ds, ok := s.downsamplers[key]; ok {
+			if err := ds.flush(); err != nil {
+				return err
+			}
+			s.resampled += ds.added()
+			delete(s.downsamplers, key)
+		}
+		if state, ok := s.states[key]; ok {
+			if err := state.markStale(instance.DeletionTime); err != nil {
+				return err
+			}
+			delete(s.states, key)
+			continue
+		}
+		st := s.stats[stat]
+		labels := s.seriesLabels(instance, inst, stat, st)
+		if err := s.appendMarker(st.metricName, labels, at); err != nil {
+			return err
+		}
+		if rate, ok := s.rates[key]; ok {
+			if err := rate.flush(); err != nil {
+				return err
+			}
+			s.resampled += rate.downsampled()
+			delete(s.rates, key)
+			if rate.written {
+				if err := s.appendMarker(rate.metricName, labels, staleTime(instance.DeletionTime, rate.last)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// append writes one sample of the series of metricName with labels, or
+// hands it to the pipeline
+func (s *sampleStream) append(metricName string, labels map[string]string, value float64, timestamp time.Time) error {
+	if s.pipeline != nil {
+		return s.pipeline.send(pipelineSample{metricName: metricName, labels: labels, value: value, timestamp: timestamp})
+	}
+	return s.appendSample(metricName, labels, value, timestamp)
+}
+
+// appendMarker writes a staleness marker of the series of metricName with
+// labels, or hands it to the pipeline behind the samples before it
+func (s *sampleStream) appendMarker(metricName string, labels map[string]string, timestamp time.Time) error {
+	if s.pipeline != nil {
+		return s.pipeline.send(pipelineSample{metricName: metricName, labels: labels, timestamp: timestamp, stale: true})
+	}
+	return s.c.writeStaleMarker(s.filename, metricName, labels, timestamp)
+}
+
+// appendSample appends one sample to the TSDB, which commits them in
+// batches of Options.CommitBatch
+func (s *sampleStream) appendSample(metricName string, labels map[string]string, value float64, timestamp time.Time) error {
+	timestamp, ok := s.c.futureTime(s.filename, metricName, labels, timestamp, false)
+	if !ok {
+		return nil
+	}
+	if err := s.c.writer.WriteSourceMetric(s.filename, metricName, labels, value, timestamp); err != nil {
+		return s.c.writeFailed(s.filename, err, "Failed to write metric %s: %v", metricName, err)
+	}
+	if s.firstSample.IsZero() || timestamp.Before(s.firstSample) {
+		s.firstSample = timestamp
+	}
+	s.written++
+	s.progress.Update(int(s.seen.Load()), s.written)
+	return nil
+}
+
+// closePipeline waits for the pipeline, if any, to append every sample
+// handed to it
+func (s *sampleStream) closePipeline() error {
+	if s.pipeline == nil {
+		return nil
+	}
+	err := s.pipeline.close()
+	s.pipeline = nil
+	return err
+}
+
+// resolve decides how a stat is written at its first sample
+func (s *sampleStream) resolve(resType *gfs.ResourceType, stat *gfs.StatDescriptor) (*streamStat, error) {
+	if st, ok := s.stats[stat]; ok {
+		return st, nil
+	}
+
+	if s.corrector == nil {
+		// The header has been read by the time the first value arrives
+		product := s.reader.GetArchiveInfo().ProductDescription
+		s.corrector = s.c.NewValueCorrector(product)
+		s.prefix, s.rule = s.c.filePrefix(s.filename, s.cluster, product)
+	}
+
+	st := &streamStat{drop: !s.c.config.Filters.IncludesStat(resType.Name, stat.Name)}
+	defaultName, err := StatMetricName(s.c.config, s.prefix, resType.Name, stat)
+	if err != nil && !st.drop {
+		return nil, err
+	}
+	if mapping, ok := s.c.mappingFor(resType.Name, stat.Name, defaultName); ok {
+		st.drop = st.drop || mapping.Drop
+		st.mapping = &mapping
+	}
+	if !st.drop {
+		metricName := s.c.formatMetricName(resType.Name, stat, defaultName)
+		st.correction = s.corrector.Lookup(resType.Name, stat.Name, metricName)
+
+		st.metricName, st.scale, err = s.c.ResolveStat(s.filename, s.corrector.Product(), stat, metricName)
+		if err != nil {
+			return nil, err
+		}
+		if st.mapping != nil && st.mapping.AsStateMetric {
+			s.c.recordStateMetadata(st.metricName, stat)
+		} else {
+			s.c.recordMetadata(st.metricName, stat)
+		}
+	}
+	s.stats[stat] = st
+	return st, nil
+}
@@ -0,0 +1,123 @@
+package converter
+
+import (
+	"sort"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/pkg/events"
+)
+
+// FileResult is the summary of one file converted in a run
+type FileResult struct {
+	File string `json:"file"`
+	// Node is the cluster member the file is from, set by the cluster
+	// command
+	Node string `json:"node,omitempty"`
+	events.FileSummary
+}
+
+// SummaryTotals adds up the summaries of several files. SeriesWritten is
+// counted per file, so a series two files wrote to counts twice.
+type SummaryTotals struct {
+	Files                int        `json:"files"`
+	FailedFiles          int        `json:"failed_files"`
+	SeriesWritten        int        `json:"series_written"`
+	SamplesWritten       int        `json:"samples_written"`
+	SkippedFiltered      int        `json:"skipped_filtered"`
+	SkippedDuplicates    int        `json:"skipped_duplicates"`
+	SkippedInvalid       int        `json:"skipped_invalid"`
+	SamplesOutsideWindow int        `json:"samples_outside_window"`
+	SkippedSeriesLimit   int        `json:"skipped_series_limit,omitempty"`
+	FutureSamples        int        `json:"future_samples,omitempty"`
+	QuarantinedSamples   int        `json:"quarantined_samples,omitempty"`
+	ParseWarnings        int        `json:"parse_warnings"`
+	FirstSample          *time.Time `json:"first_sample,omitempty"`
+	LastSample           *time.Time `json:"last_sample,omitempty"`
+}
+
+// add adds the summary of a file
+func (t *SummaryTotals) add(summary events.FileSummary) {
+	t.Files++
+	if summary.Error != "" {
+		t.FailedFiles++
+	}
+	t.SeriesWritten += summary.SeriesWritten
+	t.SamplesWritten += summary.SamplesWritten
+	t.SkippedFiltered += summary.SkippedFiltered
+	t.SkippedDuplicates += summary.SkippedDuplicates
+	t.SkippedInvalid += summary.SkippedInvalid
+	t.SamplesOutsideWindow += summary.SamplesOutsideWindow
+	t.SkippedSeriesLimit += summary.SkippedSeriesLimit
+	t.FutureSamples += summary.FutureSamples
+	t.QuarantinedSamples += summary.QuarantinedSamples
+	t.ParseWarnings += summary.ParseWarnings
+	if first := summary.FirstSample; first != nil && (t.FirstSample == nil || first.Before(*t.FirstSample)) {
+		t.FirstSample = first
+	}
+	if last := summary.LastSample; last != nil && (t.LastSample == nil || last.After(*t.LastSample)) {
+		t.LastSample = last
+	}
+}
+
+// RunReport is the summary of the files converted in a run, with their
+// totals and, for a cluster, the totals of each node
+type RunReport struct {
+	Files  []FileResult              `json:"files"`
+	Nodes  map[string]*SummaryTotals `json:"nodes,omitempty"`
+	Totals SummaryTotals             `json:"totals"`
+}
+
+// Report returns the summary of the files converted so far, sorted by
+// node and file. node, if set, returns the node a file is from.
+func (c *Converter) Report(node func(filename string) string) *RunReport {
+	c.totalsMu.Lock()
+	report := &RunReport{Files: append([]FileResult(nil), c.totals.results...)}
+	c.totalsMu.Unlock()
+
+	if node != nil {
+		report.Nodes = make(map[string]*SummaryTotals)
+	}
+	for i := range report.Files {
+		result := &report.Files[i]
+		report.Totals.add(result.FileSummary)
+		if node == nil {
+			continue
+		}
+		result.Node = node(result.File)
+		totals := report.Nodes[result.Node]
+		if totals == nil {
+			totals = &SummaryTotals{}
+			report.Nodes[result.Node] = totals
+		}
+		totals.add(result.FileSummary)
+	}
+	sort.SliceStable(report.Files, func(i, j int) bool {
+		a, b := report.Files[i], report.Files[j]
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		return a.File < b.File
+	})
+	return report
+}
+
+// summarizeSource sets what the source filename wrote in its summary
+func (c *Converter) summarizeSource(filename string, summary *events.FileSummary) {
+	stats := c.writer.SourceStats(filename)
+	summary.SeriesWritten = stats.Series
+	summary.SkippedInvalid += stats.Rejected
+	if stats.Samples > 0 {
+		first, last := stats.FirstTime.UTC(), stats.LastTime.UTC()
+		summary.FirstSample, summary.LastSample = &first, &last
+	}
+	if start, end, ok := c.writer.Window(filename); ok {
+		if !start.IsZero() {
+			start = start.UTC()
+			summary.WindowStart = &start
+		}
+		if !end.IsZero() {
+			end = end.UTC()
+			summary.WindowEnd = &end
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WindowBound is one side of a TimeWindow: a time, or if Relative is set,
+// Offset before the last sample of each archive
+type WindowBound struct {
+	Time     time.Time
+	Offset   time.Duration
+	Relative bool
+}
+
+// ParseWindowBound parses a --start or --end value: an RFC3339 time, or a
+// negative duration such as -2h, taken back from the end of each archive.
+// The empty string leaves that side of the window open.
+func ParseWindowBound(value string) (WindowBound, error) {
+	if value == "" {
+		return WindowBound{}, nil
+	}
+	if strings.HasPrefix(value, "-") {
+		offset, err := time.ParseDuration(value[1:])
+		if err != nil {
+			return WindowBound{}, fmt.Errorf("invalid duration %q: %w", value, err)
+		}
+		return WindowBound{Offset: offset, Relative: true}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return WindowBound{}, fmt.Errorf("invalid time %q (expected RFC3339, e.g. 2024-01-02T15:04:05Z, or a duration before the archive end, e.g. -2h)", value)
+	}
+	return WindowBound{Time: t}, nil
+}
+
+// IsZero reports whether the bound leaves its side of the window open
+func (b WindowBound) IsZero() bool {
+	return !b.Relative && b.Time.IsZero()
+}
+
+// resolve returns the time of the bound for an archive whose last sample
+// is at end
+func (b WindowBound) resolve(end time.Time) time.Time {
+	if b.Relative {
+		return end.Add(-b.Offset)
+	}
+	return b.Time
+}
+
+func (b WindowBound) String() string {
+	switch {
+	case b.Relative:
+		return "-" + b.Offset.String()
+	case b.Time.IsZero():
+		return "open"
+	}
+	return b.Time.Format(time.RFC3339)
+}
+
+// TimeWindow limits the samples written from each archive to those from
+// Start to End, both included, skipping the others before they reach the
+// TSDB
+type TimeWindow struct {
+	Start WindowBound
+	End   WindowBound
+}
+
+// IsZero reports whether the window lets every sample through
+func (w TimeWindow) IsZero() bool {
+	return w.Start.IsZero() && w.End.IsZero()
+}
+
+// Relative reports whether a bound depends on the end of each archive,
+// which must then be known before its first sample is written
+func (w TimeWindow) Relative() bool {
+	return w.Start.Relative || w.End.Relative
+}
+
+// Validate fails if the window is empty whatever the archive: its start is
+// after its end, both being times or both durations before the end
+func (w TimeWindow) Validate() error {
+	if w.Start.IsZero() || w.End.IsZero() || w.Start.Relative != w.End.Relative {
+		return nil
+	}
+	if w.Start.resolve(time.Time{}).After(w.End.resolve(time.Time{})) {
+		return fmt.Errorf("empty time window: start %s is after end %s", w.Start, w.End)
+	}
+	return nil
+}
+
+func (w TimeWindow) String() string {
+	return fmt.Sprintf("from %s to %s", w.Start, w.End)
+}
+
+// setWindow limits the samples written from filename to the window, for
+// an archive whose last sample is at end, failing if that leaves it empty
+func (c *Converter) setWindow(filename string, end time.Time) error {
+	window := c.opts.Window
+	if window.IsZero() {
+		return nil
+	}
+	var start, stop time.Time
+	if !window.Start.IsZero() {
+		start = window.Start.resolve(end)
+	}
+	if !window.End.IsZero() {
+		stop = window.End.resolve(end)
+	}
+	if !start.IsZero() && !stop.IsZero() && start.After(stop) {
+		return fmt.Errorf("the time window %s is empty for %s, which ends at %s", window, filename, end.Format(time.RFC3339))
+	}
+	c.writer.SetWindow(filename, start, stop)
+	return nil
+}
+
+// checkWindow fails a file that had samples but none in the window
+func (c *Converter) checkWindow(filename string, written int) error {
+	if written > 0 || c.writer.Excluded(filename) == 0 {
+		return nil
+	}
+	return fmt.Errorf("no sample of %s falls in the time window %s", filename, c.opts.Window)
+}
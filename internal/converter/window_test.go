@@ -0,0 +1,52 @@
+package converter_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// TestTimeWindow checks that a window between two samples and one going
+// back from the end each write the samples from their start to their end,
+// both included, and that a window after the last sample fails the file
+func TestTimeWindow(t *testing.T) {
+	dir := t.TempDir()
+	archive := synthetic(t, dir, testStart)
+	end := testStart.Add(time.Duration(testOptions.Samples) * gfstest.SampleInterval)
+	tests := []struct {
+		name   string
+		window converter.TimeWindow
+		first  time.Time
+	}{
+		{"absolute", converter.TimeWindow{
+			Start: converter.WindowBound{Time: testStart.Add(2 * gfstest.SampleInterval)},
+			End:   converter.WindowBound{Time: testStart.Add(4 * gfstest.SampleInterval)},
+		}, testStart.Add(2 * gfstest.SampleInterval)},
+		{"relative", converter.TimeWindow{
+			Start: converter.WindowBound{Offset: 2 * gfstest.SampleInterval, Relative: true},
+		}, end.Add(-2 * gfstest.SampleInterval)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tsdbPath := filepath.Join(t.TempDir(), "tsdb")
+			mustConvert(t, archive, tsdbPath, "", converter.Options{Window: tt.window})
+			series := instanceSeries(t, tsdbPath, testStart, testOptions.Samples+1)
+			if len(series) != len(gfstest.StatTypes) {
+				t.Fatalf("%s has %d series, want %d", gfstest.InstanceName(0, 0), len(series), len(gfstest.StatTypes))
+			}
+			for _, s := range series {
+				if len(s.Timestamps) != 3 || !s.Timestamps[0].Equal(tt.first) || !s.Timestamps[2].Equal(tt.first.Add(2*gfstest.SampleInterval)) {
+					t.Errorf("%s has samples at %v, want the 3 from %s", s.Labels["__name__"], s.Timestamps, tt.first.Format(time.RFC3339))
+				}
+			}
+		})
+	}
+
+	late := converter.TimeWindow{Start: converter.WindowBound{Time: end.Add(gfstest.SampleInterval)}}
+	if _, err := convertFile(archive, filepath.Join(dir, "tsdb-late"), "", converter.Options{Window: late}); err == nil {
+		t.Error("a window after the last sample did not fail the file")
+	}
+}
@@ -0,0 +1,193 @@
+package enrich
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is the on-disk layout of an enrichment file. Each rule describes
+// which resource types it applies to, how to extract the join key, and the
+// table of labels to add for each key.
+type File struct {
+	Enrichments []Rule `yaml:"enrichments"`
+}
+
+type Rule struct {
+	Name          string                       `yaml:"name"`
+	ResourceTypes []string                     `yaml:"resource_types"`
+	KeyFrom       string                       `yaml:"key_from"`
+	KeyPattern    string                       `yaml:"key_pattern"`
+	Labels        []string                     `yaml:"labels"`
+	Entries       map[string]map[string]string `yaml:"entries"`
+}
+
+// RuleStats counts how many instances a rule joined and how many it could
+// not find an entry for
+type RuleStats struct {
+	Name    string
+	Joined  int
+	Missing int
+}
+
+type rule struct {
+	Rule
+	keyRegex *regexp.Regexp
+	joined   int
+	missing  int
+}
+
+// Enricher adds labels to series by joining on a key extracted from the
+// instance name or node name
+type Enricher struct {
+	mu    sync.Mutex
+	rules []*rule
+}
+
+func Load(filename string) (*Enricher, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+
+	e := &Enricher{}
+	for i, r := range f.Enrichments {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("enrichment-%d", i+1)
+		}
+
+		switch r.KeyFrom {
+		case "":
+			r.KeyFrom = "instance"
+		case "instance", "node":
+		default:
+			return nil, fmt.Errorf("enrichment %s: key_from must be instance or node, got %q", r.Name, r.KeyFrom)
+		}
+
+		for _, pattern := range r.ResourceTypes {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("enrichment %s: invalid resource type pattern %s: %w", r.Name, pattern, err)
+			}
+		}
+
+		// Every entry needs a key to join on, and every label to copy must
+		// be a column of some entry, or the rule can never add it
+		columns := make(map[string]bool)
+		for key, entry := range r.Entries {
+			if key == "" {
+				return nil, fmt.Errorf("enrichment %s: entry without a key", r.Name)
+			}
+			for name := range entry {
+				columns[name] = true
+			}
+		}
+		for _, name := range r.Labels {
+			if !columns[name] {
+				return nil, fmt.Errorf("enrichment %s: label %s is not a column of any entry", r.Name, name)
+			}
+		}
+
+		compiled := &rule{Rule: r}
+		if r.KeyPattern != "" {
+			regex, err := regexp.Compile(r.KeyPattern)
+			if err != nil {
+				return nil, fmt.Errorf("enrichment %s: invalid key_pattern: %w", r.Name, err)
+			}
+			compiled.keyRegex = regex
+		}
+		e.rules = append(e.rules, compiled)
+	}
+
+	return e, nil
+}
+
+// Apply adds the labels of every matching rule to labels. Rules whose key
+// cannot be extracted are skipped; keys without an entry are counted as
+// missing and leave labels untouched.
+func (e *Enricher) Apply(resourceType, instanceName, nodeName string, labels map[string]string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, r := range e.rules {
+		if !r.appliesTo(resourceType) {
+			continue
+		}
+
+		source := instanceName
+		if r.KeyFrom == "node" {
+			source = nodeName
+		}
+
+		key, ok := r.extractKey(source)
+		if !ok {
+			continue
+		}
+
+		entry, ok := r.Entries[key]
+		if !ok {
+			r.missing++
+			continue
+		}
+		r.joined++
+
+		if len(r.Labels) == 0 {
+			for name, value := range entry {
+				labels[name] = value
+			}
+			continue
+		}
+		for _, name := range r.Labels {
+			if value, ok := entry[name]; ok {
+				labels[name] = value
+			}
+		}
+	}
+}
+
+// Stats returns the join counts for every rule in file order
+func (e *Enricher) Stats() []RuleStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stats := make([]RuleStats, 0, len(e.rules))
+	for _, r := range e.rules {
+		stats = append(stats, RuleStats{Name: r.Name, Joined: r.joined, Missing: r.missing})
+	}
+	return stats
+}
+
+func (r *rule) appliesTo(resourceType string) bool {
+	if len(r.ResourceTypes) == 0 {
+		return true
+	}
+	for _, pattern := range r.ResourceTypes {
+		if matched, _ := path.Match(pattern, resourceType); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rule) extractKey(source string) (string, bool) {
+	if r.keyRegex == nil {
+		return source, source != ""
+	}
+
+	matches := r.keyRegex.FindStringSubmatch(source)
+	if matches == nil {
+		return "", false
+	}
+	if len(matches) > 1 {
+		return matches[1], matches[1] != ""
+	}
+	return matches[0], matches[0] != ""
+}
@@ -0,0 +1,165 @@
+package enrich_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/enrich"
+)
+
+// load writes an enrichment file and loads it
+func load(t *testing.T, file string) (*enrich.Enricher, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "enrichment.yaml")
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return enrich.Load(path)
+}
+
+// TestLoadInvalid checks that enrichment files a rule could not join with
+// fail to load, naming what is wrong with them
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		// want is part of the error
+		want string
+	}{
+		{"malformed", "enrichments:\n  - name: regions\n    entries: [orders\n", "yaml"},
+		{"not a list of rules", "enrichments:\n  name: regions\n", "yaml"},
+		{"duplicate keys", `enrichments:
+  - name: regions
+    entries:
+      orders: {data_policy: PARTITION}
+      orders: {data_policy: REPLICATE}
+`, `"orders" already defined`},
+		{"entry without a key", `enrichments:
+  - name: regions
+    entries:
+      "": {data_policy: PARTITION}
+`, "entry without a key"},
+		{"missing label column", `enrichments:
+  - name: regions
+    labels: [data_policy, disk_store]
+    entries:
+      orders: {data_policy: PARTITION}
+`, "disk_store is not a column"},
+		{"unknown key source", `enrichments:
+  - name: regions
+    key_from: region
+    entries:
+      orders: {data_policy: PARTITION}
+`, "key_from"},
+		{"invalid key pattern", `enrichments:
+  - name: regions
+    key_pattern: '([a-z]+'
+    entries:
+      orders: {data_policy: PARTITION}
+`, "key_pattern"},
+		{"invalid type pattern", `enrichments:
+  - name: regions
+    resource_types: ['[Partitioned']
+    entries:
+      orders: {data_policy: PARTITION}
+`, "resource type pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := load(t, tt.file); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("want an error about %s, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+// regions joins region stats on the region name of their instance, and
+// client stats on the host of their node
+const regions = `enrichments:
+  - name: regions
+    resource_types: [PartitionedRegionStats, CachePerfStats]
+    key_pattern: '^(?:partition-)?/?([^/]+)$'
+    labels: [data_policy, redundancy]
+    entries:
+      orders: {data_policy: PARTITION_REDUNDANT, redundancy: "1", disk_store: orders-store}
+      customers: {data_policy: REPLICATE}
+  - name: hosts
+    resource_types: ["Client*"]
+    key_from: node
+    key_pattern: '^[a-z]+-[0-9]+'
+    entries:
+      app-1: {client_app: orders}
+`
+
+// TestApply checks the labels regions adds to the instances of a type,
+// from the key its instance or node name gives, and the joins and misses
+// counted for every rule, which the converter logs at the end of a run
+func TestApply(t *testing.T) {
+	enricher, err := load(t, regions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		resourceType, instance, node string
+		want                         map[string]string
+	}{
+		// The capture group is the key, and only the listed labels are added
+		{"PartitionedRegionStats", "partition-orders", "", map[string]string{"data_policy": "PARTITION_REDUNDANT", "redundancy": "1"}},
+		{"CachePerfStats", "/customers", "", map[string]string{"data_policy": "REPLICATE"}},
+		// A key without an entry is a miss
+		{"PartitionedRegionStats", "partition-invoices", "", map[string]string{}},
+		// A name the pattern does not match has no key, so is not counted
+		{"CachePerfStats", "/orders/archived", "", map[string]string{}},
+		// Rules only apply to their types
+		{"DiskRegionStatistics", "orders", "", map[string]string{}},
+		// Without a capture group the whole match is the key, here of the
+		// node name, and every label of the entry is added
+		{"ClientStats", "orders", "app-1.example.com", map[string]string{"client_app": "orders"}},
+		{"ClientStats", "orders", "app-2.example.com", map[string]string{}},
+		{"ClientStats", "orders", "", map[string]string{}},
+	}
+	for _, tt := range tests {
+		labels := make(map[string]string)
+		enricher.Apply(tt.resourceType, tt.instance, tt.node, labels)
+		if fmt.Sprint(labels) != fmt.Sprint(tt.want) {
+			t.Errorf("%s %q on %q: got labels %v, want %v", tt.resourceType, tt.instance, tt.node, labels, tt.want)
+		}
+	}
+
+	want := []enrich.RuleStats{{Name: "regions", Joined: 2, Missing: 1}, {Name: "hosts", Joined: 1, Missing: 1}}
+	if got := enricher.Stats(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got join counts %v, want %v", got, want)
+	}
+}
+
+// TestDefaults checks that an unnamed rule without a key pattern is named
+// by its place in the file and joins on the whole instance name
+func TestDefaults(t *testing.T) {
+	enricher, err := load(t, `enrichments:
+  - entries:
+      orders: {data_policy: PARTITION}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := make(map[string]string)
+	enricher.Apply("PartitionedRegionStats", "orders", "", labels)
+	enricher.Apply("PartitionedRegionStats", "partition-orders", "", labels)
+	if labels["data_policy"] != "PARTITION" {
+		t.Errorf("got labels %v", labels)
+	}
+	want := []enrich.RuleStats{{Name: "enrichment-1", Joined: 1, Missing: 1}}
+	if got := enricher.Stats(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got join counts %v, want %v", got, want)
+	}
+}
+
+// TestLoadExample checks that the example enrichment file loads
+func TestLoadExample(t *testing.T) {
+	if _, err := enrich.Load(filepath.Join("..", "..", "enrichment.example.yaml")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,257 @@
+package gfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+)
+
+// testOptions sizes the synthetic archive the tests read
+var testOptions = gfstest.Options{Types: 2, Instances: 3, Samples: 20}
+
+var testStart = time.UnixMilli(1700000000000)
+
+// synthetic returns the synthetic archive starting at start in the byte
+// order and version of layout
+func synthetic(t *testing.T, start time.Time, layout gfs.ArchiveHeader) []byte {
+	t.Helper()
+	var archive bytes.Buffer
+	if err := gfstest.Write(&archive, start, testOptions, layout); err != nil {
+		t.Fatal(err)
+	}
+	return archive.Bytes()
+}
+
+// writeFile writes data to a file in dir
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readArchive reads data with the reader configured by setup
+func readArchive(data []byte, setup func(reader *gfs.StatArchiveReader)) (*gfs.StatArchiveReader, error) {
+	reader := gfs.NewStatArchiveReaderFromReader(bytes.NewReader(data), int64(len(data)))
+	reader.SetLogger(logging.Discard)
+	if setup != nil {
+		setup(reader)
+	}
+	return reader, reader.ReadArchive()
+}
+
+// archiveMetadata reads the archive at path, which must be in byte order
+// order, and describes its header, types and instances
+func archiveMetadata(t *testing.T, path string, order binary.ByteOrder) string {
+	t.Helper()
+	reader, err := gfs.NewStatArchiveReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	reader.SetLogger(logging.Discard)
+	if err := reader.ReadArchive(); err != nil {
+		t.Fatal(err)
+	}
+
+	info := reader.GetArchiveInfo()
+	if info.ByteOrder != gfs.ByteOrderName(order) {
+		t.Fatalf("read as %s, written %s", info.ByteOrder, gfs.ByteOrderName(order))
+	}
+	info.ByteOrder = ""
+	var lines []string
+	lines = append(lines, fmt.Sprintf("%+v", info))
+	for id, resType := range reader.GetResourceTypes() {
+		lines = append(lines, fmt.Sprintf("type %d %s %+v", id, resType.Name, resType.Stats))
+	}
+	for id, instance := range reader.GetInstances() {
+		lines = append(lines, fmt.Sprintf("instance %d %s %d %s", id, instance.Name, instance.TypeID, instance.CreationTime.Format(time.RFC3339Nano)))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// TestReadLayouts checks that the synthetic archive reads back exactly in
+// both byte orders and every archive version, with the same metadata
+func TestReadLayouts(t *testing.T) {
+	dir := t.TempDir()
+	latest := writeFile(t, dir, "latest.gfs", synthetic(t, testStart, gfs.ArchiveHeader{}))
+	want := archiveMetadata(t, latest, binary.BigEndian)
+
+	tests := []struct {
+		name   string
+		layout gfs.ArchiveHeader
+		order  binary.ByteOrder
+	}{
+		{"little-endian", gfs.ArchiveHeader{ByteOrder: binary.LittleEndian}, binary.LittleEndian},
+	}
+	for version := gfs.MIN_ARCHIVE_VERSION; version <= gfs.ARCHIVE_VERSION; version++ {
+		tests = append(tests, struct {
+			name   string
+			layout gfs.ArchiveHeader
+			order  binary.ByteOrder
+		}{fmt.Sprintf("version %d", version), gfs.ArchiveHeader{Version: version}, binary.BigEndian})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, t.TempDir(), "archive.gfs", synthetic(t, testStart, tt.layout))
+			if _, _, err := gfstest.Verify(path, testStart, testOptions); err != nil {
+				t.Fatal(err)
+			}
+			got := archiveMetadata(t, path, tt.order)
+			// The version is the one field of the header meant to differ
+			if tt.layout.Version != 0 {
+				got = strings.Replace(got, fmt.Sprintf("Version:%d ", tt.layout.Version), fmt.Sprintf("Version:%d ", gfs.ARCHIVE_VERSION), 1)
+			}
+			if got != want {
+				t.Errorf("metadata differs from the latest version big-endian:\n%s\n%s", got, want)
+			}
+		})
+	}
+}
+
+// TestReadPadded checks that an archive followed by zero padding, as added
+// by backup systems that copy files in whole blocks, reads as the archive
+// alone
+func TestReadPadded(t *testing.T) {
+	const padding = 4096
+	data := append(synthetic(t, testStart, gfs.ArchiveHeader{}), make([]byte, padding)...)
+	path := writeFile(t, t.TempDir(), "padded.gfs", data)
+	_, report, err := gfstest.Verify(path, testStart, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.PaddingBytes != padding {
+		t.Errorf("ignored %d padding bytes, wrote %d", report.PaddingBytes, padding)
+	}
+}
+
+// TestReadAppended checks that the samples of both archives of a file
+// holding two, the second starting a minute after the first ends as a
+// restarted member appends it, are read
+func TestReadAppended(t *testing.T) {
+	restart := testStart.Add(time.Duration(testOptions.Samples)*gfstest.SampleInterval + time.Minute)
+	data := append(synthetic(t, testStart, gfs.ArchiveHeader{}), synthetic(t, restart, gfs.ArchiveHeader{})...)
+	reader, err := readArchive(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := reader.GetParseReport()
+	if !report.Clean() || report.Archives != 2 {
+		t.Fatalf("appended archives were not read cleanly: %s", report)
+	}
+
+	instances := reader.GetInstances()
+	if len(instances) != 2*testOptions.Types*testOptions.Instances {
+		t.Fatalf("read %d instances from both archives, wrote %d", len(instances), 2*testOptions.Types*testOptions.Instances)
+	}
+	for _, instance := range instances {
+		archiveStart := []time.Time{testStart, restart}[instance.Segment]
+		for stat, s := range gfstest.StatTypes {
+			samples := instance.Stats[int32(stat)]
+			if len(samples) != testOptions.Samples {
+				t.Fatalf("%s.%s of archive %d has %d samples, wrote %d", instance.Name, s.Name, instance.Segment+1, len(samples), testOptions.Samples)
+			}
+			if want := archiveStart.Add(gfstest.SampleInterval); !samples[0].Time().Equal(want) {
+				t.Errorf("%s.%s of archive %d starts at %s, wrote %s", instance.Name, s.Name, instance.Segment+1,
+					samples[0].Time().Format(time.RFC3339Nano), want.Format(time.RFC3339Nano))
+			}
+		}
+	}
+}
+
+// smallArchive returns an archive of two instances of one type and four
+// sample records, each setting two stats of both, and the offsets of its
+// sample records
+func smallArchive(t *testing.T) ([]byte, []int) {
+	t.Helper()
+	var archive bytes.Buffer
+	w, err := gfs.NewArchiveWriter(&archive, gfs.ArchiveHeader{StartTime: testStart, SystemStartTime: testStart})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteResourceType(gfstest.SyntheticType(0)); err != nil {
+		t.Fatal(err)
+	}
+	for i := int32(0); i < 2; i++ {
+		if err := w.CreateInstance(i, gfstest.InstanceName(0, int(i)), int64(i), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var recordStarts []int
+	for k := 0; k < 4; k++ {
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		recordStarts = append(recordStarts, archive.Len())
+		samples := []gfs.InstanceSample{
+			{InstanceID: 0, Values: map[int]float64{0: float64(k), 1: float64(k)}},
+			{InstanceID: 1, Values: map[int]float64{0: float64(k), 1: float64(k)}},
+		}
+		if err := w.WriteSample(testStart.Add(time.Duration(k)*gfstest.SampleInterval), samples); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return archive.Bytes(), recordStarts
+}
+
+// values returns the number of values the instances of reader hold
+func values(reader *gfs.StatArchiveReader) int {
+	n := 0
+	for _, instance := range reader.GetInstances() {
+		for _, stat := range instance.Stats {
+			n += len(stat)
+		}
+	}
+	return n
+}
+
+// longValues are long stat values around the widths of the compact
+// encoding, all exactly representable as a float64
+var longValues = []float64{
+	1<<31 - 1, 1 << 31, 1 << 32, 1 << 40, 1 << 53, 1 << 62,
+	-1, -1 << 31, -1<<31 - 1, -1 << 40, -1 << 62, math.MinInt64,
+}
+
+// TestLongValues checks that long stats wider than an int are read back as
+// written
+func TestLongValues(t *testing.T) {
+	var archive bytes.Buffer
+	err := gfstest.Instance{
+		Start:  testStart,
+		Stats:  []gfs.StatDescriptor{{Name: "value", Type: gfs.StatTypeLong}},
+		Values: [][]float64{longValues},
+	}.Write(&archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := readArchive(archive.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := reader.GetInstances()[0].Stats[0]
+	if len(samples) != len(longValues) {
+		t.Fatalf("read %d values, wrote %d", len(samples), len(longValues))
+	}
+	for k, sample := range samples {
+		if sample.Value != longValues[k] {
+			t.Errorf("value %d is %v, wrote %v", k, sample.Value, longValues[k])
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package gfs
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+)
+
+// binarySampleValues are the values of the int stat of the archives
+// TestBinarySampleOffset writes, of every compact width an int takes
+var binarySampleValues = []float64{5, -3, 300, -70000, 1 << 20, -1 << 30}
+
+// intArchive returns an archive with the header fields of header, of one
+// instance, id 0, of a type with one int stat sampled once a second with
+// values, and the offset of its first sample record
+func intArchive(t *testing.T, header ArchiveHeader, values []float64) ([]byte, int64) {
+	t.Helper()
+	return statArchive(t, header, StatTypeInt, values)
+}
+
+// statArchive is intArchive with a stat of the given type
+func statArchive(t *testing.T, header ArchiveHeader, statType StatType, values []float64) ([]byte, int64) {
+	t.Helper()
+	var archive bytes.Buffer
+	w, err := NewArchiveWriter(&archive, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteResourceType(&ResourceType{Name: "ValueStats", Stats: []StatDescriptor{{Name: "value", Type: statType}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CreateInstance(0, "value", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	firstSample := int64(archive.Len())
+	for k, value := range values {
+		sample := InstanceSample{InstanceID: 0, Values: map[int]float64{0: value}}
+		if err := w.WriteSample(header.StartTime.Add(time.Duration(k+1)*time.Second), []InstanceSample{sample}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return archive.Bytes(), firstSample
+}
+
+// TestBinarySampleOffset reads two archives whose headers differ in size
+// and checks that the binary sample pass of each starts at its own first
+// sample record and finds every value, and that it fails without one
+func TestBinarySampleOffset(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	tests := []struct {
+		name   string
+		header ArchiveHeader
+	}{
+		{"short header", ArchiveHeader{StartTime: start, SystemStartTime: start}},
+		{"long header", ArchiveHeader{
+			StartTime:          start,
+			SystemStartTime:    start,
+			TimeZoneName:       "America/Argentina/ComodRivadavia",
+			SystemDirectory:    "/opt/geode/" + strings.Repeat("servers/", 40),
+			ProductDescription: "Apache Geode " + strings.Repeat("with a long description ", 20),
+			OSInfo:             "Linux 6.1.0",
+			MachineInfo:        "amd64 64 cpus",
+		}},
+	}
+	firstSamples := make(map[int64]string)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, firstSample := intArchive(t, tt.header, binarySampleValues)
+			if other, ok := firstSamples[firstSample]; ok {
+				t.Fatalf("the first sample record is at %d after the %s as well", firstSample, other)
+			}
+			firstSamples[firstSample] = tt.name
+
+			reader := NewStatArchiveReaderFromReader(bytes.NewReader(data), int64(len(data)))
+			reader.SetLogger(logging.Discard)
+			if err := reader.ReadArchive(); err != nil {
+				t.Fatal(err)
+			}
+			if reader.metadataEnd != firstSample {
+				t.Errorf("metadata ends at %d, the first sample record is at %d", reader.metadataEnd, firstSample)
+			}
+
+			// The binary pass stores what it decodes over what ReadArchive read
+			instance := reader.GetInstances()[0]
+			instance.Stats = make(map[int32][]StatValue)
+			n, err := reader.parseBinarySamples()
+			if err != nil {
+				t.Fatal(err)
+			}
+			read := instance.Stats[0]
+			if n != len(binarySampleValues) || len(read) != n {
+				t.Fatalf("binary pass found %d values and stored %d, wrote %d", n, len(read), len(binarySampleValues))
+			}
+			for k, sample := range read {
+				at := start.Add(time.Duration(k+1) * time.Second)
+				if sample.Value != binarySampleValues[k] || !sample.Time().Equal(at) {
+					t.Errorf("value %d is %v at %s, wrote %v at %s", k, sample.Value, sample.Time().Format(time.RFC3339Nano),
+						binarySampleValues[k], at.Format(time.RFC3339Nano))
+				}
+			}
+		})
+	}
+
+	t.Run("no samples", func(t *testing.T) {
+		data, _ := intArchive(t, tests[0].header, nil)
+		reader := NewStatArchiveReaderFromReader(bytes.NewReader(data), int64(len(data)))
+		reader.SetLogger(logging.Discard)
+		if err := reader.ReadArchive(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reader.parseBinarySamples(); err == nil {
+			t.Error("binary pass ran without a sample record to start at")
+		}
+	})
+}
+
+// TestBinarySampleLimit checks that the binary sample pass refuses a
+// section larger than the reader's sample bytes limit
+func TestBinarySampleLimit(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	data, firstSample := intArchive(t, ArchiveHeader{StartTime: start, SystemStartTime: start}, binarySampleValues)
+	reader := NewStatArchiveReaderFromReader(bytes.NewReader(data), int64(len(data)))
+	reader.SetLogger(logging.Discard)
+	reader.SetLimits(ReaderLimits{MaxSampleBytes: 8})
+	if err := reader.ReadArchive(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := reader.parseBinarySamples()
+	var limit *ErrLimitExceeded
+	if !errors.As(err, &limit) || limit.Limit != 8 || limit.Value != int64(len(data))-firstSample {
+		t.Errorf("want the %d byte section over the limit of 8, got %v", int64(len(data))-firstSample, err)
+	}
+}
+
+// TestBinarySampleTypes checks that the binary sample pass reads longs
+// beyond the range of an int and doubles whole, as wide as their type
+func TestBinarySampleTypes(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	tests := []struct {
+		name     string
+		statType StatType
+		values   []float64
+	}{
+		{"long", StatTypeLong, []float64{math.MaxInt32 + 1, 1 << 40, -1 << 35, 7, math.MaxInt32}},
+		{"double", StatTypeDouble, []float64{0.25, -1.5e10, 3, 1e-300, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := statArchive(t, ArchiveHeader{StartTime: start, SystemStartTime: start}, tt.statType, tt.values)
+			reader := NewStatArchiveReaderFromReader(bytes.NewReader(data), int64(len(data)))
+			reader.SetLogger(logging.Discard)
+			if err := reader.ReadArchive(); err != nil {
+				t.Fatal(err)
+			}
+
+			instance := reader.GetInstances()[0]
+			instance.Stats = make(map[int32][]StatValue)
+			n, err := reader.parseBinarySamples()
+			if err != nil {
+				t.Fatal(err)
+			}
+			read := instance.Stats[0]
+			if n != len(tt.values) || len(read) != n {
+				t.Fatalf("binary pass found %d values and stored %d, wrote %d", n, len(read), len(tt.values))
+			}
+			for k, sample := range read {
+				if sample.Value != tt.values[k] {
+					t.Errorf("value %d is %v, wrote %v", k, sample.Value, tt.values[k])
+				}
+			}
+		})
+	}
+}
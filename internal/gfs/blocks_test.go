@@ -0,0 +1,80 @@
+package gfs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// TestMidBlockRecovery damages a stat offset in the middle of the block
+// of the second instance in a sample record of the synthetic archive,
+// whose stats are of every width, and checks that only that block is
+// dropped, as a whole, while every value of the instances before and after
+// it in the record, and in every other record, is read as written
+func TestMidBlockRecovery(t *testing.T) {
+	data := synthetic(t, testStart, gfs.ArchiveHeader{})
+	reader, err := readArchive(data, func(reader *gfs.StatArchiveReader) { reader.TraceLayout() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := reader.GetLayout()
+
+	// The stat offsets of each block of the record, ended by
+	// ILLEGAL_STAT_OFFSET. Blocks are written in instance id order.
+	const damagedSample, damagedID, damagedStat = 5, 1, 3
+	var samples []int64
+	for _, record := range layout.Records {
+		if record.Token == gfs.SAMPLE_TOKEN {
+			samples = append(samples, record.Offset)
+		}
+	}
+	var blocks [][]int64
+	var block []int64
+	for _, offset := range layout.StatOffsets {
+		if offset <= samples[damagedSample] || offset >= samples[damagedSample+1] {
+			continue
+		}
+		block = append(block, offset)
+		if data[offset] == gfs.ILLEGAL_STAT_OFFSET {
+			blocks = append(blocks, block)
+			block = nil
+		}
+	}
+	if len(blocks) != testOptions.Types*testOptions.Instances {
+		t.Fatalf("sample record %d holds %d blocks, want %d", damagedSample, len(blocks), testOptions.Types*testOptions.Instances)
+	}
+	damaged := append([]byte(nil), data...)
+	damaged[blocks[damagedID][damagedStat]] = 200
+
+	reader, err = readArchive(damaged, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := reader.GetParseReport(); report.WarningCount != 1 || report.SkippedRecords != 0 {
+		t.Errorf("want the block dropped with a warning, got %s", report)
+	}
+	for id, instance := range reader.GetInstances() {
+		for stat := range gfstest.StatTypes {
+			var want []int
+			for k := 0; k < testOptions.Samples; k++ {
+				if id != damagedID || k != damagedSample {
+					want = append(want, k)
+				}
+			}
+			read := instance.Stats[int32(stat)]
+			if len(read) != len(want) {
+				t.Errorf("%s.%s has %d samples, want %d", instance.Name, gfstest.StatTypes[stat].Name, len(read), len(want))
+				continue
+			}
+			for i, k := range want {
+				value, at := gfstest.Value(int32(stat), id, k), testStart.Add(time.Duration(k+1)*gfstest.SampleInterval)
+				if read[i].Value != value || !read[i].Time().Equal(at) {
+					t.Errorf("%s.%s sample %d is %v at %s, wrote %v at %s", instance.Name, gfstest.StatTypes[stat].Name, k,
+						read[i].Value, read[i].Time().Format(time.RFC3339Nano), value, at.Format(time.RFC3339Nano))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package gfs
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// headerFieldsSize is the size of the fixed-size header fields that follow
+// the version: the start timestamp, system id, system start time and
+// timezone offset
+const headerFieldsSize = 8 + 8 + 8 + 4
+
+// Bounds of the header fields of a real archive: statistics archives were
+// first written long after 1990, and no timezone is more than 18 hours
+// from UTC
+var (
+	earliestArchiveTime = time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	latestArchiveTime   = time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+)
+
+const maxTimeZoneOffset = 18 * time.Hour
+
+// headerByteOrder returns the byte order in which the fixed-size header
+// fields decode to plausible values, big-endian first as Java's
+// DataOutputStream writes them. It returns false if neither does.
+func headerByteOrder(fields []byte) (binary.ByteOrder, bool) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		if plausibleHeader(order, fields) {
+			return order, true
+		}
+	}
+	return binary.BigEndian, false
+}
+
+// plausibleHeader reports whether the fixed-size header fields decode to a
+// start time and a system start time, if one is set, within the bounds of
+// a real archive and to an offset that is a timezone
+func plausibleHeader(order binary.ByteOrder, fields []byte) bool {
+	if len(fields) < headerFieldsSize {
+		return false
+	}
+	start := int64(order.Uint64(fields))
+	systemStart := int64(order.Uint64(fields[16:]))
+	offset := time.Duration(int32(order.Uint32(fields[24:]))) * time.Millisecond
+	return plausibleArchiveTime(start) &&
+		(systemStart == 0 || plausibleArchiveTime(systemStart)) &&
+		offset >= -maxTimeZoneOffset && offset <= maxTimeZoneOffset
+}
+
+func plausibleArchiveTime(millis int64) bool {
+	return millis >= earliestArchiveTime && millis < latestArchiveTime
+}
+
+// ByteOrderName returns how ArchiveInfo names a byte order
+func ByteOrderName(order binary.ByteOrder) string {
+	switch order {
+	case binary.BigEndian:
+		return "big-endian"
+	case binary.LittleEndian:
+		return "little-endian"
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package gfs
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// compactPayload follows the first byte of every compact value decoded by
+// TestCompactValues. Its first byte is negative, so the values it makes
+// are only right if their sign comes from it.
+var compactPayload = []byte{0xA5, 0x5A, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+
+// TestCompactValues decodes a compact value starting with every byte from
+// 0x00 to 0xFF, as the binary-sample path and the record reader do, and
+// checks that both give the value and width the Geode encoding does
+func TestCompactValues(t *testing.T) {
+	tests := []struct {
+		name        string
+		first, last byte
+		width       int
+		// want is the value of a compact value starting with first
+		want func(first byte) int64
+	}{
+		{"non-negative byte", 0x00, 0x7F, 1, func(first byte) int64 { return int64(first) }},
+		{"negative byte", 0x87, 0xFF, 1, func(first byte) int64 { return int64(first) - 0x100 }},
+		{"2-byte token", 0x80, 0x80, 3, func(byte) int64 { return -1<<16 + 0xA55A }},
+		{"3-byte token", 0x81, 0x81, 4, func(byte) int64 { return -1<<24 + 0xA55A01 }},
+		{"4-byte token", 0x82, 0x82, 5, func(byte) int64 { return -1<<32 + 0xA55A0102 }},
+		{"5-byte token", 0x83, 0x83, 6, func(byte) int64 { return -1<<40 + 0xA55A010203 }},
+		{"6-byte token", 0x84, 0x84, 7, func(byte) int64 { return -1<<48 + 0xA55A01020304 }},
+		{"7-byte token", 0x85, 0x85, 8, func(byte) int64 { return -1<<56 + 0xA55A0102030405 }},
+		{"8-byte token", 0x86, 0x86, 9, func(byte) int64 { return -1<<63 + 0x255A010203040506 }},
+	}
+	covered := make(map[byte]bool, 256)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for b := int(tt.first); b <= int(tt.last); b++ {
+				first := byte(b)
+				covered[first] = true
+				data := append([]byte{first}, compactPayload...)
+				want := tt.want(first)
+
+				value, width, ok := decodeCompactValue(data)
+				if !ok || value != want || width != tt.width {
+					t.Errorf("0x%02X: decoded %d from %d bytes (ok %v), want %d from %d", first, value, width, ok, want, tt.width)
+				}
+				if _, _, ok := decodeCompactValue(data[:tt.width-1]); ok {
+					t.Errorf("0x%02X: decoded from %d of its %d bytes", first, tt.width-1, tt.width)
+				}
+
+				r := &StatArchiveReader{reader: bufio.NewReader(bytes.NewReader(data))}
+				streamed, err := r.readCompactLong()
+				if err != nil || streamed != want {
+					t.Errorf("0x%02X: record reader read %d (%v), want %d", first, streamed, err, want)
+				}
+				if left := r.reader.Buffered(); left != len(data)-tt.width {
+					t.Errorf("0x%02X: record reader left %d bytes, want %d", first, left, len(data)-tt.width)
+				}
+				r = &StatArchiveReader{reader: bufio.NewReader(bytes.NewReader(data[:tt.width-1]))}
+				if _, err := r.readCompactLong(); err == nil {
+					t.Errorf("0x%02X: record reader read a value from %d of its %d bytes", first, tt.width-1, tt.width)
+				}
+			}
+		})
+	}
+	if len(covered) != 256 {
+		t.Errorf("the table covers %d of the 256 first bytes", len(covered))
+	}
+}
@@ -0,0 +1,104 @@
+package gfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotAnArchive is returned when a file does not start with a statistics
+// archive header
+var ErrNotAnArchive = errors.New("not a GFS statistics archive")
+
+// ErrCorruptHeader is returned when the fields of an archive header cannot
+// be decoded, such as a string longer than the reader allows
+var ErrCorruptHeader = errors.New("corrupt archive header")
+
+// ErrUnsupportedVersion is returned for an archive written in a format
+// version this reader does not understand
+type ErrUnsupportedVersion struct {
+	Found int
+}
+
+func (e *ErrUnsupportedVersion) Error() string {
+	return fmt.Sprintf("unsupported archive version %d (supported: %d-%d)", e.Found, MIN_ARCHIVE_VERSION, ARCHIVE_VERSION)
+}
+
+// ErrTruncated is returned when an archive ends in the middle of a record.
+// Offset is the start of the incomplete record.
+type ErrTruncated struct {
+	Offset int64
+}
+
+func (e *ErrTruncated) Error() string {
+	return fmt.Sprintf("%s: archive is truncated: incomplete record", offsetLabel(e.Offset))
+}
+
+// Unwrap lets errors.Is match io.ErrUnexpectedEOF
+func (e *ErrTruncated) Unwrap() error {
+	return io.ErrUnexpectedEOF
+}
+
+// ErrCorruptRecord is returned for a record that could not be decoded.
+// Offset is the start of the record and Token its record token; Err is the
+// decoding failure.
+type ErrCorruptRecord struct {
+	Offset int64
+	Token  byte
+	Err    error
+}
+
+func (e *ErrCorruptRecord) Error() string {
+	return fmt.Sprintf("%s: corrupt record (token %d): %v", offsetLabel(e.Offset), e.Token, e.Err)
+}
+
+func (e *ErrCorruptRecord) Unwrap() error {
+	return e.Err
+}
+
+// ErrLimitExceeded is returned when a length or count read from the archive
+// is above its ReaderLimits bound. What names the field, Value is what was
+// read and Limit the bound.
+type ErrLimitExceeded struct {
+	What  string
+	Value int64
+	Limit int64
+}
+
+func (e *ErrLimitExceeded) Error() string {
+	return fmt.Sprintf("%s %d exceeds the reader limit of %d", e.What, e.Value, e.Limit)
+}
+
+// IsUnreadable reports whether a parse error means nothing could be read
+// from the archive, as opposed to damage after a readable header
+func IsUnreadable(err error) bool {
+	var unsupported *ErrUnsupportedVersion
+	var truncated *ErrTruncated
+	return errors.Is(err, ErrNotAnArchive) ||
+		errors.Is(err, ErrCorruptHeader) ||
+		errors.As(err, &unsupported) ||
+		(errors.As(err, &truncated) && truncated.Offset == 0)
+}
+
+// IsIncomplete reports whether a parse error means the archive ended early,
+// as it does while a member is still writing it, so reading it again once
+// it has grown may succeed
+func IsIncomplete(err error) bool {
+	var truncated *ErrTruncated
+	return errors.As(err, &truncated)
+}
+
+// IsPermanent reports whether a parse error means the file will never be
+// read: it is not an archive, its version is not supported or its header
+// is corrupt. Reading it again fails the same way.
+func IsPermanent(err error) bool {
+	var unsupported *ErrUnsupportedVersion
+	return errors.Is(err, ErrNotAnArchive) ||
+		errors.Is(err, ErrCorruptHeader) ||
+		errors.As(err, &unsupported)
+}
+
+// isTruncation reports whether err was caused by running out of input
+func isTruncation(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,89 @@
+package gfs_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// duplicateType returns an archive that defines the same type twice,
+// whose second definition is a corrupt record on its own
+func duplicateType(t *testing.T) []byte {
+	t.Helper()
+	var archive bytes.Buffer
+	w, err := gfs.NewArchiveWriter(&archive, gfs.ArchiveHeader{StartTime: testStart, SystemStartTime: testStart})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	headerEnd := archive.Len()
+	if err := w.WriteResourceType(gfstest.SyntheticType(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	archive.Write(archive.Bytes()[headerEnd:])
+	return archive.Bytes()
+}
+
+// TestParseErrors checks that reading a damaged archive fails with the
+// type of error callers branch on, and that only an archive whose header
+// cannot be read is unreadable
+func TestParseErrors(t *testing.T) {
+	data := synthetic(t, testStart, gfs.ArchiveHeader{})
+	notAnArchive := func(t *testing.T, err error) {
+		if !errors.Is(err, gfs.ErrNotAnArchive) || !gfs.IsPermanent(err) || !gfs.IsUnreadable(err) || gfs.IsIncomplete(err) {
+			t.Errorf("want a permanent ErrNotAnArchive, got %v", err)
+		}
+	}
+	incompleteAt := func(header bool) func(t *testing.T, err error) {
+		return func(t *testing.T, err error) {
+			var truncated *gfs.ErrTruncated
+			if !errors.As(err, &truncated) || (truncated.Offset == 0) != header || gfs.IsUnreadable(err) != header || !gfs.IsIncomplete(err) || gfs.IsPermanent(err) {
+				t.Errorf("want an incomplete ErrTruncated (in the header: %t), got %v", header, err)
+			}
+		}
+	}
+	tests := []struct {
+		name   string
+		data   []byte
+		limits gfs.ReaderLimits
+		check  func(t *testing.T, err error)
+	}{
+		{name: "empty file", check: incompleteAt(true)},
+		{name: "text file", data: []byte("not an archive\n"), check: notAnArchive},
+		{name: "unsupported version", data: []byte{gfs.HEADER_TOKEN, 9}, check: func(t *testing.T, err error) {
+			var unsupported *gfs.ErrUnsupportedVersion
+			if !errors.As(err, &unsupported) || unsupported.Found != 9 || !gfs.IsPermanent(err) || !gfs.IsUnreadable(err) {
+				t.Errorf("want a permanent ErrUnsupportedVersion for version 9, got %v", err)
+			}
+		}},
+		{name: "cut in header", data: data[:10], check: incompleteAt(true)},
+		{name: "cut in gzip header", data: []byte{0x1f, 0x8b, 8}, check: incompleteAt(true)},
+		{name: "cut in record", data: data[:len(data)-3], check: incompleteAt(false)},
+		{name: "corrupt header", data: data, limits: gfs.ReaderLimits{MaxStringLength: 2}, check: func(t *testing.T, err error) {
+			var limit *gfs.ErrLimitExceeded
+			if !errors.Is(err, gfs.ErrCorruptHeader) || !errors.As(err, &limit) || !gfs.IsPermanent(err) || !gfs.IsUnreadable(err) {
+				t.Errorf("want a permanent ErrCorruptHeader over a string limit, got %v", err)
+			}
+		}},
+		{name: "duplicate type", data: duplicateType(t), check: func(t *testing.T, err error) {
+			var corrupt *gfs.ErrCorruptRecord
+			if !errors.As(err, &corrupt) || corrupt.Token != gfs.RESOURCE_TYPE_TOKEN || gfs.IsPermanent(err) || gfs.IsIncomplete(err) || gfs.IsUnreadable(err) {
+				t.Errorf("want an ErrCorruptRecord for the second type record, got %v", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readArchive(tt.data, func(reader *gfs.StatArchiveReader) { reader.SetLimits(tt.limits) })
+			tt.check(t, err)
+		})
+	}
+}
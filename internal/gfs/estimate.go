@@ -0,0 +1,358 @@
+package gfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+)
+
+const (
+	// estimateWindow is how many bytes of sample records are decoded in a
+	// row before the estimate skips ahead
+	estimateWindow = 4 << 10
+	// estimateBuffer is how far ahead the estimate looks for a record
+	// boundary, and how much it scans for metadata records at a time
+	estimateBuffer = 256 << 10
+	// estimateOverlap is the longest metadata record that is still found
+	// when it straddles two scanned chunks
+	estimateOverlap = 16 << 10
+	// probeRecords is how many sample records must decode cleanly from an
+	// offset for it to be taken as a record boundary
+	probeRecords = 4
+)
+
+// Estimate is what decoding part of an archive's sample records says about
+// the whole archive. The reader's types and instances are those read from
+// the metadata records, including the ones found in skipped stretches.
+type Estimate struct {
+	// SampleBytes is the size of the sample section, from the first
+	// sample record to the end of the archive, and DecodedBytes how much
+	// of it was decoded
+	SampleBytes  int64
+	DecodedBytes int64
+	// SampleRecords counts the sample records decoded
+	SampleRecords int
+	// Values counts the values decoded per instance and stat name
+	Values map[*ResourceInstance]map[string]int
+	// Resyncs counts the times decoding resumed after skipping ahead
+	Resyncs int
+}
+
+// Fraction returns the share of the sample section that was decoded
+func (e *Estimate) Fraction() float64 {
+	if e.SampleBytes <= 0 || e.DecodedBytes >= e.SampleBytes {
+		return 1
+	}
+	return float64(e.DecodedBytes) / float64(e.SampleBytes)
+}
+
+// Scale returns the factor that extrapolates decoded counts to the whole
+// archive
+func (e *Estimate) Scale() float64 {
+	if f := e.Fraction(); f > 0 {
+		return 1 / f
+	}
+	return 1
+}
+
+// estimateState is how far an Estimate has got
+type estimateState struct {
+	estimate *Estimate
+	// gap is how many bytes are skipped after each window
+	gap int64
+	// windowStart is the offset the current window was entered at
+	windowStart int64
+	// probe decodes candidate records from peeked bytes
+	probe      *StatArchiveReader
+	probeInput bytes.Reader
+	// probeValues counts the values the probe decoded
+	probeValues int
+}
+
+// EstimateArchive reads the archive's metadata and decodes about fraction
+// of its sample records, in windows spread evenly over the file, instead of
+// all of them. Between windows the bytes are only scanned for type and
+// instance records; decoding resumes at the first offset from which
+// several sample records decode cleanly. Archives smaller than a window
+// per fraction are read whole. Values are counted, not kept.
+func (r *StatArchiveReader) EstimateArchive(fraction float64) (*Estimate, error) {
+	if fraction <= 0 || fraction > 1 {
+		return nil, fmt.Errorf("invalid fraction %g (expected more than 0, at most 1)", fraction)
+	}
+	estimate := &Estimate{Values: make(map[*ResourceInstance]map[string]int)}
+	r.estimate = &estimateState{estimate: estimate}
+	if r.size == 0 || float64(r.size) > estimateWindow/fraction {
+		r.estimate.gap = int64(estimateWindow/fraction) - estimateWindow
+	}
+	defer func() { r.estimate = nil }()
+
+	err := r.ReadArchiveStream(func(instance *ResourceInstance, stat *StatDescriptor, _ time.Time, _ float64) error {
+		values := estimate.Values[instance]
+		if values == nil {
+			values = make(map[string]int)
+			estimate.Values[instance] = values
+		}
+		values[stat.Name]++
+		return nil
+	})
+	if r.metadataEnd > 0 {
+		estimate.SampleBytes = r.report.BytesRead - r.metadataEnd
+		r.estimate.startWindow(r)
+		r.estimate.endWindow(r)
+	}
+	return estimate, err
+}
+
+// countSample counts a decoded sample record
+func (s *estimateState) countSample() {
+	s.estimate.SampleRecords++
+}
+
+// startWindow enters the first window at the first sample record
+func (s *estimateState) startWindow(r *StatArchiveReader) {
+	if s.windowStart == 0 {
+		s.windowStart = r.metadataEnd
+	}
+}
+
+// endWindow adds the bytes decoded since the current window was entered
+// to the estimate
+func (s *estimateState) endWindow(r *StatArchiveReader) {
+	s.estimate.DecodedBytes += r.Offset() - s.windowStart
+}
+
+// next is called before each record once samples have started. When the
+// current window is full it skips ahead and moves to the next record
+// boundary, reporting false if the archive ended first.
+func (s *estimateState) next(r *StatArchiveReader) (bool, error) {
+	s.startWindow(r)
+	if s.gap <= 0 || r.Offset()-s.windowStart < estimateWindow {
+		return true, nil
+	}
+	s.endWindow(r)
+	if err := s.skip(r, s.gap); err != nil {
+		return false, err
+	}
+	s.windowStart = r.Offset()
+	return s.resync(r)
+}
+
+// skip discards n bytes, adding the type and instance records found in
+// them to the reader
+func (s *estimateState) skip(r *StatArchiveReader, n int64) error {
+	for n > 0 {
+		data, err := r.reader.Peek(estimateBuffer)
+		if len(data) == 0 {
+			if isTruncation(err) {
+				return nil
+			}
+			return err
+		}
+		step := len(data)
+		if err == nil {
+			// Records starting in the overlap are scanned with the
+			// next chunk
+			step -= estimateOverlap
+		}
+		if int64(step) > n {
+			step = int(n)
+		}
+		s.scanMetadata(r, data, step)
+		if _, err := r.reader.Discard(step); err != nil {
+			return err
+		}
+		n -= int64(step)
+	}
+	return nil
+}
+
+// resync discards bytes up to the next offset from which probeRecords
+// sample records decode cleanly, reporting false if the archive ended
+// first
+func (s *estimateState) resync(r *StatArchiveReader) (bool, error) {
+	s.endWindow(r)
+	// Bytes discarded from here on are not decoded, whether or not a
+	// boundary is found
+	defer func() { s.windowStart = r.Offset() }()
+	for {
+		data, err := r.reader.Peek(estimateBuffer)
+		if len(data) == 0 {
+			if isTruncation(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		atEOF := err != nil
+		for i := 2; i < len(data); i++ {
+			// A sample record with values ends with the terminator of
+			// its last block and then of its blocks, both all ones, so
+			// only the offsets after two such bytes are probed
+			if data[i-1] != ILLEGAL_RESOURCE_INST_ID_TOKEN || data[i-2] != 0xFF {
+				continue
+			}
+			if s.probeAt(r, data[i:], atEOF) {
+				s.estimate.Resyncs++
+				s.scanMetadata(r, data, i)
+				if _, err := r.reader.Discard(i); err != nil {
+					return false, err
+				}
+				return true, nil
+			}
+		}
+		if atEOF {
+			s.scanMetadata(r, data, len(data))
+			_, err := r.reader.Discard(len(data))
+			return false, err
+		}
+		// The start of a record may be right at the end of the chunk
+		step := len(data) - estimateOverlap
+		s.scanMetadata(r, data, step)
+		if _, err := r.reader.Discard(step); err != nil {
+			return false, err
+		}
+	}
+}
+
+// probeReader returns a reader that decodes data with the types and
+// instances of r, without changing them or keeping any value
+func (s *estimateState) probeReader(r *StatArchiveReader, data []byte) *StatArchiveReader {
+	if s.probe == nil {
+		s.probe = &StatArchiveReader{
+			byteOrder: r.byteOrder,
+			logger:    logging.Discard,
+			limits:    r.limits,
+			counter:   &countingReader{r: &s.probeInput},
+		}
+		s.probe.sampleFunc = func(*ResourceInstance, *StatDescriptor, time.Time, float64) error {
+			s.probeValues++
+			return nil
+		}
+		s.probe.reader = bufio.NewReaderSize(s.probe.counter, 512)
+	}
+	p := s.probe
+	s.probeInput.Reset(data)
+	p.counter.n = 0
+	p.reader.Reset(p.counter)
+	p.resourceTypes = r.resourceTypes
+	p.instances = r.instances
+	p.report = ParseReport{}
+	p.currentTimeStamp = r.currentTimeStamp
+	p.disabledStart = 0
+	p.samplingDisabled = nil
+	p.retiredInstances = nil
+	s.probeValues = 0
+	return p
+}
+
+// probeAt reports whether data starts with probeRecords sample records that
+// decode cleanly, or with fewer that end exactly at the end of the archive
+func (s *estimateState) probeAt(r *StatArchiveReader, data []byte, atEOF bool) bool {
+	switch data[0] {
+	case RESOURCE_TYPE_TOKEN, RESOURCE_INSTANCE_CREATE_TOKEN, RESOURCE_INSTANCE_DELETE_TOKEN, RESOURCE_INSTANCE_INITIALIZE_TOKEN:
+		return false
+	}
+	p := s.probeReader(r, data)
+	for n := 0; n < probeRecords; n++ {
+		token, err := p.reader.ReadByte()
+		if err != nil {
+			return atEOF && n > 0 && s.probeValues > 0
+		}
+		if err := p.updateTimeStamp(token); err != nil {
+			return false
+		}
+		before := len(p.report.Warnings)
+		if err := p.readSampleData(); err != nil || p.report.WarningCount > 0 || len(p.report.Warnings) != before {
+			return false
+		}
+	}
+	return s.probeValues > 0
+}
+
+// scanMetadata looks for type and instance records starting in the first
+// limit bytes of data and adds the plausible ones to the reader
+func (s *estimateState) scanMetadata(r *StatArchiveReader, data []byte, limit int) {
+	for i := 0; i < limit; i++ {
+		// Both records start with an int id and a name, which rules out
+		// most offsets before decoding anything
+		switch data[i] {
+		case RESOURCE_TYPE_TOKEN:
+			if nameFollows(r, data[i+1:]) {
+				s.scanType(r, data[i+1:])
+			}
+		case RESOURCE_INSTANCE_CREATE_TOKEN, RESOURCE_INSTANCE_INITIALIZE_TOKEN:
+			if nameFollows(r, data[i+1:]) {
+				s.scanInstance(r, data[i+1:], data[i] == RESOURCE_INSTANCE_INITIALIZE_TOKEN)
+			}
+		}
+	}
+}
+
+// nameFollows reports whether data starts with an int id followed by a
+// string that is a plausible name
+func nameFollows(r *StatArchiveReader, data []byte) bool {
+	if len(data) < 6 {
+		return false
+	}
+	n := int(r.byteOrder.Uint16(data[4:]))
+	return n <= len(data)-6 && printable(data[6:6+n])
+}
+
+// scanType adds the type record data may start with, if it decodes to a
+// type with a printable name and plausible stats that is not known yet
+func (s *estimateState) scanType(r *StatArchiveReader, data []byte) {
+	p := s.probeReader(r, data)
+	p.resourceTypes = make(map[int32]*ResourceType, 1)
+	if err := p.readResourceType(); err != nil || p.report.WarningCount > 0 {
+		return
+	}
+	for id, resType := range p.resourceTypes {
+		if _, known := r.resourceTypes[id]; known || id < 0 || !printable(resType.Name) || len(resType.Stats) == 0 {
+			return
+		}
+		for _, stat := range resType.Stats {
+			if !printable(stat.Name) {
+				return
+			}
+		}
+		r.resourceTypes[id] = resType
+	}
+}
+
+// scanInstance adds the instance record data may start with, if it decodes
+// to a printable name of a known type
+func (s *estimateState) scanInstance(r *StatArchiveReader, data []byte, initialize bool) {
+	p := s.probeReader(r, data)
+	p.instances = make(map[int32]*ResourceInstance, 1)
+	p.instanceEvents = nil
+	if err := p.readResourceInstanceCreate(initialize); err != nil || p.report.WarningCount > 0 {
+		return
+	}
+	for id, instance := range p.instances {
+		if _, known := r.resourceTypes[instance.TypeID]; !known || id < 0 || !printable(instance.Name) {
+			return
+		}
+		if previous, ok := r.instances[id]; ok && previous.Name == instance.Name && previous.TypeID == instance.TypeID {
+			return
+		}
+		instance.Segment = r.segment
+		instance.Stats = make(map[int32][]StatValue)
+		r.instances[id] = instance
+	}
+}
+
+// printable reports whether s is a plausible name: not empty, not overly
+// long and printable ASCII throughout
+func printable[T string | []byte](s T) bool {
+	if len(s) == 0 || len(s) > 200 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 32 || s[i] > 126 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,243 @@
+// Package gfstest writes statistics archives for tests and the self test:
+// the synthetic archive, which uses every stat encoding, and archives of a
+// single instance whose stats and samples the caller lays out.
+package gfstest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+)
+
+// Options sizes the synthetic archive: Types resource types with
+// Instances instances each, sampled Samples times
+type Options struct {
+	Types     int
+	Instances int
+	Samples   int
+}
+
+// SampleInterval is the time between synthetic samples
+const SampleInterval = time.Second
+
+// StatTypes are the stats of every synthetic type, one of each encoding
+var StatTypes = []struct {
+	Name      string
+	Type      gfs.StatType
+	IsCounter bool
+}{
+	{"operations", gfs.StatTypeLong, true},
+	{"entries", gfs.StatTypeInt, false},
+	{"loadAverage", gfs.StatTypeDouble, false},
+	{"ratio", gfs.StatTypeFloat, false},
+	{"threads", gfs.StatTypeShort, false},
+	{"online", gfs.StatTypeBoolean, false},
+}
+
+// TypeName is the name of synthetic type t
+func TypeName(t int) string {
+	return fmt.Sprintf("SelfTestStats%d", t)
+}
+
+// InstanceName is the name of instance i of synthetic type t
+func InstanceName(t, i int) string {
+	return fmt.Sprintf("selftest-%d-%d", t, i)
+}
+
+// InstanceID is the id of instance i of synthetic type t
+func InstanceID(t, i int, opts Options) int32 {
+	return int32(t*opts.Instances + i)
+}
+
+// Value returns the value of a stat of an instance at sample k. Counters
+// grow; the rest vary within what their encoding holds exactly.
+func Value(stat, id int32, k int) float64 {
+	switch StatTypes[stat].Type {
+	case gfs.StatTypeLong:
+		return float64(int64(k)*int64(k)*1000 + int64(id))
+	case gfs.StatTypeInt:
+		return float64((k*37+int(id))%100000 - 50000)
+	case gfs.StatTypeDouble:
+		return float64(k) + float64(id)/8
+	case gfs.StatTypeFloat:
+		return float64(k%16) / 4
+	case gfs.StatTypeShort:
+		return float64(k%300 + int(id))
+	default:
+		return float64((k + int(id)) % 2)
+	}
+}
+
+// SyntheticType returns synthetic type t with every stat of StatTypes
+func SyntheticType(t int) *gfs.ResourceType {
+	resType := &gfs.ResourceType{ID: int32(t), Name: TypeName(t), Description: "Synthetic statistics"}
+	for _, s := range StatTypes {
+		resType.Stats = append(resType.Stats, gfs.StatDescriptor{Name: s.Name, Type: s.Type, IsCounter: s.IsCounter, LargerBetter: s.IsCounter, Unit: "units"})
+	}
+	return resType
+}
+
+// Write writes the synthetic archive starting at start to out, in the
+// byte order and archive version of layout, big-endian and the latest
+// version unless they are set. Sample k is taken k+1 intervals after start.
+func Write(out io.Writer, start time.Time, opts Options, layout gfs.ArchiveHeader) error {
+	w, err := gfs.NewArchiveWriter(out, gfs.ArchiveHeader{
+		StartTime:          start,
+		SystemStartTime:    start,
+		TimeZoneName:       "UTC",
+		ProductDescription: "gfs-to-prometheus selftest",
+		ByteOrder:          layout.ByteOrder,
+		Version:            layout.Version,
+	})
+	if err != nil {
+		return err
+	}
+
+	for t := 0; t < opts.Types; t++ {
+		if err := w.WriteResourceType(SyntheticType(t)); err != nil {
+			return err
+		}
+		for i := 0; i < opts.Instances; i++ {
+			if err := w.CreateInstance(InstanceID(t, i, opts), InstanceName(t, i), int64(i), int32(t)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for k := 0; k < opts.Samples; k++ {
+		var samples []gfs.InstanceSample
+		for t := 0; t < opts.Types; t++ {
+			for i := 0; i < opts.Instances; i++ {
+				id := InstanceID(t, i, opts)
+				values := make(map[int]float64, len(StatTypes))
+				for stat := range StatTypes {
+					values[stat] = Value(int32(stat), id, k)
+				}
+				samples = append(samples, gfs.InstanceSample{InstanceID: id, Values: values})
+			}
+		}
+		if err := w.WriteSample(start.Add(time.Duration(k+1)*SampleInterval), samples); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// WriteFile writes the synthetic archive starting at start to path
+func WriteFile(path string, start time.Time, opts Options) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = Write(file, start, opts, gfs.ArchiveHeader{})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Verify reads an archive holding the synthetic archive and compares
+// every value and timestamp with what was written, returning the number
+// of values compared
+func Verify(path string, start time.Time, opts Options) (int, *gfs.ParseReport, error) {
+	reader, err := gfs.NewStatArchiveReader(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer reader.Close()
+	reader.SetLogger(logging.Discard)
+
+	if err := reader.ReadArchive(); err != nil {
+		return 0, nil, err
+	}
+	report := reader.GetParseReport()
+	if !report.Clean() {
+		return 0, nil, fmt.Errorf("archive was not read cleanly: %s", report)
+	}
+
+	types := reader.GetResourceTypes()
+	instances := reader.GetInstances()
+	if len(types) != opts.Types || len(instances) != opts.Types*opts.Instances {
+		return 0, nil, fmt.Errorf("read %d types and %d instances, wrote %d and %d", len(types), len(instances), opts.Types, opts.Types*opts.Instances)
+	}
+
+	values := 0
+	for id, instance := range instances {
+		for stat := range StatTypes {
+			samples := instance.Stats[int32(stat)]
+			if len(samples) != opts.Samples {
+				return 0, nil, fmt.Errorf("%s.%s has %d samples, wrote %d", instance.Name, StatTypes[stat].Name, len(samples), opts.Samples)
+			}
+			for k, sample := range samples {
+				want := Value(int32(stat), id, k)
+				if sample.Value != want {
+					return 0, nil, fmt.Errorf("%s.%s sample %d is %v, wrote %v", instance.Name, StatTypes[stat].Name, k, sample.Value, want)
+				}
+				if wantTime := start.Add(time.Duration(k+1) * SampleInterval); !sample.Time().Equal(wantTime) {
+					return 0, nil, fmt.Errorf("%s.%s sample %d is at %s, wrote %s", instance.Name, StatTypes[stat].Name, k,
+						sample.Time().Format(time.RFC3339Nano), wantTime.Format(time.RFC3339Nano))
+				}
+				values++
+			}
+		}
+	}
+	return values, report, nil
+}
+
+// Instance is an archive of a single instance, InstanceName(0, 0) of
+// TypeName(0) with id 0, whose stats are sampled together
+type Instance struct {
+	Start time.Time
+	Stats []gfs.StatDescriptor
+	// Seconds are the times of the samples in seconds after Start, one a
+	// second from a second after it unless set
+	Seconds []int
+	// Values holds the values of each stat of Stats, one per sample
+	Values [][]float64
+}
+
+// Write writes the archive to out
+func (a Instance) Write(out io.Writer) error {
+	w, err := gfs.NewArchiveWriter(out, gfs.ArchiveHeader{StartTime: a.Start, SystemStartTime: a.Start})
+	if err != nil {
+		return err
+	}
+	if err := w.WriteResourceType(&gfs.ResourceType{Name: TypeName(0), Stats: a.Stats}); err != nil {
+		return err
+	}
+	if err := w.CreateInstance(0, InstanceName(0, 0), 0, 0); err != nil {
+		return err
+	}
+	for k := range a.Values[0] {
+		second := k + 1
+		if a.Seconds != nil {
+			second = a.Seconds[k]
+		}
+		values := make(map[int]float64, len(a.Values))
+		for stat := range a.Values {
+			values[stat] = a.Values[stat][k]
+		}
+		sample := gfs.InstanceSample{InstanceID: 0, Values: values}
+		if err := w.WriteSample(a.Start.Add(time.Duration(second)*time.Second), []gfs.InstanceSample{sample}); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// WriteFile writes the archive to path
+func (a Instance) WriteFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = a.Write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
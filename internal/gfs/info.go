@@ -0,0 +1,87 @@
+package gfs
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// ArchiveInfo holds the fields of an archive header. Times are the epoch
+// milliseconds as written, whatever the timezone mode.
+type ArchiveInfo struct {
+	Version            int             `json:"version"`
+	StartTime          time.Time       `json:"start_time"`
+	SystemID           int64           `json:"system_id"`
+	SystemStartTime    time.Time       `json:"system_start_time"`
+	TimeZone           ArchiveTimeZone `json:"time_zone"`
+	SystemDirectory    string          `json:"system_directory"`
+	ProductDescription string          `json:"product_description"`
+	OSInfo             string          `json:"os_info"`
+	MachineInfo        string          `json:"machine_info"`
+	// ByteOrder is the order the archive's multi-byte fields were read
+	// in, big-endian unless the header only makes sense the other way
+	ByteOrder string `json:"byte_order"`
+}
+
+// ArchiveTimeZone is the member's timezone as recorded in the header
+type ArchiveTimeZone struct {
+	Name   string
+	Offset time.Duration
+}
+
+// MarshalJSON writes the offset as +hh:mm rather than in nanoseconds
+func (z ArchiveTimeZone) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name   string `json:"name"`
+		Offset string `json:"offset"`
+	}{z.Name, FormatOffset(z.Offset)})
+}
+
+// Map returns the header fields keyed the way GetArchiveInfo returned them
+// before ArchiveInfo was introduced.
+//
+// Deprecated: use the fields of ArchiveInfo. Map will be removed in the
+// next release.
+func (i ArchiveInfo) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"version":            i.Version,
+		"startTimeStamp":     i.StartTime.UnixMilli(),
+		"systemId":           i.SystemID,
+		"systemStartTime":    i.SystemStartTime.UnixMilli(),
+		"timeZoneOffset":     int32(i.TimeZone.Offset.Milliseconds()),
+		"timeZoneName":       i.TimeZone.Name,
+		"systemDirectory":    i.SystemDirectory,
+		"productDescription": i.ProductDescription,
+		"osInfo":             i.OSInfo,
+		"machineInfo":        i.MachineInfo,
+	}
+}
+
+// Hostname returns the host name of the member, which Geode writes in
+// MachineInfo after the architecture, as in "amd64 server1.example.com",
+// or "" if MachineInfo holds none
+func (i ArchiveInfo) Hostname() string {
+	fields := strings.Fields(i.MachineInfo)
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[len(fields)-1]
+}
+
+// GetArchiveInfo returns the header of the archive. When archives were
+// appended to the file it describes the last one read.
+func (r *StatArchiveReader) GetArchiveInfo() ArchiveInfo {
+	name, offset := r.TimeZone()
+	return ArchiveInfo{
+		Version:            r.archiveVersion,
+		StartTime:          time.UnixMilli(r.startTimeStamp).UTC(),
+		SystemID:           r.systemId,
+		SystemStartTime:    time.UnixMilli(r.systemStartTime).UTC(),
+		TimeZone:           ArchiveTimeZone{Name: name, Offset: offset},
+		SystemDirectory:    r.systemDirectory,
+		ProductDescription: r.productDescription,
+		OSInfo:             r.osInfo,
+		MachineInfo:        r.machineInfo,
+		ByteOrder:          ByteOrderName(r.byteOrder),
+	}
+}
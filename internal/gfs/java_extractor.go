@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"time"
 )
 
@@ -25,15 +24,23 @@ type JavaInstance struct {
 }
 
 type JavaSample struct {
-	StatID    int32 `json:"statId"`
-	Timestamp int64 `json:"timestamp"` // milliseconds since epoch
+	StatID    int32       `json:"statId"`
+	Timestamp int64       `json:"timestamp"` // milliseconds since epoch
 	Value     interface{} `json:"value"`
 }
 
+// JavaAvailable reports whether java can be run to extract archives
+func JavaAvailable() bool {
+	_, err := exec.LookPath("java")
+	return err == nil
+}
+
 // JavaStatArchiveReader uses Java libraries to parse GFS files correctly
 type JavaStatArchiveReader struct {
 	filename string
 	data     *JavaExtractedData
+	// skippedValues counts the samples whose value is not a number
+	skippedValues int
 }
 
 func NewJavaStatArchiveReader(filename string) (*JavaStatArchiveReader, error) {
@@ -47,41 +54,62 @@ func (r *JavaStatArchiveReader) ReadArchive() error {
 	if err := r.buildJavaExtractor(); err != nil {
 		return fmt.Errorf("failed to build Java extractor: %w", err)
 	}
-	
-	// Create temporary output file
-	outputFile := filepath.Join(os.TempDir(), "gfs_extracted.json")
+
+	// Create temporary output file, one per archive so archives can be
+	// extracted concurrently
+	output, err := os.CreateTemp("", "gfs_extracted-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create extractor output: %w", err)
+	}
+	outputFile := output.Name()
+	output.Close()
 	defer os.Remove(outputFile)
-	
+
 	// Run Java extractor with proper classpath
-	cmd := exec.Command("java", "-cp", "java-extractor/lib/*:java-extractor/build/stat-extractor.jar", 
-						"StatExtractor", r.filename, outputFile)
-	output, err := cmd.CombinedOutput()
+	cmd := exec.Command("java", "-cp", "java-extractor/lib/*:java-extractor/build/stat-extractor.jar",
+		"StatExtractor", r.filename, outputFile)
+	out, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("Java extractor failed: %w\nOutput: %s", err, string(output))
+		return fmt.Errorf("Java extractor failed: %w\nOutput: %s", err, string(out))
 	}
-	
+
 	// Read extracted data
 	jsonData, err := os.ReadFile(outputFile)
 	if err != nil {
 		return fmt.Errorf("failed to read extracted data: %w", err)
 	}
-	
+
 	// Parse JSON
 	r.data = &JavaExtractedData{}
 	if err := json.Unmarshal(jsonData, r.data); err != nil {
 		return fmt.Errorf("failed to parse extracted data: %w", err)
 	}
-	
+
+	r.skippedValues = 0
+	for _, instance := range r.data.Instances {
+		for _, sample := range instance.Samples {
+			if _, ok := float64Value(sample.Value); !ok {
+				r.skippedValues++
+			}
+		}
+	}
+
 	return nil
 }
 
+// SkippedValues returns the number of samples left out of GetInstances
+// because the extractor gave a value that is not a number, such as null
+func (r *JavaStatArchiveReader) SkippedValues() int {
+	return r.skippedValues
+}
+
 func (r *JavaStatArchiveReader) buildJavaExtractor() error {
 	// Check if JAR already exists
 	jarPath := "java-extractor/build/stat-extractor.jar"
 	if _, err := os.Stat(jarPath); err == nil {
 		return nil // Already built
 	}
-	
+
 	// Build with our custom build script
 	cmd := exec.Command("./build.sh")
 	cmd.Dir = "java-extractor"
@@ -89,7 +117,7 @@ func (r *JavaStatArchiveReader) buildJavaExtractor() error {
 	if err != nil {
 		return fmt.Errorf("Java build failed: %w\nOutput: %s", err, string(output))
 	}
-	
+
 	return nil
 }
 
@@ -97,7 +125,7 @@ func (r *JavaStatArchiveReader) GetResourceTypes() map[int32]*ResourceType {
 	if r.data == nil {
 		return make(map[int32]*ResourceType)
 	}
-	
+
 	types := make(map[int32]*ResourceType)
 	for _, resType := range r.data.ResourceTypes {
 		types[resType.ID] = &resType
@@ -109,7 +137,7 @@ func (r *JavaStatArchiveReader) GetInstances() map[int32]*ResourceInstance {
 	if r.data == nil {
 		return make(map[int32]*ResourceInstance)
 	}
-	
+
 	instances := make(map[int32]*ResourceInstance)
 	for _, javaInstance := range r.data.Instances {
 		instance := &ResourceInstance{
@@ -119,36 +147,102 @@ func (r *JavaStatArchiveReader) GetInstances() map[int32]*ResourceInstance {
 			CreationTime: time.Unix(0, r.data.ArchiveStartTime*int64(time.Millisecond)),
 			Stats:        make(map[int32][]StatValue),
 		}
-		
+
 		// Convert samples to StatValue format
 		for _, sample := range javaInstance.Samples {
-			timestamp := time.Unix(0, sample.Timestamp*int64(time.Millisecond))
+			value, ok := float64Value(sample.Value)
+			if !ok {
+				continue
+			}
 			statValue := StatValue{
-				Timestamp: timestamp,
-				Value:     sample.Value,
+				Timestamp: sample.Timestamp,
+				Value:     value,
 			}
-			
+
 			instance.Stats[sample.StatID] = append(instance.Stats[sample.StatID], statValue)
 		}
-		
+
 		instances[javaInstance.ID] = instance
 	}
-	
+
 	return instances
 }
 
-func (r *JavaStatArchiveReader) GetArchiveInfo() map[string]interface{} {
+// GetArchiveInfo returns the start time, the only header field the Java
+// extractor reports
+func (r *JavaStatArchiveReader) GetArchiveInfo() ArchiveInfo {
+	if r.data == nil {
+		return ArchiveInfo{}
+	}
+
+	return ArchiveInfo{StartTime: time.UnixMilli(r.data.ArchiveStartTime).UTC()}
+}
+
+// GetSamplingGaps is not supported by the Java extractor
+func (r *JavaStatArchiveReader) GetSamplingGaps() []SamplingGap {
+	return nil
+}
+
+// GetSamplingDisabled is not supported by the Java extractor
+func (r *JavaStatArchiveReader) GetSamplingDisabled() []SamplingGap {
+	return nil
+}
+
+// GetInstanceEvents returns nil: the Java extractor does not report
+// instance deletions
+func (r *JavaStatArchiveReader) GetInstanceEvents() []InstanceEvent {
+	return nil
+}
+
+// GetLastSampleTime returns the time of the latest sample extracted, zero
+// if there is none
+func (r *JavaStatArchiveReader) GetLastSampleTime() time.Time {
 	if r.data == nil {
-		return make(map[string]interface{})
+		return time.Time{}
+	}
+	var last int64
+	found := false
+	for _, instance := range r.data.Instances {
+		for _, sample := range instance.Samples {
+			if !found || sample.Timestamp > last {
+				last, found = sample.Timestamp, true
+			}
+		}
 	}
-	
-	return map[string]interface{}{
-		"startTimeStamp": r.data.ArchiveStartTime,
-		"totalSamples":   r.data.TotalSamples,
+	if !found {
+		return time.Time{}
 	}
+	return time.UnixMilli(last).UTC()
+}
+
+// TimeZone returns UTC, since the extractor reports no time zone
+func (r *JavaStatArchiveReader) TimeZone() (string, time.Duration) {
+	return "UTC", 0
 }
 
 func (r *JavaStatArchiveReader) Close() error {
 	// Nothing to close for Java extractor approach
 	return nil
-}
\ No newline at end of file
+}
+
+// float64Value converts a sample value decoded from JSON to a float64,
+// reporting false for a value of any other type rather than taking it as 0
+func float64Value(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
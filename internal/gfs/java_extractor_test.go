@@ -0,0 +1,37 @@
+package gfs
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestFloat64Value checks which sample values the Java extractor gives are
+// taken as numbers, and that any other is reported rather than read as 0
+func TestFloat64Value(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  float64
+		ok    bool
+	}{
+		{"nil", nil, 0, false},
+		{"double", 2.5, 2.5, true},
+		{"int", int(-7), -7, true},
+		{"int32", int32(1 << 30), 1 << 30, true},
+		{"int64", int64(1 << 40), 1 << 40, true},
+		{"uint64", uint64(1 << 63), 1 << 63, true},
+		{"number", json.Number("12.75"), 12.75, true},
+		{"malformed number", json.Number("twelve"), 0, false},
+		{"string", "12", 0, false},
+		{"boolean", true, 0, false},
+		{"object", map[string]interface{}{"value": 1.0}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := float64Value(tt.value)
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("float64Value(%#v) = %v, %t, want %v, %t", tt.value, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package gfs
+
+// Layout is where the structural fields of an archive are, collected while
+// it is read with TraceLayout enabled. Tools that build damaged variants of
+// an archive use it to damage the fields the reader depends on.
+type Layout struct {
+	// HeaderEnd is the offset of the first record
+	HeaderEnd int64
+	// Records are the start of every record, in file order
+	Records []RecordSpan
+	// Lengths are the offsets of the 2-byte string lengths and of the
+	// stat counts of resource types
+	Lengths []int64
+	// TypeIDs are the offsets of the 4-byte ids of resource type records
+	TypeIDs []int64
+	// StatOffsets are the offsets of the stat offset bytes of sample
+	// records, including the end of block markers
+	StatOffsets []int64
+}
+
+// RecordSpan is the start of one record
+type RecordSpan struct {
+	Offset int64
+	Token  byte
+}
+
+// TraceLayout makes ReadArchive collect the layout of the archive. It
+// costs a seek per field, so it is meant for diagnostics only.
+func (r *StatArchiveReader) TraceLayout() {
+	r.layout = &Layout{}
+}
+
+// GetLayout returns the layout collected by ReadArchive, or nil if
+// TraceLayout was not called
+func (r *StatArchiveReader) GetLayout() *Layout {
+	return r.layout
+}
+
+// traceField appends the current offset to one of the layout's field lists
+func (r *StatArchiveReader) traceField(field func(*Layout) *[]int64) {
+	if r.layout == nil {
+		return
+	}
+	offsets := field(r.layout)
+	*offsets = append(*offsets, r.Offset())
+}
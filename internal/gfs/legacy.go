@@ -5,70 +5,50 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"strings"
 	"time"
-)
 
-// Geode statistics archive constants based on StatArchiveWriter.java
-const (
-	// Tokens
-	HEADER_TOKEN                     = 77
-	SAMPLE_TOKEN                     = 0
-	RESOURCE_TYPE_TOKEN              = 1
-	RESOURCE_INSTANCE_CREATE_TOKEN   = 2
-	RESOURCE_INSTANCE_DELETE_TOKEN   = 3
-	RESOURCE_INSTANCE_INITIALIZE_TOKEN = 4
-	
-	// Compact value tokens moved to statarchive.go for correct Apache Geode values
-	
-	// Resource ID tokens
-	SHORT_RESOURCE_INST_ID_TOKEN = 253
-	INT_RESOURCE_INST_ID_TOKEN   = 254
-	ILLEGAL_RESOURCE_INST_ID_TOKEN = 255
-	
-	// Timestamp tokens
-	INT_TIMESTAMP_TOKEN = 65535
-	
-	// Type codes
-	BOOLEAN_CODE = 1
-	CHAR_CODE    = 2
-	BYTE_CODE    = 3
-	SHORT_CODE   = 4
-	INT_CODE     = 5
-	LONG_CODE    = 6
-	FLOAT_CODE   = 7
-	DOUBLE_CODE  = 8
-	WCHAR_CODE   = 12
-	
-	// Archive version
-	ARCHIVE_VERSION = 4
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+	"github.com/4n3w/gfs-to-prometheus/internal/throttle"
 )
 
+// Parser is the legacy archive parser, kept only for --legacy-parser. It
+// skips the header by a fixed length and does not read stat descriptors,
+// recording a single "value" stat per resource type instead;
+// StatArchiveReader is the implementation to use.
+type Parser struct {
+	file      *os.File
+	reader    io.Reader
+	types     map[int32]*ResourceType
+	instances map[int32]*ResourceInstance
+}
+
+// GeodeParser holds the state of a legacy Parser while it reads records
 type GeodeParser struct {
-	file         *os.File
-	reader       *bufio.Reader
-	byteOrder    binary.ByteOrder
-	
+	file      *os.File
+	reader    *bufio.Reader
+	byteOrder binary.ByteOrder
+
 	// Header information
-	version       int
-	startTime     int64
-	systemID      int64
-	systemStart   int64
+	version        int
+	startTime      int64
+	systemID       int64
+	systemStart    int64
 	timeZoneOffset int32
-	timeZoneName  string
-	systemDir     string
-	productDesc   string
-	osInfo        string
-	machineInfo   string
-	
+	timeZoneName   string
+	systemDir      string
+	productDesc    string
+	osInfo         string
+	machineInfo    string
+
 	// Current state
 	currentTime   int64
 	resourceTypes map[int]*ResourceType
 	instances     map[int]*ResourceInstance
 }
 
+// NewGeodeParser opens an archive for the legacy parser
 func NewGeodeParser(filename string) (*Parser, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -79,7 +59,6 @@ func NewGeodeParser(filename string) (*Parser, error) {
 	p := &Parser{
 		file:      file,
 		reader:    file,
-		byteOrder: binary.BigEndian,
 		types:     make(map[int32]*ResourceType),
 		instances: make(map[int32]*ResourceInstance),
 	}
@@ -87,34 +66,102 @@ func NewGeodeParser(filename string) (*Parser, error) {
 	return p, nil
 }
 
+func (p *Parser) Close() error {
+	return p.file.Close()
+}
+
+// ReadArchive parses the archive, so the legacy parser can stand in for a
+// StatArchiveReader
+func (p *Parser) ReadArchive() error {
+	return p.ParseGeode()
+}
+
+func (p *Parser) GetInstances() map[int32]*ResourceInstance {
+	return p.instances
+}
+
+func (p *Parser) GetResourceTypes() map[int32]*ResourceType {
+	return p.types
+}
+
+// GetArchiveInfo returns no header fields, since the header is skipped
+func (p *Parser) GetArchiveInfo() ArchiveInfo {
+	return ArchiveInfo{}
+}
+
+// GetSamplingGaps returns nil: the legacy parser does not detect gaps
+func (p *Parser) GetSamplingGaps() []SamplingGap {
+	return nil
+}
+
+// GetSamplingDisabled returns nil: the legacy parser does not detect
+// disabled sampling
+func (p *Parser) GetSamplingDisabled() []SamplingGap {
+	return nil
+}
+
+// GetInstanceEvents returns nil: the legacy parser does not read instance
+// deletions
+func (p *Parser) GetInstanceEvents() []InstanceEvent {
+	return nil
+}
+
+// GetLastSampleTime returns the zero time: the legacy parser does not
+// track sample timestamps
+func (p *Parser) GetLastSampleTime() time.Time {
+	return time.Time{}
+}
+
+// TimeZone returns UTC, since the header is skipped
+func (p *Parser) TimeZone() (string, time.Duration) {
+	return "UTC", 0
+}
+
+// SetReadLimit caps the rate at which the archive is read from disk. It
+// must be called before parsing.
+func (p *Parser) SetReadLimit(limiter *throttle.Bucket) {
+	p.reader = throttle.NewReader(p.file, limiter)
+}
+
 func (gp *GeodeParser) parseHeader() error {
 	// Based on hex dump analysis, let's skip to where we know the records start
 	// The header structure is more complex than initially thought
-	
+
 	// Read header token
 	token, err := gp.reader.ReadByte()
 	if err != nil {
+		if isTruncation(err) {
+			return ErrNotAnArchive
+		}
 		return err
 	}
 	if token != HEADER_TOKEN {
-		return fmt.Errorf("expected header token %d, got %d", HEADER_TOKEN, token)
+		return fmt.Errorf("%w: expected header token %d, got %d", ErrNotAnArchive, HEADER_TOKEN, token)
 	}
 
-	log.Printf("Debug: Found header token at start")
+	logging.Default().Debugf("Found header token at start")
 
 	// Set byte order to little endian based on analysis
 	gp.byteOrder = binary.LittleEndian
-	
+
 	// For now, let's skip the complex header parsing and jump to where we know records start
 	// From hex analysis, first resource type token is at byte 155 (0x9b)
 	// Since we've read 1 byte already, we need to skip 154 more bytes to get to 0x9b
 	// But we're seeing we need to skip 2 more, so let's go to 0x9b directly
-	skipBytes := make([]byte, 154 + 2)
+	skipBytes := make([]byte, 154+2)
 	if _, err := io.ReadFull(gp.reader, skipBytes); err != nil {
+		if isTruncation(err) {
+			return &ErrTruncated{Offset: 0}
+		}
 		return fmt.Errorf("failed to skip header: %w", err)
 	}
+	// Little endian stays the default, but a header that only decodes the
+	// other way decides it
+	if order, ok := headerByteOrder(skipBytes[1:]); ok {
+		gp.byteOrder = order
+	}
 
-	log.Printf("Debug: Skipped header, should be at record start now")
+	logging.Default().Debugf("Skipped header, should be at record start now")
 
 	// Set some default values
 	gp.version = 4
@@ -136,7 +183,7 @@ func (gp *GeodeParser) parseRecords(p *Parser) error {
 	for {
 		token, err := gp.reader.ReadByte()
 		if err == io.EOF {
-			log.Printf("Processed %d records total", recordCount)
+			logging.Default().Debugf("Processed %d records total", recordCount)
 			break
 		}
 		if err != nil {
@@ -148,17 +195,17 @@ func (gp *GeodeParser) parseRecords(p *Parser) error {
 		switch token {
 		case RESOURCE_TYPE_TOKEN:
 			if err := gp.parseResourceType(p); err != nil {
-				log.Printf("Warning: Resource type parsing failed: %v - continuing...", err)
+				logging.Default().Warnf("Resource type parsing failed: %v - continuing...", err)
 				continue
 			}
 		case RESOURCE_INSTANCE_CREATE_TOKEN:
 			if err := gp.parseResourceInstanceCreate(p); err != nil {
-				log.Printf("Warning: Resource instance creation failed: %v - continuing...", err)
+				logging.Default().Warnf("Resource instance creation failed: %v - continuing...", err)
 				continue
 			}
 		case SAMPLE_TOKEN:
 			if err := gp.parseSample(p); err != nil {
-				log.Printf("Warning: Sample parsing failed: %v - continuing...", err)
+				logging.Default().Warnf("Sample parsing failed: %v - continuing...", err)
 				continue
 			}
 		default:
@@ -167,8 +214,8 @@ func (gp *GeodeParser) parseRecords(p *Parser) error {
 			gp.currentTime += delta
 		}
 	}
-	
-	log.Printf("Final: Found %d resource types, %d instances", len(gp.resourceTypes), len(gp.instances))
+
+	logging.Default().Debugf("Final: Found %d resource types, %d instances", len(gp.resourceTypes), len(gp.instances))
 	return nil
 }
 
@@ -177,7 +224,7 @@ func (gp *GeodeParser) decodeTimestamp(token byte) int64 {
 		// Small delta encoded in the token itself
 		return int64(token)
 	}
-	
+
 	// Larger deltas require reading more bytes
 	switch token {
 	case SHORT_RESOURCE_INST_ID_TOKEN:
@@ -210,7 +257,7 @@ func (gp *GeodeParser) parseResourceType(p *Parser) error {
 
 	// Clean and validate the type name
 	if len(typeName) > 100 || containsCorruptionMarkers(typeName) {
-		log.Printf("Skipping corrupted resource type with name: %q", typeName[:min(50, len(typeName))])
+		logging.Default().Debugf("Skipping corrupted resource type with name: %q", typeName[:min(50, len(typeName))])
 		return nil // Skip this corrupted resource type
 	}
 
@@ -229,8 +276,8 @@ func (gp *GeodeParser) parseResourceType(p *Parser) error {
 
 	// For now, skip stat parsing completely to focus on getting clean resource types
 	// The stat parsing corruption is preventing proper resource type registration
-	log.Printf("Skipping stat parsing for %s to prevent corruption", typeName)
-	
+	logging.Default().Debugf("Skipping stat parsing for %s to prevent corruption", typeName)
+
 	// Create a minimal stat for the resource type
 	stat := StatDescriptor{
 		ID:          0,
@@ -245,7 +292,7 @@ func (gp *GeodeParser) parseResourceType(p *Parser) error {
 	p.types[int32(typeID)] = resType
 	gp.resourceTypes[typeID] = resType
 
-	log.Printf("Found resource type: %s (ID: %d, Stats: %d)", typeName, typeID, len(resType.Stats))
+	logging.Default().Debugf("Found resource type: %s (ID: %d, Stats: %d)", typeName, typeID, len(resType.Stats))
 
 	return nil
 }
@@ -257,13 +304,12 @@ func (gp *GeodeParser) parseResourceInstanceCreate(p *Parser) error {
 		return err
 	}
 
-
 	var typeID int
 	var name string
 
 	// Based on debug analysis, all instances follow the same format:
 	// 4-byte type ID (big-endian), 1-byte name length, name
-	
+
 	// Read type ID (4 bytes, using same byte order as resource types)
 	var typeID32 uint32
 	if err := binary.Read(gp.reader, gp.byteOrder, &typeID32); err != nil {
@@ -307,7 +353,7 @@ func (gp *GeodeParser) parseResourceInstanceCreate(p *Parser) error {
 	p.instances[int32(instID)] = instance
 	gp.instances[instID] = instance
 
-	log.Printf("Found resource instance: %s (ID: %d, Type: %d)", name, instID, typeID)
+	logging.Default().Debugf("Found resource instance: %s (ID: %d, Type: %d)", name, instID, typeID)
 
 	return nil
 }
@@ -340,22 +386,22 @@ func (gp *GeodeParser) parseSample(p *Parser) error {
 		statCount := len(resType.Stats)
 		for j := 0; j < statCount; j++ {
 			stat := &resType.Stats[j]
-			
+
 			// Read the value based on type
-			var value interface{}
+			var value float64
 			switch stat.Type {
 			case StatTypeInt:
 				v, err := gp.readCompactValue()
 				if err != nil {
 					return err
 				}
-				value = int32(v)
+				value = float64(int32(v))
 			case StatTypeLong:
 				v, err := gp.readCompactValue()
 				if err != nil {
 					return err
 				}
-				value = v
+				value = float64(v)
 			case StatTypeDouble:
 				v, err := gp.readDouble()
 				if err != nil {
@@ -378,7 +424,7 @@ func (gp *GeodeParser) parseSample(p *Parser) error {
 			}
 
 			instance.Stats[statID] = append(instance.Stats[statID], StatValue{
-				Timestamp: statTimestamp,
+				Timestamp: statTimestamp.UnixMilli(),
 				Value:     value,
 			})
 		}
@@ -397,7 +443,7 @@ func (gp *GeodeParser) readUTF() (string, error) {
 	}
 
 	length := int(lengthByte)
-	
+
 	if length == 0 {
 		return "", nil
 	}
@@ -416,7 +462,7 @@ func (gp *GeodeParser) readUTF() (string, error) {
 			cleaned = append(cleaned, b)
 		}
 	}
-	
+
 	result := string(cleaned)
 	return result, nil
 }
@@ -428,7 +474,7 @@ func (gp *GeodeParser) readUTFWithOptionalPadding() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	
+
 	// If the first byte is 0, this might be padding - skip up to 4 zero bytes
 	if firstByte == 0 {
 		paddingCount := 1
@@ -445,24 +491,24 @@ func (gp *GeodeParser) readUTFWithOptionalPadding() (string, error) {
 			paddingCount++
 		}
 	}
-	
+
 	// Now read the string using the length byte we found
 	length := int(firstByte)
 	if length == 0 {
 		return "", nil
 	}
-	
+
 	// Sanity check on length
 	if length > 255 {
 		return "", fmt.Errorf("unreasonable string length: %d", length)
 	}
-	
+
 	// Read UTF-8 bytes
 	bytes := make([]byte, length)
 	if _, err := io.ReadFull(gp.reader, bytes); err != nil {
 		return "", err
 	}
-	
+
 	// Clean up null bytes and other control characters
 	cleaned := make([]byte, 0, length)
 	for _, b := range bytes {
@@ -470,7 +516,7 @@ func (gp *GeodeParser) readUTFWithOptionalPadding() (string, error) {
 			cleaned = append(cleaned, b)
 		}
 	}
-	
+
 	return string(cleaned), nil
 }
 
@@ -508,12 +554,12 @@ func (gp *GeodeParser) readCompactValue() (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	
+
 	// For now, just handle single byte values
 	if b <= 127 {
 		return int64(int8(b)), nil
 	}
-	
+
 	// For other values, return an error - this parser is not the main one we're using
 	return 0, fmt.Errorf("complex compact values not implemented in GeodeParser - use StatArchiveReader")
 }
@@ -550,10 +596,15 @@ func (gp *GeodeParser) readDouble() (float64, error) {
 
 // ParseGeode is the main parsing method that uses the Geode format
 func (p *Parser) ParseGeode() error {
+	src, err := decompress(p.reader)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotAnArchive, err)
+	}
+
 	// Create a Geode parser instance with fresh reader
 	gp := &GeodeParser{
 		file:          p.file,
-		reader:        bufio.NewReader(p.file),
+		reader:        bufio.NewReader(src),
 		byteOrder:     binary.LittleEndian, // GFS format uses little endian
 		resourceTypes: make(map[int]*ResourceType),
 		instances:     make(map[int]*ResourceInstance),
@@ -566,7 +617,6 @@ func (p *Parser) ParseGeode() error {
 
 	// Set current time
 	gp.currentTime = gp.startTime
-	p.baseTime = time.Unix(0, gp.startTime*int64(time.Millisecond))
 
 	// Parse records
 	if err := gp.parseRecords(p); err != nil {
@@ -583,7 +633,7 @@ func containsCorruptionMarkers(s string) bool {
 		"operations", "messages", "nanoseconds", "bytes", "sockets",
 		"Total", "Number", "threads", "requests", "exceptions",
 	}
-	
+
 	count := 0
 	for _, marker := range corruptionMarkers {
 		if strings.Contains(s, marker) {
@@ -599,4 +649,4 @@ func min(a, b int) int {
 		return a
 	}
 	return b
-}
\ No newline at end of file
+}
@@ -0,0 +1,129 @@
+package gfs_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// firstLengthAfter returns the offset of the first length traced in
+// layout after the start of the first record with token
+func firstLengthAfter(t *testing.T, layout *gfs.Layout, token byte) int64 {
+	t.Helper()
+	for _, record := range layout.Records {
+		if record.Token != token {
+			continue
+		}
+		for _, offset := range layout.Lengths {
+			if offset > record.Offset {
+				return offset
+			}
+		}
+	}
+	t.Fatalf("no length after a record with token %d", token)
+	return 0
+}
+
+// TestPathologicalLengths sets the stat count of a resource type and the
+// length of an instance name to negative and maximal values and checks
+// that the archive fails with the error callers branch on, without
+// allocating more than the intact archive needs
+func TestPathologicalLengths(t *testing.T) {
+	var archive bytes.Buffer
+	err := gfstest.Instance{
+		Start:  testStart,
+		Stats:  []gfs.StatDescriptor{{Name: "entries", Type: gfs.StatTypeInt}, {Name: "load", Type: gfs.StatTypeDouble}},
+		Values: [][]float64{{1, 2, 3}, {0.5, 1, 1.5}},
+	}.Write(&archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := archive.Bytes()
+	reader, err := readArchive(data, func(reader *gfs.StatArchiveReader) { reader.TraceLayout() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A type record is its token and id, then its name, its description
+	// and its stat count
+	layout := reader.GetLayout()
+	typeLengths := firstLengthAfter(t, layout, gfs.RESOURCE_TYPE_TOKEN)
+	var statCount int64
+	for i, offset := range layout.Lengths {
+		if offset == typeLengths {
+			statCount = layout.Lengths[i+2]
+		}
+	}
+	if count := binary.BigEndian.Uint16(data[statCount:]); count != 2 {
+		t.Fatalf("stat count at offset %d is %d, wrote 2", statCount, count)
+	}
+	instanceName := firstLengthAfter(t, layout, gfs.RESOURCE_INSTANCE_CREATE_TOKEN)
+	intact := readVariant(t, "intact archive", data)
+
+	corruptType := func(limited bool) func(t *testing.T, err error) {
+		return func(t *testing.T, err error) {
+			var corrupt *gfs.ErrCorruptRecord
+			var limit *gfs.ErrLimitExceeded
+			if !errors.As(err, &corrupt) || corrupt.Token != gfs.RESOURCE_TYPE_TOKEN || errors.As(err, &limit) != limited || gfs.IsIncomplete(err) {
+				t.Errorf("want an ErrCorruptRecord for the type record (over a limit: %t), got %v", limited, err)
+			}
+		}
+	}
+	incomplete := func(t *testing.T, err error) {
+		var truncated *gfs.ErrTruncated
+		if !errors.As(err, &truncated) || !gfs.IsIncomplete(err) {
+			t.Errorf("want an incomplete ErrTruncated, got %v", err)
+		}
+	}
+	tests := []struct {
+		name   string
+		offset int64
+		length uint16
+		limits gfs.ReaderLimits
+		gzip   bool
+		check  func(t *testing.T, err error)
+	}{
+		{name: "stat count -1", offset: statCount, length: 0xFFFF, check: corruptType(false)},
+		{name: "stat count -32768", offset: statCount, length: 0x8000, check: corruptType(false)},
+		{name: "stat count 32767", offset: statCount, length: 0x7FFF, check: corruptType(true)},
+		{name: "stat count past the end", offset: statCount, length: gfs.DefaultMaxStatsPerType, check: incomplete},
+		{name: "name length 65535", offset: instanceName, length: 0xFFFF, check: incomplete},
+		{name: "name length 65535 compressed", offset: instanceName, length: 0xFFFF, gzip: true, check: incomplete},
+		{name: "name length over a limit", offset: instanceName, length: 0xFFFF, limits: gfs.ReaderLimits{MaxStringLength: 100}, check: func(t *testing.T, err error) {
+			var corrupt *gfs.ErrCorruptRecord
+			var limit *gfs.ErrLimitExceeded
+			if !errors.As(err, &corrupt) || corrupt.Token != gfs.RESOURCE_INSTANCE_CREATE_TOKEN || !errors.As(err, &limit) || limit.Value != 0xFFFF {
+				t.Errorf("want an ErrCorruptRecord for the instance record over the string limit, got %v", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			damaged := append([]byte(nil), data...)
+			binary.BigEndian.PutUint16(damaged[tt.offset:], tt.length)
+			if tt.gzip {
+				var compressed bytes.Buffer
+				zw := gzip.NewWriter(&compressed)
+				zw.Write(damaged)
+				if err := zw.Close(); err != nil {
+					t.Fatal(err)
+				}
+				damaged = compressed.Bytes()
+			}
+
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			_, err := readArchive(damaged, func(reader *gfs.StatArchiveReader) { reader.SetLimits(tt.limits) })
+			runtime.ReadMemStats(&after)
+			tt.check(t, err)
+			if allocated := after.TotalAlloc - before.TotalAlloc; allocated > intact.allocated+1<<20 {
+				t.Errorf("allocated %d bytes, the intact archive %d", allocated, intact.allocated)
+			}
+		})
+	}
+}
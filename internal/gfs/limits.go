@@ -0,0 +1,69 @@
+package gfs
+
+// Default reader limits. Each is far above what a healthy archive holds, so
+// only a corrupt length or count reaches one.
+const (
+	// DefaultMaxStringLength is all a 16-bit string length can hold
+	DefaultMaxStringLength = 65535
+	// DefaultMaxStatsPerType is more stats than any Geode type defines
+	DefaultMaxStatsPerType = 10000
+	// DefaultMaxInstances bounds the instances kept from an archive,
+	// deleted ones included
+	DefaultMaxInstances = 1000000
+	// DefaultMaxSampleBytes bounds the sample records held in memory at
+	// once
+	DefaultMaxSampleBytes = 256 << 20
+)
+
+// ReaderLimits bounds what the lengths and counts read from an archive can
+// make the reader allocate. A length or count above its limit fails its
+// record with ErrLimitExceeded instead. Zero fields take their defaults.
+type ReaderLimits struct {
+	// MaxStringLength is the longest string, in bytes
+	MaxStringLength int
+	// MaxStatsPerType is the most stats a resource type may define
+	MaxStatsPerType int
+	// MaxInstances is the most instances kept from the archive
+	MaxInstances int
+	// MaxSampleBytes is the most bytes of sample records buffered at once
+	MaxSampleBytes int64
+}
+
+// DefaultReaderLimits returns the limits a reader starts with
+func DefaultReaderLimits() ReaderLimits {
+	return ReaderLimits{
+		MaxStringLength: DefaultMaxStringLength,
+		MaxStatsPerType: DefaultMaxStatsPerType,
+		MaxInstances:    DefaultMaxInstances,
+		MaxSampleBytes:  DefaultMaxSampleBytes,
+	}
+}
+
+// withDefaults returns the limits with every zero field set to its default
+func (l ReaderLimits) withDefaults() ReaderLimits {
+	defaults := DefaultReaderLimits()
+	if l.MaxStringLength <= 0 {
+		l.MaxStringLength = defaults.MaxStringLength
+	}
+	if l.MaxStatsPerType <= 0 {
+		l.MaxStatsPerType = defaults.MaxStatsPerType
+	}
+	if l.MaxInstances <= 0 {
+		l.MaxInstances = defaults.MaxInstances
+	}
+	if l.MaxSampleBytes <= 0 {
+		l.MaxSampleBytes = defaults.MaxSampleBytes
+	}
+	return l
+}
+
+// SetLimits replaces the reader's limits. It must be called before
+// ReadArchive.
+func (r *StatArchiveReader) SetLimits(limits ReaderLimits) {
+	r.limits = limits.withDefaults()
+}
+
+// Limits returns the limits the reader applies
+func (r *StatArchiveReader) Limits() ReaderLimits {
+	return r.limits
+}
@@ -0,0 +1,66 @@
+package gfs_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// TestReaderLimits reads intact archives with each reader limit set just
+// below what they hold and checks that the first record over it fails
+// with an ErrLimitExceeded naming it, which the parse report counts, and
+// that zero limits take the defaults
+func TestReaderLimits(t *testing.T) {
+	synthetic := synthetic(t, testStart, gfs.ArchiveHeader{})
+	// Its type name is the longest string it holds, its header's included
+	var instance bytes.Buffer
+	err := gfstest.Instance{
+		Start:  testStart,
+		Stats:  []gfs.StatDescriptor{{Name: "entries", Type: gfs.StatTypeInt}},
+		Values: [][]float64{{1, 2}},
+	}.Write(&instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nameLength := len(gfstest.TypeName(0))
+	tests := []struct {
+		name   string
+		data   []byte
+		limits gfs.ReaderLimits
+		// want is the limit exceeded first, nil if none is
+		want *gfs.ErrLimitExceeded
+	}{
+		{"defaults", synthetic, gfs.ReaderLimits{}, nil},
+		{"limits held", synthetic, gfs.ReaderLimits{MaxStringLength: 64, MaxStatsPerType: len(gfstest.StatTypes), MaxInstances: testOptions.Types * testOptions.Instances}, nil},
+		{"string length", instance.Bytes(), gfs.ReaderLimits{MaxStringLength: nameLength - 1},
+			&gfs.ErrLimitExceeded{What: "string length", Value: int64(nameLength), Limit: int64(nameLength - 1)}},
+		{"stats per type", synthetic, gfs.ReaderLimits{MaxStatsPerType: len(gfstest.StatTypes) - 1},
+			&gfs.ErrLimitExceeded{What: "stat count", Value: int64(len(gfstest.StatTypes)), Limit: int64(len(gfstest.StatTypes) - 1)}},
+		{"instances", synthetic, gfs.ReaderLimits{MaxInstances: 4},
+			&gfs.ErrLimitExceeded{What: "instance count", Value: 5, Limit: 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := readArchive(tt.data, func(reader *gfs.StatArchiveReader) { reader.SetLimits(tt.limits) })
+			report := reader.GetParseReport()
+			if tt.want == nil {
+				if err != nil || !report.Clean() {
+					t.Errorf("not read cleanly: %v, %s", err, report)
+				}
+				return
+			}
+			var corrupt *gfs.ErrCorruptRecord
+			var limit *gfs.ErrLimitExceeded
+			if !errors.As(err, &corrupt) || !errors.As(err, &limit) || *limit != *tt.want {
+				t.Errorf("want an ErrCorruptRecord over the %s limit of %d at %d, got %v", tt.want.What, tt.want.Limit, tt.want.Value, err)
+			}
+			if report.LimitsExceeded == 0 || report.LimitsExceeded > report.SkippedRecords {
+				t.Errorf("want the records over the limit counted among those skipped, got %s", report)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package gfs
+
+import "sort"
+
+// SortedResourceTypes returns the resource types ordered by type ID so that
+// callers iterating them produce the same output on every run
+func SortedResourceTypes(types map[int32]*ResourceType) []*ResourceType {
+	sorted := make([]*ResourceType, 0, len(types))
+	for _, resType := range types {
+		sorted = append(sorted, resType)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+// SortedInstances returns the resource instances ordered by instance ID,
+// those of archives earlier in the file first and, for a reused ID, the
+// earliest created first
+func SortedInstances(instances map[int32]*ResourceInstance) []*ResourceInstance {
+	sorted := make([]*ResourceInstance, 0, len(instances))
+	for _, instance := range instances {
+		sorted = append(sorted, instance)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Segment != sorted[j].Segment {
+			return sorted[i].Segment < sorted[j].Segment
+		}
+		if sorted[i].ID != sorted[j].ID {
+			return sorted[i].ID < sorted[j].ID
+		}
+		if !sorted[i].CreationTime.Equal(sorted[j].CreationTime) {
+			return sorted[i].CreationTime.Before(sorted[j].CreationTime)
+		}
+		return deletedBefore(sorted[i], sorted[j])
+	})
+	return sorted
+}
+
+// deletedBefore reports whether a was deleted before b, an instance that
+// was never deleted coming last
+func deletedBefore(a, b *ResourceInstance) bool {
+	if a.DeletionTime.IsZero() || b.DeletionTime.IsZero() {
+		return !a.DeletionTime.IsZero() && b.DeletionTime.IsZero()
+	}
+	return a.DeletionTime.Before(b.DeletionTime)
+}
+
+// SortedStatIDs returns the stat offsets that have values, in ascending order
+func SortedStatIDs(stats map[int32][]StatValue) []int32 {
+	ids := make([]int32, 0, len(stats))
+	for id := range stats {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
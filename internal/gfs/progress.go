@@ -0,0 +1,48 @@
+package gfs
+
+import "time"
+
+// progressInterval is the shortest time between two calls of a
+// ProgressFunc
+const progressInterval = 250 * time.Millisecond
+
+// ProgressFunc receives the progress of a read: the bytes of the file read
+// so far, compressed bytes for a gzipped file, its size, 0 if not known,
+// and the sample records decoded
+type ProgressFunc func(bytesRead, totalBytes int64, samples int)
+
+// SetProgressFunc makes ReadArchive and ReadArchiveStream call fn while
+// they read, at most every progressInterval and once more when the read
+// ends. A read that ends within the first interval never calls it.
+func (r *StatArchiveReader) SetProgressFunc(fn ProgressFunc) {
+	r.progressFunc = fn
+}
+
+// progressState is when a ProgressFunc was last called
+type progressState struct {
+	last     time.Time
+	reported bool
+}
+
+// progress calls the ProgressFunc if the interval has passed since the
+// last call, or if the read has ended and it was called before
+func (r *StatArchiveReader) progress(samples int, done bool) {
+	if r.progressFunc == nil {
+		return
+	}
+	now := time.Now()
+	if now.Sub(r.progressState.last) < progressInterval && !(done && r.progressState.reported) {
+		return
+	}
+	r.progressState.last, r.progressState.reported = now, true
+	r.progressFunc(r.bytesConsumed(), r.size, samples)
+}
+
+// bytesConsumed returns the bytes of the file parsed so far, counting
+// compressed bytes for a gzipped file the way Progress does
+func (r *StatArchiveReader) bytesConsumed() int64 {
+	if r.compressed {
+		return r.raw.n
+	}
+	return r.Offset()
+}
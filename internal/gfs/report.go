@@ -0,0 +1,174 @@
+package gfs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxReportWarnings caps the warnings kept in a ParseReport; the rest are
+// only counted
+const maxReportWarnings = 20
+
+// ParseReport describes how much of an archive was read and what was
+// skipped on the way, so callers can tell a clean read from a salvaged one
+type ParseReport struct {
+	// Records counts every record read, Samples the sample records among
+	// them
+	Records int
+	Samples int
+	// Archives counts the archives in the file, more than one when a
+	// restarted member appended a new archive to it
+	Archives int
+	// BytesRead is the number of archive bytes parsed, in the decompressed
+	// archive for a gzipped file; FileSize is the size on disk, 0 if the
+	// archive was not read from a file
+	BytesRead  int64
+	FileSize   int64
+	Compressed bool
+	// Truncated is set when the archive ends inside a record, which
+	// starts at TruncatedAt
+	Truncated   bool
+	TruncatedAt int64
+	// SkippedRecords counts corrupt records that were skipped whole;
+	// LimitsExceeded counts those among them skipped for a length or count
+	// above the reader's limits
+	SkippedRecords int
+	LimitsExceeded int
+	// PaddingBytes counts the zero bytes ending the file that were ignored
+	// as padding
+	PaddingBytes int64
+	// ResyncedBytes counts the bytes skipped to find the next record after
+	// a byte that could not start one
+	ResyncedBytes int64
+	// Stopped is set when damaged sample data at StoppedAt ended the read,
+	// which the strict sample error policy does instead of skipping it
+	Stopped   bool
+	StoppedAt int64
+	// TimeJumps lists the sample records whose timestamp goes back from
+	// the one before, and RegressedSamples counts the sample records the
+	// time jump policy dropped or moved for being before the latest
+	// timestamp
+	TimeJumps        []TimeJump
+	RegressedSamples int
+	// WarningCount counts every recoverable problem, including skipped
+	// records; Warnings holds the first of them
+	WarningCount int
+	Warnings     []ParseWarning
+	// Verification is the consistency verdict, nil unless Verify was
+	// called
+	Verification *Verification
+}
+
+// ParseWarning is a recoverable problem found while reading an archive.
+// Offset is where in the archive it was found, in the decompressed archive
+// for a gzipped file.
+type ParseWarning struct {
+	Offset  int64  `json:"offset"`
+	Message string `json:"message"`
+}
+
+// String returns the warning prefixed with its offset in hex, to be matched
+// against a hexdump of the archive
+func (w ParseWarning) String() string {
+	return fmt.Sprintf("%s: %s", offsetLabel(w.Offset), w.Message)
+}
+
+// offsetLabel formats an archive offset the way warnings and errors
+// report it
+func offsetLabel(offset int64) string {
+	return fmt.Sprintf("offset=0x%x", offset)
+}
+
+// Clean reports whether the whole archive was read without problems
+func (p *ParseReport) Clean() bool {
+	return !p.Truncated && p.WarningCount == 0
+}
+
+// Salvaged returns the fraction of the archive that was read before it
+// ended inside a record, 1 if it is not truncated
+func (p *ParseReport) Salvaged() float64 {
+	if !p.Truncated || p.BytesRead <= 0 {
+		return 1
+	}
+	return float64(p.TruncatedAt) / float64(p.BytesRead)
+}
+
+func (p *ParseReport) String() string {
+	parts := []string{fmt.Sprintf("%d records, %d samples, %d bytes read", p.Records, p.Samples, p.BytesRead)}
+	if p.Compressed && p.FileSize > 0 {
+		parts[0] += fmt.Sprintf(" (%d compressed)", p.FileSize)
+	}
+	if p.Archives > 1 {
+		parts = append(parts, fmt.Sprintf("%d archives", p.Archives))
+	}
+	if p.Truncated {
+		parts = append(parts, fmt.Sprintf("truncated at %.1f%% (%s)", p.Salvaged()*100, offsetLabel(p.TruncatedAt)))
+	}
+	if p.SkippedRecords > 0 {
+		parts = append(parts, plural(p.SkippedRecords, "skipped record"))
+	}
+	if p.LimitsExceeded > 0 {
+		parts = append(parts, fmt.Sprintf("%d over reader limits", p.LimitsExceeded))
+	}
+	if p.ResyncedBytes > 0 {
+		parts = append(parts, fmt.Sprintf("%d bytes skipped to resync", p.ResyncedBytes))
+	}
+	if p.Stopped {
+		parts = append(parts, fmt.Sprintf("stopped at damaged samples (%s)", offsetLabel(p.StoppedAt)))
+	}
+	if len(p.TimeJumps) > 0 {
+		parts = append(parts, plural(len(p.TimeJumps), "backwards time jump"))
+	}
+	if p.RegressedSamples > 0 {
+		parts = append(parts, plural(p.RegressedSamples, "regressed sample"))
+	}
+	if p.PaddingBytes > 0 {
+		parts = append(parts, fmt.Sprintf("%d trailing padding bytes ignored", p.PaddingBytes))
+	}
+	if p.WarningCount > 0 {
+		parts = append(parts, plural(p.WarningCount, "warning"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// GetParseReport returns the report of the last ReadArchive or
+// ReadArchiveStream call
+func (r *StatArchiveReader) GetParseReport() *ParseReport {
+	report := r.report
+	report.FileSize = r.size
+	report.Compressed = r.compressed
+	report.Warnings = append([]ParseWarning(nil), r.report.Warnings...)
+	report.TimeJumps = append([]TimeJump(nil), r.report.TimeJumps...)
+	report.Verification = r.GetVerification()
+	return &report
+}
+
+// warnf records a recoverable problem at the current offset and logs it at
+// debug level
+func (r *StatArchiveReader) warnf(format string, args ...interface{}) {
+	r.warnAt(r.Offset(), fmt.Sprintf(format, args...))
+}
+
+func (r *StatArchiveReader) warnAt(offset int64, message string) {
+	warning := ParseWarning{Offset: offset, Message: message}
+	r.report.WarningCount++
+	if len(r.report.Warnings) < maxReportWarnings {
+		r.report.Warnings = append(r.report.Warnings, warning)
+	}
+	r.logger.Debugf("Warning: %s", warning)
+}
+
+// stopAt records that the read ended at offset without reaching the end of
+// the archive, and why
+func (r *StatArchiveReader) stopAt(offset int64, message string) {
+	r.report.Stopped = true
+	r.report.StoppedAt = offset
+	r.warnAt(offset, message)
+}
@@ -0,0 +1,59 @@
+package gfs_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+)
+
+// TestWarningOffsets corrupts an archive at known offsets and checks that
+// the parse report places its warnings there: a stat offset out of range,
+// which only drops its instance's block, and an unknown instance id, which
+// drops the record holding it and fails the archive there
+func TestWarningOffsets(t *testing.T) {
+	data, recordStarts := smallArchive(t)
+	// A sample record is its token, a two byte time delta and then the
+	// instance id and first stat offset of its first block
+	badStat := recordStarts[1] + 4
+	data[badStat] = 200
+	badInstance := recordStarts[3]
+	data[badInstance+3] = 100
+
+	reader, err := readArchive(data, nil)
+	var corrupt *gfs.ErrCorruptRecord
+	if !errors.As(err, &corrupt) || corrupt.Offset != int64(badInstance) || !strings.Contains(err.Error(), fmt.Sprintf("offset=0x%x: ", badInstance)) {
+		t.Fatalf("want an error for the record at offset %d, got %v", badInstance, err)
+	}
+	// What follows the dropped record is read out of step, so only the
+	// first warnings are known
+	report := reader.GetParseReport()
+	want := []int{badStat, badInstance}
+	if len(report.Warnings) < len(want) {
+		t.Fatalf("want %d warnings at least, got %v", len(want), report.Warnings)
+	}
+	for i, offset := range want {
+		warning := report.Warnings[i]
+		prefix := fmt.Sprintf("offset=0x%x: ", offset)
+		if warning.Offset != int64(offset) || !strings.HasPrefix(warning.String(), prefix) {
+			t.Errorf("want warning %d at offset %d, got %q", i+1, offset, warning)
+		}
+	}
+}
+
+// TestTruncatedOffset cuts an archive inside a sample record and checks
+// that the error and the parse report place the cut at its start
+func TestTruncatedOffset(t *testing.T) {
+	data, recordStarts := smallArchive(t)
+	cut := recordStarts[2]
+	reader, err := readArchive(data[:cut+5], nil)
+	var truncated *gfs.ErrTruncated
+	if !errors.As(err, &truncated) || truncated.Offset != int64(cut) || !strings.Contains(err.Error(), fmt.Sprintf("offset=0x%x: ", cut)) {
+		t.Errorf("want an error for the record at offset %d, got %v", cut, err)
+	}
+	if report := reader.GetParseReport(); !report.Truncated || report.TruncatedAt != int64(cut) {
+		t.Errorf("want the report truncated at offset %d, got %s", cut, report)
+	}
+}
@@ -0,0 +1,275 @@
+package gfs
+
+import (
+	"fmt"
+	"io"
+)
+
+// sampleCheckWindow is how far ahead a sample record is checked before it
+// is decoded. A longer record is decoded if its first sampleCheckWindow
+// bytes are consistent.
+const sampleCheckWindow = 64 << 10
+
+// scanResult is what scanning bytes for a record, or part of one, found
+type scanResult int
+
+const (
+	// scanMismatch means the bytes cannot be what was scanned for
+	scanMismatch scanResult = iota
+	// scanShort means the bytes were consistent but ended first
+	scanShort
+	// scanMatch means the bytes hold all of it
+	scanMatch
+)
+
+// recordFollows reports whether the bytes after token, which has just been
+// read, can be the rest of a record. Metadata records are decoded as they
+// are; any other token starts a sample record, which is only decoded if
+// its instance blocks are consistent with the known instances, so a stray
+// byte is not taken for a timestamp delta followed by garbage values.
+func (r *StatArchiveReader) recordFollows(token byte) bool {
+	switch token {
+	case RESOURCE_TYPE_TOKEN, RESOURCE_INSTANCE_CREATE_TOKEN, RESOURCE_INSTANCE_DELETE_TOKEN, RESOURCE_INSTANCE_INITIALIZE_TOKEN:
+		return true
+	case HEADER_TOKEN:
+		if r.appendedArchiveFollows() {
+			return true
+		}
+	}
+
+	// Most records are already buffered whole
+	data, _ := r.reader.Peek(r.reader.Buffered())
+	_, values, result := r.scanSampleRecord(token, data)
+	if result == scanShort && len(data) < r.reader.Size() {
+		data, _ = r.reader.Peek(r.reader.Size())
+		_, values, result = r.scanSampleRecord(token, data)
+	}
+	if r.verify != nil {
+		r.verify.scanned(values, result)
+	}
+	// A record that runs past the window, or past the end of the archive
+	// where decoding reports the truncation, is given the benefit of the
+	// doubt
+	return result != scanMismatch
+}
+
+// resync discards bytes up to the next offset that plausibly starts a
+// record and returns how many it discarded. It stops at the end of the
+// archive if none does.
+func (r *StatArchiveReader) resync() (int64, error) {
+	var skipped int64
+	for {
+		data, err := r.reader.Peek(r.reader.Size())
+		if len(data) == 0 {
+			if err == io.EOF || isTruncation(err) {
+				return skipped, nil
+			}
+			return skipped, err
+		}
+		atEOF := err != nil
+
+		step := len(data)
+		for i := range data {
+			result := r.scanRecord(data[i:])
+			if result == scanShort && i > 0 && !atEOF {
+				// Scanned again from the start of a full buffer
+				step = i
+				break
+			}
+			if result != scanMismatch {
+				if _, err := r.reader.Discard(i); err != nil {
+					return skipped, err
+				}
+				return skipped + int64(i), nil
+			}
+		}
+		if _, err := r.reader.Discard(step); err != nil {
+			return skipped, err
+		}
+		skipped += int64(step)
+	}
+}
+
+// scanRecord checks whether data starts with a record: a type or instance
+// record with a plausible id and name, the deletion of a known instance, an
+// appended archive's header or a consistent sample record
+func (r *StatArchiveReader) scanRecord(data []byte) scanResult {
+	token, rest := data[0], data[1:]
+	switch token {
+	case RESOURCE_TYPE_TOKEN, RESOURCE_INSTANCE_CREATE_TOKEN, RESOURCE_INSTANCE_INITIALIZE_TOKEN:
+		if nameFollows(r, rest) {
+			return scanMatch
+		}
+		return scanMismatch
+	case RESOURCE_INSTANCE_DELETE_TOKEN:
+		id, _, result := r.scanInstanceID(rest)
+		if result == scanMatch && r.instances[id] == nil {
+			return scanMismatch
+		}
+		return result
+	case HEADER_TOKEN:
+		if len(rest) > 0 && rest[0] >= MIN_ARCHIVE_VERSION && rest[0] <= ARCHIVE_VERSION {
+			return scanMatch
+		}
+	}
+	_, _, result := r.scanSampleRecord(token, data[1:])
+	return result
+}
+
+// scanSampleRecord checks whether data can be the rest of a sample record
+// starting with token: its timestamp delta, then blocks of known instances
+// holding offsets and values that fit their types, and the terminator. It
+// returns the length of the record after the token and the number of
+// values it holds.
+func (r *StatArchiveReader) scanSampleRecord(token byte, data []byte) (int, int, scanResult) {
+	if token != SAMPLE_TOKEN {
+		return 0, 0, scanMismatch
+	}
+	if len(data) < 2 {
+		return 0, 0, scanShort
+	}
+	pos := 2
+	if r.byteOrder.Uint16(data) == INT_TIMESTAMP_TOKEN {
+		if len(data) < 6 {
+			return 0, 0, scanShort
+		}
+		if delta := int32(r.byteOrder.Uint32(data[2:])); delta < 0 && -int64(delta) > maxTimeJump.Milliseconds() {
+			return 0, 0, scanMismatch
+		}
+		pos = 6
+	}
+
+	values := 0
+	for blocks := 0; ; blocks++ {
+		id, n, result := r.scanInstanceID(data[pos:])
+		if result != scanMatch {
+			return 0, 0, result
+		}
+		pos += n
+		if id == -1 {
+			return pos, values, scanMatch
+		}
+		if blocks >= r.limits.MaxInstances {
+			return 0, 0, scanMismatch
+		}
+		instance := r.instances[id]
+		if instance == nil {
+			return 0, 0, scanMismatch
+		}
+		resourceType := r.resourceTypes[instance.TypeID]
+		if resourceType == nil {
+			return 0, 0, scanMismatch
+		}
+		n, blockValues, result := r.scanSampleBlock(resourceType, data[pos:])
+		if result != scanMatch {
+			return 0, 0, result
+		}
+		pos += n
+		values += blockValues
+	}
+}
+
+// scanInstanceID decodes the instance id data starts with the way
+// readResourceInstanceId does, returning -1 for the terminator of a sample
+func (r *StatArchiveReader) scanInstanceID(data []byte) (int32, int, scanResult) {
+	if len(data) == 0 {
+		return 0, 0, scanShort
+	}
+	switch data[0] {
+	case ILLEGAL_RESOURCE_INST_ID_TOKEN:
+		return -1, 1, scanMatch
+	case SHORT_RESOURCE_INST_ID_TOKEN:
+		if len(data) < 3 {
+			return 0, 0, scanShort
+		}
+		return int32(r.byteOrder.Uint16(data[1:])), 3, scanMatch
+	case INT_RESOURCE_INST_ID_TOKEN:
+		if len(data) < 5 {
+			return 0, 0, scanShort
+		}
+		return int32(r.byteOrder.Uint32(data[1:])), 5, scanMatch
+	}
+	return int32(data[0]), 1, scanMatch
+}
+
+// scanSampleBlock checks whether data starts with an instance block of the
+// given type and returns its length and the number of values it holds.
+// Like readInstanceSampleData, it tolerates one invalid stat offset if the
+// rest of the block lines up after it, counting no values for the block.
+func (r *StatArchiveReader) scanSampleBlock(resourceType *ResourceType, data []byte) (int, int, scanResult) {
+	n, values, result := scanBlockRemainder(resourceType, data)
+	if result != scanMismatch {
+		return n, values, result
+	}
+
+	// n is where the invalid stat offset is. Its value is skipped with
+	// each width skipCorruptBlockRemainder tries.
+	pos := n + 1
+	if pos >= len(data) {
+		return 0, 0, scanShort
+	}
+	candidates := []int{compactValueWidth(data[pos]), 1, 2, 4, 8}
+	short := false
+	for i, width := range candidates {
+		if containsInt(candidates[:i], width) {
+			continue
+		}
+		if pos+width > len(data) {
+			short = true
+			continue
+		}
+		remainder, _, result := scanBlockRemainder(resourceType, data[pos+width:])
+		switch result {
+		case scanMatch:
+			return pos + width + remainder, 0, scanMatch
+		case scanShort:
+			short = true
+		}
+	}
+	if short {
+		return 0, 0, scanShort
+	}
+	return 0, 0, scanMismatch
+}
+
+// scanBlockRemainder checks whether data starts with the rest of an
+// instance block, up to and including its terminator, decoding every
+// offset strictly. It returns the length of the rest and the number of
+// values in it, or for a mismatch where the offending stat offset starts.
+func scanBlockRemainder(resourceType *ResourceType, data []byte) (int, int, scanResult) {
+	pos := 0
+	for values := 0; ; values++ {
+		start := pos
+		if pos >= len(data) {
+			return 0, 0, scanShort
+		}
+		offset := data[pos]
+		pos++
+		if offset == ILLEGAL_STAT_OFFSET {
+			return pos, values, scanMatch
+		}
+		if int(offset) >= len(resourceType.Stats) || values >= len(resourceType.Stats) {
+			return start, 0, scanMismatch
+		}
+		if pos >= len(data) {
+			return 0, 0, scanShort
+		}
+		pos += statValueWidth(resourceType.Stats[offset].Type, data[pos])
+	}
+}
+
+// resyncAfter skips from a token that does not start a plausible record to
+// the next one that does. The skipped bytes are returned as a corrupt
+// record for ReadArchive to report once the rest has been read.
+func (r *StatArchiveReader) resyncAfter(token byte, recordStart int64) (corrupt *ErrCorruptRecord, err error) {
+	skipped, err := r.resync()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resync after token %d: %w", token, err)
+	}
+	// The token itself was skipped too
+	skipped++
+	r.report.ResyncedBytes += skipped
+	corrupt = &ErrCorruptRecord{Offset: recordStart, Token: token, Err: fmt.Errorf("no record starts here, skipped %d bytes to the next one", skipped)}
+	r.warnAt(recordStart, fmt.Sprintf("Skipped %d bytes from token %d, which does not start a plausible record", skipped, token))
+	return corrupt, nil
+}
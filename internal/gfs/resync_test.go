@@ -0,0 +1,49 @@
+package gfs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// junk is a byte that would be a timestamp delta followed by what would
+// be an instance id no instance has, and nothing that can start a record
+var junk = append([]byte{5}, bytes.Repeat([]byte{gfs.INT_RESOURCE_INST_ID_TOKEN}, 15)...)
+
+// withJunk returns the synthetic archive with junk injected between two of
+// its samples, and the offset of the junk
+func withJunk(t *testing.T) ([]byte, int64) {
+	t.Helper()
+	data := synthetic(t, testStart, gfs.ArchiveHeader{})
+	reader, err := readArchive(data, func(reader *gfs.StatArchiveReader) { reader.TraceLayout() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var samples []int64
+	for _, record := range reader.GetLayout().Records {
+		if record.Token == gfs.SAMPLE_TOKEN {
+			samples = append(samples, record.Offset)
+		}
+	}
+	at := samples[len(samples)/2]
+	return append(append(append([]byte(nil), data[:at]...), junk...), data[at:]...), at
+}
+
+// TestSkipJunk checks that the reader skips exactly the junk injected
+// between two samples, warning at its offset
+func TestSkipJunk(t *testing.T) {
+	data, at := withJunk(t)
+	reader, err := readArchive(data, nil)
+	if err == nil {
+		t.Fatal("archive with junk read without an error")
+	}
+	report := reader.GetParseReport()
+	if report.ResyncedBytes != int64(len(junk)) || len(report.Warnings) != 1 || report.Warnings[0].Offset != at {
+		t.Errorf("want %d bytes skipped at offset %d, got %s: %v", len(junk), at, report, report.Warnings)
+	}
+	if n, want := values(reader), testOptions.Types*testOptions.Instances*len(gfstest.StatTypes)*testOptions.Samples; n != want {
+		t.Errorf("read %d values, wrote %d", n, want)
+	}
+}
@@ -0,0 +1,34 @@
+package gfs
+
+// Sample error policies, selected with SetSampleErrorPolicy
+const (
+	// SampleErrorsLenient salvages what it can from damaged sample data:
+	// an instance block with an invalid stat offset is skipped if the rest
+	// of it lines up after the offset's value, and bytes that cannot start
+	// a record are skipped to the next offset that plausibly does
+	SampleErrorsLenient = "lenient"
+	// SampleErrorsStrict ends the read at the first sample record that
+	// cannot be decoded as written, keeping what was read before it
+	SampleErrorsStrict = "strict"
+)
+
+// ValidSampleErrorPolicy reports whether policy is a known sample error
+// policy. The empty string selects SampleErrorsLenient.
+func ValidSampleErrorPolicy(policy string) bool {
+	switch policy {
+	case "", SampleErrorsLenient, SampleErrorsStrict:
+		return true
+	}
+	return false
+}
+
+// SetSampleErrorPolicy selects how damaged sample data is handled. It must
+// be called before ReadArchive.
+func (r *StatArchiveReader) SetSampleErrorPolicy(policy string) {
+	r.sampleErrors = policy
+}
+
+// strictSamples reports whether damaged sample data ends the read
+func (r *StatArchiveReader) strictSamples() bool {
+	return r.sampleErrors == SampleErrorsStrict
+}
@@ -0,0 +1,54 @@
+package gfs_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+)
+
+// TestSampleErrorPolicies damages a stat offset in the second sample
+// record of an archive and checks what each sample error policy keeps:
+// the lenient one drops only the damaged instance block, the strict one
+// stops at the record holding it
+func TestSampleErrorPolicies(t *testing.T) {
+	data, recordStarts := smallArchive(t)
+	withPolicy := func(policy string) func(reader *gfs.StatArchiveReader) {
+		return func(reader *gfs.StatArchiveReader) { reader.SetSampleErrorPolicy(policy) }
+	}
+	intact, err := readArchive(data, withPolicy(gfs.SampleErrorsStrict))
+	if err != nil {
+		t.Fatalf("intact archive: %v", err)
+	}
+	if n := values(intact); n != 16 {
+		t.Fatalf("intact archive: want 16 values, got %d", n)
+	}
+
+	damaged := append([]byte(nil), data...)
+	damaged[recordStarts[1]+4] = 200
+	tests := []struct {
+		policy  string
+		values  int
+		stopped bool
+	}{
+		{gfs.SampleErrorsLenient, 14, false},
+		{gfs.SampleErrorsStrict, 4, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			reader, err := readArchive(damaged, withPolicy(tt.policy))
+			report := reader.GetParseReport()
+			// A dropped block is only a warning
+			var corrupt *gfs.ErrCorruptRecord
+			if tt.stopped != errors.As(err, &corrupt) || report.WarningCount != 1 {
+				t.Errorf("want a warning and a corrupt record %t, got %v and %s", tt.stopped, err, report)
+			}
+			if n := values(reader); n != tt.values || report.Stopped != tt.stopped {
+				t.Errorf("want %d values and stopped %t, got %d values and %s", tt.values, tt.stopped, n, report)
+			}
+			if tt.stopped && report.StoppedAt != int64(recordStarts[1]) {
+				t.Errorf("want the read stopped at offset %d, got %d", recordStarts[1], report.StoppedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package gfs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// HasArchiveExtension reports whether filename looks like a statistics
+// archive, compressed or not
+func HasArchiveExtension(filename string) bool {
+	lower := strings.ToLower(filename)
+	return strings.HasSuffix(lower, ".gfs") || strings.HasSuffix(lower, ".gfs.gz")
+}
+
+// OpenArchive opens a statistics archive for reading, decompressing it if
+// it is gzipped. Compression is detected from the content, not the name.
+func OpenArchive(filename string) (io.ReadCloser, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	src, err := decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{src, file}, nil
+}
+
+// decompress returns the archive bytes of raw, unwrapping gzip if raw
+// starts with the gzip magic bytes
+func decompress(raw io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(raw)
+	magic, err := buffered.Peek(len(gzipMagic))
+	if err != nil || string(magic) != string(gzipMagic) {
+		// Too short to be gzip; the header check reports what is wrong
+		return buffered, nil
+	}
+
+	gz, err := gzip.NewReader(buffered)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+	}
+	return gz, nil
+}
+
+// countingReader counts the bytes read through it, giving the reader its
+// position in the archive even when the file is compressed
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -2,26 +2,78 @@ package gfs
 
 import (
 	"bufio"
+	"compress/gzip"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
 	"os"
 	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+	"github.com/4n3w/gfs-to-prometheus/internal/throttle"
+)
+
+// Geode statistics archive constants based on StatArchiveWriter.java
+const (
+	// Tokens
+	HEADER_TOKEN                       = 77
+	SAMPLE_TOKEN                       = 0
+	RESOURCE_TYPE_TOKEN                = 1
+	RESOURCE_INSTANCE_CREATE_TOKEN     = 2
+	RESOURCE_INSTANCE_DELETE_TOKEN     = 3
+	RESOURCE_INSTANCE_INITIALIZE_TOKEN = 4
+
+	// Resource ID tokens
+	SHORT_RESOURCE_INST_ID_TOKEN   = 253
+	INT_RESOURCE_INST_ID_TOKEN     = 254
+	ILLEGAL_RESOURCE_INST_ID_TOKEN = 255
+
+	// Timestamp tokens. A sample record written by StatArchiveWriter is
+	// SAMPLE_TOKEN followed by the delta from the previous timestamp as an
+	// unsigned short, or by INT_TIMESTAMP_TOKEN and an int for deltas above
+	// MAX_SHORT_TIMESTAMP.
+	MAX_SHORT_TIMESTAMP = 65534
+	INT_TIMESTAMP_TOKEN = 65535
+
+	// Archive versions read. Geode reads the same header and records in
+	// every version from MIN_ARCHIVE_VERSION on, except that a stat
+	// descriptor only has its isLargerBetter flag from
+	// LARGER_BETTER_VERSION on, GemFire 7 and 8 writing version 3.
+	ARCHIVE_VERSION       = 4
+	MIN_ARCHIVE_VERSION   = 2
+	LARGER_BETTER_VERSION = 4
+
+	// DefaultPaddingThreshold is the shortest run of zero bytes ending an
+	// archive that is ignored as padding unless SetPaddingThreshold is
+	// called. A sample record holds a few zero bytes at most.
+	DefaultPaddingThreshold = 64
+
+	// minStatDescriptorSize is the smallest a stat descriptor can be: three
+	// empty strings and three flag bytes, one fewer before
+	// LARGER_BETTER_VERSION
+	minStatDescriptorSize = 9
 )
 
 // Additional StatArchive constants from Apache Geode's StatArchiveWriter.java
 const (
-	// Special markers  
+	// Special markers
 	ILLEGAL_STAT_OFFSET = 255
-	
-	// Compact value encoding constants (from Apache Geode StatArchiveWriter)
-	MAX_1BYTE_COMPACT_VALUE  = 127
-	MIN_1BYTE_COMPACT_VALUE  = -128
-	MAX_2BYTE_COMPACT_VALUE  = 32767
-	MIN_2BYTE_COMPACT_VALUE  = -32768
-	COMPACT_VALUE_2_TOKEN    = -1
-	
+	// MAX_DESCRIPTORS_PER_TYPE is the most stats Geode allows a type, as
+	// a stat offset is written in one byte below ILLEGAL_STAT_OFFSET
+	MAX_DESCRIPTORS_PER_TYPE = 254
+
+	// Compact value encoding constants (from Apache Geode StatArchiveWriter).
+	// A first byte below MIN_1BYTE_COMPACT_VALUE is a token: the 2-byte
+	// token is followed by a short, and COMPACT_VALUE_2_TOKEN+n-2 by n
+	// big-endian bytes for n from 3 to 8.
+	MAX_1BYTE_COMPACT_VALUE = 127
+	MIN_1BYTE_COMPACT_VALUE = -128 + 7
+	MAX_2BYTE_COMPACT_VALUE = 32767
+	MIN_2BYTE_COMPACT_VALUE = -32768
+	COMPACT_VALUE_2_TOKEN   = -128
+
 	// Type codes for statistics (from StatArchiveDescriptor.java)
 	BOOLEAN_TYPE_CODE = 1
 	CHAR_TYPE_CODE    = 2
@@ -36,30 +88,93 @@ const (
 
 // StatArchiveReader implements the official Apache Geode statistics archive format
 type StatArchiveReader struct {
-	file      *os.File
-	reader    *bufio.Reader
-	byteOrder binary.ByteOrder
-	
+	input      io.Reader       // The archive as given, compressed or not
+	closer     io.Closer       // Closes the input, nil if the caller owns it
+	size       int64           // Size of the file on disk, 0 if not known
+	source     io.Reader       // The input, possibly rate limited, before decompression
+	raw        *countingReader // Counts file bytes handed to decompression
+	counter    *countingReader // Counts decompressed archive bytes
+	compressed bool            // Set when the file is gzipped
+	reader     *bufio.Reader
+	byteOrder  binary.ByteOrder
+
 	// Archive header information
-	archiveVersion    int
-	startTimeStamp    int64
-	systemId          int64
-	systemStartTime   int64
-	timeZoneOffset    int32
-	timeZoneName      string
-	systemDirectory   string
+	archiveVersion     int
+	startTimeStamp     int64
+	systemId           int64
+	systemStartTime    int64
+	timeZoneOffset     int32
+	timeZoneName       string
+	timeZoneMode       string // One of the TimeZone* modes, raw when empty
+	sampleErrors       string // One of the SampleErrors* policies, lenient when empty
+	timeJumps          string // One of the TimeJumps* policies, drop when empty
+	systemDirectory    string
 	productDescription string
-	osInfo            string
-	machineInfo       string
-	
+	osInfo             string
+	machineInfo        string
+
 	// Current parsing state
 	currentTimeStamp  int64
 	previousTimeStamp int64
-	inBinaryDataSection bool // Track when we're in the binary sample data section
-	
+	metadataEnd       int64          // Offset of the first sample record, 0 until one is read
+	layout            *Layout        // Only collected when TraceLayout was called
+	sampleFunc        SampleFunc     // Receives values instead of the instances while streaming
+	deleteFunc        DeleteFunc     // Receives the instances deleted while streaming, if set
+	estimate          *estimateState // Set while only part of the samples is decoded
+	verify            *verifyState   // Set when Verify was called
+	progressFunc      ProgressFunc   // Receives the progress of the read, if set
+	progressState     progressState
+	timeJump          timeJumpState
+
+	// Sampling gap detection - only the previous sample time is kept
+	gapThreshold        time.Duration
+	lastSampleTimeStamp int64
+	samplingGaps        []SamplingGap
+
+	// Runs of samples without instance data, written while statistic
+	// sampling was disabled on the member
+	disabledStart    int64
+	disabledEnd      int64
+	samplingDisabled []SamplingGap
+
 	// Data structures
 	resourceTypes map[int32]*ResourceType
 	instances     map[int32]*ResourceInstance
+
+	// Creations and deletions of instances, in archive order
+	instanceEvents []InstanceEvent
+
+	// Deleted instances, and the types and instances of an archive once
+	// a restarted member appends another to the file, are retired: kept
+	// under keys counting up from math.MinInt32 so they cannot collide
+	// with ids, which Geode reuses
+	segment          int
+	segmentEvents    int // Index of the current archive's first instance event
+	retiredTypes     map[int32]*ResourceType
+	retiredInstances map[int32]*ResourceInstance
+	retiredKeys      int32 // Number of keys handed out
+
+	// Problems found while reading are logged at debug level and reported
+	logger logging.Logger
+	report ParseReport
+
+	// Bounds on what lengths and counts read from the archive allocate
+	limits ReaderLimits
+
+	// Shortest run of zero bytes at the end of the file taken for padding
+	paddingThreshold int
+}
+
+// SamplingGap is a stretch of the archive longer than the gap threshold
+// during which no samples were written, usually an unresponsive member
+type SamplingGap struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Duration returns the length of the gap
+func (g SamplingGap) Duration() time.Duration {
+	return g.End.Sub(g.Start)
 }
 
 // NewStatArchiveReader creates a new reader for Apache Geode statistics archives
@@ -68,50 +183,180 @@ func NewStatArchiveReader(filename string) (*StatArchiveReader, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
-	
-	// Get file size for debugging
+
+	// Get file size for progress reporting
 	fileInfo, err := file.Stat()
 	if err != nil {
+		file.Close()
 		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
-	
-	log.Printf("File size: %d bytes", fileInfo.Size())
-	
-	reader := &StatArchiveReader{
-		file:          file,
-		reader:        bufio.NewReader(file),
-		byteOrder:     binary.BigEndian, // Java DataOutputStream uses big endian
-		resourceTypes: make(map[int32]*ResourceType),
-		instances:     make(map[int32]*ResourceInstance),
-	}
-	
+
+	reader := NewStatArchiveReaderFromReader(file, fileInfo.Size())
+	reader.closer = file
 	return reader, nil
 }
 
-// Close closes the archive file
+// NewStatArchiveReaderFromReader creates a reader for an archive read from
+// src, such as stdin or a network stream, which may be gzipped. size is the
+// size of src in bytes if known, for progress reporting and length checks,
+// or 0. Close does not close src.
+func NewStatArchiveReaderFromReader(src io.Reader, size int64) *StatArchiveReader {
+	return &StatArchiveReader{
+		input:            src,
+		size:             size,
+		source:           src,
+		byteOrder:        binary.BigEndian, // Java DataOutputStream uses big endian
+		resourceTypes:    make(map[int32]*ResourceType),
+		instances:        make(map[int32]*ResourceInstance),
+		logger:           logging.Default(),
+		limits:           DefaultReaderLimits(),
+		paddingThreshold: DefaultPaddingThreshold,
+	}
+}
+
+// SetReadLimit caps the rate at which the archive is read from disk. It
+// must be called before ReadArchive.
+func (r *StatArchiveReader) SetReadLimit(limiter *throttle.Bucket) {
+	r.source = throttle.NewReader(r.input, limiter)
+}
+
+// openStream sets up reading of the archive bytes from the source,
+// decompressing them if the file is gzipped
+func (r *StatArchiveReader) openStream() error {
+	r.logger.Debugf("File size: %d bytes", r.size)
+	r.raw = &countingReader{r: r.source}
+	src, err := decompress(r.raw)
+	if err != nil {
+		// A gzip header cut short is a compressed archive still being
+		// written
+		if isTruncation(err) {
+			return &ErrTruncated{Offset: 0}
+		}
+		return fmt.Errorf("%w: %v", ErrNotAnArchive, err)
+	}
+	_, r.compressed = src.(*gzip.Reader)
+	r.counter = &countingReader{r: src}
+	// Padding is recognised by peeking at the whole threshold, sample
+	// records are checked before they are decoded, and an estimate peeks
+	// ahead for the next record
+	size := max(sampleCheckWindow, r.paddingThreshold)
+	if r.estimate != nil {
+		size = max(size, estimateBuffer)
+	}
+	r.reader = bufio.NewReaderSize(r.counter, size)
+	return nil
+}
+
+// SetGapThreshold enables sampling gap detection; any interval between two
+// consecutive samples longer than threshold is recorded. Zero disables it.
+func (r *StatArchiveReader) SetGapThreshold(threshold time.Duration) {
+	r.gapThreshold = threshold
+}
+
+// SetMaxStringLength caps the length of the strings in the archive, like
+// ReaderLimits.MaxStringLength. A longer string makes its record corrupt
+// instead of being allocated.
+func (r *StatArchiveReader) SetMaxStringLength(n int) {
+	r.limits.MaxStringLength = n
+	r.limits = r.limits.withDefaults()
+}
+
+// SetPaddingThreshold sets the shortest run of zero bytes at the end of the
+// archive that is ignored as padding, as added by backup systems that copy
+// files in whole blocks, instead of being read as empty samples. Zero
+// disables it. It must be called before ReadArchive.
+func (r *StatArchiveReader) SetPaddingThreshold(n int) {
+	r.paddingThreshold = n
+}
+
+// SetLogger sets the logger the reader writes to instead of
+// logging.Default()
+func (r *StatArchiveReader) SetLogger(logger logging.Logger) {
+	r.logger = logger
+}
+
+// GetSamplingGaps returns the gaps found while reading the archive
+func (r *StatArchiveReader) GetSamplingGaps() []SamplingGap {
+	return r.samplingGaps
+}
+
+// GetInstanceEvents returns the creation and deletion of every instance
+// read, in archive order
+func (r *StatArchiveReader) GetInstanceEvents() []InstanceEvent {
+	return r.instanceEvents
+}
+
+// GetLastSampleTime returns the timestamp of the last sample record read,
+// including samples without instance data, or the zero time if there was
+// none
+func (r *StatArchiveReader) GetLastSampleTime() time.Time {
+	if r.metadataEnd == 0 {
+		return time.Time{}
+	}
+	return r.getCurrentTime()
+}
+
+// GetStartTime returns the archive start time in the header, adjusted for
+// the timezone mode as sample timestamps are
+func (r *StatArchiveReader) GetStartTime() time.Time {
+	return r.toTime(r.startTimeStamp)
+}
+
+// GetSamplingDisabled returns the intervals during which the archive only
+// contained samples without instance data, as written while statistic
+// sampling was disabled. They are not reported as sampling gaps.
+func (r *StatArchiveReader) GetSamplingDisabled() []SamplingGap {
+	return r.samplingDisabled
+}
+
+// Close closes the archive file, if the reader opened it
 func (r *StatArchiveReader) Close() error {
-	return r.file.Close()
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
 }
 
 // ReadArchive reads the complete statistics archive following the official format
 func (r *StatArchiveReader) ReadArchive() error {
+	if err := r.openStream(); err != nil {
+		return err
+	}
+
 	// Read and parse the archive header
 	if err := r.readHeader(); err != nil {
 		return fmt.Errorf("failed to read header: %w", err)
 	}
-	
+	if r.verify != nil {
+		defer r.verify.finish(r)
+	}
+
 	// Initialize current timestamp
 	r.currentTimeStamp = r.startTimeStamp
 	r.previousTimeStamp = r.startTimeStamp
-	
+	r.timeJump = timeJumpState{}
+
 	// Read archive records until EOF
 	if err := r.readRecords(); err != nil {
 		return fmt.Errorf("failed to read records: %w", err)
 	}
-	
-	log.Printf("StatArchive: Successfully read %d resource types and %d instances", 
-		len(r.resourceTypes), len(r.instances))
-	
+
+	r.logger.Infof("StatArchive: Successfully read %d resource types and %d instances",
+		len(r.GetResourceTypes()), len(r.GetInstances()))
+
+	return nil
+}
+
+// ReadHeaderOnly reads the archive header and nothing after it, so that
+// GetArchiveInfo can be called without reading the records. The reader
+// cannot read the archive afterwards.
+func (r *StatArchiveReader) ReadHeaderOnly() error {
+	if err := r.openStream(); err != nil {
+		return err
+	}
+	if err := r.readHeader(); err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
 	return nil
 }
 
@@ -120,72 +365,95 @@ func (r *StatArchiveReader) readHeader() error {
 	// Read header token
 	headerToken, err := r.reader.ReadByte()
 	if err != nil {
+		// An empty file may be an archive that was only just created
+		if isTruncation(err) {
+			return &ErrTruncated{Offset: 0}
+		}
 		return fmt.Errorf("failed to read header token: %w", err)
 	}
-	
+
 	if headerToken != HEADER_TOKEN {
-		return fmt.Errorf("invalid header token: expected %d, got %d", HEADER_TOKEN, headerToken)
+		return fmt.Errorf("%w: expected header token %d, got %d", ErrNotAnArchive, HEADER_TOKEN, headerToken)
 	}
-	
+
+	if err := r.readHeaderFields(); err != nil {
+		var unsupported *ErrUnsupportedVersion
+		switch {
+		case isTruncation(err):
+			return &ErrTruncated{Offset: 0}
+		case errors.As(err, &unsupported):
+			return err
+		}
+		return fmt.Errorf("%s: %w: %w", offsetLabel(r.Offset()), ErrCorruptHeader, err)
+	}
+
+	r.logger.Debugf("StatArchive Header: version=%d, startTime=%d, system=%d",
+		r.archiveVersion, r.startTimeStamp, r.systemId)
+
+	return nil
+}
+
+// readHeaderFields reads the header fields that follow the header token.
+// Every version from MIN_ARCHIVE_VERSION on has the same fields: the
+// version, start time, system id, system start time and timezone offset,
+// then the timezone name, system directory, product description, OS and
+// machine. Only the stat descriptors differ, see readStatDescriptor.
+func (r *StatArchiveReader) readHeaderFields() error {
 	// Read archive version
 	version, err := r.reader.ReadByte()
 	if err != nil {
 		return fmt.Errorf("failed to read archive version: %w", err)
 	}
 	r.archiveVersion = int(version)
-	
-	if r.archiveVersion < 2 || r.archiveVersion > ARCHIVE_VERSION {
-		return fmt.Errorf("unsupported archive version: %d", r.archiveVersion)
-	}
-	
-	// Read start timestamp
-	if err := binary.Read(r.reader, r.byteOrder, &r.startTimeStamp); err != nil {
-		return fmt.Errorf("failed to read start timestamp: %w", err)
-	}
-	
-	// Read system ID
-	if err := binary.Read(r.reader, r.byteOrder, &r.systemId); err != nil {
-		return fmt.Errorf("failed to read system ID: %w", err)
-	}
-	
-	// Read system start time
-	if err := binary.Read(r.reader, r.byteOrder, &r.systemStartTime); err != nil {
-		return fmt.Errorf("failed to read system start time: %w", err)
-	}
-	
-	// Read timezone offset
-	if err := binary.Read(r.reader, r.byteOrder, &r.timeZoneOffset); err != nil {
-		return fmt.Errorf("failed to read timezone offset: %w", err)
-	}
-	
+
+	if r.archiveVersion < MIN_ARCHIVE_VERSION || r.archiveVersion > ARCHIVE_VERSION {
+		return &ErrUnsupportedVersion{Found: r.archiveVersion}
+	}
+
+	// The fixed-size fields are read whole, so they can be decoded in
+	// whichever byte order makes sense of them. Java writes them
+	// big-endian, but archives converted on other platforms may not be.
+	var fields [headerFieldsSize]byte
+	if _, err := io.ReadFull(r.reader, fields[:]); err != nil {
+		return fmt.Errorf("failed to read header fields: %w", err)
+	}
+	order, ok := headerByteOrder(fields[:])
+	if !ok {
+		r.warnf("Header timestamps are implausible in either byte order, reading the archive as %s", ByteOrderName(order))
+	} else if order != binary.BigEndian {
+		r.logger.Debugf("Header decodes as %s, reading the archive in that byte order", ByteOrderName(order))
+	}
+	r.byteOrder = order
+	r.startTimeStamp = int64(order.Uint64(fields[0:]))
+	r.systemId = int64(order.Uint64(fields[8:]))
+	r.systemStartTime = int64(order.Uint64(fields[16:]))
+	r.timeZoneOffset = int32(order.Uint32(fields[24:]))
+
 	// Read timezone name
 	if r.timeZoneName, err = r.readUTF(); err != nil {
 		return fmt.Errorf("failed to read timezone name: %w", err)
 	}
-	
+
 	// Read system directory
 	if r.systemDirectory, err = r.readUTF(); err != nil {
 		return fmt.Errorf("failed to read system directory: %w", err)
 	}
-	
+
 	// Read product description
 	if r.productDescription, err = r.readUTF(); err != nil {
 		return fmt.Errorf("failed to read product description: %w", err)
 	}
-	
+
 	// Read OS info
 	if r.osInfo, err = r.readUTF(); err != nil {
 		return fmt.Errorf("failed to read OS info: %w", err)
 	}
-	
+
 	// Read machine info
 	if r.machineInfo, err = r.readUTF(); err != nil {
 		return fmt.Errorf("failed to read machine info: %w", err)
 	}
-	
-	log.Printf("StatArchive Header: version=%d, startTime=%d, system=%d", 
-		r.archiveVersion, r.startTimeStamp, r.systemId)
-	
+
 	return nil
 }
 
@@ -195,184 +463,545 @@ func (r *StatArchiveReader) readRecords() error {
 	typeCount := 0
 	instanceCount := 0
 	sampleCount := 0
-	
+
+	// Corrupt records are logged and skipped; the first is returned once
+	// the rest of the archive has been read. A truncated record ends it.
+	var readErr error
+
+	r.report = ParseReport{Archives: 1}
+	r.progressState = progressState{last: time.Now()}
+	defer func() {
+		r.report.Records = recordCount
+		r.report.Samples = sampleCount
+		r.report.BytesRead = r.Offset()
+		r.progress(sampleCount, true)
+	}()
+
 	for {
+		if r.estimate != nil && r.metadataEnd > 0 {
+			more, err := r.estimate.next(r)
+			if err != nil {
+				return fmt.Errorf("failed to skip sample records: %w", err)
+			}
+			if !more {
+				break
+			}
+		}
+
+		recordStart := r.Offset()
 		token, err := r.reader.ReadByte()
 		if err == io.EOF {
-			// Get current position in file
-			pos, _ := r.file.Seek(0, io.SeekCurrent)
-			fileInfo, _ := r.file.Stat()
-			fileSize := fileInfo.Size()
-			log.Printf("Reached EOF after %d records (%d types, %d instances, %d samples) at position %d/%d (%.1f%%)", 
-				recordCount, typeCount, instanceCount, sampleCount, pos, fileSize, float64(pos)/float64(fileSize)*100)
+			r.logger.Debugf("Reached EOF after %d records (%d types, %d instances, %d samples) at %s (%.1f%%)",
+				recordCount, typeCount, instanceCount, sampleCount, offsetLabel(recordStart), r.Progress()*100)
 			break
 		}
 		if err != nil {
 			return fmt.Errorf("failed to read record token: %w", err)
 		}
-		
+
+		if token == SAMPLE_TOKEN && r.paddingThreshold > 0 {
+			zeros, atEOF, err := r.skipZeroRun()
+			if err != nil {
+				return fmt.Errorf("failed to read record token: %w", err)
+			}
+			if zeros > 0 && atEOF {
+				r.report.PaddingBytes = zeros
+				r.logger.Debugf("Ignored %d trailing padding bytes at %s", zeros, offsetLabel(recordStart))
+				break
+			}
+			if zeros > 0 {
+				r.warnAt(recordStart, fmt.Sprintf("Skipped %d zero bytes", zeros))
+				continue
+			}
+		}
+
+		if !r.recordFollows(token) {
+			if r.strictSamples() {
+				corrupt := &ErrCorruptRecord{Offset: recordStart, Token: token, Err: errors.New("no record starts here")}
+				r.stopAt(recordStart, fmt.Sprintf("Stopped at token %d, which does not start a plausible record", token))
+				if readErr == nil {
+					readErr = corrupt
+				}
+				break
+			}
+			corrupt, err := r.resyncAfter(token, recordStart)
+			if err != nil {
+				return err
+			}
+			if readErr == nil {
+				readErr = corrupt
+			}
+			continue
+		}
+
 		recordCount++
-		
+		if r.layout != nil {
+			if len(r.layout.Records) == 0 {
+				r.layout.HeaderEnd = recordStart
+			}
+			r.layout.Records = append(r.layout.Records, RecordSpan{Offset: recordStart, Token: token})
+		}
+
+		var recordErr error
+		sampleRecord := false
 		switch token {
 		case RESOURCE_TYPE_TOKEN:
 			typeCount++
 			if err := r.readResourceType(); err != nil {
-				log.Printf("Warning: Failed to read resource type %d: %v", typeCount, err)
-				continue
+				recordErr = fmt.Errorf("failed to read resource type %d: %w", typeCount, err)
 			}
 		case RESOURCE_INSTANCE_CREATE_TOKEN:
 			instanceCount++
-			if err := r.readResourceInstanceCreate(); err != nil {
-				log.Printf("Warning: Failed to read resource instance %d: %v", instanceCount, err)
-				continue
+			if err := r.readResourceInstanceCreate(false); err != nil {
+				recordErr = fmt.Errorf("failed to read resource instance %d: %w", instanceCount, err)
 			}
 			// Continue reading all metadata - we'll do binary parsing at the end
 		case RESOURCE_INSTANCE_DELETE_TOKEN:
 			if err := r.readResourceInstanceDelete(); err != nil {
-				log.Printf("Warning: Failed to read resource instance delete: %v", err)
-				continue
+				recordErr = fmt.Errorf("failed to read resource instance delete: %w", err)
 			}
 		case RESOURCE_INSTANCE_INITIALIZE_TOKEN:
-			// Handle initialize token if needed
-			log.Printf("Found RESOURCE_INSTANCE_INITIALIZE_TOKEN at record %d", recordCount)
-			// TODO: Implement if needed
+			instanceCount++
+			if err := r.readResourceInstanceCreate(true); err != nil {
+				recordErr = fmt.Errorf("failed to read initialized resource instance %d: %w", instanceCount, err)
+			}
+		case HEADER_TOKEN:
+			if r.appendedArchiveFollows() {
+				if err := r.startAppendedArchive(); err != nil {
+					recordErr = fmt.Errorf("failed to read header of appended archive: %w", err)
+				}
+				break
+			}
+			recordErr = errors.New("header token not followed by an appended archive")
 		default:
-			// ANY other byte is a timestamp delta!
-			// Update timestamp based on the token value
-			r.updateTimeStamp(token)
-			
+			// Any other token starts a sample record, which only
+			// SAMPLE_TOKEN does
+			sampleRecord = true
+			if r.metadataEnd == 0 {
+				r.metadataEnd = recordStart
+			}
+
+			if err := r.updateTimeStamp(token); err != nil {
+				recordErr = err
+				break
+			}
+			r.checkTimeJump(recordStart)
+			if r.verify != nil {
+				r.verify.startRecord()
+				r.verify.timestamp(r, recordStart)
+			}
+
 			// Now read the sample data that follows this timestamp
 			sampleCount++
 			if err := r.readSampleData(); err != nil {
-				log.Printf("Warning: Failed to read sample data after timestamp delta %d: %v", token, err)
-				continue
+				recordErr = fmt.Errorf("failed to read sample data after timestamp delta %d: %w", r.currentTimeStamp-r.previousTimeStamp, err)
+			} else if r.estimate != nil {
+				r.estimate.countSample()
+			} else if r.verify != nil {
+				r.verify.endRecord(recordStart)
 			}
 		}
-		
+
+		if recordErr != nil {
+			var stopped *streamStopped
+			if errors.As(recordErr, &stopped) {
+				return stopped
+			}
+			if isTruncation(recordErr) {
+				r.logger.Debugf("Archive ends inside record %d at %s: %v", recordCount, offsetLabel(recordStart), recordErr)
+				r.report.Truncated = true
+				r.report.TruncatedAt = recordStart
+				// After a corrupt record the reader may be out of step, so
+				// the corruption is what the archive is failed for
+				if readErr == nil {
+					readErr = &ErrTruncated{Offset: recordStart}
+				}
+				break
+			}
+			corrupt := &ErrCorruptRecord{Offset: recordStart, Token: token, Err: recordErr}
+			if sampleRecord && r.strictSamples() {
+				r.stopAt(recordStart, fmt.Sprintf("Stopped at corrupt sample record (token %d): %v", token, recordErr))
+				if readErr == nil {
+					readErr = corrupt
+				}
+				break
+			}
+			r.report.SkippedRecords++
+			var limit *ErrLimitExceeded
+			if errors.As(recordErr, &limit) {
+				r.report.LimitsExceeded++
+			}
+			r.warnAt(recordStart, fmt.Sprintf("Skipped corrupt record (token %d): %v", token, recordErr))
+			if readErr == nil {
+				readErr = corrupt
+			}
+			if r.estimate != nil && r.metadataEnd > 0 {
+				// A record decoded after skipping ahead may not start
+				// where it seemed to
+				more, err := r.estimate.resync(r)
+				if err != nil {
+					return fmt.Errorf("failed to skip sample records: %w", err)
+				}
+				if !more {
+					break
+				}
+			}
+			continue
+		}
+
+		r.progress(sampleCount, false)
+
 		// Log progress every 100 records
 		if recordCount%100 == 0 {
-			log.Printf("Progress: %d records (%d types, %d instances, %d samples)", 
-				recordCount, typeCount, instanceCount, sampleCount)
+			r.logger.Debugf("Progress: %d records (%d types, %d instances, %d samples) at %s (%.1f%%)",
+				recordCount, typeCount, instanceCount, sampleCount, offsetLabel(r.Offset()), r.Progress()*100)
 		}
 	}
-	
-	log.Printf("Final: %d records processed (%d types, %d instances, %d samples)", 
+
+	r.endSamplingDisabled()
+
+	r.logger.Debugf("Final: %d records processed (%d types, %d instances, %d samples)",
 		recordCount, typeCount, instanceCount, sampleCount)
-	
-	// Samples are parsed inline during the main loop after timestamp deltas
-	// The format doesn't use SAMPLE_TOKEN - instead any non-metadata byte is a timestamp delta
-	
-	return nil
+
+	return readErr
+}
+
+// Offset returns the position in the archive of the next byte to be
+// parsed, not counting bytes read ahead into buffers. For a compressed file
+// it is the position in the decompressed archive. It is 0 before
+// ReadArchive is called.
+func (r *StatArchiveReader) Offset() int64 {
+	if r.counter == nil {
+		return 0
+	}
+	return r.counter.n - int64(r.reader.Buffered())
+}
+
+// Progress returns the fraction of the file parsed so far, from 0 to 1.
+// It is exact for uncompressed files; for compressed ones it counts the
+// compressed bytes consumed, including what decompression has read ahead.
+func (r *StatArchiveReader) Progress() float64 {
+	if r.size <= 0 {
+		return 0
+	}
+	return math.Min(float64(r.bytesConsumed())/float64(r.size), 1)
 }
 
 // readUTF reads a UTF-8 string in the Java DataOutputStream format
 func (r *StatArchiveReader) readUTF() (string, error) {
-	// Read string length as unsigned short (big endian as per Java DataOutputStream spec)
+	r.traceField(func(l *Layout) *[]int64 { return &l.Lengths })
+
+	// Read string length as unsigned short, in the archive's byte order
 	var length uint16
-	if err := binary.Read(r.reader, binary.BigEndian, &length); err != nil {
+	if err := binary.Read(r.reader, r.byteOrder, &length); err != nil {
 		return "", err
 	}
-	
+
 	if length == 0 {
 		return "", nil
 	}
-	
-	// Sanity check on length to prevent reading too much data
-	if length > 65535 {
-		return "", fmt.Errorf("unreasonable UTF string length: %d", length)
+
+	if err := r.checkLength("string length", int64(length), 1, int64(r.limits.MaxStringLength)); err != nil {
+		return "", err
 	}
-	
-	// Read UTF-8 bytes
-	bytes := make([]byte, length)
+
+	// Read UTF-8 bytes. Long strings are read a chunk at a time, so a
+	// corrupt length in a compressed archive, whose remaining size is not
+	// known, only allocates the bytes that are actually there.
+	bytes := make([]byte, min(int(length), stringChunkSize))
 	if _, err := io.ReadFull(r.reader, bytes); err != nil {
 		return "", err
 	}
-	
-	// Return the raw string - Java's modified UTF-8 is compatible with standard UTF-8 
+	for len(bytes) < int(length) {
+		chunk := make([]byte, min(int(length)-len(bytes), stringChunkSize))
+		if _, err := io.ReadFull(r.reader, chunk); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		bytes = append(bytes, chunk...)
+	}
+
+	// Return the raw string - Java's modified UTF-8 is compatible with standard UTF-8
 	// for most characters
 	return string(bytes), nil
 }
 
-// updateTimeStamp updates the current timestamp based on a delta token
-func (r *StatArchiveReader) updateTimeStamp(token byte) {
-	r.previousTimeStamp = r.currentTimeStamp
-	
-	if token < 252 {
-		// Small delta encoded in the token
-		r.currentTimeStamp += int64(token)
-	} else if token == 252 {
-		// Medium delta - read next 2 bytes
-		var delta uint16
-		if err := binary.Read(r.reader, r.byteOrder, &delta); err == nil {
-			r.currentTimeStamp += int64(delta)
+// stringChunkSize is how much of a string readUTF reads at a time
+const stringChunkSize = 4096
+
+// remaining returns the number of archive bytes after the current offset,
+// or -1 when the file is compressed and it is not known
+func (r *StatArchiveReader) remaining() int64 {
+	if r.compressed || r.size <= 0 {
+		return -1
+	}
+	return r.size - r.Offset()
+}
+
+// checkLength validates a length read from the archive before anything is
+// allocated for it: count items of at least itemSize bytes each must not be
+// negative, which makes the record corrupt, or above limit, which fails it
+// with ErrLimitExceeded, nor need more bytes than are left in the file,
+// which means it was truncated.
+func (r *StatArchiveReader) checkLength(what string, count, itemSize, limit int64) error {
+	if count < 0 {
+		return fmt.Errorf("invalid %s %d", what, count)
+	}
+	if count > limit {
+		return &ErrLimitExceeded{What: what, Value: count, Limit: limit}
+	}
+	if left := r.remaining(); left >= 0 && count*itemSize > left {
+		return fmt.Errorf("%s %d runs past the end of the archive (%d bytes left): %w", what, count, left, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// skipZeroRun is called after reading a zero token. If at least the padding
+// threshold of zero bytes starts with it, they are skipped up to the next
+// other byte or the end of the archive, and their number is returned with
+// whether the archive ended. Otherwise nothing more is read.
+func (r *StatArchiveReader) skipZeroRun() (int64, bool, error) {
+	ahead, err := r.reader.Peek(r.paddingThreshold - 1)
+	if len(ahead) < r.paddingThreshold-1 {
+		if err == io.EOF {
+			err = nil
 		}
-	} else {
-		// Large delta - read next 4 bytes
-		var delta uint32
-		if err := binary.Read(r.reader, r.byteOrder, &delta); err == nil {
-			r.currentTimeStamp += int64(delta)
+		return 0, false, err
+	}
+	for _, b := range ahead {
+		if b != 0 {
+			return 0, false, nil
+		}
+	}
+
+	zeros := int64(1)
+	for {
+		b, err := r.reader.ReadByte()
+		if err == io.EOF {
+			return zeros, true, nil
+		}
+		if err != nil {
+			return 0, false, err
 		}
+		if b != 0 {
+			return zeros, false, r.reader.UnreadByte()
+		}
+		zeros++
+	}
+}
+
+// updateTimeStamp reads the timestamp delta of the sample record starting
+// with token and advances the current timestamp by it
+func (r *StatArchiveReader) updateTimeStamp(token byte) error {
+	if token != SAMPLE_TOKEN {
+		return fmt.Errorf("unknown record token %d", token)
+	}
+	delta, err := r.readTimeDelta()
+	if err != nil {
+		return fmt.Errorf("failed to read timestamp delta: %w", err)
 	}
+
+	r.previousTimeStamp = r.currentTimeStamp
+	r.currentTimeStamp += delta
+	return nil
 }
 
-// GetResourceTypes returns the parsed resource types
+// readTimeDelta reads a timestamp delta the way StatArchiveWriter's
+// writeTimeDelta writes it: an unsigned short, or INT_TIMESTAMP_TOKEN
+// followed by an int, which is negative when the clock went back but not
+// by more than maxTimeJump
+func (r *StatArchiveReader) readTimeDelta() (int64, error) {
+	var delta uint16
+	if err := binary.Read(r.reader, r.byteOrder, &delta); err != nil {
+		return 0, err
+	}
+	if delta != INT_TIMESTAMP_TOKEN {
+		return int64(delta), nil
+	}
+
+	var wide int32
+	if err := binary.Read(r.reader, r.byteOrder, &wide); err != nil {
+		return 0, err
+	}
+	if wide < 0 && -int64(wide) > maxTimeJump.Milliseconds() {
+		return 0, fmt.Errorf("negative timestamp delta %d", wide)
+	}
+	return int64(wide), nil
+}
+
+// GetResourceTypes returns the parsed resource types, including those of
+// archives appended to the file before the last one
 func (r *StatArchiveReader) GetResourceTypes() map[int32]*ResourceType {
-	return r.resourceTypes
+	if len(r.retiredTypes) == 0 {
+		return r.resourceTypes
+	}
+	types := make(map[int32]*ResourceType, len(r.retiredTypes)+len(r.resourceTypes))
+	for id, resType := range r.retiredTypes {
+		types[id] = resType
+	}
+	for id, resType := range r.resourceTypes {
+		types[id] = resType
+	}
+	return types
 }
 
-// GetInstances returns the parsed resource instances
+// GetInstances returns the parsed resource instances, including deleted
+// ones and those of archives appended to the file before the last one.
+// Their keys differ from their IDs, which may be shared by several
+// instances; their Segment and CreationTime tell them apart.
 func (r *StatArchiveReader) GetInstances() map[int32]*ResourceInstance {
-	return r.instances
+	if len(r.retiredInstances) == 0 {
+		return r.instances
+	}
+	instances := make(map[int32]*ResourceInstance, len(r.retiredInstances)+len(r.instances))
+	for id, instance := range r.retiredInstances {
+		instances[id] = instance
+	}
+	for id, instance := range r.instances {
+		instances[id] = instance
+	}
+	return instances
 }
 
-// GetArchiveInfo returns archive metadata
-func (r *StatArchiveReader) GetArchiveInfo() map[string]interface{} {
-	return map[string]interface{}{
-		"version":            r.archiveVersion,
-		"startTimeStamp":     r.startTimeStamp,
-		"systemId":           r.systemId,
-		"systemStartTime":    r.systemStartTime,
-		"timeZoneOffset":     r.timeZoneOffset,
-		"timeZoneName":       r.timeZoneName,
-		"systemDirectory":    r.systemDirectory,
-		"productDescription": r.productDescription,
-		"osInfo":            r.osInfo,
-		"machineInfo":       r.machineInfo,
+// maxArchiveRestartGap is the longest time between the last sample of an
+// archive and the start of one appended after it that is accepted as a
+// restart of the member
+const maxArchiveRestartGap = 365 * 24 * time.Hour
+
+// appendedArchiveFollows reports whether the HEADER_TOKEN just read starts
+// another archive appended to the file. Sample records start with
+// SAMPLE_TOKEN, so a record starting with this token is either a header or
+// damage. What follows is taken as a header only if it holds a
+// supported version and a start time no earlier than the current
+// archive's and within maxArchiveRestartGap of its last sample.
+func (r *StatArchiveReader) appendedArchiveFollows() bool {
+	peek, err := r.reader.Peek(9)
+	if err != nil {
+		return false
+	}
+	if version := int(peek[0]); version < MIN_ARCHIVE_VERSION || version > ARCHIVE_VERSION {
+		return false
+	}
+	start := int64(r.byteOrder.Uint64(peek[1:]))
+	return start >= r.startTimeStamp && start-r.currentTimeStamp <= maxArchiveRestartGap.Milliseconds()
+}
+
+// startAppendedArchive ends the current archive, deleting its remaining
+// instances and retiring its types and instances, then reads the header
+// of the next one. The header fields describe the last archive read.
+func (r *StatArchiveReader) startAppendedArchive() error {
+	r.endSamplingDisabled()
+	if r.retiredTypes == nil {
+		r.retiredTypes = make(map[int32]*ResourceType)
+	}
+
+	retiredTypeKeys := make(map[int32]int32)
+	retireType := func(id int32) int32 {
+		key, ok := retiredTypeKeys[id]
+		if !ok {
+			key = r.retiredKey()
+			retiredTypeKeys[id] = key
+		}
+		return key
+	}
+	for _, resType := range SortedResourceTypes(r.resourceTypes) {
+		key := retireType(resType.ID)
+		resType.ID = key
+		r.retiredTypes[key] = resType
+	}
+
+	// Instances deleted during this archive refer to its types too
+	for _, instance := range SortedInstances(r.retiredInstances) {
+		if instance.Segment == r.segment {
+			instance.TypeID = retireType(instance.TypeID)
+		}
+	}
+	end := r.getCurrentTime()
+	for _, instance := range SortedInstances(r.instances) {
+		r.instanceEvents = append(r.instanceEvents, InstanceEvent{Time: end, TypeID: instance.TypeID})
+		instance.TypeID = retireType(instance.TypeID)
+		if err := r.retireInstance(instance, end); err != nil {
+			return err
+		}
+	}
+	for i := r.segmentEvents; i < len(r.instanceEvents); i++ {
+		r.instanceEvents[i].TypeID = retireType(r.instanceEvents[i].TypeID)
+	}
+
+	r.resourceTypes = make(map[int32]*ResourceType)
+	r.instances = make(map[int32]*ResourceInstance)
+	r.segment++
+	r.segmentEvents = len(r.instanceEvents)
+	r.report.Archives++
+
+	if err := r.readHeaderFields(); err != nil {
+		return err
 	}
+	r.currentTimeStamp = r.startTimeStamp
+	r.previousTimeStamp = r.startTimeStamp
+	r.timeJump = timeJumpState{}
+
+	r.logger.Debugf("Archive %d appended to the file starts at %s", r.segment+1, r.getCurrentTime().Format(time.RFC3339))
+	return nil
+}
+
+// retiredKey returns a key for a retired type or instance
+func (r *StatArchiveReader) retiredKey() int32 {
+	key := math.MinInt32 + r.retiredKeys
+	r.retiredKeys++
+	return key
+}
+
+// retireInstance keeps an instance that was deleted at the given time, so
+// its samples are still returned once its id is reused
+func (r *StatArchiveReader) retireInstance(instance *ResourceInstance, deleted time.Time) error {
+	if r.retiredInstances == nil {
+		r.retiredInstances = make(map[int32]*ResourceInstance)
+	}
+	instance.DeletionTime = deleted
+	r.retiredInstances[r.retiredKey()] = instance
+	return r.instanceDeleted(instance)
 }
 
 // readResourceType reads a resource type definition record
 func (r *StatArchiveReader) readResourceType() error {
 	// Read resource type ID
+	r.traceField(func(l *Layout) *[]int64 { return &l.TypeIDs })
 	var typeId int32
 	if err := binary.Read(r.reader, r.byteOrder, &typeId); err != nil {
 		return fmt.Errorf("failed to read type ID: %w", err)
 	}
-	
+
 	// Read type name
 	typeName, err := r.readUTF()
 	if err != nil {
 		return fmt.Errorf("failed to read type name: %w", err)
 	}
-	
+
 	// Read type description
 	typeDescription, err := r.readUTF()
 	if err != nil {
 		return fmt.Errorf("failed to read type description: %w", err)
 	}
-	
+
 	// Read number of statistics
+	r.traceField(func(l *Layout) *[]int64 { return &l.Lengths })
 	var statCount int16
 	if err := binary.Read(r.reader, r.byteOrder, &statCount); err != nil {
 		return fmt.Errorf("failed to read stat count: %w", err)
 	}
-	
-	// Validate stat count to prevent panic
-	if statCount < 0 || statCount > 10000 {
-		log.Printf("Warning: Invalid stat count %d for type %s, attempting recovery", statCount, typeName)
-		return fmt.Errorf("invalid stat count: %d", statCount)
+
+	// Validate stat count before sizing the type's descriptors by it
+	minSize := int64(minStatDescriptorSize)
+	if r.archiveVersion < LARGER_BETTER_VERSION {
+		minSize--
+	}
+	if err := r.checkLength("stat count", int64(statCount), minSize, int64(r.limits.MaxStatsPerType)); err != nil {
+		return fmt.Errorf("type %s: %w", typeName, err)
 	}
-	
+
 	// Create resource type
 	resType := &ResourceType{
 		ID:          typeId,
@@ -380,370 +1009,329 @@ func (r *StatArchiveReader) readResourceType() error {
 		Description: typeDescription,
 		Stats:       make([]StatDescriptor, 0, statCount),
 	}
-	
+
 	// Read each statistic descriptor
 	for i := int16(0); i < statCount; i++ {
 		stat, err := r.readStatDescriptor()
 		if err != nil {
 			// If we hit EOF while reading stats, the record may be truncated
 			// Log warning and break instead of failing completely
-			log.Printf("Warning: Failed to read stat descriptor %d for type %s: %v", i, typeName, err)
+			r.warnf("Failed to read stat descriptor %d for type %s: %v", i, typeName, err)
 			break
 		}
 		resType.Stats = append(resType.Stats, *stat)
 	}
-	
+
+	// Type ids are never reused, so a second definition is corrupt. The
+	// first is kept, as instances may already have been read against it.
+	if existing, ok := r.resourceTypes[typeId]; ok {
+		return fmt.Errorf("duplicate resource type id %d: %s is already defined as %s", typeId, typeName, existing.Name)
+	}
 	r.resourceTypes[typeId] = resType
-	
-	log.Printf("Read resource type: %s (ID: %d, Stats: %d/%d)", typeName, typeId, len(resType.Stats), statCount)
-	
+
+	r.logger.Debugf("Read resource type: %s (ID: %d, Stats: %d/%d)", typeName, typeId, len(resType.Stats), statCount)
+
 	return nil
 }
 
-// readStatDescriptor reads a single statistic descriptor
+// readStatDescriptor reads a single statistic descriptor: its name, type
+// code, counter flag, larger-is-better flag from LARGER_BETTER_VERSION on,
+// unit and description
 func (r *StatArchiveReader) readStatDescriptor() (*StatDescriptor, error) {
 	// Read stat name
 	statName, err := r.readUTF()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read stat name: %w", err)
 	}
-	
+
 	// Read type code
 	typeCode, err := r.reader.ReadByte()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read type code: %w", err)
 	}
-	
+
 	// Read counter flag
 	isCounterByte, err := r.reader.ReadByte()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read counter flag: %w", err)
 	}
 	isCounter := isCounterByte != 0
-	
-	// Read isLargerBetter flag (this was the missing field!)
-	_, err = r.reader.ReadByte()
-	if err != nil {
-		return nil, fmt.Errorf("failed to read isLargerBetter flag: %w", err)
+
+	// Older archives have no isLargerBetter flag; Geode then takes
+	// counters for larger is better
+	largerBetter := isCounter
+	if r.archiveVersion >= LARGER_BETTER_VERSION {
+		largerBetterByte, err := r.reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read isLargerBetter flag: %w", err)
+		}
+		largerBetter = largerBetterByte != 0
 	}
-	
+
 	// Read unit
 	unit, err := r.readUTF()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read unit: %w", err)
 	}
-	
+
 	// Read description
 	description, err := r.readUTF()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read description: %w", err)
 	}
-	
+
 	// Convert type code to our internal type
 	statType := convertTypeCode(typeCode)
-	
+
 	return &StatDescriptor{
-		ID:          int32(len(r.resourceTypes)), // We'll assign proper IDs later
-		Name:        statName,
-		Description: description,
-		Unit:        unit,
-		IsCounter:   isCounter,
-		Type:        statType,
-		LargestBit:  0, // Not used in this format
+		ID:           int32(len(r.resourceTypes)), // We'll assign proper IDs later
+		Name:         statName,
+		Description:  description,
+		Unit:         unit,
+		IsCounter:    isCounter,
+		LargerBetter: largerBetter,
+		Type:         statType,
+		LargestBit:   0, // Not used in this format
 	}, nil
 }
 
-// readResourceInstanceCreate reads a resource instance creation record
-func (r *StatArchiveReader) readResourceInstanceCreate() error {
+// readResourceInstanceCreate reads a resource instance creation record. An
+// initialize record has the same layout followed by the initial value of
+// every stat of the instance's type, which seeds its samples at the
+// current timestamp.
+func (r *StatArchiveReader) readResourceInstanceCreate(initialize bool) error {
+	recordOffset := r.Offset() - 1
+
 	// Read instance ID (regular int32, not compact)
 	var instanceId int32
 	if err := binary.Read(r.reader, r.byteOrder, &instanceId); err != nil {
 		return fmt.Errorf("failed to read instance ID: %w", err)
 	}
-	
+
 	// Read text ID (name)
 	textId, err := r.readUTF()
 	if err != nil {
 		return fmt.Errorf("failed to read text ID: %w", err)
 	}
-	
+
 	// Read numeric ID
 	var numericId int64
 	if err := binary.Read(r.reader, r.byteOrder, &numericId); err != nil {
 		return fmt.Errorf("failed to read numeric ID: %w", err)
 	}
-	
+
 	// Read resource type ID
 	var typeId int32
 	if err := binary.Read(r.reader, r.byteOrder, &typeId); err != nil {
 		return fmt.Errorf("failed to read type ID: %w", err)
 	}
-	
+
+	// Deleted instances are kept, so every create counts
+	if kept := len(r.instances) + len(r.retiredInstances); kept >= r.limits.MaxInstances {
+		return &ErrLimitExceeded{What: "instance count", Value: int64(kept) + 1, Limit: int64(r.limits.MaxInstances)}
+	}
+
 	// Create resource instance
 	instance := &ResourceInstance{
 		ID:           instanceId,
 		TypeID:       typeId,
 		Name:         textId, // Use the text ID as the name
+		NumericID:    numericId,
 		CreationTime: r.getCurrentTime(),
 		Stats:        make(map[int32][]StatValue),
+		Segment:      r.segment,
 	}
-	
-	r.instances[instanceId] = instance
-	
-	log.Printf("Read resource instance: %s (ID: %d, NumericID: %d, Type: %d)", textId, instanceId, numericId, typeId)
-	
-	return nil
-}
 
-// readResourceInstanceDelete reads a resource instance deletion record
-func (r *StatArchiveReader) readResourceInstanceDelete() error {
-	// Read instance ID
-	instanceId, err := r.readResourceInstanceId()
-	if err != nil {
-		return fmt.Errorf("failed to read instance ID: %w", err)
-	}
-	
-	// Remove instance from our map
-	delete(r.instances, instanceId)
-	
-	log.Printf("Deleted resource instance: %d", instanceId)
-	
-	return nil
-}
-
-// readSampleData reads sample data that follows a timestamp delta
-func (r *StatArchiveReader) readSampleData() error {
-	// After a timestamp delta, we read resource instances until ILLEGAL_RESOURCE_INST_ID
-	instanceCount := 0
-	for {
-		// Read instance ID
-		instanceId, err := r.readResourceInstanceId()
-		if err != nil {
-			return fmt.Errorf("failed to read instance ID: %w", err)
-		}
-		
-		// Check for end of instances marker (-1 is returned for ILLEGAL_RESOURCE_INST_ID_TOKEN)
-		if instanceId == -1 {
-			break
-		}
-		
-		instanceCount++
-		
-		// Read stat data for this instance
-		if err := r.readInstanceSampleData(instanceId); err != nil {
-			log.Printf("Warning: Failed to read sample data for instance %d: %v", instanceId, err)
-			// Continue with next instance rather than failing completely
-			continue
+	// A create for a live id means its delete record was lost; the old
+	// instance must not collect the new one's samples
+	if previous, exists := r.instances[instanceId]; exists {
+		r.warnf("Instance id %d of %s created again as %s without being deleted", instanceId, previous.Name, textId)
+		r.instanceEvents = append(r.instanceEvents, InstanceEvent{Time: instance.CreationTime, TypeID: previous.TypeID})
+		if err := r.retireInstance(previous, instance.CreationTime); err != nil {
+			return err
 		}
 	}
-	
-	if instanceCount == 0 {
-		log.Printf("Debug: Sample at timestamp %d had no instance data", r.currentTimeStamp)
+	r.instances[instanceId] = instance
+	r.instanceEvents = append(r.instanceEvents, InstanceEvent{Time: instance.CreationTime, TypeID: typeId, Created: true})
+	if r.verify != nil {
+		r.verify.instanceCreated(r, recordOffset, instance)
+	}
+
+	r.logger.Debugf("Read resource instance: %s (ID: %d, NumericID: %d, Type: %d)", textId, instanceId, numericId, typeId)
+
+	if initialize {
+		return r.readInitialValues(instance)
 	}
-	
 	return nil
 }
 
-// readSample reads a sample record containing statistical data
-func (r *StatArchiveReader) readSample() error {
-	// Read the timestamp delta first (written immediately after SAMPLE_TOKEN)
-	err := r.readSampleTimestamp()
-	if err != nil {
-		return fmt.Errorf("failed to read sample timestamp: %w", err)
+// readInitialValues reads one value per stat of the instance's type, in
+// descriptor order, and stores them as its first sample
+func (r *StatArchiveReader) readInitialValues(instance *ResourceInstance) error {
+	resourceType, exists := r.resourceTypes[instance.TypeID]
+	if !exists {
+		return fmt.Errorf("unknown resource type %d for initialized instance %s", instance.TypeID, instance.Name)
 	}
-	
-	// Read instances until ILLEGAL_RESOURCE_INST_ID
-	for {
-		// Peek at the next byte to see if it's the end marker
-		nextByte, err := r.reader.ReadByte()
+
+	valuesOffset := r.Offset()
+	staged := make([]stagedValue, 0, len(resourceType.Stats))
+	for i, stat := range resourceType.Stats {
+		value, err := r.readStatValue(stat.Type)
 		if err != nil {
-			return fmt.Errorf("failed to read instance ID or end marker: %w", err)
-		}
-		
-		// Check if this is the end of sample marker
-		if nextByte == ILLEGAL_RESOURCE_INST_ID_TOKEN {
-			break // End of sample
-		}
-		
-		// Put the byte back and read as instance ID
-		// Since we already read one byte, we need to handle it as part of the instance ID
-		instanceId, err := r.readResourceInstanceIdFromByte(nextByte)
-		if err != nil {
-			return fmt.Errorf("failed to read instance ID: %w", err)
-		}
-		
-		if err := r.readInstanceSampleData(instanceId); err != nil {
-			return fmt.Errorf("failed to read instance sample data: %w", err)
+			return fmt.Errorf("failed to read initial value of %s for instance %s: %w", stat.Name, instance.Name, err)
 		}
+		staged = append(staged, stagedValue{statId: int32(i), value: value})
 	}
-	
-	return nil
-}
 
-// readSampleTimestamp reads the timestamp written as part of a sample record
-func (r *StatArchiveReader) readSampleTimestamp() error {
-	// Read first as unsigned short to check for INT_TIMESTAMP_TOKEN
-	var deltaShort uint16
-	if err := binary.Read(r.reader, r.byteOrder, &deltaShort); err != nil {
-		return fmt.Errorf("failed to read timestamp delta: %w", err)
+	if r.verify != nil {
+		r.verify.block(valuesOffset, instance, resourceType, staged, true)
 	}
-	
-	var timestampDelta int64
-	
-	if deltaShort == INT_TIMESTAMP_TOKEN {
-		// Large delta - read next 4 bytes as int
-		var deltaInt int32
-		if err := binary.Read(r.reader, r.byteOrder, &deltaInt); err != nil {
-			return fmt.Errorf("failed to read int timestamp delta: %w", err)
-		}
-		timestampDelta = int64(deltaInt)
-	} else {
-		// Small delta - use the short we already read (convert to signed)
-		timestampDelta = int64(int16(deltaShort))
-	}
-	
-	// Update our current timestamp
-	r.currentTimeStamp += timestampDelta
-	
-	return nil
+	return r.storeStagedValues(instance, staged)
 }
 
-// readResourceInstanceIdFromByte reads a resource instance ID when we already have the first byte
-func (r *StatArchiveReader) readResourceInstanceIdFromByte(firstByte byte) (int32, error) {
-	if firstByte < SHORT_RESOURCE_INST_ID_TOKEN {
-		return int32(firstByte), nil
+// readResourceInstanceDelete reads a resource instance deletion record
+func (r *StatArchiveReader) readResourceInstanceDelete() error {
+	// Read instance ID
+	instanceId, err := r.readResourceInstanceId()
+	if err != nil {
+		return fmt.Errorf("failed to read instance ID: %w", err)
 	}
-	
-	switch firstByte {
-	case SHORT_RESOURCE_INST_ID_TOKEN:
-		var id uint16
-		if err := binary.Read(r.reader, r.byteOrder, &id); err != nil {
-			return 0, err
-		}
-		return int32(id), nil
-	case INT_RESOURCE_INST_ID_TOKEN:
-		var id uint32
-		if err := binary.Read(r.reader, r.byteOrder, &id); err != nil {
-			return 0, err
+
+	// Retire the instance, keeping its samples, as its id can be reused
+	if instance, exists := r.instances[instanceId]; exists {
+		r.instanceEvents = append(r.instanceEvents, InstanceEvent{Time: r.getCurrentTime(), TypeID: instance.TypeID})
+		if err := r.retireInstance(instance, r.getCurrentTime()); err != nil {
+			return err
 		}
-		return int32(id), nil
-	default:
-		return 0, fmt.Errorf("invalid resource instance ID token: %d", firstByte)
 	}
+	delete(r.instances, instanceId)
+
+	r.logger.Debugf("Deleted resource instance: %d", instanceId)
+
+	return nil
 }
 
-// readInstanceSampleData reads sample data for a specific instance
-func (r *StatArchiveReader) readInstanceSampleData(instanceId int32) error {
-	instance, exists := r.instances[instanceId]
-	if !exists {
-		return fmt.Errorf("unknown instance ID: %d", instanceId)
-	}
-	
-	resourceType, exists := r.resourceTypes[instance.TypeID]
-	if !exists {
-		return fmt.Errorf("unknown resource type: %d", instance.TypeID)
-	}
-	
-	// Read stat offset (which stats have changed) until ILLEGAL_STAT_OFFSET
+// readSampleData reads sample data that follows a timestamp delta
+func (r *StatArchiveReader) readSampleData() error {
+	// After a timestamp delta, we read resource instances until ILLEGAL_RESOURCE_INST_ID
+	instanceCount := 0
 	for {
-		offset, err := r.reader.ReadByte()
-		if err != nil {
-			return fmt.Errorf("failed to read stat offset: %w", err)
-		}
-		
-		if offset == ILLEGAL_STAT_OFFSET {
-			break // End of stats for this instance
+		// Read instance ID
+		instanceId, err := r.readResourceInstanceId()
+		if err != nil {
+			return fmt.Errorf("failed to read instance ID: %w", err)
 		}
-		
-		// CRITICAL FIX: Stat offsets can be 0-254, not just 0-127
-		// Only 255 (ILLEGAL_STAT_OFFSET) terminates the stat list
-		// Make sure we have a valid stat at this offset
-		if int(offset) >= len(resourceType.Stats) {
-			log.Printf("Debug: Invalid stat offset %d for instance %d (type %s has %d stats)", 
-				offset, instanceId, resourceType.Name, len(resourceType.Stats))
-			return fmt.Errorf("invalid stat offset: %d (max: %d)", offset, len(resourceType.Stats))
+
+		// Check for end of instances marker (-1 is returned for ILLEGAL_RESOURCE_INST_ID_TOKEN)
+		if instanceId == -1 {
+			break
 		}
-		
-		stat := &resourceType.Stats[offset]
-		
-		// Read the stat value based on its type
-		value, err := r.readStatValue(stat.Type)
-		if err != nil {
-			return fmt.Errorf("failed to read stat value for %s: %w", stat.Name, err)
+
+		// A sample holds one block per instance at most
+		instanceCount++
+		if instanceCount > r.limits.MaxInstances {
+			return &ErrLimitExceeded{What: "instance blocks in sample", Value: int64(instanceCount), Limit: int64(r.limits.MaxInstances)}
 		}
-		
-		// Store the stat value
-		statId := int32(offset)
-		if instance.Stats[statId] == nil {
-			instance.Stats[statId] = make([]StatValue, 0)
+
+		// Read stat data for this instance. A block that was skipped
+		// cleanly, which has already been warned about, leaves the stream
+		// at the next instance ID; anything else means the rest of this
+		// sample cannot be trusted.
+		if err := r.readInstanceSampleData(instanceId); err != nil {
+			if errors.Is(err, errBlockSkipped) {
+				continue
+			}
+			return fmt.Errorf("failed to read sample data for instance %d: %w", instanceId, err)
 		}
-		
-		instance.Stats[statId] = append(instance.Stats[statId], StatValue{
-			Timestamp: r.getCurrentTime(),
-			Value:     value,
-		})
 	}
-	
+
+	if instanceCount == 0 {
+		r.markSamplingDisabled()
+		return nil
+	}
+
+	r.endSamplingDisabled()
+	r.checkSamplingGap()
 	return nil
 }
 
-// readInstanceSample reads sample data for a single resource instance
-func (r *StatArchiveReader) readInstanceSample() error {
-	// Read instance ID
-	instanceId, err := r.readResourceInstanceId()
-	if err != nil {
-		return fmt.Errorf("failed to read instance ID: %w", err)
-	}
-	
+// readInstanceSampleData reads sample data for a specific instance
+func (r *StatArchiveReader) readInstanceSampleData(instanceId int32) error {
+	blockOffset := r.Offset()
 	instance, exists := r.instances[instanceId]
 	if !exists {
+		if r.verify != nil {
+			r.verify.violate(CheckKnownInstances, blockOffset, "sample block of unknown instance %d", instanceId)
+		}
 		return fmt.Errorf("unknown instance ID: %d", instanceId)
 	}
-	
+
 	resourceType, exists := r.resourceTypes[instance.TypeID]
 	if !exists {
+		if r.verify != nil {
+			r.verify.violate(CheckKnownTypes, blockOffset, "sample block of instance %d (%s) of unknown type %d", instanceId, instance.Name, instance.TypeID)
+		}
 		return fmt.Errorf("unknown resource type: %d", instance.TypeID)
 	}
-	
-	// Read stat offset (which stats have changed)
+
+	// Values are staged and only stored once the whole block has been read,
+	// so a corrupt block never contributes a partial sample
+	var staged []stagedValue
+
+	// Read stat offset (which stats have changed) until ILLEGAL_STAT_OFFSET.
+	// Each stat appears once at most, which bounds what is staged.
 	for {
+		if len(staged) > len(resourceType.Stats) {
+			return fmt.Errorf("block holds more values than type %s has stats", resourceType.Name)
+		}
+		r.traceField(func(l *Layout) *[]int64 { return &l.StatOffsets })
+		offsetAt := r.Offset()
 		offset, err := r.reader.ReadByte()
 		if err != nil {
 			return fmt.Errorf("failed to read stat offset: %w", err)
 		}
-		
+
 		if offset == ILLEGAL_STAT_OFFSET {
 			break // End of stats for this instance
 		}
-		
+
 		// Make sure we have a valid stat at this offset
 		if int(offset) >= len(resourceType.Stats) {
-			log.Printf("Debug: Invalid stat offset %d for instance %d (type %s has %d stats)", 
-				offset, instanceId, resourceType.Name, len(resourceType.Stats))
-			return fmt.Errorf("invalid stat offset: %d (max: %d)", offset, len(resourceType.Stats))
+			if r.strictSamples() {
+				return fmt.Errorf("invalid stat offset %d (max: %d)", offset, len(resourceType.Stats))
+			}
+			err := r.skipCorruptBlockRemainder(resourceType, offset)
+			if errors.Is(err, errBlockSkipped) {
+				// Reported where the bad offset is rather than where the
+				// skipped block ends
+				r.warnAt(offsetAt, fmt.Sprintf("invalid stat offset %d for instance %d (type %s has %d stats), skipped the rest of its block",
+					offset, instanceId, resourceType.Name, len(resourceType.Stats)))
+			}
+			return err
 		}
-		
+
 		stat := &resourceType.Stats[offset]
-		
+
 		// Read the stat value based on its type
 		value, err := r.readStatValue(stat.Type)
 		if err != nil {
 			return fmt.Errorf("failed to read stat value for %s: %w", stat.Name, err)
 		}
-		
-		// Store the stat value
-		statId := int32(offset)
-		if instance.Stats[statId] == nil {
-			instance.Stats[statId] = make([]StatValue, 0)
-		}
-		
-		instance.Stats[statId] = append(instance.Stats[statId], StatValue{
-			Timestamp: r.getCurrentTime(),
-			Value:     value,
-		})
+
+		staged = append(staged, stagedValue{statId: int32(offset), value: value})
 	}
-	
-	return nil
+
+	if r.verify != nil {
+		r.verify.block(blockOffset, instance, resourceType, staged, false)
+	}
+	return r.storeStagedValues(instance, staged)
+}
+
+// stagedValue is a decoded stat value waiting for its instance block to
+// finish
+type stagedValue struct {
+	statId int32
+	value  float64
 }
 
 // readResourceInstanceId reads a resource instance ID (with compact encoding)
@@ -752,16 +1340,16 @@ func (r *StatArchiveReader) readResourceInstanceId() (int32, error) {
 	if err != nil {
 		return 0, err
 	}
-	
+
 	// Check for ILLEGAL_RESOURCE_INST_ID_TOKEN first
 	if b == ILLEGAL_RESOURCE_INST_ID_TOKEN {
 		return -1, nil // Special marker for end of instance list
 	}
-	
+
 	if b < SHORT_RESOURCE_INST_ID_TOKEN {
 		return int32(b), nil
 	}
-	
+
 	switch b {
 	case SHORT_RESOURCE_INST_ID_TOKEN:
 		var id uint16
@@ -781,27 +1369,56 @@ func (r *StatArchiveReader) readResourceInstanceId() (int32, error) {
 }
 
 // readStatValue reads a statistic value based on its type
-func (r *StatArchiveReader) readStatValue(statType StatType) (interface{}, error) {
+func (r *StatArchiveReader) readStatValue(statType StatType) (float64, error) {
 	switch statType {
 	case StatTypeInt:
-		return r.readCompactInt()
+		v, err := r.readCompactInt()
+		return float64(v), err
 	case StatTypeLong:
-		return r.readCompactLong()
+		v, err := r.readCompactLong()
+		return float64(v), err
 	case StatTypeDouble:
 		var value float64
 		if err := binary.Read(r.reader, r.byteOrder, &value); err != nil {
-			return nil, err
+			return 0, err
 		}
 		return value, nil
 	case StatTypeFloat:
 		var value float32
 		if err := binary.Read(r.reader, r.byteOrder, &value); err != nil {
-			return nil, err
+			return 0, err
+		}
+		return float64(value), nil
+	case StatTypeBoolean:
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != 0 {
+			return 1, nil
+		}
+		return 0, nil
+	case StatTypeByte:
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		return float64(int8(b)), nil
+	case StatTypeChar:
+		var value uint16
+		if err := binary.Read(r.reader, r.byteOrder, &value); err != nil {
+			return 0, err
+		}
+		return float64(value), nil
+	case StatTypeShort:
+		var value int16
+		if err := binary.Read(r.reader, r.byteOrder, &value); err != nil {
+			return 0, err
 		}
 		return float64(value), nil
 	default:
-		// For other types, read as compact int for now
-		return r.readCompactInt()
+		v, err := r.readCompactInt()
+		return float64(v), err
 	}
 }
 
@@ -812,24 +1429,44 @@ func (r *StatArchiveReader) readCompactInt() (int32, error) {
 
 // readCompactLong reads a compact-encoded long using Apache Geode format
 func (r *StatArchiveReader) readCompactLong() (int64, error) {
-	val, err := r.readCompactValue()
+	firstByte, err := r.reader.ReadByte()
 	if err != nil {
 		return 0, err
 	}
-	return int64(val), nil
+	return r.readCompactLongFromByte(firstByte)
+}
+
+// readCompactLongFromByte decodes a compact value whose first byte has
+// already been read, reading any further bytes it needs. This is the only
+// place the multi-byte forms are decoded; narrower readers truncate its
+// result.
+func (r *StatArchiveReader) readCompactLongFromByte(firstByte byte) (int64, error) {
+	width := compactValueWidth(firstByte)
+	if width == 1 {
+		return int64(int8(firstByte)), nil
+	}
+
+	var buf [9]byte
+	encoded := buf[:width]
+	encoded[0] = firstByte
+	if _, err := io.ReadFull(r.reader, encoded[1:]); err != nil {
+		return 0, fmt.Errorf("failed to read %d-byte compact value: %w", width-1, err)
+	}
+	value, _, _ := decodeCompactValue(encoded)
+	return value, nil
 }
 
 // convertTypeCode converts Geode type codes to our internal StatType
 func convertTypeCode(typeCode byte) StatType {
 	switch typeCode {
 	case BOOLEAN_TYPE_CODE:
-		return StatTypeInt
+		return StatTypeBoolean
 	case CHAR_TYPE_CODE, WCHAR_TYPE_CODE:
-		return StatTypeInt
+		return StatTypeChar
 	case BYTE_TYPE_CODE:
-		return StatTypeInt
+		return StatTypeByte
 	case SHORT_TYPE_CODE:
-		return StatTypeInt
+		return StatTypeShort
 	case INT_TYPE_CODE:
 		return StatTypeInt
 	case LONG_TYPE_CODE:
@@ -843,397 +1480,205 @@ func convertTypeCode(typeCode byte) StatType {
 	}
 }
 
-// readSampleRobust reads a sample record with robust error handling
-func (r *StatArchiveReader) readSampleRobust() error {
-	// Read sample timestamp 
-	err := r.readSampleTimestamp()
-	if err != nil {
-		// Timestamp failure is not necessarily fatal - log and continue
-		log.Printf("Warning: Failed to read sample timestamp: %v", err)
-	}
-	
-	// Track successful extractions
-	successfulExtractions := 0
-	maxAttempts := 100 // Prevent infinite loops
-	
-	// Read instance data until we hit ILLEGAL_RESOURCE_INST_ID_TOKEN
-	for attempt := 0; attempt < maxAttempts; attempt++ {
-		nextByte, err := r.reader.ReadByte()
-		if err != nil {
-			// EOF is expected at end of file, not necessarily an error
-			if err == io.EOF {
-				log.Printf("Info: Reached EOF while reading sample (extracted %d values)", successfulExtractions)
-				return nil
-			}
-			log.Printf("Warning: Unexpected error in sample reading: %v", err)
-			return nil // Don't trigger resync for this
-		}
-		
-		if nextByte == ILLEGAL_RESOURCE_INST_ID_TOKEN {
-			break // End of sample
-		}
-		
-		// This is an instance ID - try to read its data
-		instanceId, err := r.readResourceInstanceIdFromByte(nextByte)
-		if err != nil {
-			log.Printf("Warning: Failed to read instance ID from byte %d: %v", nextByte, err)
-			continue
-		}
-		
-		// Validate instance exists
-		instance, exists := r.instances[instanceId]
-		if !exists {
-			log.Printf("Warning: Unknown instance ID %d in sample", instanceId)
-			// Try to skip this instance's data
-			r.skipInstanceStatDataSafely()
+// readCompactValue implements Apache Geode's compact value decoding for
+// int stats
+func (r *StatArchiveReader) readCompactValue() (int32, error) {
+	value, err := r.readCompactLong()
+	return int32(value), err
+}
+
+// errBlockSkipped reports that an instance's block in a sample was dropped
+// but the stream is still positioned at the next instance ID
+var errBlockSkipped = errors.New("instance block skipped")
+
+// maxBlockLookahead bounds how far ahead we look when skipping a corrupt block
+const maxBlockLookahead = 4096
+
+// skipCorruptBlockRemainder skips the rest of an instance block after an
+// invalid stat offset. The width of the value belonging to the invalid
+// offset is unknown, so each plausible width is tried and accepted only if
+// the bytes after it decode as the remainder of the block, using the exact
+// value width of every following stat, up to the terminator.
+func (r *StatArchiveReader) skipCorruptBlockRemainder(resourceType *ResourceType, badOffset byte) error {
+	data, err := r.reader.Peek(maxBlockLookahead)
+	if err != nil && len(data) == 0 {
+		return fmt.Errorf("invalid stat offset %d (max: %d): %w", badOffset, len(resourceType.Stats), err)
+	}
+
+	candidates := []int{compactValueWidth(data[0]), 1, 2, 4, 8}
+	for i, width := range candidates {
+		if width > len(data) || containsInt(candidates[:i], width) {
 			continue
 		}
-		
-		// Validate resource type exists
-		resourceType, exists := r.resourceTypes[instance.TypeID]
-		if !exists {
-			log.Printf("Warning: Unknown resource type %d for instance %d", instance.TypeID, instanceId)
-			r.skipInstanceStatDataSafely()
+
+		remainder, ok := blockRemainderLength(resourceType, data[width:])
+		if !ok {
 			continue
 		}
-		
-		// Try to read stat data for this instance
-		extracted, err := r.readInstanceStatDataRobust(instanceId, instance, resourceType)
-		if err != nil {
-			log.Printf("Warning: Failed to read stats for instance %d (%s): %v", instanceId, instance.Name, err)
-			continue
+
+		if _, err := r.reader.Discard(width + remainder); err != nil {
+			return fmt.Errorf("failed to skip corrupt block: %w", err)
 		}
-		
-		successfulExtractions += extracted
-	}
-	
-	if successfulExtractions > 0 {
-		log.Printf("Successfully extracted %d metric values from sample", successfulExtractions)
+		return fmt.Errorf("%w: invalid stat offset %d (max: %d)", errBlockSkipped, badOffset, len(resourceType.Stats))
 	}
-	
-	// Always return nil - let the parser continue even if no data extracted
-	return nil
+
+	return fmt.Errorf("invalid stat offset %d (max: %d) and no consistent block end found", badOffset, len(resourceType.Stats))
 }
 
-// skipInstanceStatDataSafely safely skips stat data when instance is invalid
-func (r *StatArchiveReader) skipInstanceStatDataSafely() {
-	// Try to skip up to 1000 bytes looking for ILLEGAL_STAT_OFFSET
-	for i := 0; i < 1000; i++ {
-		b, err := r.reader.ReadByte()
-		if err != nil {
-			return // EOF or error, just return
-		}
-		if b == ILLEGAL_STAT_OFFSET {
-			return // Found end marker
-		}
-	}
+// blockRemainderLength returns how many bytes of data make up the rest of
+// an instance block, including its terminator, if data decodes as one
+func blockRemainderLength(resourceType *ResourceType, data []byte) (int, bool) {
+	n, _, result := scanBlockRemainder(resourceType, data)
+	return n, result == scanMatch
 }
 
-// readInstanceStatDataRobust reads stat data for an instance with error handling
-func (r *StatArchiveReader) readInstanceStatDataRobust(instanceId int32, instance *ResourceInstance, resourceType *ResourceType) (int, error) {
-	extracted := 0
-	maxStats := 1000 // Safety limit
-	
-	// Read stat offsets until ILLEGAL_STAT_OFFSET
-	for statCount := 0; statCount < maxStats; statCount++ {
-		offset, err := r.reader.ReadByte()
-		if err != nil {
-			return extracted, fmt.Errorf("failed to read stat offset: %w", err)
-		}
-		
-		if offset == ILLEGAL_STAT_OFFSET {
-			break // End of stats for this instance
-		}
-		
-		// FIXED: Stat offsets can be 0-254, not just 0-127
-		// Only 255 (ILLEGAL_STAT_OFFSET) terminates the stat list
-		// Validate stat offset
-		if int(offset) >= len(resourceType.Stats) {
-			log.Printf("Warning: Invalid stat offset %d for instance %d (type %s has %d stats)", 
-				offset, instanceId, resourceType.Name, len(resourceType.Stats))
-			// Try to skip this stat value
-			r.skipStatValueSafely()
-			continue
-		}
-		
-		stat := &resourceType.Stats[offset]
-		
-		// Try to read the stat value based on its type
-		value, err := r.readStatValueSafely(stat.Type)
-		if err != nil {
-			log.Printf("Warning: Failed to read stat value for %s.%s: %v", resourceType.Name, stat.Name, err)
-			continue
-		}
-		
-		// Store the stat value
-		statId := int32(offset)
-		if instance.Stats[statId] == nil {
-			instance.Stats[statId] = make([]StatValue, 0)
-		}
-		
-		instance.Stats[statId] = append(instance.Stats[statId], StatValue{
-			Timestamp: r.getCurrentTime(),
-			Value:     value,
-		})
-		
-		extracted++
+// statValueWidth returns the number of bytes readStatValue consumes for a
+// value of the given type starting with firstByte
+func statValueWidth(statType StatType, firstByte byte) int {
+	switch statType {
+	case StatTypeDouble:
+		return 8
+	case StatTypeFloat:
+		return 4
+	case StatTypeBoolean, StatTypeByte:
+		return 1
+	case StatTypeChar, StatTypeShort:
+		return 2
+	default:
+		return compactValueWidth(firstByte)
 	}
-	
-	return extracted, nil
 }
 
-// skipStatValueSafely tries to skip a stat value when we can't parse it properly
-func (r *StatArchiveReader) skipStatValueSafely() {
-	// Try reading as compact int first (most common)
-	_, err := r.readCompactInt()
-	if err != nil {
-		// If that fails, just skip a single byte
-		r.reader.ReadByte()
+// decodeCompactValue decodes the compact value at the start of data and
+// returns it with its encoded size, or ok false if data ends inside it.
+// Bytes down to MIN_1BYTE_COMPACT_VALUE are the value itself; lower ones
+// are tokens for the number of big-endian bytes that follow.
+func decodeCompactValue(data []byte) (value int64, width int, ok bool) {
+	if len(data) == 0 {
+		return 0, 0, false
+	}
+	width = compactValueWidth(data[0])
+	if len(data) < width {
+		return 0, 0, false
+	}
+	if width == 1 {
+		return int64(int8(data[0])), 1, true
 	}
+
+	// The first byte after the token carries the sign
+	value = int64(int8(data[1]))
+	for _, b := range data[2:width] {
+		value = value<<8 | int64(b)
+	}
+	return value, width, true
 }
 
-// readStatValueSafely reads a stat value with additional error handling
-func (r *StatArchiveReader) readStatValueSafely(statType StatType) (interface{}, error) {
+// decodeStatValue decodes the value of the given type at the start of data
+// as readStatValue reads it, and returns it with its encoded size, or ok
+// false if data ends inside it
+func (r *StatArchiveReader) decodeStatValue(statType StatType, data []byte) (value float64, width int, ok bool) {
+	if len(data) == 0 {
+		return 0, 0, false
+	}
+	width = statValueWidth(statType, data[0])
+	if len(data) < width {
+		return 0, 0, false
+	}
 	switch statType {
-	case StatTypeInt:
-		return r.readCompactIntSafely()
-	case StatTypeLong:
-		return r.readCompactLongSafely()
 	case StatTypeDouble:
-		var value float64
-		if err := binary.Read(r.reader, r.byteOrder, &value); err != nil {
-			return nil, err
-		}
-		// Validate the double value is reasonable
-		if value > 1e15 || value < -1e15 {
-			return nil, fmt.Errorf("unreasonable double value: %f", value)
-		}
-		return value, nil
+		return math.Float64frombits(r.byteOrder.Uint64(data)), width, true
 	case StatTypeFloat:
-		var value float32
-		if err := binary.Read(r.reader, r.byteOrder, &value); err != nil {
-			return nil, err
-		}
-		// Validate the float value is reasonable
-		if value > 1e10 || value < -1e10 {
-			return nil, fmt.Errorf("unreasonable float value: %f", value)
-		}
-		return float64(value), nil
+		return float64(math.Float32frombits(r.byteOrder.Uint32(data))), width, true
+	case StatTypeBoolean:
+		if data[0] != 0 {
+			return 1, width, true
+		}
+		return 0, width, true
+	case StatTypeByte:
+		return float64(int8(data[0])), width, true
+	case StatTypeChar:
+		return float64(r.byteOrder.Uint16(data)), width, true
+	case StatTypeShort:
+		return float64(int16(r.byteOrder.Uint16(data))), width, true
+	case StatTypeLong:
+		compact, _, _ := decodeCompactValue(data)
+		return float64(compact), width, true
 	default:
-		// For other types, try compact int
-		return r.readCompactIntSafely()
+		compact, _, _ := decodeCompactValue(data)
+		return float64(int32(compact)), width, true
 	}
 }
 
-// readCompactIntSafely reads compact int using Apache Geode encoding format
-func (r *StatArchiveReader) readCompactIntSafely() (int32, error) {
-	return r.readCompactValue()
-}
+// compactValueWidth returns the encoded size of a compact value starting
+// with firstByte
+func compactValueWidth(firstByte byte) int {
+	token := int8(firstByte)
 
-// readCompactValue implements Apache Geode's compact value decoding
-func (r *StatArchiveReader) readCompactValue() (int32, error) {
-	firstByte, err := r.reader.ReadByte()
-	if err != nil {
-		return 0, err
+	if token >= MIN_1BYTE_COMPACT_VALUE {
+		return 1
 	}
-	
-	// Convert to signed byte for proper comparison
-	signedFirstByte := int8(firstByte)
-	
-	// Single byte values: -128 to 127 stored as-is
-	if signedFirstByte >= MIN_1BYTE_COMPACT_VALUE && signedFirstByte <= MAX_1BYTE_COMPACT_VALUE {
-		return int32(signedFirstByte), nil
-	}
-	
-	// Two byte values: token -1 followed by a short
-	if signedFirstByte == COMPACT_VALUE_2_TOKEN {
-		var value int16
-		if err := binary.Read(r.reader, r.byteOrder, &value); err != nil {
-			return 0, fmt.Errorf("failed to read 2-byte compact value: %w", err)
-		}
-		return int32(value), nil
-	}
-	
-	// Multi-byte values: tokens -2, -3, -4, etc. indicate number of bytes
-	if signedFirstByte < COMPACT_VALUE_2_TOKEN {
-		numBytes := int(COMPACT_VALUE_2_TOKEN - signedFirstByte + 2)
-		if numBytes > 8 {
-			return 0, fmt.Errorf("invalid compact value byte count: %d", numBytes)
-		}
-		
-		// Read the bytes
-		bytes := make([]byte, numBytes)
-		if _, err := r.reader.Read(bytes); err != nil {
-			return 0, fmt.Errorf("failed to read %d-byte compact value: %w", numBytes, err)
-		}
-		
-		// Reconstruct the value (bytes are in little-endian order from encoding)
-		var value int64 = 0
-		for i := numBytes - 1; i >= 0; i-- {
-			value = (value << 8) | int64(bytes[i]&0xFF)
-		}
-		
-		// Handle sign extension for negative numbers
-		if (bytes[numBytes-1] & 0x80) != 0 {
-			// Negative number - sign extend
-			for i := numBytes; i < 8; i++ {
-				value |= (0xFF << uint(i*8))
-			}
-		}
-		
-		return int32(value), nil
+	if token == COMPACT_VALUE_2_TOKEN {
+		return 3
 	}
-	
-	return 0, fmt.Errorf("invalid compact value token: %d", signedFirstByte)
+	return 1 + int(token-COMPACT_VALUE_2_TOKEN) + 2
 }
 
-// readCompactValueFromByte reads a compact value when we already have the first byte
-func (r *StatArchiveReader) readCompactValueFromByte(firstByte byte) (int32, error) {
-	// Convert to signed byte for proper comparison
-	signedFirstByte := int8(firstByte)
-	
-	// Single byte values: -128 to 127 stored as-is
-	if signedFirstByte >= MIN_1BYTE_COMPACT_VALUE && signedFirstByte <= MAX_1BYTE_COMPACT_VALUE {
-		return int32(signedFirstByte), nil
-	}
-	
-	// Two byte values: token -1 followed by a short
-	if signedFirstByte == COMPACT_VALUE_2_TOKEN {
-		var value int16
-		if err := binary.Read(r.reader, r.byteOrder, &value); err != nil {
-			return 0, fmt.Errorf("failed to read 2-byte compact value: %w", err)
-		}
-		return int32(value), nil
-	}
-	
-	// Multi-byte values: tokens -2, -3, -4, etc. indicate number of bytes
-	if signedFirstByte < COMPACT_VALUE_2_TOKEN {
-		numBytes := int(COMPACT_VALUE_2_TOKEN - signedFirstByte + 2)
-		if numBytes > 8 {
-			return 0, fmt.Errorf("invalid compact value byte count: %d", numBytes)
-		}
-		
-		// Read the bytes
-		bytes := make([]byte, numBytes)
-		if _, err := r.reader.Read(bytes); err != nil {
-			return 0, fmt.Errorf("failed to read %d-byte compact value: %w", numBytes, err)
+func containsInt(values []int, v int) bool {
+	for _, existing := range values {
+		if existing == v {
+			return true
 		}
-		
-		// Reconstruct the value (bytes are in little-endian order from encoding)
-		var value int64 = 0
-		for i := numBytes - 1; i >= 0; i-- {
-			value = (value << 8) | int64(bytes[i]&0xFF)
-		}
-		
-		// Handle sign extension for negative numbers
-		if (bytes[numBytes-1] & 0x80) != 0 {
-			// Negative number - sign extend
-			for i := numBytes; i < 8; i++ {
-				value |= (0xFF << uint(i*8))
-			}
-		}
-		
-		return int32(value), nil
 	}
-	
-	return 0, fmt.Errorf("invalid compact value token: %d", signedFirstByte)
+	return false
 }
 
-// readCompactLongSafely reads compact long using Apache Geode encoding
-func (r *StatArchiveReader) readCompactLongSafely() (int64, error) {
-	val, err := r.readCompactValue()
-	if err != nil {
-		return 0, err
+// checkSamplingGap records a gap when the current sample is further from the
+// previous one than the configured threshold
+func (r *StatArchiveReader) checkSamplingGap() {
+	previous := r.lastSampleTimeStamp
+	r.lastSampleTimeStamp = r.currentTimeStamp
+
+	if r.gapThreshold <= 0 || previous == 0 {
+		return
 	}
-	return int64(val), nil
-}
 
-// skipInstanceStatData skips stat data for an instance in a sample
-func (r *StatArchiveReader) skipInstanceStatData() error {
-	// Skip stat offsets until ILLEGAL_STAT_OFFSET
-	for {
-		offset, err := r.reader.ReadByte()
-		if err != nil {
-			return fmt.Errorf("failed to read stat offset: %w", err)
-		}
-		
-		if offset == ILLEGAL_STAT_OFFSET {
-			break // End of stats for this instance
-		}
-		
-		// Skip the stat value - we don't know the type, so try compact int first
-		_, err = r.readCompactInt()
-		if err != nil {
-			// If compact int fails, try reading a single byte
-			_, err = r.reader.ReadByte()
-			if err != nil {
-				return fmt.Errorf("failed to skip stat value: %w", err)
-			}
-		}
+	if time.Duration(r.currentTimeStamp-previous)*time.Millisecond > r.gapThreshold {
+		r.samplingGaps = append(r.samplingGaps, SamplingGap{
+			Start: r.toTime(previous),
+			End:   r.getCurrentTime(),
+		})
 	}
-	
-	return nil
 }
 
-// resyncToNextToken attempts to find the next valid token after corruption
-func (r *StatArchiveReader) resyncToNextToken() error {
-	log.Printf("Warning: Attempting to resync parser after corruption - this may skip valid data")
-	
-	// Look ahead for valid tokens
-	validTokens := []byte{
-		RESOURCE_TYPE_TOKEN,
-		RESOURCE_INSTANCE_CREATE_TOKEN,
-		RESOURCE_INSTANCE_DELETE_TOKEN,
-		SAMPLE_TOKEN,
-		HEADER_TOKEN,
-	}
-	
-	// Read up to 50 bytes looking for a valid token (reduced from 1000 to be less aggressive)
-	for i := 0; i < 50; i++ {
-		b, err := r.reader.ReadByte()
-		if err != nil {
-			return fmt.Errorf("failed to resync: %w", err)
-		}
-		
-		// Check if this byte is a valid token
-		for _, token := range validTokens {
-			if b == token {
-				// Found a potential token - verify by checking what follows
-				if r.isValidTokenSequence(b) {
-					log.Printf("Resynced at token 0x%02x after skipping %d bytes", b, i)
-					// CRITICAL FIX: We need to "unread" this token so it gets processed
-					// Since bufio.Reader doesn't have UnreadByte, we'll use a hack
-					// by seeking back 1 byte
-					currentPos, _ := r.file.Seek(0, 1) // Get current position
-					r.file.Seek(currentPos-1, 0)      // Go back 1 byte
-					// Reset the reader to re-read from the new position
-					r.reader = bufio.NewReader(r.file)
-					return nil
-				}
-			}
-		}
+// markSamplingDisabled extends the current sampling disabled interval to
+// the current sample, starting one if needed
+func (r *StatArchiveReader) markSamplingDisabled() {
+	if r.disabledStart == 0 {
+		r.disabledStart = r.currentTimeStamp
 	}
-	
-	return fmt.Errorf("failed to resync within 50 bytes")
+	r.disabledEnd = r.currentTimeStamp
 }
 
-// isValidTokenSequence checks if a token is followed by valid data
-func (r *StatArchiveReader) isValidTokenSequence(token byte) bool {
-	// This is a simple heuristic - for resource types, check if followed by reasonable type ID
-	if token == RESOURCE_TYPE_TOKEN {
-		// Peek at next 4 bytes to see if they look like a reasonable type ID
-		data, err := r.reader.Peek(4)
-		if err != nil || len(data) < 4 {
-			return false
-		}
-		
-		typeId := binary.BigEndian.Uint32(data)
-		// Reasonable type IDs are usually small positive numbers
-		return typeId < 10000
-	}
-	
-	// For other tokens, assume they're valid
-	return true
+// endSamplingDisabled closes an open sampling disabled interval at the
+// current sample. The time since the last sample with data is explained by
+// the interval, so it is not checked for a sampling gap.
+func (r *StatArchiveReader) endSamplingDisabled() {
+	if r.disabledStart == 0 {
+		return
+	}
+
+	interval := SamplingGap{
+		Start: r.toTime(r.disabledStart),
+		End:   r.toTime(r.disabledEnd),
+	}
+	if r.currentTimeStamp > r.disabledEnd {
+		interval.End = r.getCurrentTime()
+	}
+	r.samplingDisabled = append(r.samplingDisabled, interval)
+	r.logger.Debugf("Statistic sampling was disabled from %s to %s", interval.Start.Format(time.RFC3339), interval.End.Format(time.RFC3339))
+
+	r.disabledStart = 0
+	r.lastSampleTimeStamp = r.currentTimeStamp
 }
 
 // Helper function to get the current timestamp as time.Time
@@ -1241,216 +1686,203 @@ func (r *StatArchiveReader) getCurrentTime() time.Time {
 	if r.currentTimeStamp <= 0 {
 		return time.Now()
 	}
-	return time.Unix(0, r.currentTimeStamp*int64(time.Millisecond))
+	return r.toTime(r.currentTimeStamp)
 }
 
-// parseBinarySamples parses the binary sample data section using the discovered format
-func (r *StatArchiveReader) parseBinarySamples() int {
-	log.Printf("Starting binary sample parsing")
-	
-	// Get file info for positioning
-	fileInfo, err := r.file.Stat()
-	if err != nil {
-		log.Printf("Warning: Failed to get file info: %v", err)
-		return 0
+// parseBinarySamples parses the binary sample data section using the discovered format.
+// The section starts at the first sample record found by ReadArchive, which
+// must have been called first.
+func (r *StatArchiveReader) parseBinarySamples() (int, error) {
+	r.logger.Debugf("Starting binary sample parsing")
+
+	if r.metadataEnd <= 0 {
+		return 0, fmt.Errorf("binary sample section not found: no sample record was read after the metadata")
 	}
-	
-	// Jump to the binary sample section at position ~91,900
-	binarySamplePos := int64(91900)
-	_, err = r.file.Seek(binarySamplePos, 0)
+	binarySamplePos := r.metadataEnd
+
+	// Read the archive again from the start, as it may be compressed
+	seeker, ok := r.input.(io.ReadSeeker)
+	if !ok {
+		return 0, fmt.Errorf("binary sample pass needs a seekable archive")
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to rewind archive: %w", err)
+	}
+	src, err := decompress(seeker)
 	if err != nil {
-		log.Printf("Warning: Failed to seek to binary sample position: %v", err)
-		return 0
+		return 0, err
+	}
+	if _, err := io.CopyN(io.Discard, src, binarySamplePos); err != nil {
+		return 0, fmt.Errorf("failed to skip to binary sample section at %d: %w", binarySamplePos, err)
+	}
+
+	// Read the binary sample section as far as ReadArchive got, rather
+	// than whatever is left of the file
+	sectionSize := r.report.BytesRead - binarySamplePos
+	if sectionSize > r.limits.MaxSampleBytes {
+		return 0, &ErrLimitExceeded{What: "binary sample section size", Value: sectionSize, Limit: r.limits.MaxSampleBytes}
 	}
-	
-	// Read remaining data from binary sample section
-	remaining := fileInfo.Size() - binarySamplePos
-	data := make([]byte, remaining)
-	n, err := r.file.Read(data)
+	data, err := io.ReadAll(io.LimitReader(src, sectionSize))
 	if err != nil {
-		log.Printf("Warning: Failed to read binary sample data: %v", err)
-		return 0
+		return 0, fmt.Errorf("failed to read binary sample section: %w", err)
 	}
-	
-	log.Printf("Reading %d bytes from position %d to end for binary sample parsing", n, binarySamplePos)
-	
+	n := len(data)
+
+	r.logger.Debugf("Reading %d bytes from position %d to end for binary sample parsing", n, binarySamplePos)
+
 	// Create lookup maps for faster access
 	instanceMap := make(map[int32]*ResourceInstance)
 	typeMap := make(map[int32]*ResourceType)
-	
+
 	for id, instance := range r.instances {
 		instanceMap[id] = instance
 	}
-	
+
 	for id, resType := range r.resourceTypes {
 		typeMap[id] = resType
 	}
-	
+
 	// Parse binary sample data using proper GFS sample record format
 	sampleCount := 0
-	startTime := time.Unix(0, r.startTimeStamp*int64(time.Millisecond))
-	
-	log.Printf("Parsing GFS sample records starting from: %s", 
+	startTime := r.toTime(r.startTimeStamp)
+
+	r.logger.Debugf("Parsing GFS sample records starting from: %s",
 		startTime.Format("15:04:05.000"))
-	
+
 	// Running timestamp - starts at archive start time and accumulates deltas
 	runningTimestamp := r.startTimeStamp // in milliseconds
-	
+
 	for i := 0; i < n-6; i++ {
 		// Look for SAMPLE_TOKEN (0x00) which marks start of sample record
 		if data[i] == 0x00 { // SAMPLE_TOKEN
 			pos := i + 1
-			
+
 			// Read timestamp delta (2 bytes unsigned short)
 			if pos+2 > n {
 				break
 			}
-			
-			timestampDelta := binary.BigEndian.Uint16(data[pos:pos+2])
+
+			timestampDelta := int64(r.byteOrder.Uint16(data[pos : pos+2]))
 			pos += 2
-			
+
 			// Handle special case for large deltas
-			if timestampDelta == 65535 { // INT_TIMESTAMP_TOKEN
+			if timestampDelta == INT_TIMESTAMP_TOKEN {
 				if pos+4 > n {
 					break
 				}
 				// Read 4-byte integer delta
-				largeDelta := binary.BigEndian.Uint32(data[pos:pos+4])
-				timestampDelta = uint16(largeDelta & 0xFFFF) // Use lower 16 bits for now
+				timestampDelta = int64(int32(r.byteOrder.Uint32(data[pos : pos+4])))
 				pos += 4
 			}
-			
+
 			// Update running timestamp
-			runningTimestamp += int64(timestampDelta)
-			currentTime := time.Unix(0, runningTimestamp*int64(time.Millisecond))
-			
+			runningTimestamp += timestampDelta
+			currentTime := r.toTime(runningTimestamp)
+
 			// Now read resource instances and their changed stats
 			samplesInRecord := 0
-			
+
 			// Read resource instance IDs until ILLEGAL_RESOURCE_INST_ID (-1 / 0xFF)
 			for pos < n-1 {
 				resourceInstId := data[pos]
 				pos++
-				
+
 				if resourceInstId == 0xFF { // ILLEGAL_RESOURCE_INST_ID - end of sample
 					break
 				}
-				
+
 				// For each resource instance, read changed stat values
 				// Read stat offsets until ILLEGAL_STAT_OFFSET (255)
 				for pos < n-3 {
 					statOffset := data[pos]
 					pos++
-					
+
 					if statOffset == 255 { // ILLEGAL_STAT_OFFSET - end of stats for this instance
 						break
 					}
-					
-					// Read compact value according to Apache Geode format
+
 					if pos >= n {
 						break
 					}
-					
-					value, bytesRead := r.readCompactValueFromBytes(data[pos:])
-					if bytesRead == 0 {
+
+					// The value is as wide as its stat's type; without a
+					// known stat it is read as a compact value
+					instance := instanceMap[int32(resourceInstId)]
+					var resType *ResourceType
+					if instance != nil {
+						resType = typeMap[instance.TypeID]
+					}
+					statType := StatTypeInt
+					known := resType != nil && int(statOffset) < len(resType.Stats)
+					if known {
+						statType = resType.Stats[statOffset].Type
+					}
+					value, bytesRead, ok := r.decodeStatValue(statType, data[pos:])
+					if !ok {
 						break
 					}
 					pos += bytesRead
-					
-					// Find the instance and store the value
-					instance := instanceMap[int32(resourceInstId)]
-					if instance != nil {
-						resType := typeMap[instance.TypeID]
-						if resType != nil && int(statOffset) < len(resType.Stats) {
-							// Store all time-series data - let converter filter later  
-							// Focus on capturing all data first, then filter in converter
-							if value >= 0 { // Only filter out clearly invalid negative values
-								statId := int32(statOffset)
-								if instance.Stats[statId] == nil {
-									instance.Stats[statId] = make([]StatValue, 0)
-								}
-								
-								instance.Stats[statId] = append(instance.Stats[statId], StatValue{
-									Timestamp: currentTime,
-									Value:     int32(value),
-								})
-								
-								samplesInRecord++
-								sampleCount++
-							}
+
+					if known {
+						// Store every value, negative ones included; which
+						// values are valid is up to the converter
+						statId := int32(statOffset)
+						if instance.Stats[statId] == nil {
+							instance.Stats[statId] = make([]StatValue, 0)
 						}
+
+						instance.Stats[statId] = append(instance.Stats[statId], StatValue{
+							Timestamp: currentTime.UnixMilli(),
+							Value:     value,
+						})
+
+						samplesInRecord++
+						sampleCount++
 					}
 				}
 			}
-			
+
 			// Log progress with real timestamps
 			if sampleCount%1000 == 0 && samplesInRecord > 0 {
-				log.Printf("Sample record parsed: %d total samples, timestamp: %s", 
+				r.logger.Debugf("Sample record parsed: %d total samples, timestamp: %s",
 					sampleCount, currentTime.Format("15:04:05.000"))
 			}
-			
+
 			// Move to position after this sample record
 			i = pos - 1
 		}
 	}
-	
-	log.Printf("Binary sample parsing completed: extracted %d total samples", sampleCount)
-	
+
+	r.logger.Debugf("Binary sample parsing completed: extracted %d total samples", sampleCount)
+
 	// Log detailed metrics by instance
-	for instanceID, instance := range r.instances {
+	for _, instance := range SortedInstances(r.instances) {
+		instanceID := instance.ID
 		resType := typeMap[instance.TypeID]
 		if resType == nil {
 			continue
 		}
-		
+
 		totalSamples := 0
-		for statID, values := range instance.Stats {
+		for _, statID := range SortedStatIDs(instance.Stats) {
+			values := instance.Stats[statID]
 			totalSamples += len(values)
-			
+
 			// Log details for key metrics like delayDuration
 			if statID < int32(len(resType.Stats)) {
 				stat := resType.Stats[statID]
 				if stat.Name == "delayDuration" && len(values) > 0 {
-					log.Printf("Instance %d (%s.%s) delayDuration: %d samples, last value: %v", 
+					r.logger.Debugf("Instance %d (%s.%s) delayDuration: %d samples, last value: %v",
 						instanceID, resType.Name, instance.Name, len(values), values[len(values)-1].Value)
 				}
 			}
 		}
-		
+
 		if totalSamples > 0 {
-			log.Printf("Instance %d (%s.%s): %d total samples across %d stats", 
+			r.logger.Debugf("Instance %d (%s.%s): %d total samples across %d stats",
 				instanceID, resType.Name, instance.Name, totalSamples, len(instance.Stats))
 		}
 	}
-	
-	return sampleCount
-}
 
-// readCompactValueFromBytes reads a compact value from a byte slice and returns (value, bytesRead)
-func (r *StatArchiveReader) readCompactValueFromBytes(data []byte) (int32, int) {
-	if len(data) == 0 {
-		return 0, 0
-	}
-	
-	// Read first byte
-	firstByte := data[0]
-	
-	// Special case: 0xFF (255) is COMPACT_VALUE_2_TOKEN, indicates 2-byte value follows
-	if firstByte == 0xFF {
-		if len(data) < 3 {
-			return 0, 0
-		}
-		// Read next 2 bytes as big-endian signed int16
-		value := int16(binary.BigEndian.Uint16(data[1:3]))
-		return int32(value), 3
-	}
-	
-	// For other values, check if it's in signed byte range
-	signedByte := int8(firstByte)
-	if signedByte >= MIN_1BYTE_COMPACT_VALUE && signedByte <= MAX_1BYTE_COMPACT_VALUE {
-		return int32(signedByte), 1
-	}
-	
-	// Values 128-254 as unsigned
-	return int32(firstByte), 1
-}
\ No newline at end of file
+	return sampleCount, nil
+}
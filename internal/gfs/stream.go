@@ -0,0 +1,98 @@
+package gfs
+
+import (
+	"errors"
+	"time"
+)
+
+// SampleFunc receives one stat value as it is decoded. Returning an error
+// stops reading; ReadArchiveStream then returns that error.
+type SampleFunc func(instance *ResourceInstance, stat *StatDescriptor, timestamp time.Time, value float64) error
+
+// DeleteFunc receives an instance deleted while streaming, after its last
+// value, with its DeletionTime set: by a delete record, by a create record
+// reusing its id without one, or by another archive appended to the file.
+// Returning an error stops reading as for a SampleFunc.
+type DeleteFunc func(instance *ResourceInstance) error
+
+// streamStopped carries an error returned by a SampleFunc out of the
+// record loop, which would otherwise treat it as a corrupt record
+type streamStopped struct {
+	err error
+}
+
+func (e *streamStopped) Error() string {
+	return e.err.Error()
+}
+
+func (e *streamStopped) Unwrap() error {
+	return e.err
+}
+
+// ReadArchiveStream reads the archive like ReadArchive, but hands every
+// decoded value to fn instead of keeping it: only resource types and
+// instances stay in memory, and GetInstances returns instances without
+// samples. Errors are classified as for ReadArchive, except that an error
+// from fn ends reading and is returned as is.
+func (r *StatArchiveReader) ReadArchiveStream(fn SampleFunc) error {
+	r.sampleFunc = fn
+	defer func() { r.sampleFunc = nil }()
+
+	err := r.ReadArchive()
+	var stopped *streamStopped
+	if errors.As(err, &stopped) {
+		return stopped.err
+	}
+	return err
+}
+
+// SetDeleteFunc makes ReadArchiveStream call fn for every instance deleted
+// during the read
+func (r *StatArchiveReader) SetDeleteFunc(fn DeleteFunc) {
+	r.deleteFunc = fn
+}
+
+// instanceDeleted hands a retired instance to the DeleteFunc while
+// streaming
+func (r *StatArchiveReader) instanceDeleted(instance *ResourceInstance) error {
+	if r.sampleFunc == nil || r.deleteFunc == nil {
+		return nil
+	}
+	if err := r.deleteFunc(instance); err != nil {
+		return &streamStopped{err: err}
+	}
+	return nil
+}
+
+// storeStagedValues hands a completed block's values to the stream
+// callback or, when not streaming, appends them to the instance
+func (r *StatArchiveReader) storeStagedValues(instance *ResourceInstance, staged []stagedValue) error {
+	if r.timeJump.drop {
+		if r.verify != nil {
+			r.verify.verification.DroppedValues += int64(len(staged))
+		}
+		return nil
+	}
+	timestamp := r.valueTime()
+	if r.sampleFunc == nil {
+		millis := timestamp.UnixMilli()
+		for _, v := range staged {
+			instance.Stats[v.statId] = append(instance.Stats[v.statId], StatValue{
+				Timestamp: millis,
+				Value:     v.value,
+			})
+		}
+		return nil
+	}
+
+	resourceType := r.resourceTypes[instance.TypeID]
+	for _, v := range staged {
+		if err := r.sampleFunc(instance, &resourceType.Stats[v.statId], timestamp, v.value); err != nil {
+			return &streamStopped{err: err}
+		}
+	}
+	if r.verify != nil {
+		r.verify.streamed += int64(len(staged))
+	}
+	return nil
+}
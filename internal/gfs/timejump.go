@@ -0,0 +1,110 @@
+package gfs
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxTimeJump is the furthest back a sample timestamp may go before its
+// delta is taken for damage rather than a clock stepped back
+const maxTimeJump = 24 * time.Hour
+
+// Time jump policies, selected with SetTimeJumpPolicy
+const (
+	// TimeJumpsDrop drops the values of sample records whose timestamp is
+	// not after the latest one before a backwards time jump, which the
+	// TSDB would reject as out of order
+	TimeJumpsDrop = "drop"
+	// TimeJumpsClamp moves those values to a millisecond after the latest
+	// timestamp given to values, so every sample is kept in order
+	TimeJumpsClamp = "clamp"
+	// TimeJumpsKeep keeps them at the timestamps the archive gives them,
+	// for a TSDB that accepts out-of-order samples
+	TimeJumpsKeep = "keep"
+)
+
+// ValidTimeJumpPolicy reports whether policy is a known time jump policy.
+// The empty string selects TimeJumpsDrop.
+func ValidTimeJumpPolicy(policy string) bool {
+	switch policy {
+	case "", TimeJumpsDrop, TimeJumpsClamp, TimeJumpsKeep:
+		return true
+	}
+	return false
+}
+
+// SetTimeJumpPolicy selects what happens to the samples recorded after the
+// clock of the member went back, until it passes the latest sample before
+// the jump. It must be called before ReadArchive.
+func (r *StatArchiveReader) SetTimeJumpPolicy(policy string) {
+	r.timeJumps = policy
+}
+
+// TimeJump is a sample record whose timestamp is before the one of the
+// sample record before it, as when NTP steps the member's clock back.
+// Offset is where the record starts and Delta how far back it goes, in
+// milliseconds.
+type TimeJump struct {
+	Offset int64     `json:"offset"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Delta  int64     `json:"delta_ms"`
+}
+
+func (j TimeJump) String() string {
+	return fmt.Sprintf("%s: time went back %s from %s to %s", offsetLabel(j.Offset),
+		time.Duration(j.Delta)*time.Millisecond, j.From.Format(time.RFC3339Nano), j.To.Format(time.RFC3339Nano))
+}
+
+// timeJumpState follows the timestamps of the sample records of an archive
+// to apply the time jump policy
+type timeJumpState struct {
+	latest int64 // The latest timestamp given to values
+	behind bool  // A jump went back and the clock has not caught up yet
+	// drop is set while the values of the current sample record are
+	// dropped, and clamped to the timestamp they are given instead when
+	// they are moved
+	drop    bool
+	clamped int64
+}
+
+// checkTimeJump records a backwards time jump at the sample record starting
+// at offset and applies the time jump policy to its values
+func (r *StatArchiveReader) checkTimeJump(offset int64) {
+	s := &r.timeJump
+	s.drop, s.clamped = false, 0
+	now := r.currentTimeStamp
+	if now < r.previousTimeStamp {
+		jump := TimeJump{
+			Offset: offset,
+			From:   r.toTime(r.previousTimeStamp),
+			To:     r.toTime(now),
+			Delta:  r.previousTimeStamp - now,
+		}
+		r.report.TimeJumps = append(r.report.TimeJumps, jump)
+		r.logger.Debugf("Sample record at %s goes back %d ms", offsetLabel(offset), jump.Delta)
+		s.behind = true
+	}
+	if r.timeJumps == TimeJumpsKeep || !s.behind || now > s.latest {
+		s.behind = false
+		s.latest = now
+		return
+	}
+
+	r.report.RegressedSamples++
+	if r.timeJumps == TimeJumpsClamp {
+		s.latest++
+		s.clamped = s.latest
+		return
+	}
+	s.drop = true
+}
+
+// valueTime returns the timestamp given to the values being read, which
+// the time jump policy may have moved from the current one
+func (r *StatArchiveReader) valueTime() time.Time {
+	if r.timeJump.clamped != 0 {
+		return r.toTime(r.timeJump.clamped)
+	}
+	return r.getCurrentTime()
+}
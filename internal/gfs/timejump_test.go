@@ -0,0 +1,80 @@
+package gfs_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+)
+
+// TestTimeJumpPolicies writes an archive whose clock goes back 2.5s after
+// the sixth sample, and passes that sample again at the ninth, and checks
+// that the jump is reported where it is and what each time jump policy
+// makes of the samples before the clock catches up
+func TestTimeJumpPolicies(t *testing.T) {
+	millis := []int64{1000, 2000, 3000, 4000, 5000, 6000, 3500, 4500, 5500, 6500, 7500}
+	var archive bytes.Buffer
+	w, err := gfs.NewArchiveWriter(&archive, gfs.ArchiveHeader{StartTime: testStart, SystemStartTime: testStart})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resType := &gfs.ResourceType{Name: gfstest.TypeName(0), Stats: []gfs.StatDescriptor{{Name: "value", Type: gfs.StatTypeDouble}}}
+	if err := w.WriteResourceType(resType); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CreateInstance(0, gfstest.InstanceName(0, 0), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	jumpAt := 0
+	for k, at := range millis {
+		if k == 6 {
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			jumpAt = archive.Len()
+		}
+		sample := gfs.InstanceSample{InstanceID: 0, Values: map[int]float64{0: float64(k)}}
+		if err := w.WriteSample(testStart.Add(time.Duration(at)*time.Millisecond), []gfs.InstanceSample{sample}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policy    string
+		times     []int64
+		regressed int
+	}{
+		{gfs.TimeJumpsDrop, []int64{1000, 2000, 3000, 4000, 5000, 6000, 6500, 7500}, 3},
+		{gfs.TimeJumpsClamp, []int64{1000, 2000, 3000, 4000, 5000, 6000, 6001, 6002, 6003, 6500, 7500}, 3},
+		{gfs.TimeJumpsKeep, millis, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			reader, err := readArchive(archive.Bytes(), func(reader *gfs.StatArchiveReader) { reader.SetTimeJumpPolicy(tt.policy) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			report := reader.GetParseReport()
+			if len(report.TimeJumps) != 1 || report.RegressedSamples != tt.regressed {
+				t.Fatalf("want 1 time jump and %d regressed samples, got %s", tt.regressed, report)
+			}
+			if jump := report.TimeJumps[0]; jump.Offset != int64(jumpAt) || jump.Delta != 2500 {
+				t.Errorf("want a jump back 2500ms at offset %d, got %s", jumpAt, jump)
+			}
+
+			var times []int64
+			for _, sample := range reader.GetInstances()[0].Stats[0] {
+				times = append(times, sample.Timestamp-testStart.UnixMilli())
+			}
+			if fmt.Sprint(times) != fmt.Sprint(tt.times) {
+				t.Errorf("want samples at %v, got %v", tt.times, times)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package gfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+)
+
+// rawSampleArchive returns an archive of one instance, id 0, of a type
+// with one int stat, followed by records written as they are
+func rawSampleArchive(t *testing.T, order binary.ByteOrder, start time.Time, records ...[]byte) []byte {
+	t.Helper()
+	var archive bytes.Buffer
+	w, err := NewArchiveWriter(&archive, ArchiveHeader{StartTime: start, SystemStartTime: start, ByteOrder: order})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteResourceType(&ResourceType{Name: "DeltaStats", Stats: []StatDescriptor{{Name: "value", Type: StatTypeInt}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CreateInstance(0, "delta", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		archive.Write(record)
+	}
+	return archive.Bytes()
+}
+
+// sampleRecord returns a sample record starting with token, then delta,
+// then a block setting the stat of instance 0 to value
+func sampleRecord(token byte, delta []byte, value int8) []byte {
+	record := append([]byte{token}, delta...)
+	return append(record, 0, 0, byte(value), ILLEGAL_STAT_OFFSET, ILLEGAL_RESOURCE_INST_ID_TOKEN)
+}
+
+// shortDelta encodes a delta as an unsigned short
+func shortDelta(order binary.AppendByteOrder, delta uint16) []byte {
+	return order.AppendUint16(nil, delta)
+}
+
+// intDelta encodes a delta as INT_TIMESTAMP_TOKEN followed by an int
+func intDelta(order binary.AppendByteOrder, delta int32) []byte {
+	return order.AppendUint32(order.AppendUint16(nil, INT_TIMESTAMP_TOKEN), uint32(delta))
+}
+
+func readRaw(t *testing.T, data []byte) *StatArchiveReader {
+	t.Helper()
+	reader := NewStatArchiveReaderFromReader(bytes.NewReader(data), int64(len(data)))
+	reader.SetLogger(logging.Discard)
+	reader.SetTimeJumpPolicy(TimeJumpsKeep)
+	reader.ReadArchive()
+	return reader
+}
+
+func TestTimeDeltas(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	for _, order := range []interface {
+		binary.ByteOrder
+		binary.AppendByteOrder
+	}{binary.BigEndian, binary.LittleEndian} {
+		tests := []struct {
+			name   string
+			deltas [][]byte
+			want   []time.Duration // From start, one per record
+		}{
+			{"short", [][]byte{shortDelta(order, 1000), shortDelta(order, 1)}, []time.Duration{time.Second, time.Second + time.Millisecond}},
+			{"zero short", [][]byte{shortDelta(order, 0)}, []time.Duration{0}},
+			{"largest short", [][]byte{shortDelta(order, MAX_SHORT_TIMESTAMP)}, []time.Duration{MAX_SHORT_TIMESTAMP * time.Millisecond}},
+			{"int", [][]byte{intDelta(order, 5*60*1000)}, []time.Duration{5 * time.Minute}},
+			{"int after short", [][]byte{shortDelta(order, 1000), intDelta(order, 24*60*60*1000)}, []time.Duration{time.Second, 24*time.Hour + time.Second}},
+			{"negative int", [][]byte{intDelta(order, 60*1000), intDelta(order, -30*1000)}, []time.Duration{time.Minute, 30 * time.Second}},
+		}
+		for _, tt := range tests {
+			t.Run(order.String()+"/"+tt.name, func(t *testing.T) {
+				var records [][]byte
+				for i, delta := range tt.deltas {
+					records = append(records, sampleRecord(SAMPLE_TOKEN, delta, int8(i)))
+				}
+				reader := readRaw(t, rawSampleArchive(t, order, start, records...))
+				if report := reader.GetParseReport(); !report.Clean() {
+					t.Fatalf("not read cleanly: %s", report)
+				}
+				samples := reader.GetInstances()[0].Stats[0]
+				if len(samples) != len(tt.want) {
+					t.Fatalf("read %d samples, wrote %d", len(samples), len(tt.want))
+				}
+				for i, sample := range samples {
+					if want := start.Add(tt.want[i]); !sample.Time().Equal(want) {
+						t.Errorf("sample %d at %s, want %s", i, sample.Time().Format(time.RFC3339Nano), want.Format(time.RFC3339Nano))
+					}
+					if sample.Value != float64(i) {
+						t.Errorf("sample %d is %v, want %d", i, sample.Value, i)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestNonSampleTokens checks that a token StatArchiveWriter never writes
+// does not start a sample record, whatever delta it might be read as
+func TestNonSampleTokens(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	order := binary.BigEndian
+	for _, token := range []byte{5, 100, 251, 252, SHORT_RESOURCE_INST_ID_TOKEN, INT_RESOURCE_INST_ID_TOKEN, ILLEGAL_RESOURCE_INST_ID_TOKEN} {
+		bogus := sampleRecord(token, shortDelta(order, 1000), 1)
+		good := sampleRecord(SAMPLE_TOKEN, shortDelta(order, 2000), 2)
+		reader := readRaw(t, rawSampleArchive(t, order, start, bogus, good))
+		if report := reader.GetParseReport(); report.Clean() {
+			t.Errorf("token %d: read cleanly", token)
+		}
+		samples := reader.GetInstances()[0].Stats[0]
+		// Resyncing may take bytes of the bogus record for an empty sample
+		// record, moving the time on, but never for a value
+		if len(samples) != 1 || samples[0].Value != 2 {
+			t.Errorf("token %d: read %v, want only the sample record after it", token, samples)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package gfs
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timestamp modes, selected with SetTimeZoneMode
+const (
+	// TimeZoneRaw trusts the archive's epoch milliseconds, which Geode
+	// writes in UTC whatever the member's zone
+	TimeZoneRaw = "raw"
+	// TimeZoneApply adds the archive's offset, so series carry the
+	// member's wall clock time
+	TimeZoneApply = "apply"
+	// TimeZoneStrip subtracts the archive's offset, for archives whose
+	// milliseconds were written as local wall clock time
+	TimeZoneStrip = "strip"
+)
+
+// ValidTimeZoneMode reports whether mode is a known timestamp mode. The
+// empty string selects TimeZoneRaw.
+func ValidTimeZoneMode(mode string) bool {
+	switch mode {
+	case "", TimeZoneRaw, TimeZoneApply, TimeZoneStrip:
+		return true
+	}
+	return false
+}
+
+// SetTimeZoneMode selects how archive timestamps are adjusted for the
+// timezone in the header. It must be called before ReadArchive.
+func (r *StatArchiveReader) SetTimeZoneMode(mode string) {
+	r.timeZoneMode = mode
+}
+
+// TimeZone returns the archive's timezone name and its offset from UTC, as
+// written in the header
+func (r *StatArchiveReader) TimeZone() (string, time.Duration) {
+	return r.timeZoneName, time.Duration(r.timeZoneOffset) * time.Millisecond
+}
+
+// toTime converts archive milliseconds to a time, applying the timezone mode
+func (r *StatArchiveReader) toTime(millis int64) time.Time {
+	switch r.timeZoneMode {
+	case TimeZoneApply:
+		millis += int64(r.timeZoneOffset)
+	case TimeZoneStrip:
+		millis -= int64(r.timeZoneOffset)
+	}
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
+
+// FormatOffset formats a timezone offset as +hh:mm
+func FormatOffset(offset time.Duration) string {
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("%c%02d:%02d", sign, int(offset.Hours()), int(offset.Minutes())%60)
+}
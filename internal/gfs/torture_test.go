@@ -0,0 +1,125 @@
+package gfs_test
+
+import (
+	"errors"
+	"flag"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/torture"
+)
+
+var tortureAll = flag.Bool("torture", false, "read every damaged variant of the synthetic archive, not a sample of each kind")
+
+// tortured is what reading a damaged variant of an archive gave
+type tortured struct {
+	err       error
+	report    *gfs.ParseReport
+	instances int
+	samples   int
+	allocated uint64
+}
+
+// readVariant reads data, failing the test if the reader panics
+func readVariant(t *testing.T, name string, data []byte) (result tortured) {
+	t.Helper()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	defer func() {
+		if p := recover(); p != nil {
+			t.Fatalf("%s: reader panicked: %v\n%s", name, p, debug.Stack())
+		}
+	}()
+	reader, err := readArchive(data, nil)
+	runtime.ReadMemStats(&after)
+	return tortured{
+		err:       err,
+		report:    reader.GetParseReport(),
+		instances: len(reader.GetInstances()),
+		samples:   values(reader),
+		allocated: after.TotalAlloc - before.TotalAlloc,
+	}
+}
+
+// TestTorture reads damaged variants of the synthetic archive, of every
+// kind torture generates, and checks that the reader neither panics nor
+// allocates several times what the intact archive needs, and that what it
+// reports fits the damage. Only a sample of each kind is read unless the
+// test runs with -torture.
+func TestTorture(t *testing.T) {
+	data := synthetic(t, testStart, gfs.ArchiveHeader{})
+	reader, err := readArchive(data, func(reader *gfs.StatArchiveReader) { reader.TraceLayout() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := reader.GetLayout()
+	intact := readVariant(t, "intact archive", data)
+	limit := 4*intact.allocated + 64<<20
+
+	perKind := 8
+	if *tortureAll {
+		perKind = math.MaxInt
+	}
+	tests := []struct {
+		kind string
+		// check reports what cannot be right for a variant of the kind
+		check func(t *testing.T, name string, r tortured)
+	}{
+		{torture.KindTruncate, func(t *testing.T, name string, r tortured) {
+			// Every record before the cut is intact
+			if r.err != nil || !r.report.Clean() {
+				t.Errorf("%s: not read cleanly: %v, %s", name, r.err, r.report)
+			}
+			if r.samples > intact.samples {
+				t.Errorf("%s: read %d values, the whole archive holds %d", name, r.samples, intact.samples)
+			}
+		}},
+		{torture.KindHeaderBitFlip, nil},
+		{torture.KindOversizedLength, func(t *testing.T, name string, r tortured) {
+			// A stat descriptor cut short only drops the stat, with a warning
+			if r.err == nil && r.report.WarningCount == 0 {
+				t.Errorf("%s: read without an error or warning", name)
+			}
+		}},
+		{torture.KindDuplicateTypeID, func(t *testing.T, name string, r tortured) {
+			var corrupt *gfs.ErrCorruptRecord
+			if !errors.As(r.err, &corrupt) || corrupt.Token != gfs.RESOURCE_TYPE_TOKEN {
+				t.Errorf("%s: want an ErrCorruptRecord for the type record, got %v", name, r.err)
+			}
+		}},
+		{torture.KindStatOffset, func(t *testing.T, name string, r tortured) {
+			// Only the damaged instance block is dropped
+			if r.report.WarningCount == 0 || r.instances != intact.instances || r.samples >= intact.samples {
+				t.Errorf("%s: read %d instances and %d of %d values, %s", name, r.instances, r.samples, intact.samples, r.report)
+			}
+		}},
+		{torture.KindRandomBytes, nil},
+	}
+	variants := make(map[string][]torture.Variant)
+	err = torture.Generate(data, layout, perKind, func(v torture.Variant) error {
+		variants[v.Kind] = append(variants[v.Kind], v)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			if len(variants[tt.kind]) == 0 {
+				t.Fatal("no variants generated")
+			}
+			for _, v := range variants[tt.kind] {
+				r := readVariant(t, v.Name, v.Data)
+				if r.allocated > limit {
+					t.Errorf("%s: allocated %d bytes, the intact archive %d", v.Name, r.allocated, intact.allocated)
+				}
+				if tt.check != nil {
+					tt.check(t, v.Name, r)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package gfs
+
+import (
+	"time"
+)
+
+type StatType int
+
+const (
+	StatTypeInt StatType = iota
+	StatTypeLong
+	StatTypeDouble
+	StatTypeFloat
+	// Types Geode writes as fixed-width values rather than compact ones
+	StatTypeBoolean
+	StatTypeByte
+	StatTypeChar
+	StatTypeShort
+)
+
+type ResourceType struct {
+	ID          int32
+	Name        string
+	Description string
+	Stats       []StatDescriptor
+}
+
+type StatDescriptor struct {
+	ID          int32
+	Name        string
+	Description string
+	Type        StatType
+	Unit        string
+	IsCounter   bool
+	// LargerBetter is the descriptor's isLargerBetter flag, which archives
+	// before version 4 take from IsCounter
+	LargerBetter bool
+	LargestBit   byte
+}
+
+type ResourceInstance struct {
+	ID     int32
+	TypeID int32
+	Name   string
+	// NumericID is the id Geode gives the instance besides its name,
+	// often the PID of a process or the id of a connection
+	NumericID    int64
+	CreationTime time.Time
+	Stats        map[int32][]StatValue
+	// DeletionTime is when the instance was deleted, or its archive ended
+	// because another was appended to the file; zero if it was live at
+	// the end of the file
+	DeletionTime time.Time
+	// Segment is the position in the file of the archive that created the
+	// instance, 0 unless archives were appended to the file
+	Segment int
+}
+
+// InstanceEvent is the creation or deletion of a resource instance
+type InstanceEvent struct {
+	Time    time.Time
+	TypeID  int32
+	Created bool // False for a deletion
+}
+
+// StatValue is one sample of a stat, kept in 16 bytes since an archive
+// holds millions of them. Values of every type are kept as the float64
+// they are written to the TSDB as, which is exact for all but longs beyond
+// 2^53.
+type StatValue struct {
+	// Timestamp is in milliseconds since the epoch, see Time
+	Timestamp int64
+	Value     float64
+}
+
+// Time returns the timestamp of the sample
+func (v StatValue) Time() time.Time {
+	return time.UnixMilli(v.Timestamp)
+}
@@ -0,0 +1,255 @@
+package gfs
+
+import "fmt"
+
+// maxVerifyExamples caps the violations a VerifyCheck keeps; the rest are
+// only counted
+const maxVerifyExamples = 10
+
+// Names of the checks of a Verification, in the order they are reported
+const (
+	CheckKnownInstances      = "known_instances"
+	CheckKnownTypes          = "known_types"
+	CheckMonotonicTimestamps = "monotonic_timestamps"
+	CheckCounters            = "counters_non_decreasing"
+	CheckSampleCounts        = "sample_counts"
+	CheckCleanRead           = "clean_read"
+)
+
+var verifyChecks = []string{
+	CheckKnownInstances,
+	CheckKnownTypes,
+	CheckMonotonicTimestamps,
+	CheckCounters,
+	CheckSampleCounts,
+	CheckCleanRead,
+}
+
+// Verification is the verdict of checking an archive's consistency while
+// reading it, collected when Verify was called
+type Verification struct {
+	// OK is set when no check found a violation
+	OK bool `json:"ok"`
+	// SampleRecords counts the sample records decoded, ScannedRecords
+	// those among them that were scanned whole before decoding, and
+	// ExpectedValues the values the scan found in them
+	SampleRecords  int   `json:"sample_records"`
+	ScannedRecords int   `json:"scanned_records"`
+	ExpectedValues int64 `json:"expected_values"`
+	// DecodedValues counts the values decoded from sample records and
+	// InitialValues those of initialized instances; StoredValues counts
+	// the values the instances hold after reading, or that were handed
+	// to the stream callback, and DroppedValues those the time jump policy
+	// dropped
+	DecodedValues int64         `json:"decoded_values"`
+	InitialValues int64         `json:"initial_values"`
+	StoredValues  int64         `json:"stored_values"`
+	DroppedValues int64         `json:"dropped_values,omitempty"`
+	Checks        []VerifyCheck `json:"checks"`
+}
+
+// VerifyCheck is the outcome of one consistency check
+type VerifyCheck struct {
+	Name       string `json:"name"`
+	OK         bool   `json:"ok"`
+	Violations int    `json:"violations"`
+	// Examples holds the first violations
+	Examples []ParseWarning `json:"examples,omitempty"`
+}
+
+// Failed returns the checks that found violations
+func (v *Verification) Failed() []VerifyCheck {
+	var failed []VerifyCheck
+	for _, check := range v.Checks {
+		if !check.OK {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
+
+func (v *Verification) String() string {
+	if v.OK {
+		return fmt.Sprintf("verified %d checks", len(v.Checks))
+	}
+	failed := v.Failed()
+	s := "failed verification:"
+	for i, check := range failed {
+		if i > 0 {
+			s += ","
+		}
+		s += fmt.Sprintf(" %s (%s)", check.Name, plural(check.Violations, "violation"))
+	}
+	return s
+}
+
+// counterKey identifies a counter of one instance
+type counterKey struct {
+	instance *ResourceInstance
+	stat     int32
+}
+
+// verifyState is the accounting behind a Verification
+type verifyState struct {
+	verification Verification
+	checks       map[string]*VerifyCheck
+
+	// What scanning the record about to be decoded found, and how many
+	// values decoding it has produced so far
+	expected      int
+	expectedWhole bool
+	recordValues  int
+
+	// The last sample timestamp, and the archive in the file it is from
+	lastTimestamp int64
+	lastSegment   int
+	timestamped   bool
+
+	// Last value of every counter
+	counters map[counterKey]float64
+
+	streamed int64
+}
+
+// Verify makes ReadArchive and ReadArchiveStream check the archive's
+// consistency while reading it: samples must reference known instances and
+// instances known types, timestamps must not go back, counters must not
+// decrease within an instance, whose deletion or the start of an appended
+// archive is what resets them, and every value the stat offsets of a
+// sample record announce must be decoded and kept. GetVerification then
+// returns the verdict.
+func (r *StatArchiveReader) Verify() {
+	s := &verifyState{
+		checks:   make(map[string]*VerifyCheck, len(verifyChecks)),
+		counters: make(map[counterKey]float64),
+	}
+	s.verification.Checks = make([]VerifyCheck, len(verifyChecks))
+	for i, name := range verifyChecks {
+		s.verification.Checks[i] = VerifyCheck{Name: name, OK: true}
+		s.checks[name] = &s.verification.Checks[i]
+	}
+	r.verify = s
+}
+
+// GetVerification returns the verdict of the last read, or nil if Verify
+// was not called
+func (r *StatArchiveReader) GetVerification() *Verification {
+	if r.verify == nil {
+		return nil
+	}
+	v := r.verify.verification
+	v.Checks = append([]VerifyCheck(nil), v.Checks...)
+	return &v
+}
+
+// violate records a violation of a check found at offset
+func (s *verifyState) violate(name string, offset int64, format string, args ...interface{}) {
+	check := s.checks[name]
+	check.OK = false
+	check.Violations++
+	if len(check.Examples) < maxVerifyExamples {
+		check.Examples = append(check.Examples, ParseWarning{Offset: offset, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// scanned records what recordFollows found scanning the next record
+func (s *verifyState) scanned(values int, result scanResult) {
+	s.expected = values
+	s.expectedWhole = result == scanMatch
+}
+
+// startRecord starts counting the values of a sample record
+func (s *verifyState) startRecord() {
+	s.verification.SampleRecords++
+	s.recordValues = 0
+}
+
+// endRecord compares the values decoded from the sample record starting
+// at offset with those its scan found
+func (s *verifyState) endRecord(offset int64) {
+	if !s.expectedWhole {
+		return
+	}
+	s.verification.ScannedRecords++
+	s.verification.ExpectedValues += int64(s.expected)
+	if s.recordValues != s.expected {
+		s.violate(CheckSampleCounts, offset, "scan expects %d values in the sample record, %d were decoded", s.expected, s.recordValues)
+	}
+}
+
+// timestamp checks the timestamp of the sample record starting at offset
+// against the previous one of the same archive
+func (s *verifyState) timestamp(r *StatArchiveReader, offset int64) {
+	if s.timestamped && s.lastSegment == r.segment && r.currentTimeStamp < s.lastTimestamp {
+		s.violate(CheckMonotonicTimestamps, offset, "sample timestamp %d is %d ms before the previous one",
+			r.currentTimeStamp, s.lastTimestamp-r.currentTimeStamp)
+	}
+	s.lastTimestamp, s.lastSegment, s.timestamped = r.currentTimeStamp, r.segment, true
+}
+
+// instanceCreated checks that a new instance's type is known
+func (s *verifyState) instanceCreated(r *StatArchiveReader, offset int64, instance *ResourceInstance) {
+	if r.resourceTypes[instance.TypeID] == nil {
+		s.violate(CheckKnownTypes, offset, "instance %d (%s) has unknown type %d", instance.ID, instance.Name, instance.TypeID)
+	}
+}
+
+// block counts the values of a completed instance block starting at
+// offset, initial values if initial is set, and checks its counters
+func (s *verifyState) block(offset int64, instance *ResourceInstance, resourceType *ResourceType, staged []stagedValue, initial bool) {
+	if initial {
+		s.verification.InitialValues += int64(len(staged))
+	} else {
+		s.verification.DecodedValues += int64(len(staged))
+		s.recordValues += len(staged)
+	}
+
+	for _, v := range staged {
+		stat := &resourceType.Stats[v.statId]
+		if !stat.IsCounter {
+			continue
+		}
+		value := v.value
+		key := counterKey{instance: instance, stat: v.statId}
+		if last, ok := s.counters[key]; ok && value < last {
+			s.violate(CheckCounters, offset, "counter %s of %s decreased from %g to %g", stat.Name, instance.Name, last, value)
+		}
+		s.counters[key] = value
+	}
+}
+
+// finish counts the values the instances kept, or that were streamed, and
+// takes the parse report's problems as violations of a clean read
+func (s *verifyState) finish(r *StatArchiveReader) {
+	v := &s.verification
+	if r.sampleFunc != nil {
+		v.StoredValues = s.streamed
+	} else {
+		v.StoredValues = 0
+		for _, instance := range r.GetInstances() {
+			for _, values := range instance.Stats {
+				v.StoredValues += int64(len(values))
+			}
+		}
+	}
+	if decoded := v.DecodedValues + v.InitialValues; v.StoredValues+v.DroppedValues != decoded {
+		s.violate(CheckSampleCounts, r.Offset(), "%d values were decoded, %d were kept and %d dropped", decoded, v.StoredValues, v.DroppedValues)
+	}
+
+	report := &r.report
+	if report.Truncated {
+		s.violate(CheckCleanRead, report.TruncatedAt, "archive is truncated")
+	}
+	for _, warning := range report.Warnings {
+		s.violate(CheckCleanRead, warning.Offset, "%s", warning.Message)
+	}
+	// Warnings beyond those the report keeps are only counted
+	s.checks[CheckCleanRead].Violations += report.WarningCount - len(report.Warnings)
+
+	v.OK = true
+	for _, check := range v.Checks {
+		if !check.OK {
+			v.OK = false
+		}
+	}
+}
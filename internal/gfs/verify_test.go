@@ -0,0 +1,46 @@
+package gfs_test
+
+import (
+	"testing"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+)
+
+// verification reads the archive at path with Verify and returns the
+// verdict
+func verification(t *testing.T, path string) *gfs.Verification {
+	t.Helper()
+	reader, err := gfs.NewStatArchiveReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	reader.SetLogger(logging.Discard)
+	reader.Verify()
+	if err := reader.ReadArchive(); gfs.IsUnreadable(err) {
+		t.Fatal(err)
+	}
+	return reader.GetVerification()
+}
+
+// TestVerify checks that the intact archive passes every consistency
+// check and that the one with junk fails only the clean read
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	intact := verification(t, writeFile(t, dir, "intact.gfs", synthetic(t, testStart, gfs.ArchiveHeader{})))
+	if !intact.OK {
+		t.Errorf("intact archive %s", intact)
+	}
+	if intact.DecodedValues+intact.InitialValues != intact.StoredValues || intact.ExpectedValues != intact.DecodedValues {
+		t.Errorf("intact archive: %d values expected, %d decoded, %d initial, %d kept",
+			intact.ExpectedValues, intact.DecodedValues, intact.InitialValues, intact.StoredValues)
+	}
+
+	data, _ := withJunk(t)
+	damaged := verification(t, writeFile(t, dir, "junk.gfs", data))
+	failed := damaged.Failed()
+	if len(failed) != 1 || failed[0].Name != gfs.CheckCleanRead {
+		t.Errorf("archive with junk %s, want only %s to fail", damaged, gfs.CheckCleanRead)
+	}
+}
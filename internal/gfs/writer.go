@@ -0,0 +1,365 @@
+package gfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// ArchiveHeader holds the header fields of an archive written by an
+// ArchiveWriter
+type ArchiveHeader struct {
+	StartTime          time.Time
+	SystemID           int64
+	SystemStartTime    time.Time
+	TimeZoneName       string
+	TimeZoneOffset     time.Duration
+	SystemDirectory    string
+	ProductDescription string
+	OSInfo             string
+	MachineInfo        string
+	// ByteOrder is the order multi-byte fields are written in, big-endian
+	// as Java writes them if nil
+	ByteOrder binary.ByteOrder
+	// Version is the archive version written, from MIN_ARCHIVE_VERSION to
+	// ARCHIVE_VERSION if set. Versions before LARGER_BETTER_VERSION leave
+	// out the LargerBetter flag of stat descriptors.
+	Version int
+}
+
+// InstanceSample holds the values of the stats of one instance that
+// changed in a sample, keyed by the stat's index in its type. Values of
+// integer stats are truncated, and are only exact up to 2^53.
+type InstanceSample struct {
+	InstanceID int32
+	Values     map[int]float64
+}
+
+// ArchiveWriter writes a statistics archive record by record, encoding
+// values the way StatArchiveWriter.java does, so tests and demos can build
+// archives that StatArchiveReader reads back exactly
+type ArchiveWriter struct {
+	w         *bufio.Writer
+	byteOrder binary.ByteOrder
+	version   int
+
+	timeStamp int64 // Milliseconds of the last timestamp written
+	types     map[int32]*ResourceType
+	instances map[int32]*ResourceType
+}
+
+// NewArchiveWriter writes the header of an archive to w and returns a
+// writer for its records. Flush must be called once they are written.
+func NewArchiveWriter(w io.Writer, header ArchiveHeader) (*ArchiveWriter, error) {
+	a := &ArchiveWriter{
+		w:         bufio.NewWriter(w),
+		byteOrder: header.ByteOrder,
+		version:   header.Version,
+		timeStamp: header.StartTime.UnixMilli(),
+		types:     make(map[int32]*ResourceType),
+		instances: make(map[int32]*ResourceType),
+	}
+
+	if a.byteOrder == nil {
+		a.byteOrder = binary.BigEndian
+	}
+	if a.version == 0 {
+		a.version = ARCHIVE_VERSION
+	}
+	if a.version < MIN_ARCHIVE_VERSION || a.version > ARCHIVE_VERSION {
+		return nil, &ErrUnsupportedVersion{Found: a.version}
+	}
+
+	a.w.WriteByte(HEADER_TOKEN)
+	a.w.WriteByte(byte(a.version))
+	a.write(header.StartTime.UnixMilli())
+	a.write(header.SystemID)
+	a.write(header.SystemStartTime.UnixMilli())
+	a.write(int32(header.TimeZoneOffset / time.Millisecond))
+	for _, s := range []string{header.TimeZoneName, header.SystemDirectory, header.ProductDescription, header.OSInfo, header.MachineInfo} {
+		if err := a.writeUTF(s); err != nil {
+			return nil, fmt.Errorf("invalid header: %w", err)
+		}
+	}
+	return a, nil
+}
+
+// WriteResourceType writes a resource type definition. Its stats are
+// numbered by their index in Stats.
+func (a *ArchiveWriter) WriteResourceType(t *ResourceType) error {
+	if _, exists := a.types[t.ID]; exists {
+		return fmt.Errorf("resource type %d is already defined", t.ID)
+	}
+	if len(t.Stats) > MAX_DESCRIPTORS_PER_TYPE {
+		return fmt.Errorf("resource type %s has %d stats (max %d)", t.Name, len(t.Stats), MAX_DESCRIPTORS_PER_TYPE)
+	}
+
+	a.w.WriteByte(RESOURCE_TYPE_TOKEN)
+	a.write(t.ID)
+	if err := a.writeUTFs(t.Name, t.Description); err != nil {
+		return err
+	}
+	a.write(int16(len(t.Stats)))
+	for _, stat := range t.Stats {
+		if err := a.writeUTF(stat.Name); err != nil {
+			return err
+		}
+		a.w.WriteByte(statTypeCode(stat.Type))
+		a.w.WriteByte(boolByte(stat.IsCounter))
+		if a.version >= LARGER_BETTER_VERSION {
+			a.w.WriteByte(boolByte(stat.LargerBetter))
+		}
+		if err := a.writeUTFs(stat.Unit, stat.Description); err != nil {
+			return err
+		}
+	}
+	a.types[t.ID] = t
+	return nil
+}
+
+// CreateInstance writes the creation of an instance of a defined type,
+// which is recorded at the time of the last sample
+func (a *ArchiveWriter) CreateInstance(id int32, name string, numericID int64, typeID int32) error {
+	return a.createInstance(RESOURCE_INSTANCE_CREATE_TOKEN, id, name, numericID, typeID)
+}
+
+// InitializeInstance writes the creation of an instance together with the
+// initial value of every stat of its type, in stat order
+func (a *ArchiveWriter) InitializeInstance(id int32, name string, numericID int64, typeID int32, values []float64) error {
+	t, ok := a.types[typeID]
+	if !ok {
+		return fmt.Errorf("unknown resource type %d", typeID)
+	}
+	if len(values) != len(t.Stats) {
+		return fmt.Errorf("%d initial values for type %s with %d stats", len(values), t.Name, len(t.Stats))
+	}
+	if err := a.createInstance(RESOURCE_INSTANCE_INITIALIZE_TOKEN, id, name, numericID, typeID); err != nil {
+		return err
+	}
+	for i, value := range values {
+		a.writeStatValue(t.Stats[i].Type, value)
+	}
+	return nil
+}
+
+func (a *ArchiveWriter) createInstance(token byte, id int32, name string, numericID int64, typeID int32) error {
+	t, ok := a.types[typeID]
+	if !ok {
+		return fmt.Errorf("unknown resource type %d", typeID)
+	}
+	if id < 0 {
+		return fmt.Errorf("invalid instance id %d", id)
+	}
+	if _, exists := a.instances[id]; exists {
+		return fmt.Errorf("instance %d already exists", id)
+	}
+
+	a.w.WriteByte(token)
+	a.write(id)
+	if err := a.writeUTF(name); err != nil {
+		return err
+	}
+	a.write(numericID)
+	a.write(typeID)
+	a.instances[id] = t
+	return nil
+}
+
+// DeleteInstance writes the deletion of an instance
+func (a *ArchiveWriter) DeleteInstance(id int32) error {
+	if _, exists := a.instances[id]; !exists {
+		return fmt.Errorf("unknown instance %d", id)
+	}
+	a.w.WriteByte(RESOURCE_INSTANCE_DELETE_TOKEN)
+	a.writeInstanceID(id)
+	delete(a.instances, id)
+	return nil
+}
+
+// WriteSample writes a sample at timestamp holding the changed values of
+// each instance. The timestamp may be before the previous one by up to a
+// day, as when a member's clock is stepped back.
+func (a *ArchiveWriter) WriteSample(timestamp time.Time, instances []InstanceSample) error {
+	delta := timestamp.UnixMilli() - a.timeStamp
+	if delta < -maxTimeJump.Milliseconds() || delta > math.MaxInt32 {
+		return fmt.Errorf("sample at %s is %dms after the previous one", timestamp.Format(time.RFC3339Nano), delta)
+	}
+	for _, sample := range instances {
+		t, ok := a.instances[sample.InstanceID]
+		if !ok {
+			return fmt.Errorf("unknown instance %d", sample.InstanceID)
+		}
+		for index := range sample.Values {
+			if index < 0 || index >= len(t.Stats) {
+				return fmt.Errorf("stat index %d out of range for type %s", index, t.Name)
+			}
+		}
+	}
+
+	a.w.WriteByte(SAMPLE_TOKEN)
+	a.writeTimeDelta(delta)
+	a.timeStamp += delta
+	for _, sample := range instances {
+		t := a.instances[sample.InstanceID]
+		a.writeInstanceID(sample.InstanceID)
+		for index := range t.Stats {
+			value, changed := sample.Values[index]
+			if !changed {
+				continue
+			}
+			a.writeStatOffset(t, index)
+			a.writeStatValue(t.Stats[index].Type, value)
+		}
+		a.writeStatOffset(t, -1)
+	}
+	a.w.WriteByte(ILLEGAL_RESOURCE_INST_ID_TOKEN)
+	return nil
+}
+
+// Flush writes any buffered records to the underlying writer
+func (a *ArchiveWriter) Flush() error {
+	return a.w.Flush()
+}
+
+// writeTimeDelta writes a timestamp delta as StatArchiveWriter does
+func (a *ArchiveWriter) writeTimeDelta(delta int64) {
+	if delta < 0 || delta > MAX_SHORT_TIMESTAMP {
+		a.write(uint16(INT_TIMESTAMP_TOKEN))
+		a.write(int32(delta))
+		return
+	}
+	a.write(uint16(delta))
+}
+
+func (a *ArchiveWriter) writeInstanceID(id int32) {
+	switch {
+	case id < SHORT_RESOURCE_INST_ID_TOKEN:
+		a.w.WriteByte(byte(id))
+	case id <= math.MaxUint16:
+		a.w.WriteByte(SHORT_RESOURCE_INST_ID_TOKEN)
+		a.write(uint16(id))
+	default:
+		a.w.WriteByte(INT_RESOURCE_INST_ID_TOKEN)
+		a.write(uint32(id))
+	}
+}
+
+// writeStatOffset writes the offset of a stat in an instance block, or the
+// block's terminator for -1
+func (a *ArchiveWriter) writeStatOffset(t *ResourceType, offset int) {
+	if offset < 0 {
+		offset = ILLEGAL_STAT_OFFSET
+	}
+	a.w.WriteByte(byte(offset))
+}
+
+func (a *ArchiveWriter) writeStatValue(statType StatType, value float64) {
+	switch statType {
+	case StatTypeDouble:
+		a.write(value)
+	case StatTypeFloat:
+		a.write(float32(value))
+	case StatTypeBoolean:
+		a.w.WriteByte(boolByte(value != 0))
+	case StatTypeByte:
+		a.w.WriteByte(byte(int8(value)))
+	case StatTypeChar:
+		a.write(uint16(value))
+	case StatTypeShort:
+		a.write(int16(value))
+	default:
+		a.writeCompactValue(int64(value))
+	}
+}
+
+// writeCompactValue writes v as StatArchiveWriter.writeCompactValue does:
+// in one byte if it fits above the tokens, otherwise as a token followed by
+// the fewest big-endian bytes that hold it. Compact values are decoded a
+// byte at a time, so they are big-endian whatever the archive's byte order.
+func (a *ArchiveWriter) writeCompactValue(v int64) {
+	if v >= MIN_1BYTE_COMPACT_VALUE && v <= MAX_1BYTE_COMPACT_VALUE {
+		a.w.WriteByte(byte(int8(v)))
+		return
+	}
+	if v >= MIN_2BYTE_COMPACT_VALUE && v <= MAX_2BYTE_COMPACT_VALUE {
+		a.w.WriteByte(compactToken(2))
+		binary.Write(a.w, binary.BigEndian, int16(v))
+		return
+	}
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(v))
+	// Drop leading bytes that only repeat the sign of the next one
+	start := 0
+	for start < 5 {
+		next := int8(buf[start+1])
+		if (v < 0 && buf[start] == 0xFF && next < 0) || (v >= 0 && buf[start] == 0 && next >= 0) {
+			start++
+			continue
+		}
+		break
+	}
+	a.w.WriteByte(compactToken(8 - start))
+	a.w.Write(buf[start:])
+}
+
+// compactToken returns the token of a compact value of n bytes after it
+func compactToken(n int) byte {
+	return byte(int8(COMPACT_VALUE_2_TOKEN + n - 2))
+}
+
+func (a *ArchiveWriter) writeUTFs(values ...string) error {
+	for _, s := range values {
+		if err := a.writeUTF(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *ArchiveWriter) writeUTF(s string) error {
+	if len(s) > math.MaxUint16 {
+		return fmt.Errorf("string of %d bytes is too long for the archive", len(s))
+	}
+	a.write(uint16(len(s)))
+	a.w.WriteString(s)
+	return nil
+}
+
+// write writes a fixed-size value; errors surface from Flush, as the
+// buffer keeps the first one
+func (a *ArchiveWriter) write(v interface{}) {
+	binary.Write(a.w, a.byteOrder, v)
+}
+
+// statTypeCode returns the type code StatArchiveWriter writes for a type
+func statTypeCode(statType StatType) byte {
+	switch statType {
+	case StatTypeBoolean:
+		return BOOLEAN_TYPE_CODE
+	case StatTypeChar:
+		return CHAR_TYPE_CODE
+	case StatTypeByte:
+		return BYTE_TYPE_CODE
+	case StatTypeShort:
+		return SHORT_TYPE_CODE
+	case StatTypeLong:
+		return LONG_TYPE_CODE
+	case StatTypeFloat:
+		return FLOAT_TYPE_CODE
+	case StatTypeDouble:
+		return DOUBLE_TYPE_CODE
+	default:
+		return INT_TYPE_CODE
+	}
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+// Package glob expands file patterns like filepath.Glob, adding "**" path
+// segments that match any number of directories.
+package glob
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const doubleStar = "**"
+
+// Validate reports filepath.ErrBadPattern if any segment of pattern is
+// malformed
+func Validate(pattern string) error {
+	for _, segment := range splitPath(pattern) {
+		if segment == doubleStar {
+			continue
+		}
+		if _, err := filepath.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Expand returns the names of the files matching pattern, sorted. Without
+// a "**" segment it behaves like filepath.Glob; with one, the directory
+// tree below the pattern's literal prefix is walked and only regular files
+// are returned. A pattern that matches nothing is not an error.
+func Expand(pattern string) ([]string, error) {
+	if err := Validate(pattern); err != nil {
+		return nil, err
+	}
+	if !hasDoubleStar(pattern) {
+		return filepath.Glob(pattern)
+	}
+
+	root := literalPrefix(pattern)
+	patternSegments := splitPath(pattern)
+
+	var matches []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// A missing root or unreadable directories match nothing, as
+			// with filepath.Glob
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if matchSegments(patternSegments, splitPath(path)) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Match reports whether name matches pattern, where a "**" segment matches
+// zero or more path segments
+func Match(pattern, name string) (bool, error) {
+	if err := Validate(pattern); err != nil {
+		return false, err
+	}
+	return matchSegments(splitPath(pattern), splitPath(name)), nil
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == doubleStar {
+			// Collapse repeated ** and try every split of the rest
+			for len(pattern) > 0 && pattern[0] == doubleStar {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// literalPrefix returns the directory made of the pattern's leading
+// segments without wildcards, where walking starts
+func literalPrefix(pattern string) string {
+	clean := filepath.Clean(pattern)
+	segments := strings.Split(clean, string(filepath.Separator))
+	var literal []string
+	for _, segment := range segments {
+		if strings.ContainsAny(segment, `*?[\`) {
+			break
+		}
+		literal = append(literal, segment)
+	}
+	if len(literal) == 0 {
+		return "."
+	}
+	root := strings.Join(literal, string(filepath.Separator))
+	if root == "" {
+		return string(filepath.Separator)
+	}
+	return root
+}
+
+// splitPath splits a cleaned path into its segments, keeping a leading
+// empty segment for absolute paths so they only match absolute patterns
+func splitPath(path string) []string {
+	return strings.Split(filepath.Clean(path), string(filepath.Separator))
+}
+
+func hasDoubleStar(pattern string) bool {
+	for _, segment := range splitPath(pattern) {
+		if segment == doubleStar {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	lockFileName = "gfs-to-prometheus.lock"
+	queueDirName = "ingest-queue"
+	requestExt   = ".json"
+)
+
+// Lock marks a TSDB directory as owned by a running watch daemon. Batch
+// commands that find a live lock hand their files to the daemon through
+// the ingest queue instead of opening the TSDB themselves.
+type Lock struct {
+	path string
+}
+
+// Request is a single file handed to the daemon
+type Request struct {
+	File     string    `json:"file"`
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+// QueueDir returns the directory the daemon watches for ingest requests
+func QueueDir(tsdbPath string) string {
+	return filepath.Join(tsdbPath, queueDirName)
+}
+
+// AcquireLock takes the daemon lock for tsdbPath and creates the queue
+// directory. The lock file is created exclusively, so of two daemons
+// started together only one gets it. A lock left behind by a process that
+// is no longer running is replaced.
+func AcquireLock(tsdbPath string) (*Lock, error) {
+	if err := os.MkdirAll(QueueDir(tsdbPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create ingest queue: %w", err)
+	}
+
+	path := LockPath(tsdbPath)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = file.WriteString(strconv.Itoa(os.Getpid()))
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write lock file: %w", err)
+			}
+			return &Lock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		if pid, running := DaemonPID(tsdbPath); running {
+			return nil, fmt.Errorf("another watch daemon (pid %d) is already using %s", pid, tsdbPath)
+		}
+		if err := removeStaleLock(path); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// removeStaleLock removes the lock file at path, left behind by a daemon
+// that is no longer running, unless another daemon replaced it meanwhile
+func removeStaleLock(path string) error {
+	stale, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read lock file: %w", err)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(stale)))
+	if pid > 0 && processRunning(pid) {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale lock file: %w", err)
+	}
+	return nil
+}
+
+// Release removes the lock file
+func (l *Lock) Release() error {
+	return os.Remove(l.path)
+}
+
+// LockPath returns the path of the daemon lock file for tsdbPath
+func LockPath(tsdbPath string) string {
+	return filepath.Join(tsdbPath, lockFileName)
+}
+
+// DaemonPID reports the pid of the watch daemon holding tsdbPath, if the
+// lock exists and that process is still alive
+func DaemonPID(tsdbPath string) (int, bool) {
+	data, err := os.ReadFile(LockPath(tsdbPath))
+	if err != nil {
+		return 0, false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+
+	if !processRunning(pid) {
+		return 0, false
+	}
+	return pid, true
+}
+
+// processRunning reports whether the process pid is alive
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// Enqueue writes one request per file into the daemon's queue. Requests
+// are written to a temporary name and renamed so the daemon never sees a
+// partial file.
+func Enqueue(tsdbPath string, files []string) error {
+	queueDir := QueueDir(tsdbPath)
+	for i, file := range files {
+		absFile, err := filepath.Abs(file)
+		if err != nil {
+			return fmt.Errorf("invalid file %s: %w", file, err)
+		}
+
+		data, err := json.Marshal(Request{File: absFile, QueuedAt: time.Now()})
+		if err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("%d-%d-%05d", time.Now().UnixNano(), os.Getpid(), i)
+		tmpPath := filepath.Join(queueDir, name+".tmp")
+		if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+			return fmt.Errorf("failed to queue %s: %w", file, err)
+		}
+		if err := os.Rename(tmpPath, filepath.Join(queueDir, name+requestExt)); err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to queue %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// IsRequest reports whether path is a complete request file in the queue
+func IsRequest(tsdbPath, path string) bool {
+	return filepath.Dir(path) == QueueDir(tsdbPath) && strings.HasSuffix(path, requestExt)
+}
+
+// PendingRequests returns the request files currently in the queue, oldest
+// first
+func PendingRequests(tsdbPath string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(QueueDir(tsdbPath), "*"+requestExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// TakeRequest reads a request file and removes it from the queue
+func TakeRequest(path string) (*Request, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("invalid ingest request %s: %w", path, err)
+	}
+	return &req, nil
+}
@@ -0,0 +1,166 @@
+package ingest
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestEnqueueTake(t *testing.T) {
+	tsdbPath := t.TempDir()
+	if err := os.MkdirAll(QueueDir(tsdbPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{"first.gfs", "second.gfs", "third.gfs"}
+	if err := Enqueue(tsdbPath, files); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := PendingRequests(tsdbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(files) {
+		t.Fatalf("%d requests pending, queued %d", len(pending), len(files))
+	}
+	for i, path := range pending {
+		if !IsRequest(tsdbPath, path) {
+			t.Errorf("%s is not a request", path)
+		}
+		request, err := TakeRequest(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := filepath.Abs(files[i])
+		if request.File != want {
+			t.Errorf("request %d is for %s, want %s in the order queued", i, request.File, want)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s is still queued once taken", path)
+		}
+	}
+
+	// A request taken by another goroutine first is gone
+	if _, err := TakeRequest(pending[0]); !os.IsNotExist(err) {
+		t.Errorf("taking a request twice returned %v, want it not to exist", err)
+	}
+}
+
+func TestTakeInvalidRequest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad"+requestExt)
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TakeRequest(path); err == nil {
+		t.Error("took an invalid request")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("an invalid request stays queued")
+	}
+}
+
+func TestIsRequest(t *testing.T) {
+	tsdbPath := "/data/tsdb"
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{filepath.Join(QueueDir(tsdbPath), "1-2-00000.json"), true},
+		{filepath.Join(QueueDir(tsdbPath), "1-2-00000.tmp"), false},
+		{filepath.Join(tsdbPath, "1-2-00000.json"), false},
+		{filepath.Join(QueueDir(tsdbPath), "sub", "1-2-00000.json"), false},
+	}
+	for _, tt := range tests {
+		if got := IsRequest(tsdbPath, tt.path); got != tt.want {
+			t.Errorf("IsRequest(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+// deadPID returns the pid of a process that has exited
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestAcquireLock(t *testing.T) {
+	tests := []struct {
+		name    string
+		lock    func(t *testing.T) string // Content of the lock found, if any
+		acquire bool
+	}{
+		{"no lock", nil, true},
+		{"stale lock", func(t *testing.T) string { return strconv.Itoa(deadPID(t)) }, true},
+		{"garbled lock", func(t *testing.T) string { return "not a pid" }, true},
+		{"live lock", func(t *testing.T) string { return strconv.Itoa(os.Getppid()) }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tsdbPath := t.TempDir()
+			if tt.lock != nil {
+				if err := os.WriteFile(LockPath(tsdbPath), []byte(tt.lock(t)), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			lock, err := AcquireLock(tsdbPath)
+			if !tt.acquire {
+				if err == nil {
+					t.Fatal("took a lock held by a running process")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pid, running := DaemonPID(tsdbPath); !running || pid != os.Getpid() {
+				t.Errorf("lock held by pid %d (running %v), want %d", pid, running, os.Getpid())
+			}
+			if _, err := os.Stat(QueueDir(tsdbPath)); err != nil {
+				t.Errorf("queue not created: %v", err)
+			}
+
+			if _, err := AcquireLock(tsdbPath); err == nil {
+				t.Error("took the lock twice")
+			}
+			if err := lock.Release(); err != nil {
+				t.Fatal(err)
+			}
+			if _, running := DaemonPID(tsdbPath); running {
+				t.Error("lock still held once released")
+			}
+		})
+	}
+}
+
+func TestRequestJSON(t *testing.T) {
+	tsdbPath := t.TempDir()
+	if err := os.MkdirAll(QueueDir(tsdbPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Enqueue(tsdbPath, []string{"/stats/node.gfs"}); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := PendingRequests(tsdbPath)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("pending %v, %v", pending, err)
+	}
+	data, err := os.ReadFile(pending[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["file"] != "/stats/node.gfs" || fields["queued_at"] == nil {
+		t.Errorf("request %s lacks the file or the time it was queued", data)
+	}
+}
@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Level is the minimum severity a Logger writes
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+)
+
+// Logger is the levelled logger the reader, converter, processor and
+// watchers write to
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// stdLogger writes the messages at or above its level through the standard
+// logger, so they follow log.SetOutput to a --log-file
+type stdLogger struct {
+	level Level
+}
+
+// New returns a Logger writing messages at or above level through the
+// standard logger
+func New(level Level) Logger {
+	return &stdLogger{level: level}
+}
+
+func (l *stdLogger) Debugf(format string, args ...interface{}) {
+	if l.level <= LevelDebug {
+		log.Output(2, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l *stdLogger) Infof(format string, args ...interface{}) {
+	if l.level <= LevelInfo {
+		log.Output(2, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l *stdLogger) Warnf(format string, args ...interface{}) {
+	log.Output(2, "Warning: "+fmt.Sprintf(format, args...))
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Debugf(string, ...interface{}) {}
+func (discardLogger) Infof(string, ...interface{})  {}
+func (discardLogger) Warnf(string, ...interface{})  {}
+
+// Discard is a Logger that writes nothing
+var Discard Logger = discardLogger{}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLogger = New(LevelInfo)
+)
+
+// Default returns the logger components use unless given another one. It
+// writes info and above until SetDefault is called.
+func Default() Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the logger returned by Default
+func SetDefault(l Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
@@ -0,0 +1,106 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an io.Writer that writes to a log file and rotates it once
+// it reaches maxBytes. Rotated files are renamed to path.1, path.2, ...
+// and at most maxFiles files, including the active one, are kept.
+type RotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// OpenRotatingFile opens path for appending. maxMegabytes <= 0 disables
+// rotation; maxFiles < 1 is treated as 1.
+func OpenRotatingFile(path string, maxMegabytes, maxFiles int) (*RotatingFile, error) {
+	if maxFiles < 1 {
+		maxFiles = 1
+	}
+
+	r := &RotatingFile{
+		path:     path,
+		maxBytes: int64(maxMegabytes) * 1024 * 1024,
+		maxFiles: maxFiles,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxBytes > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the active log file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+// rotate shifts path.N-1 to path.N, ..., path to path.1, dropping the
+// oldest file, and reopens path
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	if r.maxFiles == 1 {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return r.open()
+	}
+
+	os.Remove(r.backupName(r.maxFiles - 1))
+	for i := r.maxFiles - 2; i >= 1; i-- {
+		if err := os.Rename(r.backupName(i), r.backupName(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(r.path, r.backupName(1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return r.open()
+}
+
+func (r *RotatingFile) backupName(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
@@ -0,0 +1,524 @@
+// Package mimir backfills Grafana Mimir by uploading TSDB blocks through
+// its block upload API rather than remote write. Progress is kept per
+// file in the TSDB directory, so an interrupted upload of a large block
+// resumes after the files that were already accepted.
+package mimir
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+const (
+	stateFileName = "mimir-upload.json"
+	metaFileName  = "meta.json"
+
+	// uploadPrefix is the path of Mimir's block upload endpoints
+	uploadPrefix = "/api/v1/upload/block"
+)
+
+// StatePath returns the path of the upload progress kept in tsdbPath
+func StatePath(tsdbPath string) string {
+	return filepath.Join(tsdbPath, stateFileName)
+}
+
+// Options configures an Uploader
+type Options struct {
+	// URL is the base URL of Mimir, or of the gateway in front of it
+	URL    string
+	Tenant string
+
+	// Username and Password set basic auth; BearerToken, if set, is sent
+	// instead
+	Username    string
+	Password    string
+	BearerToken string
+
+	// Retries is how many times a request failing with a network error,
+	// 429 or 5xx is retried, with exponential backoff from RetryBackoff
+	Retries      int
+	RetryBackoff time.Duration
+
+	// ValidationTimeout is how long to wait for Mimir to validate a block
+	// once its upload is finished
+	ValidationTimeout time.Duration
+
+	Client *http.Client
+	Logger logging.Logger
+}
+
+// Result lists the blocks of an upload run
+type Result struct {
+	// Accepted are the blocks Mimir accepted in this run
+	Accepted []string `json:"accepted"`
+	// Skipped were accepted by an earlier run, or hold only data of blocks
+	// that were
+	Skipped []string `json:"skipped"`
+}
+
+// Uploader uploads the blocks of a TSDB directory to one Mimir tenant
+type Uploader struct {
+	opts Options
+	base *url.URL
+}
+
+// NewUploader checks the options and returns an Uploader for them
+func NewUploader(opts Options) (*Uploader, error) {
+	base, err := url.Parse(opts.URL)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("invalid Mimir URL %q", opts.URL)
+	}
+	if opts.Tenant == "" {
+		return nil, fmt.Errorf("a tenant is required to upload blocks to Mimir")
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{}
+	}
+	if opts.Logger == nil {
+		opts.Logger = logging.Discard
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = time.Second
+	}
+	if opts.ValidationTimeout <= 0 {
+		opts.ValidationTimeout = 10 * time.Minute
+	}
+	return &Uploader{opts: opts, base: base}, nil
+}
+
+// uploadState records what was uploaded to each Mimir tenant
+type uploadState struct {
+	// Targets is keyed by tenant and URL
+	Targets map[string]*targetState `json:"targets"`
+}
+
+type targetState struct {
+	// Accepted lists blocks Mimir accepted; Files the files of blocks
+	// whose upload has started, by block
+	Accepted []string            `json:"accepted"`
+	Files    map[string][]string `json:"files,omitempty"`
+}
+
+// Upload uploads every block in tsdbPath that this tenant has not
+// accepted yet. Blocks compacted only from accepted blocks are skipped,
+// as Mimir already holds their data.
+func (u *Uploader) Upload(ctx context.Context, tsdbPath string) (*Result, error) {
+	statePath := StatePath(tsdbPath)
+	state, err := loadState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	key := u.opts.Tenant + "@" + u.base.String()
+	target := state.Targets[key]
+	if target == nil {
+		target = &targetState{}
+		state.Targets[key] = target
+	}
+	if target.Files == nil {
+		target.Files = make(map[string][]string)
+	}
+
+	metas, err := blockMetas(tsdbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	for _, meta := range metas {
+		id := meta.ULID.String()
+		if covered(meta, target.Accepted) {
+			result.Skipped = append(result.Skipped, id)
+			continue
+		}
+
+		save := func() error { return saveState(statePath, state) }
+		if err := u.uploadBlock(ctx, filepath.Join(tsdbPath, id), id, target, save); err != nil {
+			return result, fmt.Errorf("failed to upload block %s: %w", id, err)
+		}
+		target.Accepted = append(target.Accepted, id)
+		delete(target.Files, id)
+		if err := save(); err != nil {
+			return result, err
+		}
+		result.Accepted = append(result.Accepted, id)
+	}
+	return result, nil
+}
+
+// covered reports whether the block was accepted, or was compacted only
+// from blocks that were
+func covered(meta tsdb.BlockMeta, accepted []string) bool {
+	isAccepted := func(id string) bool {
+		for _, a := range accepted {
+			if a == id {
+				return true
+			}
+		}
+		return false
+	}
+	if isAccepted(meta.ULID.String()) {
+		return true
+	}
+	if len(meta.Compaction.Sources) == 0 {
+		return false
+	}
+	for _, source := range meta.Compaction.Sources {
+		if !isAccepted(source.String()) {
+			return false
+		}
+	}
+	return true
+}
+
+// uploadBlock uploads one block: start with its metadata, upload each of
+// its files not uploaded before, finish, and wait for Mimir to validate it
+func (u *Uploader) uploadBlock(ctx context.Context, dir, id string, target *targetState, save func() error) error {
+	files, err := blockFiles(dir)
+	if err != nil {
+		return err
+	}
+	meta, err := uploadMeta(dir, files)
+	if err != nil {
+		return err
+	}
+
+	status, err := u.check(ctx, id)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case "complete":
+		u.opts.Logger.Infof("Block %s was already accepted by Mimir", id)
+		return nil
+	case "validating":
+		return u.waitValidated(ctx, id)
+	}
+
+	// Mimir keeps the files of an unfinished upload, so only the ones not
+	// uploaded yet are sent. An upload Mimir does not know of starts over.
+	uploaded := target.Files[id]
+	if status != "uploading" {
+		uploaded = nil
+		if _, _, err := u.do(ctx, http.MethodPost, id, "start", func() (io.Reader, int64, error) {
+			return bytes.NewReader(meta), int64(len(meta)), nil
+		}); err != nil {
+			return err
+		}
+		target.Files[id] = []string{}
+		if err := save(); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range files {
+		if f.RelPath == metaFileName || contains(uploaded, f.RelPath) {
+			continue
+		}
+		u.opts.Logger.Infof("Uploading %s/%s (%d bytes)", id, f.RelPath, f.SizeBytes)
+		filePath := filepath.Join(dir, filepath.FromSlash(f.RelPath))
+		query := url.Values{"path": {f.RelPath}}
+		_, _, err := u.do(ctx, http.MethodPost, id, "files?"+query.Encode(), func() (io.Reader, int64, error) {
+			file, err := os.Open(filePath)
+			if err != nil {
+				return nil, 0, err
+			}
+			return file, f.SizeBytes, nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.RelPath, err)
+		}
+		target.Files[id] = append(target.Files[id], f.RelPath)
+		if err := save(); err != nil {
+			return err
+		}
+	}
+
+	if _, _, err := u.do(ctx, http.MethodPost, id, "finish", nil); err != nil {
+		return err
+	}
+	return u.waitValidated(ctx, id)
+}
+
+// waitValidated polls the state of a finished upload until Mimir has
+// validated the block
+func (u *Uploader) waitValidated(ctx context.Context, id string) error {
+	deadline := time.Now().Add(u.opts.ValidationTimeout)
+	for {
+		status, err := u.check(ctx, id)
+		if err != nil {
+			return err
+		}
+		if status == "complete" {
+			return nil
+		}
+		if status != "validating" {
+			return fmt.Errorf("upload is %s after being finished", status)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Mimir did not validate the block within %s", u.opts.ValidationTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(u.opts.RetryBackoff):
+		}
+	}
+}
+
+// checkResponse is the body of the check endpoint
+type checkResponse struct {
+	Result string `json:"result"`
+	Error  string `json:"error"`
+}
+
+// check returns the state of a block's upload: complete, uploading,
+// validating, or none if Mimir knows nothing of it. A failed validation
+// is an error.
+func (u *Uploader) check(ctx context.Context, id string) (string, error) {
+	status, body, err := u.do(ctx, http.MethodGet, id, "check", nil)
+	if err != nil {
+		return "", err
+	}
+	if status == http.StatusNotFound {
+		return "none", nil
+	}
+
+	var resp checkResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("invalid response to upload check: %w", err)
+	}
+	if resp.Result == "failed" {
+		return "", fmt.Errorf("Mimir rejected the block: %s", resp.Error)
+	}
+	return resp.Result, nil
+}
+
+// do sends a request to a block upload endpoint and returns the status
+// and body of the response, retrying network errors, 429 and 5xx
+// responses. body, if not nil, opens the request body afresh for every
+// attempt. 404 from the check endpoint is returned as a status; every
+// other 4xx is an error.
+func (u *Uploader) do(ctx context.Context, method, id, endpoint string, body func() (io.Reader, int64, error)) (int, []byte, error) {
+	rel, err := url.Parse(path.Join(uploadPrefix, url.PathEscape(id), endpoint))
+	if err != nil {
+		return 0, nil, err
+	}
+	target := *u.base
+	target.Path = strings.TrimSuffix(target.Path, "/") + rel.Path
+	target.RawQuery = rel.RawQuery
+
+	backoff := u.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		status, respBody, err := u.attempt(ctx, method, target.String(), body)
+		retryable := err != nil && !errors.Is(err, errPermanent)
+		if err == nil {
+			switch {
+			case status < 300, status == http.StatusNotFound && endpoint == "check":
+				return status, respBody, nil
+			case status == http.StatusTooManyRequests || status >= 500:
+				retryable = true
+				err = fmt.Errorf("%s: %d %s", endpoint, status, http.StatusText(status))
+			default:
+				return status, respBody, fmt.Errorf("%s: %d %s: %s", endpoint, status, http.StatusText(status), bytes.TrimSpace(respBody))
+			}
+		}
+		if !retryable || attempt >= u.opts.Retries {
+			return status, respBody, err
+		}
+
+		u.opts.Logger.Warnf("Retrying %s of block %s in %s: %v", endpoint, id, backoff, err)
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// errPermanent marks failures that retrying cannot fix, such as a file
+// that cannot be opened
+var errPermanent = errors.New("permanent failure")
+
+func (u *Uploader) attempt(ctx context.Context, method, target string, body func() (io.Reader, int64, error)) (int, []byte, error) {
+	var reader io.Reader
+	var length int64
+	if body != nil {
+		var err error
+		if reader, length, err = body(); err != nil {
+			return 0, nil, fmt.Errorf("%w: %v", errPermanent, err)
+		}
+		if closer, ok := reader.(io.Closer); ok {
+			defer closer.Close()
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	if body != nil {
+		req.ContentLength = length
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	req.Header.Set("X-Scope-OrgID", u.opts.Tenant)
+	switch {
+	case u.opts.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+u.opts.BearerToken)
+	case u.opts.Username != "":
+		req.SetBasicAuth(u.opts.Username, u.opts.Password)
+	}
+
+	resp, err := u.opts.Client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, data, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// blockFile is an entry of the file list Mimir expects in the metadata of
+// an uploaded block
+type blockFile struct {
+	RelPath   string `json:"rel_path"`
+	SizeBytes int64  `json:"size_bytes,omitempty"`
+}
+
+// blockFiles lists the files of a block, meta.json last
+func blockFiles(dir string) ([]blockFile, error) {
+	var files []blockFile
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == metaFileName {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, blockFile{RelPath: rel, SizeBytes: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].RelPath < files[j].RelPath })
+	return append(files, blockFile{RelPath: metaFileName}), nil
+}
+
+// uploadMeta returns the metadata sent to start the upload of a block:
+// its meta.json with the Thanos section Mimir requires, listing its files
+func uploadMeta(dir string, files []blockFile) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dir, metaFileName))
+	if err != nil {
+		return nil, err
+	}
+	var meta map[string]interface{}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("invalid block metadata: %w", err)
+	}
+	meta["thanos"] = map[string]interface{}{
+		"labels":     map[string]string{},
+		"downsample": map[string]int64{"resolution": 0},
+		"source":     "gfs-to-prometheus",
+		"files":      files,
+	}
+	return json.Marshal(meta)
+}
+
+// blockMetas returns the metadata of every block in the data directory,
+// oldest first
+func blockMetas(dataPath string) ([]tsdb.BlockMeta, error) {
+	entries, err := os.ReadDir(dataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var metas []tsdb.BlockMeta
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dataPath, entry.Name(), metaFileName))
+		if err != nil {
+			continue
+		}
+		var meta tsdb.BlockMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("invalid block metadata in %s: %w", entry.Name(), err)
+		}
+		metas = append(metas, meta)
+	}
+
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].MinTime < metas[j].MinTime
+	})
+	return metas, nil
+}
+
+func loadState(path string) (*uploadState, error) {
+	state := &uploadState{}
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("invalid Mimir upload state %s: %w", path, err)
+		}
+	}
+	if state.Targets == nil {
+		state.Targets = make(map[string]*targetState)
+	}
+	return state, nil
+}
+
+func saveState(path string, state *uploadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,169 @@
+// Package plot draws time series as text charts for a terminal.
+package plot
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Glyphs mark the points of each series, in the order series are drawn.
+// Points of different series that land in the same cell are marked with
+// OverlapGlyph.
+var Glyphs = []rune{'*', '+', 'o', 'x', '@', '%'}
+
+// OverlapGlyph marks a cell shared by more than one series
+const OverlapGlyph = '#'
+
+// Series is one line of a chart. Times and Values have the same length and
+// Times are in order.
+type Series struct {
+	Name   string
+	Times  []time.Time
+	Values []float64
+}
+
+// Stats summarizes the values of a series
+type Stats struct {
+	Min, Max, Avg float64
+	Count         int
+}
+
+// Summarize returns the minimum, maximum and average of the series
+func (s Series) Summarize() Stats {
+	stats := Stats{Count: len(s.Values)}
+	if stats.Count == 0 {
+		return stats
+	}
+	stats.Min, stats.Max = math.Inf(1), math.Inf(-1)
+	sum := 0.0
+	for _, v := range s.Values {
+		stats.Min = math.Min(stats.Min, v)
+		stats.Max = math.Max(stats.Max, v)
+		sum += v
+	}
+	stats.Avg = sum / float64(stats.Count)
+	return stats
+}
+
+// Render draws the series on a grid of width columns by height rows, with
+// the value axis on the left, the time axis below and a legend giving the
+// glyph, minimum, maximum and average of each series. Each column shows the
+// average of the samples that fall in its slice of time.
+func Render(w io.Writer, series []Series, width, height int) error {
+	if len(series) > len(Glyphs) {
+		return fmt.Errorf("cannot plot %d series (at most %d)", len(series), len(Glyphs))
+	}
+	if width < 2 || height < 2 {
+		return fmt.Errorf("chart must be at least 2x2, got %dx%d", width, height)
+	}
+
+	var start, end time.Time
+	vmin, vmax := math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		if len(s.Times) == 0 {
+			continue
+		}
+		if start.IsZero() || s.Times[0].Before(start) {
+			start = s.Times[0]
+		}
+		if last := s.Times[len(s.Times)-1]; last.After(end) {
+			end = last
+		}
+		stats := s.Summarize()
+		vmin, vmax = math.Min(vmin, stats.Min), math.Max(vmax, stats.Max)
+	}
+	if start.IsZero() {
+		return fmt.Errorf("no samples to plot")
+	}
+
+	grid := make([][]rune, height)
+	for row := range grid {
+		grid[row] = []rune(strings.Repeat(" ", width))
+	}
+	for i, s := range series {
+		for col, v := range columns(s, start, end, width) {
+			if math.IsNaN(v) {
+				continue
+			}
+			row := height / 2
+			if vmax > vmin {
+				row = height - 1 - int(math.Round((v-vmin)/(vmax-vmin)*float64(height-1)))
+			}
+			if grid[row][col] != ' ' && grid[row][col] != Glyphs[i] {
+				grid[row][col] = OverlapGlyph
+			} else {
+				grid[row][col] = Glyphs[i]
+			}
+		}
+	}
+
+	// Label the top, middle and bottom rows
+	labels := make([]string, height)
+	labels[0] = formatValue(vmax)
+	labels[height-1] = formatValue(vmin)
+	if height > 2 {
+		labels[height/2] = formatValue(vmin + (vmax-vmin)*float64(height-1-height/2)/float64(height-1))
+	}
+	labelWidth := 0
+	for _, label := range labels {
+		labelWidth = max(labelWidth, len(label))
+	}
+
+	for row, cells := range grid {
+		if _, err := fmt.Fprintf(w, "%*s |%s\n", labelWidth, labels[row], string(cells)); err != nil {
+			return err
+		}
+	}
+
+	first, last := start.Format(time.RFC3339), end.Format(time.RFC3339)
+	axis := first
+	if gap := width + 1 - len(first) - len(last); gap > 0 {
+		axis += strings.Repeat(" ", gap) + last
+	} else {
+		axis += " - " + last
+	}
+	if _, err := fmt.Fprintf(w, "%*s +%s\n%*s %s\n\n", labelWidth, "", strings.Repeat("-", width), labelWidth, "", axis); err != nil {
+		return err
+	}
+
+	for i, s := range series {
+		stats := s.Summarize()
+		if _, err := fmt.Fprintf(w, "  %c %s  min=%s max=%s avg=%s samples=%d\n", Glyphs[i], s.Name,
+			formatValue(stats.Min), formatValue(stats.Max), formatValue(stats.Avg), stats.Count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columns averages the samples of s into width slices of [start, end].
+// Columns without samples are NaN.
+func columns(s Series, start, end time.Time, width int) []float64 {
+	sums := make([]float64, width)
+	counts := make([]int, width)
+	span := end.Sub(start)
+	for i, t := range s.Times {
+		col := 0
+		if span > 0 {
+			col = int(float64(t.Sub(start)) / float64(span) * float64(width-1))
+		}
+		sums[col] += s.Values[i]
+		counts[col]++
+	}
+	for col := range sums {
+		if counts[col] == 0 {
+			sums[col] = math.NaN()
+		} else {
+			sums[col] /= float64(counts[col])
+		}
+	}
+	return sums
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
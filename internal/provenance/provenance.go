@@ -0,0 +1,114 @@
+package provenance
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const historyFileName = "import-history.jsonl"
+
+// Record is the audit entry written for every converted file. Records are
+// only ever appended; nothing that resets watcher state touches them.
+type Record struct {
+	File           string    `json:"file"`
+	SHA256         string    `json:"sha256"`
+	ImportedAt     time.Time `json:"imported_at"`
+	ToolVersion    string    `json:"tool_version"`
+	ConfigHash     string    `json:"config_hash"`
+	SamplesWritten int       `json:"samples_written"`
+}
+
+// History is the append-only import log kept next to a TSDB
+type History struct {
+	mu   sync.Mutex
+	path string
+}
+
+// HistoryPath returns the location of the import log for tsdbPath
+func HistoryPath(tsdbPath string) string {
+	return filepath.Join(tsdbPath, historyFileName)
+}
+
+func OpenHistory(tsdbPath string) *History {
+	return &History{path: HistoryPath(tsdbPath)}
+}
+
+// Append adds a record to the end of the log
+func (h *History) Append(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open import history: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write import history: %w", err)
+	}
+	return nil
+}
+
+// ReadHistory returns every record in the import log of tsdbPath, oldest
+// first. A missing log is an empty history.
+func ReadHistory(tsdbPath string) ([]Record, error) {
+	f, err := os.Open(HistoryPath(tsdbPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid import history entry on line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// HashFile returns the hex SHA-256 of a file's contents
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashBytes returns the hex SHA-256 of data
+func HashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,460 @@
+package relabel
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	checkpointFileName = "relabel-checkpoint.json"
+	stagingDirName     = "relabel-staging"
+	metaFileName       = "meta.json"
+)
+
+// Rules is the on-disk layout of a rules file: standard Prometheus
+// relabel_configs, applied to every series in order
+type Rules struct {
+	RelabelConfigs []*relabel.Config `yaml:"relabel_configs"`
+
+	hash string
+}
+
+// LoadRules reads and validates a rules file
+func LoadRules(filename string) (*Rules, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules Rules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	if len(rules.RelabelConfigs) == 0 {
+		return nil, fmt.Errorf("%s has no relabel_configs", filename)
+	}
+
+	sum := sha256.Sum256(data)
+	rules.hash = hex.EncodeToString(sum[:])
+	return &rules, nil
+}
+
+// Change counts the series renamed from one metric to another, or dropped
+// when To is empty
+type Change struct {
+	From   string
+	To     string
+	Series int
+}
+
+// Result summarizes a relabel run
+type Result struct {
+	Blocks          int
+	BlocksSkipped   int
+	SeriesTotal     int
+	SeriesRelabeled int
+	SeriesDropped   int
+	// SamplesMerged counts samples dropped because a relabeled series
+	// collided with another at the same timestamp
+	SamplesMerged int
+	Changes       []Change
+}
+
+// checkpoint records the blocks a run has already written so an
+// interrupted run can resume without relabeling them twice
+type checkpoint struct {
+	RulesHash string    `json:"rules_hash"`
+	Started   time.Time `json:"started"`
+	Completed []string  `json:"completed"`
+}
+
+// Relabeler rewrites the persisted blocks of a TSDB
+type Relabeler struct {
+	dataPath string
+	rules    *Rules
+	logger   kitlog.Logger
+
+	checkpoint *checkpoint
+	changes    map[[2]string]int
+}
+
+func New(dataPath string, rules *Rules) *Relabeler {
+	return &Relabeler{
+		dataPath: dataPath,
+		rules:    rules,
+		logger:   kitlog.NewNopLogger(),
+		changes:  make(map[[2]string]int),
+	}
+}
+
+// Run relabels every block. Each block is replaced by a new block that lists it as its
+// parent, and the new block is moved into place with a single rename, so
+// an interrupted run leaves every block either old or fully relabeled;
+// Prometheus deletes parents it finds on startup. Progress is checkpointed
+// after every block and a later run with the same rules resumes.
+func (r *Relabeler) Run(progress func(block string, done, total int)) (*Result, error) {
+	lock, _, err := fileutil.Flock(filepath.Join(r.dataPath, "lock"))
+	if err != nil {
+		return nil, fmt.Errorf("TSDB at %s is in use: %w", r.dataPath, err)
+	}
+	defer lock.Release()
+
+	if err := r.loadCheckpoint(); err != nil {
+		return nil, err
+	}
+
+	metas, err := r.blockMetas()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	for i, meta := range metas {
+		id := meta.ULID.String()
+		if progress != nil {
+			progress(id, i, len(metas))
+		}
+
+		if r.completed(id) {
+			result.BlocksSkipped++
+			continue
+		}
+		if r.supersededBy(meta, metas) {
+			// Replaced in an earlier run that stopped before removing it
+			if err := os.RemoveAll(filepath.Join(r.dataPath, id)); err != nil {
+				return nil, err
+			}
+			result.BlocksSkipped++
+			continue
+		}
+
+		if err := r.rewriteBlock(meta, result); err != nil {
+			return nil, fmt.Errorf("block %s: %w", id, err)
+		}
+		result.Blocks++
+	}
+
+	result.Changes = r.sortedChanges()
+
+	os.RemoveAll(StagingDir(r.dataPath))
+	if err := os.Remove(r.checkpointPath()); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DryRun counts the series the rules would change without modifying
+// anything. Unlike Run it also sees data not yet persisted as blocks.
+func (r *Relabeler) DryRun() (*Result, error) {
+	db, err := tsdb.OpenDBReadOnly(r.dataPath, r.logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open TSDB: %w", err)
+	}
+	defer db.Close()
+
+	querier, err := db.Querier(math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return nil, err
+	}
+	defer querier.Close()
+
+	result := &Result{}
+	set := querier.Select(context.Background(), false, nil, allSeries())
+	for set.Next() {
+		r.relabelSeries(set.At().Labels(), result)
+	}
+	if err := set.Err(); err != nil {
+		return nil, err
+	}
+
+	result.Changes = r.sortedChanges()
+	return result, nil
+}
+
+func allSeries() *labels.Matcher {
+	return labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")
+}
+
+// relabelSeries applies the rules to one series and counts the outcome. It
+// returns false if the series is dropped.
+func (r *Relabeler) relabelSeries(oldLabels labels.Labels, result *Result) (labels.Labels, bool) {
+	result.SeriesTotal++
+
+	newLabels, keep := relabel.Process(oldLabels, r.rules.RelabelConfigs...)
+	oldName := oldLabels.Get(labels.MetricName)
+	if !keep || newLabels.Get(labels.MetricName) == "" {
+		result.SeriesDropped++
+		r.changes[[2]string{oldName, ""}]++
+		return labels.EmptyLabels(), false
+	}
+	if !labels.Equal(oldLabels, newLabels) {
+		result.SeriesRelabeled++
+		r.changes[[2]string{oldName, newLabels.Get(labels.MetricName)}]++
+	}
+	return newLabels, true
+}
+
+// rewriteBlock relabels one block into a staging directory and swaps it in
+func (r *Relabeler) rewriteBlock(meta tsdb.BlockMeta, result *Result) error {
+	blockDir := filepath.Join(r.dataPath, meta.ULID.String())
+	block, err := tsdb.OpenBlock(r.logger, blockDir, nil)
+	if err != nil {
+		return err
+	}
+	defer block.Close()
+
+	querier, err := tsdb.NewBlockQuerier(block, meta.MinTime, meta.MaxTime)
+	if err != nil {
+		return err
+	}
+	defer querier.Close()
+
+	stagingDir := StagingDir(r.dataPath)
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return err
+	}
+	blockSize := meta.MaxTime - meta.MinTime
+	if blockSize < tsdb.DefaultBlockDuration {
+		blockSize = tsdb.DefaultBlockDuration
+	}
+	writer, err := tsdb.NewBlockWriter(r.logger, stagingDir, blockSize)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+	appender := writer.Appender(context.Background())
+
+	kept := 0
+	set := querier.Select(context.Background(), false, nil, allSeries())
+	var it chunkenc.Iterator
+	for set.Next() {
+		series := set.At()
+		newLabels, keep := r.relabelSeries(series.Labels(), result)
+		if !keep {
+			continue
+		}
+		kept++
+
+		it = series.Iterator(it)
+		for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+			if vt != chunkenc.ValFloat {
+				return fmt.Errorf("series %s has non-float samples, which relabel does not support", series.Labels())
+			}
+			ts, value := it.At()
+			if _, err := appender.Append(0, newLabels, ts, value); err != nil {
+				if errors.Is(err, storage.ErrDuplicateSampleForTimestamp) || errors.Is(err, storage.ErrOutOfOrderSample) {
+					result.SamplesMerged++
+					continue
+				}
+				return err
+			}
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+	if err := set.Err(); err != nil {
+		return err
+	}
+
+	// Every series was dropped: nothing replaces the block
+	if kept == 0 {
+		if err := r.markCompleted(""); err != nil {
+			return err
+		}
+		return os.RemoveAll(blockDir)
+	}
+
+	if err := appender.Commit(); err != nil {
+		return err
+	}
+	newID, err := writer.Flush(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if err := adoptParent(filepath.Join(stagingDir, newID.String()), meta); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(stagingDir, newID.String()), filepath.Join(r.dataPath, newID.String())); err != nil {
+		return err
+	}
+	if err := r.markCompleted(newID.String()); err != nil {
+		return err
+	}
+	return os.RemoveAll(blockDir)
+}
+
+// adoptParent makes the block in dir replace parent: it takes over the
+// parent's time range and compaction level and lists it as its parent
+func adoptParent(dir string, parent tsdb.BlockMeta) error {
+	path := filepath.Join(dir, metaFileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var meta tsdb.BlockMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return err
+	}
+
+	meta.MinTime = parent.MinTime
+	meta.MaxTime = parent.MaxTime
+	meta.Compaction.Level = parent.Compaction.Level
+	meta.Compaction.Parents = []tsdb.BlockDesc{
+		{ULID: parent.ULID, MinTime: parent.MinTime, MaxTime: parent.MaxTime},
+	}
+
+	data, err = json.MarshalIndent(&meta, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// blockMetas returns the metadata of every block in the data directory,
+// oldest first
+func (r *Relabeler) blockMetas() ([]tsdb.BlockMeta, error) {
+	entries, err := os.ReadDir(r.dataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var metas []tsdb.BlockMeta
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(r.dataPath, entry.Name(), metaFileName))
+		if err != nil {
+			continue
+		}
+		var meta tsdb.BlockMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("invalid block metadata in %s: %w", entry.Name(), err)
+		}
+		metas = append(metas, meta)
+	}
+
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].MinTime < metas[j].MinTime
+	})
+	return metas, nil
+}
+
+// supersededBy reports whether another block lists meta as its parent
+func (r *Relabeler) supersededBy(meta tsdb.BlockMeta, metas []tsdb.BlockMeta) bool {
+	for _, other := range metas {
+		for _, parent := range other.Compaction.Parents {
+			if parent.ULID == meta.ULID && other.ULID != meta.ULID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *Relabeler) checkpointPath() string {
+	return CheckpointPath(r.dataPath)
+}
+
+// CheckpointPath returns the path of the checkpoint an interrupted run
+// leaves in dataPath
+func CheckpointPath(dataPath string) string {
+	return filepath.Join(dataPath, checkpointFileName)
+}
+
+// StagingDir returns the directory relabeled blocks are written to before
+// they are moved into place
+func StagingDir(dataPath string) string {
+	return filepath.Join(dataPath, stagingDirName)
+}
+
+// loadCheckpoint resumes an interrupted run with the same rules. A
+// checkpoint left by different rules is an error, since blocks it lists
+// would otherwise be relabeled twice.
+func (r *Relabeler) loadCheckpoint() error {
+	data, err := os.ReadFile(r.checkpointPath())
+	if os.IsNotExist(err) {
+		r.checkpoint = &checkpoint{RulesHash: r.rules.hash, Started: time.Now()}
+		return r.saveCheckpoint()
+	}
+	if err != nil {
+		return err
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return fmt.Errorf("invalid relabel checkpoint: %w", err)
+	}
+	if cp.RulesHash != r.rules.hash {
+		return fmt.Errorf("an interrupted relabel run with different rules (started %s) has not finished; rerun it with its rules file or remove %s",
+			cp.Started.Format(time.RFC3339), r.checkpointPath())
+	}
+	r.checkpoint = &cp
+	return nil
+}
+
+func (r *Relabeler) saveCheckpoint() error {
+	data, err := json.Marshal(r.checkpoint)
+	if err != nil {
+		return err
+	}
+	tmpPath := r.checkpointPath() + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, r.checkpointPath())
+}
+
+func (r *Relabeler) markCompleted(id string) error {
+	if id != "" {
+		r.checkpoint.Completed = append(r.checkpoint.Completed, id)
+	}
+	return r.saveCheckpoint()
+}
+
+func (r *Relabeler) completed(id string) bool {
+	if r.checkpoint == nil {
+		return false
+	}
+	for _, done := range r.checkpoint.Completed {
+		if done == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Relabeler) sortedChanges() []Change {
+	changes := make([]Change, 0, len(r.changes))
+	for key, count := range r.changes {
+		changes = append(changes, Change{From: key[0], To: key[1], Series: count})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].From != changes[j].From {
+			return changes[i].From < changes[j].From
+		}
+		return changes[i].To < changes[j].To
+	})
+	return changes
+}
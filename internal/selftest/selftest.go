@@ -0,0 +1,204 @@
+// Package selftest writes a synthetic statistics archive, reads it back
+// and converts it into a scratch TSDB, checking every step against what was
+// written, to validate an installation end to end. What each feature does
+// with an archive is covered by the tests of its package.
+package selftest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+	"github.com/4n3w/gfs-to-prometheus/internal/tsdb"
+)
+
+// Options sizes the synthetic archive: Types resource types with
+// Instances instances each, sampled Samples times
+type Options = gfstest.Options
+
+// Step is the outcome of one step of the self test
+type Step struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// Report is the outcome of a self test
+type Report struct {
+	Archive string `json:"archive"`
+	TSDB    string `json:"tsdb"`
+	Steps   []Step `json:"steps"`
+}
+
+// Failed reports whether any step failed
+func (r *Report) Failed() bool {
+	for _, step := range r.Steps {
+		if !step.OK {
+			return true
+		}
+	}
+	return false
+}
+
+// Run writes the synthetic archive and a TSDB under dir and checks them.
+// Steps after a failed one are not run.
+func Run(dir string, opts Options) (*Report, error) {
+	if opts.Types < 1 || opts.Instances < 1 || opts.Samples < 1 {
+		return nil, fmt.Errorf("types, instances and samples must all be at least 1")
+	}
+
+	report := &Report{
+		Archive: filepath.Join(dir, "selftest.gfs"),
+		TSDB:    filepath.Join(dir, "tsdb"),
+	}
+	start := time.Now().Add(-time.Duration(opts.Samples) * gfstest.SampleInterval).Truncate(time.Second)
+	steps := []struct {
+		name string
+		run  func() (string, error)
+	}{
+		{"write archive", func() (string, error) { return writeArchive(report.Archive, start, opts) }},
+		{"read archive", func() (string, error) { return readArchive(report.Archive, start, opts) }},
+		{"convert", func() (string, error) { return convert(report.Archive, report.TSDB, converter.Options{}) }},
+		{"query TSDB", func() (string, error) { return queryTSDB(report.TSDB, start, opts) }},
+		{"convert with low memory", func() (string, error) {
+			return convertLowMemory(report.Archive, filepath.Join(dir, "tsdb-lowmem"), start, opts)
+		}},
+		{"compare parsers", func() (string, error) {
+			return compareParsers(report.Archive, filepath.Join(dir, "tsdb-parser-go"), filepath.Join(dir, "tsdb-parser-java"), start, opts)
+		}},
+	}
+	for _, s := range steps {
+		detail, err := s.run()
+		step := Step{Name: s.name, OK: err == nil, Detail: detail}
+		if err != nil {
+			step.Detail = err.Error()
+		}
+		report.Steps = append(report.Steps, step)
+		if err != nil {
+			break
+		}
+	}
+	return report, nil
+}
+
+func writeArchive(path string, start time.Time, opts Options) (string, error) {
+	if err := gfstest.WriteFile(path, start, opts); err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d types x %d instances x %d samples, %d bytes", opts.Types, opts.Instances, opts.Samples, info.Size()), nil
+}
+
+// readArchive reads the archive back and compares every value and
+// timestamp with what was written
+func readArchive(path string, start time.Time, opts Options) (string, error) {
+	values, report, err := gfstest.Verify(path, start, opts)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d values match, %s", values, report), nil
+}
+
+func convert(archive, tsdbPath string, options converter.Options) (string, error) {
+	options.Logger = logging.Discard
+	options.ToolVersion = "selftest"
+	conv, err := converter.New(tsdbPath, "", options)
+	if err != nil {
+		return "", err
+	}
+	report, err := conv.ConvertFile(archive)
+	if closeErr := conv.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return report.String(), nil
+}
+
+// convertLowMemory converts the archive through a spill file, appending
+// its samples on a pipeline goroutine, and checks that the TSDB holds the
+// same series as a conversion in memory
+func convertLowMemory(archive, tsdbPath string, start time.Time, opts Options) (string, error) {
+	if _, err := convert(archive, tsdbPath, converter.Options{LowMemory: true, PipelineBuffer: 4096}); err != nil {
+		return "", err
+	}
+	return queryTSDB(tsdbPath, start, opts)
+}
+
+// queryTSDB checks that every stat of every instance became a series
+// holding all of its samples
+func queryTSDB(tsdbPath string, start time.Time, opts Options) (string, error) {
+	reader, err := tsdb.OpenReader(tsdbPath, start, start.Add(time.Duration(opts.Samples+1)*gfstest.SampleInterval))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	total := 0
+	for t := 0; t < opts.Types; t++ {
+		for i := 0; i < opts.Instances; i++ {
+			name := gfstest.InstanceName(t, i)
+			series, err := reader.Select(map[string]string{converter.LabelResourceType: gfstest.TypeName(t), converter.LabelInstance: name})
+			if err != nil {
+				return "", err
+			}
+			if len(series) != len(gfstest.StatTypes) {
+				return "", fmt.Errorf("%s has %d series, wrote %d stats", name, len(series), len(gfstest.StatTypes))
+			}
+			for _, s := range series {
+				if len(s.Timestamps) != opts.Samples {
+					return "", fmt.Errorf("series %s of %s has %d samples, wrote %d", s.Labels["__name__"], name, len(s.Timestamps), opts.Samples)
+				}
+				total += len(s.Timestamps)
+			}
+		}
+	}
+	return fmt.Sprintf("%d series with %d samples", opts.Types*opts.Instances*len(gfstest.StatTypes), total), nil
+}
+
+// compareParsers converts the archive with the Go reader and with the Java
+// extractor and checks that both write the same number of series of the
+// synthetic types. It is skipped when java is not available.
+func compareParsers(archive, goPath, javaPath string, start time.Time, opts Options) (string, error) {
+	if !gfs.JavaAvailable() {
+		return "skipped: java not found", nil
+	}
+	counts := make(map[string]int, 2)
+	for _, run := range []struct {
+		parser, tsdbPath string
+	}{
+		{converter.ParserGo, goPath},
+		{converter.ParserJava, javaPath},
+	} {
+		if _, err := convert(archive, run.tsdbPath, converter.Options{Parser: run.parser}); err != nil {
+			return "", fmt.Errorf("%s parser: %w", run.parser, err)
+		}
+
+		reader, err := tsdb.OpenReader(run.tsdbPath, start, start.Add(time.Duration(opts.Samples+1)*gfstest.SampleInterval))
+		if err != nil {
+			return "", err
+		}
+		for t := 0; t < opts.Types; t++ {
+			series, err := reader.Select(map[string]string{converter.LabelResourceType: gfstest.TypeName(t)})
+			if err != nil {
+				reader.Close()
+				return "", err
+			}
+			counts[run.parser] += len(series)
+		}
+		reader.Close()
+	}
+	if counts[converter.ParserGo] != counts[converter.ParserJava] {
+		return "", fmt.Errorf("the Go reader wrote %d series, the Java extractor %d", counts[converter.ParserGo], counts[converter.ParserJava])
+	}
+	return fmt.Sprintf("%d series from both parsers", counts[converter.ParserGo]), nil
+}
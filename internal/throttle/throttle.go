@@ -0,0 +1,97 @@
+package throttle
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket refilled at a fixed rate per second. A nil
+// Bucket is unlimited, so callers can use one unconditionally.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+
+	start time.Time
+	total float64
+}
+
+// NewBucket returns a bucket allowing rate units per second with a burst of
+// one second's worth. A rate <= 0 returns nil, meaning unlimited.
+func NewBucket(rate float64) *Bucket {
+	if rate <= 0 {
+		return nil
+	}
+	now := time.Now()
+	return &Bucket{rate: rate, tokens: rate, last: now, start: now}
+}
+
+// Capacity returns the number of units the bucket refills per second
+func (b *Bucket) Capacity() int {
+	if b == nil {
+		return 0
+	}
+	return int(b.rate)
+}
+
+// Wait takes n units from the bucket, sleeping until they are available.
+// Requests larger than the burst are allowed and paid back by sleeping.
+func (b *Bucket) Wait(n int) {
+	if b == nil || n <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	b.total += float64(n)
+	deficit := -b.tokens
+	b.mu.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / b.rate * float64(time.Second)))
+	}
+}
+
+// Rate returns the average units per second taken since the bucket was
+// created
+func (b *Bucket) Rate() float64 {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	elapsed := time.Since(b.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return b.total / elapsed
+}
+
+type reader struct {
+	r      io.Reader
+	bucket *Bucket
+}
+
+// NewReader returns r limited to the byte rate of bucket. With a nil bucket
+// r is returned unchanged.
+func NewReader(r io.Reader, bucket *Bucket) io.Reader {
+	if bucket == nil {
+		return r
+	}
+	return &reader{r: r, bucket: bucket}
+}
+
+func (t *reader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.bucket.Wait(n)
+	return n, err
+}
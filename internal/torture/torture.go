@@ -0,0 +1,337 @@
+// Package torture builds damaged variants of a statistics archive and runs
+// the reader over every one of them, to find inputs that crash it, make it
+// allocate without bound or misreport what it read.
+package torture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+)
+
+// Kinds of damage
+const (
+	// KindTruncate cuts the archive at a record boundary
+	KindTruncate = "truncate"
+	// KindHeaderBitFlip flips one bit of the archive header
+	KindHeaderBitFlip = "header_bit_flip"
+	// KindOversizedLength sets a string length or stat count to its
+	// maximum
+	KindOversizedLength = "oversized_length"
+	// KindDuplicateTypeID gives a resource type the id of the first one
+	KindDuplicateTypeID = "duplicate_type_id"
+	// KindStatOffset replaces a stat offset in a sample with one past
+	// the end of its type
+	KindStatOffset = "stat_offset"
+	// KindRandomBytes overwrites the start of a record with random bytes,
+	// the same for every run, so lengths and counts take arbitrary values
+	KindRandomBytes = "random_bytes"
+)
+
+// Kinds lists every kind of damage in the order variants are generated
+var Kinds = []string{KindTruncate, KindHeaderBitFlip, KindOversizedLength, KindDuplicateTypeID, KindStatOffset, KindRandomBytes}
+
+// randomSpan is the most bytes a KindRandomBytes variant overwrites
+const randomSpan = 256
+
+// Variant is one damaged copy of an archive
+type Variant struct {
+	Kind string
+	// Name says where the archive was damaged
+	Name string
+	Data []byte
+}
+
+// Result is the outcome of reading one variant
+type Result struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Error     string `json:"error,omitempty"`
+	Panic     string `json:"panic,omitempty"`
+	Types     int    `json:"types"`
+	Instances int    `json:"instances"`
+	Samples   int    `json:"samples"`
+	Allocated uint64 `json:"allocated_bytes"`
+	// Excessive is set when reading allocated more than the limit
+	Excessive bool `json:"excessive_memory,omitempty"`
+	// Unexpected explains a summary that cannot be right for the damage
+	Unexpected string `json:"unexpected,omitempty"`
+}
+
+// Failed reports whether the variant exposed a reader bug
+func (r Result) Failed() bool {
+	return r.Panic != "" || r.Excessive || r.Unexpected != ""
+}
+
+// KindSummary totals the results of one kind of damage
+type KindSummary struct {
+	Kind       string `json:"kind"`
+	Variants   int    `json:"variants"`
+	Errors     int    `json:"errors"`
+	Panics     int    `json:"panics"`
+	Excessive  int    `json:"excessive_memory"`
+	Unexpected int    `json:"unexpected"`
+}
+
+// Report is the outcome of torturing one archive
+type Report struct {
+	File string `json:"file"`
+	// Baseline is the result of reading the undamaged archive
+	Baseline   Result        `json:"baseline"`
+	AllocLimit uint64        `json:"alloc_limit_bytes"`
+	Kinds      []KindSummary `json:"kinds"`
+	// Failures are the results that exposed a reader bug
+	Failures []Result `json:"failures,omitempty"`
+}
+
+// Failed reports whether any variant exposed a reader bug
+func (r *Report) Failed() bool {
+	return len(r.Failures) > 0
+}
+
+// Run reads the archive at path, then reads up to perKind variants of
+// every kind of damage and reports how the reader coped. Variants are
+// written one at a time to a temporary directory.
+func Run(path string, perKind int) (*Report, error) {
+	data, err := readArchive(path)
+	if err != nil {
+		return nil, err
+	}
+
+	layout, baseline, err := readLayout(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "gfs-torture-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	report := &Report{
+		File:     path,
+		Baseline: baseline,
+		// Damage may legitimately make the reader keep less or different
+		// data, but never several times what the whole archive needs
+		AllocLimit: 4*baseline.Allocated + 64<<20,
+	}
+	report.Kinds = make([]KindSummary, len(Kinds))
+	summaries := make(map[string]*KindSummary)
+	for i, kind := range Kinds {
+		report.Kinds[i].Kind = kind
+		summaries[kind] = &report.Kinds[i]
+	}
+
+	variantFile := filepath.Join(dir, "variant.gfs")
+	err = Generate(data, layout, perKind, func(v Variant) error {
+		if err := os.WriteFile(variantFile, v.Data, 0644); err != nil {
+			return err
+		}
+		result := read(variantFile)
+		result.Kind, result.Name = v.Kind, v.Name
+		result.Excessive = result.Allocated > report.AllocLimit
+		if v.Kind == KindTruncate && baseline.Error == "" && result.Error != "" {
+			// Every record before the cut is intact
+			result.Unexpected = "archive cut at a record boundary did not read cleanly"
+		}
+
+		summary := summaries[v.Kind]
+		summary.Variants++
+		if result.Error != "" {
+			summary.Errors++
+		}
+		if result.Panic != "" {
+			summary.Panics++
+		}
+		if result.Excessive {
+			summary.Excessive++
+		}
+		if result.Unexpected != "" {
+			summary.Unexpected++
+		}
+		if result.Failed() {
+			report.Failures = append(report.Failures, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// readArchive returns the decompressed bytes of an archive, which is what
+// the layout offsets refer to. Variants are written uncompressed.
+func readArchive(path string) ([]byte, error) {
+	archive, err := gfs.OpenArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	return io.ReadAll(archive)
+}
+
+// readLayout reads the undamaged archive, collecting its layout
+func readLayout(path string) (*gfs.Layout, Result, error) {
+	reader, err := gfs.NewStatArchiveReader(path)
+	if err != nil {
+		return nil, Result{}, err
+	}
+	defer reader.Close()
+	reader.TraceLayout()
+
+	result, err := measure(reader)
+	if result.Panic != "" {
+		return nil, result, fmt.Errorf("reader panicked on the undamaged archive: %s", result.Panic)
+	}
+	if gfs.IsUnreadable(err) {
+		return nil, result, fmt.Errorf("cannot torture %s: %w", path, err)
+	}
+	return reader.GetLayout(), result, nil
+}
+
+// read reads one variant
+func read(path string) Result {
+	reader, err := gfs.NewStatArchiveReader(path)
+	if err != nil {
+		return Result{Error: err.Error()}
+	}
+	defer reader.Close()
+	result, _ := measure(reader)
+	return result
+}
+
+// measure runs ReadArchive, recovering from a panic, and records what was
+// read and how much was allocated doing it. The ReadArchive error is also
+// returned as is.
+func measure(reader *gfs.StatArchiveReader) (result Result, err error) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	defer func() {
+		runtime.ReadMemStats(&after)
+		result.Allocated = after.TotalAlloc - before.TotalAlloc
+	}()
+
+	defer func() {
+		if p := recover(); p != nil {
+			result.Panic = fmt.Sprintf("%v\n%s", p, debug.Stack())
+		}
+	}()
+
+	if err = reader.ReadArchive(); err != nil {
+		result.Error = err.Error()
+	}
+	result.Types = len(reader.GetResourceTypes())
+	result.Instances = len(reader.GetInstances())
+	for _, instance := range reader.GetInstances() {
+		for _, values := range instance.Stats {
+			result.Samples += len(values)
+		}
+	}
+	return result, err
+}
+
+// Generate calls fn with up to perKind variants of every kind of damage,
+// spread evenly over the places that kind can be applied. Variants share
+// no memory with data, except truncations, which are never modified.
+func Generate(data []byte, layout *gfs.Layout, perKind int, fn func(Variant) error) error {
+	for _, i := range spread(len(layout.Records), perKind) {
+		offset := layout.Records[i].Offset
+		v := Variant{Kind: KindTruncate, Name: fmt.Sprintf("cut at record %d (offset %d)", i, offset), Data: data[:offset]}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+
+	for _, bit := range spread(int(layout.HeaderEnd)*8, perKind) {
+		v := mutate(data, KindHeaderBitFlip, fmt.Sprintf("bit %d of byte %d", bit%8, bit/8), func(d []byte) {
+			d[bit/8] ^= 1 << (bit % 8)
+		})
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+
+	for _, i := range spread(len(layout.Lengths), perKind) {
+		offset := layout.Lengths[i]
+		v := mutate(data, KindOversizedLength, fmt.Sprintf("length at offset %d", offset), func(d []byte) {
+			binary.BigEndian.PutUint16(d[offset:], 0xFFFF)
+		})
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+
+	if len(layout.TypeIDs) > 1 {
+		first := layout.TypeIDs[0]
+		for _, i := range spread(len(layout.TypeIDs)-1, perKind) {
+			offset := layout.TypeIDs[i+1]
+			v := mutate(data, KindDuplicateTypeID, fmt.Sprintf("type id at offset %d", offset), func(d []byte) {
+				copy(d[offset:offset+4], d[first:first+4])
+			})
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, i := range spread(len(layout.StatOffsets), perKind) {
+		offset := layout.StatOffsets[i]
+		v := mutate(data, KindStatOffset, fmt.Sprintf("stat offset at offset %d", offset), func(d []byte) {
+			// Past the end of any type with fewer stats, without being
+			// the end of block marker
+			d[offset] = gfs.ILLEGAL_STAT_OFFSET - 1
+		})
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+
+	for _, i := range spread(len(layout.Records), perKind) {
+		offset := layout.Records[i].Offset
+		rng := rand.New(rand.NewSource(offset))
+		end := min(offset+1+rng.Int63n(randomSpan), int64(len(data)))
+		v := mutate(data, KindRandomBytes, fmt.Sprintf("bytes %d-%d", offset, end), func(d []byte) {
+			rng.Read(d[offset:end])
+		})
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mutate returns a damaged copy of data
+func mutate(data []byte, kind, name string, damage func([]byte)) Variant {
+	d := make([]byte, len(data))
+	copy(d, data)
+	damage(d)
+	return Variant{Kind: kind, Name: name, Data: d}
+}
+
+// spread returns up to limit indexes evenly spread over [0, n)
+func spread(n, limit int) []int {
+	if n <= 0 || limit <= 0 {
+		return nil
+	}
+	if n <= limit {
+		indexes := make([]int, n)
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes
+	}
+	indexes := make([]int, limit)
+	for i := range indexes {
+		indexes[i] = i * n / limit
+	}
+	return indexes
+}
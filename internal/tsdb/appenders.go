@@ -0,0 +1,166 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// sourceAppender appends the samples of one source. A storage.Appender is
+// not safe for concurrent use, so every source gets its own, committed
+// independently of the others; mu is held while it appends or commits.
+type sourceAppender struct {
+	mu       sync.Mutex
+	name     string
+	appender storage.Appender
+	// pending counts the samples appended since the last commit, and
+	// batch holds them while a quarantine is set
+	pending int
+	batch   []batchSample
+	// buckets holds the appender of every backfill block the source
+	// appended to since its last commit, by the start of its range
+	buckets map[int64]storage.Appender
+
+	// written counts the write calls of the source and committed the calls
+	// up to its last commit; skip drops the next calls without appending
+	// them, to resume a conversion that committed them before
+	written   int
+	committed int
+	skip      int
+	onCommit  func(committed int) error
+}
+
+// committedCalls marks the write calls of a so far committed, calling its
+// OnCommit hook if there are new ones. a.mu is held.
+func (a *sourceAppender) committedCalls() error {
+	if a.onCommit != nil && a.written != a.committed {
+		a.committed = a.written
+		return a.onCommit(a.committed)
+	}
+	a.committed = a.written
+	return nil
+}
+
+// createdSeries holds the hashes of the series whose first sample the
+// writer appended and committed on its own. A head appender logs the
+// series it creates to the WAL only when it commits, and the samples of a
+// series another source commits before that are dropped when the WAL is
+// replayed, so no source appends a sample of a series through its own
+// appender before the series is logged. mu is held while it is.
+type createdSeries struct {
+	mu     sync.Mutex
+	hashes map[uint64]bool
+}
+
+// appendFirst appends and commits the sample at t of series on its own if
+// it is the first of its series, returning whether it did. The series is
+// logged even if the sample is rejected.
+func (w *Writer) appendFirst(series labels.Labels, hash uint64, t int64, value float64) (bool, error) {
+	w.created.mu.Lock()
+	defer w.created.mu.Unlock()
+	if w.created.hashes[hash] {
+		return false, nil
+	}
+	if w.created.hashes == nil {
+		w.created.hashes = make(map[uint64]bool)
+	}
+
+	appender := w.db.Appender(context.Background())
+	if _, err := appender.Append(0, series, t, value); err != nil {
+		// A rollback logs the series the appender created
+		if rollbackErr := appender.Rollback(); rollbackErr != nil {
+			return true, fmt.Errorf("failed to rollback: %w", rollbackErr)
+		}
+		w.created.hashes[hash] = true
+		return true, err
+	}
+	if err := appender.Commit(); err != nil {
+		return true, err
+	}
+	w.created.hashes[hash] = true
+	return true, nil
+}
+
+// appenders holds the appenders of the sources being written, by name,
+// the empty name being that of samples written without a source. Files
+// converted concurrently append concurrently, each through its own.
+type appenders struct {
+	mu      sync.Mutex
+	sources map[string]*sourceAppender
+	// ended holds the sources ended while they had samples pending, whose
+	// appenders are dropped once those are committed
+	ended map[string]bool
+}
+
+// appenderOf returns the appender of the source name, creating it on its
+// first write call; a backfill writer appends through the backfill
+// instead, and a counting writer appends nothing
+func (w *Writer) appenderOf(name string) *sourceAppender {
+	w.appenders.mu.Lock()
+	defer w.appenders.mu.Unlock()
+	if w.appenders.sources == nil {
+		w.appenders.sources = make(map[string]*sourceAppender)
+		w.appenders.ended = make(map[string]bool)
+	}
+	delete(w.appenders.ended, name)
+	a := w.appenders.sources[name]
+	if a == nil {
+		a = &sourceAppender{name: name}
+		if w.db != nil {
+			a.appender = w.db.Appender(context.Background())
+		}
+		w.appenders.sources[name] = a
+	}
+	return a
+}
+
+// all returns the appenders of every source
+func (s *appenders) all() []*sourceAppender {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := make([]*sourceAppender, 0, len(s.sources))
+	for _, a := range s.sources {
+		all = append(all, a)
+	}
+	return all
+}
+
+// lookup returns the appender of the source name, nil if it has none
+func (s *appenders) lookup(name string) *sourceAppender {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sources[name]
+}
+
+// end drops the appender of the source name, or marks it to be dropped by
+// prune if it still has samples pending, which the next Commit commits
+func (s *appenders) end(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.sources[name]
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	pending := a.pending
+	a.mu.Unlock()
+	if pending > 0 {
+		s.ended[name] = true
+		return
+	}
+	delete(s.sources, name)
+}
+
+// prune drops the appenders of the sources ended since they were last
+// committed
+func (s *appenders) prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.ended {
+		delete(s.sources, name)
+		delete(s.ended, name)
+	}
+}
@@ -0,0 +1,167 @@
+package tsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+// backfill writes samples straight into blocks, one for every range of
+// blockSize milliseconds aligned on a multiple of it that has samples,
+// instead of through the head and WAL of an open TSDB. The samples are
+// held in memory until flush writes the blocks.
+type backfill struct {
+	mu        sync.Mutex
+	dir       string
+	blockSize int64
+	// buckets holds the block of every range written to, by its start
+	buckets map[int64]*backfillBucket
+	// blocks are the ULIDs of the blocks flush wrote
+	blocks []string
+}
+
+// backfillBucket is the block of one range being written. Every source
+// appends to it through an appender of its own, held by the source.
+type backfillBucket struct {
+	writer  *tsdb.BlockWriter
+	samples int
+}
+
+// NewBackfillWriter returns a Writer that writes into new blocks in the
+// TSDB at dataPath, without opening it, so it skips the WAL and may run
+// while nothing else writes there. Blocks cover MinBlockDuration of
+// options, aligned on multiples of it, and are written by Close; until
+// then every sample is held in memory. Series must be written in time
+// order, and Blocks returns what Close wrote.
+func NewBackfillWriter(dataPath string, options Options) (*Writer, error) {
+	absPath, err := filepath.Abs(dataPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data path: %w", err)
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	options = options.Or(DefaultOptions())
+	// An empty WAL makes the directory open like any TSDB never written
+	// to through its head, read-only included
+	if err := os.MkdirAll(filepath.Join(absPath, "wal"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create TSDB directory: %w", err)
+	}
+	return &Writer{
+		batch:   DefaultCommitBatch,
+		options: options,
+		backfill: &backfill{
+			dir:       absPath,
+			blockSize: options.MinBlockDuration.Milliseconds(),
+			buckets:   make(map[int64]*backfillBucket),
+		},
+	}, nil
+}
+
+// Blocks returns the ULIDs of the blocks a backfill Writer wrote when it
+// was closed, in time order
+func (w *Writer) Blocks() []string {
+	if w.backfill == nil {
+		return nil
+	}
+	return w.backfill.blocks
+}
+
+// append adds a sample at t of series to the block of its range, through
+// the appender of the source a, whose mu is held
+func (b *backfill) append(a *sourceAppender, series labels.Labels, t int64, value float64) error {
+	start := t - t%b.blockSize
+	if t < 0 && t%b.blockSize != 0 {
+		start -= b.blockSize
+	}
+	b.mu.Lock()
+	bucket := b.buckets[start]
+	if bucket == nil {
+		writer, err := tsdb.NewBlockWriter(log.NewNopLogger(), b.dir, b.blockSize)
+		if err != nil {
+			b.mu.Unlock()
+			return fmt.Errorf("failed to start block: %w", err)
+		}
+		bucket = &backfillBucket{writer: writer}
+		b.buckets[start] = bucket
+	}
+	b.mu.Unlock()
+
+	if a.buckets == nil {
+		a.buckets = make(map[int64]storage.Appender)
+	}
+	appender := a.buckets[start]
+	if appender == nil {
+		appender = bucket.writer.Appender(context.Background())
+		a.buckets[start] = appender
+	}
+	if _, err := appender.Append(0, series, t, value); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	bucket.samples++
+	b.mu.Unlock()
+	return nil
+}
+
+// commit commits the samples the source a, whose mu is held, appended to
+// every block so far
+func (b *backfill) commit(a *sourceAppender) error {
+	for start, appender := range a.buckets {
+		delete(a.buckets, start)
+		if err := appender.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback discards the samples the source a, whose mu is held, appended
+// to every block since its last commit
+func (b *backfill) rollback(a *sourceAppender) error {
+	var errs []error
+	for start, appender := range a.buckets {
+		delete(a.buckets, start)
+		errs = append(errs, appender.Rollback())
+	}
+	return errors.Join(errs...)
+}
+
+// flush writes the block of every range that has samples, in time order,
+// and releases them all
+func (b *backfill) flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	starts := make([]int64, 0, len(b.buckets))
+	for start := range b.buckets {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	var errs []error
+	for _, start := range starts {
+		bucket := b.buckets[start]
+		if bucket.samples > 0 {
+			id, err := bucket.writer.Flush(context.Background())
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to write block: %w", err))
+			} else {
+				b.blocks = append(b.blocks, id.String())
+			}
+		}
+		if err := bucket.writer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(b.buckets, start)
+	}
+	return errors.Join(errs...)
+}
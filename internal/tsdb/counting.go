@@ -0,0 +1,95 @@
+package tsdb
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+)
+
+// MetricCount is what was written to the series of one metric name
+type MetricCount struct {
+	Name    string `json:"name"`
+	Series  int    `json:"series"`
+	Samples int64  `json:"samples"`
+}
+
+// Counts is what a counting writer was given to write: the series and
+// samples of every metric name, sorted by name, and the time range of the
+// samples, zero if there were none
+type Counts struct {
+	Metrics []MetricCount `json:"metrics"`
+	Series  int           `json:"series"`
+	Samples int64         `json:"samples"`
+	MinTime time.Time     `json:"min_time"`
+	MaxTime time.Time     `json:"max_time"`
+}
+
+// sampleCounts counts the samples given to a counting writer. Files
+// converted concurrently write to it concurrently.
+type sampleCounts struct {
+	mu       sync.Mutex
+	series   map[uint64]bool
+	metrics  map[string]*MetricCount
+	samples  int64
+	min, max int64
+}
+
+// NewCountingWriter returns a Writer that opens no TSDB and writes
+// nothing, only counting the series and samples it is given, for a dry
+// run. It has no TSDB for DedupTSDB to read, so that mode skips what
+// DedupRun does.
+func NewCountingWriter() *Writer {
+	return &Writer{counts: &sampleCounts{
+		series:  make(map[uint64]bool),
+		metrics: make(map[string]*MetricCount),
+	}}
+}
+
+// add counts a sample at t of series
+func (s *sampleCounts) add(series labels.Labels, t int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := series.Get(labels.MetricName)
+	metric := s.metrics[name]
+	if metric == nil {
+		metric = &MetricCount{Name: name}
+		s.metrics[name] = metric
+	}
+	if hash := series.Hash(); !s.series[hash] {
+		s.series[hash] = true
+		metric.Series++
+	}
+	metric.Samples++
+	if s.samples == 0 || t < s.min {
+		s.min = t
+	}
+	if s.samples == 0 || t > s.max {
+		s.max = t
+	}
+	s.samples++
+}
+
+// Counts returns what a writer from NewCountingWriter was given to write
+// so far, nil for any other writer
+func (w *Writer) Counts() *Counts {
+	s := w.counts
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := &Counts{Series: len(s.series), Samples: s.samples}
+	for _, metric := range s.metrics {
+		counts.Metrics = append(counts.Metrics, *metric)
+	}
+	sort.Slice(counts.Metrics, func(i, j int) bool { return counts.Metrics[i].Name < counts.Metrics[j].Name })
+	if s.samples > 0 {
+		counts.MinTime = timestamp.Time(s.min)
+		counts.MaxTime = timestamp.Time(s.max)
+	}
+	return counts
+}
@@ -0,0 +1,234 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+)
+
+// Dedup modes, selected with SetDedup
+const (
+	// DedupRun skips the samples of a series that fall in the time range
+	// another source, a file converted earlier or alongside in the same
+	// run, wrote to it
+	DedupRun = "run"
+	// DedupTSDB also skips the samples that fall in the time ranges of the
+	// series the TSDB held when it was opened
+	DedupTSDB = "tsdb"
+	// DedupOff writes every sample
+	DedupOff = "off"
+)
+
+// ValidDedupMode reports whether mode is a known dedup mode. The empty
+// string selects DedupRun.
+func ValidDedupMode(mode string) bool {
+	switch mode {
+	case "", DedupRun, DedupTSDB, DedupOff:
+		return true
+	}
+	return false
+}
+
+// compactRanges is the number of time ranges of a series above which the
+// ranges of sources that have ended are merged
+const compactRanges = 8
+
+// sampleRange is the time range, in milliseconds, of the samples a source
+// wrote to a series. Source 0 stands for the TSDB as it was opened and the
+// sources that have ended.
+type sampleRange struct {
+	source   int
+	min, max int64
+}
+
+// dedup tracks the time range of the samples every source wrote to every
+// series, by the hash of its labels
+type dedup struct {
+	mu     sync.Mutex
+	series map[uint64][]sampleRange
+	// sources holds the id of every source that has begun and not ended,
+	// by name, and skipped the number of samples each skipped
+	sources map[string]int
+	skipped map[int]int
+	next    int
+	// mergeGap is the longest gap between the ranges of ended sources
+	// that are merged into one
+	mergeGap int64
+}
+
+// SetDedup selects which samples WriteSourceMetric skips as duplicates.
+// Ranges of ended sources, or of the TSDB's chunks, no further apart than
+// mergeGap are merged to save memory, taking the gap for covered. It must
+// be called before the first write.
+func (w *Writer) SetDedup(mode string, mergeGap time.Duration) error {
+	if mode == DedupOff {
+		w.dedup = nil
+		return nil
+	}
+	d := &dedup{
+		series:   make(map[uint64][]sampleRange),
+		sources:  make(map[string]int),
+		skipped:  make(map[int]int),
+		next:     1,
+		mergeGap: mergeGap.Milliseconds(),
+	}
+	if mode == DedupTSDB && w.db != nil {
+		if err := d.load(w); err != nil {
+			return fmt.Errorf("failed to read the time ranges of the TSDB: %w", err)
+		}
+	}
+	w.dedup = d
+	return nil
+}
+
+// load records the time ranges of the chunks of every series in the TSDB
+func (d *dedup) load(w *Writer) error {
+	querier, err := w.db.ChunkQuerier(math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	defer querier.Close()
+
+	set := querier.Select(context.Background(), false, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+	var it chunks.Iterator
+	for set.Next() {
+		series := set.At()
+		hash := series.Labels().Hash()
+		it = series.Iterator(it)
+		for it.Next() {
+			meta := it.At()
+			d.series[hash] = mergeRange(d.series[hash], sampleRange{min: meta.MinTime, max: meta.MaxTime}, d.mergeGap)
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+	return set.Err()
+}
+
+// BeginSource starts tracking the samples written from the source name,
+// such as a file, as a source of its own even if it was converted before
+func (w *Writer) BeginSource(name string) {
+	w.sources.begin(name)
+	if w.dedup == nil {
+		return
+	}
+	d := w.dedup
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sources[name] = d.next
+	d.next++
+}
+
+// Duplicates returns the number of samples of the source name skipped as
+// duplicates so far
+func (w *Writer) Duplicates(name string) int {
+	if w.dedup == nil {
+		return 0
+	}
+	d := w.dedup
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.skipped[d.sources[name]]
+}
+
+// EndSource stops tracking the source name, whose time ranges then count
+// for every later source, and removes its window, time shift, stats and
+// quarantine count. Its appender goes once any samples it left pending are
+// committed.
+func (w *Writer) EndSource(name string) {
+	w.appenders.end(name)
+	w.windows.clear(name)
+	w.shifts.clear(name)
+	w.sources.end(name)
+	if w.limit != nil {
+		w.limit.clear(name)
+	}
+	if w.quarantine != nil {
+		w.quarantine.clear(name)
+	}
+	if w.dedup == nil {
+		return
+	}
+	d := w.dedup
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.skipped, d.sources[name])
+	delete(d.sources, name)
+}
+
+// duplicate reports whether a sample at t of the series with the given
+// hash falls in the time range another source wrote to it, counting it if
+// count is set, and otherwise extends the range of the source name to t
+func (d *dedup) duplicate(name string, hash uint64, t int64, count bool) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id, ok := d.sources[name]
+	if !ok {
+		return false
+	}
+
+	ranges := d.series[hash]
+	own := -1
+	for i, r := range ranges {
+		if r.source == id {
+			own = i
+			continue
+		}
+		if r.min <= t && t <= r.max {
+			if count {
+				d.skipped[id]++
+			}
+			return true
+		}
+	}
+	if own >= 0 {
+		ranges[own].min = min(ranges[own].min, t)
+		ranges[own].max = max(ranges[own].max, t)
+		return false
+	}
+	if len(ranges) >= compactRanges {
+		ranges = d.compact(ranges)
+	}
+	d.series[hash] = append(ranges, sampleRange{source: id, min: t, max: t})
+	return false
+}
+
+// compact merges the ranges of the sources that have ended, keeping those
+// of the sources still running as they are
+func (d *dedup) compact(ranges []sampleRange) []sampleRange {
+	running := make(map[int]bool, len(d.sources))
+	for _, id := range d.sources {
+		running[id] = true
+	}
+	var kept, ended []sampleRange
+	for _, r := range ranges {
+		if running[r.source] {
+			kept = append(kept, r)
+		} else {
+			ended = append(ended, sampleRange{min: r.min, max: r.max})
+		}
+	}
+	sort.Slice(ended, func(i, j int) bool { return ended[i].min < ended[j].min })
+	var merged []sampleRange
+	for _, r := range ended {
+		merged = mergeRange(merged, r, d.mergeGap)
+	}
+	return append(merged, kept...)
+}
+
+// mergeRange appends r to ranges sorted by start, merging it into the last
+// one if it starts no more than gap after that one ends
+func mergeRange(ranges []sampleRange, r sampleRange, gap int64) []sampleRange {
+	if n := len(ranges); n > 0 && ranges[n-1].source == r.source && r.min-ranges[n-1].max <= gap {
+		ranges[n-1].max = max(ranges[n-1].max, r.max)
+		return ranges
+	}
+	return append(ranges, r)
+}
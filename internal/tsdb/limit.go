@@ -0,0 +1,123 @@
+package tsdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// Series limit policies, what SetSeriesLimit does with a series that
+// would go over the limit
+const (
+	// SeriesLimitAbort fails the write of the series with ErrSeriesLimit
+	SeriesLimitAbort = "abort"
+	// SeriesLimitDrop skips the samples of the series, counting them
+	SeriesLimitDrop = "drop"
+)
+
+// ValidSeriesLimitPolicy reports whether policy is a series limit policy.
+// The empty string selects SeriesLimitAbort.
+func ValidSeriesLimitPolicy(policy string) bool {
+	switch policy {
+	case "", SeriesLimitAbort, SeriesLimitDrop:
+		return true
+	}
+	return false
+}
+
+// ErrSeriesLimit is the error of a write that would go over the series
+// limit with SeriesLimitAbort
+var ErrSeriesLimit = errors.New("series limit exceeded")
+
+// seriesLimit caps the distinct series a writer writes. Files converted
+// concurrently write to it concurrently.
+type seriesLimit struct {
+	mu   sync.Mutex
+	max  int
+	drop bool
+	// series holds the series written, and dropped those over the limit
+	// skipped with SeriesLimitDrop, by hash
+	series  map[uint64]struct{}
+	dropped map[uint64]struct{}
+	// skipped counts the samples of dropped series by source
+	skipped map[string]int
+}
+
+// SetSeriesLimit caps the distinct series written at max, doing what
+// policy says with the samples of any series beyond; zero removes the
+// limit. The series the TSDB held before do not count.
+func (w *Writer) SetSeriesLimit(max int, policy string) {
+	if max <= 0 {
+		w.limit = nil
+		return
+	}
+	w.limit = &seriesLimit{
+		max:     max,
+		drop:    policy == SeriesLimitDrop,
+		series:  make(map[uint64]struct{}),
+		dropped: make(map[uint64]struct{}),
+		skipped: make(map[string]int),
+	}
+}
+
+// DroppedSeries returns the number of series skipped so far as over the
+// series limit
+func (w *Writer) DroppedSeries() int {
+	if w.limit == nil {
+		return 0
+	}
+	w.limit.mu.Lock()
+	defer w.limit.mu.Unlock()
+	return len(w.limit.dropped)
+}
+
+// OverSeriesLimit returns the number of samples of the source name skipped
+// so far as in series over the series limit
+func (w *Writer) OverSeriesLimit(name string) int {
+	if w.limit == nil {
+		return 0
+	}
+	w.limit.mu.Lock()
+	defer w.limit.mu.Unlock()
+	return w.limit.skipped[name]
+}
+
+// Skipped returns the number of samples of the source name skipped so far
+// as duplicates, as outside its window or as over the series limit, or
+// quarantined
+func (w *Writer) Skipped(name string) int {
+	return w.Duplicates(name) + w.Excluded(name) + w.OverSeriesLimit(name) + w.Quarantined(name)
+}
+
+// clear forgets the samples of the source name skipped so far
+func (l *seriesLimit) clear(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.skipped, name)
+}
+
+// admit reports whether a sample of series, with the given hash, of the
+// source name may be written, adding the series if it is new and below
+// the limit. A sample of a series over the limit is counted if count is
+// set and dropped, or fails with ErrSeriesLimit.
+func (l *seriesLimit) admit(name string, series labels.Labels, hash uint64, count bool) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.series[hash]; ok {
+		return true, nil
+	}
+	if len(l.series) < l.max {
+		l.series[hash] = struct{}{}
+		return true, nil
+	}
+	if !l.drop {
+		return false, fmt.Errorf("%w: %s would be series %d of at most %d", ErrSeriesLimit, series, l.max+1, l.max)
+	}
+	l.dropped[hash] = struct{}{}
+	if count && name != "" {
+		l.skipped[name]++
+	}
+	return false, nil
+}
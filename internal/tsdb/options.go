@@ -0,0 +1,84 @@
+package tsdb
+
+import (
+	"fmt"
+	"time"
+)
+
+// Options tune the TSDB a Writer opens. A zero field takes its value from
+// DefaultOptions.
+type Options struct {
+	// Retention is how long blocks are kept, measured back from the
+	// newest sample in the TSDB
+	Retention time.Duration
+	// OutOfOrderWindow is how far behind the newest sample a sample may
+	// be appended. Archives older than it are rejected as out of bounds
+	// once the TSDB holds newer samples.
+	OutOfOrderWindow time.Duration
+	// MinBlockDuration and MaxBlockDuration bound the time range of the
+	// blocks the head is compacted into
+	MinBlockDuration time.Duration
+	MaxBlockDuration time.Duration
+}
+
+// DefaultOptions returns the options of a Writer opened without any: one
+// year of retention, a 30 day out-of-order window and blocks of 2h to 24h
+func DefaultOptions() Options {
+	return Options{
+		Retention:        365 * 24 * time.Hour,
+		OutOfOrderWindow: 30 * 24 * time.Hour,
+		MinBlockDuration: 2 * time.Hour,
+		MaxBlockDuration: 24 * time.Hour,
+	}
+}
+
+// Or returns the options with their zero fields taken from other
+func (o Options) Or(other Options) Options {
+	if o.Retention == 0 {
+		o.Retention = other.Retention
+	}
+	if o.OutOfOrderWindow == 0 {
+		o.OutOfOrderWindow = other.OutOfOrderWindow
+	}
+	if o.MinBlockDuration == 0 {
+		o.MinBlockDuration = other.MinBlockDuration
+	}
+	if o.MaxBlockDuration == 0 {
+		o.MaxBlockDuration = other.MaxBlockDuration
+	}
+	return o
+}
+
+// Validate fails if a duration is negative, or if the options contradict
+// each other once the zero fields take their defaults
+func (o Options) Validate() error {
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"retention", o.Retention},
+		{"out-of-order window", o.OutOfOrderWindow},
+		{"minimum block duration", o.MinBlockDuration},
+		{"maximum block duration", o.MaxBlockDuration},
+	} {
+		if d.value < 0 {
+			return fmt.Errorf("TSDB %s %s is negative", d.name, d.value)
+		}
+	}
+	o = o.Or(DefaultOptions())
+	if o.MinBlockDuration > o.MaxBlockDuration {
+		return fmt.Errorf("the minimum TSDB block duration %s is longer than the maximum %s", o.MinBlockDuration, o.MaxBlockDuration)
+	}
+	if o.MaxBlockDuration > o.Retention {
+		return fmt.Errorf("the maximum TSDB block duration %s is longer than the retention %s", o.MaxBlockDuration, o.Retention)
+	}
+	if o.OutOfOrderWindow > o.Retention {
+		return fmt.Errorf("the TSDB out-of-order window %s is longer than the retention %s, which would delete the oldest samples it accepts", o.OutOfOrderWindow, o.Retention)
+	}
+	return nil
+}
+
+func (o Options) String() string {
+	return fmt.Sprintf("retention %s, out-of-order window %s, blocks of %s to %s",
+		o.Retention, o.OutOfOrderWindow, o.MinBlockDuration, o.MaxBlockDuration)
+}
@@ -0,0 +1,202 @@
+package tsdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
+)
+
+const quarantineFileName = "failed-samples.jsonl"
+
+// QuarantinePath returns the path of the file the samples the TSDB at
+// dataPath rejects are quarantined into
+func QuarantinePath(dataPath string) string {
+	return filepath.Join(dataPath, quarantineFileName)
+}
+
+// QuarantinedSample is a sample the TSDB rejected, as a line of the
+// quarantine file. Value is formatted as by the Prometheus HTTP API, so
+// that NaN and infinities survive JSON.
+type QuarantinedSample struct {
+	Source    string            `json:"source,omitempty"`
+	Labels    map[string]string `json:"labels"`
+	Timestamp time.Time         `json:"timestamp"`
+	Value     string            `json:"value"`
+	Error     string            `json:"error"`
+}
+
+// batchSample is a sample appended since the last commit of its source,
+// kept to be counted once it is committed, or appended again if the
+// commit fails. hash is that of series.
+type batchSample struct {
+	series labels.Labels
+	hash   uint64
+	t      int64
+	value  float64
+}
+
+// quarantine appends the samples the TSDB rejects to a file instead of
+// failing their source, counting them by source. The file is created on
+// the first of them.
+type quarantine struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	out    *bufio.Writer
+	counts map[string]int
+}
+
+// SetQuarantine quarantines the samples the TSDB rejects into the file at
+// path, appending to it, instead of failing the write: a sample rejected
+// when appended is quarantined on its own, and when a commit fails its
+// batch is appended again in two halves through fresh appenders, so that
+// samples poisoning one half leave the other written, and a half that
+// fails again is halved in turn, down to single samples, which are
+// quarantined. Every source keeps the samples of its batch until it is
+// committed. An empty path quarantines nothing.
+func (w *Writer) SetQuarantine(path string) {
+	if path == "" {
+		w.quarantine = nil
+		return
+	}
+	w.quarantine = &quarantine{path: path, counts: make(map[string]int)}
+}
+
+// QuarantinePath returns the path of the quarantine file, empty if none
+// is set
+func (w *Writer) QuarantinePath() string {
+	if w.quarantine == nil {
+		return ""
+	}
+	return w.quarantine.path
+}
+
+// Quarantined returns the number of samples of the source name
+// quarantined so far
+func (w *Writer) Quarantined(name string) int {
+	if w.quarantine == nil {
+		return 0
+	}
+	w.quarantine.mu.Lock()
+	defer w.quarantine.mu.Unlock()
+	return w.quarantine.counts[name]
+}
+
+// retry appends and commits the halves of the batch of the source name
+// whose commit failed with err, each through a fresh appender,
+// quarantining the samples rejected again. A half whose commit fails too
+// is halved again, down to single samples, which are quarantined with the
+// error of their commit. A failed commit has already rolled back. It
+// returns the samples committed.
+func (w *Writer) retry(name string, batch []batchSample, err error) ([]batchSample, error) {
+	if len(batch) == 1 {
+		return nil, w.quarantine.add(name, batch[0], err)
+	}
+	half := (len(batch) + 1) / 2
+	var written []batchSample
+	for _, part := range [][]batchSample{batch[:half], batch[half:]} {
+		if len(part) == 0 {
+			continue
+		}
+		appender := w.db.Appender(context.Background())
+		kept := make([]batchSample, 0, len(part))
+		for _, s := range part {
+			if _, err := appender.Append(0, s.series, s.t, s.value); err != nil {
+				if err := w.quarantine.add(name, s, err); err != nil {
+					appender.Rollback()
+					return written, err
+				}
+				continue
+			}
+			kept = append(kept, s)
+		}
+		if err := appender.Commit(); err != nil {
+			retried, err := w.retry(name, kept, err)
+			written = append(written, retried...)
+			if err != nil {
+				return written, err
+			}
+			continue
+		}
+		written = append(written, kept...)
+	}
+	return written, nil
+}
+
+// add quarantines a sample of the source name rejected with err. A
+// staleness marker is dropped, not quarantined.
+func (q *quarantine) add(name string, s batchSample, err error) error {
+	if value.IsStaleNaN(s.value) {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.file == nil {
+		file, err := os.OpenFile(q.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open quarantine file: %w", err)
+		}
+		q.file = file
+		q.out = bufio.NewWriter(file)
+	}
+	line, jsonErr := json.Marshal(QuarantinedSample{
+		Source:    name,
+		Labels:    s.series.Map(),
+		Timestamp: timestamp.Time(s.t).UTC(),
+		Value:     strconv.FormatFloat(s.value, 'f', -1, 64),
+		Error:     err.Error(),
+	})
+	if jsonErr != nil {
+		return jsonErr
+	}
+	if _, writeErr := q.out.Write(append(line, '\n')); writeErr != nil {
+		return fmt.Errorf("failed to quarantine sample rejected with %v: %w", err, writeErr)
+	}
+	q.counts[name]++
+	return nil
+}
+
+// flush writes the samples quarantined so far to the file
+func (q *quarantine) flush() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.out == nil {
+		return nil
+	}
+	if err := q.out.Flush(); err != nil {
+		return fmt.Errorf("failed to write quarantine file: %w", err)
+	}
+	return nil
+}
+
+// clear forgets the samples of the source name quarantined so far
+func (q *quarantine) clear(name string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.counts, name)
+}
+
+// close writes the samples quarantined so far and closes the file
+func (q *quarantine) close() error {
+	if err := q.flush(); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.file == nil {
+		return nil
+	}
+	err := q.file.Close()
+	q.file, q.out = nil, nil
+	return err
+}
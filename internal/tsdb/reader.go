@@ -0,0 +1,122 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// Reader gives read-only access to a TSDB for a fixed time range. It never
+// takes the directory lock, so it can be used while a writer is running.
+type Reader struct {
+	db      *tsdb.DBReadOnly
+	querier storage.Querier
+}
+
+// Series is a stored series and the timestamps and values of its samples,
+// with the times of its staleness markers apart
+type Series struct {
+	Labels     map[string]string
+	Timestamps []time.Time
+	Values     []float64
+	Stale      []time.Time
+}
+
+// OpenReader opens the TSDB at dataPath for queries between start and end
+func OpenReader(dataPath string, start, end time.Time) (*Reader, error) {
+	absPath, err := filepath.Abs(dataPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data path: %w", err)
+	}
+
+	db, err := tsdb.OpenDBReadOnly(absPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open TSDB: %w", err)
+	}
+
+	// DBReadOnly supports a single querier, so it is shared by all queries
+	querier, err := db.Querier(timestamp.FromTime(start), timestamp.FromTime(end))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to query TSDB: %w", err)
+	}
+
+	return &Reader{db: db, querier: querier}, nil
+}
+
+// ListBlocks returns the ULIDs of the persisted blocks of the TSDB at
+// dataPath, in time order, as promtool tsdb list would list them. Like a
+// Reader, it never takes the directory lock.
+func ListBlocks(dataPath string) ([]string, error) {
+	absPath, err := filepath.Abs(dataPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data path: %w", err)
+	}
+	db, err := tsdb.OpenDBReadOnly(absPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open TSDB: %w", err)
+	}
+	defer db.Close()
+
+	blocks, err := db.Blocks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blocks: %w", err)
+	}
+	ids := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		ids = append(ids, block.Meta().ULID.String())
+	}
+	return ids, nil
+}
+
+// Close releases the querier and the block readers
+func (r *Reader) Close() error {
+	r.querier.Close()
+	return r.db.Close()
+}
+
+// Select returns every series whose labels equal the given pairs, with
+// its samples and staleness markers in the reader's time range
+func (r *Reader) Select(labelPairs map[string]string) ([]Series, error) {
+	matchers := make([]*labels.Matcher, 0, len(labelPairs))
+	for name, value := range labelPairs {
+		matcher, err := labels.NewMatcher(labels.MatchEqual, name, value)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	var result []Series
+	set := r.querier.Select(context.Background(), false, nil, matchers...)
+	var it chunkenc.Iterator
+	for set.Next() {
+		series := set.At()
+		found := Series{Labels: series.Labels().Map()}
+
+		it = series.Iterator(it)
+		for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+			t, v := it.At()
+			if value.IsStaleNaN(v) {
+				found.Stale = append(found.Stale, timestamp.Time(t))
+				continue
+			}
+			found.Timestamps = append(found.Timestamps, timestamp.Time(t))
+			found.Values = append(found.Values, v)
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+
+		result = append(result, found)
+	}
+	return result, set.Err()
+}
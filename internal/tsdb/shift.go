@@ -0,0 +1,55 @@
+package tsdb
+
+import (
+	"sync"
+	"time"
+)
+
+// sourceShift is the offset, in milliseconds, the samples of a source are
+// moved by, with the label that marks their series
+type sourceShift struct {
+	offset       int64
+	label, value string
+}
+
+// shifts holds the time shifts of the sources that have one, by name
+type shifts struct {
+	mu      sync.Mutex
+	sources map[string]*sourceShift
+}
+
+// SetTimeShift moves the samples of the source name by offset once they
+// are found inside its window, and sets label to value on their series so
+// they cannot be mistaken for samples written at their own time. An empty
+// label sets none.
+func (w *Writer) SetTimeShift(name string, offset time.Duration, label, value string) {
+	w.shifts.mu.Lock()
+	defer w.shifts.mu.Unlock()
+	if w.shifts.sources == nil {
+		w.shifts.sources = make(map[string]*sourceShift)
+	}
+	w.shifts.sources[name] = &sourceShift{offset: offset.Milliseconds(), label: label, value: value}
+}
+
+// TimeShift returns the offset the samples of the source name are moved
+// by, zero if they are not
+func (w *Writer) TimeShift(name string) time.Duration {
+	if shift := w.shifts.get(name); shift != nil {
+		return time.Duration(shift.offset) * time.Millisecond
+	}
+	return 0
+}
+
+// get returns the time shift of the source name, nil if it has none
+func (s *shifts) get(name string) *sourceShift {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sources[name]
+}
+
+// clear removes the time shift of the source name
+func (s *shifts) clear(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sources, name)
+}
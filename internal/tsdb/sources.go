@@ -0,0 +1,108 @@
+package tsdb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
+)
+
+// SourceStats is what one source wrote: its distinct series, its samples
+// and their time range, zero if there were none, and the samples the TSDB
+// rejected
+type SourceStats struct {
+	Series    int
+	Samples   int
+	Rejected  int
+	FirstTime time.Time
+	LastTime  time.Time
+}
+
+// sourceStats tracks what one source begun with BeginSource wrote
+type sourceStats struct {
+	series   map[uint64]struct{}
+	samples  int
+	rejected int
+	min, max int64
+}
+
+// sources holds the stats of the sources being written, by name
+type sources struct {
+	mu    sync.Mutex
+	stats map[string]*sourceStats
+}
+
+// begin starts tracking what the source name writes
+func (s *sources) begin(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats == nil {
+		s.stats = make(map[string]*sourceStats)
+	}
+	s.stats[name] = &sourceStats{series: make(map[uint64]struct{})}
+}
+
+// add counts a sample at t of the series with the given hash written by
+// the source name
+func (s *sources) add(name string, hash uint64, t int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats[name]
+	if stats == nil {
+		return
+	}
+	stats.series[hash] = struct{}{}
+	if stats.samples == 0 || t < stats.min {
+		stats.min = t
+	}
+	if stats.samples == 0 || t > stats.max {
+		stats.max = t
+	}
+	stats.samples++
+}
+
+// added counts the samples of the batch the source name committed,
+// leaving out staleness markers
+func (s *sources) added(name string, batch []batchSample) {
+	if name == "" {
+		return
+	}
+	for _, sample := range batch {
+		if !value.IsStaleNaN(sample.value) {
+			s.add(name, sample.hash, sample.t)
+		}
+	}
+}
+
+// reject counts a sample of the source name the TSDB rejected
+func (s *sources) reject(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stats := s.stats[name]; stats != nil {
+		stats.rejected++
+	}
+}
+
+// end stops tracking the source name
+func (s *sources) end(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.stats, name)
+}
+
+// SourceStats returns what the source name wrote since BeginSource
+func (w *Writer) SourceStats(name string) SourceStats {
+	w.sources.mu.Lock()
+	defer w.sources.mu.Unlock()
+	stats := w.sources.stats[name]
+	if stats == nil {
+		return SourceStats{}
+	}
+	result := SourceStats{Series: len(stats.series), Samples: stats.samples, Rejected: stats.rejected}
+	if stats.samples > 0 {
+		result.FirstTime = timestamp.Time(stats.min)
+		result.LastTime = timestamp.Time(stats.max)
+	}
+	return result
+}
@@ -0,0 +1,129 @@
+package tsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+// Stats is what a TSDB holds: its distinct series and their samples,
+// staleness markers included, the time range of the samples, zero if
+// there are none, its persisted blocks and the size of its directory
+type Stats struct {
+	Series  int       `json:"series"`
+	Samples int64     `json:"samples"`
+	MinTime time.Time `json:"min_time"`
+	MaxTime time.Time `json:"max_time"`
+	Blocks  int       `json:"blocks"`
+	Bytes   int64     `json:"bytes"`
+}
+
+// Stats returns what the TSDB the writer opened holds, in its head and its
+// blocks, nil for a counting writer, which opened none. A backfill writer
+// reads its directory as ReadStats does, so its new blocks count once
+// Close has written them.
+func (w *Writer) Stats() (*Stats, error) {
+	if w.backfill != nil {
+		return ReadStats(w.backfill.dir)
+	}
+	if w.db == nil {
+		return nil, nil
+	}
+	querier, err := w.db.ChunkQuerier(math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query TSDB: %w", err)
+	}
+	defer querier.Close()
+	return queryStats(querier, len(w.db.Blocks()), w.db.Dir())
+}
+
+// ReadStats returns what the TSDB at dataPath holds, replaying its WAL
+// for what is in the head. Like a Reader, it never takes the directory
+// lock.
+func ReadStats(dataPath string) (*Stats, error) {
+	absPath, err := filepath.Abs(dataPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data path: %w", err)
+	}
+	// DBReadOnly closes the blocks of a querier when they are listed, so
+	// they are listed apart
+	blocks, err := ListBlocks(absPath)
+	if err != nil {
+		return nil, err
+	}
+	db, err := tsdb.OpenDBReadOnly(absPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open TSDB: %w", err)
+	}
+	defer db.Close()
+	querier, err := db.ChunkQuerier(math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query TSDB: %w", err)
+	}
+	defer querier.Close()
+	return queryStats(querier, len(blocks), absPath)
+}
+
+// queryStats counts the series and samples querier returns, from chunk
+// metadata without decoding them, and the size of dir
+func queryStats(querier storage.ChunkQuerier, blocks int, dir string) (*Stats, error) {
+	stats := &Stats{Blocks: blocks}
+	var min, max int64
+	set := querier.Select(context.Background(), false, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+	for set.Next() {
+		stats.Series++
+		it := set.At().Iterator(nil)
+		for it.Next() {
+			meta := it.At()
+			if stats.Samples == 0 || meta.MinTime < min {
+				min = meta.MinTime
+			}
+			if stats.Samples == 0 || meta.MaxTime > max {
+				max = meta.MaxTime
+			}
+			stats.Samples += int64(meta.Chunk.NumSamples())
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if err := set.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query TSDB: %w", err)
+	}
+	if stats.Samples > 0 {
+		stats.MinTime = timestamp.Time(min)
+		stats.MaxTime = timestamp.Time(max)
+	}
+
+	// An open TSDB may remove files as it compacts, which are skipped
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		stats.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to size TSDB: %w", err)
+	}
+	return stats, nil
+}
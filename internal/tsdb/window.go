@@ -0,0 +1,91 @@
+package tsdb
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/timestamp"
+)
+
+// sourceWindow is the time range, in milliseconds, the samples of a source
+// are limited to, with the number of its samples that fell outside it
+type sourceWindow struct {
+	min, max int64
+	excluded int
+}
+
+// windows holds the time windows of the sources that have one, by name
+type windows struct {
+	mu      sync.Mutex
+	sources map[string]*sourceWindow
+}
+
+// SetWindow limits the samples written from the source name to those from
+// start to end, both included. A zero start or end leaves that side open.
+func (w *Writer) SetWindow(name string, start, end time.Time) {
+	window := &sourceWindow{min: math.MinInt64, max: math.MaxInt64}
+	if !start.IsZero() {
+		window.min = timestamp.FromTime(start)
+	}
+	if !end.IsZero() {
+		window.max = timestamp.FromTime(end)
+	}
+	w.windows.mu.Lock()
+	defer w.windows.mu.Unlock()
+	if w.windows.sources == nil {
+		w.windows.sources = make(map[string]*sourceWindow)
+	}
+	w.windows.sources[name] = window
+}
+
+// Window returns the time window of the source name, a zero start or end
+// leaving that side open, and false if it has none
+func (w *Writer) Window(name string) (start, end time.Time, ok bool) {
+	w.windows.mu.Lock()
+	defer w.windows.mu.Unlock()
+	window := w.windows.sources[name]
+	if window == nil {
+		return time.Time{}, time.Time{}, false
+	}
+	if window.min != math.MinInt64 {
+		start = timestamp.Time(window.min)
+	}
+	if window.max != math.MaxInt64 {
+		end = timestamp.Time(window.max)
+	}
+	return start, end, true
+}
+
+// Excluded returns the number of samples of the source name skipped so far
+// as outside its window
+func (w *Writer) Excluded(name string) int {
+	w.windows.mu.Lock()
+	defer w.windows.mu.Unlock()
+	if window := w.windows.sources[name]; window != nil {
+		return window.excluded
+	}
+	return 0
+}
+
+// outside reports whether a sample at t of the source name falls outside
+// its window, counting it if so and count is set
+func (w *windows) outside(name string, t int64, count bool) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	window := w.sources[name]
+	if window == nil || (window.min <= t && t <= window.max) {
+		return false
+	}
+	if count {
+		window.excluded++
+	}
+	return true
+}
+
+// clear removes the window of the source name
+func (w *windows) clear(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.sources, name)
+}
@@ -2,34 +2,89 @@ package tsdb
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"path/filepath"
 	"time"
 
+	"github.com/4n3w/gfs-to-prometheus/internal/throttle"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
-	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/model/value"
 	"github.com/prometheus/prometheus/tsdb"
 )
 
+// DefaultCommitBatch is the number of samples a Writer appends before it
+// commits them on its own
+const DefaultCommitBatch = 100000
+
+// Writer writes samples into a TSDB. It is safe for concurrent use: every
+// source appends and commits through its own appender, so files converted
+// concurrently do not wait on each other's samples, and the first sample
+// of every series is committed before any source appends another.
 type Writer struct {
-	db       *tsdb.DB
-	appender storage.Appender
+	db *tsdb.DB
+
+	// appenders appends the samples of every source
+	appenders appenders
+
+	// limiter caps the sample write rate, and batch, if set, is how many
+	// samples of a source are committed together
+	limiter *throttle.Bucket
+	batch   int
+
+	// created holds the series whose first sample was committed
+	created createdSeries
+
+	// dedup, if set, tracks what every source wrote to skip duplicates
+	dedup *dedup
+
+	// counts, if set, counts the samples instead of appending them
+	counts *sampleCounts
+
+	// windows limits the samples of sources to time windows
+	windows windows
+
+	// shifts moves the samples of sources in time
+	shifts shifts
+
+	// limit, if set, caps the distinct series written
+	limit *seriesLimit
+
+	// sources tracks what every source being written wrote
+	sources sources
+
+	// options are those the TSDB was opened with
+	options Options
+
+	// backfill, if set, writes the samples into new blocks in place of the
+	// appender
+	backfill *backfill
+
+	// quarantine, if set, keeps the samples the TSDB rejects
+	quarantine *quarantine
 }
 
-func NewWriter(dataPath string) (*Writer, error) {
+// NewWriter opens the TSDB at dataPath with options, whose zero fields
+// take their DefaultOptions values
+func NewWriter(dataPath string, options Options) (*Writer, error) {
 	absPath, err := filepath.Abs(dataPath)
 	if err != nil {
 		return nil, fmt.Errorf("invalid data path: %w", err)
 	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	options = options.Or(DefaultOptions())
 
 	opts := tsdb.DefaultOptions()
-	opts.RetentionDuration = int64(365 * 24 * time.Hour / time.Millisecond) // 1 year
-	// Allow samples from up to 30 days in the past (for historical data import)
-	opts.MinBlockDuration = int64(2 * time.Hour / time.Millisecond) // 2 hours minimum block
-	opts.MaxBlockDuration = int64(24 * time.Hour / time.Millisecond) // 24 hours max block
-	// Set out-of-order time window to allow historical data
-	opts.OutOfOrderTimeWindow = int64(30 * 24 * time.Hour / time.Millisecond) // 30 days
+	opts.RetentionDuration = options.Retention.Milliseconds()
+	opts.MinBlockDuration = options.MinBlockDuration.Milliseconds()
+	opts.MaxBlockDuration = options.MaxBlockDuration.Milliseconds()
+	// The out-of-order window lets archives older than the newest sample
+	// in the TSDB be imported
+	opts.OutOfOrderTimeWindow = options.OutOfOrderWindow.Milliseconds()
 
 	db, err := tsdb.Open(absPath, nil, nil, opts, nil)
 	if err != nil {
@@ -37,52 +92,307 @@ func NewWriter(dataPath string) (*Writer, error) {
 	}
 
 	return &Writer{
-		db:       db,
-		appender: db.Appender(context.Background()),
+		db:      db,
+		batch:   DefaultCommitBatch,
+		options: options,
 	}, nil
 }
 
+// Options returns the options the TSDB was opened with, zero for a
+// counting writer
+func (w *Writer) Options() Options {
+	return w.options
+}
+
 func (w *Writer) Close() error {
 	if err := w.Commit(); err != nil {
 		return err
 	}
+	if w.quarantine != nil {
+		if err := w.quarantine.close(); err != nil {
+			return err
+		}
+	}
+	if w.backfill != nil {
+		return w.backfill.flush()
+	}
+	if w.db == nil {
+		return nil
+	}
 	return w.db.Close()
 }
 
 func (w *Writer) WriteMetric(name string, labelPairs map[string]string, value float64, ts time.Time) error {
+	return w.WriteSourceMetric("", name, labelPairs, value, ts)
+}
+
+// WriteSourceMetric writes a sample like WriteMetric, from the source
+// begun with BeginSource, skipping it if it falls outside the window of
+// the source, dedup is set and it is a duplicate or its series is over the
+// series limit. A source with a time shift has its sample moved once it is
+// found inside the window.
+func (w *Writer) WriteSourceMetric(source, name string, labelPairs map[string]string, value float64, ts time.Time) error {
+	return w.write(source, name, labelPairs, value, ts, false)
+}
+
+// WriteStaleMarker ends a series of the source begun with BeginSource at
+// ts with a Prometheus staleness marker, so queries stop returning its
+// last sample there. It counts as a WriteMetric call and is skipped where
+// WriteSourceMetric would skip a sample, but is not counted as a sample of
+// the source, skipped or written.
+func (w *Writer) WriteStaleMarker(source, name string, labelPairs map[string]string, ts time.Time) error {
+	return w.write(source, name, labelPairs, math.Float64frombits(value.StaleNaN), ts, true)
+}
+
+// write writes a sample or, if marker is set, a staleness marker
+func (w *Writer) write(source, name string, labelPairs map[string]string, value float64, ts time.Time, marker bool) error {
+	a := w.appenderOf(source)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.written++
+	if a.skip > 0 {
+		a.skip--
+		return nil
+	}
+
 	lbls := labels.NewBuilder(labels.EmptyLabels())
 	lbls.Set(labels.MetricName, name)
-	
+
 	for k, v := range labelPairs {
 		lbls.Set(k, v)
 	}
+	t := timestamp.FromTime(ts)
+	if source != "" && w.windows.outside(source, t, !marker) {
+		return nil
+	}
+	if shift := w.shifts.get(source); source != "" && shift != nil {
+		t += shift.offset
+		if shift.label != "" {
+			lbls.Set(shift.label, shift.value)
+		}
+	}
+	series := lbls.Labels()
+	hash := series.Hash()
+	if w.dedup != nil && source != "" && w.dedup.duplicate(source, hash, t, !marker) {
+		return nil
+	}
+	if w.limit != nil {
+		if ok, err := w.limit.admit(source, series, hash, !marker); !ok {
+			return err
+		}
+	}
+	if w.counts != nil {
+		if !marker {
+			w.counts.add(series, t)
+			if source != "" {
+				w.sources.add(source, hash, t)
+			}
+		}
+		return nil
+	}
+
+	var err error
+	first := false
+	if w.backfill != nil {
+		err = w.backfill.append(a, series, t, value)
+	} else if first, err = w.appendFirst(series, hash, t, value); !first {
+		_, err = a.appender.Append(0, series, t, value)
+	}
+	sample := batchSample{series: series, hash: hash, t: t, value: value}
+	if err != nil && w.quarantine != nil {
+		return w.quarantine.add(source, sample, err)
+	}
+	if err != nil {
+		if source != "" && !marker {
+			w.sources.reject(source)
+		}
+		return err
+	}
+	if first {
+		// Committed on its own already, but still one of the batch
+		w.sources.added(source, []batchSample{sample})
+	} else if w.quarantine != nil || source != "" {
+		a.batch = append(a.batch, sample)
+	}
+	a.pending++
+	if (w.limiter != nil && a.pending >= w.limiter.Capacity()) || (w.batch > 0 && a.pending >= w.batch) {
+		return w.commit(a)
+	}
+	return nil
+}
+
+// SetRateLimit caps the sample write rate. While a limit is set, samples
+// are committed in batches of one second's worth and the writer sleeps
+// between batches rather than between samples.
+func (w *Writer) SetRateLimit(limiter *throttle.Bucket) {
+	w.limiter = limiter
+}
+
+// SetCommitBatch commits the samples a source appended every n samples,
+// so that an archive does not sit in its appender until it ends; zero
+// commits only when Commit or CommitSource is called. A Rollback discards
+// only the samples appended since the last commit of their source.
+func (w *Writer) SetCommitBatch(n int) {
+	w.batch = max(n, 0)
+}
+
+// Written returns the number of write calls of the source name so far,
+// including the ones skipped and the ones that failed
+func (w *Writer) Written(name string) int {
+	a := w.appenders.lookup(name)
+	if a == nil {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.written
+}
 
-	_, err := w.appender.Append(0, lbls.Labels(), timestamp.FromTime(ts), value)
-	return err
+// Skip drops the next n write calls of the source name, which still count
+// as written
+func (w *Writer) Skip(name string, n int) {
+	a := w.appenders.lookup(name)
+	if a == nil && n == 0 {
+		return
+	}
+	if a == nil {
+		a = w.appenderOf(name)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.skip = n
 }
 
+// OnCommit sets a function called after every successful commit of the
+// source name with the number of its write calls the commit covers. An
+// error it returns is returned by the commit.
+func (w *Writer) OnCommit(name string, hook func(committed int) error) {
+	a := w.appenders.lookup(name)
+	if a == nil && hook == nil {
+		return
+	}
+	if a == nil {
+		a = w.appenderOf(name)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onCommit = hook
+}
+
+// Commit commits the samples every source appended
 func (w *Writer) Commit() error {
-	if w.appender == nil {
+	if w.db == nil && w.backfill == nil {
 		return nil
 	}
-	
-	if err := w.appender.Commit(); err != nil {
+	var errs []error
+	for _, a := range w.appenders.all() {
+		a.mu.Lock()
+		errs = append(errs, w.commit(a))
+		a.mu.Unlock()
+	}
+	w.appenders.prune()
+	return errors.Join(errs...)
+}
+
+// CommitSource commits the samples the source name appended, leaving those
+// of the sources written concurrently to their own commits
+func (w *Writer) CommitSource(name string) error {
+	a := w.appenders.lookup(name)
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return w.commit(a)
+}
+
+// commit commits the samples of a, whose mu is held, counting those
+// written as samples of its source
+func (w *Writer) commit(a *sourceAppender) error {
+	if a.appender == nil && w.backfill == nil {
+		// A counting writer has nothing to commit
+		return a.committedCalls()
+	}
+	w.limiter.Wait(a.pending)
+	a.pending = 0
+	batch := a.batch
+	a.batch = nil
+
+	var err error
+	if w.backfill != nil {
+		err = w.backfill.commit(a)
+	} else {
+		err = a.appender.Commit()
+		a.appender = w.db.Appender(context.Background())
+	}
+	if err != nil && w.quarantine != nil && w.backfill == nil {
+		batch, err = w.retry(a.name, batch, err)
+		w.sources.added(a.name, batch)
+		if err != nil {
+			return err
+		}
+		if err := w.quarantine.flush(); err != nil {
+			return err
+		}
+		return a.committedCalls()
+	}
+	if err != nil {
 		return fmt.Errorf("failed to commit: %w", err)
 	}
-	
-	w.appender = w.db.Appender(context.Background())
+	w.sources.added(a.name, batch)
+	if w.quarantine != nil {
+		if err := w.quarantine.flush(); err != nil {
+			return err
+		}
+	}
+	return a.committedCalls()
+}
+
+// FlushHead commits pending samples and persists everything held in the
+// head and WAL, including out-of-order samples, as blocks
+func (w *Writer) FlushHead() error {
+	if err := w.Commit(); err != nil || w.db == nil {
+		return err
+	}
+
+	if err := w.db.CompactOOOHead(context.Background()); err != nil {
+		return fmt.Errorf("failed to compact out-of-order head: %w", err)
+	}
+
+	head := w.db.Head()
+	if head.NumSeries() == 0 || head.MinTime() > head.MaxTime() {
+		return nil
+	}
+	if err := w.db.CompactHead(tsdb.NewRangeHead(head, head.MinTime(), head.MaxTime())); err != nil {
+		return fmt.Errorf("failed to compact head: %w", err)
+	}
 	return nil
 }
 
+// Rollback discards the samples every source appended since its last
+// commit
 func (w *Writer) Rollback() error {
-	if w.appender == nil {
+	if w.db == nil && w.backfill == nil {
 		return nil
 	}
-	
-	if err := w.appender.Rollback(); err != nil {
-		return fmt.Errorf("failed to rollback: %w", err)
+	var errs []error
+	for _, a := range w.appenders.all() {
+		a.mu.Lock()
+		a.pending = 0
+		a.batch = nil
+		a.written = a.committed
+		var err error
+		if w.backfill != nil {
+			err = w.backfill.rollback(a)
+		} else if a.appender != nil {
+			err = a.appender.Rollback()
+			a.appender = w.db.Appender(context.Background())
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to rollback: %w", err))
+		}
+		a.mu.Unlock()
 	}
-	
-	w.appender = w.db.Appender(context.Background())
-	return nil
-}
\ No newline at end of file
+	w.appenders.prune()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,292 @@
+package tsdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testStart = time.UnixMilli(1700000000000)
+
+// writeConcurrently writes samples samples of each of series series from
+// each of sources sources at once, source n writing the samples of every
+// series n milliseconds after those of source n-1. Unless shared is set,
+// every source writes series of its own.
+func writeConcurrently(t *testing.T, w *Writer, sources, series, samples int, shared bool) {
+	t.Helper()
+	errs := make([]error, sources)
+	var wg sync.WaitGroup
+	for n := 0; n < sources; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			source := fmt.Sprintf("source-%d", n)
+			w.BeginSource(source)
+			defer w.EndSource(source)
+			for k := 0; k < samples; k++ {
+				at := testStart.Add(time.Duration(k)*time.Second + time.Duration(n)*time.Millisecond)
+				for s := 0; s < series; s++ {
+					labels := map[string]string{"series": fmt.Sprint(s)}
+					if !shared {
+						labels["source"] = source
+					}
+					if err := w.WriteSourceMetric(source, "test_concurrent", labels, float64(k), at); err != nil {
+						errs[n] = err
+						return
+					}
+				}
+			}
+			errs[n] = w.CommitSource(source)
+		}(n)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// countSamples returns the number of samples of every series of the
+// metric in the TSDB at dataPath
+func countSamples(t *testing.T, dataPath, metric string) map[string]int {
+	t.Helper()
+	reader, err := OpenReader(dataPath, testStart.Add(-time.Hour), testStart.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	series, err := reader.Select(map[string]string{"__name__": metric})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, s := range series {
+		counts[s.Labels["series"]] += len(s.Timestamps)
+	}
+	return counts
+}
+
+func checkCounts(t *testing.T, counts map[string]int, series, want int) {
+	t.Helper()
+	if len(counts) != series {
+		t.Errorf("read %d series, wrote %d", len(counts), series)
+	}
+	for name, n := range counts {
+		if n != want {
+			t.Errorf("series %s has %d samples, wrote %d", name, n, want)
+		}
+	}
+}
+
+// TestConcurrentSourcesReopen checks that every sample written by sources
+// appending the same series concurrently is read back once the TSDB is
+// reopened from its WAL
+func TestConcurrentSourcesReopen(t *testing.T) {
+	const sources, series, samples = 6, 12, 40
+	for round := 0; round < 5; round++ {
+		dir := t.TempDir()
+		w, err := NewWriter(dir, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetCommitBatch(7)
+		writeConcurrently(t, w, sources, series, samples, true)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		checkCounts(t, countSamples(t, dir, "test_concurrent"), series, sources*samples)
+	}
+}
+
+// TestSeriesCreatedByAnotherSource checks that the samples a source commits
+// of a series another source created, but has not committed yet, are read
+// back once the TSDB is reopened from its WAL
+func TestSeriesCreatedByAnotherSource(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetCommitBatch(0)
+	labels := map[string]string{"series": "shared"}
+	for k, source := range []string{"creator", "other"} {
+		if err := w.WriteSourceMetric(source, "test_shared", labels, float64(k), testStart.Add(time.Duration(k)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, source := range []string{"other", "creator"} {
+		if err := w.CommitSource(source); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(t, countSamples(t, dir, "test_shared"), 1, 2)
+}
+
+// TestConcurrentBackfill checks that sources backfilling series of their
+// own concurrently lose none of their samples
+func TestConcurrentBackfill(t *testing.T) {
+	const sources, series, samples = 6, 12, 40
+	dir := t.TempDir()
+	w, err := NewBackfillWriter(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetCommitBatch(7)
+	writeConcurrently(t, w, sources, series, samples, false)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Blocks()) == 0 {
+		t.Fatal("wrote no blocks")
+	}
+	checkCounts(t, countSamples(t, dir, "test_concurrent"), series, sources*samples)
+}
+
+// TestCommitCallsPerSource checks that skipping and the OnCommit hook count
+// the write calls of their own source only
+func TestCommitCallsPerSource(t *testing.T) {
+	w, err := NewWriter(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.SetCommitBatch(0)
+
+	var committed []int
+	w.Skip("a", 2)
+	w.OnCommit("a", func(n int) error {
+		committed = append(committed, n)
+		return nil
+	})
+	for k := 0; k < 5; k++ {
+		at := testStart.Add(time.Duration(k) * time.Second)
+		for _, source := range []string{"a", "b", "b"} {
+			if err := w.WriteSourceMetric(source, "test_calls", map[string]string{"source": source}, float64(k), at); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if k == 2 {
+			if err := w.CommitSource("b"); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.CommitSource("a"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := fmt.Sprint(committed), "[3 5]"; got != want {
+		t.Errorf("OnCommit of a called with %s, want %s", got, want)
+	}
+	if got := w.Written("a"); got != 5 {
+		t.Errorf("a made %d write calls, want 5", got)
+	}
+	if got := w.Written("b"); got != 10 {
+		t.Errorf("b made %d write calls, want 10", got)
+	}
+}
+
+// TestSourceStatsCountCommitted checks that the samples of a source are
+// counted once committed, not when appended
+func TestSourceStatsCountCommitted(t *testing.T) {
+	w, err := NewWriter(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.SetCommitBatch(0)
+	w.BeginSource("a")
+	for k := 0; k < 4; k++ {
+		labels := map[string]string{"series": fmt.Sprint(k % 2)}
+		if err := w.WriteSourceMetric("a", "test_stats", labels, float64(k), testStart.Add(time.Duration(k)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first sample of each series is committed on its own
+	if stats := w.SourceStats("a"); stats.Samples != 2 {
+		t.Errorf("counted %d samples before the commit, want the 2 committed", stats.Samples)
+	}
+	if err := w.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := w.SourceStats("a"); stats.Samples != 2 || stats.Series != 2 {
+		t.Errorf("counted %d samples of %d series after a rollback, want 2 of 2", stats.Samples, stats.Series)
+	}
+	if err := w.WriteSourceMetric("a", "test_stats", map[string]string{"series": "0"}, 4, testStart.Add(4*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CommitSource("a"); err != nil {
+		t.Fatal(err)
+	}
+	stats := w.SourceStats("a")
+	if stats.Samples != 3 || !stats.LastTime.Equal(testStart.Add(4*time.Second)) {
+		t.Errorf("counted %d samples up to %s after the commit, want 3 up to %s", stats.Samples, stats.LastTime, testStart.Add(4*time.Second))
+	}
+}
+
+// TestRetryQuarantinesSingleSamples checks that a batch whose commits keep
+// failing is halved down to single samples, every one quarantined
+func TestRetryQuarantinesSingleSamples(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetQuarantine(QuarantinePath(dir))
+	w.SetCommitBatch(0)
+	w.BeginSource("a")
+	const samples = 11
+	for k := 0; k < samples; k++ {
+		if err := w.WriteSourceMetric("a", "test_retry", nil, float64(k), testStart.Add(time.Duration(k)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Every commit fails once the WAL is closed
+	if err := w.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CommitSource("a"); err != nil {
+		t.Fatal(err)
+	}
+	// The first sample was committed on its own before
+	if got := w.Quarantined("a"); got != samples-1 {
+		t.Errorf("quarantined %d samples, want %d", got, samples-1)
+	}
+	if stats := w.SourceStats("a"); stats.Samples != 1 {
+		t.Errorf("counted %d samples written, want 1", stats.Samples)
+	}
+	if err := w.quarantine.close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRollbackKeepsCommittedBatches checks that rolling back a writer that
+// has committed full batches on its own leaves those batches in the TSDB
+func TestRollbackKeepsCommittedBatches(t *testing.T) {
+	const batch, samples = 10, 25
+	dir := t.TempDir()
+	w, err := NewWriter(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetCommitBatch(batch)
+	for k := 0; k < samples; k++ {
+		if err := w.WriteMetric("test_batch", map[string]string{"series": "0"}, float64(k), testStart.Add(time.Duration(k)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(t, countSamples(t, dir, "test_batch"), 1, samples/batch*batch)
+}
@@ -1,12 +1,13 @@
 package watcher
 
 import (
-	"log"
-	"path/filepath"
-	"strings"
+	"os"
 	"sync"
 
 	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/ingest"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
 	"github.com/fsnotify/fsnotify"
 )
 
@@ -15,6 +16,8 @@ type Watcher struct {
 	fsWatcher      *fsnotify.Watcher
 	processedFiles sync.Map
 	done           chan bool
+	tsdbPath       string
+	logger         logging.Logger
 }
 
 func New(conv *converter.Converter) (*Watcher, error) {
@@ -27,6 +30,7 @@ func New(conv *converter.Converter) (*Watcher, error) {
 		converter: conv,
 		fsWatcher: fsWatcher,
 		done:      make(chan bool),
+		logger:    conv.Logger(),
 	}, nil
 }
 
@@ -34,6 +38,25 @@ func (w *Watcher) AddDirectory(dir string) error {
 	return w.fsWatcher.Add(dir)
 }
 
+// WatchIngestQueue makes the watcher also convert files that batch commands
+// hand over through the ingest queue of tsdbPath, starting with any requests
+// queued while no daemon was running
+func (w *Watcher) WatchIngestQueue(tsdbPath string) error {
+	w.tsdbPath = tsdbPath
+	if err := w.fsWatcher.Add(ingest.QueueDir(tsdbPath)); err != nil {
+		return err
+	}
+
+	pending, err := ingest.PendingRequests(tsdbPath)
+	if err != nil {
+		return err
+	}
+	for _, request := range pending {
+		go w.processRequest(request)
+	}
+	return nil
+}
+
 func (w *Watcher) Start() error {
 	go w.watch()
 	<-w.done
@@ -53,9 +76,16 @@ func (w *Watcher) watch() {
 				return
 			}
 
+			if w.tsdbPath != "" && ingest.IsRequest(w.tsdbPath, event.Name) {
+				if event.Op&fsnotify.Create == fsnotify.Create {
+					go w.processRequest(event.Name)
+				}
+				continue
+			}
+
 			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
 				if w.isGFSFile(event.Name) {
-					log.Printf("Detected GFS file: %s", event.Name)
+					w.logger.Debugf("Detected GFS file: %s", event.Name)
 					go w.processFile(event.Name)
 				}
 			}
@@ -64,7 +94,7 @@ func (w *Watcher) watch() {
 			if !ok {
 				return
 			}
-			log.Printf("Watcher error: %v", err)
+			w.logger.Warnf("Watcher error: %v", err)
 
 		case <-w.done:
 			return
@@ -73,8 +103,7 @@ func (w *Watcher) watch() {
 }
 
 func (w *Watcher) isGFSFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	return ext == ".gfs"
+	return gfs.HasArchiveExtension(filename)
 }
 
 func (w *Watcher) processFile(filename string) {
@@ -82,9 +111,47 @@ func (w *Watcher) processFile(filename string) {
 		return
 	}
 
-	log.Printf("Processing new GFS file: %s", filename)
-	if err := w.converter.ConvertFile(filename); err != nil {
-		log.Printf("Error processing %s: %v", filename, err)
+	w.logger.Infof("Processing new GFS file: %s", filename)
+	report, err := w.converter.ConvertFile(filename)
+	switch {
+	case gfs.IsPermanent(err):
+		// Stays marked as processed, so later writes do not log it again
+		w.logger.Warnf("Skipping %s, which cannot be read: %v", filename, err)
+		return
+	case gfs.IsIncomplete(err), report != nil && report.Truncated:
+		// Half written: converted again once it has grown
+		w.logger.Infof("%s ends inside a record, it will be converted again when it changes", filename)
+		w.processedFiles.Delete(filename)
+		return
+	case err != nil:
+		w.logger.Warnf("Error processing %s: %v", filename, err)
 		w.processedFiles.Delete(filename)
 	}
-}
\ No newline at end of file
+	w.warnUnclean(filename, report)
+}
+
+// processRequest converts a file queued by a batch command. Queued files
+// are always converted, even if the watcher has seen them before.
+func (w *Watcher) processRequest(path string) {
+	request, err := ingest.TakeRequest(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			w.logger.Warnf("Error reading ingest request %s: %v", path, err)
+		}
+		return
+	}
+
+	w.logger.Infof("Processing queued GFS file: %s", request.File)
+	report, err := w.converter.ConvertFile(request.File)
+	if err != nil {
+		w.logger.Warnf("Error processing %s: %v", request.File, err)
+	}
+	w.warnUnclean(request.File, report)
+}
+
+// warnUnclean logs the parse report of an archive that was only partly read
+func (w *Watcher) warnUnclean(filename string, report *gfs.ParseReport) {
+	if report != nil && !report.Clean() {
+		w.logger.Warnf("%s: %s", filename, report)
+	}
+}
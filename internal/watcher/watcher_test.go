@@ -0,0 +1,137 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/4n3w/gfs-to-prometheus/internal/converter"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs"
+	"github.com/4n3w/gfs-to-prometheus/internal/gfs/gfstest"
+	"github.com/4n3w/gfs-to-prometheus/internal/ingest"
+	"github.com/4n3w/gfs-to-prometheus/internal/logging"
+	"github.com/4n3w/gfs-to-prometheus/internal/tsdb"
+)
+
+// TestQueuedFileConverted checks that a file a batch command queued while
+// the daemon held the lock is taken from the queue and converted
+func TestQueuedFileConverted(t *testing.T) {
+	const samples = 30
+	dir := t.TempDir()
+	tsdbPath := filepath.Join(dir, "tsdb")
+	archive := filepath.Join(dir, "queued.gfs")
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	queued := gfstest.Instance{
+		Start:  start,
+		Stats:  []gfs.StatDescriptor{{Name: "entries", Type: gfs.StatTypeInt}},
+		Values: [][]float64{make([]float64, samples)},
+	}
+	if err := queued.WriteFile(archive); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := ingest.AcquireLock(tsdbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+	if err := ingest.Enqueue(tsdbPath, []string{archive}); err != nil {
+		t.Fatal(err)
+	}
+
+	conv, err := converter.New(tsdbPath, "", converter.Options{Logger: logging.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := New(conv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := ingest.PendingRequests(tsdbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("%d requests pending, queued 1", len(pending))
+	}
+	w.tsdbPath = tsdbPath
+	w.processRequest(pending[0])
+	if err := w.fsWatcher.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending, _ := ingest.PendingRequests(tsdbPath); len(pending) != 0 {
+		t.Errorf("%d requests still pending once converted", len(pending))
+	}
+	reader, err := tsdb.OpenReader(tsdbPath, start, start.Add((samples+1)*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	series, err := reader.Select(map[string]string{converter.LabelInstance: gfstest.InstanceName(0, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 1 || len(series[0].Timestamps) != samples {
+		t.Fatalf("read %d series, want 1 of %d samples", len(series), samples)
+	}
+}
+
+// TestProcessFileRetries checks which files the watcher converts again
+// when they change: a half-written archive is, while a file that is not
+// an archive and a complete archive are not
+func TestProcessFileRetries(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	complete := filepath.Join(dir, "complete.gfs")
+	err := gfstest.Instance{
+		Start:  start,
+		Stats:  []gfs.StatDescriptor{{Name: "entries", Type: gfs.StatTypeInt}},
+		Values: [][]float64{make([]float64, 10)},
+	}.WriteFile(complete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(complete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	halfWritten := filepath.Join(dir, "half-written.gfs")
+	if err := os.WriteFile(halfWritten, data[:len(data)-3], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	notAnArchive := filepath.Join(dir, "not-an-archive.gfs")
+	if err := os.WriteFile(notAnArchive, []byte("not an archive\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	conv, err := converter.New(filepath.Join(dir, "tsdb"), "", converter.Options{Logger: logging.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conv.Close()
+	w, err := New(conv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.fsWatcher.Close()
+
+	tests := []struct {
+		file  string
+		retry bool
+	}{
+		{notAnArchive, false},
+		{halfWritten, true},
+		{complete, false},
+	}
+	for _, tt := range tests {
+		w.processFile(tt.file)
+		if _, processed := w.processedFiles.Load(tt.file); processed == tt.retry {
+			t.Errorf("%s: converted again when it changes %t, want %t", filepath.Base(tt.file), !processed, tt.retry)
+		}
+	}
+}
@@ -12,4 +12,4 @@ func main() {
 		log.Fatal(err)
 		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,167 @@
+// Package events defines the newline-delimited JSON events written by
+// gfs-to-prometheus when run with --events-out. Every line is one Event.
+//
+// The schema is versioned by SchemaVersion. Fields are only ever added
+// within a version; renaming or removing a field, or changing its meaning,
+// increments the version.
+package events
+
+import (
+	"time"
+)
+
+// SchemaVersion is the version written in the schema field of every event
+const SchemaVersion = 1
+
+// Event types
+const (
+	// FileStarted is written before a file is parsed
+	FileStarted = "file_started"
+	// Progress is written periodically while a file is converted
+	Progress = "progress"
+	// FileCompleted is written after a file is converted or has failed;
+	// Summary is always set
+	FileCompleted = "file_completed"
+	// Warning is written for every warning the converter logs; Warning is
+	// always set
+	Warning = "warning"
+	// RunCompleted is written once at the end of a batch run; Run is
+	// always set
+	RunCompleted = "run_completed"
+)
+
+// Warning classes
+const (
+	WarningParse       = "parse"
+	WarningUnknownType = "unknown_type"
+	WarningWrite       = "write"
+	WarningProvenance  = "provenance"
+	// WarningDescriptorConflict is a stat whose unit or counter flag
+	// differs from an earlier file in the run
+	WarningDescriptorConflict = "descriptor_conflict"
+	// WarningLimitExceeded is a file with records skipped for a length or
+	// count above the reader's limits
+	WarningLimitExceeded = "limit_exceeded"
+	// WarningTimeJump is a file whose sample timestamps go back, as when
+	// NTP steps a member's clock
+	WarningTimeJump = "time_jump"
+	// WarningUnknownMapping is a metric mapping that matched no stat in
+	// the run
+	WarningUnknownMapping = "unknown_mapping"
+	// WarningOverlap is a file that starts before the last sample of the
+	// file converted before it
+	WarningOverlap = "overlap"
+)
+
+// Event is a single line of the event stream. Which of the optional
+// sections is set depends on Type.
+type Event struct {
+	Schema int       `json:"schema"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	File   string    `json:"file,omitempty"`
+
+	Progress *ProgressInfo `json:"progress,omitempty"`
+	Summary  *FileSummary  `json:"summary,omitempty"`
+	Warning  *WarningInfo  `json:"warning,omitempty"`
+	Run      *RunSummary   `json:"run,omitempty"`
+}
+
+// ProgressInfo reports how far the conversion of a file has got
+type ProgressInfo struct {
+	InstancesDone  int `json:"instances_done"`
+	InstancesTotal int `json:"instances_total"`
+	SamplesWritten int `json:"samples_written"`
+}
+
+// FileSummary is the outcome of converting one file
+type FileSummary struct {
+	SamplesWritten  int     `json:"samples_written"`
+	ResourceTypes   int     `json:"resource_types"`
+	Instances       int     `json:"instances"`
+	SamplingGaps    int     `json:"sampling_gaps"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	// SamplingDisabled counts the intervals during which statistic
+	// sampling was disabled on the member
+	SamplingDisabled int `json:"sampling_disabled,omitempty"`
+	// CorrectionsApplied counts the samples changed by each configured
+	// value correction
+	CorrectionsApplied map[string]int `json:"corrections_applied,omitempty"`
+	// ParseWarnings and SkippedRecords count the recoverable problems and
+	// the corrupt records skipped while reading the archive; Truncated is
+	// set if it ended inside a record
+	ParseWarnings  int  `json:"parse_warnings,omitempty"`
+	SkippedRecords int  `json:"skipped_records,omitempty"`
+	Truncated      bool `json:"truncated,omitempty"`
+	// LimitsExceeded counts the skipped records whose lengths or counts
+	// were above the reader's limits
+	LimitsExceeded int `json:"limits_exceeded,omitempty"`
+	// PaddingBytes counts the zero bytes ending the file that were ignored
+	// as padding
+	PaddingBytes int64 `json:"padding_bytes,omitempty"`
+	// SkippedDuplicates counts the samples skipped as duplicates of
+	// samples already written, as when the file was converted before
+	SkippedDuplicates int `json:"skipped_duplicates,omitempty"`
+	// SamplesOutsideWindow counts the samples skipped as outside the time
+	// window of the run
+	SamplesOutsideWindow int `json:"samples_outside_window,omitempty"`
+	// SkippedSeriesLimit counts the samples skipped as in series over the
+	// series limit of the run
+	SkippedSeriesLimit int `json:"skipped_series_limit,omitempty"`
+	// FutureSamples counts the samples dated after the wall clock plus
+	// the future limit, dropped, clamped or kept as the policy says
+	FutureSamples int `json:"future_samples,omitempty"`
+	// QuarantinedSamples counts the samples the TSDB rejected that were
+	// written to the quarantine file instead
+	QuarantinedSamples int `json:"quarantined_samples,omitempty"`
+	// SkippedFiltered counts the samples of stats excluded by the config
+	// filters or dropped by a metric mapping, and SkippedInvalid those of
+	// corrupt resource types or instances and those the TSDB rejected
+	SkippedFiltered int `json:"skipped_filtered,omitempty"`
+	SkippedInvalid  int `json:"skipped_invalid,omitempty"`
+	// SeriesWritten counts the series samples were written to, and
+	// FirstSample and LastSample are the times of the earliest and latest
+	// sample written
+	SeriesWritten int        `json:"series_written,omitempty"`
+	FirstSample   *time.Time `json:"first_sample,omitempty"`
+	LastSample    *time.Time `json:"last_sample,omitempty"`
+	// WindowStart and WindowEnd are the time window the samples were
+	// limited to, resolved for the file, if that side of it was set
+	WindowStart *time.Time `json:"window_start,omitempty"`
+	WindowEnd   *time.Time `json:"window_end,omitempty"`
+	// MetricPrefix is the prefix the file's metrics were written with and
+	// PrefixRule the prefix rule that chose it, empty for metric_prefix
+	MetricPrefix string `json:"metric_prefix,omitempty"`
+	PrefixRule   string `json:"prefix_rule,omitempty"`
+	// Error is set if the file could not be converted
+	Error string `json:"error,omitempty"`
+}
+
+// WarningInfo is a classified warning; Class is one of the Warning*
+// constants
+type WarningInfo struct {
+	Class   string `json:"class"`
+	Message string `json:"message"`
+}
+
+// RunSummary totals a batch run
+type RunSummary struct {
+	Files           int     `json:"files"`
+	FailedFiles     int     `json:"failed_files"`
+	SamplesWritten  int     `json:"samples_written"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	// SkippedDuplicates counts the samples of all files skipped as
+	// duplicates, and SamplesOutsideWindow, SkippedSeriesLimit,
+	// SkippedFiltered and SkippedInvalid the others skipped as in
+	// FileSummary. DroppedSeries counts the series skipped as over the
+	// series limit, FutureSamples the samples of all files dated after
+	// the future limit and QuarantinedSamples those quarantined.
+	SkippedDuplicates    int `json:"skipped_duplicates,omitempty"`
+	SamplesOutsideWindow int `json:"samples_outside_window,omitempty"`
+	SkippedSeriesLimit   int `json:"skipped_series_limit,omitempty"`
+	DroppedSeries        int `json:"dropped_series,omitempty"`
+	FutureSamples        int `json:"future_samples,omitempty"`
+	QuarantinedSamples   int `json:"quarantined_samples,omitempty"`
+	SkippedFiltered      int `json:"skipped_filtered,omitempty"`
+	SkippedInvalid       int `json:"skipped_invalid,omitempty"`
+}
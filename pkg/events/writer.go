@@ -0,0 +1,80 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Writer writes events as JSON lines. Each event is written with a single
+// unbuffered write so readers see it as soon as it is emitted. A nil
+// Writer discards events.
+type Writer struct {
+	mu     sync.Mutex
+	out    io.Writer
+	closer io.Closer
+}
+
+// NewWriter returns a Writer that writes to out
+func NewWriter(out io.Writer) *Writer {
+	return &Writer{out: out}
+}
+
+// Open opens an event stream target: "-" for stdout, "fd:N" for an
+// inherited file descriptor, or a file path, which is appended to
+func Open(target string) (*Writer, error) {
+	switch {
+	case target == "-":
+		return NewWriter(os.Stdout), nil
+	case strings.HasPrefix(target, "fd:"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(target, "fd:"))
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid file descriptor in %q", target)
+		}
+		f := os.NewFile(uintptr(fd), target)
+		if f == nil {
+			return nil, fmt.Errorf("invalid file descriptor in %q", target)
+		}
+		return &Writer{out: f, closer: f}, nil
+	default:
+		f, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open events output: %w", err)
+		}
+		return &Writer{out: f, closer: f}, nil
+	}
+}
+
+// Emit fills in the schema version and time, if unset, and writes event
+func (w *Writer) Emit(event Event) {
+	if w == nil {
+		return
+	}
+
+	event.Schema = SchemaVersion
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.out.Write(append(data, '\n'))
+}
+
+// Close closes the underlying file, if Open created one
+func (w *Writer) Close() error {
+	if w == nil || w.closer == nil {
+		return nil
+	}
+	return w.closer.Close()
+}